@@ -15,16 +15,23 @@
 package zoekt
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
+	"github.com/grafana/regexp"
+
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -68,6 +75,11 @@ type FileMatch struct {
 	LineMatches  []LineMatch  `json:",omitempty"`
 	ChunkMatches []ChunkMatch `json:",omitempty"`
 
+	// MatchCount is the number of matches in the file. It is only set if
+	// SearchOptions.FilesOnly is set, in which case the file has no
+	// LineMatches or ChunkMatches.
+	MatchCount int `json:",omitempty"`
+
 	// Only set if requested
 	Content []byte `json:",omitempty"`
 
@@ -81,9 +93,86 @@ type FileMatch struct {
 	// order results from different repositories relative to each other.
 	RepositoryPriority float64 `json:",omitempty"`
 
+	// IndexTimeUnix is the time the repository of the file was indexed, in
+	// seconds since the epoch, see Repository.IndexTime. Together with
+	// Checksum and Version it lets clients tell whether a match comes from
+	// content older than the revision they know about.
+	IndexTimeUnix int64 `json:",omitempty"`
+
 	// RepositoryID is a Sourcegraph extension. This is the ID of Repository in
 	// Sourcegraph.
 	RepositoryID uint32 `json:",omitempty"`
+
+	// Labels are the key/value labels of the file, eg. its service or
+	// owning team, see index.Document.Labels.
+	Labels map[string]string `json:",omitempty"`
+
+	// FieldClass is the class of the field of the best match of the file,
+	// one of FieldClassSymbol, FieldClassFileName and FieldClassContent. It
+	// is only set if SearchOptions.ScoreFieldClasses is set.
+	FieldClass string `json:",omitempty"`
+
+	// Provenance identifies the shard and content the file was matched in.
+	// It is only set if SearchOptions.Provenance is set.
+	Provenance *Provenance `json:",omitempty"`
+}
+
+// Provenance identifies the index and the content a file match was found
+// in, so audits can show exactly which index produced a result.
+type Provenance struct {
+	// ShardFile is the base name of the shard file.
+	ShardFile string
+
+	// ShardID is the ID of the shard build, see IndexMetadata.ID.
+	ShardID string `json:",omitempty"`
+
+	// IndexTime is when the repository of the file was indexed, see
+	// Repository.IndexTime. It is older than the shard if the shard is
+	// a compound shard.
+	IndexTime time.Time
+
+	// ZoektVersion is the version of zoekt which built the shard.
+	ZoektVersion string `json:",omitempty"`
+
+	// IndexFormatVersion and IndexFeatureVersion are the versions of the
+	// format of the shard.
+	IndexFormatVersion  int
+	IndexFeatureVersion int
+
+	// Checksum is the checksum of the content stored in the shard, as in
+	// FileMatch.Checksum, hex encoded. It is a CRC-64, so it detects
+	// corruption but not tampering.
+	Checksum string
+
+	// ContentSHA256 is the SHA-256 digest of the content of the file in the
+	// shard, hex encoded. It is computed when the file matches.
+	ContentSHA256 string
+}
+
+func (p *Provenance) sizeBytes() uint64 {
+	if p == nil {
+		return 0
+	}
+	sz := uint64(24) // IndexTime
+	sz += 16         // IndexFormatVersion, IndexFeatureVersion
+	for _, s := range []string{p.ShardFile, p.ShardID, p.ZoektVersion, p.Checksum, p.ContentSHA256} {
+		sz += stringHeaderBytes + uint64(len(s))
+	}
+	return sz
+}
+
+// The classes of the fields a query can match, see
+// SearchOptions.ScoreFieldClasses.
+const (
+	FieldClassSymbol   = "symbol"   // symbol definitions, eg. sym:Foo
+	FieldClassFileName = "filename" // file names, eg. file:Foo
+	FieldClassContent  = "content"  // the contents of files
+)
+
+// SizeBytes is a best-effort estimate of the size of the FileMatch in
+// memory, see SearchResult.SizeBytes.
+func (m *FileMatch) SizeBytes() uint64 {
+	return m.sizeBytes()
 }
 
 func (m *FileMatch) sizeBytes() (sz uint64) {
@@ -98,6 +187,7 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 		m.SubRepositoryName,
 		m.SubRepositoryPath,
 		m.Version,
+		m.FieldClass,
 	} {
 		sz += stringHeaderBytes + uint64(len(s))
 	}
@@ -126,12 +216,27 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 	// RepositoryPriority
 	sz += 8
 
+	// IndexTimeUnix
+	sz += 8
+
+	// MatchCount
+	sz += 8
+
 	// Content
 	sz += sliceHeaderBytes + uint64(len(m.Content))
 
 	// Checksum
 	sz += sliceHeaderBytes + uint64(len(m.Checksum))
 
+	// Labels
+	sz += mapHeaderBytes
+	for k, v := range m.Labels {
+		sz += stringHeaderBytes + uint64(len(k)) + stringHeaderBytes + uint64(len(v))
+	}
+
+	// Provenance
+	sz += pointerSize + m.Provenance.sizeBytes()
+
 	return
 }
 
@@ -158,6 +263,8 @@ type ChunkMatch struct {
 
 	// Content is a contiguous range of complete lines that fully contains Ranges.
 	// Lines will always include their terminating newline (if it exists).
+	// Matches on lines longer than 4KB are returned in separate chunks, whose
+	// Content is only the part of the line around their Ranges.
 	Content []byte
 
 	// Ranges is a set of matching ranges within this chunk. Each range is relative
@@ -238,15 +345,20 @@ type Location struct {
 	LineNumber uint32
 	// 1-based column number (in runes) from the beginning of line
 	Column uint32
+	// 1-based column at which the rune is displayed, counting tabs up to the
+	// next tab stop of SearchOptions.TabWidth
+	DisplayColumn uint32
 }
 
 func (l *Location) sizeBytes() uint64 {
-	return 3 * 4
+	return 4 * 4
 }
 
 // LineMatch holds the matches within a single line in a file.
 type LineMatch struct {
-	// The line in which a match was found.
+	// The line in which a match was found. For lines longer than 4KB, it is
+	// only the part of the line around the LineFragments, and the matches on
+	// the line are returned in several LineMatches.
 	Line []byte
 	// The byte offset of the first byte of the line.
 	LineStart int
@@ -307,15 +419,19 @@ type Symbol struct {
 	Kind       string
 	Parent     string
 	ParentKind string
+
+	// Doc is the documentation comment of the symbol, if the shard was
+	// indexed with symbol documentation for its language.
+	Doc string
 }
 
 func (s *Symbol) sizeBytes() uint64 {
-	return 4*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind))
+	return 5*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind)+len(s.Doc))
 }
 
 // LineFragmentMatch a segment of matching text within a line.
 type LineFragmentMatch struct {
-	// Offset within the line, in bytes.
+	// Offset within LineMatch.Line, in bytes.
 	LineOffset int
 
 	// Offset from file start, in bytes.
@@ -325,6 +441,14 @@ type LineFragmentMatch struct {
 	MatchLength int
 
 	SymbolInfo *Symbol
+
+	// 1-based column numbers (in runes) of the first rune of the match and
+	// the rune after it, from the beginning of the line.
+	Column, EndColumn uint32
+
+	// 1-based display columns of the first rune of the match and the rune
+	// after it, see Location.DisplayColumn.
+	DisplayColumn, EndDisplayColumn uint32
 }
 
 func (lfm *LineFragmentMatch) sizeBytes() (sz uint64) {
@@ -337,6 +461,9 @@ func (lfm *LineFragmentMatch) sizeBytes() (sz uint64) {
 	// MatchLength
 	sz += 8
 
+	// Column, EndColumn, DisplayColumn, EndDisplayColumn
+	sz += 4 * 4
+
 	// SymbolInfo
 	sz += pointerSize
 	if lfm.SymbolInfo != nil {
@@ -409,6 +536,11 @@ type Stats struct {
 	// ngram filter indicating it had no matches.
 	ShardsSkippedFilter int
 
+	// Shards whose search was interrupted because the query was canceled,
+	// eg. by SearchOptions.MaxWallTime, so some of their candidate files
+	// were not evaluated.
+	ShardsInterrupted int
+
 	// Number of non-overlapping matches
 	MatchCount int
 
@@ -429,17 +561,58 @@ type Stats struct {
 	// for the bulk of search work done looking for matches.
 	MatchTreeSearch time.Duration
 
+	// Aggregate wall clock time spent evaluating the queries with a time
+	// budget, see query.Budget. It is part of MatchTreeSearch, which is spent
+	// on the cheaper parts of the query otherwise.
+	MatchTreeBudgetSearch time.Duration
+
+	// Number of shards in which a query ran out of its time budget, so it
+	// matched no more documents of the shard.
+	BudgetsExhausted int
+
 	// Number of times regexp was called on files that we evaluated.
 	RegexpsConsidered int
 
 	// FlushReason explains why results were flushed.
 	FlushReason FlushReason
+
+	// RepoStats are the statistics of the repositories of the matches, by
+	// repository name. Only RepoStats.SuppressedMatches is set, for the
+	// repositories which had matches dropped.
+	RepoStats map[string]RepoStats
+
+	// Atoms are the statistics of each atom of the query, sorted by
+	// AtomStats.Atom. They are only collected if SearchOptions.DetailedStats
+	// is set.
+	Atoms []AtomStats
+
+	// Experiments are the experiment flags the search ran with, sorted. They
+	// are enabled per request, see package internal/experiments.
+	Experiments []string
 }
 
 func (s *Stats) sizeBytes() (sz uint64) {
 	sz = 16 * 8 // This assumes we are running on a 64-bit architecture
 	sz += 1     // FlushReason
 
+	// RepoStats
+	sz += mapHeaderBytes
+	for k := range s.RepoStats {
+		sz += stringHeaderBytes + uint64(len(k)) + 10*8 // RepoStats
+	}
+
+	// Experiments
+	sz += sliceHeaderBytes
+	for _, e := range s.Experiments {
+		sz += stringHeaderBytes + uint64(len(e))
+	}
+
+	// Atoms
+	sz += sliceHeaderBytes
+	for i := range s.Atoms {
+		sz += s.Atoms[i].sizeBytes()
+	}
+
 	return
 }
 
@@ -458,9 +631,12 @@ func (s *Stats) Add(o Stats) {
 	s.ShardsScanned += o.ShardsScanned
 	s.ShardsSkipped += o.ShardsSkipped
 	s.ShardsSkippedFilter += o.ShardsSkippedFilter
+	s.ShardsInterrupted += o.ShardsInterrupted
 	s.Wait += o.Wait
 	s.MatchTreeConstruction += o.MatchTreeConstruction
 	s.MatchTreeSearch += o.MatchTreeSearch
+	s.MatchTreeBudgetSearch += o.MatchTreeBudgetSearch
+	s.BudgetsExhausted += o.BudgetsExhausted
 	s.RegexpsConsidered += o.RegexpsConsidered
 
 	// We want the first non-zero FlushReason to be sticky. This is a useful
@@ -468,6 +644,51 @@ func (s *Stats) Add(o Stats) {
 	if s.FlushReason == 0 {
 		s.FlushReason = o.FlushReason
 	}
+
+	if len(o.RepoStats) > 0 {
+		if s.RepoStats == nil {
+			s.RepoStats = make(map[string]RepoStats, len(o.RepoStats))
+		}
+		for repo, rs := range o.RepoStats {
+			sum := s.RepoStats[repo]
+			sum.Add(&rs)
+			s.RepoStats[repo] = sum
+		}
+	}
+
+	for i := range o.Atoms {
+		s.AddAtom(o.Atoms[i])
+	}
+
+	for _, e := range o.Experiments {
+		if i, found := slices.BinarySearch(s.Experiments, e); !found {
+			s.Experiments = slices.Insert(s.Experiments, i, e)
+		}
+	}
+}
+
+// AddAtom adds the statistics of an atom to s.Atoms, merging them with the
+// statistics of the same atom in other shards.
+func (s *Stats) AddAtom(a AtomStats) {
+	i, found := slices.BinarySearchFunc(s.Atoms, a.Atom, func(a AtomStats, atom string) int {
+		return strings.Compare(a.Atom, atom)
+	})
+	if !found {
+		s.Atoms = slices.Insert(s.Atoms, i, AtomStats{Atom: a.Atom})
+	}
+	s.Atoms[i].add(a)
+}
+
+// Completeness estimates the fraction of the shards which were searched in
+// full, from 0 to 1. It is below 1 if the search was canceled, eg. by
+// SearchOptions.MaxWallTime, before all shards were searched, and the results
+// are the best found until then.
+func (s *Stats) Completeness() float64 {
+	total := s.ShardsScanned + s.ShardsSkipped + s.ShardsSkippedFilter
+	if total == 0 {
+		return 1
+	}
+	return float64(total-s.ShardsSkipped-s.ShardsInterrupted) / float64(total)
 }
 
 // Zero returns true if stats is empty.
@@ -490,10 +711,86 @@ func (s *Stats) Zero() bool {
 		s.ShardsScanned > 0 ||
 		s.ShardsSkipped > 0 ||
 		s.ShardsSkippedFilter > 0 ||
+		s.ShardsInterrupted > 0 ||
 		s.Wait > 0 ||
 		s.MatchTreeConstruction > 0 ||
 		s.MatchTreeSearch > 0 ||
-		s.RegexpsConsidered > 0)
+		s.MatchTreeBudgetSearch > 0 ||
+		s.BudgetsExhausted > 0 ||
+		s.RegexpsConsidered > 0 ||
+		len(s.RepoStats) > 0 ||
+		len(s.Atoms) > 0 ||
+		len(s.Experiments) > 0)
+}
+
+// AtomStats are detailed statistics of an atom of a query, such as a
+// substring or a regular expression. They describe how the atom was looked up
+// in the ngram index, and how many of its candidates were rejected at each
+// stage of the evaluation.
+type AtomStats struct {
+	// Atom describes the atom, eg. substr:"foo" or re((?i)foo.*bar).
+	Atom string
+
+	// Ngrams maps the ngrams chosen to find candidates for the atom to the
+	// size of their posting lists in bytes. Case variants of an ngram are
+	// separate entries.
+	Ngrams map[string]int64
+
+	// NgramLookups is the number of times we accessed an ngram in the index.
+	NgramLookups int
+
+	// IndexBytesLoaded is the number of bytes of posting lists we
+	// decompressed.
+	IndexBytesLoaded int64
+
+	// CandidatesOutOfBounds is the number of hits of the ngrams which were
+	// rejected because the atom would extend beyond the start or the end of
+	// the document.
+	CandidatesOutOfBounds int
+
+	// NgramMatches is the number of candidate matches found with the ngrams.
+	NgramMatches int
+
+	// CandidatesRejected is the number of candidate matches which were
+	// rejected after comparing them to the content of the document.
+	CandidatesRejected int
+
+	// FilesEvaluated is the number of documents whose content was searched
+	// for the atom.
+	FilesEvaluated int
+
+	// FilesMatched is the number of evaluated documents in which the atom
+	// matched.
+	FilesMatched int
+}
+
+func (a *AtomStats) add(o AtomStats) {
+	if len(o.Ngrams) > 0 && a.Ngrams == nil {
+		a.Ngrams = make(map[string]int64, len(o.Ngrams))
+	}
+	for ng, sz := range o.Ngrams {
+		a.Ngrams[ng] += sz
+	}
+	a.NgramLookups += o.NgramLookups
+	a.IndexBytesLoaded += o.IndexBytesLoaded
+	a.CandidatesOutOfBounds += o.CandidatesOutOfBounds
+	a.NgramMatches += o.NgramMatches
+	a.CandidatesRejected += o.CandidatesRejected
+	a.FilesEvaluated += o.FilesEvaluated
+	a.FilesMatched += o.FilesMatched
+}
+
+func (a *AtomStats) sizeBytes() (sz uint64) {
+	sz = stringHeaderBytes + uint64(len(a.Atom))
+	sz += 7 * 8
+
+	// Ngrams
+	sz += mapHeaderBytes
+	for ng := range a.Ngrams {
+		sz += stringHeaderBytes + uint64(len(ng)) + 8
+	}
+
+	return
 }
 
 // Progress contains information about the global progress of the running search query.
@@ -509,10 +806,27 @@ type Progress struct {
 	//
 	// MaxPendingPriority decreases monotonically in each SearchResult.
 	MaxPendingPriority float64
+
+	// ShardsCompleted is the number of shards searched so far, out of
+	// ShardsTotal. It is only set on progress events, see
+	// SearchOptions.ProgressInterval.
+	ShardsCompleted int
+	ShardsTotal     int
+
+	// FilesConsidered is the number of documents considered by the shards
+	// searched so far. It is only set on progress events.
+	FilesConsidered int
 }
 
 func (p *Progress) sizeBytes() uint64 {
-	return 2 * 8
+	return 5 * 8
+}
+
+// IsProgressEvent reports whether sr is a progress event sent because of
+// SearchOptions.ProgressInterval. Progress events carry no matches and no
+// stats, so they can be ignored by consumers which only aggregate results.
+func (sr *SearchResult) IsProgressEvent() bool {
+	return sr.ShardsTotal > 0 && len(sr.Files) == 0 && sr.Stats.Zero()
 }
 
 // SearchResult contains search matches and extra data
@@ -530,6 +844,91 @@ type SearchResult struct {
 	// FragmentNames holds a repo => template string map, for
 	// the line number fragment.
 	LineFragments map[string]string
+
+	// ResultSet holds the documents which matched, if
+	// SearchOptions.ReturnResultSet is set. When streaming, every shard sends
+	// the documents it found, and the result sets of the events have to be
+	// added up.
+	ResultSet *ResultSet `json:",omitempty"`
+
+	// Final is set on the last event of a stream with
+	// SearchOptions.FileNamesFirst. It has all the files which matched,
+	// ranked, and replaces the files of the events before it. Its Stats are
+	// those of the whole search, and the events before it have none.
+	Final bool `json:",omitempty"`
+}
+
+// ResultSet is a set of documents which matched a search, by their IDs in
+// each shard. A search restricted to it with SearchOptions.Within only
+// evaluates its query on these documents, so narrowing down a result costs a
+// fraction of searching again.
+//
+// It has the documents found before the shards stopped at their match
+// limits, including documents which were left out of the result by display
+// limits. Shards are identified by their ID and index time, so documents of
+// shards which were rebuilt since are not in the set anymore.
+type ResultSet struct {
+	// Shards maps a shard to its documents in the set.
+	Shards map[string]*roaring.Bitmap
+}
+
+// Add adds the documents of o to r.
+func (r *ResultSet) Add(o *ResultSet) {
+	if o == nil {
+		return
+	}
+	if r.Shards == nil {
+		r.Shards = make(map[string]*roaring.Bitmap, len(o.Shards))
+	}
+	for shard, docs := range o.Shards {
+		if have, ok := r.Shards[shard]; ok {
+			have.Or(docs)
+		} else {
+			r.Shards[shard] = docs.Clone()
+		}
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding is opaque
+// to clients, which pass it back in SearchOptions.Within.
+func (r *ResultSet) MarshalBinary() ([]byte, error) {
+	return resultSetEncode(r)
+}
+
+func (r *ResultSet) UnmarshalBinary(b []byte) error {
+	shards, err := resultSetDecode(b)
+	if err != nil {
+		return err
+	}
+	r.Shards = shards
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so a ResultSet is a base64
+// string in JSON.
+func (r *ResultSet) MarshalText() ([]byte, error) {
+	b, err := r.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.AppendEncode(nil, b), nil
+}
+
+func (r *ResultSet) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.AppendDecode(nil, text)
+	if err != nil {
+		return err
+	}
+	return r.UnmarshalBinary(b)
+}
+
+func (r *ResultSet) sizeBytes() (sz uint64) {
+	sz += mapHeaderBytes
+	for shard, docs := range r.Shards {
+		sz += stringHeaderBytes + uint64(len(shard))
+		sz += pointerSize + docs.GetSizeInBytes()
+	}
+	return
 }
 
 // SizeBytes is a best-effort estimate of the size of SearchResult in memory.
@@ -559,6 +958,12 @@ func (sr *SearchResult) SizeBytes() (sz uint64) {
 		sz += stringHeaderBytes + uint64(len(v))
 	}
 
+	// ResultSet
+	sz += pointerSize
+	if sr.ResultSet != nil {
+		sz += sr.ResultSet.sizeBytes()
+	}
+
 	return
 }
 
@@ -642,6 +1047,11 @@ type Repository struct {
 	// FileTombstones is a set of file paths that should be ignored across all branches
 	// in this shard.
 	FileTombstones map[string]struct{} `json:",omitempty"`
+
+	// IndexTime is when the documents of the repository were indexed. Unlike
+	// IndexMetadata.IndexTime, it stays the same when the shard is merged
+	// into a compound shard.
+	IndexTime time.Time
 }
 
 func (r *Repository) UnmarshalJSON(data []byte) error {
@@ -684,16 +1094,23 @@ func (r *Repository) UnmarshalJSON(data []byte) error {
 		// based on priority. Setting it on read instead of during indexing
 		// allows us to avoid a complete reindex.
 		if r.Rank == 0 && r.priority > 0 {
-			// Normalize the repo score within [0, maxUint16), with the midpoint at 5,000.
-			// This means popular repos (roughly ones with over 5,000 stars) see diminishing
-			// returns from more stars.
-			r.Rank = uint16(r.priority / (5000.0 + r.priority) * math.MaxUint16)
+			r.Rank = rankFromPriority(r.priority)
 		}
 	}
 
 	return nil
 }
 
+// rankFromPriority normalizes the repo score within [0, maxUint16), with the
+// midpoint at 5,000. This means popular repos (roughly ones with over 5,000
+// stars) see diminishing returns from more stars.
+func rankFromPriority(priority float64) uint16 {
+	if priority <= 0 {
+		return 0
+	}
+	return uint16(priority / (5000.0 + priority) * math.MaxUint16)
+}
+
 func (r *Repository) GetPriority() float64 {
 	return r.priority
 }
@@ -768,6 +1185,121 @@ func (r *Repository) MergeMutable(x *Repository) (mutated bool, err error) {
 	return mutated, nil
 }
 
+// ErrRepositoryNotFound is returned by metadata updates of repositories which
+// aren't indexed.
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+// ErrInvalidMetadataUpdate is returned for metadata updates which can't be
+// applied to a repository.
+var ErrInvalidMetadataUpdate = errors.New("invalid metadata update")
+
+// RepositoryMetadataUpdate changes the metadata of a repository which can be
+// corrected without re-indexing it. Fields which are nil are left unchanged.
+// The values are stored in RawConfig.
+type RepositoryMetadataUpdate struct {
+	// Priority replaces "priority", and the Rank derived from it unless the
+	// repository is ranked by its latest commit date.
+	Priority *float64 `json:",omitempty"`
+
+	// Archived replaces "archived", which archived:yes queries match.
+	Archived *bool `json:",omitempty"`
+
+	// Topics replaces the comma separated "topics". An empty, non-nil list
+	// removes them.
+	Topics []string `json:",omitempty"`
+
+	// BranchOrder replaces the comma separated "branchOrder", the order in
+	// which the branches of a file are listed in results. Branches missing
+	// from it follow in index order. An empty, non-nil list restores the
+	// index order.
+	BranchOrder []string `json:",omitempty"`
+}
+
+// Apply applies u to r. mutated is true if it changed r. err wraps
+// ErrInvalidMetadataUpdate if u can't be applied, in which case r is
+// unchanged.
+func (u *RepositoryMetadataUpdate) Apply(r *Repository) (mutated bool, err error) {
+	if u.Priority != nil && (math.IsNaN(*u.Priority) || math.IsInf(*u.Priority, 0)) {
+		return false, fmt.Errorf("%w: priority %v is not a number", ErrInvalidMetadataUpdate, *u.Priority)
+	}
+	for _, t := range u.Topics {
+		if t == "" || strings.Contains(t, ",") {
+			return false, fmt.Errorf("%w: topic %q is empty or contains a comma", ErrInvalidMetadataUpdate, t)
+		}
+	}
+	for _, name := range u.BranchOrder {
+		if !slices.ContainsFunc(r.Branches, func(b RepositoryBranch) bool { return b.Name == name }) {
+			return false, fmt.Errorf("%w: %s has no branch %q", ErrInvalidMetadataUpdate, r.Name, name)
+		}
+		if strings.Contains(name, ",") {
+			return false, fmt.Errorf("%w: branch %q contains a comma", ErrInvalidMetadataUpdate, name)
+		}
+	}
+
+	set := func(k, v string) {
+		if old, ok := r.RawConfig[k]; ok && old == v {
+			return
+		}
+		if r.RawConfig == nil {
+			r.RawConfig = make(map[string]string)
+		}
+		r.RawConfig[k] = v
+		mutated = true
+	}
+	setList := func(k string, vs []string) {
+		if len(vs) > 0 {
+			set(k, strings.Join(vs, ","))
+		} else if _, ok := r.RawConfig[k]; ok {
+			delete(r.RawConfig, k)
+			mutated = true
+		}
+	}
+
+	if u.Priority != nil {
+		set("priority", strconv.FormatFloat(*u.Priority, 'g', -1, 64))
+		r.priority = *u.Priority
+		if _, ok := r.RawConfig["latestCommitDate"]; !ok && r.Rank != rankFromPriority(r.priority) {
+			r.Rank = rankFromPriority(r.priority)
+			mutated = true
+		}
+	}
+	if u.Archived != nil {
+		if *u.Archived {
+			set("archived", "1")
+		} else {
+			set("archived", "0")
+		}
+	}
+	if u.Topics != nil {
+		setList("topics", u.Topics)
+	}
+	if u.BranchOrder != nil {
+		setList("branchOrder", u.BranchOrder)
+	}
+
+	return mutated, nil
+}
+
+// RepositoryMetadataUpdater is implemented by searchers which can update the
+// metadata of the repositories they search.
+type RepositoryMetadataUpdater interface {
+	// UpdateRepositoryMetadata applies u to the repository name, without
+	// re-indexing it, and returns the updated repository. It returns
+	// ErrRepositoryNotFound if the repository isn't indexed.
+	UpdateRepositoryMetadata(ctx context.Context, name string, u *RepositoryMetadataUpdate) (*Repository, error)
+}
+
+// UpdateRepositoryMetadata updates the metadata of the repository name with
+// s. It fails with errors.ErrUnsupported if s doesn't implement
+// RepositoryMetadataUpdater.
+func UpdateRepositoryMetadata(ctx context.Context, s Searcher, name string, u *RepositoryMetadataUpdate) (*Repository, error) {
+	mu, ok := s.(RepositoryMetadataUpdater)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support metadata updates: %w", s, errors.ErrUnsupported)
+	}
+	return mu.UpdateRepositoryMetadata(ctx, name, u)
+}
+
 // IndexMetadata holds metadata stored in the index file. It contains
 // data generated by the core indexing library.
 type IndexMetadata struct {
@@ -779,6 +1311,21 @@ type IndexMetadata struct {
 	LanguageMap           map[string]uint16
 	ZoektVersion          string
 	ID                    string
+
+	// Documents and ContentBytes are the number of documents in the shard
+	// and the sum of their sizes before encryption. Searches combine them
+	// over all shards so BM25 scores documents of different shards alike.
+	// They are zero in shards written before they were added.
+	Documents    int   `json:",omitempty"`
+	ContentBytes int64 `json:",omitempty"`
+
+	// DuplicateDocuments is the number of documents whose contents are
+	// stored once for several documents of the shard, and
+	// DuplicateContentBytes the size of the contents this saves. They are
+	// only set for shards with deduplicated contents, eg. compound shards of
+	// forks.
+	DuplicateDocuments    int   `json:",omitempty"`
+	DuplicateContentBytes int64 `json:",omitempty"`
 }
 
 // Statistics of a (collection of) repositories.
@@ -823,6 +1370,11 @@ type RepoStats struct {
 	// OtherBranchesNewLinesCount is the number of newlines "\n" in all branches
 	// except the default branch.
 	OtherBranchesNewLinesCount uint64
+
+	// SuppressedMatches is the number of matches of the repository which a
+	// search dropped because of SearchOptions.MaxMatchesPerRepo. It is only
+	// set in Stats.RepoStats.
+	SuppressedMatches int
 }
 
 func (s *RepoStats) Add(o *RepoStats) {
@@ -837,6 +1389,8 @@ func (s *RepoStats) Add(o *RepoStats) {
 	s.NewLinesCount += o.NewLinesCount
 	s.DefaultBranchNewLinesCount += o.DefaultBranchNewLinesCount
 	s.OtherBranchesNewLinesCount += o.OtherBranchesNewLinesCount
+
+	s.SuppressedMatches += o.SuppressedMatches
 }
 
 type RepoListEntry struct {
@@ -904,6 +1458,343 @@ type RepoList struct {
 	Stats RepoStats
 }
 
+// DefinitionOptions restricts a lookup of definitions.
+type DefinitionOptions struct {
+	// Language restricts the definitions to files of this language, eg. "Go".
+	Language string
+
+	// RepoFilter restricts the definitions to repositories whose name matches
+	// this regular expression.
+	RepoFilter string
+
+	// Branch restricts the definitions to files on this branch, eg. "main".
+	// Like the matches of a search with a branch: filter, the definitions are
+	// then only reported on this branch.
+	Branch string
+
+	// MaxResults is the maximum number of definitions returned. If zero, all
+	// definitions are returned.
+	MaxResults int
+}
+
+// Definition is the location of a symbol definition.
+type Definition struct {
+	Repository         string
+	RepositoryPriority float64
+	FileName           string
+	Branches           []string
+	Language           string
+
+	// Symbol is the defined symbol, eg. its kind and parent.
+	Symbol Symbol
+
+	// Start is the location of the symbol name in the file.
+	Start Location
+
+	// Ranking; the higher, the better.
+	Score float64
+}
+
+// SortDefinitions sorts definitions by score, then by repository priority,
+// repository, file name and offset.
+func SortDefinitions(defs []Definition) {
+	slices.SortStableFunc(defs, func(a, b Definition) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(b.RepositoryPriority, a.RepositoryPriority),
+			strings.Compare(a.Repository, b.Repository),
+			strings.Compare(a.FileName, b.FileName),
+			cmp.Compare(a.Start.ByteOffset, b.Start.ByteOffset),
+		)
+	})
+}
+
+// ListStreamer is implemented by searchers which can send the repositories of
+// List as they are found, rather than collecting the whole list first.
+type ListStreamer interface {
+	// StreamList calls send with batches of the repositories matching q, which
+	// have the fields of List. Each repository is in one batch. The returned
+	// RepoList has the crashes and stats of the whole list, and no
+	// repositories.
+	StreamList(ctx context.Context, q query.Q, opts *ListOptions, send func(*RepoList) error) (*RepoList, error)
+}
+
+// StreamList streams the repositories matching q with s. If s doesn't
+// implement ListStreamer, the repositories of List are sent in one batch.
+func StreamList(ctx context.Context, s Searcher, q query.Q, opts *ListOptions, send func(*RepoList) error) (*RepoList, error) {
+	if ls, ok := s.(ListStreamer); ok {
+		return ls.StreamList(ctx, q, opts, send)
+	}
+	rl, err := s.List(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(rl.Repos) > 0 || len(rl.ReposMap) > 0 {
+		if err := send(&RepoList{Repos: rl.Repos, ReposMap: rl.ReposMap}); err != nil {
+			return nil, err
+		}
+	}
+	return &RepoList{Crashes: rl.Crashes, Stats: rl.Stats}, nil
+}
+
+// DefinitionSearcher is implemented by searchers which can look up the
+// definitions of a symbol by its exact name, eg. for "go to definition"
+// without precise code intelligence.
+type DefinitionSearcher interface {
+	Definitions(ctx context.Context, name string, opts *DefinitionOptions) ([]Definition, error)
+}
+
+// Definitions looks up the definitions of name with s. It fails if s doesn't
+// implement DefinitionSearcher.
+func Definitions(ctx context.Context, s Searcher, name string, opts *DefinitionOptions) ([]Definition, error) {
+	ds, ok := s.(DefinitionSearcher)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support definition lookups", s)
+	}
+	return ds.Definitions(ctx, name, opts)
+}
+
+// DocumentResult is a document with the matches of a query in it.
+type DocumentResult struct {
+	Repository string
+	FileName   string
+	Branches   []string
+	Language   string
+	Content    []byte
+
+	// Matches are the ranges of the content matching the query, ordered by
+	// their offset.
+	Matches []Range
+}
+
+// Document returns the content of a document with the ranges in it matching
+// q, so clients can highlight each occurrence without matching q against
+// the content themselves. If branch is empty, the document may be from any
+// branch. It returns nil if the document doesn't exist. If q is nil or
+// doesn't match the document, the result has no matches.
+func Document(ctx context.Context, s Searcher, repo, path, branch string, q query.Q) (*DocumentResult, error) {
+	doc := []query.Q{query.NewRepoSet(repo), query.NewFileNameSet(path)}
+	if branch != "" {
+		doc = append(doc, &query.Branch{Pattern: branch, Exact: true})
+	}
+	opts := &SearchOptions{Whole: true, ChunkMatches: true}
+
+	var fm *FileMatch
+	if q != nil {
+		sr, err := s.Search(ctx, query.NewAnd(append(doc, q)...), opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(sr.Files) > 0 {
+			fm = &sr.Files[0]
+		}
+	}
+	if fm == nil {
+		sr, err := s.Search(ctx, query.NewAnd(doc...), opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(sr.Files) == 0 {
+			return nil, nil
+		}
+		fm = &sr.Files[0]
+		fm.ChunkMatches = nil
+	}
+
+	res := &DocumentResult{
+		Repository: fm.Repository,
+		FileName:   fm.FileName,
+		Branches:   fm.Branches,
+		Language:   fm.Language,
+		Content:    fm.Content,
+	}
+	for _, cm := range fm.ChunkMatches {
+		if !cm.FileName {
+			res.Matches = append(res.Matches, cm.Ranges...)
+		}
+	}
+	slices.SortFunc(res.Matches, func(a, b Range) int {
+		return cmp.Compare(a.Start.ByteOffset, b.Start.ByteOffset)
+	})
+	return res, nil
+}
+
+// ErrInvalidLineRange is returned for line ranges which don't start at line 1
+// or later, or which end before they start.
+var ErrInvalidLineRange = errors.New("invalid line range")
+
+// LineRange is a range of lines of a document. Start and End are 1-based and
+// inclusive.
+type LineRange struct {
+	Start uint32
+	End   uint32
+}
+
+// LineRangeContent is the content of a range of lines.
+type LineRangeContent struct {
+	Start uint32
+	End   uint32
+
+	// Content holds the lines of the range, including their newlines.
+	Content []byte
+}
+
+// ContentsResult holds ranges of lines of a document.
+type ContentsResult struct {
+	Repository string
+	FileName   string
+	Branches   []string
+
+	// LineCount is the number of lines of the document. A newline at the end
+	// of the document doesn't start another line.
+	LineCount uint32
+
+	// Ranges are the requested ranges, in the order they were requested.
+	// Ranges which end after the last line are cut off at it, and ranges
+	// which start after it are left out.
+	Ranges []LineRangeContent
+}
+
+// Contents returns the lines in ranges of a document, so clients can show
+// more of the context of a match without another search. If branch is empty,
+// the document may be from any branch. It returns nil if the document doesn't
+// exist.
+func Contents(ctx context.Context, s Searcher, repo, path, branch string, ranges []LineRange) (*ContentsResult, error) {
+	for _, r := range ranges {
+		if r.Start < 1 || r.End < r.Start {
+			return nil, fmt.Errorf("%w: %d-%d", ErrInvalidLineRange, r.Start, r.End)
+		}
+	}
+
+	doc, err := Document(ctx, s, repo, path, branch, nil)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+
+	// starts holds the offset of the start of each line, and the length of
+	// the content as the end of the last line.
+	starts := []int{0}
+	for i, c := range doc.Content {
+		if c == '\n' && i+1 < len(doc.Content) {
+			starts = append(starts, i+1)
+		}
+	}
+	starts = append(starts, len(doc.Content))
+	lineCount := uint32(len(starts) - 1)
+	if len(doc.Content) == 0 {
+		lineCount = 0
+	}
+
+	res := &ContentsResult{
+		Repository: doc.Repository,
+		FileName:   doc.FileName,
+		Branches:   doc.Branches,
+		LineCount:  lineCount,
+	}
+	for _, r := range ranges {
+		if r.Start > lineCount {
+			continue
+		}
+		end := min(r.End, lineCount)
+		res.Ranges = append(res.Ranges, LineRangeContent{
+			Start:   r.Start,
+			End:     end,
+			Content: doc.Content[starts[r.Start-1]:starts[end]],
+		})
+	}
+	return res, nil
+}
+
+// CountOptions configures a count of the matches of a query.
+type CountOptions struct {
+	// Estimate counts only with the parts of the query the index answers on
+	// its own, like the ngrams of substrings and the file metadata, without
+	// reading the content of the documents. The counts are upper bounds of
+	// the exact counts, except that a file which only matches a regular
+	// expression counts as one match.
+	Estimate bool
+}
+
+// CountResult holds the number of matches of a query.
+type CountResult struct {
+	// MatchCount is the number of matches, counted like Stats.MatchCount
+	// of a search.
+	MatchCount int
+
+	// FileCount and RepoCount are the number of files and repositories
+	// with matches.
+	FileCount int
+	RepoCount int
+
+	// Estimated is set if the counts are estimates, see
+	// CountOptions.Estimate.
+	Estimated bool
+
+	// Crashes is the number of shards which failed to count their matches.
+	Crashes int
+}
+
+// CountSearcher is implemented by searchers which can count the matches of
+// a query without collecting them, which is much cheaper than a search
+// without limits.
+type CountSearcher interface {
+	Count(ctx context.Context, q query.Q, opts *CountOptions) (*CountResult, error)
+}
+
+// Count counts the matches of q with s. It fails with errors.ErrUnsupported
+// if s doesn't implement CountSearcher.
+func Count(ctx context.Context, s Searcher, q query.Q, opts *CountOptions) (*CountResult, error) {
+	cs, ok := s.(CountSearcher)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support counts: %w", s, errors.ErrUnsupported)
+	}
+	if opts == nil {
+		opts = &CountOptions{}
+	}
+	return cs.Count(ctx, q, opts)
+}
+
+// ShardFile is a file of the shards of a ShardFetcher: a shard, its ".meta"
+// file or a shard manifest.
+type ShardFile struct {
+	// Name is the file name, without directories.
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ShardFetcher is implemented by searchers which can send the files of their
+// shards, eg. to copy them for offline searches.
+type ShardFetcher interface {
+	// ShardFiles returns the files of the shards of the repositories whose
+	// names match repos, sorted by name.
+	ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]ShardFile, error)
+
+	// OpenShardFile opens the file name returned by ShardFiles, and returns
+	// it with its current size and modification time.
+	OpenShardFile(ctx context.Context, name string) (io.ReadCloser, ShardFile, error)
+}
+
+// ShardFiles returns the files of the shards of the repositories of s whose
+// names match repos. It fails with errors.ErrUnsupported if s doesn't
+// implement ShardFetcher.
+func ShardFiles(ctx context.Context, s Searcher, repos *regexp.Regexp) ([]ShardFile, error) {
+	sf, ok := s.(ShardFetcher)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support fetching shards: %w", s, errors.ErrUnsupported)
+	}
+	return sf.ShardFiles(ctx, repos)
+}
+
+// OpenShardFile opens the shard file name of s, see ShardFiles.
+func OpenShardFile(ctx context.Context, s Searcher, name string) (io.ReadCloser, ShardFile, error) {
+	sf, ok := s.(ShardFetcher)
+	if !ok {
+		return nil, ShardFile{}, fmt.Errorf("%s does not support fetching shards: %w", s, errors.ErrUnsupported)
+	}
+	return sf.OpenShardFile(ctx, name)
+}
+
 type Searcher interface {
 	Search(ctx context.Context, q query.Q, opts *SearchOptions) (*SearchResult, error)
 
@@ -969,6 +1860,13 @@ type SearchOptions struct {
 	// be set to 1 to find all repositories containing a result.
 	ShardRepoMaxMatchCount int
 
+	// Maximum number of matches per repository: once the aggregated results
+	// contain this many matches of a repository, further matches of the
+	// repository are dropped. This keeps a single large repository from using
+	// up the display limits. The dropped matches are counted in the
+	// RepoStats.SuppressedMatches of Stats.RepoStats.
+	MaxMatchesPerRepo int
+
 	// Abort the search after this much time has passed.
 	MaxWallTime time.Duration
 
@@ -977,6 +1875,12 @@ type SearchOptions struct {
 	// be sent and then the behaviour will revert to the normal streaming.
 	FlushWallTime time.Duration
 
+	// ProgressInterval if non-zero makes StreamSearch send a progress event
+	// at this interval while shards are being searched, and once all shards
+	// are searched. Progress events only set the Progress of the
+	// SearchResult, see SearchResult.IsProgressEvent.
+	ProgressInterval time.Duration
+
 	// Truncates the number of documents (i.e. files) after collating and
 	// sorting the results.
 	MaxDocDisplayCount int
@@ -984,6 +1888,12 @@ type SearchOptions struct {
 	// Truncates the number of matchs after collating and sorting the results.
 	MaxMatchDisplayCount int
 
+	// MaxDisplayBytes truncates the files after collating and sorting the
+	// results, so that the sum of their FileMatch.SizeBytes stays below this
+	// many bytes. The first file is always kept. It bounds the size of
+	// responses whose files have long lines or many context lines.
+	MaxDisplayBytes int
+
 	// If set to a number greater than zero then up to this many number
 	// of context lines will be added before and after each matched line.
 	// Note that the included context lines might contain matches and
@@ -1003,6 +1913,10 @@ type SearchOptions struct {
 	// of the query terms during evaluation. This is true, for example, if all query
 	// terms are ORed together.
 	//
+	// The average document length is the one of all shards searched, so
+	// scores of different shards are comparable. The default scoring doesn't
+	// depend on the shard.
+	//
 	// When enabled, all other scoring signals are ignored, including document ranks.
 	UseBM25Scoring bool
 
@@ -1013,8 +1927,88 @@ type SearchOptions struct {
 	// If set, the search results will contain debug information for scoring.
 	DebugScore bool
 
+	// DetailedStats collects statistics for each atom of the query in
+	// Stats.Atoms, such as the ngrams chosen and the number of candidates
+	// rejected at each stage. It is meant for analyzing the performance of
+	// queries.
+	DetailedStats bool
+
 	// SpanContext is the opentracing span context, if it exists, from the zoekt client
 	SpanContext map[string]string
+
+	// ReturnResultSet returns the documents which matched in
+	// SearchResult.ResultSet, so later searches can be restricted to them
+	// with Within.
+	ReturnResultSet bool
+
+	// Within restricts the search to the documents of a result set of a
+	// previous search. Shards which are not in it are skipped.
+	Within *ResultSet
+
+	// FilesOnly returns the matching files without their matches, only
+	// with the number of matches in FileMatch.MatchCount. The contents of
+	// the files are not loaded to build LineMatches and ChunkMatches, which
+	// makes listing the files which match much faster.
+	FilesOnly bool
+
+	// RankingSignalsWeight blends the precomputed ranking signals of the
+	// files, see index.Document.RankingSignal, into their scores. With a
+	// weight of 1, the signal adds up to the score of a word match. Zero
+	// ignores the signals. It has no effect with UseBM25Scoring.
+	RankingSignalsWeight float64
+
+	// FileNamesFirst streams in two phases, so interactive clients can show
+	// results right away. The first phase streams the files whose names or
+	// symbols match, without searching the contents. The second phase
+	// searches as usual at the same time, and once the first phase is done
+	// sends all the results in a single event with SearchResult.Final set,
+	// which replaces the results of the first phase.
+	// It only affects StreamSearch.
+	FileNamesFirst bool
+
+	// TabWidth is the number of columns between tab stops, which tabs
+	// advance the display columns of matches to. Zero means 8.
+	TabWidth int
+
+	// PathSeparatorAgnostic makes / and \ in the patterns of file name
+	// queries, such as file:src/main.go, match either path separator. This
+	// helps searching code which was indexed with Windows paths.
+	PathSeparatorAgnostic bool
+
+	// Anytime makes StreamSearch send the best results found, instead of the
+	// first ones, when the search is canceled, eg. by MaxWallTime. It keeps
+	// the best MaxDocDisplayCount files of the shards searched so far, and
+	// sends them in one SearchResult once all shards are searched or the
+	// search is canceled. The display limits don't stop the search early,
+	// and FlushWallTime is ignored. Stats.Completeness estimates how much of
+	// the search was done. Search always returns the best results found.
+	Anytime bool
+
+	// ScoreFieldClasses scores the matches of a file by the class of the
+	// field they match: symbols (sym:), file names (file:) or contents. The
+	// best match of each class is weighted by its class, symbols highest,
+	// and the best weighted match decides the score of the file. Files with
+	// the same score are ordered by the class of their best match, in the
+	// same order. The class is reported in FileMatch.FieldClass, so the
+	// results of queries like `Foo (sym:Foo or file:Foo)` tell why they
+	// matched. It has no effect with UseBM25Scoring or FilesOnly.
+	ScoreFieldClasses bool
+
+	// Provenance reports the shard and the content digests of each file
+	// match in FileMatch.Provenance, for audits. Computing the SHA-256 digest
+	// of the content makes searches slower.
+	Provenance bool
+
+	// Experiments are the experiment flags enabled for the search, sorted.
+	// Code paths under experiment check them with HasExperiment. Package
+	// internal/experiments sets them from the flags of the request.
+	Experiments []string
+}
+
+// HasExperiment returns whether the experiment flag name is enabled.
+func (o *SearchOptions) HasExperiment(name string) bool {
+	_, found := slices.BinarySearch(o.Experiments, name)
+	return found
 }
 
 func (o *SearchOptions) SetDefaults() {
@@ -1028,6 +2022,37 @@ func (o *SearchOptions) SetDefaults() {
 	}
 }
 
+// DisplayHint describes the room a client has for the results of a search,
+// so that the server chooses the display limits instead of the client
+// guessing them, see SearchOptions.ApplyDisplayHint.
+type DisplayHint struct {
+	// Lines is the number of lines of results the client shows at once, eg.
+	// the height of its viewport. Zero means 50.
+	Lines int
+
+	// MaxBytes is the most bytes of matches the client wants to receive.
+	// Zero means 1 MiB.
+	MaxBytes int
+}
+
+// ApplyDisplayHint sets the display limits and the number of context lines of
+// o for a client described by h. It asks for a few screens of results: taller
+// screens get more context lines, and the files and matches are limited so
+// that they fill the screens with context, within h.MaxBytes.
+func (o *SearchOptions) ApplyDisplayHint(h DisplayHint) {
+	lines := min(max(cmp.Or(h.Lines, 50), 10), 1000)
+
+	// 50 lines show one line of context around matches, 150 lines three.
+	o.NumContextLines = min(lines/50, 3)
+	perMatch := 1 + 2*o.NumContextLines
+	budget := 5 * lines
+
+	o.MaxMatchDisplayCount = max(budget/perMatch, 10)
+	// A file shows its name and, typically, a few matches.
+	o.MaxDocDisplayCount = max(budget/(1+3*perMatch), 5)
+	o.MaxDisplayBytes = cmp.Or(h.MaxBytes, 1<<20)
+}
+
 // String returns a succinct representation of the options. This is meant for
 // human consumption in logs and traces.
 //
@@ -1066,12 +2091,16 @@ func (s *SearchOptions) String() string {
 	addInt("ShardMaxMatchCount", s.ShardMaxMatchCount)
 	addInt("TotalMaxMatchCount", s.TotalMaxMatchCount)
 	addInt("ShardRepoMaxMatchCount", s.ShardRepoMaxMatchCount)
+	addInt("MaxMatchesPerRepo", s.MaxMatchesPerRepo)
 	addInt("MaxDocDisplayCount", s.MaxDocDisplayCount)
 	addInt("MaxMatchDisplayCount", s.MaxMatchDisplayCount)
+	addInt("MaxDisplayBytes", s.MaxDisplayBytes)
 	addInt("NumContextLines", s.NumContextLines)
+	addInt("TabWidth", s.TabWidth)
 
 	addDuration("MaxWallTime", s.MaxWallTime)
 	addDuration("FlushWallTime", s.FlushWallTime)
+	addDuration("ProgressInterval", s.ProgressInterval)
 
 	addBool("EstimateDocCount", s.EstimateDocCount)
 	addBool("Whole", s.Whole)
@@ -1079,6 +2108,24 @@ func (s *SearchOptions) String() string {
 	addBool("UseBM25Scoring", s.UseBM25Scoring)
 	addBool("Trace", s.Trace)
 	addBool("DebugScore", s.DebugScore)
+	addBool("DetailedStats", s.DetailedStats)
+	addBool("ReturnResultSet", s.ReturnResultSet)
+	addBool("FilesOnly", s.FilesOnly)
+	addBool("FileNamesFirst", s.FileNamesFirst)
+	addBool("PathSeparatorAgnostic", s.PathSeparatorAgnostic)
+	addBool("Anytime", s.Anytime)
+	addBool("ScoreFieldClasses", s.ScoreFieldClasses)
+	addBool("Provenance", s.Provenance)
+	if len(s.Experiments) > 0 {
+		add("Experiments", strings.Join(s.Experiments, ","))
+	}
+	if s.RankingSignalsWeight != 0 {
+		add("RankingSignalsWeight", strconv.FormatFloat(s.RankingSignalsWeight, 'g', -1, 64))
+	}
+	if s.Within != nil {
+		// The number of shards in the result set.
+		add("Within", strconv.Itoa(len(s.Within.Shards)))
+	}
 
 	for k, v := range s.SpanContext {
 		add("SpanContext."+k, strconv.Quote(v))
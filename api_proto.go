@@ -15,9 +15,12 @@
 package zoekt // import "github.com/sourcegraph/zoekt"
 
 import (
+	"fmt"
 	"math/rand"
 	"reflect"
+	"strconv"
 
+	"github.com/RoaringBitmap/roaring"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -51,6 +54,11 @@ func FileMatchFromProto(p *proto.FileMatch) FileMatch {
 		SubRepositoryName:  p.GetSubRepositoryName(),
 		SubRepositoryPath:  p.GetSubRepositoryPath(),
 		Version:            p.GetVersion(),
+		IndexTimeUnix:      p.GetIndexTimeUnix(),
+		MatchCount:         int(p.GetMatchCount()),
+		Labels:             p.GetLabels(),
+		FieldClass:         p.GetFieldClass(),
+		Provenance:         provenanceFromProto(p.GetProvenance()),
 	}
 }
 
@@ -81,6 +89,43 @@ func (m *FileMatch) ToProto() *proto.FileMatch {
 		SubRepositoryName:  m.SubRepositoryName,
 		SubRepositoryPath:  m.SubRepositoryPath,
 		Version:            m.Version,
+		IndexTimeUnix:      m.IndexTimeUnix,
+		MatchCount:         int64(m.MatchCount),
+		Labels:             m.Labels,
+		FieldClass:         m.FieldClass,
+		Provenance:         m.Provenance.toProto(),
+	}
+}
+
+func provenanceFromProto(p *proto.Provenance) *Provenance {
+	if p == nil {
+		return nil
+	}
+	return &Provenance{
+		ShardFile:           p.GetShardFile(),
+		ShardID:             p.GetShardId(),
+		IndexTime:           p.GetIndexTime().AsTime(),
+		ZoektVersion:        p.GetZoektVersion(),
+		IndexFormatVersion:  int(p.GetIndexFormatVersion()),
+		IndexFeatureVersion: int(p.GetIndexFeatureVersion()),
+		Checksum:            p.GetChecksum(),
+		ContentSHA256:       p.GetContentSha256(),
+	}
+}
+
+func (p *Provenance) toProto() *proto.Provenance {
+	if p == nil {
+		return nil
+	}
+	return &proto.Provenance{
+		ShardFile:           p.ShardFile,
+		ShardId:             p.ShardID,
+		IndexTime:           timestamppb.New(p.IndexTime),
+		ZoektVersion:        p.ZoektVersion,
+		IndexFormatVersion:  int64(p.IndexFormatVersion),
+		IndexFeatureVersion: int64(p.IndexFeatureVersion),
+		Checksum:            p.Checksum,
+		ContentSha256:       p.ContentSHA256,
 	}
 }
 
@@ -146,17 +191,19 @@ func (r *Range) ToProto() *proto.Range {
 
 func LocationFromProto(p *proto.Location) Location {
 	return Location{
-		ByteOffset: p.GetByteOffset(),
-		LineNumber: p.GetLineNumber(),
-		Column:     p.GetColumn(),
+		ByteOffset:    p.GetByteOffset(),
+		LineNumber:    p.GetLineNumber(),
+		Column:        p.GetColumn(),
+		DisplayColumn: p.GetDisplayColumn(),
 	}
 }
 
 func (l *Location) ToProto() *proto.Location {
 	return &proto.Location{
-		ByteOffset: l.ByteOffset,
-		LineNumber: l.LineNumber,
-		Column:     l.Column,
+		ByteOffset:    l.ByteOffset,
+		LineNumber:    l.LineNumber,
+		Column:        l.Column,
+		DisplayColumn: l.DisplayColumn,
 	}
 }
 
@@ -210,6 +257,7 @@ func SymbolFromProto(p *proto.SymbolInfo) *Symbol {
 		Kind:       p.GetKind(),
 		Parent:     p.GetParent(),
 		ParentKind: p.GetParentKind(),
+		Doc:        p.GetDoc(),
 	}
 }
 
@@ -223,24 +271,33 @@ func (s *Symbol) ToProto() *proto.SymbolInfo {
 		Kind:       s.Kind,
 		Parent:     s.Parent,
 		ParentKind: s.ParentKind,
+		Doc:        s.Doc,
 	}
 }
 
 func LineFragmentMatchFromProto(p *proto.LineFragmentMatch) LineFragmentMatch {
 	return LineFragmentMatch{
-		LineOffset:  int(p.GetLineOffset()),
-		Offset:      p.GetOffset(),
-		MatchLength: int(p.GetMatchLength()),
-		SymbolInfo:  SymbolFromProto(p.GetSymbolInfo()),
+		LineOffset:       int(p.GetLineOffset()),
+		Offset:           p.GetOffset(),
+		MatchLength:      int(p.GetMatchLength()),
+		SymbolInfo:       SymbolFromProto(p.GetSymbolInfo()),
+		Column:           p.GetColumn(),
+		EndColumn:        p.GetEndColumn(),
+		DisplayColumn:    p.GetDisplayColumn(),
+		EndDisplayColumn: p.GetEndDisplayColumn(),
 	}
 }
 
 func (lfm *LineFragmentMatch) ToProto() *proto.LineFragmentMatch {
 	return &proto.LineFragmentMatch{
-		LineOffset:  int64(lfm.LineOffset),
-		Offset:      lfm.Offset,
-		MatchLength: int64(lfm.MatchLength),
-		SymbolInfo:  lfm.SymbolInfo.ToProto(),
+		LineOffset:       int64(lfm.LineOffset),
+		Offset:           lfm.Offset,
+		MatchLength:      int64(lfm.MatchLength),
+		SymbolInfo:       lfm.SymbolInfo.ToProto(),
+		Column:           lfm.Column,
+		EndColumn:        lfm.EndColumn,
+		DisplayColumn:    lfm.DisplayColumn,
+		EndDisplayColumn: lfm.EndDisplayColumn,
 	}
 }
 
@@ -284,7 +341,36 @@ func (fr FlushReason) Generate(rand *rand.Rand, size int) reflect.Value {
 	}
 }
 
+// Generate valid result sets for quickchecks
+func (*ResultSet) Generate(rand *rand.Rand, size int) reflect.Value {
+	r := &ResultSet{Shards: map[string]*roaring.Bitmap{}}
+	for range rand.Intn(3) {
+		docs := roaring.New()
+		for range rand.Intn(10) {
+			docs.Add(rand.Uint32() % 1000)
+		}
+		r.Shards[strconv.Itoa(rand.Int())] = docs
+	}
+	return reflect.ValueOf(r)
+}
+
 func StatsFromProto(p *proto.Stats) Stats {
+	var repoStats map[string]RepoStats
+	if p.GetRepoStats() != nil {
+		repoStats = make(map[string]RepoStats, len(p.GetRepoStats()))
+		for repo, rs := range p.GetRepoStats() {
+			repoStats[repo] = RepoStatsFromProto(rs)
+		}
+	}
+
+	var atoms []AtomStats
+	if p.GetAtoms() != nil {
+		atoms = make([]AtomStats, 0, len(p.GetAtoms()))
+		for _, a := range p.GetAtoms() {
+			atoms = append(atoms, AtomStatsFromProto(a))
+		}
+	}
+
 	return Stats{
 		ContentBytesLoaded:    p.GetContentBytesLoaded(),
 		IndexBytesLoaded:      p.GetIndexBytesLoaded(),
@@ -298,18 +384,41 @@ func StatsFromProto(p *proto.Stats) Stats {
 		ShardsScanned:         int(p.GetShardsScanned()),
 		ShardsSkipped:         int(p.GetShardsSkipped()),
 		ShardsSkippedFilter:   int(p.GetShardsSkippedFilter()),
+		ShardsInterrupted:     int(p.GetShardsInterrupted()),
 		MatchCount:            int(p.GetMatchCount()),
 		NgramMatches:          int(p.GetNgramMatches()),
 		NgramLookups:          int(p.GetNgramLookups()),
 		Wait:                  p.GetWait().AsDuration(),
 		MatchTreeConstruction: p.GetMatchTreeConstruction().AsDuration(),
 		MatchTreeSearch:       p.GetMatchTreeSearch().AsDuration(),
+		MatchTreeBudgetSearch: p.GetMatchTreeBudgetSearch().AsDuration(),
+		BudgetsExhausted:      int(p.GetBudgetsExhausted()),
 		RegexpsConsidered:     int(p.GetRegexpsConsidered()),
 		FlushReason:           FlushReasonFromProto(p.GetFlushReason()),
+
+		RepoStats:   repoStats,
+		Atoms:       atoms,
+		Experiments: p.GetExperiments(),
 	}
 }
 
 func (s *Stats) ToProto() *proto.Stats {
+	var repoStats map[string]*proto.RepoStats
+	if s.RepoStats != nil {
+		repoStats = make(map[string]*proto.RepoStats, len(s.RepoStats))
+		for repo, rs := range s.RepoStats {
+			repoStats[repo] = rs.ToProto()
+		}
+	}
+
+	var atoms []*proto.AtomStats
+	if s.Atoms != nil {
+		atoms = make([]*proto.AtomStats, 0, len(s.Atoms))
+		for i := range s.Atoms {
+			atoms = append(atoms, s.Atoms[i].ToProto())
+		}
+	}
+
 	return &proto.Stats{
 		ContentBytesLoaded:    s.ContentBytesLoaded,
 		IndexBytesLoaded:      s.IndexBytesLoaded,
@@ -323,14 +432,65 @@ func (s *Stats) ToProto() *proto.Stats {
 		ShardsScanned:         int64(s.ShardsScanned),
 		ShardsSkipped:         int64(s.ShardsSkipped),
 		ShardsSkippedFilter:   int64(s.ShardsSkippedFilter),
+		ShardsInterrupted:     int64(s.ShardsInterrupted),
 		MatchCount:            int64(s.MatchCount),
 		NgramMatches:          int64(s.NgramMatches),
 		NgramLookups:          int64(s.NgramLookups),
 		Wait:                  durationpb.New(s.Wait),
 		MatchTreeConstruction: durationpb.New(s.MatchTreeConstruction),
 		MatchTreeSearch:       durationpb.New(s.MatchTreeSearch),
+		MatchTreeBudgetSearch: durationpb.New(s.MatchTreeBudgetSearch),
+		BudgetsExhausted:      int64(s.BudgetsExhausted),
 		RegexpsConsidered:     int64(s.RegexpsConsidered),
 		FlushReason:           s.FlushReason.ToProto(),
+
+		RepoStats:   repoStats,
+		Atoms:       atoms,
+		Experiments: s.Experiments,
+	}
+}
+
+func AtomStatsFromProto(p *proto.AtomStats) AtomStats {
+	var ngrams map[string]int64
+	if p.GetNgrams() != nil {
+		ngrams = make(map[string]int64, len(p.GetNgrams()))
+		for ng, sz := range p.GetNgrams() {
+			ngrams[ng] = sz
+		}
+	}
+
+	return AtomStats{
+		Atom:                  p.GetAtom(),
+		Ngrams:                ngrams,
+		NgramLookups:          int(p.GetNgramLookups()),
+		IndexBytesLoaded:      p.GetIndexBytesLoaded(),
+		CandidatesOutOfBounds: int(p.GetCandidatesOutOfBounds()),
+		NgramMatches:          int(p.GetNgramMatches()),
+		CandidatesRejected:    int(p.GetCandidatesRejected()),
+		FilesEvaluated:        int(p.GetFilesEvaluated()),
+		FilesMatched:          int(p.GetFilesMatched()),
+	}
+}
+
+func (a *AtomStats) ToProto() *proto.AtomStats {
+	var ngrams map[string]int64
+	if a.Ngrams != nil {
+		ngrams = make(map[string]int64, len(a.Ngrams))
+		for ng, sz := range a.Ngrams {
+			ngrams[ng] = sz
+		}
+	}
+
+	return &proto.AtomStats{
+		Atom:                  a.Atom,
+		Ngrams:                ngrams,
+		NgramLookups:          int64(a.NgramLookups),
+		IndexBytesLoaded:      a.IndexBytesLoaded,
+		CandidatesOutOfBounds: int64(a.CandidatesOutOfBounds),
+		NgramMatches:          int64(a.NgramMatches),
+		CandidatesRejected:    int64(a.CandidatesRejected),
+		FilesEvaluated:        int64(a.FilesEvaluated),
+		FilesMatched:          int64(a.FilesMatched),
 	}
 }
 
@@ -338,6 +498,9 @@ func ProgressFromProto(p *proto.Progress) Progress {
 	return Progress{
 		Priority:           p.GetPriority(),
 		MaxPendingPriority: p.GetMaxPendingPriority(),
+		ShardsCompleted:    int(p.GetShardsCompleted()),
+		ShardsTotal:        int(p.GetShardsTotal()),
+		FilesConsidered:    int(p.GetFilesConsidered()),
 	}
 }
 
@@ -345,20 +508,28 @@ func (p *Progress) ToProto() *proto.Progress {
 	return &proto.Progress{
 		Priority:           p.Priority,
 		MaxPendingPriority: p.MaxPendingPriority,
+		ShardsCompleted:    int64(p.ShardsCompleted),
+		ShardsTotal:        int64(p.ShardsTotal),
+		FilesConsidered:    int64(p.FilesConsidered),
 	}
 }
 
-func SearchResultFromStreamProto(p *proto.StreamSearchResponse, repoURLs, lineFragments map[string]string) *SearchResult {
+func SearchResultFromStreamProto(p *proto.StreamSearchResponse, repoURLs, lineFragments map[string]string) (*SearchResult, error) {
 	if p == nil {
-		return nil
+		return nil, nil
 	}
 
 	return SearchResultFromProto(p.GetResponseChunk(), repoURLs, lineFragments)
 }
 
-func SearchResultFromProto(p *proto.SearchResponse, repoURLs, lineFragments map[string]string) *SearchResult {
+func SearchResultFromProto(p *proto.SearchResponse, repoURLs, lineFragments map[string]string) (*SearchResult, error) {
 	if p == nil {
-		return nil
+		return nil, nil
+	}
+
+	resultSet, err := resultSetFromProto(p.GetResultSet())
+	if err != nil {
+		return nil, err
 	}
 
 	files := make([]FileMatch, len(p.GetFiles()))
@@ -374,7 +545,10 @@ func SearchResultFromProto(p *proto.SearchResponse, repoURLs, lineFragments map[
 
 		RepoURLs:      repoURLs,
 		LineFragments: lineFragments,
-	}
+
+		ResultSet: resultSet,
+		Final:     p.GetFinal(),
+	}, nil
 }
 
 func (sr *SearchResult) ToProto() *proto.SearchResponse {
@@ -392,7 +566,31 @@ func (sr *SearchResult) ToProto() *proto.SearchResponse {
 		Progress: sr.Progress.ToProto(),
 
 		Files: files,
+
+		ResultSet: sr.ResultSet.toProto(),
+		Final:     sr.Final,
+	}
+}
+
+// resultSetFromProto decodes a result set.
+func resultSetFromProto(b []byte) (*ResultSet, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var r ResultSet
+	if err := r.UnmarshalBinary(b); err != nil {
+		return nil, fmt.Errorf("invalid result set: %w", err)
 	}
+	return &r, nil
+}
+
+func (r *ResultSet) toProto() []byte {
+	if r == nil {
+		return nil
+	}
+	// Encoding into memory can't fail.
+	b, _ := r.MarshalBinary()
+	return b
 }
 
 func (sr *SearchResult) ToStreamProto() *proto.StreamSearchResponse {
@@ -453,6 +651,7 @@ func RepositoryFromProto(p *proto.Repository) Repository {
 		Tombstone:            p.GetTombstone(),
 		LatestCommitDate:     p.GetLatestCommitDate().AsTime(),
 		FileTombstones:       fileTombstones,
+		IndexTime:            p.GetIndexTime().AsTime(),
 	}
 }
 
@@ -495,6 +694,7 @@ func (r *Repository) ToProto() *proto.Repository {
 		Tombstone:            r.Tombstone,
 		LatestCommitDate:     timestamppb.New(r.LatestCommitDate),
 		FileTombstones:       fileTombstones,
+		IndexTime:            timestamppb.New(r.IndexTime),
 	}
 }
 
@@ -548,6 +748,7 @@ func RepoStatsFromProto(p *proto.RepoStats) RepoStats {
 		NewLinesCount:              p.GetNewLinesCount(),
 		DefaultBranchNewLinesCount: p.GetDefaultBranchNewLinesCount(),
 		OtherBranchesNewLinesCount: p.GetOtherBranchesNewLinesCount(),
+		SuppressedMatches:          int(p.GetSuppressedMatches()),
 	}
 }
 
@@ -561,6 +762,7 @@ func (s *RepoStats) ToProto() *proto.RepoStats {
 		NewLinesCount:              s.NewLinesCount,
 		DefaultBranchNewLinesCount: s.DefaultBranchNewLinesCount,
 		OtherBranchesNewLinesCount: s.OtherBranchesNewLinesCount,
+		SuppressedMatches:          int64(s.SuppressedMatches),
 	}
 }
 
@@ -684,9 +886,14 @@ func ListOptionsFromProto(p *proto.ListOptions) *ListOptions {
 	}
 }
 
-func SearchOptionsFromProto(p *proto.SearchOptions) *SearchOptions {
+func SearchOptionsFromProto(p *proto.SearchOptions) (*SearchOptions, error) {
 	if p == nil {
-		return nil
+		return nil, nil
+	}
+
+	within, err := resultSetFromProto(p.GetWithin())
+	if err != nil {
+		return nil, err
 	}
 
 	return &SearchOptions{
@@ -697,14 +904,29 @@ func SearchOptionsFromProto(p *proto.SearchOptions) *SearchOptions {
 		ShardRepoMaxMatchCount: int(p.GetShardRepoMaxMatchCount()),
 		MaxWallTime:            p.GetMaxWallTime().AsDuration(),
 		FlushWallTime:          p.GetFlushWallTime().AsDuration(),
+		ProgressInterval:       p.GetProgressInterval().AsDuration(),
 		MaxDocDisplayCount:     int(p.GetMaxDocDisplayCount()),
 		MaxMatchDisplayCount:   int(p.GetMaxMatchDisplayCount()),
+		MaxDisplayBytes:        int(p.GetMaxDisplayBytes()),
 		NumContextLines:        int(p.GetNumContextLines()),
 		ChunkMatches:           p.GetChunkMatches(),
 		Trace:                  p.GetTrace(),
 		DebugScore:             p.GetDebugScore(),
 		UseBM25Scoring:         p.GetUseBm25Scoring(),
-	}
+		MaxMatchesPerRepo:      int(p.GetMaxMatchesPerRepo()),
+		DetailedStats:          p.GetDetailedStats(),
+		ReturnResultSet:        p.GetReturnResultSet(),
+		Within:                 within,
+		FilesOnly:              p.GetFilesOnly(),
+		RankingSignalsWeight:   p.GetRankingSignalsWeight(),
+		FileNamesFirst:         p.GetFileNamesFirst(),
+		TabWidth:               int(p.GetTabWidth()),
+		PathSeparatorAgnostic:  p.GetPathSeparatorAgnostic(),
+		Anytime:                p.GetAnytime(),
+		ScoreFieldClasses:      p.GetScoreFieldClasses(),
+		Provenance:             p.GetProvenance(),
+		Experiments:            p.GetExperiments(),
+	}, nil
 }
 
 func (s *SearchOptions) ToProto() *proto.SearchOptions {
@@ -720,12 +942,266 @@ func (s *SearchOptions) ToProto() *proto.SearchOptions {
 		ShardRepoMaxMatchCount: int64(s.ShardRepoMaxMatchCount),
 		MaxWallTime:            durationpb.New(s.MaxWallTime),
 		FlushWallTime:          durationpb.New(s.FlushWallTime),
+		ProgressInterval:       durationpb.New(s.ProgressInterval),
 		MaxDocDisplayCount:     int64(s.MaxDocDisplayCount),
 		MaxMatchDisplayCount:   int64(s.MaxMatchDisplayCount),
+		MaxDisplayBytes:        int64(s.MaxDisplayBytes),
 		NumContextLines:        int64(s.NumContextLines),
 		ChunkMatches:           s.ChunkMatches,
 		Trace:                  s.Trace,
 		DebugScore:             s.DebugScore,
 		UseBm25Scoring:         s.UseBM25Scoring,
+		MaxMatchesPerRepo:      int64(s.MaxMatchesPerRepo),
+		DetailedStats:          s.DetailedStats,
+		ReturnResultSet:        s.ReturnResultSet,
+		Within:                 s.Within.toProto(),
+		FilesOnly:              s.FilesOnly,
+		RankingSignalsWeight:   s.RankingSignalsWeight,
+		FileNamesFirst:         s.FileNamesFirst,
+		TabWidth:               int64(s.TabWidth),
+		PathSeparatorAgnostic:  s.PathSeparatorAgnostic,
+		Anytime:                s.Anytime,
+		ScoreFieldClasses:      s.ScoreFieldClasses,
+		Provenance:             s.Provenance,
+		Experiments:            s.Experiments,
+	}
+}
+
+func DefinitionOptionsFromProto(p *proto.DefinitionOptions) *DefinitionOptions {
+	if p == nil {
+		return nil
+	}
+
+	return &DefinitionOptions{
+		Language:   p.GetLanguage(),
+		RepoFilter: p.GetRepoFilter(),
+		Branch:     p.GetBranch(),
+		MaxResults: int(p.GetMaxResults()),
+	}
+}
+
+func (o *DefinitionOptions) ToProto() *proto.DefinitionOptions {
+	if o == nil {
+		return nil
+	}
+
+	return &proto.DefinitionOptions{
+		Language:   o.Language,
+		RepoFilter: o.RepoFilter,
+		Branch:     o.Branch,
+		MaxResults: int64(o.MaxResults),
+	}
+}
+
+func RepositoryMetadataUpdateFromProto(p *proto.UpdateRepositoryMetadataRequest) *RepositoryMetadataUpdate {
+	if p == nil {
+		return nil
+	}
+
+	// Unset lists are left unchanged, but empty ones replace the old values,
+	// so they must not be nil.
+	fromProto := func(l *proto.StringList) []string {
+		if l == nil {
+			return nil
+		}
+		return append([]string{}, l.GetValues()...)
+	}
+	return &RepositoryMetadataUpdate{
+		Priority:    p.Priority,
+		Archived:    p.Archived,
+		Topics:      fromProto(p.GetTopics()),
+		BranchOrder: fromProto(p.GetBranchOrder()),
+	}
+}
+
+// ToProto returns the request updating the metadata of repository with u.
+func (u *RepositoryMetadataUpdate) ToProto(repository string) *proto.UpdateRepositoryMetadataRequest {
+	if u == nil {
+		return &proto.UpdateRepositoryMetadataRequest{Repository: repository}
+	}
+
+	toProto := func(l []string) *proto.StringList {
+		if l == nil {
+			return nil
+		}
+		return &proto.StringList{Values: l}
+	}
+	return &proto.UpdateRepositoryMetadataRequest{
+		Repository:  repository,
+		Priority:    u.Priority,
+		Archived:    u.Archived,
+		Topics:      toProto(u.Topics),
+		BranchOrder: toProto(u.BranchOrder),
+	}
+}
+
+func CountOptionsFromProto(p *proto.CountOptions) *CountOptions {
+	if p == nil {
+		return nil
+	}
+
+	return &CountOptions{
+		Estimate: p.GetEstimate(),
+	}
+}
+
+func (o *CountOptions) ToProto() *proto.CountOptions {
+	if o == nil {
+		return nil
+	}
+
+	return &proto.CountOptions{
+		Estimate: o.Estimate,
+	}
+}
+
+func CountResultFromProto(p *proto.CountResponse) *CountResult {
+	return &CountResult{
+		MatchCount: int(p.GetMatchCount()),
+		FileCount:  int(p.GetFileCount()),
+		RepoCount:  int(p.GetRepoCount()),
+		Estimated:  p.GetEstimated(),
+		Crashes:    int(p.GetCrashes()),
+	}
+}
+
+func (r *CountResult) ToProto() *proto.CountResponse {
+	return &proto.CountResponse{
+		MatchCount: int64(r.MatchCount),
+		FileCount:  int64(r.FileCount),
+		RepoCount:  int64(r.RepoCount),
+		Estimated:  r.Estimated,
+		Crashes:    int64(r.Crashes),
+	}
+}
+
+func DefinitionFromProto(p *proto.Definition) Definition {
+	var sym Symbol
+	if s := SymbolFromProto(p.GetSymbol()); s != nil {
+		sym = *s
+	}
+
+	return Definition{
+		Repository:         p.GetRepository(),
+		RepositoryPriority: p.GetRepositoryPriority(),
+		FileName:           string(p.GetFileName()), // Note: 🚨Warning, this filename may be a non-UTF8 string.
+		Branches:           p.GetBranches(),
+		Language:           p.GetLanguage(),
+		Symbol:             sym,
+		Start:              LocationFromProto(p.GetStart()),
+		Score:              p.GetScore(),
+	}
+}
+
+func (d *Definition) ToProto() *proto.Definition {
+	return &proto.Definition{
+		Repository:         d.Repository,
+		RepositoryPriority: d.RepositoryPriority,
+		FileName:           []byte(d.FileName),
+		Branches:           d.Branches,
+		Language:           d.Language,
+		Symbol:             d.Symbol.ToProto(),
+		Start:              d.Start.ToProto(),
+		Score:              d.Score,
+	}
+}
+
+func DocumentResultFromProto(p *proto.DocumentResponse) *DocumentResult {
+	matches := make([]Range, len(p.GetMatches()))
+	for i, r := range p.GetMatches() {
+		matches[i] = RangeFromProto(r)
+	}
+
+	return &DocumentResult{
+		Repository: p.GetRepository(),
+		FileName:   string(p.GetFileName()), // Note: 🚨Warning, this filename may be a non-UTF8 string.
+		Branches:   p.GetBranches(),
+		Language:   p.GetLanguage(),
+		Content:    p.GetContent(),
+		Matches:    matches,
+	}
+}
+
+func (d *DocumentResult) ToProto() *proto.DocumentResponse {
+	matches := make([]*proto.Range, len(d.Matches))
+	for i, r := range d.Matches {
+		matches[i] = r.ToProto()
+	}
+
+	return &proto.DocumentResponse{
+		Repository: d.Repository,
+		FileName:   []byte(d.FileName),
+		Branches:   d.Branches,
+		Language:   d.Language,
+		Content:    d.Content,
+		Matches:    matches,
+	}
+}
+
+func LineRangeFromProto(p *proto.LineRange) LineRange {
+	return LineRange{
+		Start: p.GetStart(),
+		End:   p.GetEnd(),
+	}
+}
+
+func (r LineRange) ToProto() *proto.LineRange {
+	return &proto.LineRange{
+		Start: r.Start,
+		End:   r.End,
+	}
+}
+
+func ContentsResultFromProto(p *proto.ContentsResponse) *ContentsResult {
+	ranges := make([]LineRangeContent, len(p.GetRanges()))
+	for i, r := range p.GetRanges() {
+		ranges[i] = LineRangeContent{
+			Start:   r.GetStart(),
+			End:     r.GetEnd(),
+			Content: r.GetContent(),
+		}
+	}
+
+	return &ContentsResult{
+		Repository: p.GetRepository(),
+		FileName:   string(p.GetFileName()), // Note: 🚨Warning, this filename may be a non-UTF8 string.
+		Branches:   p.GetBranches(),
+		LineCount:  p.GetLineCount(),
+		Ranges:     ranges,
+	}
+}
+
+func (c *ContentsResult) ToProto() *proto.ContentsResponse {
+	ranges := make([]*proto.LineRangeContent, len(c.Ranges))
+	for i, r := range c.Ranges {
+		ranges[i] = &proto.LineRangeContent{
+			Start:   r.Start,
+			End:     r.End,
+			Content: r.Content,
+		}
+	}
+
+	return &proto.ContentsResponse{
+		Repository: c.Repository,
+		FileName:   []byte(c.FileName),
+		Branches:   c.Branches,
+		LineCount:  c.LineCount,
+		Ranges:     ranges,
+	}
+}
+
+func ShardFileFromProto(p *proto.ShardFile) ShardFile {
+	return ShardFile{
+		Name:    p.GetName(),
+		Size:    p.GetSize(),
+		ModTime: p.GetModTime().AsTime(),
+	}
+}
+
+func (f ShardFile) ToProto() *proto.ShardFile {
+	return &proto.ShardFile{
+		Name:    f.Name,
+		Size:    f.Size,
+		ModTime: timestamppb.New(f.ModTime),
 	}
 }
@@ -25,6 +25,7 @@ import (
 	"testing/quick"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	webproto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
@@ -100,6 +101,94 @@ func TestProtoRoundtrip(t *testing.T) {
 		}
 	})
 
+	t.Run("Definition", func(t *testing.T) {
+		f := func(f1 Definition) bool {
+			p1 := f1.ToProto()
+			f2 := DefinitionFromProto(p1)
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("DefinitionOptions", func(t *testing.T) {
+		f := func(f1 *DefinitionOptions) bool {
+			p1 := f1.ToProto()
+			f2 := DefinitionOptionsFromProto(p1)
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("CountOptions", func(t *testing.T) {
+		f := func(f1 *CountOptions) bool {
+			p1 := f1.ToProto()
+			f2 := CountOptionsFromProto(p1)
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("CountResult", func(t *testing.T) {
+		f := func(f1 CountResult) bool {
+			p1 := f1.ToProto()
+			f2 := CountResultFromProto(p1)
+			return reflect.DeepEqual(&f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("DocumentResult", func(t *testing.T) {
+		f := func(f1 DocumentResult) bool {
+			p1 := f1.ToProto()
+			f2 := DocumentResultFromProto(p1)
+			return reflect.DeepEqual(&f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("LineRange", func(t *testing.T) {
+		f := func(f1 LineRange) bool {
+			p1 := f1.ToProto()
+			f2 := LineRangeFromProto(p1)
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ContentsResult", func(t *testing.T) {
+		f := func(f1 ContentsResult) bool {
+			p1 := f1.ToProto()
+			f2 := ContentsResultFromProto(p1)
+			return reflect.DeepEqual(&f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ShardFile", func(t *testing.T) {
+		f := func(name string, size int64, secs int32) bool {
+			f1 := ShardFile{Name: name, Size: size, ModTime: time.Unix(int64(secs), 0).UTC()}
+			f2 := ShardFileFromProto(f1.ToProto())
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("FlushReson", func(t *testing.T) {
 		f := func(f1 FlushReason) bool {
 			p1 := f1.ToProto()
@@ -145,9 +234,9 @@ func TestProtoRoundtrip(t *testing.T) {
 				}
 
 				p1 := f1.ToProto()
-				f2 := SearchResultFromProto(p1, repoURLs, lineFragments)
+				f2, err := SearchResultFromProto(p1, repoURLs, lineFragments)
 
-				return reflect.DeepEqual(f1, f2)
+				return err == nil && reflect.DeepEqual(f1, f2)
 			}
 			if err := quick.Check(f, nil); err != nil {
 				t.Fatal(err)
@@ -165,9 +254,9 @@ func TestProtoRoundtrip(t *testing.T) {
 				}
 
 				p1 := f1.ToStreamProto()
-				f2 := SearchResultFromStreamProto(p1, repoURLs, lineFragments)
+				f2, err := SearchResultFromStreamProto(p1, repoURLs, lineFragments)
 
-				return reflect.DeepEqual(f1, f2)
+				return err == nil && reflect.DeepEqual(f1, f2)
 			}
 			if err := quick.Check(f, nil); err != nil {
 				t.Fatal(err)
@@ -332,14 +421,33 @@ func TestProtoRoundtrip(t *testing.T) {
 		}
 	})
 
+	t.Run("RepositoryMetadataUpdate", func(t *testing.T) {
+		f := func(f1 RepositoryMetadataUpdate) bool {
+			p1 := f1.ToProto("repo")
+			f2 := RepositoryMetadataUpdateFromProto(p1)
+			if diff := cmp.Diff(&f1, f2); diff != "" || p1.GetRepository() != "repo" {
+				fmt.Printf("got diff: %s", diff)
+				return false
+			}
+			return true
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("SearchOptions", func(t *testing.T) {
 		f := func(f1 *SearchOptions) bool {
 			if f1 != nil {
 				f1.SpanContext = nil
 			}
 			p1 := f1.ToProto()
-			f2 := SearchOptionsFromProto(p1)
-			if diff := cmp.Diff(f1, f2); diff != "" {
+			f2, err := SearchOptionsFromProto(p1)
+			if err != nil {
+				fmt.Printf("got error: %s", err)
+				return false
+			}
+			if diff := cmp.Diff(f1, f2, cmp.Comparer(func(a, b *roaring.Bitmap) bool { return a.Equals(b) })); diff != "" {
 				fmt.Printf("got diff: %s", diff)
 				return false
 			}
@@ -365,6 +473,24 @@ func (*IndexMetadata) Generate(r *rand.Rand, _ int) reflect.Value {
 	return reflect.ValueOf(&i)
 }
 
+func (*Provenance) Generate(r *rand.Rand, _ int) reflect.Value {
+	if r.Intn(2) == 0 {
+		return reflect.ValueOf((*Provenance)(nil))
+	}
+	var p Provenance
+	p.ShardFile = gen(p.ShardFile, r)
+	p.ShardID = gen(p.ShardID, r)
+	// FileMatch is compared with reflect.DeepEqual, so the time must be in
+	// the form of Timestamp.AsTime.
+	p.IndexTime = time.Unix(r.Int63n(1<<32), r.Int63n(1e9)).UTC()
+	p.ZoektVersion = gen(p.ZoektVersion, r)
+	p.IndexFormatVersion = gen(p.IndexFormatVersion, r)
+	p.IndexFeatureVersion = gen(p.IndexFeatureVersion, r)
+	p.Checksum = gen(p.Checksum, r)
+	p.ContentSHA256 = gen(p.ContentSHA256, r)
+	return reflect.ValueOf(&p)
+}
+
 func (*Repository) Generate(rng *rand.Rand, _ int) reflect.Value {
 	latestCommitDate := time.Now().Add(time.Duration(rng.Int63n(1000)) * time.Hour)
 	var r Repository
@@ -423,7 +549,7 @@ var (
 	}()
 
 	// The non-proto struct representation of the search result
-	exampleSearchResultGo = SearchResultFromProto(exampleSearchResultProto, nil, nil)
+	exampleSearchResultGo, _ = SearchResultFromProto(exampleSearchResultProto, nil, nil)
 )
 
 func BenchmarkGobRoundtrip(b *testing.B) {
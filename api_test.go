@@ -86,16 +86,16 @@ func benchmarkEncoding(data interface{}) func(*testing.B) {
 
 func TestSizeBytesSearchResult(t *testing.T) {
 	sr := SearchResult{
-		Stats:    Stats{},    // 129 bytes
-		Progress: Progress{}, // 16 bytes
-		Files: []FileMatch{{ // 24 bytes + 460 bytes
+		Stats:    Stats{},    // 225 bytes
+		Progress: Progress{}, // 40 bytes
+		Files: []FileMatch{{ // 24 bytes + 480 bytes
 			Score:       0,   // 8 bytes
 			Debug:       "",  // 16 bytes
 			FileName:    "",  // 16 bytes
 			Repository:  "",  // 16 bytes
 			Branches:    nil, // 24 bytes
 			LineMatches: nil, // 24 bytes
-			ChunkMatches: []ChunkMatch{{ // 24 bytes + 208 bytes (see TestSizeByteChunkMatches)
+			ChunkMatches: []ChunkMatch{{ // 24 bytes + 236 bytes (see TestSizeByteChunkMatches)
 				Content:      []byte("foo"),
 				ContentStart: Location{},
 				FileName:     false,
@@ -106,6 +106,7 @@ func TestSizeBytesSearchResult(t *testing.T) {
 			}},
 			RepositoryID:       0,   // 4 bytes
 			RepositoryPriority: 0,   // 8 bytes
+			IndexTimeUnix:      0,   // 8 bytes
 			Content:            nil, // 24 bytes
 			Checksum:           nil, // 24 bytes
 			Language:           "",  // 16 bytes
@@ -115,9 +116,10 @@ func TestSizeBytesSearchResult(t *testing.T) {
 		}},
 		RepoURLs:      nil, // 48 bytes
 		LineFragments: nil, // 48 bytes
+		ResultSet:     nil, // 8 bytes
 	}
 
-	var wantBytes uint64 = 725
+	var wantBytes uint64 = 969
 	if sr.SizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, sr.SizeBytes())
 	}
@@ -126,15 +128,15 @@ func TestSizeBytesSearchResult(t *testing.T) {
 func TestSizeBytesChunkMatches(t *testing.T) {
 	cm := ChunkMatch{
 		Content:      []byte("foo"), // 24 + 3 bytes
-		ContentStart: Location{},    // 12 bytes
+		ContentStart: Location{},    // 16 bytes
 		FileName:     false,         // 1 byte
-		Ranges:       []Range{{}},   // 24 bytes (slice header) + 24 bytes (content)
-		SymbolInfo:   []*Symbol{{}}, // 24 bytes (slice header) + 4 * 16 bytes (string header) + 8 bytes (pointer)
+		Ranges:       []Range{{}},   // 24 bytes (slice header) + 32 bytes (content)
+		SymbolInfo:   []*Symbol{{}}, // 24 bytes (slice header) + 5 * 16 bytes (string header) + 8 bytes (pointer)
 		Score:        0,             // 8 byte
 		DebugScore:   "",            // 16 bytes (string header)
 	}
 
-	var wantBytes uint64 = 208
+	var wantBytes uint64 = 236
 	if cm.sizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, cm.sizeBytes())
 	}
@@ -146,10 +148,10 @@ func TestMatchSize(t *testing.T) {
 		size int
 	}{{
 		v:    FileMatch{},
-		size: 256,
+		size: 304,
 	}, {
 		v:    ChunkMatch{},
-		size: 120,
+		size: 128,
 	}}
 	for _, c := range cases {
 		got := reflect.TypeOf(c.v).Size()
@@ -185,6 +187,12 @@ func TestSearchOptions_String(t *testing.T) {
 		case reflect.Map:
 			// Only map is SpanContext
 			f.Set(reflect.ValueOf(map[string]string{"key": "value"}))
+		case reflect.Ptr:
+			// Only pointer is Within
+			f.Set(reflect.ValueOf(&ResultSet{}))
+		case reflect.Slice:
+			// Only slice is Experiments
+			f.Set(reflect.ValueOf([]string{"flag"}))
 		default:
 			t.Fatalf("add support for %s field (%s)", f.Kind(), name)
 		}
@@ -232,6 +240,85 @@ func TestSearchOptions_String(t *testing.T) {
 	}
 }
 
+func TestSearchOptions_ApplyDisplayHint(t *testing.T) {
+	cases := []struct {
+		hint DisplayHint
+		want SearchOptions
+	}{{
+		hint: DisplayHint{},
+		want: SearchOptions{MaxDocDisplayCount: 25, MaxMatchDisplayCount: 83, NumContextLines: 1, MaxDisplayBytes: 1 << 20},
+	}, {
+		hint: DisplayHint{Lines: 20, MaxBytes: 1000},
+		want: SearchOptions{MaxDocDisplayCount: 25, MaxMatchDisplayCount: 100, MaxDisplayBytes: 1000},
+	}, {
+		hint: DisplayHint{Lines: 200},
+		want: SearchOptions{MaxDocDisplayCount: 45, MaxMatchDisplayCount: 142, NumContextLines: 3, MaxDisplayBytes: 1 << 20},
+	}, {
+		// Tiny viewports still get a few results.
+		hint: DisplayHint{Lines: 1},
+		want: SearchOptions{MaxDocDisplayCount: 12, MaxMatchDisplayCount: 50, MaxDisplayBytes: 1 << 20},
+	}}
+	for _, tc := range cases {
+		var got SearchOptions
+		got.ApplyDisplayHint(tc.hint)
+		if got.String() != tc.want.String() {
+			t.Errorf("%+v: got %s, want %s", tc.hint, got.String(), tc.want.String())
+		}
+	}
+}
+
+func TestStatsAddAtoms(t *testing.T) {
+	var s Stats
+	s.Add(Stats{Atoms: []AtomStats{
+		{Atom: `substr:"foo"`, Ngrams: map[string]int64{"foo": 10}, NgramMatches: 3},
+		{Atom: `substr:"bar"`, NgramMatches: 1},
+	}})
+	other := Stats{Atoms: []AtomStats{
+		{Atom: `substr:"foo"`, Ngrams: map[string]int64{"foo": 5, "Foo": 1}, NgramMatches: 2, FilesMatched: 1},
+	}}
+	s.Add(other)
+
+	want := []AtomStats{
+		{Atom: `substr:"bar"`, NgramMatches: 1},
+		{Atom: `substr:"foo"`, Ngrams: map[string]int64{"foo": 15, "Foo": 1}, NgramMatches: 5, FilesMatched: 1},
+	}
+	if !reflect.DeepEqual(s.Atoms, want) {
+		t.Errorf("got %+v, want %+v", s.Atoms, want)
+	}
+
+	// Add must not modify the stats it adds.
+	if other.Atoms[0].Ngrams["foo"] != 5 {
+		t.Errorf("Add modified its argument: %+v", other.Atoms)
+	}
+}
+
+func TestStatsAddExperiments(t *testing.T) {
+	var s Stats
+	s.Add(Stats{Experiments: []string{"bm25"}})
+	s.Add(Stats{Experiments: []string{"bm25", "chunk_matches"}})
+	s.Add(Stats{})
+	if want := []string{"bm25", "chunk_matches"}; !reflect.DeepEqual(s.Experiments, want) {
+		t.Errorf("got %v, want %v", s.Experiments, want)
+	}
+}
+
+func TestStatsCompleteness(t *testing.T) {
+	for _, tc := range []struct {
+		stats Stats
+		want  float64
+	}{
+		{stats: Stats{}, want: 1},
+		{stats: Stats{ShardsScanned: 3, ShardsSkippedFilter: 1}, want: 1},
+		{stats: Stats{ShardsScanned: 2, ShardsSkipped: 2}, want: 0.5},
+		{stats: Stats{ShardsScanned: 4, ShardsInterrupted: 1}, want: 0.75},
+		{stats: Stats{ShardsSkipped: 1}, want: 0},
+	} {
+		if got := tc.stats.Completeness(); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.stats, got, tc.want)
+		}
+	}
+}
+
 func TestRepositoryMergeMutable(t *testing.T) {
 	a := Repository{
 		ID:   0,
@@ -363,6 +450,39 @@ func TestRepositoryMergeMutable(t *testing.T) {
 	})
 }
 
+func TestRepositoryMetadataUpdateApply(t *testing.T) {
+	priority, archived := 5000.0, true
+	u := &RepositoryMetadataUpdate{Priority: &priority, Archived: &archived, Topics: []string{"a", "b"}}
+
+	r := &Repository{Name: "repo", RawConfig: map[string]string{"priority": "1"}}
+	if mutated, err := u.Apply(r); err != nil || !mutated {
+		t.Fatalf("got mutated=%t, err %v", mutated, err)
+	}
+	want := map[string]string{"priority": "5000", "archived": "1", "topics": "a,b"}
+	if !reflect.DeepEqual(r.RawConfig, want) {
+		t.Fatalf("got RawConfig %v, want %v", r.RawConfig, want)
+	}
+	if r.GetPriority() != 5000 || r.Rank != 32767 {
+		t.Fatalf("got priority %v and rank %d, want 5000 and 32767", r.GetPriority(), r.Rank)
+	}
+	if mutated, err := u.Apply(r); err != nil || mutated {
+		t.Fatalf("got mutated=%t, err %v applying the update again", mutated, err)
+	}
+
+	// The rank of repositories ranked by their latest commit doesn't change.
+	r = &Repository{Rank: 600, RawConfig: map[string]string{"latestCommitDate": "1"}}
+	if _, err := u.Apply(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Rank != 600 {
+		t.Fatalf("got rank %d, want 600", r.Rank)
+	}
+
+	if _, err := (&RepositoryMetadataUpdate{Topics: []string{}}).Apply(r); err != nil || r.RawConfig["topics"] != "" {
+		t.Fatalf("got topics %q, err %v after removing them", r.RawConfig["topics"], err)
+	}
+}
+
 func TestMonthsSince1970(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -35,6 +35,8 @@ func main() {
 	dest := flag.String("dest", "", "destination directory")
 	nameFlag := flag.String("name", "", "name of repository")
 	repoIDFlag := flag.Uint("repoid", 0, "id of repository")
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if *dest == "" {
@@ -68,7 +70,7 @@ func main() {
 		config["zoekt.repoid"] = strconv.FormatUint(uint64(repoID), 10)
 	}
 
-	destRepo, err := gitindex.CloneRepo(destDir, filepath.Base(name), u.String(), config)
+	destRepo, err := gitindex.CloneRepo(destDir, filepath.Base(name), u.String(), config, cloneOpts)
 	if err != nil {
 		log.Fatalf("CloneRepo: %v", err)
 	}
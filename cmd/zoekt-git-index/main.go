@@ -35,8 +35,13 @@ import (
 func run() int {
 	allowMissing := flag.Bool("allow_missing_branches", false, "allow missing branches.")
 	submodules := flag.Bool("submodules", true, "if set to false, do not recurse into submodules")
-	branchesStr := flag.String("branches", "HEAD", "git branches to index.")
+	branchesStr := flag.String("branches", "HEAD", "git branches to index. If unset, the branches in the zoekt.branches git config of a repository are indexed, if it has them.")
+	tagsStr := flag.String("tags", "", "comma separated git tags to index, eg. v1.0.0,v2.*. Queries select them with rev:.")
+	commitMessages := flag.Int("commit_messages", 0, "also index the messages of this many most recent commits of each branch. Queries search them with type:commit.")
 	branchPrefix := flag.String("prefix", "refs/heads/", "prefix for branch names")
+	includePaths := flag.String("include-path", "", "comma separated globs of the paths to index, eg. services/search/**,lib. If neither -include-path nor -exclude-path are set, the zoekt.include-paths and zoekt.exclude-paths git config of a repository are used.")
+	excludePaths := flag.String("exclude-path", "", "comma separated globs of the paths not to index, eg. **/testdata/**.")
+	lfsMaxSize := flag.Int64("lfs_max_size", 0, "if positive, index the Git LFS objects of up to this many bytes instead of their pointer files, fetching them if they aren't stored locally.")
 
 	incremental := flag.Bool("incremental", true, "only index changed repositories")
 	repoCacheDir := flag.String("repo_cache", "", "directory holding bare git repos, named by URL. "+
@@ -81,6 +86,28 @@ func run() int {
 		branches = strings.Split(*branchesStr, ",")
 	}
 
+	// Without -branches, repositories may list their branches in their git
+	// config.
+	branchesFromConfig := true
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "branches" {
+			branchesFromConfig = false
+		}
+	})
+
+	var tags []string
+	if *tagsStr != "" {
+		tags = strings.Split(*tagsStr, ",")
+	}
+
+	var include, exclude []string
+	if *includePaths != "" {
+		include = strings.Split(*includePaths, ",")
+	}
+	if *excludePaths != "" {
+		exclude = strings.Split(*excludePaths, ",")
+	}
+
 	gitRepos := map[string]string{}
 	for _, repoDir := range flag.Args() {
 		repoDir, err := filepath.Abs(repoDir)
@@ -129,8 +156,21 @@ func run() int {
 			AllowMissingBranch:                *allowMissing,
 			BuildOptions:                      *opts,
 			Branches:                          branches,
+			Tags:                              tags,
+			CommitMessages:                    *commitMessages,
 			RepoDir:                           dir,
 			DeltaShardNumberFallbackThreshold: *deltaShardNumberFallbackThreshold,
+			IncludePaths:                      include,
+			ExcludePaths:                      exclude,
+			LFSMaxSize:                        *lfsMaxSize,
+		}
+
+		if branchesFromConfig {
+			if b, err := gitindex.ConfiguredBranches(dir); err != nil {
+				log.Printf("ConfiguredBranches(%s): %v", dir, err)
+			} else if len(b) > 0 {
+				gitOpts.Branches = b
+			}
 		}
 
 		if _, err := gitindex.IndexGitRepo(gitOpts); err != nil {
@@ -24,6 +24,7 @@ import (
 	"runtime/pprof"
 	"strings"
 
+	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/cmd"
 	"github.com/sourcegraph/zoekt/index"
 	"go.uber.org/automaxprocs/maxprocs"
@@ -61,10 +62,14 @@ func (a *fileAggregator) add(path string, info os.FileInfo, err error) error {
 func main() {
 	cpuProfile := flag.String("cpu_profile", "", "write cpu profile to file")
 	ignoreDirs := flag.String("ignore_dirs", ".git,.hg,.svn", "comma separated list of directories to ignore.")
+	stream := flag.Bool("stream", false, "read the documents to index from stdin as JSON objects with the fields path, content (or content_base64), language and branches, ending with {\"end\": true}, instead of indexing PATHS.")
+	name := flag.String("name", "", "the repository name of the documents read with -stream.")
+	branches := flag.String("branches", "", "comma separated list of the branches the documents read with -stream can be on.")
 	flag.Parse()
 
-	if flag.NArg() == 0 {
+	if *stream == (flag.NArg() > 0) || *stream && *name == "" {
 		fmt.Fprintf(flag.CommandLine.Output(), "USAGE: %s [options] PATHS...\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [options] -stream -name NAME < DOCUMENTS\n", filepath.Base(os.Args[0]))
 		fmt.Fprintln(flag.CommandLine.Output(), "Options:")
 		flag.PrintDefaults()
 		os.Exit(1)
@@ -85,6 +90,20 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	if *stream {
+		opts.RepositoryDescription.Name = *name
+		opts.RepositoryDescription.Source = "stdin"
+		if *branches != "" {
+			for _, b := range strings.Split(*branches, ",") {
+				opts.RepositoryDescription.Branches = append(opts.RepositoryDescription.Branches, zoekt.RepositoryBranch{Name: strings.TrimSpace(b)})
+			}
+		}
+		if err := indexStream(os.Stdin, *opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ignoreDirMap := map[string]struct{}{}
 	if *ignoreDirs != "" {
 		dirs := strings.Split(*ignoreDirs, ",")
@@ -133,7 +152,12 @@ func indexArg(arg string, opts index.Options, ignore map[string]struct{}) error
 	}()
 
 	for f := range comm {
-		displayName := strings.TrimPrefix(f.name, dir+"/")
+		// Document names use slashes, also on Windows.
+		displayName, err := filepath.Rel(dir, f.name)
+		if err != nil {
+			return err
+		}
+		displayName = filepath.ToSlash(displayName)
 		if f.size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(displayName) {
 			if err := builder.Add(index.Document{
 				Name:       displayName,
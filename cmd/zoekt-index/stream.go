@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// streamDocument is a document sent to zoekt-index -stream. The documents are
+// sent as JSON objects, usually one per line:
+//
+//	{"path": "src/main.go", "content": "package main\n", "branches": ["main"]}
+//
+// Content which is not valid UTF-8 is sent base64 encoded in content_base64
+// instead of content. If language is empty, it is detected from the path and
+// content.
+//
+// The last object must be {"end": true}, so a driver which fails or is killed
+// halfway doesn't replace the index of the repository by a partial one.
+type streamDocument struct {
+	Path          string   `json:"path"`
+	Content       string   `json:"content"`
+	ContentBase64 string   `json:"content_base64"`
+	Language      string   `json:"language"`
+	Branches      []string `json:"branches"`
+	End           bool     `json:"end"`
+}
+
+func (d *streamDocument) content() ([]byte, error) {
+	if d.ContentBase64 == "" {
+		return []byte(d.Content), nil
+	}
+	if d.Content != "" {
+		return nil, errors.New("both content and content_base64 are set")
+	}
+	return base64.StdEncoding.DecodeString(d.ContentBase64)
+}
+
+// indexStream indexes the documents read from r into the repository
+// described by opts. A document is only read once the previous one was added
+// to the builder, which blocks while opts.Parallelism shards are being built.
+// So a driver writing to a pipe is slowed down to the pace of indexing
+// instead of zoekt-index buffering its documents.
+func indexStream(r io.Reader, opts index.Options) error {
+	builder, err := index.NewBuilder(opts)
+	if err != nil {
+		return err
+	}
+	// Unless the stream ended properly, we drop the shards built so far and
+	// keep the previous index of the repository.
+	defer builder.Abort()
+
+	// The shard builder only reports unknown branches once it builds the
+	// shard, so we check them here to point at the document.
+	known := map[string]bool{}
+	for _, b := range opts.RepositoryDescription.Branches {
+		known[b.Name] = true
+	}
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	for i := 0; ; i++ {
+		var d streamDocument
+		if err := dec.Decode(&d); errors.Is(err, io.EOF) {
+			return errors.New("the stream ended without an end object")
+		} else if err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		if d.End {
+			if d.Path != "" || d.Content != "" || d.ContentBase64 != "" || d.Language != "" || len(d.Branches) > 0 {
+				return fmt.Errorf("document %d: the end object has other fields", i)
+			}
+			if dec.More() {
+				return fmt.Errorf("document %d: the stream continues after the end object", i)
+			}
+			break
+		}
+		if d.Path == "" {
+			return fmt.Errorf("document %d: path is empty", i)
+		}
+
+		for _, b := range d.Branches {
+			if !known[b] {
+				return fmt.Errorf("document %d (%s): unknown branch %q", i, d.Path, b)
+			}
+		}
+
+		content, err := d.content()
+		if err != nil {
+			return fmt.Errorf("document %d (%s): %w", i, d.Path, err)
+		}
+
+		if err := builder.Add(index.Document{
+			Name:     d.Path,
+			Content:  content,
+			Branches: d.Branches,
+			Language: d.Language,
+		}); err != nil {
+			return fmt.Errorf("document %d (%s): %w", i, d.Path, err)
+		}
+	}
+
+	return builder.Finish()
+}
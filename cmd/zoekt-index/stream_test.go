@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestIndexStream(t *testing.T) {
+	opts := index.Options{
+		IndexDir: t.TempDir(),
+		RepositoryDescription: zoekt.Repository{
+			Name:     "repo",
+			Branches: []zoekt.RepositoryBranch{{Name: "main"}, {Name: "dev"}},
+		},
+	}
+	opts.SetDefaults()
+
+	input := `{"path": "main.go", "content": "package main\n", "branches": ["main", "dev"]}
+{"path": "README", "content": "hello world\n", "language": "Markdown", "branches": ["dev"]}
+{"path": "bin/data", "content_base64": "aGVsbG8gYmluYXJ5"}
+{"end": true}
+`
+	if err := indexStream(strings.NewReader(input), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(opts.IndexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "hello"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]zoekt.FileMatch{}
+	for _, f := range res.Files {
+		got[f.FileName] = f
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d files, want 2: %v", len(got), res.Files)
+	}
+	if f := got["README"]; f.Language != "Markdown" || len(f.Branches) != 1 || f.Branches[0] != "dev" {
+		t.Errorf("unexpected README match %+v", f)
+	}
+	if _, ok := got["bin/data"]; !ok {
+		t.Error("base64 content was not indexed")
+	}
+
+	readIndex := func() map[string]string {
+		t.Helper()
+		paths, err := filepath.Glob(filepath.Join(opts.IndexDir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{}
+		for _, p := range paths {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[filepath.Base(p)] = string(b)
+		}
+		return files
+	}
+	want := readIndex()
+	for _, bad := range []string{
+		`{"content": "no path"}`,
+		`{"path": "a", "branches": ["unknown"]}`,
+		`{"path": "a", "content": "x", "content_base64": "eA=="}`,
+		`{"path": "a", "unknown_field": 1}`,
+		`{"path": "a"`,
+		`{"path": "a", "content": "truncated"}`,
+		`{"path": "a", "end": true}`,
+		`{"end": true} {"path": "a"}`,
+	} {
+		if err := indexStream(strings.NewReader(bad), opts); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+		// A failed stream leaves the previous shards of the repository.
+		if got := readIndex(); !maps.Equal(got, want) {
+			t.Errorf("%s: the index changed after the failure", bad)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// indexCost is the estimated amount of resources a zoekt-git-index run for a
+// repository uses.
+type indexCost struct {
+	cpus   int
+	memory int64 // bytes
+}
+
+const (
+	// bytesPerShard is the amount of git data we expect to end up in one
+	// shard. zoekt-git-index builds shards in parallel, so it is also the
+	// amount of data we expect to keep one CPU busy.
+	bytesPerShard = 100 << 20
+
+	// memoryPerByte is the factor between the size of the data being indexed
+	// and the memory needed to build its shards.
+	memoryPerByte = 4
+
+	// baseMemory is the memory used by zoekt-git-index independently of the
+	// size of the repository.
+	baseMemory = 64 << 20
+)
+
+// estimateIndexCost estimates the cost of indexing a repository of size
+// bytes. The estimate is capped at limit, so a repository which exceeds the
+// budget can still be indexed on its own.
+func estimateIndexCost(size int64, limit indexCost) indexCost {
+	cpus := int((size + bytesPerShard - 1) / bytesPerShard)
+	cpus = max(1, min(limit.cpus, cpus))
+
+	// Only the shards built in parallel are kept in memory.
+	memory := baseMemory + memoryPerByte*min(size, int64(cpus)*bytesPerShard)
+	if limit.memory > 0 {
+		memory = min(limit.memory, memory)
+	}
+
+	return indexCost{cpus: cpus, memory: memory}
+}
+
+// repoSize returns the number of bytes used by the git repository in dir.
+func repoSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// indexBudget limits the resources used by concurrent index jobs. A memory
+// budget or maxJobs of 0 means there is no limit.
+type indexBudget struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	total   indexCost
+	maxJobs int
+	used    indexCost
+	jobs    int
+}
+
+func newIndexBudget(total indexCost, maxJobs int) *indexBudget {
+	b := &indexBudget{total: total, maxJobs: maxJobs}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until c fits in the budget and reserves it. The caller must
+// call release with the same cost once the job is done.
+func (b *indexBudget) acquire(c indexCost) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.fits(c) {
+		b.cond.Wait()
+	}
+	b.used.cpus += c.cpus
+	b.used.memory += c.memory
+	b.jobs++
+}
+
+// release returns c to the budget.
+func (b *indexBudget) release(c indexCost) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used.cpus -= c.cpus
+	b.used.memory -= c.memory
+	b.jobs--
+	b.cond.Broadcast()
+}
+
+// fits reports whether c can be started now. The first job always fits, so
+// jobs which exceed the budget on their own make progress.
+func (b *indexBudget) fits(c indexCost) bool {
+	if b.jobs == 0 {
+		return true
+	}
+	if b.maxJobs > 0 && b.jobs >= b.maxJobs {
+		return false
+	}
+	if b.used.cpus+c.cpus > b.total.cpus {
+		return false
+	}
+	return b.total.memory <= 0 || b.used.memory+c.memory <= b.total.memory
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateIndexCost(t *testing.T) {
+	limit := indexCost{cpus: 4, memory: 1 << 30}
+	for _, tc := range []struct {
+		size int64
+		want indexCost
+	}{
+		{size: 0, want: indexCost{cpus: 1, memory: baseMemory}},
+		{size: 1 << 20, want: indexCost{cpus: 1, memory: baseMemory + 4<<20}},
+		{size: 250 << 20, want: indexCost{cpus: 3, memory: 1 << 30}},
+		{size: 10 << 30, want: indexCost{cpus: 4, memory: 1 << 30}},
+	} {
+		if got := estimateIndexCost(tc.size, limit); got != tc.want {
+			t.Errorf("estimateIndexCost(%d): got %+v, want %+v", tc.size, got, tc.want)
+		}
+	}
+
+	// Without a memory limit the estimate is not capped.
+	if got, want := estimateIndexCost(250<<20, indexCost{cpus: 4}).memory, int64(baseMemory+4*250<<20); got != want {
+		t.Errorf("got memory %d, want %d", got, want)
+	}
+}
+
+func TestIndexBudget(t *testing.T) {
+	b := newIndexBudget(indexCost{cpus: 4, memory: 100}, 0)
+
+	small := indexCost{cpus: 1, memory: 10}
+	big := indexCost{cpus: 3, memory: 80}
+	b.acquire(big)
+	b.acquire(small)
+
+	// Neither the CPU nor the memory budget has room for another job.
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(small)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired beyond the budget")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	b.release(big)
+	<-acquired
+
+	b.release(small)
+	b.release(small)
+
+	// A job larger than the budget still runs on its own.
+	b.acquire(indexCost{cpus: 8, memory: 1000})
+	b.release(indexCost{cpus: 8, memory: 1000})
+
+	// maxJobs limits the number of jobs independently of their cost.
+	b = newIndexBudget(indexCost{cpus: 4}, 1)
+	b.acquire(small)
+	if b.fits(small) {
+		t.Fatal("second job fits with maxJobs 1")
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"old.zoekt.1.tmp", "new.zoekt.2.tmp", "repo.zoekt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.zoekt.1.tmp"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// The temp files of running jobs are younger than the index timeout.
+	removeTempFiles(dir, time.Hour)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got, want := strings.Join(names, " "), "new.zoekt.2.tmp repo.zoekt"; got != want {
+		t.Errorf("got files %s, want %s", got, want)
+	}
+}
+
+func TestRepoSize(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "objects", "pack"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"HEAD": 10, "objects/pack/p.pack": 100} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repoSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 110 {
+		t.Fatalf("got %d, want 110", got)
+	}
+}
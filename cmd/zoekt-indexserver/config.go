@@ -17,8 +17,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -53,6 +54,7 @@ type ConfigEntry struct {
 	GerritFetchMetaConfig  bool
 	GerritRepoNameFormat   string
 	ExcludeUserRepos       bool
+	RepoListURL            string
 }
 
 func randomize(entries []ConfigEntry) []ConfigEntry {
@@ -121,7 +123,7 @@ func watchFile(path string) (<-chan struct{}, error) {
 				}
 			case err := <-watcher.Errors:
 				if err != nil {
-					log.Printf("watcher error: %v", err)
+					slog.Error("watching mirror config", "err", err)
 				}
 			}
 		}
@@ -137,7 +139,7 @@ func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- strin
 		var err error
 		watcher, err = watchFile(opts.mirrorConfigFile)
 		if err != nil {
-			log.Printf("watchFile(%q): %v", opts.mirrorConfigFile, err)
+			slog.Error("watching mirror config", "mirror_config", opts.mirrorConfigFile, "err", err)
 		}
 	}
 
@@ -145,7 +147,7 @@ func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- strin
 	for {
 		cfg, err := readConfigURL(opts.mirrorConfigFile)
 		if err != nil {
-			log.Printf("readConfig(%s): %v", opts.mirrorConfigFile, err)
+			slog.Error("reading mirror config", "mirror_config", opts.mirrorConfigFile, "err", err)
 		} else {
 			lastCfg = cfg
 		}
@@ -154,7 +156,7 @@ func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- strin
 
 		select {
 		case <-watcher:
-			log.Printf("mirror config %s changed", opts.mirrorConfigFile)
+			slog.Info("mirror config changed", "mirror_config", opts.mirrorConfigFile)
 		case <-ticker.C:
 		}
 	}
@@ -275,12 +277,22 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 				cmd.Args = append(cmd.Args, "-repo-name-format", c.GerritRepoNameFormat)
 			}
 			cmd.Args = append(cmd.Args, c.GerritApiURL)
+		} else if c.RepoListURL != "" {
+			cmd = exec.Command("zoekt-mirror-list",
+				"-dest", repoDir, "-delete")
+			if c.Name != "" {
+				cmd.Args = append(cmd.Args, "-name", c.Name)
+			}
+			if c.Exclude != "" {
+				cmd.Args = append(cmd.Args, "-exclude", c.Exclude)
+			}
+			cmd.Args = append(cmd.Args, c.RepoListURL)
 		} else {
-			log.Printf("executeMirror: ignoring config, because it does not contain any valid repository definition: %v", c)
+			slog.Warn("ignoring mirror config entry without a valid repository definition", "entry", fmt.Sprintf("%+v", c))
 			continue
 		}
 
-		stdout, _ := loggedRun(cmd)
+		stdout, _, _ := loggedRun(cmd)
 
 		for _, fn := range bytes.Split(stdout, []byte{'\n'}) {
 			if len(fn) == 0 {
@@ -21,13 +21,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sourcegraph/zoekt/index"
@@ -36,19 +38,25 @@ import (
 
 const day = time.Hour * 24
 
-func loggedRun(cmd *exec.Cmd) (out, err []byte) {
+func loggedRun(cmd *exec.Cmd) (out, errOut []byte, err error) {
 	outBuf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
 	cmd.Stdout = outBuf
 	cmd.Stderr = errBuf
 
-	log.Printf("run %v", cmd.Args)
-	if err := cmd.Run(); err != nil {
-		log.Printf("command %s failed: %v\nOUT: %s\nERR: %s",
-			cmd.Args, err, outBuf.String(), errBuf.String())
+	slog.Info("run", "args", cmd.Args)
+	if err = cmd.Run(); err != nil {
+		slog.Error("command failed", "args", cmd.Args, "err", err,
+			"stdout", outBuf.String(), "stderr", errBuf.String())
 	}
 
-	return outBuf.Bytes(), errBuf.Bytes()
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
+
+// fatal logs msg with args as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 type Options struct {
@@ -61,11 +69,15 @@ type Options struct {
 	mirrorConfigFile string
 	maxLogAge        time.Duration
 	indexTimeout     time.Duration
+	indexMemoryMB    int64
+	indexConcurrency int
+	reportsDir       string
+	listen           string
 }
 
 func (o *Options) validate() {
 	if o.cpuFraction <= 0.0 || o.cpuFraction > 1.0 {
-		log.Fatal("cpu_fraction must be between 0.0 and 1.0")
+		fatal("cpu_fraction must be between 0.0 and 1.0", "cpu_fraction", o.cpuFraction)
 	}
 
 	o.cpuCount = int(math.Trunc(float64(runtime.GOMAXPROCS(0)) * o.cpuFraction))
@@ -87,7 +99,13 @@ func (o *Options) defineFlags() {
 	flag.DurationVar(&o.mirrorInterval, "mirror_duration", 24*time.Hour, "find and clone new repos at this frequency.")
 	flag.Float64Var(&o.cpuFraction, "cpu_fraction", 0.25,
 		"use this fraction of the cores for indexing.")
+	flag.Int64Var(&o.indexMemoryMB, "index_memory_mb", 0,
+		"limit the estimated memory of concurrent index jobs to this many MB. 0 means no limit.")
+	flag.IntVar(&o.indexConcurrency, "index_concurrency", 0,
+		"index at most this many repositories concurrently. 0 means the number is only limited by -cpu_fraction and -index_memory_mb.")
 	flag.StringVar(&o.indexFlagsStr, "git_index_flags", "", "space separated list of flags passed through to zoekt-git-index (e.g. -git_index_flags='-symbols=false -submodules=false'")
+	flag.StringVar(&o.reportsDir, "reports_dir", "", "directory holding the index report of every repository. Defaults to $data_dir/reports/")
+	flag.StringVar(&o.listen, "listen", "", "if set, serve the admin API with the index reports on this address, eg. :6072.")
 }
 
 // periodicFetch runs git-fetch every once in a while. Results are
@@ -97,11 +115,11 @@ func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<-
 	for {
 		repos, err := gitindex.FindGitRepos(repoDir)
 		if err != nil {
-			log.Println(err)
+			slog.Error("finding git repositories", "repo_dir", repoDir, "err", err)
 			continue
 		}
 		if len(repos) == 0 {
-			log.Printf("no repos found under %s", repoDir)
+			slog.Warn("no repositories found", "repo_dir", repoDir)
 		}
 
 		// TODO: Randomize to make sure quota throttling hits everyone.
@@ -126,12 +144,11 @@ func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<-
 // fetchGitRepo runs git-fetch, and returns true if there was an
 // update.
 func fetchGitRepo(dir string) bool {
-	cmd := exec.Command("git", "--git-dir", dir, "fetch", "origin", "--prune")
+	cmd := exec.Command("git", gitindex.FetchArgs(dir)...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("command %s failed: %v\nCOMBINED_OUT: %s\n",
-			cmd.Args, err, string(output))
+		slog.Error("fetch failed", "repo", dir, "args", cmd.Args, "err", err, "output", string(output))
 		return false
 	}
 	// When fetch found no updates, it prints nothing out
@@ -139,44 +156,158 @@ func fetchGitRepo(dir string) bool {
 }
 
 // indexPendingRepos consumes the directories on the repos channel and
-// indexes them, sequentially.
+// indexes them. Repositories are indexed concurrently as long as their
+// estimated cost fits in the CPU and memory budget of opts.
 func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan string) {
+	limit := indexCost{cpus: opts.cpuCount, memory: opts.indexMemoryMB << 20}
+	budget := newIndexBudget(limit, opts.indexConcurrency)
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+
 	for dir := range repos {
-		indexPendingRepo(dir, indexDir, repoDir, opts)
-
-		// Failures (eg. timeout) will leave temp files
-		// around. We have to clean them, or they will fill up the indexing volume.
-		if failures, err := filepath.Glob(filepath.Join(indexDir, "*.tmp")); err != nil {
-			log.Printf("Glob: %v", err)
-		} else {
-			for _, f := range failures {
-				os.Remove(f)
-			}
+		mu.Lock()
+		isRunning := running[dir]
+		mu.Unlock()
+		if isRunning {
+			// The next fetch will pick up changes we miss now.
+			slog.Info("skipping repository, still indexing", "repo", dir)
+			continue
+		}
+
+		size, err := repoSize(dir)
+		if err != nil {
+			slog.Warn("estimating repository size", "repo", dir, "err", err)
+		}
+		cost := estimateIndexCost(size, limit)
+
+		// Blocking here keeps the order of repos, so big repositories are
+		// not starved by small ones.
+		budget.acquire(cost)
+
+		mu.Lock()
+		running[dir] = true
+		mu.Unlock()
+
+		go func() {
+			indexPendingRepo(dir, indexDir, repoDir, opts, cost.cpus)
+
+			mu.Lock()
+			delete(running, dir)
+			mu.Unlock()
+
+			budget.release(cost)
+		}()
+	}
+}
+
+// removeTempFiles removes the temp files in indexDir which were last
+// modified more than maxAge ago. Failures (eg. timeout) leave temp files
+// around, which would fill up the indexing volume.
+func removeTempFiles(indexDir string, maxAge time.Duration) {
+	failures, err := filepath.Glob(filepath.Join(indexDir, "*.tmp"))
+	if err != nil {
+		slog.Error("finding temp files", "index_dir", indexDir, "err", err)
+		return
+	}
+	threshold := time.Now().Add(-maxAge)
+	for _, f := range failures {
+		if fi, err := os.Lstat(f); err == nil && fi.ModTime().Before(threshold) {
+			os.Remove(f)
 		}
 	}
 }
 
-func indexPendingRepo(dir, indexDir, repoDir string, opts *Options) {
+// removeTempFilesLoop removes the temp files of failed index jobs while
+// other jobs run. Jobs are killed after indexTimeout, so only temp files
+// older than that can belong to a failed one.
+func removeTempFilesLoop(indexDir string, indexTimeout time.Duration) {
+	tick := time.NewTicker(indexTimeout / 10)
+	for range tick.C {
+		removeTempFiles(indexDir, indexTimeout)
+	}
+}
+
+func indexPendingRepo(dir, indexDir, repoDir string, opts *Options, parallelism int) {
 	ctx, cancel := context.WithTimeout(context.Background(), opts.indexTimeout)
 	defer cancel()
 	args := []string{
 		"-require_ctags",
-		fmt.Sprintf("-parallelism=%d", opts.cpuCount),
+		fmt.Sprintf("-parallelism=%d", parallelism),
 		"-repo_cache", repoDir,
 		"-index", indexDir,
 		"-incremental",
 	}
+	reports := reportStore{dir: opts.reportsDir}
+	name := repoName(repoDir, dir)
+	buildReport := reports.buildReportPath(name)
+	if opts.reportsDir != "" {
+		args = append(args, "-report_file", buildReport)
+	}
 	args = append(args, opts.indexFlags...)
 	args = append(args, dir)
 	cmd := exec.CommandContext(ctx, "zoekt-git-index", args...)
-	loggedRun(cmd)
+
+	start := time.Now()
+	_, errOut, err := loggedRun(cmd)
+	report := &indexReport{
+		Repo:     name,
+		Status:   statusIndexed,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	if opts.reportsDir != "" {
+		report.Build, _ = index.ReadBuildReport(buildReport)
+		os.Remove(buildReport)
+	}
+
+	switch {
+	case err != nil:
+		report.Status = statusFailed
+		report.Error = err.Error()
+		if line := lastLine(errOut); line != "" {
+			report.Error += ": " + line
+		}
+	case report.Build == nil:
+		// Incremental runs which find nothing to do don't build.
+		report.Status = statusUpToDate
+		if last, err := reports.read(name); err == nil {
+			report.Build = last.Build
+		}
+	case report.Build.Error != "":
+		report.Status = statusFailed
+		report.Error = report.Build.Error
+	}
+
+	attrs := []any{"repo", name, "status", report.Status, "duration", report.Duration}
+	if b := report.Build; b != nil && report.Status != statusUpToDate {
+		attrs = append(attrs, "documents", b.Documents, "skipped", b.Skipped,
+			"shards", len(b.Shards), "ctags_failures", len(b.CTagsFailures))
+	}
+	if report.Status == statusFailed {
+		slog.Error("index failed", append(attrs, "err", report.Error)...)
+	} else {
+		slog.Info("index finished", attrs...)
+	}
+
+	if opts.reportsDir != "" {
+		if err := reports.write(report); err != nil {
+			slog.Error("writing index report", "repo", name, "err", err)
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of out.
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
 }
 
 // deleteLogs deletes old logs.
 func deleteLogs(logDir string, maxAge time.Duration) {
 	fs, err := filepath.Glob(filepath.Join(logDir, "*"))
 	if err != nil {
-		log.Fatalf("filepath.Glob(%s): %v", logDir, err)
+		fatal("finding logs", "log_dir", logDir, "err", err)
 	}
 
 	threshold := time.Now().Add(-maxAge)
@@ -222,7 +353,7 @@ func deleteIfOrphan(repoDir string, fn string) error {
 
 	_, err = os.Stat(repo.Source)
 	if os.IsNotExist(err) {
-		log.Printf("deleting orphan shard %s; source %q not found", fn, repo.Source)
+		slog.Info("deleting orphan shard", "shard", fn, "source", repo.Source)
 		return os.Remove(fn)
 	}
 
@@ -236,12 +367,12 @@ func deleteOrphanIndexes(indexDir, repoDir string, watchInterval time.Duration)
 	for {
 		fs, err := filepath.Glob(expr)
 		if err != nil {
-			log.Printf("Glob(%q): %v", expr, err)
+			slog.Error("finding shards", "pattern", expr, "err", err)
 		}
 
 		for _, f := range fs {
 			if err := deleteIfOrphan(repoDir, f); err != nil {
-				log.Printf("deleteIfOrphan(%q): %v", f, err)
+				slog.Error("deleting orphan shard", "shard", f, "err", err)
 			}
 		}
 		<-t.C
@@ -255,10 +386,12 @@ func main() {
 		filepath.Join(os.Getenv("HOME"), "zoekt-serving"), "directory holding all data.")
 	indexDir := flag.String("index_dir", "", "directory holding index shards. Defaults to $data_dir/index/")
 	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
 	opts.validate()
 
 	if *dataDir == "" {
-		log.Fatal("must set --data_dir")
+		fatal("must set --data_dir")
 	}
 
 	// Automatically prepend our own path at the front, to minimize
@@ -271,26 +404,42 @@ func main() {
 	if *indexDir == "" {
 		*indexDir = filepath.Join(*dataDir, "index")
 	}
+	if opts.reportsDir == "" {
+		opts.reportsDir = filepath.Join(*dataDir, "reports")
+	}
 	repoDir := filepath.Join(*dataDir, "repos")
-	for _, s := range []string{logDir, *indexDir, repoDir} {
+	for _, s := range []string{logDir, *indexDir, repoDir, opts.reportsDir} {
 		if _, err := os.Stat(s); err == nil {
 			continue
 		}
 
 		if err := os.MkdirAll(s, 0o755); err != nil {
-			log.Fatalf("MkdirAll %s: %v", s, err)
+			fatal("creating directory", "dir", s, "err", err)
 		}
 	}
 
 	_, err := readConfigURL(opts.mirrorConfigFile)
 	if err != nil {
-		log.Fatalf("readConfigURL(%s): %v", opts.mirrorConfigFile, err)
+		fatal("reading mirror config", "mirror_config", opts.mirrorConfigFile, "err", err)
+	}
+
+	if opts.listen != "" {
+		go func() {
+			slog.Info("serving admin API", "listen", opts.listen)
+			err := http.ListenAndServe(opts.listen, newAdminMux(reportStore{dir: opts.reportsDir}))
+			fatal("admin API", "listen", opts.listen, "err", err)
+		}()
 	}
 
 	pendingRepos := make(chan string, 10)
 	go periodicMirrorFile(repoDir, &opts, pendingRepos)
 	go deleteLogsLoop(logDir, opts.maxLogAge)
+	// Reports of existing repositories are rewritten on every fetch.
+	go deleteLogsLoop(opts.reportsDir, opts.maxLogAge)
 	go deleteOrphanIndexes(*indexDir, repoDir, opts.fetchInterval)
+	// No job is running yet, so all temp files are left from before.
+	removeTempFiles(*indexDir, 0)
+	go removeTempFilesLoop(*indexDir, opts.indexTimeout)
 	go indexPendingRepos(*indexDir, repoDir, &opts, pendingRepos)
 	periodicFetch(repoDir, *indexDir, &opts, pendingRepos)
 }
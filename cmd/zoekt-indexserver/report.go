@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// The outcomes of an index run.
+const (
+	statusIndexed  = "indexed"
+	statusUpToDate = "up to date"
+	statusFailed   = "failed"
+)
+
+// indexReport is the report of the last index run of a repository. Reports
+// are written to the reports directory, and served by the admin API.
+type indexReport struct {
+	// Repo is the directory of the repository relative to the repos
+	// directory, without the ".git" suffix.
+	Repo string
+
+	Status   string
+	Start    time.Time
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+
+	// Build is the report of zoekt-git-index. Runs which find the repository
+	// up to date keep the report of the last build.
+	Build *index.BuildReport `json:",omitempty"`
+}
+
+// repoName returns the name of the repository in dir for reports.
+func repoName(repoDir, dir string) string {
+	rel, err := filepath.Rel(repoDir, dir)
+	if err != nil {
+		rel = dir
+	}
+	return strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+}
+
+// reportStore holds one report per repository in dir.
+type reportStore struct {
+	dir string
+}
+
+func (s reportStore) path(repo string) string {
+	return filepath.Join(s.dir, url.QueryEscape(repo)+".json")
+}
+
+// buildReportPath is the path zoekt-git-index writes its report to.
+func (s reportStore) buildReportPath(repo string) string {
+	return filepath.Join(s.dir, url.QueryEscape(repo)+".build")
+}
+
+func (s reportStore) read(repo string) (*indexReport, error) {
+	return readReport(s.path(repo))
+}
+
+func readReport(path string) (*indexReport, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r indexReport
+	if err := json.Unmarshal(blob, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s reportStore) write(r *indexReport) error {
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, "report.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(blob); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(r.Repo))
+}
+
+// list returns the reports of all repositories, sorted by name.
+func (s reportStore) list() ([]*indexReport, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var reports []*indexReport
+	for _, p := range paths {
+		r, err := readReport(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted since we listed it.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b *indexReport) int { return strings.Compare(a.Repo, b.Repo) })
+	return reports, nil
+}
+
+// newAdminMux returns the handler of the admin API:
+//
+//	GET /reports[?status=STATUS]  the reports of all repositories, without their skipped files
+//	GET /reports/REPO              the full report of REPO, eg. github.com/foo/bar
+func newAdminMux(reports reportStore) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reports", func(w http.ResponseWriter, r *http.Request) {
+		all, err := reports.list()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		list := []*indexReport{}
+		for _, rep := range all {
+			if status != "" && rep.Status != status {
+				continue
+			}
+			if rep.Build != nil {
+				build := *rep.Build
+				build.SkippedDocuments = nil
+				rep.Build = &build
+			}
+			list = append(list, rep)
+		}
+		writeJSON(w, list)
+	})
+	mux.HandleFunc("GET /reports/{repo...}", func(w http.ResponseWriter, r *http.Request) {
+		rep, err := reports.read(r.PathValue("repo"))
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "no report for "+r.PathValue("repo"), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, rep)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
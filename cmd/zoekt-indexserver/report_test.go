@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestRepoName(t *testing.T) {
+	if got := repoName("/data/repos", "/data/repos/github.com/foo/bar.git"); got != "github.com/foo/bar" {
+		t.Errorf("got %q, want github.com/foo/bar", got)
+	}
+}
+
+func TestAdminReports(t *testing.T) {
+	reports := reportStore{dir: t.TempDir()}
+	for _, r := range []*indexReport{{
+		Repo:   "github.com/foo/bar",
+		Status: statusIndexed,
+		Build: &index.BuildReport{
+			Documents:        2,
+			Skipped:          1,
+			SkippedDocuments: []index.SkippedDocument{{Name: "blob.bin", Reason: "binary"}},
+		},
+	}, {
+		Repo:   "github.com/foo/baz",
+		Status: statusFailed,
+		Error:  "exit status 1",
+	}} {
+		if err := reports.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := newAdminMux(reports)
+	get := func(path string, v any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code
+	}
+
+	var list []*indexReport
+	if code := get("/reports", &list); code != http.StatusOK || len(list) != 2 {
+		t.Fatalf("got %d with %d reports, want 2", code, len(list))
+	}
+	if list[0].Repo != "github.com/foo/bar" || list[0].Build.Skipped != 1 || list[0].Build.SkippedDocuments != nil {
+		t.Errorf("got %+v, want the skipped count without the skipped files", list[0].Build)
+	}
+
+	list = nil
+	if get("/reports?status=failed", &list); len(list) != 1 || list[0].Repo != "github.com/foo/baz" {
+		t.Errorf("got %+v, want the failed report", list)
+	}
+
+	var rep indexReport
+	if code := get("/reports/github.com/foo/bar", &rep); code != http.StatusOK || len(rep.Build.SkippedDocuments) != 1 {
+		t.Errorf("got %d, %+v, want the full report", code, rep.Build)
+	}
+	if code := get("/reports/github.com/foo/missing", &rep); code != http.StatusNotFound {
+		t.Errorf("got %d for a missing report, want 404", code)
+	}
+}
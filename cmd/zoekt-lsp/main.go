@@ -0,0 +1,51 @@
+// Command zoekt-lsp is a language server which answers workspace/symbol and
+// textDocument/references requests of editors with searches of a shard
+// directory. It speaks JSON-RPC over stdio, so editors get project-wide
+// search without running a webserver.
+//
+// Results are located in the directory of the repository that was indexed,
+// if it has the file, and otherwise relative to the root of the workspace
+// of the editor.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+)
+
+func main() {
+	indexDir := flag.String("index", index.DefaultDir, "search the shards in this `directory`")
+	maxResults := flag.Int("max_results", 200, "maximum number of symbols or references returned")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option]\n\n"+
+			"Serves the language server protocol on stdin and stdout.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// stdout is the protocol stream.
+	log.SetOutput(os.Stderr)
+
+	searcher, err := shards.NewDirectorySearcher(*indexDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &server{searcher: searcher, maxResults: *maxResults}
+	err = s.serve(os.Stdin, os.Stdout)
+	searcher.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !s.shutdown {
+		// The protocol asks for exit code 1 if the client exits without
+		// shutting down first.
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+
+	// codeRequestCancelled is the error of requests canceled with
+	// $/cancelRequest.
+	codeRequestCancelled = -32800
+)
+
+// message is a JSON-RPC request, notification or response. Notifications
+// have no ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// readMessage reads a message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	var m message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return &m, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return &m, nil
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+func writeMessage(w io.Writer, m *message) error {
+	m.JSONRPC = "2.0"
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// server answers the requests of one client.
+type server struct {
+	searcher   zoekt.Searcher
+	maxResults int
+
+	// rootDir is the root of the workspace of the client.
+	rootDir string
+
+	// shutdown is set once the client asked the server to shut down.
+	shutdown bool
+}
+
+// serve handles the messages of r until the client exits. The requests are
+// handled in order, while the messages after them are read, so that
+// $/cancelRequest can cancel a request which is queued or running.
+func (s *server) serve(r io.Reader, w io.Writer) error {
+	q := newRequestQueue()
+	handled := make(chan error, 1)
+	go func() {
+		handled <- s.handleRequests(q, w)
+	}()
+
+	err := s.readRequests(r, q)
+	q.close()
+	if herr := <-handled; err == nil {
+		err = herr
+	}
+	return err
+}
+
+// readRequests queues the messages of r until the client exits, and cancels
+// the requests named by $/cancelRequest.
+func (s *server) readRequests(r io.Reader, q *requestQueue) error {
+	br := bufio.NewReader(r)
+	for {
+		m, err := readMessage(br)
+		var rerr *responseError
+		if errors.As(err, &rerr) {
+			q.push(m, rerr)
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch m.Method {
+		case "exit":
+			return nil
+		case "$/cancelRequest":
+			var p struct {
+				ID json.RawMessage `json:"id"`
+			}
+			if err := json.Unmarshal(m.Params, &p); err == nil {
+				q.cancel(p.ID)
+			}
+		default:
+			q.push(m, nil)
+		}
+	}
+}
+
+// handleRequests handles the requests of q in order, until q is closed and
+// empty.
+func (s *server) handleRequests(q *requestQueue, w io.Writer) error {
+	for {
+		req, ok := q.pop()
+		if !ok {
+			return nil
+		}
+		m := req.m
+		if req.err != nil {
+			if err := writeMessage(w, &message{ID: m.ID, Error: req.err}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err := s.handle(req.ctx, m.Method, m.Params)
+		canceled := req.ctx.Err() != nil
+		q.done(m)
+		if m.ID == nil {
+			// Notifications, eg. initialized, have no response.
+			continue
+		}
+		resp := &message{ID: m.ID, Result: result}
+		var rerr *responseError
+		if err != nil && canceled {
+			resp.Error = &responseError{Code: codeRequestCancelled, Message: "request canceled"}
+		} else if errors.As(err, &rerr) {
+			resp.Error = rerr
+		} else if err != nil {
+			resp.Error = &responseError{Code: codeInternalError, Message: err.Error()}
+		} else if result == nil {
+			// The result of a successful request must be present.
+			resp.Result = json.RawMessage("null")
+		}
+		if err := writeMessage(w, resp); err != nil {
+			return err
+		}
+	}
+}
+
+// request is a message to handle, with the context it is handled in. If err
+// is set, it is the response instead.
+type request struct {
+	m   *message
+	ctx context.Context
+	err *responseError
+}
+
+// requestQueue holds the requests which are read but not handled yet. The
+// context of a request is canceled by cancel until it was handled.
+type requestQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	reqs   []request
+	closed bool
+
+	// cancels are the cancel functions of the requests which are queued or
+	// running, by ID.
+	cancels map[string]context.CancelFunc
+}
+
+func newRequestQueue() *requestQueue {
+	q := &requestQueue{cancels: map[string]context.CancelFunc{}}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// requestKey returns the key of a request ID in cancels.
+func requestKey(id json.RawMessage) string {
+	return string(bytes.TrimSpace(id))
+}
+
+func (q *requestQueue) push(m *message, err *responseError) {
+	ctx := context.Background()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if m.ID != nil && err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		q.cancels[requestKey(*m.ID)] = cancel
+	}
+	q.reqs = append(q.reqs, request{m: m, ctx: ctx, err: err})
+	q.cond.Signal()
+}
+
+// pop returns the next request. It blocks until there is one, and returns
+// false once q is closed and empty.
+func (q *requestQueue) pop() (request, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.reqs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.reqs) == 0 {
+		return request{}, false
+	}
+	req := q.reqs[0]
+	q.reqs = q.reqs[1:]
+	return req, true
+}
+
+// cancel cancels the request with the ID id, unless it was handled.
+func (q *requestQueue) cancel(id json.RawMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.cancels[requestKey(id)]; ok {
+		cancel()
+	}
+}
+
+// done releases the context of the request m once it was handled.
+func (q *requestQueue) done(m *message) {
+	if m.ID == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := requestKey(*m.ID)
+	if cancel, ok := q.cancels[key]; ok {
+		cancel()
+		delete(q.cancels, key)
+	}
+}
+
+// close makes pop return false once the queued requests are handled.
+func (q *requestQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (s *server) handle(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "initialize":
+		var p struct {
+			RootURI string `json:"rootUri"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.rootDir = uriPath(p.RootURI)
+		return map[string]any{
+			"capabilities": map[string]any{
+				"workspaceSymbolProvider": true,
+				"referencesProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "zoekt-lsp"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "workspace/symbol":
+		var p struct {
+			Query string `json:"query"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.symbols(ctx, p.Query)
+	case "textDocument/references":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Position position `json:"position"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.references(ctx, p.TextDocument.URI, p.Position)
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + method}
+}
+
+func unmarshalParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// position is a position in a document. Character counts UTF-16 code units,
+// as the protocol defaults to.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type symbolInformation struct {
+	Name          string   `json:"name"`
+	Kind          int      `json:"kind"`
+	Location      location `json:"location"`
+	ContainerName string   `json:"containerName,omitempty"`
+}
+
+// symbols returns the symbols whose names contain q.
+func (s *server) symbols(ctx context.Context, q string) ([]symbolInformation, error) {
+	syms := []symbolInformation{}
+	if q == "" {
+		return syms, nil
+	}
+
+	// The match is expanded to the symbol, as the whole symbol is located.
+	re, err := syntax.Parse(`\S*`+regexp.QuoteMeta(q)+`\S*`, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.search(ctx, &query.Symbol{Expr: &query.Regexp{Regexp: re}})
+	if err != nil {
+		return nil, err
+	}
+	sources := s.repoSources(ctx, files)
+	for _, f := range files {
+		for _, cm := range f.ChunkMatches {
+			for i, r := range cm.Ranges {
+				if len(syms) == s.maxResults {
+					return syms, nil
+				}
+				sym := symbolInformation{
+					Name:     string(chunkBytes(&cm, r)),
+					Kind:     symbolKindVariable,
+					Location: location{URI: s.fileURI(sources, f.Repository, f.FileName), Range: chunkRange(&cm, r)},
+				}
+				if i < len(cm.SymbolInfo) && cm.SymbolInfo[i] != nil {
+					info := cm.SymbolInfo[i]
+					sym.Name = info.Sym
+					sym.Kind = symbolKind(info.Kind)
+					sym.ContainerName = info.Parent
+				}
+				syms = append(syms, sym)
+			}
+		}
+	}
+	return syms, nil
+}
+
+// references returns the occurrences of the identifier at pos in the
+// document uri.
+func (s *server) references(ctx context.Context, uri string, pos position) ([]location, error) {
+	locs := []location{}
+	content, err := os.ReadFile(uriPath(uri))
+	if err != nil {
+		return nil, err
+	}
+	word := identifierAt(content, pos)
+	if word == "" {
+		return locs, nil
+	}
+
+	re, err := syntax.Parse(`\b`+regexp.QuoteMeta(word)+`\b`, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.search(ctx, &query.Regexp{Regexp: re, Content: true, CaseSensitive: true})
+	if err != nil {
+		return nil, err
+	}
+	sources := s.repoSources(ctx, files)
+	for _, f := range files {
+		uri := s.fileURI(sources, f.Repository, f.FileName)
+		var fileLocs []location
+		for _, cm := range f.ChunkMatches {
+			for _, r := range cm.Ranges {
+				fileLocs = append(fileLocs, location{URI: uri, Range: chunkRange(&cm, r)})
+			}
+		}
+		// Chunks and their ranges aren't ordered by offset, but editors
+		// list the references of a file in order.
+		slices.SortFunc(fileLocs, func(a, b location) int {
+			return cmp.Or(cmp.Compare(a.Range.Start.Line, b.Range.Start.Line), cmp.Compare(a.Range.Start.Character, b.Range.Start.Character))
+		})
+		for _, l := range fileLocs {
+			if len(locs) == s.maxResults {
+				return locs, nil
+			}
+			locs = append(locs, l)
+		}
+	}
+	return locs, nil
+}
+
+func (s *server) search(ctx context.Context, q query.Q) ([]zoekt.FileMatch, error) {
+	res, err := s.searcher.Search(ctx, q, &zoekt.SearchOptions{
+		ChunkMatches:         true,
+		MaxMatchDisplayCount: s.maxResults,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Files, nil
+}
+
+// fileURI returns the URI of a file of a repository. Repositories are
+// indexed from their working copy, whose directory is their Source in
+// sources, or the file is looked up in the workspace.
+func (s *server) fileURI(sources map[string]string, repo, fileName string) string {
+	p := filepath.Join(s.rootDir, fileName)
+	if dir := sources[repo]; dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, fileName)); err == nil {
+			p = filepath.Join(dir, fileName)
+		}
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String()
+}
+
+// repoSources returns the directories the repositories of files were indexed
+// from, by name. They are listed at once, rather than once per file.
+func (s *server) repoSources(ctx context.Context, files []zoekt.FileMatch) map[string]string {
+	sources := map[string]string{}
+	var repos []string
+	for _, f := range files {
+		if _, ok := sources[f.Repository]; !ok {
+			sources[f.Repository] = ""
+			repos = append(repos, f.Repository)
+		}
+	}
+	if len(repos) == 0 {
+		return sources
+	}
+	rl, err := s.searcher.List(ctx, query.NewRepoSet(repos...), nil)
+	if err != nil {
+		return sources
+	}
+	for _, r := range rl.Repos {
+		sources[r.Repository.Name] = r.Repository.Source
+	}
+	return sources
+}
+
+// uriPath returns the path of a file URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// chunkBytes returns the content of the range r of the chunk cm.
+func chunkBytes(cm *zoekt.ChunkMatch, r zoekt.Range) []byte {
+	start := int(r.Start.ByteOffset - cm.ContentStart.ByteOffset)
+	end := int(r.End.ByteOffset - cm.ContentStart.ByteOffset)
+	return cm.Content[start:end]
+}
+
+// chunkRange converts the range r of the chunk cm to a protocol range.
+func chunkRange(cm *zoekt.ChunkMatch, r zoekt.Range) lspRange {
+	return lspRange{Start: chunkPosition(cm, r.Start), End: chunkPosition(cm, r.End)}
+}
+
+func chunkPosition(cm *zoekt.ChunkMatch, l zoekt.Location) position {
+	p := position{Line: int(l.LineNumber) - 1, Character: int(l.Column) - 1}
+
+	// Columns count runes, so the characters before l on its line are
+	// counted again in UTF-16. The chunks around matches on long lines
+	// don't start at the line, so their columns are kept.
+	if cm.ContentStart.Column != 1 {
+		return p
+	}
+	prefix := cm.Content[:l.ByteOffset-cm.ContentStart.ByteOffset]
+	prefix = prefix[bytes.LastIndexByte(prefix, '\n')+1:]
+	p.Character = utf16Len(prefix)
+	return p
+}
+
+func utf16Len(b []byte) int {
+	n := 0
+	for _, r := range string(b) {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// identifierAt returns the identifier at pos in content.
+func identifierAt(content []byte, pos position) string {
+	lines := bytes.SplitAfter(content, []byte{'\n'})
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+
+	// Find the byte offset of the character.
+	off, units := 0, 0
+	for off < len(line) && units < pos.Character {
+		r, size := utf8.DecodeRune(line[off:])
+		units += utf16.RuneLen(r)
+		off += size
+	}
+
+	isIdent := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	start := off
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(line[:start])
+		if !isIdent(r) {
+			break
+		}
+		start -= size
+	}
+	end := off
+	for end < len(line) {
+		r, size := utf8.DecodeRune(line[end:])
+		if !isIdent(r) {
+			break
+		}
+		end += size
+	}
+	return string(line[start:end])
+}
+
+// Symbol kinds of the protocol.
+const (
+	symbolKindModule    = 2
+	symbolKindNamespace = 3
+	symbolKindPackage   = 4
+	symbolKindClass     = 5
+	symbolKindMethod    = 6
+	symbolKindProperty  = 7
+	symbolKindField     = 8
+	symbolKindEnum      = 10
+	symbolKindInterface = 11
+	symbolKindFunction  = 12
+	symbolKindVariable  = 13
+	symbolKindConstant  = 14
+	symbolKindStruct    = 23
+)
+
+// symbolKind maps the kinds of ctags to the symbol kinds of the protocol.
+func symbolKind(kind string) int {
+	switch strings.ToLower(kind) {
+	case "module":
+		return symbolKindModule
+	case "namespace":
+		return symbolKindNamespace
+	case "package", "packagename":
+		return symbolKindPackage
+	case "class", "type", "typedef", "talias":
+		return symbolKindClass
+	case "method", "methodspec":
+		return symbolKindMethod
+	case "property":
+		return symbolKindProperty
+	case "field", "member":
+		return symbolKindField
+	case "enum":
+		return symbolKindEnum
+	case "interface":
+		return symbolKindInterface
+	case "function", "func":
+		return symbolKindFunction
+	case "constant", "const":
+		return symbolKindConstant
+	case "struct":
+		return symbolKindStruct
+	}
+	return symbolKindVariable
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestServer(t *testing.T) {
+	srcDir := t.TempDir()
+	content := "package web\n\n// ünicode Server\ntype Server struct{}\n\nvar s Server\n"
+	if err := os.WriteFile(filepath.Join(srcDir, "server.go"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "web", Source: srcDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(index.Document{
+		Name:            "server.go",
+		Content:         []byte(content),
+		Symbols:         []index.DocumentSection{{Start: 37, End: 43}},
+		SymbolsMetaData: []*zoekt.Symbol{{Sym: "Server", Kind: "struct", Parent: "web"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	shard := filepath.Join(t.TempDir(), "web_v16.00000.zoekt")
+	f, err := os.Create(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = os.Open(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iFile, err := index.NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searcher, err := index.NewSearcher(iFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	uri := "file://" + filepath.ToSlash(filepath.Join(srcDir, "server.go"))
+	var in bytes.Buffer
+	for _, m := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"rootUri":"file:///nonexistent"}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"workspace/symbol","params":{"query":"serv"}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/references","params":{"textDocument":{"uri":"` + uri + `"},"position":{"line":5,"character":7}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+
+	s := &server{searcher: searcher, maxResults: 10}
+	var out bytes.Buffer
+	if err := s.serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !s.shutdown {
+		t.Error("server did not shut down")
+	}
+
+	responses := map[string]json.RawMessage{}
+	errors := map[string]int{}
+	r := bufio.NewReader(&out)
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		if m.Error != nil {
+			errors[string(*m.ID)] = m.Error.Code
+			continue
+		}
+		b, _ := json.Marshal(m.Result)
+		responses[string(*m.ID)] = b
+	}
+
+	var syms []symbolInformation
+	if err := json.Unmarshal(responses["2"], &syms); err != nil {
+		t.Fatal(err)
+	}
+	wantSyms := []symbolInformation{{
+		Name:          "Server",
+		Kind:          symbolKindStruct,
+		ContainerName: "web",
+		Location:      location{URI: uri, Range: lspRange{Start: position{3, 5}, End: position{3, 11}}},
+	}}
+	if d := cmp.Diff(wantSyms, syms); d != "" {
+		t.Errorf("unexpected symbols (-want, +got):\n%s", d)
+	}
+
+	var locs []location
+	if err := json.Unmarshal(responses["3"], &locs); err != nil {
+		t.Fatal(err)
+	}
+	wantLocs := []location{
+		{URI: uri, Range: lspRange{Start: position{2, 11}, End: position{2, 17}}},
+		{URI: uri, Range: lspRange{Start: position{3, 5}, End: position{3, 11}}},
+		{URI: uri, Range: lspRange{Start: position{5, 6}, End: position{5, 12}}},
+	}
+	if d := cmp.Diff(wantLocs, locs); d != "" {
+		t.Errorf("unexpected references (-want, +got):\n%s", d)
+	}
+
+	if errors["4"] != codeMethodNotFound {
+		t.Errorf("got error %d for an unknown method, want %d", errors["4"], codeMethodNotFound)
+	}
+	if string(responses["5"]) != "null" {
+		t.Errorf("got shutdown result %s, want null", responses["5"])
+	}
+}
+
+// blockingSearcher blocks searches until they are canceled.
+type blockingSearcher struct {
+	zoekt.Searcher
+}
+
+func (s *blockingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Second):
+		return &zoekt.SearchResult{}, nil
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	var in bytes.Buffer
+	for _, m := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"workspace/symbol","params":{"query":"serv"}}`,
+		`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":1}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+
+	s := &server{searcher: &blockingSearcher{}, maxResults: 10}
+	var out bytes.Buffer
+	if err := s.serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMessage(bufio.NewReader(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Error == nil || m.Error.Code != codeRequestCancelled {
+		t.Errorf("got response %+v, want error %d", m, codeRequestCancelled)
+	}
+}
+
+// listSearcher lists the repositories of repos, and counts the List calls.
+type listSearcher struct {
+	zoekt.Searcher
+	repos []*zoekt.Repository
+	lists int
+}
+
+func (s *listSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	s.lists++
+	rl := &zoekt.RepoList{}
+	for _, r := range s.repos {
+		rl.Repos = append(rl.Repos, &zoekt.RepoListEntry{Repository: *r})
+	}
+	return rl, nil
+}
+
+func TestRepoSources(t *testing.T) {
+	searcher := &listSearcher{repos: []*zoekt.Repository{{Name: "a", Source: "/src/a"}, {Name: "b", Source: "/src/b"}}}
+	s := &server{searcher: searcher}
+	sources := s.repoSources(context.Background(), []zoekt.FileMatch{
+		{Repository: "a", FileName: "1.go"},
+		{Repository: "b", FileName: "2.go"},
+		{Repository: "a", FileName: "3.go"},
+	})
+	if d := cmp.Diff(map[string]string{"a": "/src/a", "b": "/src/b"}, sources); d != "" {
+		t.Errorf("unexpected sources (-want, +got):\n%s", d)
+	}
+	if searcher.lists != 1 {
+		t.Errorf("got %d List calls, want 1", searcher.lists)
+	}
+}
@@ -11,6 +11,10 @@
 // limitations under the License.
 
 // Command zoekt-merge-index merges a set of index shards into a compound shard.
+//
+// The plan subcommand plans merging all simple shards of one or more shard
+// directories into compound shards of similar size, and the apply subcommand
+// executes such a plan step by step.
 package main
 
 import (
@@ -154,6 +158,14 @@ func main() {
 		if err := explodeCmd(os.Args[2]); err != nil {
 			log.Fatal(err)
 		}
+	case "plan":
+		if err := planCmd(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "apply":
+		if err := applyCmd(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
 	default:
 		log.Fatalf("unknown subcommand %s", subCommand)
 	}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+)
+
+// Plan is a merge plan. It is written by the plan subcommand and executed by
+// the apply subcommand, which records the progress of the steps in it.
+type Plan struct {
+	TargetSizeBytes int64
+
+	// Steps are ordered by location, and within a location by the priority
+	// of their shards, descending.
+	Steps []*PlanStep
+}
+
+// PlanStep merges simple shards of one location into a compound shard.
+type PlanStep struct {
+	// Location is the directory the shards were found in. Shards of
+	// different locations are never merged, so each index server can apply
+	// the steps of its own location.
+	Location string
+
+	// Shards are the names of the shards in their location, ordered by the
+	// priority of their repositories, descending.
+	Shards    []string
+	SizeBytes int64
+
+	// Compound is the name of the compound shard of the step, once it is
+	// written. Done is set once it replaced the shards of the step.
+	Done     bool   `json:",omitempty"`
+	Compound string `json:",omitempty"`
+}
+
+// planCandidate is a simple shard which may be merged.
+type planCandidate struct {
+	location  string
+	name      string
+	sizeBytes int64
+	priority  float64
+}
+
+// inventory returns the shards of location which may be merged: simple
+// shards of repositories whose latest commit is at least minAge old, and
+// which fit into a single shard. The location must be a local directory,
+// since the steps are applied to local shards.
+func inventory(ctx context.Context, location string, minAge time.Duration) ([]planCandidate, error) {
+	if strings.Contains(location, "://") {
+		return nil, fmt.Errorf("%s: only shards in local directories can be merged", location)
+	}
+	st, err := shards.NewShardStorage(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	listed, err := st.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for fn := range listed {
+		if strings.HasSuffix(fn, ".zoekt") {
+			names[fn] = true
+		}
+	}
+
+	var cands []planCandidate
+	for fn := range names {
+		// Repositories which need more than one shard can't be merged.
+		if !strings.HasSuffix(fn, ".00000.zoekt") || names[strings.TrimSuffix(fn, ".00000.zoekt")+".00001.zoekt"] {
+			continue
+		}
+
+		c, ok, err := readCandidate(ctx, st, fn, minAge)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c.location = location
+			cands = append(cands, c)
+		}
+	}
+	slices.SortFunc(cands, func(a, b planCandidate) int { return cmp.Compare(a.name, b.name) })
+	return cands, nil
+}
+
+func readCandidate(ctx context.Context, st shards.ShardStorage, fn string, minAge time.Duration) (planCandidate, bool, error) {
+	path, err := st.Fetch(ctx, fn)
+	if err != nil {
+		return planCandidate{}, false, err
+	}
+	defer st.Evict(fn)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return planCandidate{}, false, err
+	}
+	repos, _, err := index.ReadMetadataPath(path)
+	if err != nil {
+		return planCandidate{}, false, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	// Compound shards are not merged again, so their repositories stay
+	// ordered by priority.
+	if len(repos) != 1 || repos[0].LatestCommitDate.After(time.Now().Add(-minAge)) {
+		return planCandidate{}, false, nil
+	}
+	return planCandidate{
+		name:      filepath.Base(fn),
+		sizeBytes: fi.Size(),
+		priority:  repos[0].GetPriority(),
+	}, true, nil
+}
+
+// planMerges groups the candidates into compound shards of about
+// targetSizeBytes. Only shards of the same location are grouped, and shards
+// which are as large as a compound shard are left alone. Shards of similar
+// priority are grouped together, and the shards of a location are spread
+// evenly over as many compound shards as they fill.
+func planMerges(cands []planCandidate, targetSizeBytes int64) []*PlanStep {
+	byLocation := map[string][]planCandidate{}
+	for _, c := range cands {
+		if c.sizeBytes < targetSizeBytes {
+			byLocation[c.location] = append(byLocation[c.location], c)
+		}
+	}
+
+	var steps []*PlanStep
+	for _, location := range slices.Sorted(maps.Keys(byLocation)) {
+		cs := byLocation[location]
+		slices.SortStableFunc(cs, func(a, b planCandidate) int { return cmp.Compare(b.priority, a.priority) })
+
+		var total int64
+		for _, c := range cs {
+			total += c.sizeBytes
+		}
+		n := total / targetSizeBytes
+		if n == 0 {
+			continue
+		}
+
+		// The k-th compound shard is closed once the shards so far add up to
+		// k/n of the total.
+		step := &PlanStep{Location: location}
+		var sum int64
+		k := int64(1)
+		for i, c := range cs {
+			step.Shards = append(step.Shards, c.name)
+			step.SizeBytes += c.sizeBytes
+			sum += c.sizeBytes
+			if sum*n < k*total && i < len(cs)-1 {
+				continue
+			}
+			if len(step.Shards) > 1 {
+				steps = append(steps, step)
+			}
+			step = &PlanStep{Location: location}
+			for k <= n && sum*n >= k*total {
+				k++
+			}
+		}
+	}
+	return steps
+}
+
+func readPlan(path string) (*Plan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// writePlan replaces the plan in path, so an interrupted write leaves the
+// previous version.
+func writePlan(path string, p *Plan) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// apply executes the steps of location in the plan in path which are not
+// done yet, merging the shards in dir. It saves the plan after every stage of
+// a step, so it can be resumed after it failed or was interrupted.
+//
+// A step writes the compound shard to a temporary file and records its name,
+// removes the simple shards, and renames the compound shard into place. Only
+// then it is done. Resuming a step picks up after the last stage which
+// completed, so the simple shards are only removed once the compound shard
+// which replaces them is written.
+func apply(path, location, dir string) error {
+	p, err := readPlan(path)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range p.Steps {
+		if step.Done || (location != "" && step.Location != location) {
+			continue
+		}
+		stepDir := dir
+		if stepDir == "" {
+			stepDir = step.Location
+		}
+		if err := applyStep(step, stepDir, func() error { return writePlan(path, p) }); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// applyStep applies step to the shards in dir. It calls save after each
+// stage.
+func applyStep(step *PlanStep, dir string, save func() error) error {
+	var present []string
+	for _, name := range step.Shards {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			present = append(present, filepath.Join(dir, name))
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	dstName := filepath.Join(dir, step.Compound)
+	tmpName := dstName + ".tmp"
+	written := false
+	if step.Compound != "" {
+		_, err := os.Stat(tmpName)
+		written = err == nil
+		if !written {
+			if _, err := os.Stat(dstName); err == nil {
+				// Renamed into place, but interrupted before the plan was
+				// saved. The simple shards may still need to be removed.
+				if err := removeShards(present); err != nil {
+					return err
+				}
+				step.Done = true
+				return save()
+			}
+		}
+	}
+
+	if !written {
+		if len(present) != len(step.Shards) {
+			return fmt.Errorf("%d of %d shards are missing in %s", len(step.Shards)-len(present), len(step.Shards), dir)
+		}
+		files, err := openShards(present)
+		if err != nil {
+			return err
+		}
+		tmpName, dstName, err = index.Merge(dir, files...)
+		for _, f := range files {
+			f.Close()
+		}
+		if err != nil {
+			return err
+		}
+		step.Compound = filepath.Base(dstName)
+		if err := save(); err != nil {
+			return err
+		}
+		log.Printf("merged %d shards into %s", len(present), step.Compound)
+	}
+
+	// We only rename the compound shard once all simple shards are
+	// removed, so there are no duplicate indexes.
+	if err := removeShards(present); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		return fmt.Errorf("failed to rename compound shard: %w", err)
+	}
+	step.Done = true
+	return save()
+}
+
+func openShards(paths []string) ([]index.IndexFile, error) {
+	var files []index.IndexFile
+	for _, fn := range paths {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		indexFile, err := index.NewIndexFile(f)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, indexFile)
+	}
+	return files, nil
+}
+
+// removeShards removes the shards and their metadata. Files which are gone
+// already are skipped, so a failed removal can be retried.
+func removeShards(paths []string) error {
+	for _, name := range paths {
+		for _, p := range []string{name, name + ".meta"} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove simple shard: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func planCmd(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	targetSize := fs.Int64("target_size", 1000, "the target size of compound shards in MiB")
+	minAgeDays := fs.Int("min_age", 7, "the time since the last commit in days. Shards with newer commits are excluded from merging.")
+	out := fs.String("o", "", "write the plan to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s plan [option] LOCATION...\n\n"+
+			"Plans merging the simple shards of each location, a local directory,\n"+
+			"into compound shards of similar size.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *targetSize <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var cands []planCandidate
+	for _, location := range fs.Args() {
+		cs, err := inventory(context.Background(), location, time.Duration(*minAgeDays)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		cands = append(cands, cs...)
+	}
+	targetSizeBytes := *targetSize * 1024 * 1024
+	p := &Plan{TargetSizeBytes: targetSizeBytes, Steps: planMerges(cands, targetSizeBytes)}
+	log.Printf("planned %d compound shards from %d candidates", len(p.Steps), len(cands))
+
+	if *out != "" {
+		return writePlan(*out, p)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func applyCmd(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	location := fs.String("location", "", "only apply the steps of this location")
+	dir := fs.String("dir", "", "the local directory with the shards of the steps, if it isn't their location")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s apply [option] PLAN\n\n"+
+			"Applies the steps of a plan which are not done yet, and records the\n"+
+			"progress in the plan.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return apply(fs.Arg(0), *location, *dir)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanMerges(t *testing.T) {
+	cands := []planCandidate{
+		{location: "a", name: "low1", sizeBytes: 6, priority: 1},
+		{location: "a", name: "high1", sizeBytes: 6, priority: 10},
+		{location: "a", name: "high2", sizeBytes: 6, priority: 9},
+		{location: "a", name: "low2", sizeBytes: 6, priority: 2},
+		{location: "a", name: "huge", sizeBytes: 100, priority: 5},
+		// Too small for a compound shard on its own location.
+		{location: "b", name: "b1", sizeBytes: 3},
+		{location: "b", name: "b2", sizeBytes: 3},
+	}
+
+	steps := planMerges(cands, 10)
+	require.Equal(t, []*PlanStep{
+		{Location: "a", Shards: []string{"high1", "high2"}, SizeBytes: 12},
+		{Location: "a", Shards: []string{"low2", "low1"}, SizeBytes: 12},
+	}, steps)
+}
+
+func TestPlanApply(t *testing.T) {
+	v16Shards, err := filepath.Glob("../../testdata/shards/repo*_v16.*.zoekt")
+	require.NoError(t, err)
+	sort.Strings(v16Shards)
+
+	dir := t.TempDir()
+	testShards, err := copyTestShards(dir, v16Shards)
+	require.NoError(t, err)
+
+	cands, err := inventory(context.Background(), dir, 0)
+	require.NoError(t, err)
+	require.Len(t, cands, len(testShards))
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	var total int64
+	for _, c := range cands {
+		total += c.sizeBytes
+	}
+	require.NoError(t, writePlan(planPath, &Plan{TargetSizeBytes: total, Steps: planMerges(cands, total)}))
+
+	require.NoError(t, apply(planPath, dir, ""))
+
+	p, err := readPlan(planPath)
+	require.NoError(t, err)
+	require.Len(t, p.Steps, 1)
+	require.True(t, p.Steps[0].Done)
+	require.FileExists(t, filepath.Join(dir, p.Steps[0].Compound))
+	for _, s := range testShards {
+		require.NoFileExists(t, s)
+	}
+
+	// Applying the plan again does nothing.
+	require.NoError(t, apply(planPath, dir, ""))
+	compounds, err := filepath.Glob(filepath.Join(dir, "compound-*.zoekt"))
+	require.NoError(t, err)
+	require.Len(t, compounds, 1)
+
+	t.Run("resume", func(t *testing.T) {
+		// The compound shard is in place if the plan couldn't be saved after
+		// renaming it.
+		p.Steps[0].Done = false
+		require.NoError(t, writePlan(planPath, p))
+		require.NoError(t, apply(planPath, dir, ""))
+		p, err := readPlan(planPath)
+		require.NoError(t, err)
+		require.True(t, p.Steps[0].Done)
+	})
+
+	t.Run("resume after removing some shards", func(t *testing.T) {
+		// The run was interrupted after it wrote the compound shard and
+		// removed one of the simple shards.
+		dir := t.TempDir()
+		testShards, err := copyTestShards(dir, v16Shards)
+		require.NoError(t, err)
+		step := &PlanStep{Location: dir}
+		for _, s := range testShards {
+			step.Shards = append(step.Shards, filepath.Base(s))
+		}
+		files, err := openShards(testShards)
+		require.NoError(t, err)
+		tmpName, dstName, err := index.Merge(dir, files...)
+		require.NoError(t, err)
+		for _, f := range files {
+			f.Close()
+		}
+		step.Compound = filepath.Base(dstName)
+		require.NoError(t, os.Remove(testShards[0]))
+
+		planPath := filepath.Join(t.TempDir(), "plan.json")
+		require.NoError(t, writePlan(planPath, &Plan{Steps: []*PlanStep{step}}))
+		require.NoError(t, apply(planPath, "", ""))
+
+		p, err := readPlan(planPath)
+		require.NoError(t, err)
+		require.True(t, p.Steps[0].Done)
+		require.FileExists(t, dstName)
+		require.NoFileExists(t, tmpName)
+		for _, s := range testShards {
+			require.NoFileExists(t, s)
+		}
+	})
+
+	t.Run("object store", func(t *testing.T) {
+		_, err := inventory(context.Background(), "s3://bucket/shards", 0)
+		require.Error(t, err)
+	})
+
+	t.Run("missing shard", func(t *testing.T) {
+		dir := t.TempDir()
+		testShards, err := copyTestShards(dir, v16Shards)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(testShards[0]))
+
+		planPath := filepath.Join(t.TempDir(), "plan.json")
+		require.NoError(t, writePlan(planPath, &Plan{Steps: []*PlanStep{{
+			Location: "elsewhere",
+			Shards:   []string{filepath.Base(testShards[0]), filepath.Base(testShards[1])},
+		}}}))
+		require.Error(t, apply(planPath, "", dir))
+		require.FileExists(t, testShards[1])
+	})
+}
@@ -18,7 +18,6 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -43,6 +42,8 @@ func main() {
 	excludePattern := flag.String("exclude", "", "don't mirror repos whose names match this regexp.")
 	projectType := flag.String("type", "", "only clone repos whose type matches the given string. "+
 		"Type can be either NORMAl or PERSONAL. Clones projects of both types if not set.")
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if *serverUrl == "" {
@@ -92,21 +93,19 @@ func main() {
 
 	apiBaseURL := rootURL.ResolveReference(apiPath).String()
 
-	var config *bitbucketv1.Configuration
+	var tr http.RoundTripper = http.DefaultTransport
 	if *disableTLS {
-		tr := &http.Transport{
+		tr = &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
-		httpClient := &http.Client{
-			Transport: tr,
-		}
-		httpClientConfig := func(configs *bitbucketv1.Configuration) {
-			configs.HTTPClient = httpClient
-		}
-		config = bitbucketv1.NewConfiguration(apiBaseURL, httpClientConfig)
-	} else {
-		config = bitbucketv1.NewConfiguration(apiBaseURL)
 	}
+	httpClient := &http.Client{
+		Transport: gitindex.NewRateLimitTransport(tr),
+	}
+	httpClientConfig := func(configs *bitbucketv1.Configuration) {
+		configs.HTTPClient = httpClient
+	}
+	config := bitbucketv1.NewConfiguration(apiBaseURL, httpClientConfig)
 	client := bitbucketv1.NewAPIClient(ctx, config)
 
 	var repos []bitbucketv1.Repository
@@ -134,7 +133,7 @@ func main() {
 	}
 	repos = trimmed
 
-	if err := cloneRepos(destDir, rootURL.Host, repos, password); err != nil {
+	if err := cloneRepos(destDir, rootURL.Host, repos, password, cloneOpts); err != nil {
 		log.Fatalf("cloneRepos: %v", err)
 	}
 
@@ -235,13 +234,15 @@ func getProjectRepos(client bitbucketv1.APIClient, projectName string) ([]bitbuc
 	return allRepos, nil
 }
 
-func cloneRepos(destDir string, host string, repos []bitbucketv1.Repository, password string) error {
+func cloneRepos(destDir string, host string, repos []bitbucketv1.Repository, password string, cloneOpts gitindex.CloneOptions) error {
+	cloner := gitindex.NewCloner(cloneOpts)
 	for _, r := range repos {
 		fullName := filepath.Join(r.Project.Key, r.Slug)
 		config := map[string]string{
 			"zoekt.web-url-type": "bitbucket-server",
 			"zoekt.web-url":      r.Links.Self[0].Href,
 			"zoekt.name":         filepath.Join(host, fullName),
+			"zoekt.public":       marshalBool(r.Public || (r.Project != nil && r.Project.Public)),
 		}
 
 		httpsCloneUrl := ""
@@ -254,15 +255,18 @@ func cloneRepos(destDir string, host string, repos []bitbucketv1.Repository, pas
 		}
 
 		if httpsCloneUrl != "" {
-			dest, err := gitindex.CloneRepo(destDir, fullName, httpsCloneUrl, config)
-			if err != nil {
-				return err
-			}
-			if dest != "" {
-				fmt.Println(dest)
+			if err := cloner.Clone(destDir, fullName, httpsCloneUrl, config); err != nil {
+				break
 			}
 		}
 	}
 
-	return nil
+	return cloner.Wait()
+}
+
+func marshalBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
 }
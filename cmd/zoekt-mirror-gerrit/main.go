@@ -71,7 +71,7 @@ func (rt *loggingRT) RoundTrip(req *http.Request) (rep *http.Response, err error
 func newLoggingClient() *http.Client {
 	return &http.Client{
 		Transport: &loggingRT{
-			RoundTripper: http.DefaultTransport,
+			RoundTripper: gitindex.NewRateLimitTransport(http.DefaultTransport),
 		},
 	}
 }
@@ -97,6 +97,8 @@ func main() {
 	fetchMetaConfig := flag.Bool("fetch-meta-config", false, "fetch gerrit meta/config branch")
 	httpCrendentialsPath := flag.String("http-credentials", "", "path to a file containing http credentials stored like 'user:password'.")
 	active := flag.Bool("active", false, "mirror only active projects")
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
@@ -176,6 +178,8 @@ func main() {
 		}
 	}
 
+	cloner := gitindex.NewCloner(cloneOpts)
+	var names []string
 	for k, v := range projects {
 		if !filter.Include(k) {
 			continue
@@ -216,12 +220,16 @@ func main() {
 			}
 		}
 
-		if dest, err := gitindex.CloneRepo(*dest, name, cloneURL.String(), config); err != nil {
-			log.Fatalf("CloneRepo: %v", err)
-		} else {
-			fmt.Println(dest)
+		if err := cloner.Clone(*dest, name, cloneURL.String(), config); err != nil {
+			break
 		}
-		if *fetchMetaConfig {
+		names = append(names, name)
+	}
+	if err := cloner.Wait(); err != nil {
+		log.Fatalf("CloneRepo: %v", err)
+	}
+	if *fetchMetaConfig {
+		for _, name := range names {
 			if err := addMetaConfigFetch(filepath.Join(*dest, name+".git")); err != nil {
 				log.Fatalf("addMetaConfigFetch: %v", err)
 			}
@@ -20,8 +20,8 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -66,6 +66,8 @@ func main() {
 	flag.Var(&excludeTopics, "exclude_topic", "don't clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
 	noArchived := flag.Bool("no_archived", false, "mirror only projects that are not archived")
 
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if *dest == "" {
@@ -77,7 +79,9 @@ func main() {
 
 	var host string
 	var client *gitea.Client
-	clientOptions := []gitea.ClientOption{}
+	clientOptions := []gitea.ClientOption{
+		gitea.SetHTTPClient(&http.Client{Transport: gitindex.NewRateLimitTransport(http.DefaultTransport)}),
+	}
 
 	destDir := filepath.Join(*dest, host)
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
@@ -144,7 +148,7 @@ func main() {
 		repos = trimmed
 	}
 
-	if err := cloneRepos(destDir, repos); err != nil {
+	if err := cloneRepos(destDir, repos, cloneOpts); err != nil {
 		log.Fatalf("cloneRepos: %v", err)
 	}
 
@@ -249,7 +253,8 @@ func getUserRepos(client *gitea.Client, user string, reposFilters reposFilters)
 	return allRepos, nil
 }
 
-func cloneRepos(destDir string, repos []*gitea.Repository) error {
+func cloneRepos(destDir string, repos []*gitea.Repository, cloneOpts gitindex.CloneOptions) error {
+	cloner := gitindex.NewCloner(cloneOpts)
 	for _, r := range repos {
 		host, err := url.Parse(r.HTMLURL)
 		if err != nil {
@@ -269,19 +274,14 @@ func cloneRepos(destDir string, repos []*gitea.Repository) error {
 
 			"zoekt.archived": marshalBool(r.Archived),
 			"zoekt.fork":     marshalBool(r.Fork),
-			"zoekt.public":   marshalBool(r.Private || r.Internal), // count internal repos as private
-		}
-		dest, err := gitindex.CloneRepo(destDir, r.FullName, r.CloneURL, config)
-		if err != nil {
-			return err
+			"zoekt.public":   marshalBool(!r.Private && !r.Internal), // count internal repos as private
 		}
-		if dest != "" {
-			fmt.Println(dest)
+		if err := cloner.Clone(destDir, r.FullName, r.CloneURL, config); err != nil {
+			break
 		}
-
 	}
 
-	return nil
+	return cloner.Wait()
 }
 
 func marshalBool(b bool) string {
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSearchResults is the number of results GitHub returns for a search
+	// query. We split searches which match more repositories by creation
+	// date.
+	maxSearchResults = 1000
+
+	// searchPageSize is the number of repositories we fetch per request.
+	searchPageSize = 100
+)
+
+// firstCreated is a date before any GitHub repository was created.
+var firstCreated = time.Date(2007, 10, 1, 0, 0, 0, 0, time.UTC)
+
+// reposFilters are the filters which GitHub applies to the repositories it
+// returns.
+type reposFilters struct {
+	org           string
+	user          string
+	topics        []string
+	excludeTopics []string
+	languages     []string
+	pushedAfter   time.Time
+	visibility    string
+	forks         bool
+	noArchived    bool
+}
+
+// searchQueries returns the search queries matching the repositories
+// selected by f. GitHub search can't express alternatives of qualifiers, so
+// there is a query for each combination of topic and language.
+func (f reposFilters) searchQueries() []string {
+	var base []string
+	if f.org != "" {
+		base = append(base, "org:"+f.org)
+	}
+	if f.user != "" {
+		base = append(base, "user:"+f.user)
+	}
+	if f.forks {
+		base = append(base, "fork:true")
+	}
+	if f.noArchived {
+		base = append(base, "archived:false")
+	}
+	if f.visibility != "" {
+		base = append(base, "is:"+f.visibility)
+	}
+	if !f.pushedAfter.IsZero() {
+		base = append(base, "pushed:>="+f.pushedAfter.Format("2006-01-02"))
+	}
+	for _, t := range f.excludeTopics {
+		base = append(base, "-topic:"+t)
+	}
+
+	topics := []string{""}
+	if len(f.topics) > 0 {
+		topics = f.topics
+	}
+	languages := []string{""}
+	if len(f.languages) > 0 {
+		languages = f.languages
+	}
+
+	var queries []string
+	for _, t := range topics {
+		for _, l := range languages {
+			q := slices.Clone(base)
+			if t != "" {
+				q = append(q, "topic:"+t)
+			}
+			if l != "" {
+				q = append(q, fmt.Sprintf("language:%q", l))
+			}
+			queries = append(queries, strings.Join(q, " "))
+		}
+	}
+	return queries
+}
+
+// window is a range of repository creation times, both ends inclusive.
+type window struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w window) qualifier() string {
+	return "created:" + w.From.Format(time.RFC3339) + ".." + w.To.Format(time.RFC3339)
+}
+
+// split splits w in two halves. It returns false if w is too short to be
+// split.
+func (w window) split() (window, window, bool) {
+	d := w.To.Sub(w.From)
+	if d < time.Second {
+		return w, w, false
+	}
+	mid := w.From.Add(d / 2).Truncate(time.Second)
+	return window{From: w.From, To: mid}, window{From: mid.Add(time.Second), To: w.To}, true
+}
+
+// pendingSearch is a search which is not finished yet.
+type pendingSearch struct {
+	Query  string
+	Window window
+
+	// Cursor is the end cursor of the last page we fetched. It is empty if
+	// we didn't fetch a page yet.
+	Cursor string
+}
+
+// enumerationState is the progress of an enumeration. It is saved after
+// every page, so a restarted mirror continues where it stopped instead of
+// enumerating all repositories again.
+type enumerationState struct {
+	// Queries are the search queries of the enumeration. A saved state for
+	// different queries is discarded.
+	Queries []string
+
+	// Pending are the searches left to do. The first one is in progress.
+	Pending []pendingSearch
+
+	// Repos are the repositories found so far, by name with owner.
+	Repos map[string]repository
+}
+
+func newEnumerationState(queries []string, now time.Time) *enumerationState {
+	s := &enumerationState{
+		Queries: queries,
+		Repos:   map[string]repository{},
+	}
+	for _, q := range queries {
+		s.Pending = append(s.Pending, pendingSearch{
+			Query:  q,
+			Window: window{From: firstCreated, To: now.UTC().Truncate(time.Second)},
+		})
+	}
+	return s
+}
+
+// loadEnumerationState returns the state saved at path if it belongs to
+// queries, and nil otherwise.
+func loadEnumerationState(path string, queries []string) (*enumerationState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s enumerationState
+	if err := json.Unmarshal(b, &s); err != nil {
+		log.Printf("ignoring invalid enumeration state %s: %v", path, err)
+		return nil, nil
+	}
+	if !slices.Equal(s.Queries, queries) {
+		return nil, nil
+	}
+	if s.Repos == nil {
+		s.Repos = map[string]repository{}
+	}
+	return &s, nil
+}
+
+func (s *enumerationState) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// enumerateRepos returns the repositories matching filters. If statePath is
+// set, the progress is saved there, and a previous enumeration of the same
+// filters which was interrupted is continued.
+func enumerateRepos(ctx context.Context, c *graphQLClient, filters reposFilters, statePath string) ([]repository, error) {
+	queries := filters.searchQueries()
+
+	var s *enumerationState
+	if statePath != "" {
+		var err error
+		s, err = loadEnumerationState(statePath, queries)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			log.Printf("continuing enumeration with %d repositories and %d pending searches", len(s.Repos), len(s.Pending))
+		}
+	}
+	if s == nil {
+		s = newEnumerationState(queries, time.Now())
+	}
+
+	save := func() error {
+		if statePath == "" {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+			return err
+		}
+		return s.save(statePath)
+	}
+
+	for len(s.Pending) > 0 {
+		p := &s.Pending[0]
+		page, err := c.searchRepositories(ctx, p.Query+" "+p.Window.qualifier(), searchPageSize, p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Cursor == "" && page.RepositoryCount > maxSearchResults {
+			if a, b, ok := p.Window.split(); ok {
+				s.Pending = append([]pendingSearch{{Query: p.Query, Window: a}, {Query: p.Query, Window: b}}, s.Pending[1:]...)
+				continue
+			}
+			log.Printf("%q matches %d repositories created at %s, only the first %d are mirrored", p.Query, page.RepositoryCount, p.Window.From.Format(time.RFC3339), maxSearchResults)
+		}
+
+		for _, r := range page.Nodes {
+			// Search may return other kinds of nodes as empty objects.
+			if r.NameWithOwner != "" {
+				s.Repos[r.NameWithOwner] = r
+			}
+		}
+
+		if page.PageInfo.HasNextPage && page.PageInfo.EndCursor != "" {
+			p.Cursor = page.PageInfo.EndCursor
+		} else {
+			s.Pending = s.Pending[1:]
+		}
+
+		if err := save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if statePath != "" {
+		if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	repos := make([]repository, 0, len(s.Repos))
+	for _, r := range s.Repos {
+		repos = append(repos, r)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].NameWithOwner < repos[j].NameWithOwner })
+	return repos, nil
+}
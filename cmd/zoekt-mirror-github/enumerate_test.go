@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeGitHub serves repository searches over repos, which are created one
+// hour apart. Like GitHub, it returns at most maxSearchResults results per
+// query.
+type fakeGitHub struct {
+	repos    int
+	requests int
+
+	// failAfter makes requests fail once this many requests were served.
+	failAfter int
+}
+
+var createdRe = regexp.MustCompile(`created:(\S+)\.\.(\S+)`)
+
+func (f *fakeGitHub) created(i int) time.Time {
+	return firstCreated.Add(time.Duration(i+1) * time.Hour)
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.failAfter > 0 && f.requests >= f.failAfter {
+		http.Error(w, "rate limited", http.StatusForbidden)
+		return
+	}
+	f.requests++
+
+	var req struct {
+		Variables struct {
+			Q     string
+			First int
+			After string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m := createdRe.FindStringSubmatch(req.Variables.Q)
+	from, _ := time.Parse(time.RFC3339, m[1])
+	to, _ := time.Parse(time.RFC3339, m[2])
+	var matches []repository
+	for i := 0; i < f.repos; i++ {
+		if c := f.created(i); !c.Before(from) && !c.After(to) {
+			name := fmt.Sprintf("org/repo-%04d", i)
+			matches = append(matches, repository{Name: name[4:], NameWithOwner: name, URL: "https://github.com/" + name})
+		}
+	}
+
+	var page searchPage
+	page.RepositoryCount = len(matches)
+	matches = matches[:min(len(matches), maxSearchResults)]
+	offset, _ := strconv.Atoi(req.Variables.After)
+	end := min(offset+req.Variables.First, len(matches))
+	page.Nodes = matches[offset:end]
+	page.PageInfo.HasNextPage = end < len(matches)
+	page.PageInfo.EndCursor = strconv.Itoa(end)
+
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"search": page}})
+}
+
+func TestEnumerateRepos(t *testing.T) {
+	gh := &fakeGitHub{repos: 2500, failAfter: 10}
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+
+	client := &graphQLClient{endpoint: srv.URL, client: srv.Client()}
+	filters := reposFilters{org: "org"}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	if _, err := enumerateRepos(context.Background(), client, filters, statePath); err == nil {
+		t.Fatal("expected error from failing server")
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("state should be saved after an error: %v", err)
+	}
+
+	// The second run continues where the first one stopped instead of
+	// starting over.
+	failed := gh.requests
+	gh.failAfter = 0
+	repos, err := enumerateRepos(context.Background(), client, filters, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != gh.repos {
+		t.Fatalf("got %d repos, want %d", len(repos), gh.repos)
+	}
+	for i, r := range repos {
+		if want := fmt.Sprintf("org/repo-%04d", i); r.NameWithOwner != want {
+			t.Fatalf("repos[%d] = %s, want %s", i, r.NameWithOwner, want)
+		}
+	}
+	resumed := gh.requests - failed
+
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Fatalf("state should be removed after enumerating: %v", err)
+	}
+
+	// A fresh run needs more requests than the resumed one.
+	gh.requests = 0
+	if _, err := enumerateRepos(context.Background(), client, filters, statePath); err != nil {
+		t.Fatal(err)
+	}
+	if resumed >= gh.requests {
+		t.Errorf("resumed enumeration made %d requests, a fresh one %d", resumed, gh.requests)
+	}
+}
+
+func TestSearchQueries(t *testing.T) {
+	filters := reposFilters{
+		org:           "acme",
+		topics:        []string{"search", "index"},
+		excludeTopics: []string{"deprecated"},
+		languages:     []string{"Go", "C++"},
+		pushedAfter:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		visibility:    "public",
+		noArchived:    true,
+	}
+	want := []string{
+		`org:acme archived:false is:public pushed:>=2024-01-02 -topic:deprecated topic:search language:"Go"`,
+		`org:acme archived:false is:public pushed:>=2024-01-02 -topic:deprecated topic:search language:"C++"`,
+		`org:acme archived:false is:public pushed:>=2024-01-02 -topic:deprecated topic:index language:"Go"`,
+		`org:acme archived:false is:public pushed:>=2024-01-02 -topic:deprecated topic:index language:"C++"`,
+	}
+	if d := cmp.Diff(want, filters.searchQueries()); d != "" {
+		t.Errorf("unexpected queries (-want, +got):\n%s", d)
+	}
+
+	if d := cmp.Diff([]string{"user:alice fork:true"}, reposFilters{user: "alice", forks: true}.searchQueries()); d != "" {
+		t.Errorf("unexpected queries (-want, +got):\n%s", d)
+	}
+}
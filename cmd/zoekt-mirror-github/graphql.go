@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// repository is the subset of a GitHub repository which we need to mirror
+// it.
+type repository struct {
+	Name           string `json:"name"`
+	NameWithOwner  string `json:"nameWithOwner"`
+	URL            string `json:"url"`
+	IsFork         bool   `json:"isFork"`
+	IsArchived     bool   `json:"isArchived"`
+	IsPrivate      bool   `json:"isPrivate"`
+	StargazerCount int    `json:"stargazerCount"`
+	ForkCount      int    `json:"forkCount"`
+	Watchers       struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"watchers"`
+
+	// DiskUsage is the size of the repository in kilobytes.
+	DiskUsage int       `json:"diskUsage"`
+	PushedAt  time.Time `json:"pushedAt"`
+}
+
+const searchRepositoriesQuery = `query($q: String!, $first: Int!, $after: String) {
+  search(query: $q, type: REPOSITORY, first: $first, after: $after) {
+    repositoryCount
+    pageInfo {
+      hasNextPage
+      endCursor
+    }
+    nodes {
+      ... on Repository {
+        name
+        nameWithOwner
+        url
+        isFork
+        isArchived
+        isPrivate
+        stargazerCount
+        forkCount
+        watchers {
+          totalCount
+        }
+        diskUsage
+        pushedAt
+      }
+    }
+  }
+}`
+
+// searchPage is a page of repository search results.
+type searchPage struct {
+	RepositoryCount int `json:"repositoryCount"`
+	PageInfo        struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+	Nodes []repository `json:"nodes"`
+}
+
+// graphQLClient queries the GitHub GraphQL API.
+type graphQLClient struct {
+	endpoint string
+	client   *http.Client
+}
+
+// graphQLEndpoint returns the GraphQL endpoint of the GitHub instance at
+// rootURL, or of github.com if rootURL is empty.
+func graphQLEndpoint(rootURL string) string {
+	if rootURL == "" {
+		return "https://api.github.com/graphql"
+	}
+	return strings.TrimSuffix(rootURL, "/") + "/api/graphql"
+}
+
+type graphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// searchRepositories returns the page of repositories matching the search
+// query q after cursor.
+func (c *graphQLClient) searchRepositories(ctx context.Context, q string, first int, after string) (*searchPage, error) {
+	vars := map[string]any{"q": q, "first": first}
+	if after != "" {
+		vars["after"] = after
+	}
+	body, err := json.Marshal(map[string]any{
+		"query":     searchRepositoriesQuery,
+		"variables": vars,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("search %q: %s: %s", q, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Data struct {
+			Search searchPage `json:"search"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("search %q: %w", q, err)
+	}
+	if len(result.Errors) > 0 {
+		e := result.Errors[0]
+		return nil, fmt.Errorf("search %q: %s (%s)", q, e.Message, e.Type)
+	}
+	return &result.Data.Search, nil
+}
@@ -13,23 +13,25 @@
 // limitations under the License.
 
 // Command zoekt-mirror-github fetches all repos of a github user or organization
-// and clones them. It is strongly recommended to get a personal API token from
-// https://github.com/settings/tokens, save the token in a file, and point the
-// --token option to it.
+// and clones them. Repositories are found with the GraphQL search API, which
+// requires a personal API token from https://github.com/settings/tokens. Save
+// the token in a file, and point the --token option to it. Without a token,
+// the public repositories of the user or organization are listed with the
+// REST API, which GitHub rate limits to 60 requests an hour.
 package main
 
 import (
 	"context"
 	"flag"
-	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/google/go-github/v27/github"
 	"golang.org/x/oauth2"
 
 	"github.com/sourcegraph/zoekt/internal/gitindex"
@@ -46,12 +48,6 @@ func (f *topicsFlag) Set(value string) error {
 	return nil
 }
 
-type reposFilters struct {
-	topics        []string
-	excludeTopics []string
-	noArchived    *bool
-}
-
 func main() {
 	dest := flag.String("dest", "", "destination directory")
 	githubURL := flag.String("url", "", "GitHub Enterprise url. If not set github.com will be used as the host.")
@@ -59,7 +55,7 @@ func main() {
 	user := flag.String("user", "", "user to mirror")
 	token := flag.String("token",
 		filepath.Join(os.Getenv("HOME"), ".github-token"),
-		"file holding API token.")
+		"file holding API token. If empty, only the public repos of --org or --user are mirrored, which GitHub lists without authentication at a lower rate limit.")
 	forks := flag.Bool("forks", false, "also mirror forks.")
 	deleteRepos := flag.Bool("delete", false, "delete missing repos")
 	namePattern := flag.String("name", "", "only clone repos whose name matches the given regexp.")
@@ -68,8 +64,18 @@ func main() {
 	flag.Var(&topics, "topic", "only clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
 	excludeTopics := topicsFlag{}
 	flag.Var(&excludeTopics, "exclude_topic", "don't clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
+	languages := topicsFlag{}
+	flag.Var(&languages, "language", "only clone repos whose primary language is one of the given languages. You can add multiple languages by setting this more than once.")
+	pushedAfter := flag.String("pushed_after", "", "only clone repos pushed to on or after this date (YYYY-MM-DD).")
+	visibility := flag.String("visibility", "", "only clone repos with this visibility: public, private or internal.")
 	noArchived := flag.Bool("no_archived", false, "mirror only projects that are not archived")
+	maxSizeMB := flag.Int("max_size_mb", 0, "don't mirror repos whose disk usage on GitHub is above this many megabytes. With --delete, their existing mirrors are deleted.")
+	inactiveMonths := flag.Int("inactive_months", 0, "don't mirror repos without pushes in this many months. With --delete, their existing mirrors are deleted.")
+	skipReport := flag.String("skip_report", "", "if set, write the repos skipped by --max_size_mb and --inactive_months to this file as JSON lines, with the reason, disk usage and last push.")
+	stateFile := flag.String("state_file", "", "file in which the progress of the repository enumeration is saved, so an interrupted mirror continues where it stopped. Defaults to a file in --dest.")
 
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if *dest == "" {
@@ -78,102 +84,112 @@ func main() {
 	if *githubURL == "" && *org == "" && *user == "" {
 		log.Fatal("must set either --org or --user when github.com is used as host")
 	}
+	switch *visibility {
+	case "", "public", "private", "internal":
+	default:
+		log.Fatalf("unknown visibility %q, want public, private or internal", *visibility)
+	}
 
-	var host string
-	var apiBaseURL string
-	var client *github.Client
+	host := "github.com"
 	if *githubURL != "" {
 		rootURL, err := url.Parse(*githubURL)
 		if err != nil {
 			log.Fatal(err)
 		}
 		host = rootURL.Host
-		apiPath, err := url.Parse("/api/v3/")
-		if err != nil {
-			log.Fatal(err)
-		}
-		apiBaseURL = rootURL.ResolveReference(apiPath).String()
-		client, err = github.NewEnterpriseClient(apiBaseURL, apiBaseURL, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		host = "github.com"
-		apiBaseURL = "https://github.com/"
-		client = github.NewClient(nil)
 	}
 	destDir := filepath.Join(*dest, host)
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
 		log.Fatal(err)
 	}
 
-	if *token != "" {
+	reposFilters := reposFilters{
+		org:           *org,
+		user:          *user,
+		topics:        topics,
+		excludeTopics: excludeTopics,
+		languages:     languages,
+		visibility:    *visibility,
+		forks:         *forks,
+		noArchived:    *noArchived,
+	}
+	if *pushedAfter != "" {
+		var err error
+		reposFilters.pushedAfter, err = time.Parse("2006-01-02", *pushedAfter)
+		if err != nil {
+			log.Fatalf("invalid --pushed_after: %v", err)
+		}
+	}
+	if *org == "" && *user == "" {
+		log.Printf("no user or org specified, cloning all repos.")
+	}
+
+	httpClient := &http.Client{
+		Transport: gitindex.NewRateLimitTransport(http.DefaultTransport),
+	}
+	var repos []repository
+	if *token == "" {
+		log.Printf("no token specified, mirroring only public repos.")
+		var err error
+		repos, err = listPublicRepos(context.Background(), httpClient, restAPIURL(*githubURL), reposFilters)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
 		content, err := os.ReadFile(*token)
 		if err != nil {
 			log.Fatal(err)
 		}
-
 		ts := oauth2.StaticTokenSource(
 			&oauth2.Token{
 				AccessToken: strings.TrimSpace(string(content)),
 			})
-		tc := oauth2.NewClient(context.Background(), ts)
-		if *githubURL != "" {
-			client, err = github.NewEnterpriseClient(apiBaseURL, apiBaseURL, tc)
-			if err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			client = github.NewClient(tc)
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+		client := &graphQLClient{
+			endpoint: graphQLEndpoint(*githubURL),
+			client:   oauth2.NewClient(ctx, ts),
 		}
-	}
 
-	reposFilters := reposFilters{
-		topics:        topics,
-		excludeTopics: excludeTopics,
-		noArchived:    noArchived,
-	}
-	var repos []*github.Repository
-	var err error
-	if *org != "" {
-		repos, err = getOrgRepos(client, *org, reposFilters)
-	} else if *user != "" {
-		repos, err = getUserRepos(client, *user, reposFilters)
-	} else {
-		log.Printf("no user or org specified, cloning all repos.")
-		repos, err = getUserRepos(client, "", reposFilters)
+		statePath := *stateFile
+		if statePath == "" {
+			statePath = filepath.Join(destDir, ".zoekt-mirror-github-"+*org+*user+".json")
+		}
+		repos, err = enumerateRepos(context.Background(), client, reposFilters, statePath)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if !*forks {
+	{
 		trimmed := repos[:0]
 		for _, r := range repos {
-			if r.Fork == nil || !*r.Fork {
+			if filter.Include(r.Name) {
 				trimmed = append(trimmed, r)
 			}
 		}
 		repos = trimmed
 	}
 
-	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
-	if err != nil {
-		log.Fatal(err)
+	skip := skipFilters{maxDiskUsage: *maxSizeMB * 1024}
+	if *inactiveMonths > 0 {
+		skip.pushedSince = time.Now().AddDate(0, -*inactiveMonths, 0)
 	}
-
-	{
-		trimmed := repos[:0]
-		for _, r := range repos {
-			if filter.Include(*r.Name) {
-				trimmed = append(trimmed, r)
-			}
+	repos, skipped := skip.apply(repos)
+	if len(skipped) > 0 {
+		log.Printf("skipping %d repos which are too large or inactive", len(skipped))
+	}
+	if *skipReport != "" {
+		if err := writeSkipReport(*skipReport, skipped); err != nil {
+			log.Fatalf("writeSkipReport: %v", err)
 		}
-		repos = trimmed
 	}
 
-	if err := cloneRepos(destDir, repos); err != nil {
+	if err := cloneRepos(destDir, repos, cloneOpts); err != nil {
 		log.Fatalf("cloneRepos: %v", err)
 	}
 
@@ -184,10 +200,10 @@ func main() {
 	}
 }
 
-func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*github.Repository, user string) error {
+func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []repository, user string) error {
 	var baseURL string
 	if len(repos) > 0 {
-		baseURL = *repos[0].HTMLURL
+		baseURL = repos[0].URL
 	} else {
 		return nil
 	}
@@ -199,7 +215,7 @@ func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*github.R
 
 	names := map[string]struct{}{}
 	for _, r := range repos {
-		u, err := url.Parse(*r.HTMLURL)
+		u, err := url.Parse(r.URL)
 		if err != nil {
 			return err
 		}
@@ -212,115 +228,36 @@ func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*github.R
 	return nil
 }
 
-func hasIntersection(s1, s2 []string) bool {
-	hash := make(map[string]bool)
-	for _, e := range s1 {
-		hash[e] = true
-	}
-	for _, e := range s2 {
-		if hash[e] {
-			return true
-		}
-	}
-	return false
-}
-
-func filterRepositories(repos []*github.Repository, include []string, exclude []string, noArchived bool) (filteredRepos []*github.Repository) {
-	for _, repo := range repos {
-		if noArchived && *repo.Archived {
-			continue
-		}
-		if (len(include) == 0 || hasIntersection(include, repo.Topics)) &&
-			!hasIntersection(exclude, repo.Topics) {
-			filteredRepos = append(filteredRepos, repo)
-		}
-	}
-	return
-}
-
-func getOrgRepos(client *github.Client, org string, reposFilters reposFilters) ([]*github.Repository, error) {
-	var allRepos []*github.Repository
-	opt := &github.RepositoryListByOrgOptions{}
-	for {
-		repos, resp, err := client.Repositories.ListByOrg(context.Background(), org, opt)
-		if err != nil {
-			return nil, err
-		}
-		if len(repos) == 0 {
-			break
-		}
-
-		opt.Page = resp.NextPage
-		repos = filterRepositories(repos, reposFilters.topics, reposFilters.excludeTopics, *reposFilters.noArchived)
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
-		}
-	}
-	return allRepos, nil
-}
-
-func getUserRepos(client *github.Client, user string, reposFilters reposFilters) ([]*github.Repository, error) {
-	var allRepos []*github.Repository
-	opt := &github.RepositoryListOptions{}
-	for {
-		repos, resp, err := client.Repositories.List(context.Background(), user, opt)
-		if err != nil {
-			return nil, err
-		}
-		if len(repos) == 0 {
-			break
-		}
-
-		opt.Page = resp.NextPage
-		repos = filterRepositories(repos, reposFilters.topics, reposFilters.excludeTopics, *reposFilters.noArchived)
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
-		}
-	}
-	return allRepos, nil
-}
-
-func itoa(p *int) string {
-	if p != nil {
-		return strconv.Itoa(*p)
-	}
-	return ""
-}
-
-func cloneRepos(destDir string, repos []*github.Repository) error {
+func cloneRepos(destDir string, repos []repository, cloneOpts gitindex.CloneOptions) error {
+	cloner := gitindex.NewCloner(cloneOpts)
 	for _, r := range repos {
-		host, err := url.Parse(*r.HTMLURL)
+		host, err := url.Parse(r.URL)
 		if err != nil {
 			return err
 		}
 
 		config := map[string]string{
 			"zoekt.web-url-type": "github",
-			"zoekt.web-url":      *r.HTMLURL,
-			"zoekt.name":         filepath.Join(host.Hostname(), *r.FullName),
-
-			"zoekt.github-stars":       itoa(r.StargazersCount),
-			"zoekt.github-watchers":    itoa(r.WatchersCount),
-			"zoekt.github-subscribers": itoa(r.SubscribersCount),
-			"zoekt.github-forks":       itoa(r.ForksCount),
-
-			"zoekt.archived": marshalBool(r.Archived != nil && *r.Archived),
-			"zoekt.fork":     marshalBool(r.Fork != nil && *r.Fork),
-			"zoekt.public":   marshalBool(r.Private == nil || !*r.Private),
+			"zoekt.web-url":      r.URL,
+			"zoekt.name":         filepath.Join(host.Hostname(), r.NameWithOwner),
+
+			// The REST API reports stars as watchers and watchers as
+			// subscribers, we keep the names it used.
+			"zoekt.github-stars":       strconv.Itoa(r.StargazerCount),
+			"zoekt.github-watchers":    strconv.Itoa(r.StargazerCount),
+			"zoekt.github-subscribers": strconv.Itoa(r.Watchers.TotalCount),
+			"zoekt.github-forks":       strconv.Itoa(r.ForkCount),
+
+			"zoekt.archived": marshalBool(r.IsArchived),
+			"zoekt.fork":     marshalBool(r.IsFork),
+			"zoekt.public":   marshalBool(!r.IsPrivate),
 		}
-		dest, err := gitindex.CloneRepo(destDir, *r.FullName, *r.CloneURL, config)
-		if err != nil {
-			return err
-		}
-		if dest != "" {
-			fmt.Println(dest)
+		if err := cloner.Clone(destDir, r.NameWithOwner, r.URL+".git", config); err != nil {
+			break
 		}
-
 	}
 
-	return nil
+	return cloner.Wait()
 }
 
 func marshalBool(b bool) string {
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// restRepository is a repository as listed by the REST API.
+type restRepository struct {
+	Name            string    `json:"name"`
+	FullName        string    `json:"full_name"`
+	HTMLURL         string    `json:"html_url"`
+	Fork            bool      `json:"fork"`
+	Archived        bool      `json:"archived"`
+	Private         bool      `json:"private"`
+	StargazersCount int       `json:"stargazers_count"`
+	ForksCount      int       `json:"forks_count"`
+	Size            int       `json:"size"`
+	PushedAt        time.Time `json:"pushed_at"`
+	Language        string    `json:"language"`
+	Topics          []string  `json:"topics"`
+}
+
+func (r restRepository) repository() repository {
+	return repository{
+		Name:           r.Name,
+		NameWithOwner:  r.FullName,
+		URL:            r.HTMLURL,
+		IsFork:         r.Fork,
+		IsArchived:     r.Archived,
+		IsPrivate:      r.Private,
+		StargazerCount: r.StargazersCount,
+		ForkCount:      r.ForksCount,
+		DiskUsage:      r.Size,
+		PushedAt:       r.PushedAt,
+	}
+}
+
+// include reports whether r passes f. The REST API can't filter the
+// repositories it lists, so we do.
+func (f reposFilters) include(r restRepository) bool {
+	if r.Fork && !f.forks || r.Archived && f.noArchived {
+		return false
+	}
+	if !f.pushedAfter.IsZero() && r.PushedAt.Before(f.pushedAfter) {
+		return false
+	}
+	if len(f.topics) > 0 && !slices.ContainsFunc(f.topics, func(t string) bool { return slices.Contains(r.Topics, t) }) {
+		return false
+	}
+	if slices.ContainsFunc(f.excludeTopics, func(t string) bool { return slices.Contains(r.Topics, t) }) {
+		return false
+	}
+	if len(f.languages) > 0 && !slices.ContainsFunc(f.languages, func(l string) bool { return strings.EqualFold(l, r.Language) }) {
+		return false
+	}
+	return true
+}
+
+// restAPIURL returns the REST API root of the GitHub instance at rootURL, or
+// of github.com if rootURL is empty.
+func restAPIURL(rootURL string) string {
+	if rootURL == "" {
+		return "https://api.github.com"
+	}
+	return strings.TrimSuffix(rootURL, "/") + "/api/v3"
+}
+
+// listPublicRepos returns the public repositories of the organization or
+// user of filters which pass them. It uses the REST API, which unlike the
+// GraphQL API can be used without a token.
+func listPublicRepos(ctx context.Context, client *http.Client, apiURL string, filters reposFilters) ([]repository, error) {
+	var path string
+	switch {
+	case filters.org != "":
+		path = "/orgs/" + url.PathEscape(filters.org) + "/repos"
+	case filters.user != "":
+		path = "/users/" + url.PathEscape(filters.user) + "/repos"
+	default:
+		return nil, fmt.Errorf("listing the repositories without a token needs --org or --user")
+	}
+	if filters.visibility != "" && filters.visibility != "public" {
+		return nil, fmt.Errorf("listing %s repositories needs a token", filters.visibility)
+	}
+
+	var repos []repository
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%s%s?type=public&per_page=%d&page=%d", apiURL, path, searchPageSize, page)
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var list []restRepository
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err = fmt.Errorf("list %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&list)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range list {
+			if !r.Private && filters.include(r) {
+				repos = append(repos, r.repository())
+			}
+		}
+		if len(list) < searchPageSize {
+			sort.Slice(repos, func(i, j int) bool { return repos[i].NameWithOwner < repos[j].NameWithOwner })
+			return repos, nil
+		}
+	}
+}
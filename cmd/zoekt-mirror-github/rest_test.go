@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListPublicRepos(t *testing.T) {
+	// Two pages of repositories, every tenth of which is a fork.
+	var all []restRepository
+	for i := 0; i < searchPageSize+5; i++ {
+		name := fmt.Sprintf("repo%03d", i)
+		all = append(all, restRepository{
+			Name:     name,
+			FullName: "acme/" + name,
+			HTMLURL:  "https://github.com/acme/" + name,
+			Fork:     i%10 == 0,
+			PushedAt: firstCreated.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/acme/repos" || r.URL.Query().Get("type") != "public" {
+			http.NotFound(w, r)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min((page-1)*searchPageSize, len(all))
+		json.NewEncoder(w).Encode(all[start:min(start+searchPageSize, len(all))])
+	}))
+	defer ts.Close()
+
+	repos, err := listPublicRepos(context.Background(), ts.Client(), ts.URL, reposFilters{org: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i, r := range all {
+		if i%10 != 0 {
+			want = append(want, r.FullName)
+		}
+	}
+	var got []string
+	for _, r := range repos {
+		got = append(got, r.NameWithOwner)
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", d)
+	}
+
+	if _, err := listPublicRepos(context.Background(), ts.Client(), ts.URL, reposFilters{user: "someone"}); err == nil {
+		t.Error("got no error for a missing user")
+	}
+	if _, err := listPublicRepos(context.Background(), ts.Client(), ts.URL, reposFilters{org: "acme", visibility: "private"}); err == nil {
+		t.Error("got no error for private repositories")
+	}
+}
+
+func TestReposFiltersInclude(t *testing.T) {
+	pushed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := restRepository{Language: "Go", Topics: []string{"search"}, PushedAt: pushed}
+	for _, tc := range []struct {
+		filters reposFilters
+		want    bool
+	}{
+		{reposFilters{}, true},
+		{reposFilters{topics: []string{"search", "web"}}, true},
+		{reposFilters{topics: []string{"web"}}, false},
+		{reposFilters{excludeTopics: []string{"search"}}, false},
+		{reposFilters{languages: []string{"go"}}, true},
+		{reposFilters{languages: []string{"rust"}}, false},
+		{reposFilters{pushedAfter: pushed}, true},
+		{reposFilters{pushedAfter: pushed.AddDate(0, 0, 1)}, false},
+	} {
+		if got := tc.filters.include(r); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.filters, got, tc.want)
+		}
+	}
+
+	if (reposFilters{}).include(restRepository{Fork: true}) {
+		t.Error("included a fork without forks")
+	}
+	if (reposFilters{noArchived: true}).include(restRepository{Archived: true}) {
+		t.Error("included an archived repository with noArchived")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// skipReason is why a repository found by the enumeration is not mirrored.
+type skipReason string
+
+const (
+	skipTooLarge skipReason = "too_large"
+	skipInactive skipReason = "inactive"
+)
+
+// skipFilters skip repositories which GitHub search can't leave out for us
+// without losing track of them: they are recorded in the skip report.
+type skipFilters struct {
+	// maxDiskUsage is the largest disk usage in kilobytes of a mirrored
+	// repository, or 0 for no limit.
+	maxDiskUsage int
+
+	// pushedSince skips the repositories without pushes since then, unless
+	// it is zero.
+	pushedSince time.Time
+}
+
+// skippedRepo is an entry of the skip report.
+type skippedRepo struct {
+	NameWithOwner string     `json:"name_with_owner"`
+	URL           string     `json:"url"`
+	Reason        skipReason `json:"reason"`
+	DiskUsage     int        `json:"disk_usage_kb"`
+	PushedAt      time.Time  `json:"pushed_at"`
+}
+
+// reason returns why r is skipped, or "" if it is mirrored.
+func (f skipFilters) reason(r repository) skipReason {
+	if f.maxDiskUsage > 0 && r.DiskUsage > f.maxDiskUsage {
+		return skipTooLarge
+	}
+	// The push time is unknown for repositories from an enumeration state
+	// saved before we asked for it.
+	if !f.pushedSince.IsZero() && !r.PushedAt.IsZero() && r.PushedAt.Before(f.pushedSince) {
+		return skipInactive
+	}
+	return ""
+}
+
+// apply returns the repositories of repos which are mirrored, and the
+// skipped ones.
+func (f skipFilters) apply(repos []repository) ([]repository, []skippedRepo) {
+	var (
+		kept    []repository
+		skipped []skippedRepo
+	)
+	for _, r := range repos {
+		reason := f.reason(r)
+		if reason == "" {
+			kept = append(kept, r)
+			continue
+		}
+		skipped = append(skipped, skippedRepo{
+			NameWithOwner: r.NameWithOwner,
+			URL:           r.URL,
+			Reason:        reason,
+			DiskUsage:     r.DiskUsage,
+			PushedAt:      r.PushedAt,
+		})
+	}
+	return kept, skipped
+}
+
+// writeSkipReport writes skipped to path as JSON lines, replacing the report
+// of a previous run.
+func writeSkipReport(path string, skipped []skippedRepo) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, s := range skipped {
+		if err := enc.Encode(s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSkipFilters(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	repos := []repository{
+		{NameWithOwner: "org/small", DiskUsage: 100, PushedAt: now},
+		{NameWithOwner: "org/huge", DiskUsage: 5 << 20, PushedAt: now},
+		{NameWithOwner: "org/abandoned", DiskUsage: 100, PushedAt: now.AddDate(-2, 0, 0)},
+		// Repos from an old enumeration state have no push time.
+		{NameWithOwner: "org/unknown", DiskUsage: 100},
+	}
+	f := skipFilters{maxDiskUsage: 1 << 20, pushedSince: now.AddDate(0, -6, 0)}
+
+	kept, skipped := f.apply(repos)
+	var names []string
+	for _, r := range kept {
+		names = append(names, r.NameWithOwner)
+	}
+	if d := cmp.Diff([]string{"org/small", "org/unknown"}, names); d != "" {
+		t.Errorf("kept (-want, +got):\n%s", d)
+	}
+	want := []skippedRepo{
+		{NameWithOwner: "org/huge", Reason: skipTooLarge, DiskUsage: 5 << 20, PushedAt: now},
+		{NameWithOwner: "org/abandoned", Reason: skipInactive, DiskUsage: 100, PushedAt: now.AddDate(-2, 0, 0)},
+	}
+	if d := cmp.Diff(want, skipped); d != "" {
+		t.Errorf("skipped (-want, +got):\n%s", d)
+	}
+
+	path := filepath.Join(t.TempDir(), "skipped.jsonl")
+	if err := writeSkipReport(path, skipped); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	var got []skippedRepo
+	for sc := bufio.NewScanner(fd); sc.Scan(); {
+		var s skippedRepo
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("report (-want, +got):\n%s", d)
+	}
+
+	if kept, skipped := (skipFilters{}).apply(repos); len(kept) != len(repos) || len(skipped) != 0 {
+		t.Errorf("without limits got %d kept and %d skipped repos", len(kept), len(skipped))
+	}
+}
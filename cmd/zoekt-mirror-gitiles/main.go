@@ -18,8 +18,8 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -40,12 +40,17 @@ func main() {
 	namePattern := flag.String("name", "", "only clone repos whose name matches the regexp.")
 	excludePattern := flag.String("exclude", "", "don't mirror repos whose names match this regexp.")
 	hostType := flag.String("type", "gitiles", "which webserver to crawl. Choices: gitiles, cgit")
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
 		log.Fatal("must provide URL argument.")
 	}
 
+	// The crawlers use http.Get.
+	http.DefaultClient.Transport = gitindex.NewRateLimitTransport(http.DefaultTransport)
+
 	var crawler hostCrawler
 	switch *hostType {
 	case "gitiles":
@@ -79,6 +84,7 @@ func main() {
 		log.Fatal(err)
 	}
 
+	cloner := gitindex.NewCloner(cloneOpts)
 	for nm, target := range repos {
 		// For git.savannah.gnu.org, this puts an ugly "CGit"
 		// path component into the name. However, it's
@@ -91,12 +97,11 @@ func main() {
 			"zoekt.name":         fullName,
 		}
 
-		dest, err := gitindex.CloneRepo(*dest, fullName, target.cloneURL, config)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if dest != "" {
-			fmt.Println(dest)
+		if err := cloner.Clone(*dest, fullName, target.cloneURL, config); err != nil {
+			break
 		}
 	}
+	if err := cloner.Wait(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -25,7 +25,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"net/url"
 	"os"
@@ -53,6 +52,8 @@ func main() {
 	lastActivityAfter := flag.String("last_activity_after", "", "only mirror repos that have been active since this date (format: 2006-01-02).")
 	noArchived := flag.Bool("no_archived", false, "mirror only projects that are not archived")
 
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
 	flag.Parse()
 
 	if *dest == "" {
@@ -148,7 +149,7 @@ func main() {
 		}
 		gitlabProjects = trimmed
 	}
-	fetchProjects(destDir, apiToken, gitlabProjects)
+	fetchProjects(destDir, apiToken, gitlabProjects, cloneOpts)
 
 	if *deleteRepos {
 		if err := deleteStaleProjects(*dest, filter, gitlabProjects); err != nil {
@@ -180,7 +181,9 @@ func deleteStaleProjects(destDir string, filter *gitindex.Filter, projects []*gi
 	return nil
 }
 
-func fetchProjects(destDir, token string, projects []*gitlab.Project) {
+func fetchProjects(destDir, token string, projects []*gitlab.Project, cloneOpts gitindex.CloneOptions) {
+	cloner := gitindex.NewCloner(cloneOpts)
+	cloner.KeepGoing = true
 	for _, p := range projects {
 		u, err := url.Parse(p.HTTPURLToRepo)
 		if err != nil {
@@ -201,15 +204,9 @@ func fetchProjects(destDir, token string, projects []*gitlab.Project) {
 		}
 
 		cloneURL := p.HTTPURLToRepo
-		dest, err := gitindex.CloneRepo(destDir, p.PathWithNamespace, cloneURL, config)
-		if err != nil {
-			log.Printf("cloneRepos: %v", err)
-			continue
-		}
-		if dest != "" {
-			fmt.Println(dest)
-		}
+		cloner.Clone(destDir, p.PathWithNamespace, cloneURL, config) // nolint:errcheck
 	}
+	cloner.Wait() // nolint:errcheck
 }
 
 func marshalBool(b bool) string {
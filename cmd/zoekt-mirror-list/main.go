@@ -0,0 +1,242 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command zoekt-mirror-list clones the repositories of a list of git clone
+// URLs, for code hosts without a mirror command of their own. The list is a
+// file or an http(s) URL with one repository per line:
+//
+//	https://git.example.com/tools/build.git name=example.com/build branches=main,release priority=10
+//	git@git.example.com:tools/lint.git
+//
+// The URL may be followed by the optional settings
+//
+//	name=NAME         the name of the repository, by default host/path of the URL.
+//	branches=A,B      the branches zoekt-git-index indexes, by default HEAD.
+//	priority=N        the priority of the repository in the ranking.
+//
+// Empty lines and lines starting with # are ignored.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/sourcegraph/zoekt/internal/gitindex"
+)
+
+// listConfigKey is the git config setting recording the list a repository
+// was cloned from, so -delete only deletes the repositories of that list.
+const listConfigKey = "zoekt.mirror-list"
+
+type listEntry struct {
+	cloneURL string
+	name     string
+	branches []string
+	priority string
+
+	// explicitName is set if the name was given in the list.
+	explicitName bool
+}
+
+// scpURLRegexp matches clone URLs like git@host:path.
+var scpURLRegexp = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// nameFromURL returns host/path of a clone URL, without the .git suffix.
+func nameFromURL(cloneURL string) (string, error) {
+	if u, err := url.Parse(cloneURL); err == nil && u.Host != "" {
+		return filepath.Join(u.Host, strings.TrimSuffix(u.Path, ".git")), nil
+	}
+	if m := scpURLRegexp.FindStringSubmatch(cloneURL); m != nil {
+		return filepath.Join(m[1], strings.TrimSuffix(m[2], ".git")), nil
+	}
+	return "", fmt.Errorf("cannot derive a name from %q, set name=", cloneURL)
+}
+
+func parseList(r io.Reader) ([]listEntry, error) {
+	var entries []listEntry
+	names := map[string]int{}
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		e := listEntry{cloneURL: fields[0]}
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || v == "" {
+				return nil, fmt.Errorf("line %d: want key=value, got %q", lineNum, f)
+			}
+			switch k {
+			case "name":
+				e.name = strings.Trim(v, "/")
+				e.explicitName = true
+			case "branches":
+				e.branches = strings.Split(v, ",")
+			case "priority":
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					return nil, fmt.Errorf("line %d: priority must be a number, got %q", lineNum, v)
+				}
+				e.priority = v
+			default:
+				return nil, fmt.Errorf("line %d: unknown setting %q", lineNum, k)
+			}
+		}
+
+		if e.name == "" {
+			name, err := nameFromURL(e.cloneURL)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			e.name = name
+		}
+		if other, ok := names[e.name]; ok {
+			return nil, fmt.Errorf("line %d: repository %s is already on line %d", lineNum, e.name, other)
+		}
+		names[e.name] = lineNum
+
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+func readList(list string) ([]listEntry, error) {
+	var r io.Reader
+	if u, err := url.Parse(list); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		rep, err := http.Get(list)
+		if err != nil {
+			return nil, err
+		}
+		defer rep.Body.Close()
+		if rep.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", list, rep.Status)
+		}
+		r = rep.Body
+	} else {
+		f, err := os.Open(list)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return parseList(r)
+}
+
+// deleteStale deletes the repositories below destDir which were cloned from
+// list, but are no longer on it. names holds the names of the repositories
+// on the list. Like gitindex.DeleteRepos, it keeps the repositories filter
+// excludes.
+func deleteStale(destDir, list string, names map[string]struct{}, filter *gitindex.Filter) error {
+	paths, err := gitindex.ListRepos(destDir, &url.URL{})
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		name := strings.TrimSuffix(p, ".git")
+		if _, ok := names[name]; ok || !filter.Include(name) {
+			continue
+		}
+		repo, err := git.PlainOpen(filepath.Join(destDir, p))
+		if err != nil {
+			continue
+		}
+		cfg, err := repo.Config()
+		if err != nil || cfg.Raw.Section("zoekt").Options.Get("mirror-list") != list {
+			continue
+		}
+		log.Printf("deleting repo %s", p)
+		if err := os.RemoveAll(filepath.Join(destDir, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	dest := flag.String("dest", "", "destination directory")
+	namePattern := flag.String("name", "", "only clone repos whose name matches the regexp.")
+	excludePattern := flag.String("exclude", "", "don't mirror repos whose names match this regexp.")
+	deleteRepos := flag.Bool("delete", false, "delete repos cloned from the list which are no longer on it")
+	var cloneOpts gitindex.CloneOptions
+	cloneOpts.Flags(flag.CommandLine)
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		log.Fatal("must provide the file or URL of the list as argument.")
+	}
+	if *dest == "" {
+		log.Fatal("must set --dest")
+	}
+
+	list := flag.Arg(0)
+	if u, err := url.Parse(list); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		abs, err := filepath.Abs(list)
+		if err != nil {
+			log.Fatal(err)
+		}
+		list = abs
+	}
+
+	entries, err := readList(list)
+	if err != nil {
+		log.Fatalf("readList(%s): %v", list, err)
+	}
+
+	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cloner := gitindex.NewCloner(cloneOpts)
+	cloner.KeepGoing = true
+	names := map[string]struct{}{}
+	for _, e := range entries {
+		if !filter.Include(e.name) {
+			continue
+		}
+		names[e.name] = struct{}{}
+
+		config := map[string]string{
+			listConfigKey:    list,
+			"zoekt.branches": strings.Join(e.branches, ","),
+			"zoekt.priority": e.priority,
+		}
+		// Without a name, the indexer derives it from the clone URL, along
+		// with the links to known code hosts.
+		if e.explicitName {
+			config["zoekt.name"] = e.name
+		}
+		cloner.Clone(*dest, e.name, e.cloneURL, config) // nolint:errcheck
+	}
+	cloner.Wait() // nolint:errcheck
+
+	if *deleteRepos {
+		if err := deleteStale(*dest, list, names, filter); err != nil {
+			log.Fatalf("deleteStale: %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseList(t *testing.T) {
+	list := `
+# Build tools.
+https://git.example.com/tools/build.git name=example.com/build branches=main,release priority=10
+git@git.example.com:tools/lint.git
+ssh://git@git.example.com:2222/tools/fmt
+`
+	got, err := parseList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []listEntry{{
+		cloneURL:     "https://git.example.com/tools/build.git",
+		name:         "example.com/build",
+		branches:     []string{"main", "release"},
+		priority:     "10",
+		explicitName: true,
+	}, {
+		cloneURL: "git@git.example.com:tools/lint.git",
+		name:     "git.example.com/tools/lint",
+	}, {
+		cloneURL: "ssh://git@git.example.com:2222/tools/fmt",
+		name:     "git.example.com:2222/tools/fmt",
+	}}
+	if d := cmp.Diff(want, got, cmp.AllowUnexported(listEntry{})); d != "" {
+		t.Errorf("(-want +got):\n%s", d)
+	}
+}
+
+func TestParseList_errors(t *testing.T) {
+	for _, list := range []string{
+		"https://git.example.com/a.git owner=me",
+		"https://git.example.com/a.git priority=high",
+		"https://git.example.com/a.git name",
+		"/srv/git/a.git",
+		"https://git.example.com/a.git\nhttps://git.example.com/a",
+	} {
+		if _, err := parseList(strings.NewReader(list)); err == nil {
+			t.Errorf("%q: got no error", list)
+		}
+	}
+}
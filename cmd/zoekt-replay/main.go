@@ -0,0 +1,138 @@
+// Command zoekt-replay replays the searches logged by zoekt-webserver with
+// -query_log against an index, and reports the searches whose results
+// changed or which got slower. Use it to validate a new version of zoekt or
+// of the index before rolling it out.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/querylog"
+	"github.com/sourcegraph/zoekt/internal/shards"
+)
+
+func main() {
+	indexDir := flag.String("index", index.DefaultDir, "replay the searches against the shards in this `directory`")
+	slower := flag.Float64("slower", 2, "report searches which take this many times longer than logged")
+	minDuration := flag.Duration("min_duration", 10*time.Millisecond, "don't report searches as slower if they take less than this")
+	maxFiles := flag.Int("max_files", querylog.DefaultMaxFiles, "number of results compared per search; must match the number the webserver logs")
+	verbose := flag.Bool("v", false, "print the added and removed files of changed searches")
+
+	flag.Usage = func() {
+		name := os.Args[0]
+		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] [QUERY_LOG ...]\n\n"+
+			"Replays the query logs, or stdin if none are given.\n\n", name)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	searcher, err := shards.NewDirectorySearcher(*indexDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer searcher.Close()
+
+	var readers []io.Reader
+	for _, fn := range flag.Args() {
+		f, err := os.Open(fn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if len(readers) == 0 {
+		readers = append(readers, os.Stdin)
+	}
+
+	var sum summary
+	r := querylog.NewReader(io.MultiReader(readers...))
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		rp, err := querylog.ReplayEntry(context.Background(), searcher, e, *maxFiles)
+		if err != nil {
+			log.Printf("skipping %q: %v", e.Query, err)
+			sum.skipped++
+			continue
+		}
+		sum.add(rp)
+
+		if rp.Changed(*maxFiles) {
+			sum.changed++
+			fmt.Printf("changed\t%s\t%d -> %d files, +%d -%d\n", e.Query, fileCount(e.Stats), rp.Stats.FileCount, len(rp.Added), len(rp.Removed))
+			if rp.Error != e.Error {
+				fmt.Printf("\terror %q -> %q\n", e.Error, rp.Error)
+			}
+			if *verbose {
+				for _, f := range rp.Added {
+					fmt.Printf("\t+ %s\n", f)
+				}
+				for _, f := range rp.Removed {
+					fmt.Printf("\t- %s\n", f)
+				}
+			}
+		}
+		if rp.Duration >= *minDuration && float64(rp.Duration) >= *slower*float64(e.Duration) {
+			sum.slower++
+			fmt.Printf("slower\t%s\t%v -> %v\n", e.Query, e.Duration, rp.Duration)
+		}
+	}
+
+	fmt.Print(sum.String())
+}
+
+func fileCount(st *zoekt.Stats) int {
+	if st == nil {
+		return 0
+	}
+	return st.FileCount
+}
+
+// summary aggregates the replayed searches.
+type summary struct {
+	replayed, skipped, changed, slower int
+
+	logged, replay []time.Duration
+}
+
+func (s *summary) add(rp *querylog.Replay) {
+	s.replayed++
+	s.logged = append(s.logged, rp.Entry.Duration)
+	s.replay = append(s.replay, rp.Duration)
+}
+
+func (s *summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "replayed %d searches (%d skipped): %d changed, %d slower\n", s.replayed, s.skipped, s.changed, s.slower)
+	if s.replayed == 0 {
+		return b.String()
+	}
+
+	slices.Sort(s.logged)
+	slices.Sort(s.replay)
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		fmt.Fprintf(&b, "p%g\t%v -> %v\n", p*100, percentile(s.logged, p), percentile(s.replay, p))
+	}
+	return b.String()
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)]
+}
@@ -36,6 +36,7 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 	trash := getShards(trashDir)
 	tombtones := getTombstonedRepos(indexDir)
 	indexShards := getShards(indexDir)
+	history := repoHistoryEnabled(indexDir)
 
 	// trash: Remove old shards and conflicts with index
 	minAge := now.Add(-24 * time.Hour)
@@ -118,6 +119,9 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 		if shards, ok := trash[repo]; ok {
 			infoLog.Printf("restoring shards from trash for %v", repo)
 			moveAll(indexDir, shards)
+			if history {
+				recordRestoredRepo(indexDir, shards[0], now)
+			}
 			continue
 		}
 
@@ -138,6 +142,10 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 			_ = os.Chtimes(shard.Path, now, now)
 		}
 
+		if history {
+			recordDeletedRepo(indexDir, shards[0], now)
+		}
+
 		if shardMerging && maybeSetTombstone(shards, repo) {
 			continue
 		}
@@ -167,9 +175,60 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 	metricCleanupDuration.Observe(time.Since(start).Seconds())
 }
 
+// repoHistoryEnabled returns true if the builds in indexDir record the
+// repository history, see index.Options.RepoHistory.
+func repoHistoryEnabled(indexDir string) bool {
+	_, err := os.Stat(filepath.Join(indexDir, index.RepoHistoryFile))
+	return err == nil
+}
+
+// recordDeletedRepo records in the repository history that the repository of
+// s is no longer searchable.
+func recordDeletedRepo(indexDir string, s shard, now time.Time) {
+	err := index.AppendRepoHistory(indexDir, index.RepoSnapshot{
+		Time:     now,
+		Name:     s.RepoName,
+		ID:       s.RepoID,
+		TenantID: s.TenantID,
+		Deleted:  true,
+	})
+	if err != nil {
+		errorLog.Printf("error recording deletion of %v in repository history: %s", s.RepoID, err)
+	}
+}
+
+// recordRestoredRepo records in the repository history that the repository
+// of s, restored from the trash, is searchable again.
+func recordRestoredRepo(indexDir string, s shard, now time.Time) {
+	path := filepath.Join(indexDir, filepath.Base(s.Path))
+	repos, md, err := index.ReadMetadataPathAlive(path)
+	if err != nil {
+		errorLog.Printf("error reading metadata of restored shard %s: %s", path, err)
+		return
+	}
+	for _, r := range repos {
+		if r.ID != s.RepoID {
+			continue
+		}
+		err := index.AppendRepoHistory(indexDir, index.RepoSnapshot{
+			Time:      now,
+			Name:      r.Name,
+			ID:        r.ID,
+			TenantID:  r.TenantID,
+			Branches:  r.Branches,
+			IndexTime: md.IndexTime,
+		})
+		if err != nil {
+			errorLog.Printf("error recording restore of %v in repository history: %s", s.RepoID, err)
+		}
+		return
+	}
+}
+
 type shard struct {
 	RepoID        uint32
 	RepoName      string
+	TenantID      int
 	Path          string
 	ModTime       time.Time
 	RepoTombstone bool
@@ -207,6 +266,7 @@ func getShards(dir string) map[uint32][]shard {
 			shards[repo.ID] = append(shards[repo.ID], shard{
 				RepoID:        repo.ID,
 				RepoName:      repo.Name,
+				TenantID:      repo.TenantID,
 				Path:          path,
 				ModTime:       fi.ModTime(),
 				RepoTombstone: repo.Tombstone,
@@ -245,6 +305,7 @@ func getTombstonedRepos(dir string) map[uint32]shard {
 			m[repo.ID] = shard{
 				RepoID:        repo.ID,
 				RepoName:      repo.Name,
+				TenantID:      repo.TenantID,
 				Path:          p,
 				ModTime:       repo.LatestCommitDate,
 				RepoTombstone: repo.Tombstone,
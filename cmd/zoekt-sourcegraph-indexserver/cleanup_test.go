@@ -196,6 +196,32 @@ func globBase(pattern string) []string {
 	return paths
 }
 
+func TestCleanupRepoHistory(t *testing.T) {
+	dir := t.TempDir()
+	createTestShard(t, "repo1", 1, filepath.Join(dir, "repo1_v16.00000.zoekt"))
+	createTestShard(t, "repo2", 2, filepath.Join(dir, ".trash", "repo2_v16.00000.zoekt"), func(in *zoekt.Repository) {
+		in.Branches = []zoekt.RepositoryBranch{{Name: "main", Version: "abc"}}
+	})
+	if err := index.AppendRepoHistory(dir, index.RepoSnapshot{Time: time.Unix(0, 0), Name: "repo1", ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(100, 0)
+	cleanup(dir, []uint32{2}, now, false)
+
+	snapshots, err := index.ReadRepoHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := index.ReposAt(snapshots, now)
+	if len(repos) != 1 || repos[0].ID != 2 || repos[0].Branches[0].Version != "abc" {
+		t.Errorf("got %v, want only the restored repo2", repos)
+	}
+	if repos := index.ReposAt(snapshots, now.Add(-time.Second)); len(repos) != 1 || repos[0].ID != 1 {
+		t.Errorf("got %v before the cleanup, want repo1", repos)
+	}
+}
+
 func TestRemoveIncompleteShards(t *testing.T) {
 	shards, incomplete := []string{
 		"test.zoekt",
@@ -97,6 +97,10 @@ type indexArgs struct {
 
 	// ShardMerging is true if we want zoekt-git-index to respect compound shards.
 	ShardMerging bool
+
+	// ShardBytesLimit, if positive, is the most bytes the shards of the
+	// repository may take up, which is the rest of the quota of its tenant.
+	ShardBytesLimit int64
 }
 
 // BuildOptions returns a index.Options represented by indexArgs. Note: it
@@ -141,6 +145,8 @@ func (o *indexArgs) BuildOptions() *index.Options {
 		ShardMerging: o.ShardMerging,
 
 		ShardPrefix: shardPrefix,
+
+		ShardBytesLimit: o.ShardBytesLimit,
 	}
 }
 
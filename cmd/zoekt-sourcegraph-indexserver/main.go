@@ -141,6 +141,11 @@ var (
 		Help: "Counts indexings (indexing activity, should be used with rate())",
 	})
 
+	metricQuotaExceededTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "index_quota_exceeded_total",
+		Help: "Counts index jobs rejected because the tenant of the repository is at its quota.",
+	})
+
 	metricNumStoppedTrackingTotal = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "index_num_stopped_tracking_total",
 		Help: "Counts the number of repos we stopped tracking.",
@@ -226,6 +231,12 @@ type Server struct {
 
 	// timeout defines how long the index server waits before killing an indexing job.
 	timeout time.Duration
+
+	// quotas limit the repositories each tenant can index. Nil is unlimited.
+	quotas *tenant.Quotas
+
+	// quotaUsage is what the tenants have indexed, to check their quotas.
+	quotaUsage quotaUsage
 }
 
 var (
@@ -376,6 +387,7 @@ func (s *Server) Run() {
 				s.muIndexDir.Global(func() {
 					cleanup(s.IndexDir, repos.IDs, time.Now(), s.shardMerging)
 				})
+				s.quotaUsage.reset()
 			}()
 
 			repos.IterateIndexOptions(s.queue.AddOrUpdate)
@@ -628,6 +640,10 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		}
 	}
 
+	if err := s.checkQuota(args); err != nil {
+		return indexStateFail, err
+	}
+
 	infoLog.Printf("updating index %s reason=%s", args.String(), reason)
 
 	metricIndexingTotal.Inc()
@@ -646,6 +662,7 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 	if err != nil {
 		return indexStateFail, err
 	}
+	s.quotaUsage.update(args)
 
 	if err := updateIndexStatusOnSourcegraph(c, args, s.Sourcegraph); err != nil {
 		s.logger.Error("failed to update index status",
@@ -756,6 +773,7 @@ func (s *Server) addDebugHandlers(mux *http.ServeMux) {
 	mux.Handle("/debug/merge", http.HandlerFunc(s.handleDebugMerge))
 	mux.Handle("/debug/queue", http.HandlerFunc(s.queue.handleDebugQueue))
 	mux.Handle("/debug/host", http.HandlerFunc(s.handleHost))
+	mux.Handle("/debug/tenants", http.HandlerFunc(s.handleDebugTenants))
 }
 
 func (s *Server) handleHost(w http.ResponseWriter, r *http.Request) {
@@ -1280,6 +1298,9 @@ type rootConfig struct {
 	// config values related to backoff indexing repos with one or more consecutive failures
 	backoffDuration    time.Duration
 	maxBackoffDuration time.Duration
+
+	// tenantQuotas is the path of the JSON file with the quotas of tenants.
+	tenantQuotas string
 }
 
 func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
@@ -1293,6 +1314,8 @@ func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.DurationVar(&rc.backoffDuration, "backoff_duration", getEnvWithDefaultDuration("BACKOFF_DURATION", 10*time.Minute), "for the given duration we backoff from enqueue operations for a repository that's failed its previous indexing attempt. Consecutive failures increase the duration of the delay linearly up to the maxBackoffDuration. A negative value disables indexing backoff.")
 	fs.DurationVar(&rc.maxBackoffDuration, "max_backoff_duration", getEnvWithDefaultDuration("MAX_BACKOFF_DURATION", 120*time.Minute), "the maximum duration to backoff from enqueueing a repo for indexing.  A negative value disables indexing backoff.")
 
+	fs.StringVar(&rc.tenantQuotas, "tenant_quotas", getEnvWithDefaultString("SRC_TENANT_QUOTAS", ""), "the path of a JSON file with the maximum number of repositories and shard bytes of each tenant, eg. {\"default\": {\"max_repos\": 1000}, \"tenants\": {\"42\": {\"max_bytes\": 1073741824}}}. Repositories of tenants at their quota are not indexed.")
+
 	// flags related to shard merging
 	fs.BoolVar(&rc.disableShardMerging, "shard_merging", getEnvWithDefaultBool("SRC_DISABLE_SHARD_MERGING", false), "disable shard merging")
 	fs.DurationVar(&rc.vacuumInterval, "vacuum_interval", getEnvWithDefaultDuration("SRC_VACUUM_INTERVAL", 24*time.Hour), "run vacuum this often")
@@ -1319,6 +1342,7 @@ func startServer(conf rootConfig) error {
 			{Href: "debug/list?indexed=false", Text: "Assigned (this instance)", Description: "list of all repositories that are assigned to this instance"},
 			{Href: "debug/list?indexed=true", Text: "Assigned (all)", Description: "same as above, but includes repositories which this instance temporarily holds during re-balancing"},
 			{Href: "debug/queue", Text: "Indexing Queue State", Description: "list of all repositories in the indexing queue, sorted by descending priority"},
+			{Href: "debug/tenants", Text: "Tenant Quotas", Description: "the indexed repositories and shard bytes of each tenant, and their quotas"},
 		}...)
 		s.addDebugHandlers(mux)
 
@@ -1487,6 +1511,14 @@ func newServer(conf rootConfig) (*Server, error) {
 		conf.indexConcurrency = int64(cpuCount)
 	}
 
+	var quotas *tenant.Quotas
+	if conf.tenantQuotas != "" {
+		quotas, err = tenant.LoadQuotas(conf.tenantQuotas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	q := NewQueue(conf.backoffDuration, conf.maxBackoffDuration, logger)
 
 	return &Server{
@@ -1510,6 +1542,7 @@ func newServer(conf rootConfig) (*Server, error) {
 			minAgeDays:      conf.minAgeDays,
 		},
 		timeout: indexingTimeout,
+		quotas:  quotas,
 	}, err
 }
 
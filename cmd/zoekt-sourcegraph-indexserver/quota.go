@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+// tenantRepoBytes returns the size of the shards of every repository in dir
+// by tenant. The size of a compound shard is split evenly between its
+// repositories.
+func tenantRepoBytes(dir string) (map[int]map[uint32]int64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[int]map[uint32]int64{}
+	for _, path := range paths {
+		addShardBytes(usage, path, nil)
+	}
+	return usage, nil
+}
+
+// addShardBytes adds the size of the shard at path to the repositories in it
+// which keep returns true for, or all of them if keep is nil.
+func addShardBytes(usage map[int]map[uint32]int64, path string, keep func(*zoekt.Repository) bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		debugLog.Printf("stat failed: %v", err)
+		return
+	}
+	repos, _, err := index.ReadMetadataPathAlive(path)
+	if err != nil {
+		debugLog.Printf("failed to read shard: %v", err)
+		return
+	}
+	if len(repos) == 0 {
+		return
+	}
+	share := fi.Size() / int64(len(repos))
+	for _, repo := range repos {
+		if keep != nil && !keep(repo) {
+			continue
+		}
+		if usage[repo.TenantID] == nil {
+			usage[repo.TenantID] = map[uint32]int64{}
+		}
+		usage[repo.TenantID][repo.ID] += share
+	}
+}
+
+// quotaUsage is the running total of the shard bytes of each repository by
+// tenant. It reads the index directory once, and is then updated with the
+// shards of each repository which is indexed. Cleanup, which removes
+// repositories, resets it so the next check reads the directory again.
+type quotaUsage struct {
+	mu        sync.Mutex
+	repoBytes map[int]map[uint32]int64 // nil until read
+}
+
+// get returns a copy of the bytes of the repositories of tenant id, reading
+// dir if necessary.
+func (u *quotaUsage) get(dir string, id int) (map[uint32]int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.load(dir); err != nil {
+		return nil, err
+	}
+	return maps.Clone(u.repoBytes[id]), nil
+}
+
+// all returns a copy of the bytes of all repositories by tenant.
+func (u *quotaUsage) all(dir string) (map[int]map[uint32]int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.load(dir); err != nil {
+		return nil, err
+	}
+	usage := make(map[int]map[uint32]int64, len(u.repoBytes))
+	for id, repoBytes := range u.repoBytes {
+		usage[id] = maps.Clone(repoBytes)
+	}
+	return usage, nil
+}
+
+func (u *quotaUsage) load(dir string) error {
+	if u.repoBytes != nil {
+		return nil
+	}
+	usage, err := tenantRepoBytes(dir)
+	if err != nil {
+		return err
+	}
+	u.repoBytes = usage
+	return nil
+}
+
+// update replaces the bytes of the repository of args by the size of its
+// shards.
+func (u *quotaUsage) update(args *indexArgs) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.repoBytes == nil {
+		return
+	}
+	delete(u.repoBytes[args.TenantID], args.RepoID)
+	for _, path := range args.BuildOptions().FindAllShards() {
+		addShardBytes(u.repoBytes, path, func(r *zoekt.Repository) bool {
+			return r.TenantID == args.TenantID && r.ID == args.RepoID
+		})
+	}
+}
+
+func (u *quotaUsage) reset() {
+	u.mu.Lock()
+	u.repoBytes = nil
+	u.mu.Unlock()
+}
+
+// usageWithout returns the usage of the repositories in repoBytes other than
+// repoID.
+func usageWithout(repoBytes map[uint32]int64, repoID uint32) tenant.Usage {
+	var u tenant.Usage
+	for id, b := range repoBytes {
+		if id == repoID {
+			continue
+		}
+		u.Repos++
+		u.Bytes += b
+	}
+	return u
+}
+
+// checkQuota returns a *tenant.QuotaExceededError if the tenant of args is at
+// its quota, not counting the shards of the repository itself. Otherwise it
+// limits the shards of the repository to the rest of the quota, so the
+// indexer doesn't publish shards which exceed it.
+func (s *Server) checkQuota(args *indexArgs) error {
+	quota := s.quotas.For(args.TenantID)
+	if quota == (tenant.Quota{}) {
+		return nil
+	}
+	repoBytes, err := s.quotaUsage.get(s.IndexDir, args.TenantID)
+	if err != nil {
+		return err
+	}
+	others := usageWithout(repoBytes, args.RepoID)
+	if err := quota.Check(args.TenantID, others); err != nil {
+		metricQuotaExceededTotal.Inc()
+		return err
+	}
+	if quota.MaxBytes > 0 {
+		args.ShardBytesLimit = quota.MaxBytes - others.Bytes
+	}
+	return nil
+}
+
+// handleDebugTenants lists the usage and quota of the tenants with indexed
+// repositories, and whether they are at their quota.
+func (s *Server) handleDebugTenants(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.quotaUsage.all(s.IndexDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]int, 0, len(usage))
+	for id := range usage {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	bw := bytes.Buffer{}
+	tw := tabwriter.NewWriter(&bw, 16, 8, 4, ' ', 0)
+
+	_, err = fmt.Fprintf(tw, "Tenant\tRepos\tMaxRepos\tBytes\tMaxBytes\tStatus\n")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("writing column headers: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	for _, id := range ids {
+		quota := s.quotas.For(id)
+		u := usageWithout(usage[id], 0)
+
+		// The status is whether the tenant can index another repository.
+		status := "ok"
+		if err := quota.Check(id, u); err != nil {
+			status = err.Error()
+		}
+		_, err = fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\n", id, u.Repos, quota.MaxRepos, u.Bytes, quota.MaxBytes, status)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("writing tenant: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		http.Error(w, fmt.Sprintf("flushing tabwriter: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(bw.Len()))
+
+	if _, err := io.Copy(w, &bw); err != nil {
+		http.Error(w, fmt.Sprintf("copying output to response writer: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+func TestCheckQuota(t *testing.T) {
+	dir := t.TempDir()
+	withTenant := func(id int) func(*zoekt.Repository) {
+		return func(r *zoekt.Repository) { r.TenantID = id }
+	}
+	createTestShard(t, "repo1", 1, filepath.Join(dir, "repo1.zoekt"), withTenant(1))
+	createTestShard(t, "repo2", 2, filepath.Join(dir, "repo2.zoekt"), withTenant(1))
+	createTestShard(t, "repo3", 3, filepath.Join(dir, "repo3.zoekt"), withTenant(2))
+
+	usage, err := tenantRepoBytes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage[1]) != 2 || len(usage[2]) != 1 {
+		t.Fatalf("got repositories by tenant %v", usage)
+	}
+
+	s := &Server{
+		IndexDir: dir,
+		quotas:   &tenant.Quotas{Default: tenant.Quota{MaxRepos: 2}},
+	}
+	cases := []struct {
+		tenantID  int
+		repoID    uint32
+		wantError bool
+	}{
+		// Reindexing a repository of a tenant at its quota.
+		{tenantID: 1, repoID: 1},
+		// A new repository of a tenant at its quota.
+		{tenantID: 1, repoID: 4, wantError: true},
+		{tenantID: 2, repoID: 4},
+	}
+	for _, tc := range cases {
+		err := s.checkQuota(&indexArgs{IndexOptions: IndexOptions{TenantID: tc.tenantID, RepoID: tc.repoID}})
+		var qe *tenant.QuotaExceededError
+		if got := errors.As(err, &qe); got != tc.wantError {
+			t.Errorf("tenant %d repo %d: got error %v, want quota error %t", tc.tenantID, tc.repoID, err, tc.wantError)
+		}
+	}
+
+	// The usage is a running total, which is only updated for the
+	// repositories which are indexed, until cleanup resets it.
+	checkNew := func() error {
+		return s.checkQuota(&indexArgs{IndexOptions: IndexOptions{TenantID: 2, RepoID: 6}})
+	}
+	createTestShard(t, "repo5", 5, filepath.Join(dir, "repo5_v16.00000.zoekt"), withTenant(2))
+	if err := checkNew(); err != nil {
+		t.Errorf("got %v before the usage is updated", err)
+	}
+	s.quotaUsage.update(&indexArgs{IndexOptions: IndexOptions{TenantID: 2, RepoID: 5, Name: "repo5"}, IndexDir: dir})
+	if err := checkNew(); err == nil {
+		t.Error("got no error once the indexed repository is counted")
+	}
+	s.quotaUsage.reset()
+	if err := checkNew(); err == nil {
+		t.Error("got no error after rereading the index directory")
+	}
+
+	// The shards of a repository are limited to the rest of the quota.
+	fi, err := os.Stat(filepath.Join(dir, "repo2.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.quotas = &tenant.Quotas{Default: tenant.Quota{MaxBytes: 1 << 20}}
+	args := &indexArgs{IndexOptions: IndexOptions{TenantID: 1, RepoID: 1}}
+	if err := s.checkQuota(args); err != nil {
+		t.Fatal(err)
+	}
+	if want := 1<<20 - fi.Size(); args.ShardBytesLimit != want {
+		t.Errorf("got shard bytes limit %d, want %d", args.ShardBytesLimit, want)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sourcegraph/zoekt/internal/admission"
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/experiments"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/web"
+)
+
+var metricConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_webserver_config_reloads_total",
+	Help: "The number of times the --config file was reloaded, by result.",
+}, []string{"result"})
+
+// configReloadDelay is how long we wait for changes to the directory of the
+// config file to settle before reloading it, like templateReloadDelay.
+const configReloadDelay = 200 * time.Millisecond
+
+// config holds the settings which can change while the webserver is running.
+// They default to the flags of the same names, which the keys of the --config
+// file override.
+type config struct {
+	MaxConcurrentRequests int           `yaml:"max_concurrent_requests"`
+	MaxQueuedRequests     int           `yaml:"max_queued_requests"`
+	MaxQueueTime          time.Duration `yaml:"max_queue_time"`
+	RequestTimeout        time.Duration `yaml:"request_timeout"`
+	ShutdownGracePeriod   time.Duration `yaml:"shutdown_grace_period"`
+
+	ExportMaxResults int     `yaml:"export_max_results"`
+	ExportPerMinute  float64 `yaml:"export_per_minute"`
+
+	Print             bool     `yaml:"print"`
+	TemplateDir       string   `yaml:"template_dir"`
+	HostCustomization string   `yaml:"host_customization"`
+	QueryRewriteRules string   `yaml:"query_rewrite_rules"`
+	QueryTemplates    string   `yaml:"query_templates"`
+	Experiments       []string `yaml:"experiments"`
+
+	AuthBasicFile string `yaml:"auth_basic_file"`
+}
+
+// loadConfig returns the config in the YAML or JSON file path, with the
+// settings it doesn't contain taken from flags. It also returns the content of
+// the file.
+func loadConfig(path string, flags config) (config, []byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, nil, err
+	}
+
+	cfg := flags
+	cfg.Experiments = nil
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return config{}, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Experiments == nil {
+		cfg.Experiments = flags.Experiments
+	}
+	return cfg, b, nil
+}
+
+// admissionOptions returns the options of the request limiter for cfg.
+func (cfg *config) admissionOptions() admission.Options {
+	return admission.Options{
+		MaxConcurrent: cfg.MaxConcurrentRequests,
+		MaxQueue:      cfg.MaxQueuedRequests,
+		MaxQueueTime:  cfg.MaxQueueTime,
+		Timeout:       cfg.RequestTimeout,
+		// Health checks, metrics and debug pages must work when the server
+		// is overloaded.
+		Exempt: []string{"/healthz", "/metrics", "/debug", "/vars", "/gc", "/freeosmemory", "/freeze", "/indexserver/"},
+	}
+}
+
+// parseHostCustomization parses the host_customization setting, a comma
+// separated list of host=query pairs.
+func parseHostCustomization(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	queries := map[string]string{}
+	for _, h := range strings.Split(s, ",") {
+		if len(h) == 0 {
+			continue
+		}
+		host, q, ok := strings.Cut(h, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid host_customization %q", h)
+		}
+		queries[host] = q
+	}
+	return queries, nil
+}
+
+// configurable applies configs to the parts of a running webserver.
+type configurable struct {
+	server      *web.Server
+	experiments *experiments.Searcher
+
+	// limiter is nil if requests are not limited and can't be.
+	limiter *admission.Limiter
+
+	// basic is nil if basic auth is disabled. Its users can change, but
+	// enabling or disabling it requires a restart.
+	basic *auth.BasicAuth
+
+	mu            sync.Mutex
+	applied       bool
+	current       config
+	exportLimiter *rate.Limiter
+	templates     *templateWatcher
+}
+
+// config returns the config which is in effect.
+func (c *configurable) config() config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// apply replaces the settings of the webserver with those of cfg. Everything
+// which can fail is prepared before anything changes, so if apply returns an
+// error, the previous config stays in effect.
+func (c *configurable) apply(cfg config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (cfg.AuthBasicFile != "") != (c.basic != nil) {
+		return errors.New("enabling or disabling auth_basic_file requires a restart")
+	}
+	for _, name := range cfg.Experiments {
+		if _, ok := experiments.Flags[name]; !ok {
+			return fmt.Errorf("unknown experiment flag %q", name)
+		}
+	}
+
+	hostQueries, err := parseHostCustomization(cfg.HostCustomization)
+	if err != nil {
+		return err
+	}
+
+	var rewriter *query.Rewriter
+	if cfg.QueryRewriteRules != "" {
+		if rewriter, err = query.LoadRewriter(cfg.QueryRewriteRules); err != nil {
+			return err
+		}
+	}
+
+	var queryTemplates *query.Templates
+	if cfg.QueryTemplates != "" {
+		if queryTemplates, err = query.LoadTemplates(cfg.QueryTemplates); err != nil {
+			return err
+		}
+	}
+
+	top, err := parseTemplates(cfg.TemplateDir)
+	if err != nil {
+		return fmt.Errorf("loadTemplates: %w", err)
+	}
+
+	var users *auth.BasicAuth
+	if cfg.AuthBasicFile != "" {
+		if users, err = auth.LoadBasicAuth(cfg.AuthBasicFile); err != nil {
+			return err
+		}
+	}
+
+	exportLimiter := c.exportLimiter
+	if !c.applied || cfg.ExportPerMinute != c.current.ExportPerMinute {
+		exportLimiter = nil
+		if cfg.ExportPerMinute > 0 {
+			exportLimiter = rate.NewLimiter(rate.Limit(cfg.ExportPerMinute/60), max(1, int(cfg.ExportPerMinute)))
+		}
+	}
+
+	if err := c.setTemplates(cfg.TemplateDir, top); err != nil {
+		return fmt.Errorf("watching templates: %w", err)
+	}
+	c.server.SetSettings(web.Settings{
+		Print:             cfg.Print,
+		HostCustomQueries: hostQueries,
+		QueryRewriter:     rewriter,
+		QueryTemplates:    queryTemplates,
+		ExportMaxResults:  cfg.ExportMaxResults,
+		ExportLimiter:     exportLimiter,
+	})
+	if c.limiter != nil {
+		c.limiter.SetOptions(cfg.admissionOptions())
+	}
+	// The flags were checked above.
+	_ = c.experiments.SetDefaults(cfg.Experiments)
+	if users != nil {
+		c.basic.SetUsers(users)
+	}
+
+	c.applied = true
+	c.current = cfg
+	c.exportLimiter = exportLimiter
+	return nil
+}
+
+// setTemplates serves top, and watches dir for changes to the templates. The
+// watcher is started once a template directory is configured.
+func (c *configurable) setTemplates(dir string, top *template.Template) error {
+	if c.templates == nil {
+		if dir == "" {
+			return c.server.SetTemplates(top)
+		}
+		w, err := newTemplateWatcher(c.server)
+		if err != nil {
+			return err
+		}
+		c.templates = w
+	}
+	return c.templates.setDir(dir, top)
+}
+
+// watchConfig applies the config file path, with the defaults in flags,
+// whenever it changes and on SIGHUP, which also rereads the files it refers
+// to. If the changed config is invalid, c keeps the previous one.
+func watchConfig(c *configurable, path string, flags config, last []byte) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watching the directory catches editors and Kubernetes replacing the
+	// file instead of writing to it. Kubernetes replaces the ..data symlink
+	// the file links to.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, unix.SIGHUP)
+
+	// last is the content we last tried to apply, so an invalid config is
+	// only reported once.
+	reload := func(force bool) {
+		cfg, b, err := loadConfig(path, flags)
+		if err == nil {
+			if !force && bytes.Equal(b, last) {
+				return
+			}
+			last = b
+			if err = c.apply(cfg); err != nil {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if err != nil {
+			metricConfigReloads.WithLabelValues("error").Inc()
+			log.Printf("reloading config: %v", err)
+			return
+		}
+		metricConfigReloads.WithLabelValues("success").Inc()
+		log.Printf("reloaded config from %s", path)
+	}
+
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if name := filepath.Base(event.Name); name == filepath.Base(path) || name == "..data" {
+					settled = time.After(configReloadDelay)
+				}
+
+			case <-settled:
+				settled = nil
+				reload(false)
+
+			case <-hup:
+				reload(true)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("config watcher: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
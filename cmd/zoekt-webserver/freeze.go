@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	sglog "github.com/sourcegraph/log"
+
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/shards"
+)
+
+// freezeHandler serves the freeze state of the shards of f. GET returns it,
+// and a POST with frozen=true or frozen=false changes it, so the loaded
+// shards can be pinned without a restart.
+func freezeHandler(f shards.ShardFreezer, logger sglog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			frozen, err := strconv.ParseBool(r.FormValue("frozen"))
+			if err != nil {
+				http.Error(w, "frozen must be true or false", http.StatusBadRequest)
+				return
+			}
+			f.FreezeShards(frozen)
+
+			fields := []sglog.Field{sglog.Bool("frozen", frozen)}
+			if id, ok := auth.FromContext(r.Context()); ok {
+				fields = append(fields, sglog.String("actor", id.Subject), sglog.String("actor.provider", id.Provider))
+			}
+			logger.Info("shards freeze changed", fields...)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct{ Frozen bool }{f.ShardsFrozen()})
+	})
+}
@@ -22,11 +22,19 @@ func (s *samplingSender) Send(event *zoekt.SearchResult) {
 	// We don't want to send events over the wire if they don't contain file
 	// matches. Hence, in case we didn't find any results, we aggregate the stats
 	// and send them out in regular intervals.
-	if len(event.Files) == 0 {
+	// Progress events are already sent at the interval the client asked for.
+	if event.IsProgressEvent() {
+		s.next.Send(event)
+		return
+	}
+
+	// The final event has to be sent even if no files matched.
+	if len(event.Files) == 0 && !event.Final {
 		s.aggCount++
 
 		s.agg.Stats.Add(event.Stats)
 		s.agg.Progress = event.Progress
+		s.addResultSet(event.ResultSet)
 
 		if s.aggCount%100 == 0 && !s.agg.Stats.Zero() {
 			s.next.Send(&s.agg)
@@ -39,23 +47,40 @@ func (s *samplingSender) Send(event *zoekt.SearchResult) {
 	// If we have aggregate stats, we merge them with the new event before sending
 	// it. We drop agg.Progress, because we assume that event.Progress reflects the
 	// latest status.
-	if !s.agg.Stats.Zero() {
+	if !s.agg.Stats.Zero() || s.agg.ResultSet != nil {
 		event.Stats.Add(s.agg.Stats)
+		if s.agg.ResultSet != nil {
+			s.agg.ResultSet.Add(event.ResultSet)
+			event.ResultSet = s.agg.ResultSet
+		}
 		s.agg = zoekt.SearchResult{}
 	}
 
 	s.next.Send(event)
 }
 
+// addResultSet adds the documents of an event without files to the aggregate.
+// They are sent with the next stats.
+func (s *samplingSender) addResultSet(r *zoekt.ResultSet) {
+	if r == nil {
+		return
+	}
+	if s.agg.ResultSet == nil {
+		s.agg.ResultSet = &zoekt.ResultSet{}
+	}
+	s.agg.ResultSet.Add(r)
+}
+
 // Flush sends any aggregated stats that we haven't sent yet
 func (s *samplingSender) Flush() {
-	if !s.agg.Stats.Zero() {
+	if !s.agg.Stats.Zero() || s.agg.ResultSet != nil {
 		s.next.Send(&zoekt.SearchResult{
 			Stats: s.agg.Stats,
 			Progress: zoekt.Progress{
 				Priority:           math.Inf(-1),
 				MaxPendingPriority: math.Inf(-1),
 			},
+			ResultSet: s.agg.ResultSet,
 		})
 	}
 }
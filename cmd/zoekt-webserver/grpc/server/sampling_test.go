@@ -31,6 +31,9 @@ func TestSamplingStream(t *testing.T) {
 		}
 		return res
 	}
+	progressEvent := &zoekt.SearchResult{
+		Progress: zoekt.Progress{ShardsCompleted: 1, ShardsTotal: 2},
+	}
 	cases := []struct {
 		events           []*zoekt.SearchResult
 		beforeFlushCount int
@@ -49,6 +52,8 @@ func TestSamplingStream(t *testing.T) {
 		{append(fileEvents(1), statsEvents(100)...), 2, 2},
 		{statsEvents(500), 5, 5},
 		{statsEvents(501), 5, 6},
+		{[]*zoekt.SearchResult{progressEvent, progressEvent}, 2, 2},
+		{append(statsEvents(1), progressEvent), 1, 2},
 	}
 
 	for _, tc := range cases {
@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
+	"io"
 	"math"
+	"os"
 
+	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/grpc/chunk"
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"google.golang.org/grpc/codes"
@@ -30,7 +34,12 @@ func (s *Server) Search(ctx context.Context, req *proto.SearchRequest) (*proto.S
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	res, err := s.streamer.Search(ctx, q, zoekt.SearchOptionsFromProto(req.GetOpts()))
+	opts, err := zoekt.SearchOptionsFromProto(req.GetOpts())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, err := s.streamer.Search(ctx, q, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -46,10 +55,15 @@ func (s *Server) StreamSearch(req *proto.StreamSearchRequest, ss proto.Webserver
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	opts, err := zoekt.SearchOptionsFromProto(request.GetOpts())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	sender := gRPCChunkSender(ss)
 	sampler := newSamplingSender(sender)
 
-	err = s.streamer.StreamSearch(ss.Context(), q, zoekt.SearchOptionsFromProto(request.GetOpts()), sampler)
+	err = s.streamer.StreamSearch(ss.Context(), q, opts, sampler)
 	if err == nil {
 		sampler.Flush()
 	}
@@ -70,6 +84,219 @@ func (s *Server) List(ctx context.Context, req *proto.ListRequest) (*proto.ListR
 	return repoList.ToProto(), nil
 }
 
+// StreamList sends the repositories of List in chunks, as the searcher
+// finds them if it implements zoekt.ListStreamer. Each entry is converted to
+// its protobuf message only when it is added to a chunk, and sending a chunk
+// blocks until the client has room for it, so a slow client doesn't make the
+// server buffer the whole list.
+func (s *Server) StreamList(req *proto.ListRequest, ss proto.WebserverService_StreamListServer) error {
+	q, err := query.QFromProto(req.GetQuery())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Every item holds a single entry, and a chunk is sent as the union of
+	// its items.
+	chunker := chunk.New(func(items []*proto.ListResponse) error {
+		resp := &proto.ListResponse{}
+		for _, item := range items {
+			resp.Repos = append(resp.Repos, item.GetRepos()...)
+			for id, mle := range item.GetReposMap() {
+				if resp.ReposMap == nil {
+					resp.ReposMap = make(map[uint32]*proto.MinimalRepoListEntry)
+				}
+				resp.ReposMap[id] = mle
+			}
+		}
+		return ss.Send(&proto.StreamListResponse{ResponseChunk: resp})
+	})
+
+	totals, err := zoekt.StreamList(ss.Context(), s.streamer, q, zoekt.ListOptionsFromProto(req.GetOpts()), func(repoList *zoekt.RepoList) error {
+		for _, repo := range repoList.Repos {
+			if err := chunker.Send(&proto.ListResponse{Repos: []*proto.RepoListEntry{repo.ToProto()}}); err != nil {
+				return err
+			}
+		}
+		for id, mle := range repoList.ReposMap {
+			if err := chunker.Send(&proto.ListResponse{ReposMap: map[uint32]*proto.MinimalRepoListEntry{id: mle.ToProto()}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := chunker.Flush(); err != nil {
+		return err
+	}
+
+	return ss.Send(&proto.StreamListResponse{
+		ResponseChunk: &proto.ListResponse{
+			Crashes: int64(totals.Crashes),
+			Stats:   totals.Stats.ToProto(),
+		},
+	})
+}
+
+func (s *Server) Definitions(ctx context.Context, req *proto.DefinitionsRequest) (*proto.DefinitionsResponse, error) {
+	ds, ok := s.streamer.(zoekt.DefinitionSearcher)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "definition lookups are not supported")
+	}
+
+	defs, err := ds.Definitions(ctx, req.GetName(), zoekt.DefinitionOptionsFromProto(req.GetOpts()))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.DefinitionsResponse{
+		Definitions: make([]*proto.Definition, 0, len(defs)),
+	}
+	for _, d := range defs {
+		resp.Definitions = append(resp.Definitions, d.ToProto())
+	}
+	return resp, nil
+}
+
+func (s *Server) Document(ctx context.Context, req *proto.DocumentRequest) (*proto.DocumentResponse, error) {
+	var q query.Q
+	if req.GetQuery() != nil {
+		var err error
+		q, err = query.QFromProto(req.GetQuery())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	doc, err := zoekt.Document(ctx, s.streamer, req.GetRepository(), string(req.GetFileName()), req.GetBranch(), q)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, status.Error(codes.NotFound, "document not found")
+	}
+	return doc.ToProto(), nil
+}
+
+func (s *Server) Contents(ctx context.Context, req *proto.ContentsRequest) (*proto.ContentsResponse, error) {
+	ranges := make([]zoekt.LineRange, 0, len(req.GetRanges()))
+	for _, r := range req.GetRanges() {
+		ranges = append(ranges, zoekt.LineRangeFromProto(r))
+	}
+
+	res, err := zoekt.Contents(ctx, s.streamer, req.GetRepository(), string(req.GetFileName()), req.GetBranch(), ranges)
+	switch {
+	case errors.Is(err, zoekt.ErrInvalidLineRange):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, err
+	case res == nil:
+		return nil, status.Error(codes.NotFound, "document not found")
+	}
+	return res.ToProto(), nil
+}
+
+func (s *Server) UpdateRepositoryMetadata(ctx context.Context, req *proto.UpdateRepositoryMetadataRequest) (*proto.UpdateRepositoryMetadataResponse, error) {
+	if req.GetRepository() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing repository")
+	}
+
+	repo, err := zoekt.UpdateRepositoryMetadata(ctx, s.streamer, req.GetRepository(), zoekt.RepositoryMetadataUpdateFromProto(req))
+	switch {
+	case errors.Is(err, zoekt.ErrRepositoryNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, zoekt.ErrInvalidMetadataUpdate):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errors.ErrUnsupported):
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	case err != nil:
+		return nil, err
+	}
+	return &proto.UpdateRepositoryMetadataResponse{Repository: repo.ToProto()}, nil
+}
+
+// fetchShardsChunkSize is the most shard data sent in one FetchShardsResponse.
+const fetchShardsChunkSize = 1 << 20
+
+func (s *Server) FetchShards(req *proto.FetchShardsRequest, ss proto.WebserverService_FetchShardsServer) error {
+	repos, err := regexp.Compile(req.GetRepos())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx := ss.Context()
+	files, err := zoekt.ShardFiles(ctx, s.streamer, repos)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case err != nil:
+		return err
+	}
+
+	have := make(map[string]zoekt.ShardFile, len(req.GetHave()))
+	for _, f := range req.GetHave() {
+		have[f.GetName()] = zoekt.ShardFileFromProto(f)
+	}
+
+	for _, f := range files {
+		if h, ok := have[f.Name]; ok && h.Size == f.Size && h.ModTime.Equal(f.ModTime) {
+			if err := ss.Send(&proto.FetchShardsResponse{File: f.ToProto(), Unchanged: true}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := sendShardFile(ctx, s.streamer, f.Name, ss); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendShardFile sends the file name as a response with the file followed by
+// responses with its data. Files deleted since they were listed are skipped.
+func sendShardFile(ctx context.Context, s zoekt.Streamer, name string, ss proto.WebserverService_FetchShardsServer) error {
+	r, f, err := zoekt.OpenShardFile(ctx, s, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := ss.Send(&proto.FetchShardsResponse{File: f.ToProto()}); err != nil {
+		return err
+	}
+	buf := make([]byte, fetchShardsChunkSize)
+	for sent := int64(0); sent < f.Size; {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), f.Size-sent)])
+		if err != nil {
+			return status.Errorf(codes.Internal, "reading %s: %v", name, err)
+		}
+		if err := ss.Send(&proto.FetchShardsResponse{Data: buf[:n]}); err != nil {
+			return err
+		}
+		sent += int64(n)
+	}
+	return nil
+}
+
+func (s *Server) Count(ctx context.Context, req *proto.CountRequest) (*proto.CountResponse, error) {
+	q, err := query.QFromProto(req.GetQuery())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, err := zoekt.Count(ctx, s.streamer, q, zoekt.CountOptionsFromProto(req.GetOpts()))
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	case err != nil:
+		return nil, err
+	}
+	return res.ToProto(), nil
+}
+
 // gRPCChunkSender is a zoekt.Sender that sends small chunks of FileMatches to the provided gRPC stream.
 func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender {
 	f := func(r *zoekt.SearchResult) {
@@ -91,9 +318,11 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 			numFilesSent += len(filesChunk)
 
 			var stats *proto.Stats
-			if !statsSent { // We only send stats back on the first chunk
+			var resultSet []byte
+			if !statsSent { // We only send stats and the result set back on the first chunk
 				statsSent = true
 				stats = result.GetStats()
+				resultSet = result.GetResultSet()
 			}
 
 			progress := result.GetProgress()
@@ -117,6 +346,11 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 
 					Stats:    stats,
 					Progress: progress,
+
+					ResultSet: resultSet,
+
+					// Every chunk of the final result is final.
+					Final: result.GetFinal(),
 				},
 			})
 		}
@@ -1,23 +1,31 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"go.uber.org/atomic"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 
@@ -47,6 +55,18 @@ func TestClientServer(t *testing.T) {
 				},
 			},
 		},
+
+		WantDefinitions: "Server",
+		DefinitionList: []zoekt.Definition{
+			{
+				Repository: "foo/bar",
+				FileName:   "server.go",
+				Branches:   []string{"main"},
+				Language:   "Go",
+				Symbol:     zoekt.Symbol{Sym: "Server", Kind: "struct"},
+				Start:      zoekt.Location{ByteOffset: 18, LineNumber: 3, Column: 6},
+			},
+		},
 	}
 
 	gs := grpc.NewServer()
@@ -85,6 +105,18 @@ func TestClientServer(t *testing.T) {
 		t.Fatalf("got %+v, want %+v", l, mock.RepoList.ToProto())
 	}
 
+	d, err := client.Definitions(context.Background(), &v1.DefinitionsRequest{Name: mock.WantDefinitions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defs []zoekt.Definition
+	for _, def := range d.GetDefinitions() {
+		defs = append(defs, zoekt.DefinitionFromProto(def))
+	}
+	if diff := cmp.Diff(mock.DefinitionList, defs); diff != "" {
+		t.Fatalf("unexpected difference in definitions (-want +got):\n%s", diff)
+	}
+
 	request := v1.StreamSearchRequest{
 		Request: &v1.SearchRequest{Query: query.QToProto(mock.WantSearch)},
 	}
@@ -107,6 +139,443 @@ func TestClientServer(t *testing.T) {
 	}
 }
 
+func TestDocument(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("main.go"), mustParse("needle")),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{{
+				Repository: "foo/bar",
+				FileName:   "main.go",
+				Content:    []byte("needle\n"),
+				ChunkMatches: []zoekt.ChunkMatch{{
+					Ranges: []zoekt.Range{{Start: zoekt.Location{ByteOffset: 0}, End: zoekt.Location{ByteOffset: 6}}},
+				}},
+			}},
+		},
+	}
+
+	gs := grpc.NewServer()
+	defer gs.Stop()
+
+	v1.RegisterWebserverServiceServer(gs, NewServer(adapter{mock}))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	client := v1.NewWebserverServiceClient(cc)
+
+	d, err := client.Document(context.Background(), &v1.DocumentRequest{
+		Repository: "foo/bar",
+		FileName:   []byte("main.go"),
+		Query:      query.QToProto(mustParse("needle")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.DocumentResult{
+		Repository: "foo/bar",
+		FileName:   "main.go",
+		Content:    []byte("needle\n"),
+		Matches:    mock.SearchResult.Files[0].ChunkMatches[0].Ranges,
+	}
+	if diff := cmp.Diff(want, zoekt.DocumentResultFromProto(d)); diff != "" {
+		t.Fatalf("unexpected difference in document (-want +got):\n%s", diff)
+	}
+
+	mock.WantSearch = query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("main.go"))
+	mock.SearchResult = &zoekt.SearchResult{}
+	_, err = client.Document(context.Background(), &v1.DocumentRequest{
+		Repository: "foo/bar",
+		FileName:   []byte("main.go"),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got error %v, want NotFound", err)
+	}
+}
+
+func TestContents(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("main.go"), &query.Branch{Pattern: "main", Exact: true}),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{{
+				Repository: "foo/bar",
+				FileName:   "main.go",
+				Branches:   []string{"main"},
+				Content:    []byte("one\ntwo\nthree\nfour\n"),
+			}},
+		},
+	}
+
+	gs := grpc.NewServer()
+	defer gs.Stop()
+
+	v1.RegisterWebserverServiceServer(gs, NewServer(adapter{mock}))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	client := v1.NewWebserverServiceClient(cc)
+
+	c, err := client.Contents(context.Background(), &v1.ContentsRequest{
+		Repository: "foo/bar",
+		FileName:   []byte("main.go"),
+		Branch:     "main",
+		Ranges: []*v1.LineRange{
+			{Start: 2, End: 2},
+			{Start: 3, End: 10},
+			{Start: 5, End: 6},
+			{Start: 1, End: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.ContentsResult{
+		Repository: "foo/bar",
+		FileName:   "main.go",
+		Branches:   []string{"main"},
+		LineCount:  4,
+		Ranges: []zoekt.LineRangeContent{
+			{Start: 2, End: 2, Content: []byte("two\n")},
+			{Start: 3, End: 4, Content: []byte("three\nfour\n")},
+			{Start: 1, End: 2, Content: []byte("one\ntwo\n")},
+		},
+	}
+	if diff := cmp.Diff(want, zoekt.ContentsResultFromProto(c)); diff != "" {
+		t.Fatalf("unexpected difference in contents (-want +got):\n%s", diff)
+	}
+
+	_, err = client.Contents(context.Background(), &v1.ContentsRequest{
+		Repository: "foo/bar",
+		FileName:   []byte("main.go"),
+		Branch:     "main",
+		Ranges:     []*v1.LineRange{{Start: 3, End: 2}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, want InvalidArgument", err)
+	}
+
+	mock.SearchResult = &zoekt.SearchResult{}
+	_, err = client.Contents(context.Background(), &v1.ContentsRequest{
+		Repository: "foo/bar",
+		FileName:   []byte("main.go"),
+		Branch:     "main",
+		Ranges:     []*v1.LineRange{{Start: 1, End: 1}},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got error %v, want NotFound", err)
+	}
+}
+
+func TestSearchInvalidWithin(t *testing.T) {
+	gs := grpc.NewServer()
+	defer gs.Stop()
+
+	v1.RegisterWebserverServiceServer(gs, NewServer(adapter{&mockSearcher.MockSearcher{}}))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	client := v1.NewWebserverServiceClient(cc)
+	req := &v1.SearchRequest{
+		Query: query.QToProto(&query.Substring{Pattern: "needle"}),
+		Opts:  &v1.SearchOptions{Within: []byte("not a result set")},
+	}
+	if _, err := client.Search(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Search: got error %v, want InvalidArgument", err)
+	}
+
+	stream, err := client.StreamSearch(context.Background(), &v1.StreamSearchRequest{Request: req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("StreamSearch: got error %v, want InvalidArgument", err)
+	}
+}
+
+type shardFetcher struct {
+	adapter
+	files map[string][]byte
+	mtime time.Time
+}
+
+func (s *shardFetcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	var files []zoekt.ShardFile
+	for name, data := range s.files {
+		if repos.MatchString(name) {
+			files = append(files, zoekt.ShardFile{Name: name, Size: int64(len(data)), ModTime: s.mtime})
+		}
+	}
+	slices.SortFunc(files, func(a, b zoekt.ShardFile) int { return strings.Compare(a.Name, b.Name) })
+	return files, nil
+}
+
+func (s *shardFetcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	data, ok := s.files[name]
+	if !ok {
+		return nil, zoekt.ShardFile{}, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), zoekt.ShardFile{Name: name, Size: int64(len(data)), ModTime: s.mtime}, nil
+}
+
+func TestFetchShards(t *testing.T) {
+	fetcher := &shardFetcher{
+		adapter: adapter{&mockSearcher.MockSearcher{}},
+		files: map[string][]byte{
+			"a_v16.00000.zoekt":      bytes.Repeat([]byte("a"), fetchShardsChunkSize+10),
+			"a_v16.00000.zoekt.meta": []byte("{}"),
+			"b_v16.00000.zoekt":      []byte("b"),
+			"c_v16.00000.zoekt":      []byte("c"),
+		},
+		mtime: time.Unix(1000, 0),
+	}
+
+	gs := grpc.NewServer()
+	defer gs.Stop()
+
+	v1.RegisterWebserverServiceServer(gs, NewServer(fetcher))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	client := v1.NewWebserverServiceClient(cc)
+
+	fetch := func(req *v1.FetchShardsRequest) ([]*v1.FetchShardsResponse, error) {
+		stream, err := client.FetchShards(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		var responses []*v1.FetchShardsResponse
+		for {
+			r, err := stream.Recv()
+			if err == io.EOF {
+				return responses, nil
+			} else if err != nil {
+				return nil, err
+			}
+			responses = append(responses, r)
+		}
+	}
+
+	responses, err := fetch(&v1.FetchShardsRequest{
+		Repos: "^[ab]_",
+		Have: []*v1.ShardFile{
+			zoekt.ShardFile{Name: "b_v16.00000.zoekt", Size: 1, ModTime: fetcher.mtime}.ToProto(),
+			zoekt.ShardFile{Name: "a_v16.00000.zoekt.meta", Size: 2, ModTime: time.Unix(999, 0)}.ToProto(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type received struct {
+		Name      string
+		Unchanged bool
+		Size      int
+		Chunks    int
+	}
+	var got []received
+	for _, r := range responses {
+		if r.GetFile() != nil {
+			got = append(got, received{Name: r.GetFile().GetName(), Unchanged: r.GetUnchanged()})
+			continue
+		}
+		got[len(got)-1].Size += len(r.GetData())
+		got[len(got)-1].Chunks++
+	}
+	want := []received{
+		{Name: "a_v16.00000.zoekt", Size: fetchShardsChunkSize + 10, Chunks: 2},
+		{Name: "a_v16.00000.zoekt.meta", Size: 2, Chunks: 1},
+		{Name: "b_v16.00000.zoekt", Unchanged: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected responses (-want +got):\n%s", diff)
+	}
+
+	if _, err := fetch(&v1.FetchShardsRequest{Repos: "("}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, want InvalidArgument", err)
+	}
+}
+
+type countSearcher struct {
+	*mockSearcher.MockSearcher
+	want query.Q
+}
+
+func (s *countSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	if q.String() != s.want.String() {
+		return nil, fmt.Errorf("got query %s, want %s", q, s.want)
+	}
+	return &zoekt.CountResult{MatchCount: 5, FileCount: 2, RepoCount: 1, Estimated: opts.Estimate}, nil
+}
+
+func TestCount(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		searcher zoekt.Searcher
+		want     *zoekt.CountResult
+		wantCode codes.Code
+	}{{
+		name:     "count",
+		searcher: &countSearcher{MockSearcher: &mockSearcher.MockSearcher{}, want: mustParse("needle")},
+		want:     &zoekt.CountResult{MatchCount: 5, FileCount: 2, RepoCount: 1, Estimated: true},
+	}, {
+		name:     "unsupported",
+		searcher: &mockSearcher.MockSearcher{},
+		wantCode: codes.Unimplemented,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			gs := grpc.NewServer()
+			defer gs.Stop()
+
+			v1.RegisterWebserverServiceServer(gs, NewServer(adapter{tc.searcher}))
+			ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Close()
+
+			client := v1.NewWebserverServiceClient(cc)
+			resp, err := client.Count(context.Background(), &v1.CountRequest{
+				Query: query.QToProto(mustParse("needle")),
+				Opts:  &v1.CountOptions{Estimate: true},
+			})
+			if tc.wantCode != codes.OK {
+				if status.Code(err) != tc.wantCode {
+					t.Fatalf("got error %v, want %s", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, zoekt.CountResultFromProto(resp)); diff != "" {
+				t.Fatalf("unexpected difference in count (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStreamList(t *testing.T) {
+	// Enough repositories for several chunks of 1 MiB.
+	padding := strings.Repeat("x", 1000)
+	repoList := &zoekt.RepoList{
+		ReposMap: zoekt.ReposMap{},
+		Crashes:  1,
+		Stats:    zoekt.RepoStats{Repos: 4000, Shards: 4001},
+	}
+	for i := 0; i < 2000; i++ {
+		repoList.Repos = append(repoList.Repos, &zoekt.RepoListEntry{
+			Repository: zoekt.Repository{
+				ID:        uint32(i),
+				Name:      fmt.Sprintf("repo-%d", i),
+				RawConfig: map[string]string{"padding": padding},
+			},
+		})
+		repoList.ReposMap[uint32(2000+i)] = zoekt.MinimalRepoListEntry{
+			Branches: []zoekt.RepositoryBranch{{Name: "HEAD", Version: padding}},
+		}
+	}
+	mock := &mockSearcher.MockSearcher{
+		WantList: &query.Const{Value: true},
+		RepoList: repoList,
+	}
+
+	gs := grpc.NewServer()
+	defer gs.Stop()
+
+	v1.RegisterWebserverServiceServer(gs, NewServer(adapter{mock}))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	cs, err := v1.NewWebserverServiceClient(cc).StreamList(context.Background(), &v1.ListRequest{Query: query.QToProto(mock.WantList)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &v1.ListResponse{}
+	chunks := 0
+	for {
+		r, err := cs.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := r.GetResponseChunk()
+		if size := proto.Size(chunk); size > 1024*1024 {
+			t.Errorf("chunk %d has %d bytes", chunks, size)
+		}
+		if chunk.GetStats() != nil && (len(chunk.GetRepos()) > 0 || len(chunk.GetReposMap()) > 0) {
+			t.Errorf("chunk %d has stats and repositories", chunks)
+		}
+		proto.Merge(got, chunk)
+		chunks++
+	}
+
+	if chunks < 3 {
+		t.Errorf("got %d chunks, want several", chunks)
+	}
+	if diff := cmp.Diff(repoList.ToProto(), got, protocmp.Transform()); diff != "" {
+		t.Fatalf("unexpected difference in repo list (-want +got):\n%s", diff)
+	}
+}
+
 func TestFuzzGRPCChunkSender(t *testing.T) {
 	validateResult := func(input zoekt.SearchResult) error {
 		clientStream, serverStream := newPairedSearchStream(t)
@@ -146,9 +615,10 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			opts := []cmp.Option{
 				protocmp.Transform(),
 				protocmp.IgnoreFields(&v1.SearchResponse{},
-					"progress", // progress is tested above
-					"stats",    // aggregated stats are tested below
-					"files",    // files are tested separately
+					"progress",   // progress is tested above
+					"stats",      // aggregated stats are tested below
+					"files",      // files are tested separately
+					"result_set", // the result set is tested below
 				),
 			}
 
@@ -175,6 +645,21 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			return fmt.Errorf("unexpected difference in stats (-want +got):\n%s", diff)
 		}
 
+		// Check to make sure that we get the result set back once
+		var receivedResultSets [][]byte
+		for _, r := range allResponses {
+			if rs := r.GetResultSet(); len(rs) > 0 {
+				receivedResultSets = append(receivedResultSets, rs)
+			}
+		}
+		if want := expectedResult.GetResultSet(); len(want) > 0 {
+			if len(receivedResultSets) != 1 || !bytes.Equal(receivedResultSets[0], want) {
+				return fmt.Errorf("got result sets %v, want %v once", receivedResultSets, want)
+			}
+		} else if len(receivedResultSets) > 0 {
+			return fmt.Errorf("got unexpected result sets %v", receivedResultSets)
+		}
+
 		// Check to make sure that we get the same set of file matches back
 		if diff := cmp.Diff(expectedResult.GetFiles(), receivedFileMatches,
 			protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
@@ -281,6 +766,14 @@ type adapter struct {
 	zoekt.Searcher
 }
 
+func (a adapter) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, a.Searcher, name, opts)
+}
+
+func (a adapter) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, a.Searcher, q, opts)
+}
+
 func (a adapter) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (err error) {
 	sr, err := a.Searcher.Search(ctx, q, opts)
 	if err != nil {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+// historyHandler serves the repositories which were indexed in indexDir at
+// the time at, RFC 3339 or seconds since the epoch, or now if unset. The
+// optional repo parameter restricts the answer to one repository. It needs
+// builds with -repo_history. With tenant enforcement, it only serves the
+// repositories of the tenant of the request.
+func historyHandler(indexDir string) http.Handler {
+	h := &repoHistory{indexDir: indexDir}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		at := time.Now()
+		if v := r.FormValue("at"); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				http.Error(w, "at must be an RFC 3339 time or seconds since the epoch", http.StatusBadRequest)
+				return
+			}
+			at = t
+		}
+
+		snapshots, err := h.snapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := r.FormValue("repo")
+		repos := []index.RepoSnapshot{}
+		for _, s := range index.ReposAt(snapshots, at) {
+			if (name == "" || s.Name == name) && tenant.HasAccess(r.Context(), s.TenantID) {
+				repos = append(repos, s)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			At    time.Time
+			Repos []index.RepoSnapshot
+		}{at, repos})
+	})
+}
+
+// repoHistory caches the repository history of an index directory until
+// the file changes.
+type repoHistory struct {
+	indexDir string
+
+	mu      sync.Mutex
+	size    int64
+	modTime time.Time
+	cached  []index.RepoSnapshot
+}
+
+func (h *repoHistory) snapshots() ([]index.RepoSnapshot, error) {
+	fi, err := os.Stat(filepath.Join(h.indexDir, index.RepoHistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.modTime.IsZero() && fi.Size() == h.size && fi.ModTime().Equal(h.modTime) {
+		return h.cached, nil
+	}
+	// The file is appended to or replaced by a compacted one. Either
+	// changes what was stat'ed, so a change during the read is picked up by
+	// the next request.
+	snapshots, err := index.ReadRepoHistory(h.indexDir)
+	if err != nil {
+		return nil, err
+	}
+	h.size, h.modTime, h.cached = fi.Size(), fi.ModTime(), snapshots
+	return snapshots, nil
+}
+
+func parseHistoryTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
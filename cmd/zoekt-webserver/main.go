@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"errors"
 	"flag"
@@ -37,6 +38,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/grafana/regexp"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/sourcegraph/mountinfo"
 	"github.com/sourcegraph/zoekt/internal/debugserver"
@@ -54,12 +56,20 @@ import (
 	sglog "github.com/sourcegraph/log"
 	"github.com/sourcegraph/zoekt"
 	zoektgrpc "github.com/sourcegraph/zoekt/cmd/zoekt-webserver/grpc/server"
+	"github.com/sourcegraph/zoekt/grpc/capabilities"
 	"github.com/sourcegraph/zoekt/grpc/internalerrs"
 	"github.com/sourcegraph/zoekt/grpc/messagesize"
 	"github.com/sourcegraph/zoekt/grpc/propagator"
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/admission"
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/drain"
+	"github.com/sourcegraph/zoekt/internal/experiments"
+	"github.com/sourcegraph/zoekt/internal/peers"
 	"github.com/sourcegraph/zoekt/internal/profiler"
+	"github.com/sourcegraph/zoekt/internal/querylog"
+	"github.com/sourcegraph/zoekt/internal/repogroups"
 	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/internal/tracer"
@@ -139,6 +149,7 @@ func main() {
 
 	listen := flag.String("listen", ":6070", "listen on this address.")
 	indexDir := flag.String("index", index.DefaultDir, "set index directory to use")
+	shardStorage := flag.String("shard_storage", "", "if set, load shards from this object store location (s3://bucket/prefix or gs://bucket/prefix) into --index instead of serving the shards in --index")
 	html := flag.Bool("html", true, "enable HTML interface")
 	enableRPC := flag.Bool("rpc", false, "enable go/net RPC")
 	enableIndexserverProxy := flag.Bool("indexserver_proxy", false, "proxy requests with URLs matching the path /indexserver/ to <index>/indexserver.sock")
@@ -150,10 +161,45 @@ func main() {
 		"host_customization", "",
 		"specify host customization, as HOST1=QUERY,HOST2=QUERY")
 
-	templateDir := flag.String("template_dir", "", "set directory from which to load custom .html.tpl template files")
+	queryRewriteRules := flag.String("query_rewrite_rules", "", "if set, rewrite queries with the rules in this YAML or JSON file, eg. to expand aliases for groups of repositories")
+	repoGroups := flag.String("repo_groups", "", "if set, interleave the ranked results of the groups of repositories in this YAML or JSON file by their weights, so that no group dominates the top results")
+	queryTemplates := flag.String("query_templates", "", "if set, serve the query templates in this YAML or JSON file, which JSON API clients can search for by name with arguments")
+	templateDir := flag.String("template_dir", "", "set directory from which to load custom .html.tpl template files. They are reloaded when they change.")
 	dumpTemplates := flag.Bool("dump_templates", false, "dump templates into --template_dir and exit.")
 	version := flag.Bool("version", false, "Print version number")
 
+	queryLog := flag.String("query_log", "", "if set, append the searches to this file as JSON lines, which zoekt-replay can replay against another index")
+	queryLogSample := flag.Float64("query_log_sample", 1, "fraction of the searches written to --query_log")
+	queryLogSlow := flag.Duration("query_log_slow", 0, "if set, always write searches taking at least this long to --query_log, regardless of --query_log_sample")
+	warmupSearches := flag.Int("warmup_searches", 0, "if set, once the shards are loaded at startup, replay this many of the searches logged most often in --query_log in the background, so their pages are in memory when users search again")
+	warmupWindow := flag.Duration("warmup_window", 24*time.Hour, "with --warmup_searches, replay the searches logged within this long before startup")
+
+	authBasicFile := flag.String("auth_basic_file", "", "if set, require basic auth with the users in this htpasswd file (bcrypt hashes, as generated by htpasswd -B)")
+	authOIDCIssuer := flag.String("auth_oidc_issuer", "", "if set, require an OpenID Connect login with this issuer")
+	authOIDCClientID := flag.String("auth_oidc_client_id", "", "OpenID Connect client ID")
+	authOIDCClientSecretFile := flag.String("auth_oidc_client_secret_file", "", "file containing the OpenID Connect client secret")
+	authOIDCRedirectURL := flag.String("auth_oidc_redirect_url", "", "external URL of /auth/callback on this server, registered with the OpenID provider")
+	authOIDCSessionKeyFile := flag.String("auth_oidc_session_key_file", "", "file containing the key which signs session cookies. If unset, a random key is used and logins don't survive restarts")
+	authOIDCSessionTTL := flag.Duration("auth_oidc_session_ttl", 24*time.Hour, "how long an OpenID Connect login is valid")
+
+	maxConcurrentRequests := flag.Int("max_concurrent_requests", 0, "if set, serve at most this many HTTP and gRPC requests at once. Other requests wait in a queue")
+	maxQueuedRequests := flag.Int("max_queued_requests", 100, "with --max_concurrent_requests, the number of requests which may wait. Requests beyond it are rejected with 429 or ResourceExhausted")
+	maxQueueTime := flag.Duration("max_queue_time", 5*time.Second, "with --max_concurrent_requests, how long a request may wait before it is rejected")
+	requestTimeout := flag.Duration("request_timeout", 0, "with --max_concurrent_requests, if set, cancel requests which take longer than this, including the time they waited")
+
+	exportMaxResults := flag.Int("export_max_results", 10000, "the most line matches /export downloads as CSV or JSON lines. Zero disables /export")
+	exportPerMinute := flag.Float64("export_per_minute", 10, "how many exports /export serves per minute. Exports beyond it are rejected with 429. Zero means no limit")
+
+	metadataUpdates := flag.Bool("metadata_updates", false, "allow updating the priority, topics, archived flag and branch order of repositories through the APIs. They rewrite the .meta files of the shards in --index")
+	shardDownloads := flag.Bool("shard_downloads", false, "allow downloading the shards of repositories through the FetchShards gRPC method, as done by zoekt pull")
+	freezeShards := flag.Bool("freeze_shards", false, "after loading the shards at startup, don't load new or changed shards and keep deleted ones until a POST to /freeze with frozen=false")
+	peerList := flag.String("peers", "", "if set, the comma separated gRPC addresses of all the replicas serving the same shards, including this one. Searches are forwarded to the replica owning their query on a consistent hash ring, so its caches are warm for them")
+	peerSelf := flag.String("peer_self", "", "with --peers, the address of this replica in --peers")
+	peerBackoff := flag.Duration("peer_backoff", 10*time.Second, "with --peers, how long the searches owned by an unreachable replica are served locally before it is tried again")
+	shutdownGracePeriod := flag.Duration("shutdown_grace_period", 10*time.Second, "on SIGTERM or SIGINT, how long to wait for the requests in flight before closing connections. New requests and /healthz fail with 503 or Unavailable meanwhile")
+	experimentFlags := flag.String("experiments", "", "comma separated experiment flags enabled for all searches, eg. bm25 or file_names_first, in addition to those of the requests")
+	configFile := flag.String("config", "", "if set, override flags with the settings in this YAML or JSON `file`, whose keys are the flag names, and apply its changes without a restart, also on SIGHUP. The settings are print, template_dir, host_customization, query_rewrite_rules, query_templates, experiments, export_max_results, export_per_minute, max_concurrent_requests, max_queued_requests, max_queue_time, request_timeout, shutdown_grace_period and auth_basic_file")
+
 	flag.Parse()
 
 	if *version {
@@ -210,45 +256,160 @@ func main() {
 	// Do not block on loading shards so we can become partially available
 	// sooner. Otherwise on large instances zoekt can be unavailable on the
 	// order of minutes.
-	searcher, err := shards.NewDirectorySearcherFast(*indexDir)
-	if err != nil {
-		log.Fatal(err)
+	var (
+		searcher zoekt.Streamer
+		err      error
+	)
+	if *shardStorage != "" {
+		storage, err := shards.NewShardStorage(context.Background(), *shardStorage, *indexDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		searcher, err = shards.NewStorageSearcherFast(storage)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		searcher, err = shards.NewDirectorySearcherFast(*indexDir)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-
-	searcher = &loggedSearcher{
-		Streamer: searcher,
-		Logger:   sglog.Scoped("searcher"),
+	freezer := searcher.(shards.ShardFreezer)
+	freezer.FreezeShards(*freezeShards)
+	// The wrappers below don't forward WaitUntilReady, and the warmup is for
+	// the shards of this replica.
+	local := searcher
+
+	flagConfig := config{
+		MaxConcurrentRequests: *maxConcurrentRequests,
+		MaxQueuedRequests:     *maxQueuedRequests,
+		MaxQueueTime:          *maxQueueTime,
+		RequestTimeout:        *requestTimeout,
+		ShutdownGracePeriod:   *shutdownGracePeriod,
+		ExportMaxResults:      *exportMaxResults,
+		ExportPerMinute:       *exportPerMinute,
+		Print:                 *print,
+		TemplateDir:           *templateDir,
+		HostCustomization:     *hostCustomization,
+		QueryRewriteRules:     *queryRewriteRules,
+		QueryTemplates:        *queryTemplates,
+		AuthBasicFile:         *authBasicFile,
+	}
+	if *experimentFlags != "" {
+		flagConfig.Experiments = strings.Split(*experimentFlags, ",")
+	}
+	cfg := flagConfig
+	var cfgContent []byte
+	if *configFile != "" {
+		cfg, cfgContent, err = loadConfig(*configFile, flagConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	s := &web.Server{
-		Searcher: searcher,
-		Top:      web.Top,
-		Version:  index.Version,
+	authOpts := auth.Options{
+		// The watchdog checks /healthz without credentials.
+		Public: []string{"/healthz"},
+		Logger: sglog.Scoped("auth"),
+	}
+	if cfg.AuthBasicFile != "" {
+		authOpts.Basic, err = auth.LoadBasicAuth(cfg.AuthBasicFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *authOIDCIssuer != "" {
+		cfg := auth.OIDCConfig{
+			Issuer:      *authOIDCIssuer,
+			ClientID:    *authOIDCClientID,
+			RedirectURL: *authOIDCRedirectURL,
+			SessionTTL:  *authOIDCSessionTTL,
+			Logger:      sglog.Scoped("oidc"),
+		}
+		if *authOIDCClientSecretFile != "" {
+			secret, err := os.ReadFile(*authOIDCClientSecretFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.ClientSecret = strings.TrimSpace(string(secret))
+		}
+		if *authOIDCSessionKeyFile != "" {
+			cfg.SessionKey, err = os.ReadFile(*authOIDCSessionKeyFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			cfg.SessionKey = make([]byte, 32)
+			if _, err := rand.Read(cfg.SessionKey); err != nil {
+				log.Fatal(err)
+			}
+		}
+		authOpts.OIDC, err = auth.NewOIDC(context.Background(), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	if *templateDir != "" {
-		if err := loadTemplates(s.Top, *templateDir); err != nil {
-			log.Fatalf("loadTemplates: %v", err)
+	if *peerList != "" {
+		// Peers forward searches without the credentials of the user.
+		if authOpts.Basic != nil || authOpts.OIDC != nil {
+			log.Fatal("--peers can't be combined with authentication")
+		}
+		searcher, err = peers.New(searcher, peers.Options{
+			Self:    *peerSelf,
+			Peers:   strings.Split(*peerList, ","),
+			Backoff: *peerBackoff,
+		})
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	s.Print = *print
-	s.HTML = *html
-	s.RPC = *enableRPC
+	if *repoGroups != "" {
+		groups, err := repogroups.Load(*repoGroups)
+		if err != nil {
+			log.Fatal(err)
+		}
+		searcher, err = repogroups.New(searcher, groups)
+		if err != nil {
+			log.Fatalf("%s: %v", *repoGroups, err)
+		}
+	}
 
-	if *hostCustomization != "" {
-		s.HostCustomQueries = map[string]string{}
-		for _, h := range strings.SplitN(*hostCustomization, ",", -1) {
-			if len(h) == 0 {
-				continue
-			}
-			fields := strings.SplitN(h, "=", 2)
-			if len(fields) < 2 {
-				log.Fatalf("invalid host_customization %q", h)
-			}
+	exps := &experiments.Searcher{Streamer: searcher}
+	searcher = exps
 
-			s.HostCustomQueries[fields[0]] = fields[1]
+	ls := &loggedSearcher{
+		Streamer:        searcher,
+		Logger:          sglog.Scoped("searcher"),
+		MetadataUpdates: *metadataUpdates,
+		ShardDownloads:  *shardDownloads,
+		Experiments:     exps,
+	}
+	if *queryLog != "" && *warmupSearches > 0 {
+		go warmup(local, *queryLog, *warmupSearches, *warmupWindow)
+	}
+	if *queryLog != "" {
+		f, err := os.OpenFile(*queryLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer f.Close()
+		ls.QueryLog = querylog.NewLogger(f)
+		ls.QueryLog.Sample = *queryLogSample
+		ls.QueryLog.Slow = *queryLogSlow
+	}
+	searcher = ls
+
+	// The settings which may change while the server is running are applied
+	// by the configurable below.
+	s := &web.Server{
+		Searcher: searcher,
+		Top:      web.Top,
+		Version:  index.Version,
+		HTML:     *html,
+		RPC:      *enableRPC,
 	}
 
 	serveMux, err := web.NewMux(s)
@@ -256,7 +417,17 @@ func main() {
 		log.Fatal(err)
 	}
 
-	debugserver.AddHandlers(serveMux, *enablePprof)
+	debugserver.AddHandlers(serveMux, *enablePprof, debugserver.DebugPage{
+		Href:        "freeze",
+		Text:        "Freeze",
+		Description: "whether loading new shards is frozen, POST frozen=true or false to change it",
+	}, debugserver.DebugPage{
+		Href:        "history",
+		Text:        "History",
+		Description: "the repositories indexed at a past time, eg. ?at=2024-01-02T15:04:05Z, if built with -repo_history",
+	})
+	serveMux.Handle("/freeze", freezeHandler(freezer, sglog.Scoped("freeze")))
+	serveMux.Handle("/history", historyHandler(*indexDir))
 
 	if *enableIndexserverProxy {
 		socket := filepath.Join(*indexDir, "indexserver.sock")
@@ -264,7 +435,7 @@ func main() {
 		addProxyHandler(serveMux, socket)
 	}
 
-	handler := trace.Middleware(serveMux)
+	handler := trace.Middleware(experiments.Middleware(serveMux))
 
 	// Sourcegraph: We use environment variables to configure watchdog since
 	// they are more convenient than flags in containerized environments.
@@ -294,10 +465,52 @@ func main() {
 
 	logger := sglog.Scoped("ZoektWebserverGRPCServer")
 
+	// With --config, the limits can be enabled while the server is running.
+	var limiter *admission.Limiter
+	if *configFile != "" {
+		limiter = admission.NewAdjustable(cfg.admissionOptions())
+	} else {
+		limiter = admission.New(cfg.admissionOptions())
+	}
+
+	configured := &configurable{
+		server:      s,
+		experiments: exps,
+		limiter:     limiter,
+		basic:       authOpts.Basic,
+	}
+	if err := configured.apply(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if *configFile != "" {
+		if err := watchConfig(configured, *configFile, flagConfig, cfgContent); err != nil {
+			log.Fatalf("watchConfig: %v", err)
+		}
+	}
+	drainer := drain.New(drain.Options{
+		Health: []string{"/healthz"},
+		// Metrics and debug pages stay up until the server is closed.
+		Exempt: []string{"/metrics", "/debug", "/vars", "/gc", "/freeosmemory", "/freeze", "/indexserver/"},
+	})
+	grpcOpts := []grpc.ServerOption{
+		grpc.ChainStreamInterceptor(drainer.StreamServerInterceptor),
+		grpc.ChainUnaryInterceptor(drainer.UnaryServerInterceptor),
+	}
+	if limiter != nil {
+		grpcOpts = append(grpcOpts,
+			grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor),
+			grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor),
+		)
+	}
+
 	streamer := web.NewTraceAwareSearcher(s.Searcher)
-	grpcServer := newGRPCServer(logger, streamer)
+	grpcServer := newGRPCServer(logger, streamer, grpcOpts...)
 
-	handler = multiplexGRPC(grpcServer, handler)
+	// Authentication wraps both handlers, so it sits inside the h2c handler
+	// which upgrades gRPC connections. Requests are only queued once they are
+	// authenticated, and gRPC calls are limited by the interceptors.
+	authenticate := auth.Middleware(authOpts)
+	handler = multiplexGRPC(authenticate(grpcServer), drainer.Middleware(authenticate(limiter.Middleware(handler))))
 
 	srv := &http.Server{
 		Addr:    *listen,
@@ -319,24 +532,19 @@ func main() {
 		}
 	}()
 
-	if s.RPC {
-		// Our RPC system does not support shutdown and hijacks the underlying
-		// http connection. This means shutdown is ineffective and just waits 10s
-		// before calling close. Lets just quit faster in that case.
-		if err := closeOnSignal(srv); err != nil {
-			log.Fatalf("http.Server.Close: %v", err)
-		}
-	} else {
-		if err := shutdownOnSignal(srv); err != nil {
-			log.Fatalf("http.Server.Shutdown: %v", err)
-		}
+	if err := shutdownOnSignal(srv, grpcServer, drainer, func() time.Duration { return configured.config().ShutdownGracePeriod }); err != nil {
+		log.Printf("shutdown: %v", err)
+		return
 	}
+
+	// Nothing uses the shards anymore, so we can unmap them.
+	searcher.Close()
 }
 
 // multiplexGRPC takes a gRPC server and a plain HTTP handler and multiplexes the
 // request handling. Any requests that declare themselves as gRPC requests are routed
 // to the gRPC server, all others are routed to the httpHandler.
-func multiplexGRPC(grpcServer *grpc.Server, httpHandler http.Handler) http.Handler {
+func multiplexGRPC(grpcServer http.Handler, httpHandler http.Handler) http.Handler {
 	newHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
 			grpcServer.ServeHTTP(w, r)
@@ -379,25 +587,18 @@ func shutdownSignalChan(maxReads int) <-chan os.Signal {
 	return c
 }
 
-// closeOnSignal will listen for SIGINT or SIGTERM and call srv.Close. This is
-// not a graceful shutdown, see shutdownOnSignal.
-func closeOnSignal(srv *http.Server) error {
-	c := shutdownSignalChan(1)
-	<-c
-
-	return srv.Close()
-}
-
-// shutdownOnSignal will listen for SIGINT or SIGTERM and call srv.Shutdown.
-// Note it doesn't call anything else for shutting down. Notably our RPC
-// framework doesn't allow us to drain connections, so when Shutdown we will
-// wait 10s before closing.
+// shutdownOnSignal will listen for SIGINT or SIGTERM and shut down the
+// server gracefully. It fails health checks and new requests, and waits up to
+// the gracePeriod at the time of the signal for the HTTP and gRPC requests in
+// flight before it closes the connections. A second signal shuts down
+// immediately.
 //
-// Note: the call site for shutdownOnSignal should use closeOnSignal instead
-// if rpc mode is enabled due to the above limitation.
-func shutdownOnSignal(srv *http.Server) error {
+// It returns an error if requests were still in flight when the connections
+// were closed, in which case the shards may still be in use.
+func shutdownOnSignal(srv *http.Server, grpcServer *grpc.Server, drainer *drain.Drainer, gracePeriodFunc func() time.Duration) error {
 	c := shutdownSignalChan(2)
 	<-c
+	gracePeriod := gracePeriodFunc()
 
 	// If we receive another signal, immediate shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -411,14 +612,28 @@ func shutdownOnSignal(srv *http.Server) error {
 		}
 	}()
 
-	// Wait for 10s to drain ongoing requests. Kubernetes gives us 30s to
-	// shutdown, we have already used 15s waiting for our endpoint removal to
-	// propagate.
-	ctx, cancel2 := context.WithTimeout(ctx, 10*time.Second)
+	// Kubernetes gives us 30s to shutdown by default, we have already used
+	// 15s waiting for our endpoint removal to propagate.
+	ctx, cancel2 := context.WithTimeout(ctx, gracePeriod)
 	defer cancel2()
 
-	log.Printf("shutting down")
-	return srv.Shutdown(ctx)
+	log.Printf("shutting down, draining requests for up to %v", gracePeriod)
+	start := time.Now()
+	err := drainer.Drain(ctx)
+	if err == nil {
+		log.Printf("drained requests in %v", time.Since(start).Round(time.Millisecond))
+		err = srv.Shutdown(ctx)
+	}
+
+	// gRPC calls are served on connections hijacked by h2c, which Shutdown
+	// doesn't close. The calls are done unless we ran out of time, but
+	// GracefulStop doesn't support ServeHTTP so we always Stop.
+	grpcServer.Stop()
+	if err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("closed connections with requests in flight: %w", err)
+	}
+	return nil
 }
 
 func watchdogOnce(ctx context.Context, client *http.Client, addr string) error {
@@ -507,6 +722,20 @@ func mustRegisterDiskMonitor(path string) {
 type loggedSearcher struct {
 	zoekt.Streamer
 	Logger sglog.Logger
+
+	// QueryLog records the searches for zoekt-replay, if set.
+	QueryLog *querylog.Logger
+
+	// MetadataUpdates enables the repository metadata updates of the
+	// searcher, which write to the index directory.
+	MetadataUpdates bool
+
+	// ShardDownloads enables sending the shard files of the searcher.
+	ShardDownloads bool
+
+	// Experiments reports the experiment flags of searches in their logs, if
+	// set.
+	Experiments *experiments.Searcher
 }
 
 func (s *loggedSearcher) Search(
@@ -514,12 +743,15 @@ func (s *loggedSearcher) Search(
 	q query.Q,
 	opts *zoekt.SearchOptions,
 ) (sr *zoekt.SearchResult, err error) {
+	qs := s.QueryLog.Start(ctx, q, opts)
 	defer func() {
 		var stats *zoekt.Stats
 		if sr != nil {
 			stats = &sr.Stats
+			qs.AddFiles(sr.Files)
 		}
 		s.log(ctx, q, opts, stats, err)
+		qs.Done(stats, err)
 	}()
 
 	metricSearchRequestsTotal.Inc()
@@ -533,18 +765,78 @@ func (s *loggedSearcher) StreamSearch(
 	sender zoekt.Sender,
 ) error {
 	var stats zoekt.Stats
+	qs := s.QueryLog.StartStream(ctx, q, opts)
 
 	metricSearchRequestsTotal.Inc()
 	err := s.Streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(event *zoekt.SearchResult) {
 		stats.Add(event.Stats)
+		qs.AddFiles(event.Files)
 		sender.Send(event)
 	}))
 
 	s.log(ctx, q, opts, &stats, err)
+	qs.Done(&stats, err)
 
 	return err
 }
 
+// StreamList implements zoekt.ListStreamer.
+func (s *loggedSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *loggedSearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s *loggedSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s *loggedSearcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	if !s.MetadataUpdates {
+		return nil, fmt.Errorf("metadata updates are disabled, see -metadata_updates: %w", errors.ErrUnsupported)
+	}
+	repo, err := zoekt.UpdateRepositoryMetadata(ctx, s.Streamer, name, u)
+	logger := s.Logger.WithTrace(traceContext(ctx)).With(sglog.String("repo", name))
+	if id, ok := auth.FromContext(ctx); ok {
+		logger = logger.With(sglog.String("actor", id.Subject), sglog.String("actor.provider", id.Provider))
+	}
+	if flags := experiments.FromContext(ctx); len(flags) > 0 {
+		logger = logger.With(sglog.Strings("experiments", flags))
+	}
+	if err != nil {
+		logger.Warn("metadata update failed", sglog.Error(err))
+	} else {
+		logger.Info("metadata updated")
+	}
+	return repo, err
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s *loggedSearcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	if !s.ShardDownloads {
+		return nil, fmt.Errorf("shard downloads are disabled, see -shard_downloads: %w", errors.ErrUnsupported)
+	}
+	files, err := zoekt.ShardFiles(ctx, s.Streamer, repos)
+	if err == nil {
+		s.Logger.WithTrace(traceContext(ctx)).Info("shard download",
+			sglog.String("repos", repos.String()), sglog.Int("files", len(files)))
+	}
+	return files, err
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *loggedSearcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	if !s.ShardDownloads {
+		return nil, zoekt.ShardFile{}, fmt.Errorf("shard downloads are disabled, see -shard_downloads: %w", errors.ErrUnsupported)
+	}
+	return zoekt.OpenShardFile(ctx, s.Streamer, name)
+}
+
 func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, st *zoekt.Stats, err error) {
 	logger := s.Logger.
 		WithTrace(traceContext(ctx)).
@@ -557,7 +849,16 @@ func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchO
 			sglog.Duration("opts.MaxWallTime", opts.MaxWallTime),
 			sglog.Int("opts.MaxDocDisplayCount", opts.MaxDocDisplayCount),
 			sglog.Int("opts.MaxMatchDisplayCount", opts.MaxMatchDisplayCount),
+			sglog.Int("opts.MaxDisplayBytes", opts.MaxDisplayBytes),
 		)
+	if id, ok := auth.FromContext(ctx); ok {
+		logger = logger.With(sglog.String("actor", id.Subject), sglog.String("actor.provider", id.Provider))
+	}
+	if s.Experiments != nil {
+		if flags := s.Experiments.Flags(ctx); len(flags) > 0 {
+			logger = logger.With(sglog.Strings("experiments", flags))
+		}
+	}
 
 	if err != nil {
 		switch {
@@ -588,12 +889,15 @@ func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchO
 		sglog.Int("stat.ShardsScanned", st.ShardsScanned),
 		sglog.Int("stat.ShardsSkipped", st.ShardsSkipped),
 		sglog.Int("stat.ShardsSkippedFilter", st.ShardsSkippedFilter),
+		sglog.Int("stat.ShardsInterrupted", st.ShardsInterrupted),
 		sglog.Int("stat.MatchCount", st.MatchCount),
 		sglog.Int("stat.NgramMatches", st.NgramMatches),
 		sglog.Int("stat.NgramLookups", st.NgramLookups),
 		sglog.Duration("stat.Wait", st.Wait),
 		sglog.Duration("stat.MatchTreeConstruction", st.MatchTreeConstruction),
 		sglog.Duration("stat.MatchTreeSearch", st.MatchTreeSearch),
+		sglog.Duration("stat.MatchTreeBudgetSearch", st.MatchTreeBudgetSearch),
+		sglog.Int("stat.BudgetsExhausted", st.BudgetsExhausted),
 		sglog.Int("stat.RegexpsConsidered", st.RegexpsConsidered),
 		sglog.String("stat.FlushReason", st.FlushReason.String()),
 	)
@@ -623,22 +927,26 @@ func traceContext(ctx context.Context) sglog.TraceContext {
 func newGRPCServer(logger sglog.Logger, streamer zoekt.Streamer, additionalOpts ...grpc.ServerOption) *grpc.Server {
 	metrics := serverMetricsOnce()
 
+	prop := propagator.Chain(tenant.Propagator{}, experiments.Propagator{}, propagator.NewBaggage(propagator.DefaultBaggageKeys...))
+
 	opts := []grpc.ServerOption{
 		grpc.ChainStreamInterceptor(
-			propagator.StreamServerPropagator(tenant.Propagator{}),
+			propagator.StreamServerPropagator(prop),
 			tenant.StreamServerInterceptor,
 			otelgrpc.StreamServerInterceptor(),
 			metrics.StreamServerInterceptor(),
 			messagesize.StreamServerInterceptor,
 			internalerrs.LoggingStreamServerInterceptor(logger),
+			capabilities.StreamServerInterceptor(capabilities.Server),
 		),
 		grpc.ChainUnaryInterceptor(
-			propagator.UnaryServerPropagator(tenant.Propagator{}),
+			propagator.UnaryServerPropagator(prop),
 			tenant.UnaryServerInterceptor,
 			otelgrpc.UnaryServerInterceptor(),
 			metrics.UnaryServerInterceptor(),
 			messagesize.UnaryServerInterceptor,
 			internalerrs.LoggingUnaryServerInterceptor(logger),
+			capabilities.UnaryServerInterceptor(capabilities.Server),
 		),
 	}
 
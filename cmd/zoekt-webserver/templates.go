@@ -0,0 +1,121 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/sourcegraph/zoekt/web"
+)
+
+// templateReloadDelay is how long we wait for changes to the template
+// directory to settle before reloading it. Editors often save a file in
+// several steps.
+const templateReloadDelay = 200 * time.Millisecond
+
+// parseTemplates returns the standard templates overridden by the templates
+// in dir. If dir is empty, it returns the standard templates.
+func parseTemplates(dir string) (*template.Template, error) {
+	top, err := web.NewTop()
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return top, nil
+	}
+	if err := loadTemplates(top, dir); err != nil {
+		return nil, err
+	}
+	return top, nil
+}
+
+// templateWatcher reloads the templates of a server from a directory
+// whenever a template in it changes. If the changed templates are invalid,
+// the server keeps serving the previous ones.
+type templateWatcher struct {
+	s       *web.Server
+	watcher *fsnotify.Watcher
+
+	mu  sync.Mutex
+	dir string
+}
+
+func newTemplateWatcher(s *web.Server) (*templateWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &templateWatcher{s: s, watcher: watcher}
+	go w.run()
+	return w, nil
+}
+
+// setDir serves top, parsed from dir, and watches dir instead of the
+// previous directory. If dir is empty, nothing is watched.
+func (w *templateWatcher) setDir(dir string, top *template.Template) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if dir != w.dir {
+		if dir != "" {
+			if err := w.watcher.Add(dir); err != nil {
+				return err
+			}
+		}
+		if w.dir != "" {
+			_ = w.watcher.Remove(w.dir)
+		}
+		w.dir = dir
+	}
+	return w.s.SetTemplates(top)
+}
+
+func (w *templateWatcher) run() {
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if strings.HasSuffix(event.Name, templateExtension) {
+				reload = time.After(templateReloadDelay)
+			}
+
+		case <-reload:
+			reload = nil
+			w.mu.Lock()
+			dir := w.dir
+			err := reloadTemplates(w.s, dir)
+			w.mu.Unlock()
+			if err != nil {
+				log.Printf("reloading templates from %s: %v", dir, err)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("template watcher: %v", err)
+		}
+	}
+}
+
+func reloadTemplates(s *web.Server, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	top, err := parseTemplates(dir)
+	if err != nil {
+		return err
+	}
+	if err := s.SetTemplates(top); err != nil {
+		return err
+	}
+	log.Printf("reloaded templates from %s", dir)
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/querylog"
+	"github.com/sourcegraph/zoekt/internal/shards"
+)
+
+// warmupLogBytes is how much of the end of the query log the warmup reads
+// to find the most frequent searches.
+const warmupLogBytes = 64 << 20
+
+// warmup replays the n searches logged most often in the query log at path
+// within window against s, once s loaded the shards present at startup.
+func warmup(s zoekt.Searcher, path string, n int, window time.Duration) {
+	since := time.Now().Add(-window)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("warmup: %v", err)
+		return
+	}
+	r, err := querylog.NewTailReader(f, warmupLogBytes)
+	if err != nil {
+		f.Close()
+		log.Printf("warmup: %v", err)
+		return
+	}
+	entries, err := querylog.TopSearches(r, since, n)
+	f.Close()
+	if err != nil {
+		log.Printf("warmup: reading %s: %v", path, err)
+		return
+	}
+
+	if w, ok := s.(shards.ShardsWaiter); ok {
+		if err := w.WaitUntilReady(); err != nil {
+			log.Printf("warmup: %v", err)
+			return
+		}
+	}
+
+	start := time.Now()
+	log.Printf("warmup: replaying %d searches", len(entries))
+	querylog.Warmup(context.Background(), s, entries)
+	log.Printf("warmup: replayed %d searches in %v", len(entries), time.Since(start))
+}
@@ -0,0 +1,1049 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// An archive is a tar file holding a snapshot of the shards of some
+// repositories. It starts with its manifest, followed by the ed25519
+// signature of the manifest and the files the manifest lists, so an import
+// verifies everything before it writes to the index directory.
+const (
+	archiveManifestName  = "zoekt-archive.json"
+	archiveSignatureName = archiveManifestName + ".sig"
+
+	archiveVersion = 1
+
+	// maxArchiveManifestSize bounds the memory used by the manifest, which
+	// is read before its signature can be checked.
+	maxArchiveManifestSize = 64 << 20
+
+	// snapshotAttempts is how often export tries to take a snapshot of
+	// shards which an indexer is replacing.
+	snapshotAttempts = 3
+)
+
+type archiveManifest struct {
+	Version      int           `json:"version"`
+	Created      time.Time     `json:"created"`
+	ZoektVersion string        `json:"zoekt_version"`
+	Repositories []archiveRepo `json:"repositories"`
+	Files        []archiveFile `json:"files"`
+}
+
+// archiveRepo is an exported repository in a shard of an archive. The
+// other repositories of the shard are tombstoned by its ".meta" file.
+type archiveRepo struct {
+	Name     string `json:"name"`
+	ID       uint32 `json:"id"`
+	TenantID int    `json:"tenant_id"`
+	Shard    string `json:"shard"`
+}
+
+// archiveFile is a file of an archive. The versions are only set for shards.
+type archiveFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+
+	IndexFormatVersion    int  `json:"index_format_version,omitempty"`
+	IndexFeatureVersion   int  `json:"index_feature_version,omitempty"`
+	IndexMinReaderVersion int  `json:"index_min_reader_version,omitempty"`
+	Encrypted             bool `json:"encrypted,omitempty"`
+}
+
+func (f *archiveFile) isShard() bool {
+	return strings.HasSuffix(f.Name, ".zoekt")
+}
+
+func (f *archiveFile) metadata() *zoekt.IndexMetadata {
+	return &zoekt.IndexMetadata{
+		IndexFormatVersion:    f.IndexFormatVersion,
+		IndexFeatureVersion:   f.IndexFeatureVersion,
+		IndexMinReaderVersion: f.IndexMinReaderVersion,
+	}
+}
+
+// runArchive implements "zoekt archive", which moves the shards of
+// repositories between index directories without a network connection.
+func runArchive(args []string, out io.Writer) int {
+	usage := func() {
+		fmt.Fprintf(out, "Usage:\n\n"+
+			"  %[1]s archive keygen -key FILE\n"+
+			"  %[1]s archive export [option] -key FILE -o ARCHIVE REPO_REGEXP...\n"+
+			"  %[1]s archive import [option] -key FILE.pub ARCHIVE\n\n"+
+			"Exports a snapshot of the shards of the repositories matching any of the regular\n"+
+			"expressions to a signed archive, and imports it into another index directory, eg.\n"+
+			"one without network access. Run a subcommand with -help for its options.\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	switch args[0] {
+	case "keygen":
+		return runArchiveKeygen(args[1:], out)
+	case "export":
+		return runArchiveExport(args[1:], out)
+	case "import":
+		return runArchiveImport(args[1:], out)
+	case "-h", "-help", "--help", "help":
+		usage()
+		return 0
+	}
+	fmt.Fprintf(out, "unknown subcommand %q\n", args[0])
+	usage()
+	return 2
+}
+
+func runArchiveKeygen(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("archive keygen", flag.ContinueOnError)
+	fs.SetOutput(out)
+	key := fs.String("key", "", "write the private key to `file`, and the public key to file.pub")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage:\n\n  %s archive keygen -key FILE\n\n"+
+			"Creates the ed25519 key pair which signs archives. Keep the private key on the\n"+
+			"exporting side, and copy the public key to the importing side.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *key == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := writeArchiveKeys(*key); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintf(out, "Wrote the private key to %s and the public key to %s.pub.\n", *key, *key)
+	return 0
+}
+
+func runArchiveExport(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("archive export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	indexDir := fs.String("index_dir",
+		filepath.Join(os.Getenv("HOME"), ".zoekt"), "export the shards in `directory`")
+	key := fs.String("key", "", "sign the archive with the private key in `file`, see zoekt archive keygen")
+	output := fs.String("o", "", "write the archive to `file`")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage:\n\n  %s archive export [option] -key FILE -o ARCHIVE REPO_REGEXP...\n\n"+
+			"Writes the shards holding a repository matching any of the regular expressions,\n"+
+			"with their .meta files and the manifests listing them, to a signed archive. The\n"+
+			"archive is a consistent snapshot even while indexers replace the shards. The other\n"+
+			"repositories of a compound shard are tombstoned, but their contents are exported.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *key == "" || *output == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	repos, err := compileRepoPatterns(fs.Args())
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	priv, err := readArchivePrivateKey(*key)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	m, err := exportArchive(*indexDir, repos, priv, *output)
+	if err != nil {
+		fmt.Fprintf(out, "export from %s: %v\n", *indexDir, err)
+		return 1
+	}
+	fmt.Fprintf(out, "Exported %d repositories in %d file(s) to %s.\n", countRepos(m.Repositories), len(m.Files), *output)
+	return 0
+}
+
+func runArchiveImport(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("archive import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	indexDir := fs.String("index_dir",
+		filepath.Join(os.Getenv("HOME"), ".zoekt"), "import the shards into `directory`")
+	key := fs.String("key", "", "verify the archive with the public key in `file`, see zoekt archive keygen")
+	tenantMap := fs.String("tenant_map", "", "comma separated `old=new` pairs of tenant IDs. If set, every tenant in the archive must be mapped")
+	replace := fs.Bool("replace", false, "remove the shards of the imported repositories which the archive doesn't have")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage:\n\n  %s archive import [option] -key FILE.pub ARCHIVE\n\n"+
+			"Imports an archive written by zoekt archive export. The signature, checksums and\n"+
+			"shard versions are checked before any shard is replaced.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *key == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	tenants, err := parseTenantMap(*tenantMap)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	pub, err := readArchivePublicKey(*key)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	st, err := importArchive(*indexDir, fs.Arg(0), pub, tenants, *replace)
+	if err != nil {
+		fmt.Fprintf(out, "import %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	fmt.Fprintf(out, "Imported %d repositories in %d file(s), %d removed.\n", st.repos, st.files, st.removed)
+	fmt.Fprintf(out, "Search them with\n\n  %s -index_dir %s QUERY\n", os.Args[0], *indexDir)
+	return 0
+}
+
+// countRepos returns the number of repositories in repos, which lists a
+// repository once for each of its shards.
+func countRepos(repos []archiveRepo) int {
+	names := map[string]bool{}
+	for _, r := range repos {
+		names[r.Name] = true
+	}
+	return len(names)
+}
+
+func writeArchiveKeys(path string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	// Never overwrite a key, which would make the archives signed with it
+	// unverifiable.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: privDER}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644)
+}
+
+// readPEM returns the DER bytes of the PEM block of type typ in the file
+// path.
+func readPEM(path, typ string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("%s: no PEM %s block", path, typ)
+	}
+	return block.Bytes, nil
+}
+
+func readArchivePrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %T is not an ed25519 key", path, key)
+	}
+	return priv, nil
+}
+
+func readArchivePublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %T is not an ed25519 key", path, key)
+	}
+	return pub, nil
+}
+
+// parseTenantMap parses the -tenant_map flag.
+func parseTenantMap(s string) (map[int]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tenants := map[int]int{}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -tenant_map pair %q, want old=new", pair)
+		}
+		old, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -tenant_map pair %q: %w", pair, err)
+		}
+		tenant, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -tenant_map pair %q: %w", pair, err)
+		}
+		if _, ok := tenants[old]; ok {
+			return nil, fmt.Errorf("-tenant_map maps tenant %d twice", old)
+		}
+		tenants[old] = tenant
+	}
+	return tenants, nil
+}
+
+// mapTenant returns the tenant of repo after the import with tenants. A
+// shard with encrypted contents can only be read with the keys of its
+// tenants, so they can't change.
+func mapTenant(tenants map[int]int, repo, shard string, tenant int, encrypted bool) (int, error) {
+	if tenants == nil {
+		return tenant, nil
+	}
+	to, ok := tenants[tenant]
+	if !ok {
+		return 0, fmt.Errorf("-tenant_map doesn't map tenant %d of %s", tenant, repo)
+	}
+	if to != tenant && encrypted {
+		return 0, fmt.Errorf("can't map tenant %d of %s to %d: the contents of %s are encrypted with the key of tenant %d, reindex it instead", tenant, repo, to, shard, tenant)
+	}
+	return to, nil
+}
+
+// snapshotFile is a file of a snapshot. It is open, so indexers replacing
+// its path don't change what is exported. The ".meta" files tombstoning the
+// repositories which aren't exported only exist in memory, in data.
+type snapshotFile struct {
+	archiveFile
+	f    *os.File
+	data []byte
+}
+
+func (f *snapshotFile) reader() io.Reader {
+	if f.f == nil {
+		return bytes.NewReader(f.data)
+	}
+	return f.f
+}
+
+// errSnapshotChanged is returned if the index directory changed while a
+// snapshot was taken.
+var errSnapshotChanged = errors.New("the index directory changed during the snapshot")
+
+// snapshotShards opens the shards holding a repository matching repos,
+// their ".meta" files and the manifests listing them. It returns the
+// matching repositories, and the files sorted by name. The other
+// repositories of a compound shard are tombstoned in the ".meta" file of the
+// shard, which replaces the one of the index directory, so they aren't
+// searched after an import. Their contents are still in the shard.
+func snapshotShards(dir string, repos *regexp.Regexp) ([]archiveRepo, []*snapshotFile, error) {
+	for attempt := 1; ; attempt++ {
+		rs, files, err := trySnapshotShards(dir, repos)
+		if !errors.Is(err, errSnapshotChanged) || attempt == snapshotAttempts {
+			return rs, files, err
+		}
+	}
+}
+
+type snapshotShard struct {
+	repos     []*zoekt.Repository
+	md        *zoekt.IndexMetadata
+	encrypted bool
+}
+
+func readSnapshotShard(fn string) (*snapshotShard, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	iFile, err := index.NewIndexFile(f)
+	if err != nil {
+		return nil, err
+	}
+	defer iFile.Close()
+
+	repos, md, err := index.ReadMetadata(iFile)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := index.HasEncryptedContents(iFile)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotShard{repos: repos, md: md, encrypted: encrypted}, nil
+}
+
+func trySnapshotShards(dir string, repos *regexp.Regexp) ([]archiveRepo, []*snapshotFile, error) {
+	have, err := localShardFiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Shard files are never written in place, so a file which is the one we
+	// read the metadata of once it is open has the contents it was read
+	// with.
+	stats := map[string]os.FileInfo{}
+	for _, f := range have {
+		if fi, err := os.Stat(filepath.Join(dir, f.Name)); err == nil {
+			stats[f.Name] = fi
+		}
+	}
+
+	shards := map[string]*snapshotShard{}
+	matching := map[string]bool{}
+	for name := range stats {
+		if !strings.HasSuffix(name, ".zoekt") {
+			continue
+		}
+		s, err := readSnapshotShard(filepath.Join(dir, name))
+		if err != nil {
+			// Reported by zoekt index doctor.
+			continue
+		}
+		shards[name] = s
+		if slices.ContainsFunc(s.repos, func(r *zoekt.Repository) bool { return !r.Tombstone && repos.MatchString(r.Name) }) {
+			matching[name] = true
+		}
+	}
+
+	selected := map[string]bool{}
+	for name := range matching {
+		if !index.IsContentAddressedShard(name) {
+			selected[name] = true
+		}
+	}
+	// Content-addressed shards are only searched if a manifest lists them.
+	// Manifests are exported with all the shards they list.
+	for name := range stats {
+		if !strings.HasSuffix(name, ".manifest") {
+			continue
+		}
+		m, err := index.ReadShardManifest(filepath.Join(dir, name))
+		if err != nil || !slices.ContainsFunc(m.Shards, func(s string) bool { return matching[s] }) {
+			continue
+		}
+		selected[name] = true
+		for _, s := range m.Shards {
+			if shards[s] == nil {
+				return nil, nil, fmt.Errorf("%s lists the missing or unreadable shard %s: %w", name, s, errSnapshotChanged)
+			}
+			selected[s] = true
+		}
+	}
+	// The shards which hold repositories not matching repos get a ".meta"
+	// file tombstoning them.
+	hiding := map[string]bool{}
+	for name := range selected {
+		s := shards[name]
+		if s == nil {
+			continue
+		}
+		if slices.ContainsFunc(s.repos, func(r *zoekt.Repository) bool { return !r.Tombstone && !repos.MatchString(r.Name) }) {
+			hiding[name] = true
+		} else if _, ok := stats[name+".meta"]; ok {
+			selected[name+".meta"] = true
+		}
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rs []archiveRepo
+	files := make([]*snapshotFile, 0, len(names))
+	fail := func(err error) ([]archiveRepo, []*snapshotFile, error) {
+		closeSnapshot(files)
+		return nil, nil, err
+	}
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return fail(fmt.Errorf("%s was removed: %w", name, errSnapshotChanged))
+		} else if err != nil {
+			return fail(err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return fail(err)
+		}
+		files = append(files, &snapshotFile{archiveFile: archiveFile{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, f: f})
+		if !os.SameFile(fi, stats[name]) {
+			return fail(fmt.Errorf("%s was replaced: %w", name, errSnapshotChanged))
+		}
+
+		s := shards[name]
+		if s == nil {
+			continue
+		}
+		af := &files[len(files)-1].archiveFile
+		af.IndexFormatVersion = s.md.IndexFormatVersion
+		af.IndexFeatureVersion = s.md.IndexFeatureVersion
+		af.IndexMinReaderVersion = s.md.IndexMinReaderVersion
+		af.Encrypted = s.encrypted
+		for _, r := range s.repos {
+			if r.Tombstone || !repos.MatchString(r.Name) {
+				r.Tombstone = true
+				continue
+			}
+			rs = append(rs, archiveRepo{Name: r.Name, ID: r.ID, TenantID: r.TenantID, Shard: name})
+		}
+		if hiding[name] {
+			meta, err := tombstoneMeta(s)
+			if err != nil {
+				return fail(err)
+			}
+			files = append(files, &snapshotFile{archiveFile: archiveFile{Name: name + ".meta", Size: int64(len(meta)), ModTime: fi.ModTime()}, data: meta})
+		}
+	}
+	return rs, files, nil
+}
+
+// tombstoneMeta returns the ".meta" file of the shard s, whose repositories
+// which aren't exported are tombstones.
+func tombstoneMeta(s *snapshotShard) ([]byte, error) {
+	// Before format 17, the ".meta" file holds a single repository.
+	var v any = s.repos
+	if s.md.IndexFormatVersion < 17 {
+		v = s.repos[0]
+	}
+	return json.Marshal(v)
+}
+
+func closeSnapshot(files []*snapshotFile) {
+	for _, f := range files {
+		if f.f != nil {
+			f.f.Close()
+		}
+	}
+}
+
+// exportArchive writes a snapshot of the shards in dir holding a repository
+// matching repos to the archive path, signed with priv.
+func exportArchive(dir string, repos *regexp.Regexp, priv ed25519.PrivateKey, path string) (*archiveManifest, error) {
+	rs, files, err := snapshotShards(dir, repos)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSnapshot(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no shards of repositories matching %s", repos)
+	}
+
+	m := &archiveManifest{
+		Version:      archiveVersion,
+		Created:      time.Now().UTC(),
+		ZoektVersion: index.Version,
+		Repositories: rs,
+	}
+	// The checksums are part of the manifest, which comes first, so the
+	// files are read twice.
+	for _, f := range files {
+		h := sha256.New()
+		if _, err := io.Copy(h, f.reader()); err != nil {
+			return nil, err
+		}
+		if f.f != nil {
+			if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		f.SHA256 = hex.EncodeToString(h.Sum(nil))
+		m.Files = append(m.Files, f.archiveFile)
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeArchive(out, m, manifest, ed25519.Sign(priv, manifest), files); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return nil, err
+	}
+	return m, os.Rename(out.Name(), path)
+}
+
+func writeArchive(w io.Writer, m *archiveManifest, manifest, sig []byte, files []*snapshotFile) error {
+	bw := bufio.NewWriter(w)
+	tw := tar.NewWriter(bw)
+	writeEntry := func(name string, size int64, modTime time.Time, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Size:     size,
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+		n, err := io.Copy(tw, r)
+		if err == nil && n != size {
+			err = fmt.Errorf("%s: read %d of %d bytes", name, n, size)
+		}
+		return err
+	}
+
+	if err := writeEntry(archiveManifestName, int64(len(manifest)), m.Created, bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+	if err := writeEntry(archiveSignatureName, int64(len(sig)), m.Created, bytes.NewReader(sig)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := writeEntry(f.Name, f.Size, f.ModTime, f.reader()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readArchiveManifest reads the manifest at the start of an archive, and
+// checks its signature with pub.
+func readArchiveManifest(tr *tar.Reader, pub ed25519.PublicKey) (*archiveManifest, error) {
+	readEntry := func(name string, maxSize int64) ([]byte, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		if hdr.Name != name {
+			return nil, fmt.Errorf("not a zoekt archive: got %q, want %s", hdr.Name, name)
+		}
+		if hdr.Size > maxSize {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, maxSize)
+		}
+		return io.ReadAll(tr)
+	}
+
+	manifest, err := readEntry(archiveManifestName, maxArchiveManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := readEntry(archiveSignatureName, ed25519.SignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, manifest, sig) {
+		return nil, errors.New("the signature doesn't match: the archive was modified or signed with another key")
+	}
+
+	var m archiveManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", archiveManifestName, err)
+	}
+	if m.Version != archiveVersion {
+		return nil, fmt.Errorf("archive version %d is not supported, want %d", m.Version, archiveVersion)
+	}
+	return &m, nil
+}
+
+// checkArchive returns why the archive with manifest m can't be imported
+// with tenants.
+func checkArchive(m *archiveManifest, tenants map[int]int) error {
+	files := map[string]*archiveFile{}
+	for i := range m.Files {
+		f := &m.Files[i]
+		if !isShardFileName(f.Name) {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+		if files[f.Name] != nil {
+			return fmt.Errorf("%s is listed twice", f.Name)
+		}
+		files[f.Name] = f
+		if !f.isShard() {
+			continue
+		}
+		if desc, _ := checkVersion(f.metadata()); desc != "" {
+			return fmt.Errorf("%s: %s", f.Name, desc)
+		}
+	}
+	for _, r := range m.Repositories {
+		f := files[r.Shard]
+		if f == nil || !f.isShard() {
+			return fmt.Errorf("%s is in the missing shard %s", r.Name, r.Shard)
+		}
+		if _, err := mapTenant(tenants, r.Name, r.Shard, r.TenantID, f.Encrypted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive writes the files of the archive with manifest m to dir,
+// checking their sizes and checksums.
+func extractArchive(tr *tar.Reader, m *archiveManifest, dir string) error {
+	want := map[string]archiveFile{}
+	for _, f := range m.Files {
+		want[f.Name] = f
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		f, ok := want[hdr.Name]
+		if !ok {
+			return fmt.Errorf("unexpected file %q", hdr.Name)
+		}
+		delete(want, hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || hdr.Size != f.Size {
+			return fmt.Errorf("%s: not a regular file of %d bytes", f.Name, f.Size)
+		}
+		if err := extractFile(tr, f, filepath.Join(dir, f.Name)); err != nil {
+			return err
+		}
+	}
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for name := range want {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("the archive is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, f archiveFile, path string) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return fmt.Errorf("%s: checksum %s, want %s", f.Name, sum, f.SHA256)
+	}
+	// Like zoekt pull, keep the modification time of the exported file.
+	return os.Chtimes(path, f.ModTime, f.ModTime)
+}
+
+// mapShardTenants checks the shard fn after it is extracted, and writes a
+// ".meta" file with the tenants of its repositories mapped with tenants. It
+// returns true if it wrote a ".meta" file.
+func mapShardTenants(fn string, f *archiveFile, tenants map[int]int) (bool, error) {
+	repos, md, err := index.ReadMetadataPath(fn)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	if desc, _ := checkVersion(md); desc != "" {
+		return false, fmt.Errorf("%s: %s", f.Name, desc)
+	}
+
+	changed := false
+	for _, r := range repos {
+		// Tombstones aren't searched, so their tenant doesn't matter.
+		if r.Tombstone {
+			continue
+		}
+		to, err := mapTenant(tenants, r.Name, f.Name, r.TenantID, f.Encrypted)
+		if err != nil {
+			return false, err
+		}
+		if to != r.TenantID {
+			r.TenantID = to
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	// Before format 17, the ".meta" file holds a single repository.
+	var v any = repos
+	if md.IndexFormatVersion < 17 {
+		v = repos[0]
+	}
+	tmp, final, err := index.JsonMarshalRepoMetaTemp(fn, v)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// importMove is a step of moving the imported files into place: the file
+// name in the index directory was replaced by the one of the archive if
+// imported, and moved to the backup directory if backedUp.
+type importMove struct {
+	name     string
+	imported bool
+	backedUp bool
+}
+
+// moveImportedFiles moves the files names from tmpDir to dir, in order. The
+// files they replace, and the ".meta" files of shards without one in files,
+// are moved to a backup directory first, so a failure moves them back. If
+// that fails too, the error lists the files which are left from the archive
+// and the backup directory is kept.
+func moveImportedFiles(dir, tmpDir string, names []string, files map[string]bool) error {
+	backupDir, err := os.MkdirTemp(dir, ".zoekt-replaced-")
+	if err != nil {
+		return err
+	}
+	keepBackup := false
+	defer func() {
+		if !keepBackup {
+			os.RemoveAll(backupDir)
+		}
+	}()
+
+	var moves []importMove
+	backup := func(name string) (bool, error) {
+		err := os.Rename(filepath.Join(dir, name), filepath.Join(backupDir, name))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	move := func(name string) error {
+		if strings.HasSuffix(name, ".zoekt") && !files[name+".meta"] {
+			backedUp, err := backup(name + ".meta")
+			if err != nil {
+				return err
+			}
+			if backedUp {
+				moves = append(moves, importMove{name: name + ".meta", backedUp: true})
+			}
+		}
+		backedUp, err := backup(name)
+		if err != nil {
+			return err
+		}
+		moves = append(moves, importMove{name: name, backedUp: backedUp})
+		if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		moves[len(moves)-1].imported = true
+		return nil
+	}
+
+	for _, name := range names {
+		err := move(name)
+		if err == nil {
+			continue
+		}
+		var left, lost []string
+		for i := len(moves) - 1; i >= 0; i-- {
+			mv := moves[i]
+			dst := filepath.Join(dir, mv.name)
+			if mv.backedUp {
+				if os.Rename(filepath.Join(backupDir, mv.name), dst) != nil {
+					lost = append(lost, mv.name)
+					if mv.imported {
+						left = append(left, mv.name)
+					}
+				}
+			} else if mv.imported && os.Remove(dst) != nil {
+				left = append(left, mv.name)
+			}
+		}
+		var undo []string
+		if len(left) > 0 {
+			slices.Reverse(left)
+			undo = append(undo, fmt.Sprintf("%s are from the archive", strings.Join(left, ", ")))
+		}
+		if len(lost) > 0 {
+			keepBackup = true
+			slices.Reverse(lost)
+			undo = append(undo, fmt.Sprintf("the previous %s are in %s", strings.Join(lost, ", "), backupDir))
+		}
+		if len(undo) > 0 {
+			return fmt.Errorf("%w; undoing the import failed: %s", err, strings.Join(undo, "; "))
+		}
+		return err
+	}
+	return nil
+}
+
+type importStats struct {
+	repos, files, removed int
+}
+
+// importArchive imports the archive path, verified with pub, into dir,
+// mapping the tenants of the repositories with tenants unless it is nil.
+// With replace, it removes the shards of imported repositories which the
+// archive doesn't have.
+func importArchive(dir, path string, pub ed25519.PublicKey, tenants map[int]int, replace bool) (importStats, error) {
+	var st importStats
+	f, err := os.Open(path)
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(bufio.NewReader(f))
+	m, err := readArchiveManifest(tr, pub)
+	if err != nil {
+		return st, err
+	}
+	if err := checkArchive(m, tenants); err != nil {
+		return st, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return st, err
+	}
+	// Extracting to a directory next to the shards lets us rename the files
+	// into place, and the dot keeps the shard watcher from loading them.
+	tmpDir, err := os.MkdirTemp(dir, ".zoekt-import-")
+	if err != nil {
+		return st, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractArchive(tr, m, tmpDir); err != nil {
+		return st, err
+	}
+
+	files := map[string]bool{}
+	for _, f := range m.Files {
+		files[f.Name] = true
+	}
+	for i := range m.Files {
+		f := &m.Files[i]
+		if !f.isShard() {
+			continue
+		}
+		wrote, err := mapShardTenants(filepath.Join(tmpDir, f.Name), f, tenants)
+		if err != nil {
+			return st, err
+		}
+		if wrote {
+			files[f.Name+".meta"] = true
+		}
+	}
+
+	have, err := localShardFiles(dir)
+	if err != nil {
+		return st, err
+	}
+
+	// The ".meta" files come first, so no shard is loaded with the ".meta"
+	// file of its previous version, and the manifests last, so they only
+	// list shards which are in place.
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	rank := func(name string) int {
+		switch {
+		case strings.HasSuffix(name, ".meta"):
+			return 0
+		case strings.HasSuffix(name, ".zoekt"):
+			return 1
+		}
+		return 2
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if c := rank(a) - rank(b); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	if err := moveImportedFiles(dir, tmpDir, names, files); err != nil {
+		return st, err
+	}
+	st.files = len(names)
+
+	st.repos = countRepos(m.Repositories)
+	if replace {
+		var patterns []string
+		for _, r := range m.Repositories {
+			patterns = append(patterns, regexp.QuoteMeta(r.Name))
+		}
+		if len(patterns) == 0 {
+			return st, nil
+		}
+		repos := regexp.MustCompile("^(?:" + strings.Join(patterns, "|") + ")$")
+		if st.removed, err = removeStaleShards(dir, have, files, repos); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
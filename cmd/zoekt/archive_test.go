@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func runArchiveForTest(t *testing.T, wantStatus int, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if got := runArchive(args, &out); got != wantStatus {
+		t.Fatalf("zoekt archive %s: got status %d, want %d\n%s", strings.Join(args, " "), got, wantStatus, out.String())
+	}
+	return out.String()
+}
+
+func TestArchive(t *testing.T) {
+	keyDir := t.TempDir()
+	key := filepath.Join(keyDir, "key")
+	runArchiveForTest(t, 0, "keygen", "-key", key)
+	// An existing key is never overwritten.
+	runArchiveForTest(t, 1, "keygen", "-key", key)
+
+	srcDir := t.TempDir()
+	writeShard(t, filepath.Join(srcDir, "repo-a_v16.00000.zoekt"), &zoekt.Repository{ID: 1, Name: "repo-a", TenantID: 1})
+	writeShard(t, filepath.Join(srcDir, "repo-b_v16.00000.zoekt"), &zoekt.Repository{ID: 2, Name: "repo-b", TenantID: 1})
+	writeShard(t, filepath.Join(srcDir, "other_v16.00000.zoekt"), &zoekt.Repository{ID: 3, Name: "other", TenantID: 1})
+	if err := os.WriteFile(filepath.Join(srcDir, "repo-b_v16.00000.zoekt.meta"), []byte(`{"ID":2,"Name":"repo-b","TenantID":1,"Rank":7}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "repos.tar")
+	runArchiveForTest(t, 0, "export", "-index_dir", srcDir, "-key", key, "-o", archive, "^repo-")
+	runArchiveForTest(t, 1, "export", "-index_dir", srcDir, "-key", key, "-o", archive+"2", "^nothing$")
+
+	// The destination has a shard of an imported repository which the
+	// archive doesn't have, and a shard of another repository.
+	dstDir := t.TempDir()
+	writeShard(t, filepath.Join(dstDir, "repo-a_v16.00001.zoekt"), &zoekt.Repository{ID: 1, Name: "repo-a", TenantID: 1})
+	writeShard(t, filepath.Join(dstDir, "mine_v16.00000.zoekt"), &zoekt.Repository{ID: 5, Name: "mine", TenantID: 1})
+	out := runArchiveForTest(t, 0, "import", "-index_dir", dstDir, "-key", key+".pub", "-replace", archive)
+	if !strings.Contains(out, "Imported 2 repositories in 3 file(s), 1 removed.") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	for _, name := range []string{"repo-a_v16.00000.zoekt", "repo-b_v16.00000.zoekt", "repo-b_v16.00000.zoekt.meta"} {
+		want, err := os.ReadFile(filepath.Join(srcDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the exported file", name)
+		}
+	}
+	entries, err := os.ReadDir(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := "mine_v16.00000.zoekt repo-a_v16.00000.zoekt repo-b_v16.00000.zoekt repo-b_v16.00000.zoekt.meta"; strings.Join(names, " ") != want {
+		t.Errorf("got files %v, want %s", names, want)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+	rl, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 3 {
+		t.Errorf("got %d repositories after the import, want 3", len(rl.Repos))
+	}
+
+	t.Run("compound shard", func(t *testing.T) {
+		src := t.TempDir()
+		a, b := filepath.Join(src, "a.zoekt"), filepath.Join(src, "b.zoekt")
+		writeShard(t, a, &zoekt.Repository{ID: 1, Name: "repo-a", TenantID: 1})
+		writeShard(t, b, &zoekt.Repository{ID: 2, Name: "secret", TenantID: 2})
+		tmpName, compound, err := index.Merge(src, openIndexFile(t, a), openIndexFile(t, b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpName, compound); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(a)
+		os.Remove(b)
+
+		archive := filepath.Join(t.TempDir(), "compound.tar")
+		runArchiveForTest(t, 0, "export", "-index_dir", src, "-key", key, "-o", archive, "^repo-a$")
+
+		// Only repo-a is in the manifest, so the tenant of secret needs no
+		// mapping, and the ".meta" file tombstones secret.
+		dir := t.TempDir()
+		out := runArchiveForTest(t, 0, "import", "-index_dir", dir, "-key", key+".pub", "-tenant_map", "1=1", archive)
+		if !strings.Contains(out, "Imported 1 repositories in 2 file(s)") {
+			t.Errorf("unexpected output %q", out)
+		}
+		repos, _, err := index.ReadMetadataPathAlive(filepath.Join(dir, filepath.Base(compound)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repos) != 1 || repos[0].Name != "repo-a" {
+			t.Errorf("got alive repositories %+v, want repo-a", repos)
+		}
+		if _, err := os.Stat(filepath.Join(src, filepath.Base(compound)+".meta")); !os.IsNotExist(err) {
+			t.Errorf("the export changed the source directory: %v", err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		blob, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := bytes.Index(blob, []byte("package main"))
+		if i < 0 {
+			t.Fatal("archive doesn't contain the file contents")
+		}
+		tampered := bytes.Clone(blob)
+		tampered[i] = 'P'
+		path := filepath.Join(t.TempDir(), "tampered.tar")
+		if err := os.WriteFile(path, tampered, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		out := runArchiveForTest(t, 1, "import", "-index_dir", dir, "-key", key+".pub", path)
+		if !strings.Contains(out, "checksum") {
+			t.Errorf("got %q, want a checksum error", out)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("failed import left %d file(s)", len(entries))
+		}
+
+		other := filepath.Join(keyDir, "other")
+		runArchiveForTest(t, 0, "keygen", "-key", other)
+		out = runArchiveForTest(t, 1, "import", "-index_dir", dir, "-key", other+".pub", archive)
+		if !strings.Contains(out, "signature") {
+			t.Errorf("got %q, want a signature error", out)
+		}
+	})
+
+	t.Run("tenant map", func(t *testing.T) {
+		dir := t.TempDir()
+		out := runArchiveForTest(t, 1, "import", "-index_dir", dir, "-key", key+".pub", "-tenant_map", "3=4", archive)
+		if !strings.Contains(out, "doesn't map tenant 1") {
+			t.Errorf("got %q, want an unmapped tenant error", out)
+		}
+
+		runArchiveForTest(t, 0, "import", "-index_dir", dir, "-key", key+".pub", "-tenant_map", "1=2", archive)
+		for _, name := range []string{"repo-a_v16.00000.zoekt", "repo-b_v16.00000.zoekt"} {
+			repos, _, err := index.ReadMetadataPath(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(repos) != 1 || repos[0].TenantID != 2 {
+				t.Errorf("%s: got %+v, want tenant 2", name, repos)
+			}
+			if name == "repo-b_v16.00000.zoekt" && repos[0].Rank != 7 {
+				t.Errorf("%s: mapping the tenant lost the rank of the .meta file", name)
+			}
+		}
+
+		if _, err := mapTenant(map[int]int{1: 2}, "repo-a", "repo-a_v16.00000.zoekt", 1, true); err == nil {
+			t.Error("mapped the tenant of a shard with encrypted contents")
+		}
+		if to, err := mapTenant(map[int]int{1: 1}, "repo-a", "repo-a_v16.00000.zoekt", 1, true); err != nil || to != 1 {
+			t.Errorf("keeping the tenant of an encrypted shard: got %d, %v", to, err)
+		}
+	})
+}
+
+func TestMoveImportedFilesUndo(t *testing.T) {
+	dir, tmpDir := t.TempDir(), t.TempDir()
+	write := func(fn, content string) {
+		if err := os.WriteFile(fn, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "a.zoekt"), "old")
+	write(filepath.Join(dir, "a.zoekt.meta"), "old meta")
+	write(filepath.Join(tmpDir, "a.zoekt"), "new")
+
+	// b.zoekt is missing, so moving it fails after a.zoekt replaced the old
+	// shard and its ".meta" file.
+	err := moveImportedFiles(dir, tmpDir, []string{"a.zoekt", "b.zoekt"}, map[string]bool{"a.zoekt": true, "b.zoekt": true})
+	if err == nil {
+		t.Fatal("moving a missing file succeeded")
+	}
+
+	got := map[string]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[e.Name()] = string(b)
+	}
+	want := map[string]string{"a.zoekt": "old", "a.zoekt.meta": "old meta"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected files after the failed move (-want +got):\n%s", diff)
+	}
+}
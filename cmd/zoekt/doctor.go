@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// problem is something wrong with the files in an index directory. Most of
+// them only show up as errors loading shards, or as repositories which are
+// never searched.
+type problem struct {
+	path string
+	desc string
+
+	// fix describes the repair. repair is nil if it must be done by hand.
+	fix    string
+	repair func() error
+}
+
+func removeFiles(paths ...string) func() error {
+	return func() error {
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// removeShard returns a problem of the shard fn, repaired by removing the
+// shard and its ".meta" file.
+func removeShard(fn, desc, fix string) problem {
+	return problem{path: fn, desc: desc, fix: fix, repair: removeFiles(fn, fn+".meta")}
+}
+
+// checkVersion returns why a shard with metadata md can't be searched, or ""
+// if it can. newer is whether the shard was written by a newer version of
+// zoekt, so this version must not remove it.
+func checkVersion(md *zoekt.IndexMetadata) (desc string, newer bool) {
+	switch {
+	case md.IndexFormatVersion > index.NextIndexFormatVersion:
+		return fmt.Sprintf("index format v%d is newer than v%d", md.IndexFormatVersion, index.NextIndexFormatVersion), true
+	case md.IndexFormatVersion != index.IndexFormatVersion && md.IndexFormatVersion != index.NextIndexFormatVersion:
+		return fmt.Sprintf("index format v%d is older than v%d", md.IndexFormatVersion, index.IndexFormatVersion), false
+	case md.IndexMinReaderVersion > index.ReadMaxFeatureVersion:
+		return fmt.Sprintf("shard needs read feature version %d, have %d", md.IndexMinReaderVersion, index.ReadMaxFeatureVersion), true
+	case md.IndexFeatureVersion < index.ReadMinFeatureVersion:
+		return fmt.Sprintf("feature version %d is older than %d", md.IndexFeatureVersion, index.ReadMinFeatureVersion), false
+	}
+	return "", false
+}
+
+// checkMeta returns an error if the ".meta" file of a shard can't be
+// decoded. Depending on the index format, it holds a repository or a list of
+// them.
+func checkMeta(path string) error {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var repos []*zoekt.Repository
+	if err := json.Unmarshal(blob, &repos); err == nil {
+		return nil
+	}
+	var repo zoekt.Repository
+	return json.Unmarshal(blob, &repo)
+}
+
+// diagnose returns the problems of the index directory dir.
+func diagnose(dir string) ([]problem, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[e.Name()] = true
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []problem
+	published := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".manifest") {
+			continue
+		}
+		fn := filepath.Join(dir, name)
+		m, err := index.ReadShardManifest(fn)
+		if err != nil {
+			problems = append(problems, problem{path: fn, desc: fmt.Sprintf("unreadable manifest: %v", err), fix: "reindex its repository"})
+			continue
+		}
+		for _, shard := range m.Shards {
+			published[shard] = true
+			if !files[shard] {
+				problems = append(problems, problem{path: fn, desc: fmt.Sprintf("lists missing shard %s", shard), fix: "reindex its repository"})
+			}
+		}
+	}
+
+	for _, name := range names {
+		fn := filepath.Join(dir, name)
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			problems = append(problems, problem{path: fn, desc: "temporary file of an interrupted write", fix: "remove it", repair: removeFiles(fn)})
+
+		case strings.HasSuffix(name, ".zoekt.meta"):
+			if !files[strings.TrimSuffix(name, ".meta")] {
+				problems = append(problems, problem{path: fn, desc: "orphaned .meta file without its shard", fix: "remove it", repair: removeFiles(fn)})
+			}
+
+		case strings.HasSuffix(name, ".zoekt"):
+			if p, ok := diagnoseShard(fn, files[name+".meta"]); ok {
+				problems = append(problems, p)
+			} else if index.IsContentAddressedShard(fn) && !published[name] {
+				problems = append(problems, removeShard(fn, "content-addressed shard which no manifest lists, so it is never searched", "remove it"))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// diagnoseShard returns the problem of the shard fn, if it has one.
+func diagnoseShard(fn string, hasMeta bool) (problem, bool) {
+	// A broken .meta file breaks reading the shard, but the shard itself can
+	// still be fine.
+	if hasMeta {
+		if err := checkMeta(fn + ".meta"); err != nil {
+			return problem{
+				path:   fn + ".meta",
+				desc:   fmt.Sprintf("unreadable .meta file: %v", err),
+				fix:    "remove it, which reverts the metadata to the one in the shard and removes its tombstones",
+				repair: removeFiles(fn + ".meta"),
+			}, true
+		}
+	}
+
+	repos, md, err := index.ReadMetadataPath(fn)
+	if md != nil {
+		if desc, newer := checkVersion(md); newer {
+			return problem{path: fn, desc: desc, fix: "search it with a newer version of zoekt"}, true
+		} else if desc != "" {
+			return removeShard(fn, desc, "remove it and reindex its repositories"), true
+		}
+	}
+	if err != nil {
+		return removeShard(fn, fmt.Sprintf("unreadable shard: %v", err), "remove it and reindex its repositories"), true
+	}
+
+	if len(repos) > 0 && allTombstoned(repos) {
+		return removeShard(fn, fmt.Sprintf("all %d repositories are tombstoned", len(repos)), "remove it"), true
+	}
+	return problem{}, false
+}
+
+func allTombstoned(repos []*zoekt.Repository) bool {
+	for _, r := range repos {
+		if !r.Tombstone {
+			return false
+		}
+	}
+	return true
+}
+
+// runDoctor implements "zoekt index doctor". It prints the problems of an
+// index directory, and with -fix repairs those it can. It returns the exit
+// status: 1 if problems remain.
+func runDoctor(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("index doctor", flag.ContinueOnError)
+	fs.SetOutput(out)
+	indexDir := fs.String("index_dir",
+		filepath.Join(os.Getenv("HOME"), ".zoekt"), "check the index files in `directory`")
+	fix := fs.Bool("fix", false, "repair the problems which are fixed by removing files. Only use this while no indexer writes to the directory, since it removes the temporary files of writes in progress")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage:\n\n  %s index doctor [option]\n\n"+
+			"Reports shards which can't be searched and leftover files in an index directory.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	problems, err := diagnose(*indexDir)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	remaining := 0
+	for _, p := range problems {
+		fmt.Fprintf(out, "%s: %s\n", p.path, p.desc)
+		switch {
+		case !*fix || p.repair == nil:
+			fmt.Fprintf(out, "\tfix: %s\n", p.fix)
+			remaining++
+		default:
+			if err := p.repair(); err != nil {
+				fmt.Fprintf(out, "\trepair failed: %v\n", err)
+				remaining++
+			} else {
+				fmt.Fprintf(out, "\tfixed: %s\n", p.fix)
+			}
+		}
+	}
+
+	if remaining > 0 {
+		if !*fix {
+			fmt.Fprintf(out, "%d problem(s). Run with -fix to apply the fixes which remove files.\n", remaining)
+		} else {
+			fmt.Fprintf(out, "%d problem(s) remain.\n", remaining)
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func writeShard(t *testing.T, fn string, repo *zoekt.Repository) {
+	t.Helper()
+	b, err := index.NewShardBuilder(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("main.go", []byte("package main\n")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openIndexFile(t *testing.T, fn string) index.IndexFile {
+	t.Helper()
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iFile, err := index.NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(iFile.Close)
+	return iFile
+}
+
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeShard(t, filepath.Join(dir, "good_v16.00000.zoekt"), &zoekt.Repository{ID: 1, Name: "good"})
+	writeShard(t, filepath.Join(dir, "badmeta_v16.00000.zoekt"), &zoekt.Repository{ID: 2, Name: "badmeta"})
+	write("badmeta_v16.00000.zoekt.meta", "{")
+	write("corrupt_v16.00000.zoekt", "not a shard")
+	write("orphan_v16.00000.zoekt.meta", "{}")
+	write("half_v16.00000.zoekt.123.tmp", "")
+	write("repo.manifest", `{"Shards": ["repo_v16.0123456789abcdef0123456789abcdef.zoekt"]}`)
+
+	// A compound shard of tombstoned repositories.
+	a, b := filepath.Join(dir, "a.zoekt"), filepath.Join(dir, "b.zoekt")
+	writeShard(t, a, &zoekt.Repository{ID: 3, Name: "a"})
+	writeShard(t, b, &zoekt.Repository{ID: 4, Name: "b"})
+	tmpName, compound, err := index.Merge(dir, openIndexFile(t, a), openIndexFile(t, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, compound); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(a)
+	os.Remove(b)
+	for _, id := range []uint32{3, 4} {
+		if err := index.SetTombstone(compound, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := diagnose(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, p := range problems {
+		got[filepath.Base(p.path)] = p.repair != nil
+	}
+	want := map[string]bool{
+		"badmeta_v16.00000.zoekt.meta": true,
+		"corrupt_v16.00000.zoekt":      true,
+		"orphan_v16.00000.zoekt.meta":  true,
+		"half_v16.00000.zoekt.123.tmp": true,
+		"repo.manifest":                false,
+		filepath.Base(compound):        true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("problems mismatch (-want +repairable):\n%s", diff)
+	}
+
+	var out bytes.Buffer
+	if status := runDoctor([]string{"-index_dir", dir}, &out); status != 1 {
+		t.Fatalf("got exit status %d without -fix, want 1:\n%s", status, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt_v16.00000.zoekt")); err != nil {
+		t.Fatalf("removed a file without -fix: %v", err)
+	}
+
+	out.Reset()
+	if status := runDoctor([]string{"-index_dir", dir, "-fix"}, &out); status != 1 {
+		t.Fatalf("got exit status %d with -fix, want 1 for the manifest:\n%s", status, out.String())
+	}
+
+	var remaining []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		remaining = append(remaining, e.Name())
+	}
+	wantRemaining := []string{"badmeta_v16.00000.zoekt", "good_v16.00000.zoekt", "repo.manifest"}
+	if diff := cmp.Diff(wantRemaining, remaining); diff != "" {
+		t.Errorf("files after -fix mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	for _, tc := range []struct {
+		md        zoekt.IndexMetadata
+		ok, newer bool
+	}{
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.IndexFormatVersion, IndexFeatureVersion: index.FeatureVersion}, ok: true},
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.NextIndexFormatVersion, IndexFeatureVersion: index.FeatureVersion}, ok: true},
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.IndexFormatVersion - 1, IndexFeatureVersion: index.FeatureVersion}},
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.NextIndexFormatVersion + 1}, newer: true},
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.IndexFormatVersion, IndexFeatureVersion: index.ReadMinFeatureVersion - 1}},
+		{md: zoekt.IndexMetadata{IndexFormatVersion: index.IndexFormatVersion, IndexFeatureVersion: index.FeatureVersion, IndexMinReaderVersion: index.ReadMaxFeatureVersion + 1}, newer: true},
+	} {
+		desc, newer := checkVersion(&tc.md)
+		if (desc == "") != tc.ok || newer != tc.newer {
+			t.Errorf("%+v: got %q, newer %v", tc.md, desc, newer)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// SGR sequences of GNU grep's default GREP_COLORS.
+const (
+	colorMatch     = "01;31"
+	colorFileName  = "35"
+	colorLineNum   = "32"
+	colorSeparator = "36"
+)
+
+// grepWriter writes matches like grep -Hn --column, one line per match, as
+// editor integrations expect them:
+//
+//	path:line:column:text
+//
+// Columns are 1-based byte offsets. Matches on file names have no line, so
+// they are only listed with -l.
+type grepWriter struct {
+	w *bufio.Writer
+
+	withRepo bool
+	list     bool
+
+	// null separates the path from the rest with a NUL byte, or ends it with
+	// one if list is set, like grep -Z.
+	null bool
+
+	color bool
+}
+
+func (g *grepWriter) write(files []zoekt.FileMatch) error {
+	for _, f := range files {
+		path := f.FileName
+		if g.withRepo {
+			path = f.Repository + "/" + path
+		}
+
+		if g.list {
+			g.colored(colorFileName, path)
+			if g.null {
+				g.w.WriteByte(0)
+			} else {
+				g.w.WriteByte('\n')
+			}
+			continue
+		}
+
+		lines := slices.Clone(f.LineMatches)
+		slices.SortStableFunc(lines, func(a, b zoekt.LineMatch) int { return cmp.Compare(a.LineNumber, b.LineNumber) })
+		for _, l := range lines {
+			if l.FileName {
+				continue
+			}
+			text := bytes.TrimSuffix(l.Line, []byte{'\n'})
+			frags := slices.Clone(l.LineFragments)
+			slices.SortFunc(frags, func(a, b zoekt.LineFragmentMatch) int { return cmp.Compare(a.LineOffset, b.LineOffset) })
+			for _, m := range frags {
+				g.colored(colorFileName, path)
+				if g.null {
+					g.w.WriteByte(0)
+				} else {
+					g.separator()
+				}
+				g.colored(colorLineNum, strconv.Itoa(l.LineNumber))
+				g.separator()
+				// Line is only a window of long lines, so the column is
+				// computed from the offset in the file.
+				g.colored(colorLineNum, strconv.Itoa(int(m.Offset)-l.LineStart+1))
+				g.separator()
+				g.line(text, frags)
+				g.w.WriteByte('\n')
+			}
+		}
+	}
+	return g.w.Flush()
+}
+
+// line writes text, highlighting all matches on it.
+func (g *grepWriter) line(text []byte, frags []zoekt.LineFragmentMatch) {
+	if !g.color {
+		g.w.Write(text)
+		return
+	}
+	last := 0
+	for _, m := range frags {
+		start, end := max(m.LineOffset, last), min(m.LineOffset+m.MatchLength, len(text))
+		if start >= end {
+			continue
+		}
+		g.w.Write(text[last:start])
+		g.colored(colorMatch, string(text[start:end]))
+		last = end
+	}
+	g.w.Write(text[last:])
+}
+
+func (g *grepWriter) separator() {
+	g.colored(colorSeparator, ":")
+}
+
+func (g *grepWriter) colored(sgr, s string) {
+	if !g.color {
+		g.w.WriteString(s)
+		return
+	}
+	fmt.Fprintf(g.w, "\x1b[%sm\x1b[K%s\x1b[m\x1b[K", sgr, s)
+}
+
+// useColor interprets the value of -color: "always", "never", or "auto",
+// which colors the output if it goes to a terminal.
+func useColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		fi, err := out.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("-color must be always, never or auto, got %q", mode)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestGrepWriter(t *testing.T) {
+	files := []zoekt.FileMatch{{
+		Repository: "repo",
+		FileName:   "a.go",
+		LineMatches: []zoekt.LineMatch{{
+			Line:          []byte("x needle needle\n"),
+			LineStart:     20,
+			LineEnd:       36,
+			LineNumber:    7,
+			LineFragments: []zoekt.LineFragmentMatch{{Offset: 29, LineOffset: 9, MatchLength: 6}, {Offset: 22, LineOffset: 2, MatchLength: 6}},
+		}, {
+			Line:          []byte("needle"),
+			LineNumber:    3,
+			LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 0, MatchLength: 6}},
+		}, {
+			// A window of a long line.
+			Line:          []byte("le needle nee"),
+			LineStart:     40,
+			LineEnd:       10000,
+			LineNumber:    8,
+			LineFragments: []zoekt.LineFragmentMatch{{Offset: 5003, LineOffset: 3, MatchLength: 6}},
+		}, {
+			Line:          []byte("a.go"),
+			FileName:      true,
+			LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 0, MatchLength: 1}},
+		}},
+	}, {
+		Repository: "repo",
+		FileName:   "b.go",
+	}}
+
+	cases := []struct {
+		name  string
+		g     grepWriter
+		files []zoekt.FileMatch
+		want  string
+	}{{
+		name: "plain",
+		want: "a.go:3:1:needle\n" +
+			"a.go:7:3:x needle needle\n" +
+			"a.go:7:10:x needle needle\n" +
+			"a.go:8:4964:le needle nee\n",
+	}, {
+		name: "repo and null",
+		g:    grepWriter{withRepo: true, null: true},
+		want: "repo/a.go\x003:1:needle\n" +
+			"repo/a.go\x007:3:x needle needle\n" +
+			"repo/a.go\x007:10:x needle needle\n" +
+			"repo/a.go\x008:4964:le needle nee\n",
+	}, {
+		name: "list",
+		g:    grepWriter{list: true, null: true},
+		want: "a.go\x00b.go\x00",
+	}, {
+		name:  "color",
+		g:     grepWriter{color: true},
+		files: []zoekt.FileMatch{{FileName: "a.go", LineMatches: files[0].LineMatches[1:2]}},
+		want: "\x1b[35m\x1b[Ka.go\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K\x1b[32m\x1b[K3\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K\x1b[32m\x1b[K1\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K" +
+			"\x1b[01;31m\x1b[Kneedle\x1b[m\x1b[K\n",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			g := tc.g
+			g.w = bufio.NewWriter(&buf)
+			files := files
+			if tc.files != nil {
+				files = tc.files
+			}
+			if err := g.write(files); err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.want, buf.String()); d != "" {
+				t.Errorf("(-want +got):\n%s", d)
+			}
+		})
+	}
+}
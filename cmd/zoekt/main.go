@@ -16,8 +16,10 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -153,7 +155,63 @@ func toSymbolQuery(q query.Q) query.Q {
 	})
 }
 
+func parseQuery(pat string, sym bool, rewriter *query.Rewriter) (query.Q, error) {
+	q, err := rewriter.Parse(pat)
+	if err != nil {
+		return nil, err
+	}
+	q = query.Map(q, query.ExpandFileContent)
+	if sym {
+		q = toSymbolQuery(q)
+	}
+	return query.Simplify(q), nil
+}
+
+// explainQuery prints how each shard evaluates pat. It searches the shard fn
+// if set, otherwise all shards in indexDir.
+func explainQuery(pat, fn, indexDir string, sym bool, rewriter *query.Rewriter) error {
+	q, err := parseQuery(pat, sym, rewriter)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("query: %s\n", q)
+
+	fns := []string{fn}
+	if fn == "" {
+		fns, err = filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, fn := range fns {
+		s, err := loadShard(fn, false)
+		if err != nil {
+			return err
+		}
+		err = index.Explain(context.Background(), os.Stdout, s, q)
+		s.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	return nil
+}
+
 func main() {
+	// To search for the words "index doctor", quote them as one argument.
+	if len(os.Args) > 2 && os.Args[1] == "index" && os.Args[2] == "doctor" {
+		os.Exit(runDoctor(os.Args[3:], os.Stdout))
+	}
+	// To search for the word "pull", use "zoekt -- pull".
+	if len(os.Args) > 1 && os.Args[1] == "pull" {
+		os.Exit(runPull(os.Args[2:], os.Stdout))
+	}
+	// To search for the word "archive", use "zoekt -- archive".
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		os.Exit(runArchive(os.Args[2:], os.Stdout))
+	}
+
 	shard := flag.String("shard", "", "search in a specific shard")
 	index := flag.String("index_dir",
 		filepath.Join(os.Getenv("HOME"), ".zoekt"), "search for index files in `directory`")
@@ -165,11 +223,22 @@ func main() {
 	withRepo := flag.Bool("r", false, "print the repo before the file name")
 	list := flag.Bool("l", false, "print matching filenames only")
 	sym := flag.Bool("sym", false, "do experimental symbol search")
+	explain := flag.Bool("explain", false, "print the query plan for each shard instead of searching")
+	detailedStats := flag.Bool("detailed_stats", false, "print statistics for each atom of the query as JSON to stderr")
+	rewriteRules := flag.String("query_rewrite_rules", "", "rewrite the query with the rules in this YAML or JSON `file`")
+	format := flag.String("format", "", "print matches in this `format`. With \"grep\", every match is printed as path:line:column:text, like grep -Hn --column.")
+	null := flag.Bool("null", false, "with -format grep, print a NUL byte instead of the colon after the path, or after each file name with -l")
+	color := flag.String("color", "auto", "with -format grep, color the output like grep: always, never, or auto if the output is a terminal")
+	anySep := flag.Bool("any_path_separator", false, "match / and \\ in file: patterns with either path separator")
+	fieldClasses := flag.Bool("field_classes", false, "rank symbol, file name and content matches by field class; with -debug, the class of each file is printed")
 
 	flag.Usage = func() {
 		name := os.Args[0]
 		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] QUERY\n"+
-			"for example\n\n  %s byte file:java -file:test\n\n", name, name)
+			"for example\n\n  %s byte file:java -file:test\n\n"+
+			"To check an index directory for problems, run\n\n  %s index doctor [-fix] [-index_dir directory]\n\n"+
+			"To download the shards of repositories from a zoekt-webserver for offline searches, run\n\n  %s pull -server address REPO_REGEXP...\n\n"+
+			"To move the shards of repositories to an index directory without network access, run\n\n  %s archive export|import ...\n\n", name, name, name, name, name)
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n")
 	}
@@ -182,12 +251,39 @@ func main() {
 	}
 	pat := strings.Join(flag.Args(), " ")
 
+	if *format != "" && *format != "grep" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q.\n", *format)
+		os.Exit(2)
+	}
+	colored, err := useColor(*color, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	if !*verbose {
 		log.SetOutput(io.Discard)
 	}
 
+	var rewriter *query.Rewriter
+	if *rewriteRules != "" {
+		var err error
+		rewriter, err = query.LoadRewriter(*rewriteRules)
+		if err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatal(err)
+		}
+	}
+
+	if *explain {
+		if err := explainQuery(pat, *shard, *index, *sym, rewriter); err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var searcher zoekt.Searcher
-	var err error
 	if *shard != "" {
 		searcher, err = loadShard(*shard, *verbose)
 	} else {
@@ -198,21 +294,20 @@ func main() {
 		log.Fatal(err)
 	}
 
-	q, err := query.Parse(pat)
+	q, err := parseQuery(pat, *sym, rewriter)
 	if err != nil {
 		log.Fatal(err)
 	}
-	q = query.Map(q, query.ExpandFileContent)
-	if *sym {
-		q = toSymbolQuery(q)
-	}
-	q = query.Simplify(q)
 	if *verbose {
 		log.Println("query:", q)
 	}
 
 	sOpts := zoekt.SearchOptions{
-		DebugScore: *debug,
+		DebugScore:            *debug,
+		DetailedStats:         *detailedStats,
+		FilesOnly:             *list,
+		PathSeparatorAgnostic: *anySep,
+		ScoreFieldClasses:     *fieldClasses,
 	}
 	sres, err := searcher.Search(context.Background(), q, &sOpts)
 	if err != nil {
@@ -228,8 +323,28 @@ func main() {
 		sres, _ = searcher.Search(context.Background(), q, &sOpts)
 	}
 
-	displayMatches(sres.Files, pat, *withRepo, *list)
+	if *format == "grep" {
+		g := &grepWriter{
+			w:        bufio.NewWriter(os.Stdout),
+			withRepo: *withRepo,
+			list:     *list,
+			null:     *null,
+			color:    colored,
+		}
+		if err := g.write(sres.Files); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		displayMatches(sres.Files, pat, *withRepo, *list)
+	}
 	if *verbose {
 		log.Printf("stats: %#v", sres.Stats)
 	}
+	if *detailedStats {
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sres.Stats.Atoms); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/grafana/regexp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// defaultCacheDir returns the directory zoekt pull downloads shards to by
+// default.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.Getenv("HOME"), ".zoekt-pull")
+	}
+	return filepath.Join(dir, "zoekt")
+}
+
+func runPull(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	fs.SetOutput(out)
+	server := fs.String("server", "", "the gRPC `address` of the zoekt-webserver, which must run with -shard_downloads")
+	cacheDir := fs.String("cache_dir", defaultCacheDir(), "download the shards to `directory`")
+	useTLS := fs.Bool("tls", false, "connect with TLS, for webservers serving with -ssl_cert")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage:\n\n  %s pull [option] REPO_REGEXP...\n\n"+
+			"Downloads the shards of the repositories matching any of the regular expressions\n"+
+			"from a zoekt-webserver, so they can be searched offline with -index_dir. Shards\n"+
+			"which are up to date are kept, and shards of matching repositories which the\n"+
+			"server doesn't have anymore are removed. The value of $ZOEKT_AUTHORIZATION, eg.\n"+
+			"\"Bearer TOKEN\", is sent as the Authorization header.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *server == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	repos, err := compileRepoPatterns(fs.Args())
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	if err := os.MkdirAll(*cacheDir, 0o755); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(*server, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if a := os.Getenv("ZOEKT_AUTHORIZATION"); a != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", a)
+	}
+
+	st, err := pull(ctx, proto.NewWebserverServiceClient(conn), *cacheDir, repos)
+	if err != nil {
+		fmt.Fprintf(out, "pull from %s: %v\n", *server, err)
+		return 1
+	}
+	fmt.Fprintf(out, "%d file(s) downloaded, %d up to date, %d removed.\n", st.downloaded, st.unchanged, st.removed)
+	fmt.Fprintf(out, "Search them with\n\n  %s -index_dir %s QUERY\n", os.Args[0], *cacheDir)
+	return 0
+}
+
+// compileRepoPatterns returns a regular expression matching the
+// repositories which match any of patterns.
+func compileRepoPatterns(patterns []string) (*regexp.Regexp, error) {
+	alts := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, err
+		}
+		alts = append(alts, "(?:"+p+")")
+	}
+	return regexp.MustCompile(strings.Join(alts, "|")), nil
+}
+
+type pullStats struct {
+	downloaded, unchanged, removed int
+}
+
+// pull downloads the shard files of the repositories matching repos into
+// dir, skipping the ones which have the size and modification time of the
+// server's. Afterwards, it removes the shards of matching repositories which
+// the server didn't send.
+func pull(ctx context.Context, client proto.WebserverServiceClient, dir string, repos *regexp.Regexp) (pullStats, error) {
+	var st pullStats
+	have, err := localShardFiles(dir)
+	if err != nil {
+		return st, err
+	}
+	req := &proto.FetchShardsRequest{Repos: repos.String()}
+	for _, f := range have {
+		req.Have = append(req.Have, f.ToProto())
+	}
+
+	stream, err := client.FetchShards(ctx, req)
+	if err != nil {
+		return st, err
+	}
+
+	sent := map[string]bool{}
+	var cur *pullFile
+	defer func() {
+		if cur != nil {
+			cur.abort()
+		}
+	}()
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return st, err
+		}
+
+		if resp.GetFile() == nil {
+			if cur == nil {
+				return st, errors.New("received data before a file")
+			}
+			if err := cur.write(resp.GetData()); err != nil {
+				return st, err
+			}
+			continue
+		}
+
+		if cur != nil {
+			if err := cur.finish(); err != nil {
+				return st, err
+			}
+			cur = nil
+			st.downloaded++
+		}
+		f := zoekt.ShardFileFromProto(resp.GetFile())
+		if !isShardFileName(f.Name) {
+			return st, fmt.Errorf("received invalid file name %q", f.Name)
+		}
+		sent[f.Name] = true
+		if resp.GetUnchanged() {
+			st.unchanged++
+			continue
+		}
+		if cur, err = createPullFile(dir, f); err != nil {
+			return st, err
+		}
+	}
+	if cur != nil {
+		if err := cur.finish(); err != nil {
+			return st, err
+		}
+		cur = nil
+		st.downloaded++
+	}
+
+	st.removed, err = removeStaleShards(dir, have, sent, repos)
+	return st, err
+}
+
+// localShardFiles returns the shards, ".meta" files and manifests in dir.
+func localShardFiles(dir string) ([]zoekt.ShardFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []zoekt.ShardFile
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !isShardFileName(name) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, zoekt.ShardFile{Name: name, Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	return files, nil
+}
+
+// isShardFileName returns true if name is the name of a shard, ".meta"
+// file or manifest, without directories.
+func isShardFileName(name string) bool {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta") || strings.HasSuffix(name, ".manifest")
+}
+
+// removeStaleShards removes the shards in have which the server didn't send
+// although they hold a repository matching repos, with their ".meta" files,
+// and the manifests which only list removed shards. Shards of other
+// repositories are left alone since they were pulled with other patterns,
+// and in compound shards also holding them the matching repositories are
+// tombstoned instead.
+func removeStaleShards(dir string, have []zoekt.ShardFile, sent map[string]bool, repos *regexp.Regexp) (int, error) {
+	removed := map[string]bool{}
+	for _, f := range have {
+		if sent[f.Name] || !strings.HasSuffix(f.Name, ".zoekt") {
+			continue
+		}
+		fn := filepath.Join(dir, f.Name)
+		rs, _, err := index.ReadMetadataPathAlive(fn)
+		if err != nil || !slices.ContainsFunc(rs, func(r *zoekt.Repository) bool { return repos.MatchString(r.Name) }) {
+			continue
+		}
+		if slices.ContainsFunc(rs, func(r *zoekt.Repository) bool { return !repos.MatchString(r.Name) }) {
+			for _, r := range rs {
+				if !repos.MatchString(r.Name) {
+					continue
+				}
+				if err := index.SetTombstone(fn, r.ID); err != nil {
+					return len(removed), err
+				}
+			}
+			continue
+		}
+		if err := removeFiles(fn, fn+".meta")(); err != nil {
+			return len(removed), err
+		}
+		removed[f.Name] = true
+	}
+
+	n := len(removed)
+	for _, f := range have {
+		if sent[f.Name] || !strings.HasSuffix(f.Name, ".manifest") {
+			continue
+		}
+		fn := filepath.Join(dir, f.Name)
+		m, err := index.ReadShardManifest(fn)
+		if err != nil || !slices.ContainsFunc(m.Shards, func(s string) bool { return removed[s] }) {
+			continue
+		}
+		if slices.ContainsFunc(m.Shards, func(s string) bool { return sent[s] }) {
+			continue
+		}
+		if err := removeFiles(fn)(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// pullFile is a shard file being downloaded. It is written to a temporary
+// file, which replaces the file once it is complete, so searches of dir never
+// see partial shards.
+type pullFile struct {
+	f       *os.File
+	path    string
+	want    zoekt.ShardFile
+	written int64
+}
+
+func createPullFile(dir string, want zoekt.ShardFile) (*pullFile, error) {
+	path := filepath.Join(dir, want.Name)
+	f, err := os.OpenFile(path+".pull.tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &pullFile{f: f, path: path, want: want}, nil
+}
+
+func (p *pullFile) write(data []byte) error {
+	if p.written+int64(len(data)) > p.want.Size {
+		return fmt.Errorf("%s: received more than %d bytes", p.want.Name, p.want.Size)
+	}
+	n, err := p.f.Write(data)
+	p.written += int64(n)
+	return err
+}
+
+func (p *pullFile) finish() error {
+	if p.written != p.want.Size {
+		p.abort()
+		return fmt.Errorf("%s: received %d of %d bytes", p.want.Name, p.written, p.want.Size)
+	}
+	if err := p.f.Close(); err != nil {
+		os.Remove(p.f.Name())
+		return err
+	}
+	// The modification time tells the server that the file is up to date on
+	// the next pull.
+	if err := os.Chtimes(p.f.Name(), p.want.ModTime, p.want.ModTime); err != nil {
+		os.Remove(p.f.Name())
+		return err
+	}
+	return os.Rename(p.f.Name(), p.path)
+}
+
+func (p *pullFile) abort() {
+	p.f.Close()
+	os.Remove(p.f.Name())
+}
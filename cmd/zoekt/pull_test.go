@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/regexp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/cmd/zoekt-webserver/grpc/server"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestPull(t *testing.T) {
+	serverDir := t.TempDir()
+	writeShard(t, filepath.Join(serverDir, "repo-a_v16.00000.zoekt"), &zoekt.Repository{ID: 1, Name: "repo-a"})
+	writeShard(t, filepath.Join(serverDir, "repo-b_v16.00000.zoekt"), &zoekt.Repository{ID: 2, Name: "repo-b"})
+	writeShard(t, filepath.Join(serverDir, "other_v16.00000.zoekt"), &zoekt.Repository{ID: 3, Name: "other"})
+
+	searcher, err := shards.NewDirectorySearcher(serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	gs := grpc.NewServer()
+	defer gs.Stop()
+	proto.RegisterWebserverServiceServer(gs, server.NewServer(searcher))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := proto.NewWebserverServiceClient(cc)
+
+	// The cache has a shard of a matching repository which is gone from the
+	// server, and one pulled with another pattern.
+	cacheDir := t.TempDir()
+	writeShard(t, filepath.Join(cacheDir, "repo-gone_v16.00000.zoekt"), &zoekt.Repository{ID: 4, Name: "repo-gone"})
+	writeShard(t, filepath.Join(cacheDir, "mine_v16.00000.zoekt"), &zoekt.Repository{ID: 5, Name: "mine"})
+
+	ctx := context.Background()
+	repos := regexp.MustCompile("^repo-")
+	st, err := pull(ctx, client, cacheDir, repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (pullStats{downloaded: 2, removed: 1}); st != want {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+
+	for _, name := range []string{"repo-a_v16.00000.zoekt", "repo-b_v16.00000.zoekt"} {
+		want, err := os.ReadFile(filepath.Join(serverDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(cacheDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s differs from the server's", name)
+		}
+	}
+	for name, want := range map[string]bool{
+		"other_v16.00000.zoekt":     false,
+		"repo-gone_v16.00000.zoekt": false,
+		"mine_v16.00000.zoekt":      true,
+	} {
+		if _, err := os.Stat(filepath.Join(cacheDir, name)); (err == nil) != want {
+			t.Errorf("%s: got %v, want present=%v", name, err, want)
+		}
+	}
+
+	// The second pull only confirms the shards.
+	st, err = pull(ctx, client, cacheDir, repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (pullStats{unchanged: 2}); st != want {
+		t.Errorf("second pull: got %+v, want %+v", st, want)
+	}
+
+	// The local shards are searchable.
+	local, err := shards.NewDirectorySearcher(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	rl, err := local.List(ctx, &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 3 {
+		t.Errorf("got %d repositories in the cache, want 3", len(rl.Repos))
+	}
+}
@@ -0,0 +1,208 @@
+// Package capabilities negotiates the API version and the optional features of
+// the webserver gRPC API.
+//
+// The server advertises its API version and the features it supports in the
+// response headers of every RPC, so clients can detect support for newer
+// search options like context lines or chunk matches without parsing build
+// versions. Clients announce the API version they speak in the request
+// metadata, which the server counts to see when support for old versions can
+// be removed.
+//
+// Servers which don't send the headers speak version 1 and support none of
+// the capabilities listed here.
+//
+// The API version is not the version of the protobuf package. Versions 1 and
+// 2 are both served by zoekt.webserver.v1.WebserverService: the options and
+// RPCs added since version 1 are new fields and methods, which old clients
+// and servers ignore or reject as unimplemented, so they don't need a new
+// package. A zoekt.webserver.v2 package is only warranted by a change old
+// clients can't ignore, like removing or retyping a field, and would be
+// served next to the v1 package until the version counts show that no
+// clients use version 1.
+package capabilities
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt/grpc/grpcutil"
+)
+
+const (
+	// VersionKey is the metadata key of the API version of a client or server.
+	VersionKey = "zoekt-api-version"
+
+	// CapabilitiesKey is the metadata key of the capabilities of a server,
+	// separated by commas.
+	CapabilitiesKey = "zoekt-capabilities"
+)
+
+// APIVersion is the version of the API implemented by this package. Version
+// 2 is the first version which negotiates capabilities. It is served by the
+// v1 protobuf package, see the package documentation.
+const APIVersion = 2
+
+// The capabilities of the webserver. Each names an option or RPC which older
+// servers ignore or don't implement.
+const (
+	ChunkMatches             = "chunk_matches"              // SearchOptions.chunk_matches
+	ContextLines             = "context_lines"              // SearchOptions.num_context_lines
+	BM25Scoring              = "bm25_scoring"               // SearchOptions.use_bm25_scoring
+	DetailedStats            = "detailed_stats"             // SearchOptions.detailed_stats
+	ResultSet                = "result_set"                 // SearchOptions.return_result_set and within
+	FilesOnly                = "files_only"                 // SearchOptions.files_only
+	RankingSignals           = "ranking_signals"            // SearchOptions.ranking_signals_weight
+	FileNamesFirst           = "file_names_first"           // SearchOptions.file_names_first
+	TabWidth                 = "tab_width"                  // SearchOptions.tab_width
+	ProgressInterval         = "progress_interval"          // SearchOptions.progress_interval
+	PathSeparatorAgnostic    = "path_separator_agnostic"    // SearchOptions.path_separator_agnostic
+	Anytime                  = "anytime"                    // SearchOptions.anytime
+	MaxDisplayBytes          = "max_display_bytes"          // SearchOptions.max_display_bytes
+	FieldClasses             = "field_classes"              // SearchOptions.score_field_classes
+	Provenance               = "provenance"                 // SearchOptions.provenance
+	StreamList               = "stream_list"                // the StreamList RPC
+	Definitions              = "definitions"                // the Definitions RPC
+	Document                 = "document"                   // the Document RPC
+	Count                    = "count"                      // the Count RPC
+	Contents                 = "contents"                   // the Contents RPC
+	UpdateRepositoryMetadata = "update_repository_metadata" // the UpdateRepositoryMetadata RPC
+	Experiments              = "experiments"                // the zoekt-experiments metadata
+	FetchShards              = "fetch_shards"               // the FetchShards RPC
+)
+
+// Server is the list of capabilities of this server.
+var Server = []string{
+	ChunkMatches,
+	ContextLines,
+	BM25Scoring,
+	DetailedStats,
+	ResultSet,
+	FilesOnly,
+	RankingSignals,
+	FileNamesFirst,
+	TabWidth,
+	ProgressInterval,
+	PathSeparatorAgnostic,
+	Anytime,
+	MaxDisplayBytes,
+	FieldClasses,
+	Provenance,
+	StreamList,
+	Definitions,
+	Document,
+	Count,
+	Contents,
+	UpdateRepositoryMetadata,
+	Experiments,
+	FetchShards,
+}
+
+// Set is the API version and capabilities advertised by a server.
+type Set struct {
+	Version      int
+	Capabilities []string
+}
+
+// Has returns true if the server supports the capability.
+func (s Set) Has(capability string) bool {
+	return slices.Contains(s.Capabilities, capability)
+}
+
+// FromHeader returns the capabilities advertised in the response header md of
+// a server. Use grpc.Header to receive the header of unary RPCs, and the
+// Header method of client streams.
+func FromHeader(md metadata.MD) Set {
+	s := Set{Version: parseVersion(md)}
+	for _, v := range md.Get(CapabilitiesKey) {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				s.Capabilities = append(s.Capabilities, c)
+			}
+		}
+	}
+	return s
+}
+
+// parseVersion returns the API version in md, which is 1 if it is missing or
+// invalid.
+func parseVersion(md metadata.MD) int {
+	vs := md.Get(VersionKey)
+	if len(vs) == 0 {
+		return 1
+	}
+	v, err := strconv.Atoi(vs[0])
+	if err != nil || v < 1 {
+		return 1
+	}
+	return v
+}
+
+func header(capabilities []string) metadata.MD {
+	return metadata.Pairs(
+		VersionKey, strconv.Itoa(APIVersion),
+		CapabilitiesKey, strings.Join(capabilities, ","),
+	)
+}
+
+var metricClientAPIVersion = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_grpc_client_api_version_total",
+	Help: "Number of RPCs by the API version announced by the client.",
+}, []string{
+	"grpc_method", // e.g. "Search"
+	"version",     // e.g. "1"
+})
+
+func observeClientVersion(ctx context.Context, fullMethod string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	_, method := grpcutil.SplitMethodName(fullMethod)
+	metricClientAPIVersion.WithLabelValues(method, strconv.Itoa(parseVersion(md))).Inc()
+}
+
+// UnaryServerInterceptor returns an interceptor which advertises capabilities
+// in the response header of unary RPCs.
+func UnaryServerInterceptor(capabilities []string) grpc.UnaryServerInterceptor {
+	md := header(capabilities)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		observeClientVersion(ctx, info.FullMethod)
+		if err := grpc.SetHeader(ctx, md); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor which advertises
+// capabilities in the response header of streaming RPCs.
+func StreamServerInterceptor(capabilities []string) grpc.StreamServerInterceptor {
+	md := header(capabilities)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		observeClientVersion(ss.Context(), info.FullMethod)
+		if err := ss.SetHeader(md); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientInterceptor announces the API version of the client in the
+// metadata of unary RPCs.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(withVersion(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor announces the API version of the client in the
+// metadata of streaming RPCs.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(withVersion(ctx), desc, cc, method, opts...)
+}
+
+func withVersion(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, VersionKey, strconv.Itoa(APIVersion))
+}
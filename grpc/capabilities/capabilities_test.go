@@ -0,0 +1,113 @@
+package capabilities
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	webserverv1 "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+func TestNegotiation(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := []string{ChunkMatches, ContextLines}
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(caps)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(caps)),
+	)
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	cc, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+
+	want := Set{Version: APIVersion, Capabilities: caps}
+
+	before := testutil.ToFloat64(metricClientAPIVersion.WithLabelValues("Check", "2"))
+	var md metadata.MD
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&md)); err != nil {
+		t.Fatal(err)
+	}
+	got := FromHeader(md)
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unary header mismatch (-want, +got):\n%s", d)
+	}
+	if !got.Has(ContextLines) || got.Has(FilesOnly) {
+		t.Errorf("Has is wrong for %v", got)
+	}
+	if after := testutil.ToFloat64(metricClientAPIVersion.WithLabelValues("Check", "2")); after != before+1 {
+		t.Errorf("client version count went from %v to %v, want an increment", before, after)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err = stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, FromHeader(md)); d != "" {
+		t.Errorf("stream header mismatch (-want, +got):\n%s", d)
+	}
+}
+
+func TestFromHeaderOldServer(t *testing.T) {
+	got := FromHeader(metadata.MD{})
+	if d := cmp.Diff(Set{Version: 1}, got); d != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", d)
+	}
+	if got.Has(ChunkMatches) {
+		t.Error("old servers have no capabilities")
+	}
+}
+
+func TestServerRPCs(t *testing.T) {
+	// The RPCs of version 1, which every server implements.
+	v1 := map[string]bool{"Search": true, "StreamSearch": true, "List": true}
+
+	upper := regexp.MustCompile(`[A-Z]`)
+	methods := webserverv1.File_zoekt_webserver_v1_webserver_proto.Services().ByName("WebserverService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		name := string(methods.Get(i).Name())
+		if v1[name] {
+			continue
+		}
+		capability := strings.TrimPrefix(strings.ToLower(upper.ReplaceAllString(name, "_$0")), "_")
+		if !(Set{Capabilities: Server}).Has(capability) {
+			t.Errorf("RPC %s has no %s capability", name, capability)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, c := range Server {
+		if seen[c] {
+			t.Errorf("capability %s is listed twice", c)
+		}
+		seen[c] = true
+	}
+}
@@ -0,0 +1,114 @@
+package propagator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Well-known metadata keys which can be carried by Baggage. Metadata keys are
+// case-insensitive.
+const (
+	// HeaderActorUID identifies the actor on whose behalf a request is made.
+	HeaderActorUID = "X-Sourcegraph-Actor-UID"
+
+	// HeaderFeatureFlags carries a comma separated list of feature flags
+	// enabled for a request.
+	HeaderFeatureFlags = "X-Sourcegraph-Feature-Flags"
+
+	// HeaderTraceParent and HeaderTraceState carry the W3C trace context.
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// BaggageKey is a metadata key propagated by Baggage.
+type BaggageKey struct {
+	// Header is the metadata key.
+	Header string
+
+	// Validate, if set, is called on the server side for the value received
+	// for Header. If it returns an error the RPC fails with
+	// codes.InvalidArgument.
+	Validate func(value string) error
+}
+
+// DefaultBaggageKeys are the well-known metadata keys without validation.
+var DefaultBaggageKeys = []BaggageKey{
+	{Header: HeaderActorUID},
+	{Header: HeaderFeatureFlags},
+	{Header: HeaderTraceParent},
+	{Header: HeaderTraceState},
+}
+
+// Baggage is a Propagator which carries the values of an allowlisted set of
+// metadata keys from the context of a client to the context of the server.
+// Values are attached to a context with WithBaggage and read with
+// BaggageFromContext. Keys which are not allowlisted are ignored in both
+// directions.
+type Baggage struct {
+	keys map[string]BaggageKey
+}
+
+var _ Propagator = &Baggage{}
+
+// NewBaggage returns a Baggage propagator for keys.
+func NewBaggage(keys ...BaggageKey) *Baggage {
+	b := &Baggage{keys: make(map[string]BaggageKey, len(keys))}
+	for _, k := range keys {
+		b.keys[strings.ToLower(k.Header)] = k
+	}
+	return b
+}
+
+func (b *Baggage) FromContext(ctx context.Context) metadata.MD {
+	md := make(metadata.MD)
+	values, _ := ctx.Value(baggageContextKey{}).(map[string]string)
+	for key := range b.keys {
+		if v, ok := values[key]; ok {
+			md.Set(key, v)
+		}
+	}
+	return md
+}
+
+func (b *Baggage) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	for key, k := range b.keys {
+		vals := md.Get(key)
+		if len(vals) == 0 {
+			continue
+		}
+		if k.Validate != nil {
+			if err := k.Validate(vals[0]); err != nil {
+				return ctx, status.New(codes.InvalidArgument, fmt.Errorf("bad %s value in metadata: %w", k.Header, err).Error()).Err()
+			}
+		}
+		ctx = WithBaggage(ctx, key, vals[0])
+	}
+	return ctx, nil
+}
+
+type baggageContextKey struct{}
+
+// WithBaggage returns a copy of ctx in which the metadata key is set to value.
+// The value is only propagated by a Baggage propagator which allowlists key.
+func WithBaggage(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(baggageContextKey{}).(map[string]string)
+	values := maps.Clone(old)
+	if values == nil {
+		values = make(map[string]string, 1)
+	}
+	values[strings.ToLower(key)] = value
+	return context.WithValue(ctx, baggageContextKey{}, values)
+}
+
+// BaggageFromContext returns the value of the metadata key in ctx.
+func BaggageFromContext(ctx context.Context, key string) (string, bool) {
+	values, _ := ctx.Value(baggageContextKey{}).(map[string]string)
+	v, ok := values[strings.ToLower(key)]
+	return v, ok
+}
@@ -22,6 +22,80 @@ type Propagator interface {
 	InjectContext(context.Context, metadata.MD) (context.Context, error)
 }
 
+// Chain returns a Propagator which propagates the information of all props.
+// The metadata of props is merged on the client side and injected by each
+// propagator in order on the server side.
+func Chain(props ...Propagator) Propagator {
+	return chain(props)
+}
+
+type chain []Propagator
+
+func (c chain) FromContext(ctx context.Context) metadata.MD {
+	mds := make([]metadata.MD, 0, len(c))
+	for _, p := range c {
+		mds = append(mds, p.FromContext(ctx))
+	}
+	return metadata.Join(mds...)
+}
+
+func (c chain) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	for _, p := range c {
+		var err error
+		ctx, err = p.InjectContext(ctx, md)
+		if err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+// StreamClientPropagator returns an interceptor that will use the given propagator
+// to forward information from the context to the server. The server should be
+// configured with an interceptor that uses the same propagator.
+func StreamClientPropagator(prop Propagator) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx, prop), desc, cc, method, opts...)
+	}
+}
+
+// UnaryClientPropagator returns an interceptor that will use the given propagator
+// to forward information from the context to the server. The server should be
+// configured with an interceptor that uses the same propagator.
+func UnaryClientPropagator(prop Propagator) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(outgoingContext(ctx, prop), method, req, reply, cc, opts...)
+	}
+}
+
+// outgoingContext adds the metadata of prop to the outgoing metadata of ctx.
+// Keys which are already set in the outgoing metadata, for example by another
+// interceptor, are left alone.
+func outgoingContext(ctx context.Context, prop Propagator) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, vs := range prop.FromContext(ctx) {
+		if len(md.Get(k)) == 0 {
+			md.Set(k, vs...)
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
 // StreamServerPropagator returns an interceptor that will use the given propagator
 // to translate some metadata back into the context for the RPC handler. The client
 // should be configured with an interceptor that uses the same propagator.
@@ -0,0 +1,118 @@
+package propagator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// roundTrip sends ctx through the client and server interceptors of prop and
+// returns the context seen by the server handler.
+func roundTrip(t *testing.T, prop Propagator, ctx context.Context) (context.Context, error) {
+	t.Helper()
+
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := UnaryClientPropagator(prop)(ctx, "/test", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	var got context.Context
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		got = ctx
+		return nil, nil
+	}
+	incoming := metadata.NewIncomingContext(context.Background(), outgoing)
+	_, err := UnaryServerPropagator(prop)(incoming, nil, &grpc.UnaryServerInfo{}, handler)
+	return got, err
+}
+
+func TestBaggage(t *testing.T) {
+	errBadFlag := errors.New("bad flag")
+	prop := NewBaggage(
+		BaggageKey{Header: HeaderActorUID},
+		BaggageKey{Header: HeaderFeatureFlags, Validate: func(v string) error {
+			if v == "bad" {
+				return errBadFlag
+			}
+			return nil
+		}},
+	)
+
+	ctx := WithBaggage(context.Background(), HeaderActorUID, "42")
+	ctx = WithBaggage(ctx, HeaderFeatureFlags, "a,b")
+	ctx = WithBaggage(ctx, "X-Not-Allowed", "secret")
+
+	got, err := roundTrip(t, prop, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{HeaderActorUID: "42", HeaderFeatureFlags: "a,b"} {
+		if v, _ := BaggageFromContext(got, key); v != want {
+			t.Errorf("%s: got %q, want %q", key, v, want)
+		}
+	}
+	if v, ok := BaggageFromContext(got, "X-Not-Allowed"); ok {
+		t.Errorf("key without allowlist entry was propagated: %q", v)
+	}
+
+	_, err = roundTrip(t, prop, WithBaggage(context.Background(), HeaderFeatureFlags, "bad"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, want InvalidArgument", err)
+	}
+}
+
+func TestClientPropagatorKeepsOutgoingMetadata(t *testing.T) {
+	prop := NewBaggage(DefaultBaggageKeys...)
+
+	ctx := WithBaggage(context.Background(), HeaderTraceParent, "from-baggage")
+	ctx = metadata.AppendToOutgoingContext(ctx, HeaderTraceParent, "from-tracer", "other", "value")
+
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := UnaryClientPropagator(prop)(ctx, "/test", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	want := metadata.Pairs(HeaderTraceParent, "from-tracer", "other", "value")
+	if d := cmp.Diff(want, outgoing); d != "" {
+		t.Fatalf("unexpected metadata (-want, +got):\n%s", d)
+	}
+}
+
+type staticPropagator struct{ key, value string }
+
+func (p staticPropagator) FromContext(context.Context) metadata.MD {
+	return metadata.Pairs(p.key, p.value)
+}
+
+func (p staticPropagator) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	if vals := md.Get(p.key); len(vals) == 0 || vals[0] != p.value {
+		return ctx, status.Error(codes.InvalidArgument, "missing "+p.key)
+	}
+	return ctx, nil
+}
+
+func TestChain(t *testing.T) {
+	prop := Chain(staticPropagator{"a", "1"}, staticPropagator{"b", "2"}, NewBaggage(BaggageKey{Header: "c"}))
+
+	got, err := roundTrip(t, prop, WithBaggage(context.Background(), "c", "3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := BaggageFromContext(got, "c"); v != "3" {
+		t.Fatalf("got %q, want %q", v, "3")
+	}
+}
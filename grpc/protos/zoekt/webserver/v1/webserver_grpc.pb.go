@@ -19,9 +19,16 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	WebserverService_Search_FullMethodName       = "/zoekt.webserver.v1.WebserverService/Search"
-	WebserverService_StreamSearch_FullMethodName = "/zoekt.webserver.v1.WebserverService/StreamSearch"
-	WebserverService_List_FullMethodName         = "/zoekt.webserver.v1.WebserverService/List"
+	WebserverService_Search_FullMethodName                   = "/zoekt.webserver.v1.WebserverService/Search"
+	WebserverService_StreamSearch_FullMethodName             = "/zoekt.webserver.v1.WebserverService/StreamSearch"
+	WebserverService_List_FullMethodName                     = "/zoekt.webserver.v1.WebserverService/List"
+	WebserverService_Definitions_FullMethodName              = "/zoekt.webserver.v1.WebserverService/Definitions"
+	WebserverService_Document_FullMethodName                 = "/zoekt.webserver.v1.WebserverService/Document"
+	WebserverService_StreamList_FullMethodName               = "/zoekt.webserver.v1.WebserverService/StreamList"
+	WebserverService_UpdateRepositoryMetadata_FullMethodName = "/zoekt.webserver.v1.WebserverService/UpdateRepositoryMetadata"
+	WebserverService_Count_FullMethodName                    = "/zoekt.webserver.v1.WebserverService/Count"
+	WebserverService_Contents_FullMethodName                 = "/zoekt.webserver.v1.WebserverService/Contents"
+	WebserverService_FetchShards_FullMethodName              = "/zoekt.webserver.v1.WebserverService/FetchShards"
 )
 
 // WebserverServiceClient is the client API for WebserverService service.
@@ -33,6 +40,29 @@ type WebserverServiceClient interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Definitions looks up the definitions of the symbols with the exact name
+	// `name`.
+	Definitions(ctx context.Context, in *DefinitionsRequest, opts ...grpc.CallOption) (*DefinitionsResponse, error)
+	// Document returns the content of a document with the ranges in it
+	// matching `query`.
+	Document(ctx context.Context, in *DocumentRequest, opts ...grpc.CallOption) (*DocumentResponse, error)
+	// StreamList is like List, but sends the repositories in chunks as they are
+	// found, so large lists don't have to fit into a single message.
+	StreamList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (WebserverService_StreamListClient, error)
+	// UpdateRepositoryMetadata changes the metadata of a repository which
+	// doesn't need a re-index, like its priority. Fields which are unset are
+	// left unchanged.
+	UpdateRepositoryMetadata(ctx context.Context, in *UpdateRepositoryMetadataRequest, opts ...grpc.CallOption) (*UpdateRepositoryMetadataResponse, error)
+	// Count returns the number of matches of a query, without their contents.
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error)
+	// Contents returns ranges of lines of a document, eg. to expand the
+	// context of a match without another search.
+	Contents(ctx context.Context, in *ContentsRequest, opts ...grpc.CallOption) (*ContentsResponse, error)
+	// FetchShards sends the files of the shards of the repositories matching
+	// `repos`, eg. to search them offline. Each file starts with a message
+	// with its `file`, followed by messages with chunks of its `data`, unless
+	// the client already has it.
+	FetchShards(ctx context.Context, in *FetchShardsRequest, opts ...grpc.CallOption) (WebserverService_FetchShardsClient, error)
 }
 
 type webserverServiceClient struct {
@@ -93,6 +123,115 @@ func (c *webserverServiceClient) List(ctx context.Context, in *ListRequest, opts
 	return out, nil
 }
 
+func (c *webserverServiceClient) Definitions(ctx context.Context, in *DefinitionsRequest, opts ...grpc.CallOption) (*DefinitionsResponse, error) {
+	out := new(DefinitionsResponse)
+	err := c.cc.Invoke(ctx, WebserverService_Definitions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *webserverServiceClient) Document(ctx context.Context, in *DocumentRequest, opts ...grpc.CallOption) (*DocumentResponse, error) {
+	out := new(DocumentResponse)
+	err := c.cc.Invoke(ctx, WebserverService_Document_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *webserverServiceClient) StreamList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (WebserverService_StreamListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WebserverService_ServiceDesc.Streams[1], WebserverService_StreamList_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &webserverServiceStreamListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WebserverService_StreamListClient interface {
+	Recv() (*StreamListResponse, error)
+	grpc.ClientStream
+}
+
+type webserverServiceStreamListClient struct {
+	grpc.ClientStream
+}
+
+func (x *webserverServiceStreamListClient) Recv() (*StreamListResponse, error) {
+	m := new(StreamListResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *webserverServiceClient) UpdateRepositoryMetadata(ctx context.Context, in *UpdateRepositoryMetadataRequest, opts ...grpc.CallOption) (*UpdateRepositoryMetadataResponse, error) {
+	out := new(UpdateRepositoryMetadataResponse)
+	err := c.cc.Invoke(ctx, WebserverService_UpdateRepositoryMetadata_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *webserverServiceClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, WebserverService_Count_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *webserverServiceClient) Contents(ctx context.Context, in *ContentsRequest, opts ...grpc.CallOption) (*ContentsResponse, error) {
+	out := new(ContentsResponse)
+	err := c.cc.Invoke(ctx, WebserverService_Contents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *webserverServiceClient) FetchShards(ctx context.Context, in *FetchShardsRequest, opts ...grpc.CallOption) (WebserverService_FetchShardsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WebserverService_ServiceDesc.Streams[2], WebserverService_FetchShards_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &webserverServiceFetchShardsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WebserverService_FetchShardsClient interface {
+	Recv() (*FetchShardsResponse, error)
+	grpc.ClientStream
+}
+
+type webserverServiceFetchShardsClient struct {
+	grpc.ClientStream
+}
+
+func (x *webserverServiceFetchShardsClient) Recv() (*FetchShardsResponse, error) {
+	m := new(FetchShardsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // WebserverServiceServer is the server API for WebserverService service.
 // All implementations must embed UnimplementedWebserverServiceServer
 // for forward compatibility
@@ -102,6 +241,29 @@ type WebserverServiceServer interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Definitions looks up the definitions of the symbols with the exact name
+	// `name`.
+	Definitions(context.Context, *DefinitionsRequest) (*DefinitionsResponse, error)
+	// Document returns the content of a document with the ranges in it
+	// matching `query`.
+	Document(context.Context, *DocumentRequest) (*DocumentResponse, error)
+	// StreamList is like List, but sends the repositories in chunks as they are
+	// found, so large lists don't have to fit into a single message.
+	StreamList(*ListRequest, WebserverService_StreamListServer) error
+	// UpdateRepositoryMetadata changes the metadata of a repository which
+	// doesn't need a re-index, like its priority. Fields which are unset are
+	// left unchanged.
+	UpdateRepositoryMetadata(context.Context, *UpdateRepositoryMetadataRequest) (*UpdateRepositoryMetadataResponse, error)
+	// Count returns the number of matches of a query, without their contents.
+	Count(context.Context, *CountRequest) (*CountResponse, error)
+	// Contents returns ranges of lines of a document, eg. to expand the
+	// context of a match without another search.
+	Contents(context.Context, *ContentsRequest) (*ContentsResponse, error)
+	// FetchShards sends the files of the shards of the repositories matching
+	// `repos`, eg. to search them offline. Each file starts with a message
+	// with its `file`, followed by messages with chunks of its `data`, unless
+	// the client already has it.
+	FetchShards(*FetchShardsRequest, WebserverService_FetchShardsServer) error
 	mustEmbedUnimplementedWebserverServiceServer()
 }
 
@@ -118,6 +280,27 @@ func (UnimplementedWebserverServiceServer) StreamSearch(*StreamSearchRequest, We
 func (UnimplementedWebserverServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
+func (UnimplementedWebserverServiceServer) Definitions(context.Context, *DefinitionsRequest) (*DefinitionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Definitions not implemented")
+}
+func (UnimplementedWebserverServiceServer) Document(context.Context, *DocumentRequest) (*DocumentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Document not implemented")
+}
+func (UnimplementedWebserverServiceServer) StreamList(*ListRequest, WebserverService_StreamListServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamList not implemented")
+}
+func (UnimplementedWebserverServiceServer) UpdateRepositoryMetadata(context.Context, *UpdateRepositoryMetadataRequest) (*UpdateRepositoryMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateRepositoryMetadata not implemented")
+}
+func (UnimplementedWebserverServiceServer) Count(context.Context, *CountRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedWebserverServiceServer) Contents(context.Context, *ContentsRequest) (*ContentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Contents not implemented")
+}
+func (UnimplementedWebserverServiceServer) FetchShards(*FetchShardsRequest, WebserverService_FetchShardsServer) error {
+	return status.Errorf(codes.Unimplemented, "method FetchShards not implemented")
+}
 func (UnimplementedWebserverServiceServer) mustEmbedUnimplementedWebserverServiceServer() {}
 
 // UnsafeWebserverServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -188,6 +371,138 @@ func _WebserverService_List_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WebserverService_Definitions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DefinitionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).Definitions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_Definitions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).Definitions(ctx, req.(*DefinitionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WebserverService_Document_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).Document(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_Document_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).Document(ctx, req.(*DocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WebserverService_StreamList_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WebserverServiceServer).StreamList(m, &webserverServiceStreamListServer{stream})
+}
+
+type WebserverService_StreamListServer interface {
+	Send(*StreamListResponse) error
+	grpc.ServerStream
+}
+
+type webserverServiceStreamListServer struct {
+	grpc.ServerStream
+}
+
+func (x *webserverServiceStreamListServer) Send(m *StreamListResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WebserverService_UpdateRepositoryMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRepositoryMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).UpdateRepositoryMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_UpdateRepositoryMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).UpdateRepositoryMetadata(ctx, req.(*UpdateRepositoryMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WebserverService_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_Count_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WebserverService_Contents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ContentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).Contents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_Contents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).Contents(ctx, req.(*ContentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WebserverService_FetchShards_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FetchShardsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WebserverServiceServer).FetchShards(m, &webserverServiceFetchShardsServer{stream})
+}
+
+type WebserverService_FetchShardsServer interface {
+	Send(*FetchShardsResponse) error
+	grpc.ServerStream
+}
+
+type webserverServiceFetchShardsServer struct {
+	grpc.ServerStream
+}
+
+func (x *webserverServiceFetchShardsServer) Send(m *FetchShardsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // WebserverService_ServiceDesc is the grpc.ServiceDesc for WebserverService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -203,6 +518,26 @@ var WebserverService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _WebserverService_List_Handler,
 		},
+		{
+			MethodName: "Definitions",
+			Handler:    _WebserverService_Definitions_Handler,
+		},
+		{
+			MethodName: "Document",
+			Handler:    _WebserverService_Document_Handler,
+		},
+		{
+			MethodName: "UpdateRepositoryMetadata",
+			Handler:    _WebserverService_UpdateRepositoryMetadata_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _WebserverService_Count_Handler,
+		},
+		{
+			MethodName: "Contents",
+			Handler:    _WebserverService_Contents_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -210,6 +545,16 @@ var WebserverService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _WebserverService_StreamSearch_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StreamList",
+			Handler:       _WebserverService_StreamList_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "FetchShards",
+			Handler:       _WebserverService_FetchShards_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "zoekt/webserver/v1/webserver.proto",
 }
@@ -16,18 +16,29 @@ package ignore
 import (
 	"bufio"
 	"io"
+	"slices"
 	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/gobwas/glob"
 )
 
 var (
 	lineComment = "#"
 	IgnoreFile  = ".sourcegraph/ignore"
+
+	// ZoektIgnoreFile is read from the root of a repository like IgnoreFile,
+	// but its patterns follow the syntax of .gitignore files.
+	ZoektIgnoreFile = ".zoektignore"
 )
 
 type Matcher struct {
 	ignoreList []glob.Glob
+
+	// gitignore are the patterns of ParseGitIgnoreFile, and patterns their
+	// lines.
+	gitignore []gitignore.Pattern
+	patterns  []string
 }
 
 // ParseIgnoreFile parses an ignore-file according to the following rules
@@ -64,15 +75,57 @@ func ParseIgnoreFile(r io.Reader) (matcher *Matcher, error error) {
 	return &Matcher{ignoreList: patterns}, scanner.Err()
 }
 
-// Match returns true if path has a prefix in common with any item in m.ignoreList
-func (m *Matcher) Match(path string) bool {
-	if len(m.ignoreList) == 0 {
-		return false
+// ParseGitIgnoreFile parses an ignore-file in the syntax of .gitignore files,
+// like ZoektIgnoreFile. Patterns are relative to the root of the repository,
+// and a pattern starting with ! includes the files of earlier patterns again.
+func ParseGitIgnoreFile(r io.Reader) (*Matcher, error) {
+	m := &Matcher{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, lineComment) {
+			continue
+		}
+		m.gitignore = append(m.gitignore, gitignore.ParsePattern(line, nil))
+		m.patterns = append(m.patterns, line)
+	}
+	return m, scanner.Err()
+}
+
+// Union returns a matcher which matches the paths matched by m or other.
+func (m *Matcher) Union(other *Matcher) *Matcher {
+	return &Matcher{
+		ignoreList: append(slices.Clip(m.ignoreList), other.ignoreList...),
+		gitignore:  append(slices.Clip(m.gitignore), other.gitignore...),
+		patterns:   append(slices.Clip(m.patterns), other.patterns...),
 	}
+}
+
+// Patterns returns the gitignore patterns of m, in the order of their files.
+func (m *Matcher) Patterns() []string {
+	return m.patterns
+}
+
+// Match returns true if path has a prefix in common with any item in
+// m.ignoreList, or is ignored by the gitignore patterns of m.
+func (m *Matcher) Match(path string) bool {
 	for _, pattern := range m.ignoreList {
 		if pattern.Match(path) {
 			return true
 		}
 	}
+	if len(m.gitignore) == 0 {
+		return false
+	}
+	// Like git, the last pattern matching path decides.
+	parts := strings.Split(path, "/")
+	for i := len(m.gitignore) - 1; i >= 0; i-- {
+		switch m.gitignore[i].Match(parts, false) {
+		case gitignore.Exclude:
+			return true
+		case gitignore.Include:
+			return false
+		}
+	}
 	return false
 }
@@ -87,3 +87,54 @@ dir1/
 		})
 	}
 }
+
+func TestGitIgnoreMatcher(t *testing.T) {
+	ig, err := ParseGitIgnoreFile(strings.NewReader(`
+# generated
+build/
+*.min.js
+!vendor/keep.min.js
+/root.txt
+docs/**/gen
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPatterns := []string{"build/", "*.min.js", "!vendor/keep.min.js", "/root.txt", "docs/**/gen"}
+	if !reflect.DeepEqual(ig.Patterns(), wantPatterns) {
+		t.Errorf("got patterns %q, want %q", ig.Patterns(), wantPatterns)
+	}
+
+	for path, want := range map[string]bool{
+		"build/a.js":          true,
+		"src/build/a.js":      true,
+		"build.go":            false,
+		"a.min.js":            true,
+		"vendor/keep.min.js":  false,
+		"vendor/other.min.js": true,
+		"root.txt":            true,
+		"src/root.txt":        false,
+		"docs/a/b/gen/x.md":   true,
+		"docs/readme.md":      false,
+	} {
+		if got := ig.Match(path); got != want {
+			t.Errorf("Match(%q): got %t, want %t", path, got, want)
+		}
+	}
+
+	// The union matches the paths of either file.
+	sg, err := ParseIgnoreFile(strings.NewReader("third_party"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := sg.Union(ig)
+	for path, want := range map[string]bool{
+		"third_party/x.go":   true,
+		"build/a.js":         true,
+		"vendor/keep.min.js": false,
+	} {
+		if got := u.Match(path); got != want {
+			t.Errorf("union: Match(%q): got %t, want %t", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/grafana/regexp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Match trees hold the state of evaluating a query on a shard, so they are
+// built for every search and not cached. What their atoms look up, which
+// only depends on the query and the shard, is cached instead, so repeated
+// queries, eg. of dashboards and polling bots, skip compiling regexps and
+// selecting ngrams:
+//
+//   - compiled regexps are shared by all shards, as they don't depend on the
+//     shard.
+//   - the ngrams selected for a substring are cached by each indexData. A
+//     rebuilt shard is a new indexData, so it starts with an empty cache.
+
+const (
+	// regexpCacheSize is the number of compiled regexps cached.
+	regexpCacheSize = 1000
+
+	// ngramSelectionCacheSize is the number of ngram selections each shard
+	// caches. It is small, as a process can load many thousands of shards.
+	ngramSelectionCacheSize = 64
+)
+
+var metricAtomCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_atom_cache_lookups_total",
+	Help: "The number of lookups of compiled regexps and selected ngrams of query atoms in their caches.",
+}, []string{
+	"kind",   // regexp or ngrams
+	"result", // hit or miss
+})
+
+// boundedCache is a map which drops an arbitrary entry once it holds size
+// entries. Repeated queries are usually among few distinct ones, so it avoids
+// the bookkeeping of an LRU.
+type boundedCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]V
+	size    int
+
+	hits, misses prometheus.Counter
+}
+
+// newBoundedCache returns a cache of size entries whose lookups are counted
+// as kind.
+func newBoundedCache[K comparable, V any](size int, kind string) boundedCache[K, V] {
+	return boundedCache[K, V]{
+		size:   size,
+		hits:   metricAtomCacheLookups.WithLabelValues(kind, "hit"),
+		misses: metricAtomCacheLookups.WithLabelValues(kind, "miss"),
+	}
+}
+
+// get returns the cached value for key, or caches the value computed by
+// compute. Errors are not cached. The zero boundedCache caches nothing.
+func (c *boundedCache[K, V]) get(key K, compute func() (V, error)) (V, error) {
+	if c.size == 0 {
+		return compute()
+	}
+
+	c.mu.Lock()
+	v, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		c.hits.Inc()
+		return v, nil
+	}
+	c.misses.Inc()
+
+	v, err := compute()
+	if err != nil {
+		return v, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[K]V, c.size)
+	}
+	if len(c.entries) >= c.size {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = v
+	return v, nil
+}
+
+var regexpCache = newBoundedCache[string, *regexp.Regexp](regexpCacheSize, "regexp")
+
+// compileRegexp returns the compiled regexp for expr, which must be valid.
+// Regexps are safe for concurrent use, so they are shared by all searches.
+func compileRegexp(expr string) *regexp.Regexp {
+	re, err := regexpCache.get(expr, func() (*regexp.Regexp, error) {
+		return regexp.Compile(expr)
+	})
+	if err != nil {
+		panic("regexp: Compile(" + expr + "): " + err.Error())
+	}
+	return re
+}
+
+// ngramSelectionKey identifies the ngram lookup of a substring query.
+type ngramSelectionKey struct {
+	pattern       string
+	caseSensitive bool
+	fileName      bool
+	symbol        bool
+}
+
+// ngramSelection are the ngrams selected for a substring query by selectNgrams.
+type ngramSelection struct {
+	// noMatch is set if an ngram of the pattern isn't in the shard.
+	noMatch bool
+
+	first, last runeNgramOff
+}
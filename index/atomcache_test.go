@@ -0,0 +1,85 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestAtomCache(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("x banana y")},
+		Document{Name: "f2", Content: []byte("x apple y")},
+		Document{Name: "f3", Content: []byte("x banana apple y")})
+	searcher := searcherForTest(t, b)
+
+	q := query.NewOr(
+		&query.Substring{Pattern: "banana"},
+		&query.Regexp{Regexp: mustParseRE("app.e"), Content: true},
+	)
+	hits := testutil.ToFloat64(metricAtomCacheLookups.WithLabelValues("ngrams", "hit"))
+
+	first, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := cmp.Diff(first.Files, second.Files); d != "" {
+		t.Errorf("repeated search (-first +second):\n%s", d)
+	}
+	if second.Stats.NgramLookups >= first.Stats.NgramLookups {
+		t.Errorf("got %d ngram lookups for the repeated search, want fewer than %d", second.Stats.NgramLookups, first.Stats.NgramLookups)
+	}
+	if got := testutil.ToFloat64(metricAtomCacheLookups.WithLabelValues("ngrams", "hit")) - hits; got == 0 {
+		t.Error("got no ngram selection cache hits")
+	}
+
+	// A rebuilt shard starts with an empty cache.
+	if d := searcherForTest(t, b).(*indexData); len(d.ngramSelections.entries) != 0 {
+		t.Errorf("got %d cached ngram selections for a new shard", len(d.ngramSelections.entries))
+	}
+}
+
+func TestBoundedCache(t *testing.T) {
+	c := newBoundedCache[int, int](2, "test")
+	computed := 0
+	get := func(k int) int {
+		v, err := c.get(k, func() (int, error) {
+			computed++
+			return k * 10, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if get(1) != 10 || get(1) != 10 || computed != 1 {
+		t.Fatalf("got %d computations for a repeated key, want 1", computed)
+	}
+	get(2)
+	get(3)
+	if len(c.entries) != 2 {
+		t.Errorf("got %d entries, want at most 2", len(c.entries))
+	}
+
+	errFailed := errors.New("failed")
+	for range 2 {
+		if _, err := c.get(4, func() (int, error) { return 0, errFailed }); err != errFailed {
+			t.Fatalf("got %v, want the error of compute", err)
+		}
+	}
+	if _, ok := c.entries[4]; ok {
+		t.Error("got a cached error")
+	}
+}
@@ -46,6 +46,17 @@ func generateCaseNgrams(g ngram) []ngram {
 	return variants
 }
 
+// lowerNGram returns g with all runes lowercased. This is how case-folded
+// indexes store trigrams, and matches how candidates are verified by
+// caseFoldingEqualsRunes.
+func lowerNGram(g ngram) ngram {
+	rs := ngramToRunes(g)
+	for i, r := range rs {
+		rs[i] = unicode.ToLower(r)
+	}
+	return runesToNGram(rs)
+}
+
 func toLower(in []byte) []byte {
 	out := make([]byte, 0, len(in))
 	var buf [4]byte
@@ -177,7 +188,7 @@ func byteClass(c byte) int {
 	}
 
 	switch c {
-	case ' ', '\n':
+	case ' ', '\r', '\n':
 		return _classSpace
 	case '.', ',', ';', '"', '\'':
 		return _classPunct
@@ -282,6 +293,46 @@ func fromSizedDeltas(data []byte, ps []uint32) []uint32 {
 	return ps
 }
 
+func toSizedDeltas64(offsets []uint64) []byte {
+	var enc [binary.MaxVarintLen64]byte
+
+	deltas := make([]byte, 0, len(offsets)*2)
+
+	m := binary.PutUvarint(enc[:], uint64(len(offsets)))
+	deltas = append(deltas, enc[:m]...)
+
+	var last uint64
+	for _, p := range offsets {
+		delta := p - last
+		last = p
+
+		m := binary.PutUvarint(enc[:], delta)
+		deltas = append(deltas, enc[:m]...)
+	}
+	return deltas
+}
+
+func fromSizedDeltas64(data []byte, ps []uint64) []uint64 {
+	sz, m := binary.Uvarint(data)
+	data = data[m:]
+
+	if cap(ps) < int(sz) {
+		ps = make([]uint64, 0, sz)
+	} else {
+		ps = ps[:0]
+	}
+
+	var last uint64
+	for len(data) > 0 {
+		delta, m := binary.Uvarint(data)
+		offset := last + delta
+		last = offset
+		data = data[m:]
+		ps = append(ps, offset)
+	}
+	return ps
+}
+
 func toSizedDeltas16(offsets []uint16) []byte {
 	var enc [8]byte
 
@@ -340,7 +391,8 @@ func fromDeltas(data []byte, buf []uint32) []uint32 {
 }
 
 type runeOffsetCorrection struct {
-	runeOffset, byteOffset uint32
+	runeOffset uint32
+	byteOffset uint64
 }
 
 // runeOffsetMap converts from rune offsets (with granularity runeOffsetFrequency)
@@ -358,8 +410,8 @@ type runeOffsetMap []runeOffsetCorrection
 //
 // The input is a sequence of y values that we expect to increase by 100 each,
 // so we just store (x, y) points where the expectation is violated.
-func makeRuneOffsetMap(off []uint32) runeOffsetMap {
-	expected := uint32(0)
+func makeRuneOffsetMap(off []uint64) runeOffsetMap {
+	expected := uint64(0)
 	tmp := []runeOffsetCorrection{}
 	for runeOffset, byteOffset := range off {
 		if byteOffset != expected {
@@ -378,12 +430,12 @@ func makeRuneOffsetMap(off []uint32) runeOffsetMap {
 // runes to traverse, given the granularity of runeOffsetFrequency.
 //
 // It does this by finding the nearest point to interpolate from in the map.
-func (m runeOffsetMap) lookup(runeOffset uint32) (uint32, uint32) {
+func (m runeOffsetMap) lookup(runeOffset uint32) (uint64, uint32) {
 	left := runeOffset % runeOffsetFrequency
 	runeOffset -= left
 	slen := len(m)
 	if slen == 0 {
-		return runeOffset, left
+		return uint64(runeOffset), left
 	}
 	// sort.Search finds the *first* index for which the predicate is true,
 	// but we want to find the *last* index for which the predicate is true.
@@ -394,15 +446,15 @@ func (m runeOffsetMap) lookup(runeOffset uint32) (uint32, uint32) {
 	idx = slen - 1 - idx
 	// idx is now in the range [-1, len(m))-- -1 indicates that the offset is smaller
 	// than the first entry in the map, so no correction is necessary.
-	byteOff := runeOffset
+	byteOff := uint64(runeOffset)
 	if idx >= 0 {
-		byteOff = m[idx].byteOffset + runeOffset - m[idx].runeOffset
+		byteOff = m[idx].byteOffset + uint64(runeOffset-m[idx].runeOffset)
 	}
 	return byteOff, left
 }
 
 func (m runeOffsetMap) sizeBytes() int {
-	return 8 * len(m)
+	return 16 * len(m)
 }
 
 func epsilonEqualsOne(scoreWeight float64) bool {
@@ -209,12 +209,13 @@ func sortedUnique(nums []uint32) []uint32 {
 
 func TestCondenseRuneOffsets(t *testing.T) {
 	for i, tc := range []struct {
-		arr  []uint32
+		arr  []uint64
 		want []runeOffsetCorrection
 	}{
-		{[]uint32{}, []runeOffsetCorrection{}},
-		{[]uint32{0, 100, 200, 300, 400, 500}, []runeOffsetCorrection{}},
-		{[]uint32{0, 105, 205, 310, 420}, []runeOffsetCorrection{{100, 105}, {300, 310}, {400, 420}}},
+		{[]uint64{}, []runeOffsetCorrection{}},
+		{[]uint64{0, 100, 200, 300, 400, 500}, []runeOffsetCorrection{}},
+		{[]uint64{0, 105, 205, 310, 420}, []runeOffsetCorrection{{100, 105}, {300, 310}, {400, 420}}},
+		{[]uint64{0, 5 << 30, 5<<30 + 100}, []runeOffsetCorrection{{100, 5 << 30}}},
 	} {
 		got := makeRuneOffsetMap(tc.arr)
 		if !reflect.DeepEqual(got, runeOffsetMap(tc.want)) {
@@ -232,15 +233,17 @@ func TestCondenseRuneOffsets(t *testing.T) {
 
 func TestRuneOffsetLookup(t *testing.T) {
 	for i, tc := range []struct {
-		r                 uint32
-		wantOff, wantLeft uint32
-		offsets           []runeOffsetCorrection
+		r        uint32
+		wantOff  uint64
+		wantLeft uint32
+		offsets  []runeOffsetCorrection
 	}{
 		{0, 0, 0, nil},
 		{1234, 1200, 34, nil},
 		{5, 0, 5, []runeOffsetCorrection{{100, 105}, {400, 430}}},
 		{120, 105, 20, []runeOffsetCorrection{{100, 105}, {400, 430}}},
 		{1234, 1230, 34, []runeOffsetCorrection{{100, 105}, {400, 430}}},
+		{1234, 5<<30 + 1100, 34, []runeOffsetCorrection{{100, 5 << 30}}},
 	} {
 		gotOff, gotLeft := runeOffsetMap(tc.offsets).lookup(tc.r)
 		if gotLeft != tc.wantLeft {
@@ -253,7 +256,7 @@ func TestRuneOffsetLookup(t *testing.T) {
 
 	m := runeOffsetMap([]runeOffsetCorrection{{100, 105}, {200, 210}, {400, 430}})
 	inputs := []uint32{0, 1, 99, 100, 101, 199, 200, 201, 300, 399, 400, 401, 510, 610}
-	wanted := []uint32{0, 0, 0, 105, 105, 105, 210, 210, 310, 310, 430, 430, 530, 630}
+	wanted := []uint64{0, 0, 0, 105, 105, 105, 210, 210, 310, 310, 430, 430, 530, 630}
 	for i, v := range inputs {
 		got, _ := m.lookup(v)
 		if got != wanted[i] {
@@ -294,6 +294,9 @@ type btreeIndex struct {
 	ngramSec simpleSection
 
 	postingIndex simpleSection
+
+	// widePostingIndex is set if postingIndex contains 64-bit offsets.
+	widePostingIndex bool
 }
 
 // SizeBytes returns how much memory this structure uses in the heap.
@@ -303,11 +306,11 @@ func (b btreeIndex) SizeBytes() (sz int) {
 		sz += int(pointerSize) + b.bt.sizeBytes()
 	}
 	// ngramSec
-	sz += 8
+	sz += 16
 	// postingIndex
-	sz += 8
-	// postingDataSentinelOffset
-	sz += 4
+	sz += 16
+	// widePostingIndex
+	sz += 1
 	return
 }
 
@@ -357,17 +360,20 @@ func (b btreeIndex) Get(ng ngram) (ss simpleSection) {
 // Assumming we don't hit a page boundary, which should be rare given that we
 // only read 8 bytes, we need 1 disk access to read the posting offset.
 func (b btreeIndex) getPostingList(ngramIndex int) simpleSection {
-	relativeOffsetBytes := uint32(ngramIndex) * 4
+	width := uint64(4)
+	if b.widePostingIndex {
+		width = 8
+	}
+	relativeOffsetBytes := uint64(ngramIndex) * width
 
-	if relativeOffsetBytes+8 <= b.postingIndex.sz {
+	if relativeOffsetBytes+2*width <= b.postingIndex.sz {
 		// read 2 offsets
-		o, err := b.file.Read(b.postingIndex.off+relativeOffsetBytes, 8)
+		o, err := b.file.Read(b.postingIndex.off+relativeOffsetBytes, 2*width)
 		if err != nil {
 			return simpleSection{}
 		}
 
-		start := binary.BigEndian.Uint32(o[0:4])
-		end := binary.BigEndian.Uint32(o[4:8])
+		start, end := decodeOffset(o, width), decodeOffset(o[width:], width)
 		return simpleSection{
 			off: start,
 			sz:  end - start,
@@ -375,12 +381,12 @@ func (b btreeIndex) getPostingList(ngramIndex int) simpleSection {
 	} else {
 		// last ngram => read 1 offset and calculate the size of the posting
 		// list from the offset of index section.
-		o, err := b.file.Read(b.postingIndex.off+relativeOffsetBytes, 4)
+		o, err := b.file.Read(b.postingIndex.off+relativeOffsetBytes, width)
 		if err != nil {
 			return simpleSection{}
 		}
 
-		start := binary.BigEndian.Uint32(o[0:4])
+		start := decodeOffset(o, width)
 		return simpleSection{
 			off: start,
 			// The layout of the posting list compound section on disk is
@@ -396,10 +402,18 @@ func (b btreeIndex) getPostingList(ngramIndex int) simpleSection {
 	}
 }
 
-func (b btreeIndex) getBucket(bucketIndex int) (off uint32, sz uint32) {
+// decodeOffset decodes a 32-bit or 64-bit offset from the start of b.
+func decodeOffset(b []byte, width uint64) uint64 {
+	if width == 8 {
+		return binary.BigEndian.Uint64(b)
+	}
+	return uint64(binary.BigEndian.Uint32(b))
+}
+
+func (b btreeIndex) getBucket(bucketIndex int) (off uint64, sz uint64) {
 	// All but the rightmost bucket have exactly bucketSize/2 ngrams
-	sz = uint32(b.bt.opts.bucketSize / 2 * ngramEncoding)
-	off = b.ngramSec.off + uint32(bucketIndex)*sz
+	sz = uint64(b.bt.opts.bucketSize / 2 * ngramEncoding)
+	off = b.ngramSec.off + uint64(bucketIndex)*sz
 
 	// Rightmost bucket has size upto the end of the ngramSec.
 	if bucketIndex == b.bt.lastBucketIndex {
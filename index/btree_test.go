@@ -65,14 +65,14 @@ func TestFindBucket(t *testing.T) {
 }
 
 func TestGetBucket(t *testing.T) {
-	var off uint32 = 13
+	var off uint64 = 13
 	bucketSize := 4
 
 	cases := []struct {
 		nNgrams     int
 		bucketIndex int
-		wantOff     uint32
-		wantSz      uint32
+		wantOff     uint64
+		wantSz      uint64
 	}{
 		// tiny B-tree with just 1 bucket.
 		{
@@ -129,7 +129,7 @@ func TestGetBucket(t *testing.T) {
 	for _, tt := range cases {
 		t.Run("", func(t *testing.T) {
 			bi := btreeIndex{
-				ngramSec: simpleSection{off: off, sz: uint32(tt.nNgrams * ngramEncoding)},
+				ngramSec: simpleSection{off: off, sz: uint64(tt.nNgrams * ngramEncoding)},
 			}
 
 			bt := newBtree(btreeOpts{
@@ -19,6 +19,7 @@ package index
 import (
 	"cmp"
 	"crypto/sha1"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -30,6 +31,7 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/pprof"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -96,6 +98,26 @@ type Options struct {
 	// https://github.com/bmatcuk/doublestar/tree/v1#patterns.
 	LargeFiles []string
 
+	// ContentExtractors are the ContentExtractors in the format of
+	// ParseContentExtractor, eg. "**/*.pdf=pdftotext - -". The first one
+	// matching a document converts its content to text before the document
+	// is checked for binary content. Documents above SizeMax are skipped
+	// before extraction, and so is text above SizeMax.
+	ContentExtractors []string
+
+	// MaxNULBytes is the number of NUL bytes a document may contain and
+	// still be indexed as text. Documents with more are binary, and only
+	// indexed by name.
+	MaxNULBytes int
+
+	// SniffMIME also indexes documents only by name if their content
+	// sniffs as a binary MIME type, eg. application/pdf or image/png.
+	SniffMIME bool
+
+	// TextExtensions are file extensions, eg. ".dat", whose documents are
+	// never binary, regardless of MaxNULBytes and SniffMIME.
+	TextExtensions []string
+
 	// IsDelta is true if this run contains only the changed documents since the
 	// last run.
 	IsDelta bool
@@ -121,6 +143,115 @@ type Options struct {
 
 	// ShardPrefix is the prefix of the shard. It defaults to the repository name.
 	ShardPrefix string
+
+	// RedactSecrets enables the filtering of documents with DefaultSecretRules.
+	// It is one of "redact", "line" or "skip" for the SecretActions
+	// SecretRedact, SecretRedactLine and SecretSkipFile. The empty string
+	// disables redaction.
+	RedactSecrets string
+
+	// DocumentFilter is called for every document before it is added to a
+	// shard. If RedactSecrets is set, its filter runs after DocumentFilter.
+	DocumentFilter DocumentFilter
+
+	// FilterCommand is a command with its arguments separated by spaces,
+	// which decides for every document whether to index, skip or transform
+	// it, see NewFilterCommand. It runs after DocumentFilter and before the
+	// filter of RedactSecrets.
+	FilterCommand string
+
+	// FoldedNgrams adds trigram indexes of the lowercased file names and
+	// symbols to shards. They make case-insensitive searches over file names
+	// and symbols cheaper, at the cost of larger shards.
+	FoldedNgrams bool
+
+	// SymbolNgrams adds a trigram index of the symbols to shards. Symbol
+	// searches look up candidates in it instead of in the content index, so
+	// they only consider occurrences of the pattern within symbols.
+	SymbolNgrams bool
+
+	// SymbolHashes adds a hash index of the symbol names to shards. Definition
+	// lookups by exact name use it instead of scanning all symbols.
+	SymbolHashes bool
+
+	// FuzzySymbols adds a hash index of the deletion neighborhoods of the
+	// symbol names to shards. fuzzy: queries look up the symbols one edit
+	// away from their pattern in it instead of comparing the pattern to
+	// every symbol. Names longer than 64 characters are left out of it.
+	FuzzySymbols bool
+
+	// CJKBigrams adds an index of the documents containing each pair of
+	// adjacent Chinese, Japanese or Korean characters to shards. Searches
+	// for patterns too short for the trigram index, like most Chinese words,
+	// use it instead of scanning every document.
+	CJKBigrams bool
+
+	// EncryptContents encrypts the file contents of shards with AES-GCM, with
+	// the key of the tenant of the repository, see SetContentKeys.
+	//
+	// This protects the contents from someone who can read the shard files,
+	// eg. on a disk or in a backup, but not the keys. It doesn't protect
+	// them from someone who can read the memory or the environment of the
+	// processes which index and search, or who can search. Most of the
+	// shard stays in plaintext: the file names, the symbols, the ngram
+	// indexes, which tell what each document contains, the sizes and line
+	// lengths of the documents and the repository metadata. The contents
+	// of a document are bound to its position in the shard, not to the
+	// shard, so swapping them with those of the same position in another
+	// shard of the tenant isn't detected.
+	EncryptContents bool
+
+	// MaxTrigramFrequency, if non-zero, leaves the content trigrams which
+	// occur more than this many times in a shard out of its index. This
+	// keeps shards with very repetitive content, such as minified
+	// JavaScript, small. Searches for patterns made up of only such
+	// trigrams scan the documents instead.
+	MaxTrigramFrequency int
+
+	// MaxPostingsMemory, if non-zero, is the approximate number of bytes of
+	// trigram posting lists a shard holds in memory while it is built. Above
+	// it, the posting lists are spilled to sorted runs in temporary files in
+	// IndexDir, which are merged when the shard is written. This bounds the
+	// memory of building the shards of giant repositories, eg. to build more
+	// of them in parallel, at the cost of disk IO.
+	MaxPostingsMemory int
+
+	// ShardBytesLimit, if positive, is the most bytes the shards of the
+	// repository may take up once they are published, eg. the remaining
+	// quota of its tenant. Finish removes larger shards instead of
+	// publishing them, and returns an error. The shards of previous runs
+	// which a delta build keeps are counted too.
+	ShardBytesLimit int64
+
+	// ContentAddressedShards names shards by a hash of their content, and
+	// publishes them with a manifest, see ShardManifest. Readers switch from
+	// the previous shards of the repository to the new ones at once, and
+	// unchanged shards keep their names.
+	ContentAddressedShards bool
+
+	// RankingSignals is the path of a JSON file with precomputed scores of
+	// the files, see LoadRankingSignals. They are stored as the
+	// RankingSignal of the documents.
+	RankingSignals string
+
+	// DocumentLabels is the path of a JSON file with key/value labels of the
+	// files, see LoadDocumentLabels. They are stored as the Labels of the
+	// documents.
+	DocumentLabels string
+
+	// RepoHistory appends a snapshot of the repository to the RepoHistoryFile
+	// in the index directory whenever its shards were written, so the
+	// indexed state as of a past time can be looked up with ReposAt.
+	RepoHistory bool
+
+	// ReportFile, if set, is the path the builder writes a BuildReport of
+	// the run to when it finishes.
+	ReportFile string
+
+	// SymbolDocs is a comma separated list of languages, or "all", whose
+	// symbols get the comment or docstring documenting them stored as their
+	// Symbol.Doc, eg. for hover previews.
+	SymbolDocs string
 }
 
 // HashOptions contains only the options in Options that upon modification leads to IndexState of IndexStateMismatch during the next index building.
@@ -130,6 +261,25 @@ type HashOptions struct {
 	ctagsPath        string
 	cTagsMustSucceed bool
 	largeFiles       []string
+	redactSecrets    string
+	filterCommand    string
+
+	contentExtractors []string
+	maxNULBytes       int
+	sniffMIME         bool
+	textExtensions    []string
+	foldedNgrams      bool
+	symbolNgrams      bool
+	symbolHashes      bool
+	fuzzySymbols      bool
+	cjkBigrams        bool
+	encryptContents   bool
+
+	maxTrigramFrequency    int
+	contentAddressedShards bool
+	rankingSignals         string
+	documentLabels         string
+	symbolDocs             string
 }
 
 func (o *Options) HashOptions() HashOptions {
@@ -139,6 +289,25 @@ func (o *Options) HashOptions() HashOptions {
 		ctagsPath:        o.CTagsPath,
 		cTagsMustSucceed: o.CTagsMustSucceed,
 		largeFiles:       o.LargeFiles,
+		redactSecrets:    o.RedactSecrets,
+		filterCommand:    o.FilterCommand,
+
+		contentExtractors: o.ContentExtractors,
+		maxNULBytes:       o.MaxNULBytes,
+		sniffMIME:         o.SniffMIME,
+		textExtensions:    o.TextExtensions,
+		foldedNgrams:      o.FoldedNgrams,
+		symbolNgrams:      o.SymbolNgrams,
+		symbolHashes:      o.SymbolHashes,
+		fuzzySymbols:      o.FuzzySymbols,
+		cjkBigrams:        o.CJKBigrams,
+		encryptContents:   o.EncryptContents,
+
+		maxTrigramFrequency:    o.MaxTrigramFrequency,
+		contentAddressedShards: o.ContentAddressedShards,
+		rankingSignals:         o.RankingSignals,
+		documentLabels:         o.DocumentLabels,
+		symbolDocs:             o.SymbolDocs,
 	}
 }
 
@@ -151,6 +320,54 @@ func (o *Options) GetHash() string {
 	hasher.Write([]byte(fmt.Sprintf("%d", h.sizeMax)))
 	hasher.Write([]byte(fmt.Sprintf("%q", h.largeFiles)))
 	hasher.Write([]byte(fmt.Sprintf("%t", h.disableCTags)))
+	// Only hashed if set, so enabling redaction does not change existing
+	// hashes.
+	if h.redactSecrets != "" {
+		hasher.Write([]byte(h.redactSecrets))
+	}
+	if h.filterCommand != "" {
+		hasher.Write([]byte("filter_command=" + h.filterCommand))
+	}
+	if len(h.contentExtractors) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("content_extractors=%q", h.contentExtractors)))
+	}
+	if h.maxNULBytes != 0 {
+		hasher.Write([]byte(fmt.Sprintf("max_nul_bytes=%d", h.maxNULBytes)))
+	}
+	if h.sniffMIME {
+		hasher.Write([]byte("sniff_mime"))
+	}
+	if len(h.textExtensions) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("text_extensions=%q", h.textExtensions)))
+	}
+	if h.foldedNgrams {
+		hasher.Write([]byte("folded_ngrams"))
+	}
+	if h.symbolNgrams {
+		hasher.Write([]byte("symbol_ngrams"))
+	}
+	if h.maxTrigramFrequency != 0 {
+		hasher.Write([]byte(fmt.Sprintf("max_trigram_frequency=%d", h.maxTrigramFrequency)))
+	}
+	if h.contentAddressedShards {
+		hasher.Write([]byte("content_addressed_shards"))
+	}
+	if h.rankingSignals != "" {
+		// Hash the signals rather than the path, so new signals are indexed.
+		hasher.Write([]byte("ranking_signals="))
+		if b, err := os.ReadFile(h.rankingSignals); err == nil {
+			hasher.Write(b)
+		}
+	}
+	if h.symbolDocs != "" {
+		hasher.Write([]byte("symbol_docs=" + h.symbolDocs))
+	}
+	if h.documentLabels != "" {
+		hasher.Write([]byte("document_labels="))
+		if b, err := os.ReadFile(h.documentLabels); err == nil {
+			hasher.Write(b)
+		}
+	}
 
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
@@ -174,6 +391,23 @@ func (f largeFilesFlag) Set(value string) error {
 	return nil
 }
 
+type contentExtractorsFlag struct{ *Options }
+
+func (f contentExtractorsFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	return strings.Join(f.ContentExtractors, " ")
+}
+
+func (f contentExtractorsFlag) Set(value string) error {
+	if _, err := ParseContentExtractor(value); err != nil {
+		return err
+	}
+	f.ContentExtractors = append(f.ContentExtractors, value)
+	return nil
+}
+
 // Flags adds flags for build options to fs. It is the "inverse" of Args.
 func (o *Options) Flags(fs *flag.FlagSet) {
 	x := *o
@@ -185,7 +419,28 @@ func (o *Options) Flags(fs *flag.FlagSet) {
 	fs.StringVar(&o.IndexDir, "index", x.IndexDir, "directory for search indices")
 	fs.BoolVar(&o.CTagsMustSucceed, "require_ctags", x.CTagsMustSucceed, "If set, ctags calls must succeed.")
 	fs.Var(largeFilesFlag{o}, "large_file", "A glob pattern where matching files are to be index regardless of their size. You can add multiple patterns by setting this more than once.")
+	fs.Var(contentExtractorsFlag{o}, "content_extractor", "PATTERN=COMMAND: index the text COMMAND writes to stdout, given the content on stdin, instead of the content of files matching the glob PATTERN, eg. '**/*.pdf=pdftotext - -'. The first matching extractor is used. You can add multiple extractors by setting this more than once.")
+	fs.IntVar(&o.MaxNULBytes, "max_nul_bytes", x.MaxNULBytes, "the number of NUL bytes a file may contain and still be indexed as text. Files with more are binary, and only indexed by name.")
+	fs.BoolVar(&o.SniffMIME, "sniff_mime", x.SniffMIME, "If set, also index files only by name if their content sniffs as a binary MIME type, eg. application/pdf or image/png.")
+	fs.Var(textExtensionsFlag{o}, "text_extensions", "comma separated file extensions, eg. .dat,.bin, whose files are indexed as text even if they contain NUL bytes or sniff as binary. You can set this more than once.")
 	fs.StringVar(&o.ShardPrefix, "shard_prefix", x.ShardPrefix, "the prefix of the shard. Defaults to repository name")
+	fs.StringVar(&o.FilterCommand, "filter_command", x.FilterCommand, "If set, run this command with the content of every document on stdin, and index, skip or transform the document as it answers on the first line of stdout: index, skip [reason] or transform followed by the new content.")
+	fs.StringVar(&o.RedactSecrets, "redact_secrets", x.RedactSecrets, "If set, mask secrets matching the built-in rules before indexing. One of redact (mask the secret), line (mask the line) or skip (skip the file).")
+	fs.BoolVar(&o.FoldedNgrams, "folded_ngrams", x.FoldedNgrams, "If set, add case-folded trigram indexes for file names and symbols, which speed up case-insensitive searches over them.")
+	fs.BoolVar(&o.SymbolNgrams, "symbol_ngrams", x.SymbolNgrams, "If set, add a trigram index of the symbols, which speeds up symbol searches.")
+	fs.BoolVar(&o.SymbolHashes, "symbol_hashes", x.SymbolHashes, "If set, add a hash index of the symbol names, which speeds up definition lookups.")
+	fs.BoolVar(&o.FuzzySymbols, "fuzzy_symbols", x.FuzzySymbols, "If set, add an index of the symbol names with one character deleted, which speeds up fuzzy: searches.")
+	fs.BoolVar(&o.CJKBigrams, "cjk_bigrams", x.CJKBigrams, "If set, add an index of the pairs of adjacent Chinese, Japanese and Korean characters, which speeds up searches for two character words.")
+	fs.IntVar(&o.MaxTrigramFrequency, "max_trigram_frequency", x.MaxTrigramFrequency, "If non-zero, don't index content trigrams which occur more often than this in a shard. Searches for them scan the documents instead.")
+	fs.IntVar(&o.MaxPostingsMemory, "max_postings_memory", x.MaxPostingsMemory, "If non-zero, spill the posting lists of a shard being built to temporary files in the index directory once they take more than this many bytes, and merge them when the shard is written.")
+	fs.Int64Var(&o.ShardBytesLimit, "shard_bytes_limit", x.ShardBytesLimit, "If positive, don't publish the shards of the repository if they take up more than this many bytes, and fail instead.")
+	fs.BoolVar(&o.ContentAddressedShards, "content_addressed_shards", x.ContentAddressedShards, "If set, name shards by the hash of their content and publish them with a manifest, so searches switch to the new shards of a repository at once.")
+	fs.StringVar(&o.RankingSignals, "ranking_signals", x.RankingSignals, "the path of a JSON file with precomputed scores of the files, eg. {\"default\": 0.5, \"paths\": {\"cmd/main.go\": 12.5}}, which are blended into the score of matches if requested.")
+	fs.StringVar(&o.DocumentLabels, "document_labels", x.DocumentLabels, "the path of a JSON file with key/value labels of the files or directories, eg. {\"services/billing/\": {\"team\": \"payments\"}}, which are searched with meta.key:value and returned with matches.")
+	fs.BoolVar(&o.RepoHistory, "repo_history", x.RepoHistory, "If set, record the branches and index time of the repository in "+RepoHistoryFile+" in the index directory whenever it is indexed, to look up which code was searchable at a past time.")
+	fs.StringVar(&o.ReportFile, "report_file", x.ReportFile, "If set, write a JSON report of the build, with the skipped files, shard sizes and ctags failures, to this path.")
+	fs.StringVar(&o.SymbolDocs, "symbol_docs", x.SymbolDocs, "If set, store the documentation comments of the symbols of these comma separated languages, or of all supported languages with 'all', eg. go,python.")
+	fs.BoolVar(&o.EncryptContents, "encrypt_contents", x.EncryptContents, "If set, encrypt the file contents with the key of the tenant, from ZOEKT_CONTENT_KEY_COMMAND or ZOEKT_CONTENT_KEY[_<tenant ID>].")
 
 	// Sourcegraph specific
 	fs.BoolVar(&o.DisableCTags, "disable_ctags", x.DisableCTags, "If set, ctags will not be called.")
@@ -224,6 +479,22 @@ func (o *Options) Args() []string {
 		args = append(args, "-large_file", a)
 	}
 
+	for _, e := range o.ContentExtractors {
+		args = append(args, "-content_extractor", e)
+	}
+
+	if o.MaxNULBytes != 0 {
+		args = append(args, "-max_nul_bytes", strconv.Itoa(o.MaxNULBytes))
+	}
+
+	if o.SniffMIME {
+		args = append(args, "-sniff_mime")
+	}
+
+	if len(o.TextExtensions) > 0 {
+		args = append(args, "-text_extensions", strings.Join(o.TextExtensions, ","))
+	}
+
 	// Sourcegraph specific
 	if o.DisableCTags {
 		args = append(args, "-disable_ctags")
@@ -237,6 +508,74 @@ func (o *Options) Args() []string {
 		args = append(args, "-shard_prefix", o.ShardPrefix)
 	}
 
+	if o.RedactSecrets != "" {
+		args = append(args, "-redact_secrets", o.RedactSecrets)
+	}
+
+	if o.FilterCommand != "" {
+		args = append(args, "-filter_command", o.FilterCommand)
+	}
+
+	if o.FoldedNgrams {
+		args = append(args, "-folded_ngrams")
+	}
+
+	if o.SymbolNgrams {
+		args = append(args, "-symbol_ngrams")
+	}
+
+	if o.SymbolHashes {
+		args = append(args, "-symbol_hashes")
+	}
+
+	if o.FuzzySymbols {
+		args = append(args, "-fuzzy_symbols")
+	}
+
+	if o.CJKBigrams {
+		args = append(args, "-cjk_bigrams")
+	}
+
+	if o.EncryptContents {
+		args = append(args, "-encrypt_contents")
+	}
+
+	if o.MaxTrigramFrequency != 0 {
+		args = append(args, "-max_trigram_frequency", strconv.Itoa(o.MaxTrigramFrequency))
+	}
+
+	if o.MaxPostingsMemory != 0 {
+		args = append(args, "-max_postings_memory", strconv.Itoa(o.MaxPostingsMemory))
+	}
+
+	if o.ShardBytesLimit > 0 {
+		args = append(args, "-shard_bytes_limit", strconv.FormatInt(o.ShardBytesLimit, 10))
+	}
+
+	if o.ContentAddressedShards {
+		args = append(args, "-content_addressed_shards")
+	}
+
+	if o.RankingSignals != "" {
+		args = append(args, "-ranking_signals", o.RankingSignals)
+	}
+
+	if o.DocumentLabels != "" {
+		args = append(args, "-document_labels", o.DocumentLabels)
+	}
+
+	if o.RepoHistory {
+		args = append(args, "-repo_history")
+	}
+
+	if o.ReportFile != "" {
+		args = append(args, "-report_file", o.ReportFile)
+	}
+
+	if o.SymbolDocs != "" {
+		args = append(args, "-symbol_docs", o.SymbolDocs)
+	}
+
 	return args
 }
 
@@ -246,10 +585,23 @@ func (o *Options) Args() []string {
 type Builder struct {
 	opts     Options
 	throttle chan int
+	filter   DocumentFilter
+
+	extractors []ContentExtractor
+
+	// rankingSignals are loaded from Options.RankingSignals.
+	rankingSignals *RankingSignals
+
+	// documentLabels are loaded from Options.DocumentLabels.
+	documentLabels *DocumentLabels
+
+	// symbolDocStyles are the languages of Options.SymbolDocs, or nil.
+	symbolDocStyles map[string]symbolDocStyle
 
 	nextShardNum int
 	todo         []*Document
 	docChecker   DocChecker
+	sniffer      *binarySniffer
 	size         int
 
 	parserBins ctags.ParserBinMap
@@ -272,6 +624,8 @@ type Builder struct {
 	// a sortable 20 chars long id.
 	id string
 
+	report buildReport
+
 	finishCalled bool
 }
 
@@ -334,6 +688,51 @@ func (o *Options) SetDefaults() {
 	}
 }
 
+// documentFilter returns the combination of DocumentFilter, the filter of
+// FilterCommand and the secret filter requested by RedactSecrets.
+func (o *Options) documentFilter() (DocumentFilter, error) {
+	var filters []DocumentFilter
+	if o.DocumentFilter != nil {
+		filters = append(filters, o.DocumentFilter)
+	}
+
+	if o.FilterCommand != "" {
+		cmd, err := NewFilterCommand(o.FilterCommand, o.RepositoryDescription.Name)
+		if err != nil {
+			return nil, fmt.Errorf("builder: %w", err)
+		}
+		filters = append(filters, cmd)
+	}
+
+	var action SecretAction
+	switch o.RedactSecrets {
+	case "":
+	case "redact":
+		action = SecretRedact
+	case "line":
+		action = SecretRedactLine
+	case "skip":
+		action = SecretSkipFile
+	default:
+		return nil, fmt.Errorf("builder: invalid RedactSecrets %q, want redact, line or skip", o.RedactSecrets)
+	}
+	if o.RedactSecrets != "" {
+		filters = append(filters, NewSecretFilter(DefaultSecretRules, action))
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return func(doc *Document) {
+		for _, f := range filters {
+			f(doc)
+		}
+	}, nil
+}
+
 // ShardName returns the name the given index shard.
 func (o *Options) shardName(n int) string {
 	return o.shardNameVersion(IndexFormatVersion, n)
@@ -343,6 +742,24 @@ func (o *Options) shardNameVersion(version, n int) string {
 	return ShardName(o.IndexDir, cmp.Or(o.ShardPrefix, o.RepositoryDescription.Name), version, n)
 }
 
+func (o *Options) manifestName() string {
+	return ShardManifestName(o.IndexDir, cmp.Or(o.ShardPrefix, o.RepositoryDescription.Name))
+}
+
+// manifestShards returns the shards listed in the manifest of the
+// repository, if it has one.
+func (o *Options) manifestShards() ([]string, bool) {
+	m, err := ReadShardManifest(o.manifestName())
+	if err != nil {
+		return nil, false
+	}
+	shards := make([]string, 0, len(m.Shards))
+	for _, name := range m.Shards {
+		shards = append(shards, filepath.Join(o.IndexDir, name))
+	}
+	return shards, true
+}
+
 type IndexState string
 
 const (
@@ -459,6 +876,10 @@ func (o *Options) FindRepositoryMetadata() (repository *zoekt.Repository, metada
 }
 
 func (o *Options) findShard() string {
+	if shards, ok := o.manifestShards(); ok && len(shards) > 0 {
+		return shards[0]
+	}
+
 	for _, v := range readVersions {
 		fn := o.shardNameVersion(v.IndexFormatVersion, 0)
 		if _, err := os.Stat(fn); err == nil {
@@ -486,6 +907,10 @@ func (o *Options) findCompoundShard() string {
 }
 
 func (o *Options) FindAllShards() []string {
+	if shards, ok := o.manifestShards(); ok {
+		return shards
+	}
+
 	for _, v := range readVersions {
 		fn := o.shardNameVersion(v.IndexFormatVersion, 0)
 		if _, err := os.Stat(fn); err == nil {
@@ -563,6 +988,33 @@ func NewBuilder(opts Options) (*Builder, error) {
 
 	b.parserBins = parserBins
 
+	b.filter, err = opts.documentFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	b.extractors, err = opts.contentExtractors()
+	if err != nil {
+		return nil, err
+	}
+	b.sniffer = newBinarySniffer(&opts)
+
+	if opts.RankingSignals != "" {
+		if b.rankingSignals, err = LoadRankingSignals(opts.RankingSignals); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DocumentLabels != "" {
+		if b.documentLabels, err = LoadDocumentLabels(opts.DocumentLabels); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.symbolDocStyles, err = parseSymbolDocLanguages(opts.SymbolDocs); err != nil {
+		return nil, err
+	}
+
 	if opts.IsDelta {
 		// Delta shards build on top of previously existing shards.
 		// As a consequence, the shardNum for delta shards starts from
@@ -581,6 +1033,8 @@ func NewBuilder(opts Options) (*Builder, error) {
 	now := time.Now()
 	b.indexTime = now
 	b.id = xid.NewWithTime(now).String()
+	b.report.Repository = opts.RepositoryDescription.Name
+	b.report.Start = now
 
 	return b, nil
 }
@@ -595,15 +1049,50 @@ func (b *Builder) Add(doc Document) error {
 		return nil
 	}
 
+	skipKind := ""
+	if doc.SkipReason != "" {
+		skipKind = skipKindOther
+	}
 	allowLargeFile := b.opts.IgnoreSizeMax(doc.Name)
-	if len(doc.Content) > b.opts.SizeMax && !allowLargeFile {
+	tooLarge := func() bool {
+		return len(doc.Content) > b.opts.SizeMax && !allowLargeFile
+	}
+	// The size is checked before extraction, so large files are not passed to
+	// the extractors, and again for the text they return.
+	if tooLarge() {
 		// We could pass the document on to the shardbuilder, but if
 		// we pass through a part of the source tree with binary/large
 		// files, the corresponding shard would be mostly empty, so
 		// insert a reason here too.
 		doc.SkipReason = fmt.Sprintf("document size %d larger than limit %d", len(doc.Content), b.opts.SizeMax)
-	} else if err := b.docChecker.Check(doc.Content, b.opts.TrigramMax, allowLargeFile); err != nil {
+		skipKind = skipKindSize
+	} else if reason := extract(b.extractors, &doc); reason != "" {
+		doc.SkipReason = reason
+		skipKind = skipKindExtractor
+	} else if tooLarge() {
+		doc.SkipReason = fmt.Sprintf("extracted text size %d larger than limit %d", len(doc.Content), b.opts.SizeMax)
+		skipKind = skipKindSize
+	} else if reason := b.sniffer.sniff(doc.Name, doc.Content); reason != "" {
+		doc.SkipReason = reason
+		skipKind = skipKindBinary
+	} else if err := b.docChecker.checkText(doc.Content, b.opts.TrigramMax, allowLargeFile); err != nil {
 		doc.SkipReason = err.Error()
+		skipKind = skipKindText
+	}
+
+	if b.filter != nil && doc.SkipReason == "" {
+		b.filter(&doc)
+		if doc.SkipReason != "" {
+			skipKind = skipKindFilter
+		}
+	}
+	b.report.addDocument(&doc, skipKind)
+
+	if b.rankingSignals != nil && doc.RankingSignal == 0 {
+		doc.RankingSignal = b.rankingSignals.signal(doc.Name, doc.Content)
+	}
+	if b.documentLabels != nil {
+		b.documentLabels.addLabels(&doc)
 	}
 
 	b.todo = append(b.todo, &doc)
@@ -642,6 +1131,65 @@ func (b *Builder) Finish() error {
 		return b.buildError
 	}
 
+	err := b.finish()
+	if b.opts.ReportFile != "" {
+		if werr := WriteBuildReport(b.opts.ReportFile, b.report.finish(err)); werr != nil {
+			log.Printf("writing build report %s: %v", b.opts.ReportFile, werr)
+		}
+	}
+	return err
+}
+
+// Abort stops the build without publishing anything: the shards built so
+// far are removed, and the shards of previous runs stay as they are. Abort
+// after Finish does nothing.
+func (b *Builder) Abort() {
+	if b.finishCalled {
+		return
+	}
+	b.finishCalled = true
+	b.todo = nil
+	b.building.Wait()
+
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+	for tmp := range b.finishedShards {
+		os.Remove(tmp)
+	}
+	b.finishedShards = map[string]string{}
+	if b.buildError == nil {
+		b.buildError = errors.New("build aborted")
+	}
+}
+
+// checkShardBytesLimit returns an error if the new shards, and the old ones
+// a delta build keeps, are larger than Options.ShardBytesLimit.
+func (b *Builder) checkShardBytesLimit(oldShards []string) error {
+	if b.opts.ShardBytesLimit <= 0 {
+		return nil
+	}
+	var paths []string
+	for tmp := range b.finishedShards {
+		paths = append(paths, tmp)
+	}
+	if b.opts.IsDelta {
+		paths = append(paths, oldShards...)
+	}
+	var total int64
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		total += fi.Size()
+	}
+	if total > b.opts.ShardBytesLimit {
+		return fmt.Errorf("shards of %d bytes exceed the limit of %d bytes", total, b.opts.ShardBytesLimit)
+	}
+	return nil
+}
+
+func (b *Builder) finish() error {
 	b.finishCalled = true
 
 	b.flush()
@@ -664,6 +1212,15 @@ func (b *Builder) Finish() error {
 
 	oldShards := b.opts.FindAllShards()
 
+	if err := b.checkShardBytesLimit(oldShards); err != nil {
+		for tmp := range b.finishedShards {
+			os.Remove(tmp)
+		}
+		b.finishedShards = map[string]string{}
+		b.buildError = err
+		return err
+	}
+
 	if b.opts.IsDelta {
 		// Delta shard builds need to update FileTombstone and branch commit information for all
 		// existing shards
@@ -749,9 +1306,22 @@ func (b *Builder) Finish() error {
 				toDelete[p] = struct{}{}
 			}
 		}
+		if _, err := os.Stat(b.opts.manifestName()); err == nil && !b.opts.ContentAddressedShards {
+			toDelete[b.opts.manifestName()] = struct{}{}
+		}
 	}
 
 	for tmp, final := range artifactPaths {
+		if b.opts.ContentAddressedShards && IsContentAddressedShard(final) {
+			if _, err := os.Stat(final); err == nil {
+				// The shard didn't change. Keep the file readers may have
+				// loaded.
+				os.Remove(tmp)
+				delete(toDelete, final)
+				continue
+			}
+		}
+
 		if err := os.Rename(tmp, final); err != nil {
 			b.buildError = err
 			continue
@@ -762,6 +1332,18 @@ func (b *Builder) Finish() error {
 
 	b.finishedShards = map[string]string{}
 
+	if b.opts.ContentAddressedShards {
+		// Publish the new shards. The previous ones are searched until the
+		// manifest is replaced, so they are only deleted afterwards.
+		if b.buildError != nil {
+			return b.buildError
+		}
+		if err := b.writeManifest(oldShards, artifactPaths); err != nil {
+			b.buildError = err
+			return b.buildError
+		}
+	}
+
 	for p := range toDelete {
 		// Don't delete compound shards, set tombstones instead.
 		if b.opts.ShardMerging && strings.HasPrefix(filepath.Base(p), "compound-") {
@@ -778,9 +1360,31 @@ func (b *Builder) Finish() error {
 		}
 	}
 
+	if b.buildError == nil && b.opts.RepoHistory {
+		b.recordRepoHistory()
+	}
+
 	return b.buildError
 }
 
+// writeManifest replaces the manifest of the repository with the new shards
+// in artifactPaths, and for delta builds the old shards they add to.
+func (b *Builder) writeManifest(oldShards []string, artifactPaths map[string]string) error {
+	var names []string
+	if b.opts.IsDelta {
+		for _, p := range oldShards {
+			names = append(names, filepath.Base(p))
+		}
+	}
+	for _, final := range artifactPaths {
+		if IsContentAddressedShard(final) {
+			names = append(names, filepath.Base(final))
+		}
+	}
+	slices.Sort(names)
+	return writeShardManifest(b.opts.manifestName(), &ShardManifest{Shards: slices.Compact(names)})
+}
+
 // BranchNamesEqual compares the given zoekt.RepositoryBranch slices, and returns true
 // iff both slices specify the same set of branch names in the same order.
 func BranchNamesEqual(a, b []zoekt.RepositoryBranch) bool {
@@ -958,8 +1562,12 @@ func (b *Builder) buildShard(todo []*Document, nextShardNum int) (*finishedShard
 		}
 		if err != nil {
 			log.Printf("ignoring universal:%s or scip:%s error: %v", b.opts.CTagsPath, b.opts.ScipCTagsPath, err)
+			b.report.addCTagsFailure(err)
 		}
 	}
+	if b.symbolDocStyles != nil {
+		addSymbolDocs(todo, b.symbolDocStyles)
+	}
 
 	name := b.opts.shardName(nextShardNum)
 
@@ -967,6 +1575,7 @@ func (b *Builder) buildShard(todo []*Document, nextShardNum int) (*finishedShard
 	if err != nil {
 		return nil, err
 	}
+	defer shardBuilder.removeSpills()
 
 	sortDocuments(todo)
 
@@ -1019,6 +1628,7 @@ func (b *Builder) newShardBuilder() (*ShardBuilder, error) {
 	desc.HasSymbols = !b.opts.DisableCTags && b.opts.CTagsPath != ""
 	desc.SubRepoMap = b.opts.SubRepositories
 	desc.IndexOptions = b.opts.GetHash()
+	desc.IndexTime = b.indexTime
 
 	shardBuilder, err := NewShardBuilder(&desc)
 	if err != nil {
@@ -1026,6 +1636,34 @@ func (b *Builder) newShardBuilder() (*ShardBuilder, error) {
 	}
 	shardBuilder.IndexTime = b.indexTime
 	shardBuilder.ID = b.id
+	shardBuilder.skipBinaryCheck()
+	if b.opts.FoldedNgrams {
+		shardBuilder.enableFoldedNgrams()
+	}
+	if b.opts.SymbolNgrams {
+		shardBuilder.enableSymbolNgrams()
+	}
+	if b.opts.SymbolHashes {
+		shardBuilder.enableSymbolHashes()
+	}
+	if b.opts.FuzzySymbols {
+		shardBuilder.enableFuzzySymbols()
+	}
+	if b.opts.CJKBigrams {
+		shardBuilder.enableCJKBigrams()
+	}
+	if b.opts.EncryptContents {
+		shardBuilder.enableContentEncryption(getContentKeys())
+	}
+	if b.opts.MaxTrigramFrequency > 0 {
+		shardBuilder.enableStopNgrams(uint32(b.opts.MaxTrigramFrequency))
+	}
+	if b.opts.MaxPostingsMemory > 0 {
+		if err := os.MkdirAll(b.opts.IndexDir, 0o700); err != nil {
+			return nil, err
+		}
+		shardBuilder.enablePostingsSpill(b.opts.IndexDir, b.opts.MaxPostingsMemory)
+	}
 	return shardBuilder, nil
 }
 
@@ -1046,7 +1684,13 @@ func (b *Builder) writeShard(fn string, ib *ShardBuilder) (*finishedShard, error
 	}
 
 	defer f.Close()
-	if err := ib.Write(f); err != nil {
+	if b.opts.ContentAddressedShards {
+		hash, err := ib.writeHashed(f)
+		if err != nil {
+			return nil, err
+		}
+		fn = ContentAddressedShardName(dir, cmp.Or(b.opts.ShardPrefix, b.opts.RepositoryDescription.Name), IndexFormatVersion, hash)
+	} else if err := ib.Write(f); err != nil {
 		return nil, err
 	}
 	fi, err := f.Stat()
@@ -1062,6 +1706,7 @@ func (b *Builder) writeShard(fn string, ib *ShardBuilder) (*finishedShard, error
 		fi.Size(),
 		float64(fi.Size())/float64(ib.ContentSize()+1),
 		ib.NumFiles())
+	b.report.addShard(fn, fi.Size())
 
 	return &finishedShard{f.Name(), fn}, nil
 }
@@ -1099,8 +1744,24 @@ type Document struct {
 	// Document sections for symbols. Offsets should use bytes.
 	Symbols         []DocumentSection
 	SymbolsMetaData []*zoekt.Symbol
+
+	// RankingSignal is a score in [0, 1] of the document computed offline,
+	// eg. its centrality in the import graph of the repository. It is added
+	// to the score of matches if SearchOptions.RankingSignalsWeight is set.
+	// The Builder sets it from Options.RankingSignals unless it is non-zero.
+	RankingSignal float64
+
+	// Labels are key/value labels of the document, eg. its service or owning
+	// team, which meta.key:value queries match and FileMatch.Labels returns.
+	// The Builder adds those of Options.DocumentLabels for the keys it
+	// doesn't have.
+	Labels map[string]string
 }
 
+// CommitLanguage is the language of the documents holding commit messages.
+// They are only matched by queries with type:commit.
+const CommitLanguage = "Commit Message"
+
 type DocumentSection struct {
 	Start, End uint32
 }
@@ -1,14 +1,17 @@
 package index
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -133,6 +136,93 @@ func TestFlags(t *testing.T) {
 		want: Options{
 			LargeFiles: []string{"*.md", "\\!*.yaml"},
 		},
+	}, {
+		args: []string{"-redact_secrets", "line"},
+		want: Options{
+			RedactSecrets: "line",
+		},
+	}, {
+		args: []string{"-folded_ngrams"},
+		want: Options{
+			FoldedNgrams: true,
+		},
+	}, {
+		args: []string{"-symbol_ngrams"},
+		want: Options{
+			SymbolNgrams: true,
+		},
+	}, {
+		args: []string{"-symbol_hashes"},
+		want: Options{
+			SymbolHashes: true,
+		},
+	}, {
+		args: []string{"-fuzzy_symbols"},
+		want: Options{
+			FuzzySymbols: true,
+		},
+	}, {
+		args: []string{"-encrypt_contents"},
+		want: Options{
+			EncryptContents: true,
+		},
+	}, {
+		args: []string{"-max_trigram_frequency", "100000"},
+		want: Options{
+			MaxTrigramFrequency: 100000,
+		},
+	}, {
+		args: []string{"-max_postings_memory", "1048576"},
+		want: Options{
+			MaxPostingsMemory: 1 << 20,
+		},
+	}, {
+		args: []string{"-document_labels", "/labels.json"},
+		want: Options{
+			DocumentLabels: "/labels.json",
+		},
+	}, {
+		args: []string{"-max_nul_bytes", "4", "-sniff_mime", "-text_extensions", ".dat, .bin", "-text_extensions", ".raw"},
+		want: Options{
+			MaxNULBytes:    4,
+			SniffMIME:      true,
+			TextExtensions: []string{".dat", ".bin", ".raw"},
+		},
+	}, {
+		args: []string{"-repo_history"},
+		want: Options{
+			RepoHistory: true,
+		},
+	}, {
+		args: []string{"-content_addressed_shards"},
+		want: Options{
+			ContentAddressedShards: true,
+		},
+	}, {
+		args: []string{"-shard_bytes_limit", "1073741824"},
+		want: Options{
+			ShardBytesLimit: 1 << 30,
+		},
+	}, {
+		args: []string{"-content_extractor", "**/*.pdf=pdftotext - -", "-content_extractor", "*.docx=docx2txt"},
+		want: Options{
+			ContentExtractors: []string{"**/*.pdf=pdftotext - -", "*.docx=docx2txt"},
+		},
+	}, {
+		args: []string{"-filter_command", "policy --strict"},
+		want: Options{
+			FilterCommand: "policy --strict",
+		},
+	}, {
+		args: []string{"-report_file", "/tmp/report.json"},
+		want: Options{
+			ReportFile: "/tmp/report.json",
+		},
+	}, {
+		args: []string{"-symbol_docs", "go,python"},
+		want: Options{
+			SymbolDocs: "go,python",
+		},
 	}}
 
 	ignored := []cmp.Option{
@@ -291,6 +381,45 @@ func TestPartialSuccess(t *testing.T) {
 	}
 }
 
+func TestShardBytesLimit(t *testing.T) {
+	build := func(dir string, limit int64) error {
+		opts := Options{
+			IndexDir:        dir,
+			ShardBytesLimit: limit,
+		}
+		opts.RepositoryDescription.Name = "repo"
+		opts.SetDefaults()
+		b, err := NewBuilder(opts)
+		if err != nil {
+			t.Fatalf("NewBuilder: %v", err)
+		}
+		if err := b.AddFile("F", []byte(strings.Repeat("01234567\n", 128))); err != nil {
+			t.Fatal(err)
+		}
+		return b.Finish()
+	}
+
+	// The shards of the previous run stay if the new ones are too large.
+	dir := t.TempDir()
+	if err := build(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	before, err := filepath.Glob(dir + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build(dir, 100); err == nil || !strings.Contains(err.Error(), "exceed the limit of 100 bytes") {
+		t.Errorf("got %v, want an error for shards above the limit", err)
+	}
+	if after, _ := filepath.Glob(dir + "/*"); !slices.Equal(before, after) {
+		t.Errorf("got files %v after a build above the limit, want %v", after, before)
+	}
+
+	if err := build(dir, 1<<20); err != nil {
+		t.Errorf("got %v for shards below the limit", err)
+	}
+}
+
 // Tests that we skip looping over repos in compound shards when we know that
 // the repository we are looking for is not in the shard.
 func TestSkipCompoundShards(t *testing.T) {
@@ -505,6 +634,73 @@ func TestOptions_FindAllShards(t *testing.T) {
 	}
 }
 
+func TestContentAddressedShards(t *testing.T) {
+	dir := t.TempDir()
+	repo := zoekt.Repository{Name: "repo", ID: 1}
+	contentAddressed := func(o *Options) { o.ContentAddressedShards = true }
+
+	shards := createTestShard(t, dir, repo, 2, contentAddressed)
+	if len(shards) != 2 {
+		t.Fatalf("got shards %v, want 2", shards)
+	}
+	for _, s := range shards {
+		if !IsContentAddressedShard(s) {
+			t.Errorf("%s is not content-addressed", s)
+		}
+	}
+	before, err := os.Stat(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Builds of the same documents differ in their index time, but not in
+	// their shard names.
+	other := createTestShard(t, t.TempDir(), repo, 2, contentAddressed)
+	for i := range shards {
+		if filepath.Base(shards[i]) != filepath.Base(other[i]) {
+			t.Errorf("shard %d is named %s in another build, want %s", i, filepath.Base(other[i]), filepath.Base(shards[i]))
+		}
+	}
+
+	o := Options{IndexDir: dir, RepositoryDescription: repo, ContentAddressedShards: true}
+	o.SetDefaults()
+	if state, fn := o.IndexState(); state != IndexStateEqual {
+		t.Errorf("got index state %s for %s, want %s", state, fn, IndexStateEqual)
+	}
+
+	// A rebuild with another document keeps the files of the unchanged
+	// shards, and only lists the new shards.
+	grown := createTestShard(t, dir, repo, 3, contentAddressed)
+	if len(grown) != 3 {
+		t.Fatalf("got shards %v, want 3", grown)
+	}
+	after, err := os.Stat(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Errorf("unchanged shard %s was replaced", shards[0])
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(slices.Clone(grown), o.manifestName()); !cmp.Equal(files, want, cmpopts.SortSlices(func(a, b string) bool { return a < b })) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+
+	// Without content addressing, the shards and the manifest are replaced
+	// by numbered shards.
+	numbered := createTestShard(t, dir, repo, 1)
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(files, numbered) {
+		t.Errorf("got files %v, want %v", files, numbered)
+	}
+}
+
 func TestBuilder_BranchNamesEqual(t *testing.T) {
 	for i, test := range []struct {
 		oldBranches []zoekt.RepositoryBranch
@@ -751,7 +947,7 @@ func TestBuilder_DeltaShardsMetadataInOlderShards(t *testing.T) {
 
 				diffOptions := []cmp.Option{
 					cmpopts.IgnoreUnexported(zoekt.Repository{}),
-					cmpopts.IgnoreFields(zoekt.Repository{}, "IndexOptions"),
+					cmpopts.IgnoreFields(zoekt.Repository{}, "IndexOptions", "IndexTime"),
 					cmpopts.EquateEmpty(),
 				}
 
@@ -858,7 +1054,7 @@ func TestFindRepositoryMetadata(t *testing.T) {
 			// check outcome
 			compareOptions := []cmp.Option{
 				cmpopts.IgnoreUnexported(zoekt.Repository{}),
-				cmpopts.IgnoreFields(zoekt.Repository{}, "IndexOptions"),
+				cmpopts.IgnoreFields(zoekt.Repository{}, "IndexOptions", "IndexTime"),
 				cmpopts.EquateEmpty(),
 			}
 
@@ -1209,3 +1405,122 @@ func TestFileRank(t *testing.T) {
 		})
 	}
 }
+
+func TestContentExtractors(t *testing.T) {
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("tr not found")
+	}
+
+	b, err := NewBuilder(Options{
+		IndexDir:              t.TempDir(),
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		ContentExtractors: []string{
+			// Turns the NUL bytes separating the pages into line breaks.
+			`**/*.pdf=tr \000 \n`,
+			`*.bad=false`,
+		},
+		SizeMax: 32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []Document{
+		{Name: "doc/a.pdf", Content: []byte("page one\x00page two")},
+		{Name: "b.bad", Content: []byte("text")},
+		{Name: "c.txt", Content: []byte("binary\x00")},
+		{Name: "d.bad", Content: bytes.Repeat([]byte("x"), 64)},
+	}
+	for _, d := range docs {
+		if err := b.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := b.todo[len(b.todo)-len(docs):]
+	if string(got[0].Content) != "page one\npage two" || got[0].SkipReason != "" {
+		t.Errorf("got %q (skipped: %q) for the PDF, want the pages on separate lines", got[0].Content, got[0].SkipReason)
+	}
+	if !strings.Contains(got[1].SkipReason, "content extractor false failed") {
+		t.Errorf("got skip reason %q for a failed extractor", got[1].SkipReason)
+	}
+	if got[2].SkipReason == "" {
+		t.Error("binary document without extractor is not skipped")
+	}
+	if !strings.HasPrefix(got[3].SkipReason, "document size") {
+		t.Errorf("got skip reason %q for a document above the size limit, want it skipped before extraction", got[3].SkipReason)
+	}
+
+	if _, err := NewBuilder(Options{
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		ContentExtractors:     []string{"*.pdf"},
+	}); err == nil {
+		t.Error("got no error for an extractor without command")
+	}
+}
+
+func TestFilterCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	policy := filepath.Join(t.TempDir(), "policy.sh")
+	script := `#!/bin/sh
+case "$ZOEKT_REPOSITORY_NAME/$ZOEKT_DOCUMENT_NAME" in
+repo/internal/*) echo "skip internal code" ;;
+repo/*.env) echo transform; sed 's/=.*/=REDACTED/' ;;
+repo/broken) echo "maybe" ;;
+repo/crash) echo "no policy" >&2; exit 1 ;;
+*) echo index ;;
+esac
+`
+	if err := os.WriteFile(policy, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBuilder(Options{
+		IndexDir:              t.TempDir(),
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		FilterCommand:         policy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []Document{
+		{Name: "main.go", Content: []byte("package main\n")},
+		{Name: "internal/secret.go", Content: []byte("package secret\n")},
+		{Name: "prod.env", Content: []byte("PASSWORD=hunter2\n")},
+		{Name: "broken", Content: []byte("text\n")},
+		{Name: "crash", Content: []byte("text\n")},
+	}
+	for _, d := range docs {
+		if err := b.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := b.todo[len(b.todo)-len(docs):]
+	if string(got[0].Content) != "package main\n" || got[0].SkipReason != "" {
+		t.Errorf("got %q (skipped: %q) for an indexed document", got[0].Content, got[0].SkipReason)
+	}
+	if got[1].SkipReason != "internal code" {
+		t.Errorf("got skip reason %q, want the reason of the filter command", got[1].SkipReason)
+	}
+	if string(got[2].Content) != "PASSWORD=REDACTED\n" || got[2].SkipReason != "" {
+		t.Errorf("got %q (skipped: %q) for a transformed document", got[2].Content, got[2].SkipReason)
+	}
+	if !strings.Contains(got[3].SkipReason, "unknown decision") {
+		t.Errorf("got skip reason %q for an unknown decision", got[3].SkipReason)
+	}
+	if !strings.Contains(got[4].SkipReason, "no policy") {
+		t.Errorf("got skip reason %q for a failed filter command", got[4].SkipReason)
+	}
+
+	if _, err := NewBuilder(Options{
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		FilterCommand:         " ",
+	}); err == nil {
+		t.Error("got no error for an empty filter command")
+	}
+}
@@ -0,0 +1,180 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// Chinese and Japanese words are often two characters long, and so are many
+// Korean words, but patterns shorter than a trigram can't be looked up in the
+// ngram index: the documents are scanned for them. Shards built with
+// Options.CJKBigrams store the documents containing each pair of adjacent
+// CJK characters, so searches for such patterns only scan the documents which
+// contain all of their bigrams.
+
+// cjkBigramEntrySize is the size of an entry of the CJK bigram index: the
+// 64-bit bigram followed by the 32-bit end offset of its documents.
+const cjkBigramEntrySize = 12
+
+// isCJK returns true for Chinese, Japanese and Korean characters.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// cjkBigram is a pair of adjacent CJK characters, encoded like an ngram.
+type cjkBigram uint64
+
+func newCJKBigram(r1, r2 rune) cjkBigram {
+	return cjkBigram(uint64(r1)<<21 | uint64(r2))
+}
+
+// cjkBigrams calls f with each pair of adjacent CJK characters of b.
+func cjkBigrams(b []byte, f func(cjkBigram)) {
+	var prev rune = -1
+	for len(b) > 0 {
+		r, sz := utf8.DecodeRune(b)
+		b = b[sz:]
+		if !isCJK(r) {
+			prev = -1
+			continue
+		}
+		if prev >= 0 {
+			f(newCJKBigram(prev, r))
+		}
+		prev = r
+	}
+}
+
+// cjkBigramsBuilder collects the documents containing each CJK bigram.
+type cjkBigramsBuilder map[cjkBigram]*roaring.Bitmap
+
+// add adds the bigrams of b to the document docID.
+func (c cjkBigramsBuilder) add(docID uint32, b []byte) {
+	cjkBigrams(b, func(bg cjkBigram) {
+		bm, ok := c[bg]
+		if !ok {
+			bm = roaring.New()
+			c[bg] = bm
+		}
+		bm.Add(docID)
+	})
+}
+
+// encodeCJKBigrams returns the CJK bigram index of c: the number of bigrams,
+// the entries of the bigrams sorted so that lookups are binary searches, and
+// then the documents of each bigram as a roaring bitmap.
+func encodeCJKBigrams(c cjkBigramsBuilder) ([]byte, error) {
+	bigrams := make([]cjkBigram, 0, len(c))
+	for bg := range c {
+		bigrams = append(bigrams, bg)
+	}
+	sort.Slice(bigrams, func(i, j int) bool { return bigrams[i] < bigrams[j] })
+
+	var docs []byte
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(bigrams)))
+	for _, bg := range bigrams {
+		bm := c[bg]
+		bm.RunOptimize()
+		b, err := bm.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, b...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(bg))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(docs)))
+	}
+	return append(buf, docs...), nil
+}
+
+// cjkBigramIndex is the encoded CJK bigram index of a shard.
+type cjkBigramIndex struct {
+	entries []byte
+	docs    []byte
+}
+
+func decodeCJKBigrams(blob []byte) (*cjkBigramIndex, error) {
+	if len(blob) < 4 {
+		return nil, fmt.Errorf("cjkBigrams section has invalid size %d", len(blob))
+	}
+	n := int(binary.BigEndian.Uint32(blob))
+	if len(blob) < 4+n*cjkBigramEntrySize {
+		return nil, fmt.Errorf("cjkBigrams section has %d bigrams, but only %d bytes", n, len(blob))
+	}
+	idx := &cjkBigramIndex{
+		entries: blob[4 : 4+n*cjkBigramEntrySize],
+		docs:    blob[4+n*cjkBigramEntrySize:],
+	}
+	if n > 0 && int(idx.end(n-1)) != len(idx.docs) {
+		return nil, fmt.Errorf("cjkBigrams section has %d bytes of documents, want %d", len(idx.docs), idx.end(n-1))
+	}
+	return idx, nil
+}
+
+func (c *cjkBigramIndex) bigram(i int) cjkBigram {
+	return cjkBigram(binary.BigEndian.Uint64(c.entries[i*cjkBigramEntrySize:]))
+}
+
+func (c *cjkBigramIndex) end(i int) uint32 {
+	return binary.BigEndian.Uint32(c.entries[i*cjkBigramEntrySize+8:])
+}
+
+// lookup returns the documents containing bg, or nil if there are none.
+func (c *cjkBigramIndex) lookup(bg cjkBigram) (*roaring.Bitmap, error) {
+	n := len(c.entries) / cjkBigramEntrySize
+	i := sort.Search(n, func(i int) bool { return c.bigram(i) >= bg })
+	if i == n || c.bigram(i) != bg {
+		return nil, nil
+	}
+	var start uint32
+	if i > 0 {
+		start = c.end(i - 1)
+	}
+	bm := roaring.New()
+	if err := bm.UnmarshalBinary(c.docs[start:c.end(i)]); err != nil {
+		return nil, err
+	}
+	return bm, nil
+}
+
+// cjkBigramDocs returns the documents which contain all the CJK bigrams of
+// pattern in their content or file name. It returns false if the shard has
+// no CJK bigram index or pattern has no CJK bigrams.
+func (d *indexData) cjkBigramDocs(pattern string) (*roaring.Bitmap, bool, error) {
+	if d.cjkBigrams == nil {
+		return nil, false, nil
+	}
+
+	var (
+		docs *roaring.Bitmap
+		err  error
+	)
+	cjkBigrams([]byte(pattern), func(bg cjkBigram) {
+		if err != nil || (docs != nil && docs.IsEmpty()) {
+			return
+		}
+		var bm *roaring.Bitmap
+		if bm, err = d.cjkBigrams.lookup(bg); err != nil {
+			return
+		}
+		if bm == nil {
+			bm = roaring.New()
+		}
+		if docs == nil {
+			docs = bm
+		} else {
+			docs.And(bm)
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if docs == nil {
+		return nil, false, nil
+	}
+	return docs, true, nil
+}
@@ -0,0 +1,130 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestCJKBigrams(t *testing.T) {
+	docs := []Document{
+		{Name: "zh.go", Content: []byte("// 中文搜索\nfunc f() {}\n")},
+		{Name: "ja.txt", Content: []byte("日本語のテキスト\n")},
+		{Name: "ko.md", Content: []byte("한국어 검색\n")},
+		{Name: "名前.txt", Content: []byte("hello\n")},
+		{Name: "other.go", Content: []byte("package other // 中 文\n")},
+	}
+	repo := &zoekt.Repository{Name: "repo"}
+
+	plain := testShardBuilder(t, repo, docs...)
+	bigrams, err := NewShardBuilder(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigrams.enableCJKBigrams()
+	for _, d := range docs {
+		if err := bigrams.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := searcherForTest(t, bigrams).(*indexData)
+	if d.cjkBigrams == nil {
+		t.Fatal("shard has no CJK bigram index")
+	}
+	merged, err := merge(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.cjkBigrams == nil {
+		t.Fatal("merged shard has no CJK bigram index")
+	}
+
+	search := func(s zoekt.Searcher, q query.Q) ([]zoekt.FileMatch, zoekt.Stats) {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res.Files, res.Stats
+	}
+
+	plainSearcher := searcherForTest(t, plain)
+	mergedSearcher := searcherForTest(t, merged)
+	for _, tc := range []struct {
+		q         query.Q
+		matches   int
+		bigramHit bool
+	}{
+		{q: &query.Substring{Pattern: "中文", Content: true}, matches: 1, bigramHit: true},
+		{q: &query.Substring{Pattern: "搜索"}, matches: 1, bigramHit: true},
+		{q: &query.Substring{Pattern: "日本"}, matches: 1, bigramHit: true},
+		{q: &query.Substring{Pattern: "검색"}, matches: 1, bigramHit: true},
+		{q: &query.Substring{Pattern: "名前", FileName: true}, matches: 1, bigramHit: true},
+		{q: &query.Substring{Pattern: "文字"}, matches: 0, bigramHit: true},
+		// Without bigrams, the documents are scanned.
+		{q: &query.Substring{Pattern: "文"}, matches: 2},
+		{q: &query.Substring{Pattern: "語の", Content: true}, matches: 1, bigramHit: true},
+		{q: query.NewAnd(&query.Substring{Pattern: "中文"}, &query.Substring{Pattern: "f"}), matches: 1, bigramHit: true},
+		{q: &query.Not{Child: &query.Substring{Pattern: "中文"}}, matches: 4},
+	} {
+		want, plainStats := search(plainSearcher, tc.q)
+		if len(want) != tc.matches {
+			t.Fatalf("%s: got %d matches without bigrams, want %d", tc.q, len(want), tc.matches)
+		}
+		if tc.bigramHit && plainStats.FilesConsidered <= 1 {
+			t.Fatalf("%s: considered %d files without bigrams, want more", tc.q, plainStats.FilesConsidered)
+		}
+		for name, s := range map[string]zoekt.Searcher{"bigrams": d, "merged": mergedSearcher} {
+			got, stats := search(s, tc.q)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s: %s: mismatch (-want +got):\n%s", name, tc.q, diff)
+			}
+			if tc.bigramHit && stats.FilesConsidered > 1 {
+				t.Errorf("%s: %s: considered %d files, want at most 1", name, tc.q, stats.FilesConsidered)
+			}
+		}
+	}
+}
+
+func TestCJKBigramIndex(t *testing.T) {
+	c := cjkBigramsBuilder{}
+	c.add(0, []byte("中文abc中文"))
+	c.add(1, []byte("文中"))
+	c.add(3, []byte("x中文y"))
+
+	blob, err := encodeCJKBigrams(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := decodeCJKBigrams(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bg, want := range map[cjkBigram][]uint32{
+		newCJKBigram('中', '文'): {0, 3},
+		newCJKBigram('文', '中'): {1},
+		newCJKBigram('文', '字'): nil,
+	} {
+		bm, err := idx.lookup(bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []uint32
+		if bm != nil {
+			got = bm.ToArray()
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%x: mismatch (-want +got):\n%s", bg, diff)
+		}
+	}
+
+	if _, err := decodeCJKBigrams(blob[:len(blob)-1]); err == nil {
+		t.Error("truncated index decoded without error")
+	}
+}
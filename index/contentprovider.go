@@ -23,8 +23,11 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/go-enry/go-enry/v2"
+
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/ctags"
+	"github.com/sourcegraph/zoekt/internal/experiments"
 )
 
 var _ = log.Println
@@ -43,6 +46,8 @@ type contentProvider struct {
 	_nlBuf   []uint32
 	_sects   []DocumentSection
 	_sectBuf []DocumentSection
+	_ll      []longLineCheckpoint
+	_llRead  bool
 	fileSize uint32
 }
 
@@ -51,11 +56,13 @@ func (p *contentProvider) setDocument(docID uint32) {
 	fileStart := p.id.boundaries[docID]
 
 	p.idx = docID
-	p.fileSize = p.id.boundaries[docID+1] - fileStart
+	p.fileSize = uint32(p.id.boundaries[docID+1] - fileStart)
 
 	p._nl = nil
 	p._sects = nil
 	p._data = nil
+	p._ll = nil
+	p._llRead = false
 }
 
 func (p *contentProvider) docSections() []DocumentSection {
@@ -78,6 +85,15 @@ func (p *contentProvider) newlines() newlines {
 	return newlines{locs: p._nl, fileSize: p.fileSize}
 }
 
+// longLines returns the checkpoints of the long lines of the document.
+func (p *contentProvider) longLines() []longLineCheckpoint {
+	if !p._llRead {
+		p._ll, p.err = p.id.readLongLines(p.idx)
+		p._llRead = true
+	}
+	return p._ll
+}
+
 func (p *contentProvider) data(fileName bool) []byte {
 	if fileName {
 		return p.id.fileNameContent[p.id.fileNameIndex[p.idx]:p.id.fileNameIndex[p.idx+1]]
@@ -119,6 +135,19 @@ func (p *contentProvider) findOffset(filename bool, r uint32) uint32 {
 
 	if filename {
 		data = p.id.fileNameContent[byteOff:]
+	} else if p.id.contentCiphers != nil || p.id.contentSources != nil {
+		// The stored contents of such shards are read a whole document at
+		// a time, so slice the contents of the document, which are read
+		// once per search, rather than reading them again for every match.
+		// A checkpoint before the document is replaced by its start.
+		if byteOff < fileStartByte {
+			byteOff, left = fileStartByte, r
+		}
+		data = p.data(false)
+		if p.err != nil {
+			return 0
+		}
+		data = data[byteOff-fileStartByte:]
 	} else {
 		data, p.err = p.id.readContentSlice(byteOff, 3*runeOffsetFrequency)
 		if p.err != nil {
@@ -127,13 +156,12 @@ func (p *contentProvider) findOffset(filename bool, r uint32) uint32 {
 	}
 	for left > 0 {
 		_, sz := utf8.DecodeRune(data)
-		byteOff += uint32(sz)
+		byteOff += uint64(sz)
 		data = data[sz:]
 		left--
 	}
 
-	byteOff -= fileStartByte
-	return byteOff
+	return uint32(byteOff - fileStartByte)
 }
 
 // fillMatches converts the internal candidateMatch slice into our API's LineMatch.
@@ -171,12 +199,16 @@ func (p *contentProvider) fillMatches(ms []*candidateMatch, numContextLines int,
 		DebugScore: lineScore.debugScore,
 	}
 
+	columnHelper := newColumnHelper(res.Line, nil, opts)
 	for _, m := range ms {
-		res.LineFragments = append(res.LineFragments, zoekt.LineFragmentMatch{
+		fragment := zoekt.LineFragmentMatch{
 			LineOffset:  int(m.byteOffset),
 			MatchLength: int(m.byteMatchSz),
 			Offset:      m.byteOffset,
-		})
+		}
+		fragment.Column, fragment.DisplayColumn = columnHelper.columns(0, m.byteOffset)
+		fragment.EndColumn, fragment.EndDisplayColumn = columnHelper.columns(0, m.byteOffset+m.byteMatchSz)
+		res.LineFragments = append(res.LineFragments, fragment)
 	}
 
 	return []zoekt.LineMatch{res}
@@ -211,25 +243,18 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 	// Otherwise, we return a single chunk representing the filename index.
 	lineScore, _ := p.scoreLine(filenameMatches, language, -1 /* must pass -1 for filenames */, opts)
 	fileName := p.id.fileName(p.idx)
+	columnHelper := newColumnHelper(fileName, nil, opts)
 	ranges := make([]zoekt.Range, 0, len(ms))
 	for _, m := range ms {
 		ranges = append(ranges, zoekt.Range{
-			Start: zoekt.Location{
-				ByteOffset: m.byteOffset,
-				LineNumber: 1,
-				Column:     uint32(utf8.RuneCount(fileName[:m.byteOffset]) + 1),
-			},
-			End: zoekt.Location{
-				ByteOffset: m.byteOffset + m.byteMatchSz,
-				LineNumber: 1,
-				Column:     uint32(utf8.RuneCount(fileName[:m.byteOffset+m.byteMatchSz]) + 1),
-			},
+			Start: columnHelper.location(1, 0, m.byteOffset),
+			End:   columnHelper.location(1, 0, m.byteOffset+m.byteMatchSz),
 		})
 	}
 
 	return []zoekt.ChunkMatch{{
 		Content:      fileName,
-		ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+		ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
 		Ranges:       ranges,
 		FileName:     true,
 		Score:        lineScore.score,
@@ -239,6 +264,7 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 
 func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLines int, language string, opts *zoekt.SearchOptions) []zoekt.LineMatch {
 	var result []zoekt.LineMatch
+	columnHelper := newColumnHelper(p.data(false), p.longLines(), opts)
 	for len(ms) > 0 {
 		m := ms[0]
 		num := p.newlines().atOffset(m.byteOffset)
@@ -283,36 +309,53 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 			}
 		}
 
-		finalMatch := zoekt.LineMatch{
-			LineStart:  lineStart,
-			LineEnd:    nextLineStart,
-			LineNumber: num,
-		}
-		finalMatch.Line = data[lineStart:nextLineStart]
-
-		if numContextLines > 0 {
-			finalMatch.Before = p.newlines().getLines(data, num-numContextLines, num)
-			finalMatch.After = p.newlines().getLines(data, num+1, num+1+numContextLines)
+		// Long lines are returned as windows around the matches, with
+		// the fragments relative to the window.
+		groups := [][]*candidateMatch{lineCands}
+		if nextLineStart-lineStart > longLineLength {
+			groups = splitLongLineMatches(lineCands)
 		}
 
-		lineScore, symbolInfo := p.scoreLine(lineCands, language, num, opts)
-		finalMatch.Score = lineScore.score
-		finalMatch.DebugScore = lineScore.debugScore
+		for _, lineCands := range groups {
+			windowStart, windowEnd := lineStart, nextLineStart
+			if nextLineStart-lineStart > longLineLength {
+				last := lineCands[len(lineCands)-1]
+				windowStart, windowEnd = longLineWindow(data, lineStart, nextLineStart, int(lineCands[0].byteOffset), int(last.byteOffset+last.byteMatchSz))
+			}
 
-		for i, m := range lineCands {
-			fragment := zoekt.LineFragmentMatch{
-				Offset:      m.byteOffset,
-				LineOffset:  int(m.byteOffset) - lineStart,
-				MatchLength: int(m.byteMatchSz),
+			finalMatch := zoekt.LineMatch{
+				LineStart:  lineStart,
+				LineEnd:    nextLineStart,
+				LineNumber: num,
 			}
+			finalMatch.Line = data[windowStart:windowEnd]
 
-			if i < len(symbolInfo) && symbolInfo[i] != nil {
-				fragment.SymbolInfo = symbolInfo[i]
+			if numContextLines > 0 {
+				finalMatch.Before = p.newlines().getLines(data, num-numContextLines, num)
+				finalMatch.After = p.newlines().getLines(data, num+1, num+1+numContextLines)
 			}
 
-			finalMatch.LineFragments = append(finalMatch.LineFragments, fragment)
+			lineScore, symbolInfo := p.scoreLine(lineCands, language, num, opts)
+			finalMatch.Score = lineScore.score
+			finalMatch.DebugScore = lineScore.debugScore
+
+			for i, m := range lineCands {
+				fragment := zoekt.LineFragmentMatch{
+					Offset:      m.byteOffset,
+					LineOffset:  int(m.byteOffset) - windowStart,
+					MatchLength: int(m.byteMatchSz),
+				}
+				fragment.Column, fragment.DisplayColumn = columnHelper.columns(lineStart, m.byteOffset)
+				fragment.EndColumn, fragment.EndDisplayColumn = columnHelper.columns(lineStart, m.byteOffset+m.byteMatchSz)
+
+				if i < len(symbolInfo) && symbolInfo[i] != nil {
+					fragment.SymbolInfo = symbolInfo[i]
+				}
+
+				finalMatch.LineFragments = append(finalMatch.LineFragments, fragment)
+			}
+			result = append(result, finalMatch)
 		}
-		result = append(result, finalMatch)
 	}
 	return result
 }
@@ -325,16 +368,24 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 	// This invariant is true at the time of writing, but we conservatively
 	// enforce this. Note: chunkCandidates preserves the sorting so safe to
 	// transform now.
-	columnHelper := columnHelper{data: data}
+	columnHelper := newColumnHelper(data, p.longLines(), opts)
 	if !sort.IsSorted((sortByOffsetSlice)(ms)) {
 		log.Printf("WARN: performance invariant violated. candidate matches are not sorted in fillContentChunkMatches. Report to developers.")
 		sort.Sort((sortByOffsetSlice)(ms))
 	}
 
 	newlines := p.newlines()
-	chunks := chunkCandidates(ms, newlines, numContextLines)
+	chunks := chunkCandidates(ms, newlines, numContextLines, opts.HasExperiment(experiments.ChunkMergeAdjacent))
 	chunkMatches := make([]zoekt.ChunkMatch, 0, len(chunks))
 	for _, chunk := range chunks {
+		if chunk.firstLine == chunk.lastLine {
+			lineStart, lineEnd := newlines.lineStart(int(chunk.firstLine)), newlines.lineStart(int(chunk.firstLine)+1)
+			if lineEnd-lineStart > longLineLength {
+				chunkMatches = p.appendLongLineChunkMatches(chunkMatches, chunk, lineStart, lineEnd, &columnHelper, language, opts)
+				continue
+			}
+		}
+
 		ranges := make([]zoekt.Range, 0, len(chunk.candidates))
 		for _, cm := range chunk.candidates {
 			startOffset := cm.byteOffset
@@ -342,16 +393,8 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 			startLine, endLine := newlines.offsetRangeToLineRange(startOffset, endOffset)
 
 			ranges = append(ranges, zoekt.Range{
-				Start: zoekt.Location{
-					ByteOffset: startOffset,
-					LineNumber: uint32(startLine),
-					Column:     columnHelper.get(int(newlines.lineStart(startLine)), startOffset),
-				},
-				End: zoekt.Location{
-					ByteOffset: endOffset,
-					LineNumber: uint32(endLine),
-					Column:     columnHelper.get(int(newlines.lineStart(endLine)), endOffset),
-				},
+				Start: columnHelper.location(uint32(startLine), int(newlines.lineStart(startLine)), startOffset),
+				End:   columnHelper.location(uint32(endLine), int(newlines.lineStart(endLine)), endOffset),
 			})
 		}
 
@@ -365,9 +408,10 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 		chunkMatches = append(chunkMatches, zoekt.ChunkMatch{
 			Content: newlines.getLines(data, firstLineNumber, int(chunk.lastLine)+numContextLines+1),
 			ContentStart: zoekt.Location{
-				ByteOffset: firstLineStart,
-				LineNumber: uint32(firstLineNumber),
-				Column:     1,
+				ByteOffset:    firstLineStart,
+				LineNumber:    uint32(firstLineNumber),
+				Column:        1,
+				DisplayColumn: 1,
 			},
 			FileName:      false,
 			Ranges:        ranges,
@@ -380,6 +424,38 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 	return chunkMatches
 }
 
+// appendLongLineChunkMatches appends the chunk matches of a chunk on the long
+// line [lineStart, lineEnd). Instead of the line, every chunk match has a
+// window of it around its ranges as content, without context lines.
+func (p *contentProvider) appendLongLineChunkMatches(chunkMatches []zoekt.ChunkMatch, chunk candidateChunk, lineStart, lineEnd uint32, columnHelper *columnHelper, language string, opts *zoekt.SearchOptions) []zoekt.ChunkMatch {
+	data := p.data(false)
+	for _, cands := range splitLongLineMatches(chunk.candidates) {
+		last := cands[len(cands)-1]
+		windowStart, windowEnd := longLineWindow(data, int(lineStart), int(lineEnd), int(cands[0].byteOffset), int(last.byteOffset+last.byteMatchSz))
+
+		contentStart := columnHelper.location(chunk.firstLine, int(lineStart), uint32(windowStart))
+		ranges := make([]zoekt.Range, 0, len(cands))
+		for _, cm := range cands {
+			ranges = append(ranges, zoekt.Range{
+				Start: columnHelper.location(chunk.firstLine, int(lineStart), cm.byteOffset),
+				End:   columnHelper.location(chunk.firstLine, int(lineStart), cm.byteOffset+cm.byteMatchSz),
+			})
+		}
+
+		chunkScore, symbolInfo := p.scoreChunk(cands, language, opts)
+		chunkMatches = append(chunkMatches, zoekt.ChunkMatch{
+			Content:       data[windowStart:windowEnd],
+			ContentStart:  contentStart,
+			Ranges:        ranges,
+			SymbolInfo:    symbolInfo,
+			BestLineMatch: uint32(chunkScore.bestLine),
+			Score:         chunkScore.score,
+			DebugScore:    chunkScore.debugScore,
+		})
+	}
+	return chunkMatches
+}
+
 type candidateChunk struct {
 	candidates []*candidateMatch
 	firstLine  uint32 // 1-based, inclusive
@@ -390,19 +466,26 @@ type candidateChunk struct {
 
 // chunkCandidates groups a set of sorted, non-overlapping candidate matches by line number. Adjacent
 // chunks will be merged if adding `numContextLines` to the beginning and end of the chunk would cause
-// it to overlap with an adjacent chunk.
+// it to overlap with an adjacent chunk, or with mergeAdjacent, to touch it.
 //
 // input invariants: ms is sorted by byteOffset and is non overlapping with respect to endOffset.
 // output invariants: if you flatten candidates the input invariant is retained.
-func chunkCandidates(ms []*candidateMatch, newlines newlines, numContextLines int) []candidateChunk {
+func chunkCandidates(ms []*candidateMatch, newlines newlines, numContextLines int, mergeAdjacent bool) []candidateChunk {
 	var chunks []candidateChunk
 
+	// gap is the number of lines allowed between the context lines of
+	// merged chunks.
+	gap := 0
+	if mergeAdjacent {
+		gap = 1
+	}
+
 	for _, m := range ms {
 		startOffset := m.byteOffset
 		endOffset := m.byteOffset + m.byteMatchSz
 		firstLine, lastLine := newlines.offsetRangeToLineRange(startOffset, endOffset)
 
-		if len(chunks) > 0 && int(chunks[len(chunks)-1].lastLine)+numContextLines >= firstLine-numContextLines {
+		if len(chunks) > 0 && int(chunks[len(chunks)-1].lastLine)+numContextLines+gap >= firstLine-numContextLines {
 			// If a new chunk created with the current candidateMatch would
 			// overlap with the previous chunk, instead add the candidateMatch
 			// to the last chunk and extend end of the last chunk.
@@ -433,31 +516,103 @@ func chunkCandidates(ms []*candidateMatch, newlines newlines, numContextLines in
 type columnHelper struct {
 	data []byte
 
+	// checkpoints of the long lines in data, which save counting the runes
+	// from the start of the line.
+	checkpoints []longLineCheckpoint
+
+	// tabWidth is the distance of the tab stops of display columns. If it is
+	// 0, defaultTabWidth is used.
+	tabWidth uint32
+
 	// 0 values for all these are valid values
 	lastLineOffset int
 	lastOffset     uint32
 	lastRuneCount  uint32
+	lastDisplay    uint32
+}
+
+// defaultTabWidth is the tab width of display columns if
+// SearchOptions.TabWidth is unset.
+const defaultTabWidth = 8
+
+// newColumnHelper returns a columnHelper for the lines of data with the tab
+// width requested by opts.
+func newColumnHelper(data []byte, checkpoints []longLineCheckpoint, opts *zoekt.SearchOptions) columnHelper {
+	c := columnHelper{data: data, checkpoints: checkpoints}
+	if opts != nil && opts.TabWidth > 0 {
+		c.tabWidth = uint32(opts.TabWidth)
+	}
+	return c
 }
 
 // get returns the line column for offset. offset is the byte offset of the
 // rune in data. lineOffset is the byte offset inside of data for the line
 // containing offset.
 func (c *columnHelper) get(lineOffset int, offset uint32) uint32 {
-	var runeCount uint32
+	column, _ := c.columns(lineOffset, offset)
+	return column
+}
 
+// columns is like get, and also returns the display column of offset, at
+// which tabs advance to the next tab stop.
+func (c *columnHelper) columns(lineOffset int, offset uint32) (column, display uint32) {
+	tabWidth := c.tabWidth
+	if tabWidth == 0 {
+		tabWidth = defaultTabWidth
+	}
+
+	var runeCount uint32
 	if lineOffset == c.lastLineOffset && offset >= c.lastOffset {
 		// Can count from last calculation
 		runeCount = c.lastRuneCount + uint32(utf8.RuneCount(c.data[c.lastOffset:offset]))
+		display = advanceDisplayColumn(c.lastDisplay, c.data[c.lastOffset:offset], tabWidth)
+	} else if cp, ok := lastCheckpoint(c.checkpoints, uint32(lineOffset), offset); ok {
+		// Can count from the last checkpoint of a long line
+		runeCount = cp.runes + uint32(utf8.RuneCount(c.data[cp.offset:offset]))
+		if bytes.IndexByte(c.data[lineOffset:cp.offset], '\t') < 0 {
+			display = advanceDisplayColumn(cp.runes, c.data[cp.offset:offset], tabWidth)
+		} else {
+			display = advanceDisplayColumn(0, c.data[lineOffset:offset], tabWidth)
+		}
 	} else {
 		// Need to count from the beginning of line
 		runeCount = uint32(utf8.RuneCount(c.data[lineOffset:offset]))
+		display = advanceDisplayColumn(0, c.data[lineOffset:offset], tabWidth)
 	}
 
 	c.lastLineOffset = lineOffset
 	c.lastOffset = offset
 	c.lastRuneCount = runeCount
+	c.lastDisplay = display
 
-	return runeCount + 1
+	return runeCount + 1, display + 1
+}
+
+// location returns the location of offset on the line lineNumber, which
+// starts at lineOffset.
+func (c *columnHelper) location(lineNumber uint32, lineOffset int, offset uint32) zoekt.Location {
+	column, display := c.columns(lineOffset, offset)
+	return zoekt.Location{
+		ByteOffset:    offset,
+		LineNumber:    lineNumber,
+		Column:        column,
+		DisplayColumn: display,
+	}
+}
+
+// advanceDisplayColumn returns the 0-based display column after the runes of
+// b, displayed from the 0-based column col with tab stops every tabWidth
+// columns.
+func advanceDisplayColumn(col uint32, b []byte, tabWidth uint32) uint32 {
+	for {
+		i := bytes.IndexByte(b, '\t')
+		if i < 0 {
+			return col + uint32(utf8.RuneCount(b))
+		}
+		col += uint32(utf8.RuneCount(b[:i]))
+		col += tabWidth - col%tabWidth
+		b = b[i+1:]
+	}
 }
 
 type newlines struct {
@@ -528,15 +683,32 @@ const (
 	scoreKindMatch        = 100.0
 	scoreFactorAtomMatch  = 400.0
 
+	// Used for blending the precomputed ranking signal of a file, in [0, 1],
+	// into its score, see SearchOptions.RankingSignalsWeight.
+	scoreRankingSignalFactor = 500.0
+
 	// Used for ordering line and chunk matches within a file.
 	scoreLineOrderFactor = 1.0
 
+	// Used for selecting the lines and chunks of a file to display, see
+	// scoreSnippets. Together they stay below scorePartialWordMatch, so they
+	// only reorder matches with the same query-dependent score.
+	scoreSnippetDensityFactor   = 20.0
+	scoreSnippetProximityFactor = 10.0
+	scoreSnippetDistinctFactor  = 10.0
+
 	// Used for tiebreakers. The scores are not combined with the main score, but
 	// are used to break ties between matches with the same score. The factors are
 	// chosen to separate the tiebreakers from the main score and from each other.
 	// If you make changes here, make sure to update indexData.scoreFile too.
 	scoreRepoRankFactor  = 100.0
 	scoreFileOrderFactor = 10.0
+
+	// Used for ordering files with the same score by the class of the field
+	// of their best match, see zoekt.SearchOptions.ScoreFieldClasses. It is
+	// above the repo rank tiebreaker, which is at most 65535 *
+	// scoreRepoRankFactor, and twice it stays below ScoreOffset.
+	scoreFieldClassFactor = 1_000_000.0
 )
 
 // findMaxOverlappingSection returns the index of the section in secs that
@@ -627,6 +799,9 @@ func (p *contentProvider) findSymbol(cm *candidateMatch) (DocumentSection, *zoek
 	// surprising that we have a matching section but not symbol data.
 	start := p.id.fileEndSymbol[p.idx]
 	si := p.id.symbols.data(start + secIdx)
+	if si != nil {
+		si.Doc = p.id.symbolDocumentation(p.idx, secIdx)
+	}
 
 	return sec, si, true
 }
@@ -644,10 +819,62 @@ func sectionSlice(data []byte, sec DocumentSection) []byte {
 	return data[sec.Start:sec.End]
 }
 
-// scoreSymbolKind boosts a match based on the combination of language, symbol
-// and kind. The language string comes from go-enry, the symbol and kind from
-// ctags.
-func scoreSymbolKind(language string, filename []byte, sym []byte, kind ctags.SymbolKind) float64 {
+// symbolScore is the score of a symbol definition, broken down into its
+// components so they can be reported individually in DebugScore.
+type symbolScore struct {
+	kind     float64 // definition kind, e.g. class > method > variable
+	exported float64 // boost for symbols visible outside their package
+	test     float64 // penalty for definitions in test files
+	fileRank float64 // penalty for definitions in vendored or generated files
+}
+
+const (
+	scoreExportedFactor = 0.5
+	scoreTestFileFactor = 0.8
+	scoreLowRankFactor  = 0.9
+)
+
+// scoreSymbolDefinition scores the definition of sym in filename.
+func scoreSymbolDefinition(language string, filename []byte, sym []byte, kind ctags.SymbolKind) symbolScore {
+	s := symbolScore{kind: scoreSymbolKind(language, kind)}
+	if symbolExported(language, sym) {
+		s.exported = scoreExportedFactor * scoreKindMatch
+	}
+
+	// The penalties scale with the score of the definition, so a class in a
+	// test file still ranks above a variable in a regular file.
+	name := string(filename)
+	if enry.IsTest(name) {
+		s.test = -(1 - scoreTestFileFactor) * (s.kind + s.exported)
+	}
+	if enry.IsVendor(name) || enry.IsGenerated(name, nil) {
+		s.fileRank = -(1 - scoreLowRankFactor) * (s.kind + s.exported + s.test)
+	}
+	return s
+}
+
+// symbolExported reports whether sym is visible outside of its package or
+// module. It is only implemented for languages which encode visibility in the
+// name of a symbol.
+func symbolExported(language string, sym []byte) bool {
+	switch language {
+	case "Go", "go":
+		// Same implementation as token.IsExported
+		ch, _ := utf8.DecodeRune(sym)
+		return unicode.IsUpper(ch)
+	case "Python", "python":
+		// Dunder methods like __init__ are public.
+		isDunder := len(sym) > 4 && bytes.HasPrefix(sym, []byte("__")) && bytes.HasSuffix(sym, []byte("__"))
+		return isDunder || !bytes.HasPrefix(sym, []byte("_"))
+	case "JavaScript", "javascript", "TypeScript", "typescript", "Dart", "dart":
+		return len(sym) > 0 && sym[0] != '_' && sym[0] != '#'
+	}
+	return false
+}
+
+// scoreSymbolKind boosts a match based on the combination of language and
+// kind. The language string comes from go-enry, the kind from ctags.
+func scoreSymbolKind(language string, kind ctags.SymbolKind) float64 {
 	var factor float64
 
 	// Generic ranking which will be overriden by language specific ranking
@@ -733,15 +960,6 @@ func scoreSymbolKind(language string, filename []byte, sym []byte, kind ctags.Sy
 			factor = 5
 		}
 
-		// Boost exported go symbols. Same implementation as token.IsExported
-		if ch, _ := utf8.DecodeRune(sym); unicode.IsUpper(ch) {
-			factor += 0.5
-		}
-
-		if bytes.HasSuffix(filename, []byte("_test.go")) {
-			factor *= 0.8
-		}
-
 		// Could also rank on:
 		//
 		//   - anonMember  struct anonymous members
@@ -3,11 +3,15 @@ package index
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 	"testing/quick"
 	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/sourcegraph/zoekt/internal/ctags"
 )
 
 func getNewlines(data []byte) newlines {
@@ -218,6 +222,7 @@ func TestChunkMatches(t *testing.T) {
 	cases := []struct {
 		candidateMatches []*candidateMatch
 		numContextLines  int
+		mergeAdjacent    bool
 		want             []candidateChunk
 	}{{
 		candidateMatches: []*candidateMatch{match_0_2},
@@ -309,6 +314,25 @@ func TestChunkMatches(t *testing.T) {
 			maxOffset:  72,
 			candidates: []*candidateMatch{match_71_72},
 		}},
+	}, {
+		candidateMatches: []*candidateMatch{
+			match_0_2, match_19_42, match_45_48, match_71_72,
+		},
+		numContextLines: 0,
+		mergeAdjacent:   true,
+		want: []candidateChunk{{
+			firstLine:  1,
+			minOffset:  0,
+			lastLine:   4,
+			maxOffset:  48,
+			candidates: []*candidateMatch{match_0_2, match_19_42, match_45_48},
+		}, {
+			firstLine:  6,
+			minOffset:  71,
+			lastLine:   6,
+			maxOffset:  72,
+			candidates: []*candidateMatch{match_71_72},
+		}},
 	}, {
 		candidateMatches: []*candidateMatch{
 			match_0_2, match_19_42, match_45_48, match_71_72,
@@ -326,7 +350,7 @@ func TestChunkMatches(t *testing.T) {
 	newlines := getNewlines(content)
 	for _, tt := range cases {
 		t.Run("", func(t *testing.T) {
-			got := chunkCandidates(tt.candidateMatches, newlines, tt.numContextLines)
+			got := chunkCandidates(tt.candidateMatches, newlines, tt.numContextLines, tt.mergeAdjacent)
 			if diff := cmp.Diff(fmt.Sprintf("%#v\n", tt.want), fmt.Sprintf("%#v\n", got)); diff != "" {
 				t.Fatal(diff)
 			}
@@ -412,6 +436,53 @@ func TestColumnHelper(t *testing.T) {
 	}
 }
 
+func TestColumnHelperDisplay(t *testing.T) {
+	// --------------------0-1-23456789
+	data := []byte("x\n\tab\tcé\td")
+	cases := []struct {
+		tabWidth        uint32
+		offset          uint32
+		column, display uint32
+	}{
+		{offset: 2, column: 1, display: 1},  // the first tab
+		{offset: 3, column: 2, display: 9},  // a
+		{offset: 6, column: 5, display: 17}, // c
+		{offset: 7, column: 6, display: 18}, // é
+		{offset: 10, column: 8, display: 25},
+		{tabWidth: 4, offset: 3, column: 2, display: 5},
+		{tabWidth: 4, offset: 10, column: 8, display: 13},
+	}
+	for _, c := range cases {
+		// A fresh helper counts from the start of the line, a shared one
+		// from the last offset.
+		ch := columnHelper{data: data, tabWidth: c.tabWidth}
+		column, display := ch.columns(2, c.offset)
+		if column != c.column || display != c.display {
+			t.Errorf("tab width %d, offset %d: got column %d and display column %d, want %d and %d", c.tabWidth, c.offset, column, display, c.column, c.display)
+		}
+	}
+
+	ch := columnHelper{data: data}
+	for _, c := range cases[:5] {
+		if _, display := ch.columns(2, c.offset); display != c.display {
+			t.Errorf("offset %d: got cached display column %d, want %d", c.offset, display, c.display)
+		}
+	}
+
+	// Long lines are counted from their checkpoints, unless there is a tab
+	// before the checkpoint.
+	line := []byte(strings.Repeat("a", 100) + "\tb")
+	ch = columnHelper{data: line, checkpoints: []longLineCheckpoint{{offset: 50, runes: 50}}}
+	if column, display := ch.columns(0, 101); column != 102 || display != 105 {
+		t.Errorf("got column %d and display column %d after a checkpoint, want 102 and 105", column, display)
+	}
+	line = []byte("\t" + strings.Repeat("a", 100))
+	ch = columnHelper{data: line, checkpoints: []longLineCheckpoint{{offset: 50, runes: 50}}}
+	if column, display := ch.columns(0, 60); column != 61 || display != 68 {
+		t.Errorf("got column %d and display column %d after a checkpoint, want 61 and 68", column, display)
+	}
+}
+
 func TestFindMaxOverlappingSection(t *testing.T) {
 	secs := []DocumentSection{
 		{Start: 0, End: 5},
@@ -464,3 +535,39 @@ func TestFindMaxOverlappingSection(t *testing.T) {
 		})
 	}
 }
+
+func TestScoreSymbolDefinition(t *testing.T) {
+	testcases := []struct {
+		language string
+		filename string
+		sym      string
+		kind     ctags.SymbolKind
+		want     symbolScore
+	}{
+		{language: "Go", filename: "server.go", sym: "server", kind: ctags.Struct, want: symbolScore{kind: 900}},
+		{language: "Go", filename: "server.go", sym: "Server", kind: ctags.Struct, want: symbolScore{kind: 900, exported: 50}},
+		{language: "Go", filename: "server_test.go", sym: "Server", kind: ctags.Struct, want: symbolScore{kind: 900, exported: 50, test: -190}},
+		{language: "Go", filename: "vendor/x/server.go", sym: "server", kind: ctags.Struct, want: symbolScore{kind: 900, fileRank: -90}},
+		{language: "Python", filename: "a.py", sym: "_private", kind: ctags.Function, want: symbolScore{kind: 800}},
+		{language: "Python", filename: "a.py", sym: "__init__", kind: ctags.Method, want: symbolScore{kind: 800, exported: 50}},
+		{language: "Python", filename: "tests/test_a.py", sym: "helper", kind: ctags.Function, want: symbolScore{kind: 800, exported: 50, test: -170}},
+		{language: "TypeScript", filename: "a.ts", sym: "_cache", kind: ctags.Variable, want: symbolScore{kind: 400}},
+		{language: "Java", filename: "A.java", sym: "A", kind: ctags.Class, want: symbolScore{kind: 1000}},
+	}
+
+	for _, tt := range testcases {
+		t.Run(tt.language+"/"+tt.filename+"/"+tt.sym, func(t *testing.T) {
+			got := scoreSymbolDefinition(tt.language, []byte(tt.filename), []byte(tt.sym), tt.kind)
+			if d := cmp.Diff(tt.want, got, cmp.AllowUnexported(symbolScore{}), cmpopts.EquateApprox(0, 1e-9)); d != "" {
+				t.Fatalf("unexpected score (-want, +got):\n%s", d)
+			}
+		})
+	}
+
+	// Class > method > variable, even in test files.
+	class := scoreSymbolDefinition("Go", []byte("a_test.go"), []byte("a"), ctags.Interface)
+	variable := scoreSymbolDefinition("Go", []byte("a.go"), []byte("A"), ctags.Variable)
+	if class.kind+class.test <= variable.kind+variable.exported {
+		t.Fatalf("interface in test file %+v should rank above exported variable %+v", class, variable)
+	}
+}
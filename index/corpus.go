@@ -0,0 +1,80 @@
+package index
+
+import (
+	"context"
+)
+
+// CorpusStats describe the documents of one or more shards. BM25 scoring
+// (SearchOptions.UseBM25Scoring) depends on them, as it compares the length of
+// a document with the average length of the documents. If every shard used
+// its own statistics, a document would score differently depending on the
+// shard it is in, and the order of results from different shards would be
+// arbitrary. A searcher over many shards therefore passes the statistics of
+// all of them to each shard with WithCorpusStats.
+//
+// Only BM25 uses them. The default scoring only depends on the document and
+// its matches, so its scores are comparable across shards without them.
+type CorpusStats struct {
+	Documents    int64
+	ContentBytes int64
+}
+
+// Add adds the statistics of o to s.
+func (s *CorpusStats) Add(o CorpusStats) {
+	s.Documents += o.Documents
+	s.ContentBytes += o.ContentBytes
+}
+
+// averageDocumentLength is the average size of the documents in bytes, or 0
+// if there are none.
+func (s CorpusStats) averageDocumentLength() float64 {
+	if s.Documents == 0 {
+		return 0
+	}
+	return float64(s.ContentBytes) / float64(s.Documents)
+}
+
+type corpusStatsKey struct{}
+
+// WithCorpusStats returns a copy of ctx in which shards score documents with
+// BM25 using the statistics s instead of their own. Other scoring ignores
+// them.
+func WithCorpusStats(ctx context.Context, s CorpusStats) context.Context {
+	return context.WithValue(ctx, corpusStatsKey{}, s)
+}
+
+func corpusStatsFromContext(ctx context.Context) (CorpusStats, bool) {
+	s, ok := ctx.Value(corpusStatsKey{}).(CorpusStats)
+	return s, ok && s.Documents > 0
+}
+
+// CorpusStats returns the statistics of the documents in the shard. They are
+// written at build time. For older shards, the sizes are computed from the
+// stored contents.
+func (d *indexData) CorpusStats() CorpusStats {
+	if d.metaData.Documents > 0 {
+		return CorpusStats{
+			Documents:    int64(d.metaData.Documents),
+			ContentBytes: d.metaData.ContentBytes,
+		}
+	}
+	n := d.numDocs()
+	if n == 0 {
+		return CorpusStats{}
+	}
+	return CorpusStats{
+		Documents:    int64(n),
+		ContentBytes: int64(d.boundaries[n]),
+	}
+}
+
+// averageDocumentLength returns the average document length BM25 compares
+// documents to: the one of the corpus in ctx, or else the one of the shard.
+func (d *indexData) averageDocumentLength(ctx context.Context) float64 {
+	s, ok := corpusStatsFromContext(ctx)
+	if !ok {
+		s = d.CorpusStats()
+	}
+	// This is very unlikely, but explicitly guard against division by zero.
+	return max(s.averageDocumentLength(), 1)
+}
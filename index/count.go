@@ -0,0 +1,151 @@
+package index
+
+import (
+	"context"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Count implements zoekt.CountSearcher.
+func (d *indexData) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	var (
+		res *zoekt.CountResult
+		err error
+	)
+	if opts.Estimate {
+		res, err = d.estimateCount(ctx, q)
+	} else {
+		res, err = d.exactCount(ctx, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Searches stop early once ctx is done, so the counts are incomplete.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// exactCount evaluates q like a search, but only counts the matches of the
+// documents.
+func (d *indexData) exactCount(ctx context.Context, q query.Q) (*zoekt.CountResult, error) {
+	sr, err := d.Search(ctx, q, &zoekt.SearchOptions{FilesOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	repos := map[string]struct{}{}
+	for _, f := range sr.Files {
+		repos[f.Repository] = struct{}{}
+	}
+	return &zoekt.CountResult{
+		MatchCount: sr.Stats.MatchCount,
+		FileCount:  sr.Stats.FileCount,
+		RepoCount:  len(repos),
+	}, nil
+}
+
+// estimateCount evaluates the match tree of q only as far as it can without
+// reading the contents of the documents. A document counts as a match unless
+// the index rules it out, with its ngram candidates as its matches.
+func (d *indexData) estimateCount(ctx context.Context, q query.Q) (*zoekt.CountResult, error) {
+	res := &zoekt.CountResult{Estimated: true}
+	if len(d.fileNameIndex) == 0 {
+		return res, nil
+	}
+
+	q = d.simplify(q)
+	if c, ok := q.(*query.Const); ok && !c.Value {
+		return res, nil
+	}
+	q = query.Map(q, query.ExpandFileContent)
+
+	mt, err := d.newMatchTree(q, matchTreeOpt{})
+	if err != nil {
+		return nil, err
+	}
+	mt, err = pruneMatchTree(mt)
+	if err != nil {
+		return nil, err
+	}
+	if mt == nil {
+		return res, nil
+	}
+
+	var stats zoekt.Stats
+	cp := &contentProvider{
+		id:    d,
+		stats: &stats,
+	}
+	repos := map[uint16]struct{}{}
+
+	docCount := d.numDocs()
+	lastDoc := -1
+nextDoc:
+	for ctx.Err() == nil {
+		doc := mt.nextDoc()
+		if int(doc) <= lastDoc {
+			doc = uint32(lastDoc + 1)
+		}
+		for doc < docCount && !d.docVisible(ctx, doc) {
+			doc++
+		}
+		if doc >= docCount {
+			break
+		}
+		lastDoc = int(doc)
+
+		mt.prepare(doc)
+		cp.setDocument(doc)
+		known := make(map[matchTree]bool)
+		for cost := costMin; cost <= costMemory; cost++ {
+			if evalMatchTree(cp, cost, known, mt) == matchesNone {
+				continue nextDoc
+			}
+		}
+
+		res.FileCount++
+		// Like a search, a file without content candidates matches on its
+		// name.
+		res.MatchCount += max(1, countCandidates(mt, known))
+		repos[d.repos[doc]] = struct{}{}
+	}
+	res.RepoCount = len(repos)
+	return res, nil
+}
+
+// countCandidates returns the number of candidate matches of the substrings
+// of mt which may match the current document, according to known.
+func countCandidates(mt matchTree, known map[matchTree]bool) int {
+	maybe := func(mt matchTree) bool {
+		v, ok := known[mt]
+		return v || !ok
+	}
+	n := 0
+	switch s := mt.(type) {
+	case *andMatchTree:
+		for _, ch := range s.children {
+			if maybe(ch) {
+				n += countCandidates(ch, known)
+			}
+		}
+	case *andLineMatchTree:
+		n = countCandidates(&s.andMatchTree, known)
+	case *orMatchTree:
+		for _, ch := range s.children {
+			if maybe(ch) {
+				n += countCandidates(ch, known)
+			}
+		}
+	case *boostMatchTree:
+		n = countCandidates(s.child, known)
+	case *budgetMatchTree:
+		n = countCandidates(s.child, known)
+	case *symbolSubstrMatchTree:
+		n = len(s.current)
+	case *substrMatchTree:
+		n = len(s.current)
+	}
+	return n
+}
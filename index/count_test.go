@@ -0,0 +1,59 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestCount(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "a.go", Content: []byte("needle haystack needle\n")},
+		Document{Name: "b.go", Content: []byte("needles\n")},
+		Document{Name: "c.go", Content: []byte("nothing to see\n")},
+		Document{Name: "needle.txt", Content: []byte("hay\n")},
+	)
+	searcher := searcherForTest(t, b)
+
+	count := func(q query.Q, estimate bool) *zoekt.CountResult {
+		t.Helper()
+		res, err := zoekt.Count(context.Background(), searcher, q, &zoekt.CountOptions{Estimate: estimate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		q     query.Q
+		exact zoekt.CountResult
+	}{{
+		q:     &query.Substring{Pattern: "needle", Content: true},
+		exact: zoekt.CountResult{MatchCount: 3, FileCount: 2, RepoCount: 1},
+	}, {
+		q:     &query.Substring{Pattern: "needle", FileName: true},
+		exact: zoekt.CountResult{MatchCount: 1, FileCount: 1, RepoCount: 1},
+	}, {
+		q:     &query.Substring{Pattern: "xyzzy"},
+		exact: zoekt.CountResult{},
+	}, {
+		q:     &query.Const{Value: false},
+		exact: zoekt.CountResult{},
+	}} {
+		t.Run(tc.q.String(), func(t *testing.T) {
+			if got := count(tc.q, false); *got != tc.exact {
+				t.Errorf("exact: got %+v, want %+v", *got, tc.exact)
+			}
+
+			est := count(tc.q, true)
+			if !est.Estimated {
+				t.Error("estimate is not marked as estimated")
+			}
+			if est.FileCount < tc.exact.FileCount || est.MatchCount < tc.exact.MatchCount || est.RepoCount != tc.exact.RepoCount {
+				t.Errorf("estimate: got %+v, want at least %+v", *est, tc.exact)
+			}
+		})
+	}
+}
@@ -50,6 +50,9 @@ func parseSymbols(todo []*Document, languageMap ctags.LanguageMap, parserBins ct
 	parser := ctags.NewCTagsParser(parserBins)
 	defer parser.Close()
 
+	treeSitter := ctags.NewTreeSitterParser()
+	defer treeSitter.Close()
+
 	for _, doc := range todo {
 		if len(doc.Content) == 0 || doc.Symbols != nil {
 			continue
@@ -67,9 +70,24 @@ func parseSymbols(todo []*Document, languageMap ctags.LanguageMap, parserBins ct
 			parserType = ctags.UniversalCTags
 		}
 
+		if parserType == ctags.TreeSitterCTags {
+			monitor.BeginParsing(doc)
+			syms, ok, err := treeSitter.Parse(normalizeLanguage(doc.Language), doc.Content)
+			monitor.EndParsing(len(syms))
+			if err != nil {
+				return fmt.Errorf("%s: %w", doc.Name, err)
+			}
+			if ok {
+				doc.Symbols, doc.SymbolsMetaData = treeSitterSections(syms)
+				continue
+			}
+			// Languages without a tree-sitter grammar use universal-ctags.
+			parserType = ctags.UniversalCTags
+		}
+
 		monitor.BeginParsing(doc)
 		es, err := parser.Parse(doc.Name, doc.Content, parserType)
-		monitor.EndParsing(es)
+		monitor.EndParsing(len(es))
 
 		if err != nil {
 			return err
@@ -89,6 +107,26 @@ func parseSymbols(todo []*Document, languageMap ctags.LanguageMap, parserBins ct
 	return nil
 }
 
+// treeSitterSections converts the symbols of a tree-sitter parser, which are
+// sorted and don't overlap, to byte ranges with metadata.
+func treeSitterSections(syms []ctags.Symbol) ([]DocumentSection, []*zoekt.Symbol) {
+	if len(syms) == 0 {
+		return nil, nil
+	}
+	symOffsets := make([]DocumentSection, 0, len(syms))
+	symMetaData := make([]*zoekt.Symbol, 0, len(syms))
+	for _, s := range syms {
+		symOffsets = append(symOffsets, DocumentSection{Start: s.Start, End: s.End})
+		symMetaData = append(symMetaData, &zoekt.Symbol{
+			Sym:        s.Name,
+			Kind:       s.Kind,
+			Parent:     s.Parent,
+			ParentKind: s.ParentKind,
+		})
+	}
+	return symOffsets, symMetaData
+}
+
 // overlaps finds the proper position to insert a zoekt.DocumentSection with
 // "start and "end" into "symOffsets". It returns -1 if the new section overlaps
 // with one of the existing ones.
@@ -254,18 +292,18 @@ func (m *monitor) BeginParsing(doc *Document) {
 	m.mu.Unlock()
 }
 
-func (m *monitor) EndParsing(entries []*ctags.Entry) {
+func (m *monitor) EndParsing(symbols int) {
 	now := time.Now()
 	m.mu.Lock()
 	m.lastUpdate = now
 
 	// update aggregate stats
 	m.totalSize += m.currentDocSize
-	m.totalSymbols += len(entries)
+	m.totalSymbols += symbols
 
 	// inform done if we warned about current document
 	if m.currentDocStuckCount > 0 {
-		log.Printf("symbol analysis for %s (size %d bytes) is done and found %d symbols", m.currentDocName, m.currentDocSize, len(entries))
+		log.Printf("symbol analysis for %s (size %d bytes) is done and found %d symbols", m.currentDocName, m.currentDocSize, symbols)
 		m.currentDocStuckCount = 0
 	}
 
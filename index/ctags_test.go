@@ -43,6 +43,30 @@ func TestTagsToSections(t *testing.T) {
 	}
 }
 
+func TestTreeSitterSymbols(t *testing.T) {
+	if !ctags.TreeSitterSupported() {
+		t.Skip("built without the treesitter tag")
+	}
+
+	// ctags misses the multi-line declaration.
+	doc := &Document{
+		Name:     "main.go",
+		Language: "Go",
+		Content:  []byte("package main\n\nfunc run(\n\tctx int,\n) {}\n"),
+	}
+	if err := parseSymbols([]*Document{doc}, ctags.LanguageMap{"go": ctags.TreeSitterCTags}, ctags.ParserBinMap{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DocumentSection{{Start: 8, End: 12}, {Start: 19, End: 22}}
+	if !reflect.DeepEqual(doc.Symbols, want) {
+		t.Fatalf("got sections %v, want %v", doc.Symbols, want)
+	}
+	if got := doc.SymbolsMetaData[1]; got.Sym != "run" || got.Kind != "function" {
+		t.Errorf("got symbol %+v, want function run", got)
+	}
+}
+
 func TestTagsToSectionsMultiple(t *testing.T) {
 	c := []byte("class Foo { int x; int b; }")
 	// ----------012345678901234567890123456
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// dedupStats are the documents left out of the stored contents of a shard.
+type dedupStats struct {
+	documents int
+	bytes     int64
+}
+
+// contentSources returns the first document with the same contents for each
+// document, and the stats of the duplicates.
+func (b *ShardBuilder) contentSources() ([]uint32, dedupStats) {
+	var stats dedupStats
+	sources := make([]uint32, len(b.contentStrings))
+	first := make(map[[sha256.Size]byte]uint32, len(b.contentStrings))
+	for i, s := range b.contentStrings {
+		sources[i] = uint32(i)
+		if len(s.data) == 0 {
+			continue
+		}
+		sum := sha256.Sum256(s.data)
+		src, ok := first[sum]
+		if !ok {
+			first[sum] = uint32(i)
+			continue
+		}
+		if bytes.Equal(b.contentStrings[src].data, s.data) {
+			sources[i] = src
+			stats.documents++
+			stats.bytes += int64(len(s.data))
+		}
+	}
+	return sources, stats
+}
+
+// writeDedupedContents writes the file contents, storing the contents of
+// byte-identical documents once. The documents which share the contents of
+// another have an empty item in the fileContents section. The rest of the
+// shard refers to the offsets of the contents of all documents, which are
+// written to the contentBoundaries section.
+//
+// If no documents share contents, the contents are written as usual.
+func (b *ShardBuilder) writeDedupedContents(w *writer, toc *indexTOC) dedupStats {
+	sources, stats := b.contentSources()
+	if stats.documents == 0 {
+		toc.fileContents.writeStrings(w, b.contentStrings)
+		return stats
+	}
+
+	toc.fileContents.start(w)
+	var boundaries []uint64
+	var off uint64
+	for i, s := range b.contentStrings {
+		if sources[i] == uint32(i) {
+			toc.fileContents.addItem(w, s.data)
+		} else {
+			toc.fileContents.addItem(w, nil)
+		}
+		boundaries = append(boundaries, off)
+		off += uint64(len(s.data))
+	}
+	toc.fileContents.end(w)
+
+	writeContentBoundaries(w, &toc.contentBoundaries, append(boundaries, off))
+
+	toc.contentSources.start(w)
+	for _, src := range sources {
+		w.U32(src)
+	}
+	toc.contentSources.end(w)
+	return stats
+}
+
+// readDedupedContentsIndex sets up d to read the file contents of a shard
+// written by writeDedupedContents.
+func (d *indexData) readDedupedContentsIndex(toc *indexTOC) error {
+	var err error
+	if d.boundaries, err = readContentBoundaries(d.file, toc); err != nil {
+		return err
+	}
+	if d.contentSources, err = readSectionU32(d.file, toc.contentSources); err != nil {
+		return err
+	}
+	d.storedContentIndex = toc.fileContents.relativeIndex()
+	if len(d.contentSources) != len(d.boundaries)-1 {
+		return fmt.Errorf("got %d content sources, want %d", len(d.contentSources), len(d.boundaries)-1)
+	}
+	return nil
+}
+
+// readDedupedContents returns the contents of document i, which are stored
+// with the document it shares them with.
+func (d *indexData) readDedupedContents(i uint32) ([]byte, error) {
+	src := d.contentSources[i]
+	return d.readSectionBlob(simpleSection{
+		off: d.boundariesStart + d.storedContentIndex[src],
+		sz:  d.storedContentIndex[src+1] - d.storedContentIndex[src],
+	})
+}
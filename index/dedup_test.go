@@ -0,0 +1,92 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestDedupedContents(t *testing.T) {
+	vendored := []byte("package lib\n\nfunc Needle() {}\n")
+	repos := []*zoekt.Repository{
+		{Name: "repo"},
+		{Name: "fork"},
+	}
+	var docs [][]Document
+	for _, repo := range repos {
+		docs = append(docs, []Document{
+			{Name: "lib/lib.go", Content: vendored},
+			{Name: "main.go", Content: []byte(fmt.Sprintf("package main\n\n// %s calls Needle\n", repo.Name))},
+			{Name: "vendor/lib/lib.go", Content: vendored},
+		})
+	}
+
+	plain := testShardBuilderCompound(t, repos, docs)
+	deduped := testShardBuilderCompound(t, repos, docs)
+	deduped.enableContentDedup()
+
+	var plainBuf, dedupedBuf bytes.Buffer
+	if err := plain.Write(&plainBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := deduped.Write(&dedupedBuf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bytes.Count(dedupedBuf.Bytes(), []byte("func Needle")), 1; got != want {
+		t.Errorf("deduplicated shard stores the contents %d times, want %d", got, want)
+	}
+
+	search := func(s zoekt.Searcher) *zoekt.SearchResult {
+		t.Helper()
+		res, err := s.Search(context.Background(), &query.Substring{Pattern: "Needle", Content: true}, &zoekt.SearchOptions{NumContextLines: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res
+	}
+
+	want := search(searcherForTest(t, plain))
+	if len(want.Files) != 6 {
+		t.Fatalf("got %d matches in the plain shard, want 6", len(want.Files))
+	}
+	d := searcherForTest(t, deduped).(*indexData)
+	if got := search(d); !cmp.Equal(want.Files, got.Files) {
+		t.Errorf("search of deduplicated shard (-want +got):\n%s", cmp.Diff(want.Files, got.Files))
+	}
+
+	if got, want := d.metaData.DuplicateDocuments, 3; got != want {
+		t.Errorf("got %d duplicate documents, want %d", got, want)
+	}
+	if got, want := d.metaData.DuplicateContentBytes, int64(3*len(vendored)); got != want {
+		t.Errorf("got %d duplicate content bytes, want %d", got, want)
+	}
+	if d.metaData.IndexMinReaderVersion != dedupedContentsMinReaderVersion {
+		t.Errorf("got min reader version %d, want %d", d.metaData.IndexMinReaderVersion, dedupedContentsMinReaderVersion)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		merged, err := merge(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := search(searcherForTest(t, merged)); !cmp.Equal(want.Files, got.Files) {
+			t.Errorf("search of merged shard (-want +got):\n%s", cmp.Diff(want.Files, got.Files))
+		}
+	})
+
+	t.Run("no duplicates", func(t *testing.T) {
+		b := testShardBuilderCompound(t, repos[:1], [][]Document{{docs[0][1]}})
+		b.enableContentDedup()
+		d := searcherForTest(t, b).(*indexData)
+		if d.contentSources != nil || d.metaData.DuplicateDocuments != 0 {
+			t.Errorf("shard without duplicates has content sources %v", d.contentSources)
+		}
+	})
+}
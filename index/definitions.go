@@ -0,0 +1,243 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/ctags"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+// symbolHashEntrySize is the size of an entry of the symbol hash index: the
+// 64-bit hash of the symbol name followed by the 32-bit index of the symbol in
+// the shard.
+const symbolHashEntrySize = 12
+
+// hashSymbol returns the hash of a symbol name in the symbol hash index.
+func hashSymbol(name []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(name)
+	return h.Sum64()
+}
+
+// symbolHashEntry is an entry of the symbol hash index.
+type symbolHashEntry struct {
+	hash uint64
+	sym  uint32
+}
+
+// encodeSymbolHashes returns the symbol hash index of entries, sorted by hash
+// so that lookups are binary searches.
+func encodeSymbolHashes(entries []symbolHashEntry) []byte {
+	slices.SortFunc(entries, func(a, b symbolHashEntry) int {
+		if a.hash != b.hash {
+			if a.hash < b.hash {
+				return -1
+			}
+			return 1
+		}
+		return int(a.sym) - int(b.sym)
+	})
+	buf := make([]byte, 0, len(entries)*symbolHashEntrySize)
+	for _, e := range entries {
+		buf = binary.BigEndian.AppendUint64(buf, e.hash)
+		buf = binary.BigEndian.AppendUint32(buf, e.sym)
+	}
+	return buf
+}
+
+// symbolHashIndex is the encoded symbol hash index of a shard.
+type symbolHashIndex []byte
+
+func (s symbolHashIndex) entry(i int) symbolHashEntry {
+	b := s[i*symbolHashEntrySize:]
+	return symbolHashEntry{
+		hash: binary.BigEndian.Uint64(b),
+		sym:  binary.BigEndian.Uint32(b[8:]),
+	}
+}
+
+// lookup returns the indexes of the symbols whose name has the hash of name.
+// Because of hash collisions, the caller has to compare the names.
+func (s symbolHashIndex) lookup(name []byte) []uint32 {
+	return s.lookupHash(hashSymbol(name))
+}
+
+// lookupHash returns the indexes of the symbols with the hash h.
+func (s symbolHashIndex) lookupHash(h uint64) []uint32 {
+	n := len(s) / symbolHashEntrySize
+	i := sort.Search(n, func(i int) bool { return s.entry(i).hash >= h })
+
+	var syms []uint32
+	for ; i < n; i++ {
+		e := s.entry(i)
+		if e.hash != h {
+			break
+		}
+		syms = append(syms, e.sym)
+	}
+	return syms
+}
+
+// symbolDoc returns the document containing the symbol with index sym and the
+// index of the symbol within the document.
+func (d *indexData) symbolDoc(sym uint32) (uint32, uint32) {
+	// fileEndSymbol[i] is the index of the first symbol of document i, so the
+	// document is the last one starting at or before sym.
+	doc := sort.Search(len(d.fileEndSymbol), func(i int) bool { return d.fileEndSymbol[i] > sym }) - 1
+	return uint32(doc), sym - d.fileEndSymbol[doc]
+}
+
+// candidateSymbols returns the indexes of the symbols which may be named
+// name. Shards without a symbol hash index return all their symbols whose
+// name has the same length.
+func (d *indexData) candidateSymbols(name []byte) ([]uint32, error) {
+	if d.symbolHashes != nil {
+		return d.symbolHashes.lookup(name), nil
+	}
+
+	var syms []uint32
+	var secs []DocumentSection
+	for doc := uint32(0); doc < d.numDocs(); doc++ {
+		var err error
+		secs, _, err = d.readDocSections(doc, secs[:0])
+		if err != nil {
+			return nil, err
+		}
+		for i, sec := range secs {
+			if int(sec.End-sec.Start) == len(name) {
+				syms = append(syms, d.fileEndSymbol[doc]+uint32(i))
+			}
+		}
+	}
+	return syms, nil
+}
+
+// Definitions implements zoekt.DefinitionSearcher. It looks up the symbols
+// named name in the symbol hash index, so it doesn't need to consider the
+// content of other documents.
+func (d *indexData) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if opts == nil {
+		opts = &zoekt.DefinitionOptions{}
+	}
+	var repoFilter *regexp.Regexp
+	if opts.RepoFilter != "" {
+		var err error
+		repoFilter, err = regexp.Compile(opts.RepoFilter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	syms, err := d.candidateSymbols([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var defs []zoekt.Definition
+	for _, sym := range syms {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		doc, secIdx := d.symbolDoc(sym)
+		repo := &d.repoMetaData[d.repos[doc]]
+		if repo.Tombstone || !tenant.HasAccess(ctx, repo.TenantID) {
+			continue
+		}
+		if repoFilter != nil && !repoFilter.MatchString(repo.Name) {
+			continue
+		}
+		// A symbol is only defined on the branches of its document: other
+		// branches have a different version of the file, or none.
+		if opts.Branch != "" {
+			if mask, ok := d.branchIDs[d.repos[doc]][opts.Branch]; !ok || d.fileBranchMasks[doc]&uint64(mask) == 0 {
+				continue
+			}
+		}
+		fileName := d.fileName(doc)
+		if _, tombstoned := repo.FileTombstones[string(fileName)]; tombstoned {
+			continue
+		}
+		language := d.languageMap[d.getLanguage(doc)]
+		if opts.Language != "" && language != opts.Language {
+			continue
+		}
+
+		secs, _, err := d.readDocSections(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		content, err := d.readContents(doc)
+		if err != nil {
+			return nil, err
+		}
+		sec := secs[secIdx]
+		if !bytes.Equal(sectionSlice(content, sec), []byte(name)) {
+			continue
+		}
+
+		branches := []string{opts.Branch}
+		if opts.Branch == "" {
+			branches = d.docBranches(doc)
+		}
+		def := zoekt.Definition{
+			Repository:         repo.Name,
+			RepositoryPriority: repo.GetPriority(),
+			FileName:           string(fileName),
+			Language:           language,
+			Branches:           branches,
+			Start:              symbolLocation(content, sec.Start),
+		}
+		if si := d.symbols.data(sym); si != nil {
+			def.Symbol = *si
+		}
+		def.Symbol.Sym = name
+		def.Symbol.Doc = d.symbolDocumentation(doc, secIdx)
+		ss := scoreSymbolDefinition(language, fileName, []byte(name), ctags.ParseSymbolKind(def.Symbol.Kind))
+		def.Score = ss.kind + ss.exported + ss.test + ss.fileRank
+		defs = append(defs, def)
+	}
+
+	zoekt.SortDefinitions(defs)
+	if opts.MaxResults > 0 && len(defs) > opts.MaxResults {
+		defs = defs[:opts.MaxResults]
+	}
+	return defs, nil
+}
+
+// docBranches returns the names of the branches containing doc.
+func (d *indexData) docBranches(doc uint32) []string {
+	var branches []string
+	names := d.branchNames[d.repos[doc]]
+	for id, mask := uint(1), d.fileBranchMasks[doc]; mask != 0; id, mask = id<<1, mask>>1 {
+		if mask&1 != 0 {
+			branches = append(branches, names[id])
+		}
+	}
+	sortBranches(branches, d.branchOrders[d.repos[doc]])
+	return branches
+}
+
+// symbolLocation returns the location of the byte offset off in content.
+func symbolLocation(content []byte, off uint32) zoekt.Location {
+	before := content[:off]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return zoekt.Location{
+		ByteOffset:    off,
+		LineNumber:    uint32(bytes.Count(before, []byte{'\n'}) + 1),
+		Column:        uint32(utf8.RuneCount(before[lineStart:]) + 1),
+		DisplayColumn: advanceDisplayColumn(0, before[lineStart:], defaultTabWidth) + 1,
+	}
+}
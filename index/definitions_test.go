@@ -0,0 +1,144 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestDefinitions(t *testing.T) {
+	docs := []Document{
+		{
+			Name:            "server.go",
+			Content:         []byte("package web\n\ntype Server struct{}\n\nconst Server = 1\n"),
+			Symbols:         []DocumentSection{{Start: 18, End: 24}, {Start: 41, End: 47}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "struct"}, {Kind: "constant"}},
+		},
+		{
+			Name:            "server_test.go",
+			Content:         []byte("package web\n\nfunc Server() {}\n"),
+			Symbols:         []DocumentSection{{Start: 18, End: 24}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}},
+		},
+		{
+			Name:            "server.py",
+			Content:         []byte("class Server:\n    pass\n"),
+			Symbols:         []DocumentSection{{Start: 6, End: 12}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "class"}},
+		},
+		{Name: "main.go", Content: []byte("package main\n\nfunc main() { web.Server{} }\n")},
+	}
+	repo := &zoekt.Repository{Name: "github.com/org/web"}
+
+	plain := testShardBuilder(t, repo, docs...)
+	hashed, err := NewShardBuilder(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed.enableSymbolHashes()
+	for _, d := range docs {
+		if err := hashed.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d := searcherForTest(t, plain).(*indexData); d.symbolHashes != nil {
+		t.Error("shard built without symbol hashes has a symbol hash index")
+	}
+	if d := searcherForTest(t, hashed).(*indexData); d.symbolHashes == nil {
+		t.Error("shard built with symbol hashes has no symbol hash index")
+	}
+
+	definitions := func(b *ShardBuilder, name string, opts *zoekt.DefinitionOptions) []string {
+		t.Helper()
+		defs, err := zoekt.Definitions(context.Background(), searcherForTest(t, b), name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range defs {
+			got = append(got, d.FileName+":"+d.Symbol.Kind)
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts *zoekt.DefinitionOptions
+		want []string
+	}{
+		{"Server", nil, []string{"server.py:class", "server.go:struct", "server_test.go:function", "server.go:constant"}},
+		{"Server", &zoekt.DefinitionOptions{Language: "Go"}, []string{"server.go:struct", "server_test.go:function", "server.go:constant"}},
+		{"Server", &zoekt.DefinitionOptions{Language: "Go", MaxResults: 1}, []string{"server.go:struct"}},
+		{"Server", &zoekt.DefinitionOptions{RepoFilter: "other"}, nil},
+		{"server", nil, nil},
+		{"Serve", nil, nil},
+		{"main", nil, nil},
+	} {
+		for _, b := range []*ShardBuilder{plain, hashed} {
+			if d := cmp.Diff(tc.want, definitions(b, tc.name, tc.opts)); d != "" {
+				t.Errorf("%s %+v: mismatch (-want +got):\n%s", tc.name, tc.opts, d)
+			}
+		}
+	}
+
+	defs, err := zoekt.Definitions(context.Background(), searcherForTest(t, hashed), "Server", &zoekt.DefinitionOptions{Language: "Python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []zoekt.Definition{{
+		Repository: "github.com/org/web",
+		FileName:   "server.py",
+		Language:   "Python",
+		Symbol:     zoekt.Symbol{Sym: "Server", Kind: "class"},
+		Start:      zoekt.Location{ByteOffset: 6, LineNumber: 1, Column: 7, DisplayColumn: 7},
+		Score:      defs[0].Score,
+	}}
+	if d := cmp.Diff(want, defs); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestDefinitionsBranches(t *testing.T) {
+	repo := &zoekt.Repository{
+		Name:     "github.com/org/web",
+		Branches: []zoekt.RepositoryBranch{{Name: "main"}, {Name: "dev"}},
+	}
+	fn := []*zoekt.Symbol{{Kind: "function"}}
+	b := testShardBuilder(t, repo,
+		Document{Name: "a.go", Content: []byte("func Old() {}\n"), Branches: []string{"main"}, Symbols: []DocumentSection{{5, 8}}, SymbolsMetaData: fn},
+		Document{Name: "a.go", Content: []byte("func New() {}\n"), Branches: []string{"dev"}, Symbols: []DocumentSection{{5, 8}}, SymbolsMetaData: fn},
+		Document{Name: "both.go", Content: []byte("func Both() {}\n"), Branches: []string{"main", "dev"}, Symbols: []DocumentSection{{5, 9}}, SymbolsMetaData: fn},
+	)
+	s := searcherForTest(t, b)
+
+	for _, tc := range []struct {
+		name   string
+		branch string
+		want   []string
+	}{
+		{"New", "", []string{"a.go@dev"}},
+		{"New", "dev", []string{"a.go@dev"}},
+		{"New", "main", nil},
+		{"Old", "dev", nil},
+		{"Both", "", []string{"both.go@main,dev"}},
+		{"Both", "dev", []string{"both.go@dev"}},
+		{"Both", "release", nil},
+	} {
+		defs, err := zoekt.Definitions(context.Background(), s, tc.name, &zoekt.DefinitionOptions{Branch: tc.branch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range defs {
+			got = append(got, d.FileName+"@"+strings.Join(d.Branches, ","))
+		}
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("%s on %q: mismatch (-want +got):\n%s", tc.name, tc.branch, d)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package index
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/go-enry/go-enry/v2"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Flags of a document, which are classified at index time and stored in the
+// docFlags section, one byte per document.
+const (
+	// docFlagTest is set for test files.
+	docFlagTest byte = 1 << iota
+)
+
+// docFlagsFeatureVersion is the feature version from which shards have the
+// docFlags section, so their documents need not be classified when they are
+// loaded.
+const docFlagsFeatureVersion = 14
+
+// classifyDoc returns the flags of a document with the given name and
+// language.
+func classifyDoc(name, language string) byte {
+	var flags byte
+	if isTestFile(name, language) {
+		flags |= docFlagTest
+	}
+	return flags
+}
+
+// testDirs are the names of directories holding test code.
+var testDirs = map[string]bool{
+	"test":      true,
+	"tests":     true,
+	"__tests__": true,
+	"spec":      true,
+	"specs":     true,
+}
+
+// isTestFile returns true if the file looks like test code, either because it
+// is in a test directory, or by the test file conventions of its language.
+func isTestFile(name, language string) bool {
+	dir, base := path.Split(name)
+	for _, d := range strings.Split(dir, "/") {
+		if testDirs[d] {
+			return true
+		}
+	}
+	if enry.IsTest(name) {
+		return true
+	}
+
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	hasTestSuffix := strings.HasSuffix(stem, "Test") || strings.HasSuffix(stem, "Tests")
+	switch language {
+	case "Go":
+		return strings.HasSuffix(base, "_test.go")
+	case "Python":
+		return strings.HasPrefix(base, "test_") || strings.HasSuffix(stem, "_test") || base == "conftest.py"
+	case "JavaScript", "TypeScript", "TSX", "JSX", "Vue":
+		return strings.Contains(base, ".test.") || strings.Contains(base, ".spec.")
+	case "Java", "Kotlin", "Scala", "Groovy":
+		return hasTestSuffix || strings.HasSuffix(stem, "IT") || strings.HasSuffix(stem, "Spec")
+	case "Ruby":
+		return strings.HasSuffix(stem, "_spec") || strings.HasSuffix(stem, "_test")
+	case "C#", "PHP", "Swift", "Objective-C":
+		return hasTestSuffix
+	case "Rust":
+		return stem == "tests"
+	case "C", "C++":
+		return strings.HasSuffix(stem, "_test") || strings.HasSuffix(stem, "_unittest")
+	case "Elixir":
+		return strings.HasSuffix(base, "_test.exs")
+	}
+	return false
+}
+
+func decodeDocFlags(blob []byte, numDocs uint32) ([]byte, error) {
+	if len(blob) != int(numDocs) {
+		return nil, fmt.Errorf("docFlags: got %d bytes for %d documents", len(blob), numDocs)
+	}
+	return blob, nil
+}
+
+// buildTestDocs fills testDocs from the flags of the documents, which are nil
+// if the docFlags section is empty or missing. Shards from before
+// docFlagsFeatureVersion are classified from the names and languages of their
+// documents. testDocs is nil if the shard has no test files.
+func (d *indexData) buildTestDocs(flags []byte) {
+	if flags == nil && d.metaData.IndexFeatureVersion >= docFlagsFeatureVersion {
+		d.testDocs = nil
+		return
+	}
+	d.testDocs = roaring.New()
+	for doc := range d.numDocs() {
+		var f byte
+		if flags != nil {
+			f = flags[doc]
+		} else {
+			f = classifyDoc(string(d.fileName(doc)), d.languageMap[d.getLanguage(doc)])
+		}
+		if f&docFlagTest != 0 {
+			d.testDocs.Add(doc)
+		}
+	}
+	if d.testDocs.IsEmpty() {
+		d.testDocs = nil
+		return
+	}
+	d.testDocs.RunOptimize()
+}
+
+// docFlagMatchTree returns the match tree of the documents with the flag of
+// q.
+func (d *indexData) docFlagMatchTree(q *query.DocFlag) (matchTree, error) {
+	switch q.Flag {
+	case query.DocFlagTest:
+		if d.testDocs == nil {
+			return &noMatchTree{Why: q.String()}, nil
+		}
+		return &docMatchTree{
+			reason:    q.String(),
+			numDocs:   d.numDocs(),
+			docs:      d.testDocs,
+			predicate: d.testDocs.Contains,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown document flag %q", q.Flag)
+}
@@ -0,0 +1,105 @@
+package index
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestIsTestFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, language string
+		want           bool
+	}{
+		{"test/util.c", "C", true},
+		{"server_test.go", "Go", true},
+		{"server.go", "Go", false},
+		{"testdata.go", "Go", false},
+		{"pkg/test_parse.py", "Python", true},
+		{"pkg/parse_test.py", "Python", true},
+		{"conftest.py", "Python", true},
+		{"pkg/contest.py", "Python", false},
+		{"src/app.test.ts", "TypeScript", true},
+		{"src/app.spec.js", "JavaScript", true},
+		{"src/__tests__/app.js", "JavaScript", true},
+		{"src/app.js", "JavaScript", false},
+		{"src/main/java/FooTest.java", "Java", true},
+		{"src/main/java/FooIT.java", "Java", true},
+		{"src/test/java/Helper.java", "Java", true},
+		{"src/main/java/Contest.java", "Java", false},
+		{"lib/foo_spec.rb", "Ruby", true},
+		{"Sources/FooTests.swift", "Swift", true},
+		{"crates/foo/tests/it.rs", "Rust", true},
+		{"src/lib.rs", "Rust", false},
+		{"base/strings_unittest.cc", "C++", true},
+		{"lib/foo_test.exs", "Elixir", true},
+		// Conventions only apply to their language.
+		{"FooTest.md", "Markdown", false},
+	} {
+		if got := isTestFile(tc.name, tc.language); got != tc.want {
+			t.Errorf("isTestFile(%q, %q) = %v, want %v", tc.name, tc.language, got, tc.want)
+		}
+	}
+}
+
+func TestSearchDocFlag(t *testing.T) {
+	content := []byte("needle")
+	docs := []Document{
+		{Name: "server.go", Language: "Go", Content: content},
+		{Name: "server_test.go", Language: "Go", Content: content},
+		{Name: "src/app.spec.ts", Language: "TypeScript", Content: content},
+		{Name: "README.md", Language: "Markdown", Content: content},
+	}
+
+	search := func(t *testing.T, b *ShardBuilder, q query.Q) []string {
+		t.Helper()
+		res := searchForTest(t, b, query.NewAnd(&query.Substring{Pattern: "needle"}, q))
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	test := func(t *testing.T, b *ShardBuilder) {
+		if got, want := search(t, b, &query.DocFlag{Flag: query.DocFlagTest}), []string{"server_test.go", "src/app.spec.ts"}; !slices.Equal(got, want) {
+			t.Errorf("is:test: got %v, want %v", got, want)
+		}
+		if got, want := search(t, b, &query.Not{Child: &query.DocFlag{Flag: query.DocFlagTest}}), []string{"README.md", "server.go"}; !slices.Equal(got, want) {
+			t.Errorf("-is:test: got %v, want %v", got, want)
+		}
+	}
+
+	t.Run("Indexed", func(t *testing.T) {
+		test(t, testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs...))
+	})
+
+	// Shards from before the docFlags section are classified when they are
+	// loaded.
+	t.Run("Classified", func(t *testing.T) {
+		b := testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs...)
+		b.featureVersion = docFlagsFeatureVersion - 1
+		b.docFlags = nil
+		test(t, b)
+	})
+
+	// For newer shards, an empty section means no document has flags, so
+	// they are not classified again.
+	t.Run("EmptySection", func(t *testing.T) {
+		b := testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs...)
+		clear(b.docFlags)
+		if got := search(t, b, &query.DocFlag{Flag: query.DocFlagTest}); len(got) != 0 {
+			t.Errorf("is:test: got %v, want no files", got)
+		}
+	})
+
+	t.Run("NoTests", func(t *testing.T) {
+		b := testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs[0], docs[3])
+		if got := search(t, b, &query.DocFlag{Flag: query.DocFlagTest}); len(got) != 0 {
+			t.Errorf("is:test: got %v, want no files", got)
+		}
+	})
+}
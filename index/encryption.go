@@ -0,0 +1,275 @@
+package index
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentKeys provides the keys which encrypt the file contents of shards at
+// rest. Each tenant has its own key, so the shards of a tenant can only be
+// read with the key of that tenant.
+type ContentKeys interface {
+	// ContentKey returns the AES key of the tenant, which is 16, 24 or 32
+	// bytes long.
+	ContentKey(tenantID int) ([]byte, error)
+}
+
+// EnvContentKeys reads the keys from the environment. The key of a tenant is
+// the base64 encoded ZOEKT_CONTENT_KEY_<tenant ID>. Tenants without their own
+// key use ZOEKT_CONTENT_KEY, which is enough for deployments with a single
+// tenant.
+type EnvContentKeys struct{}
+
+func (EnvContentKeys) ContentKey(tenantID int) ([]byte, error) {
+	env := "ZOEKT_CONTENT_KEY_" + strconv.Itoa(tenantID)
+	v := os.Getenv(env)
+	if v == "" {
+		env = "ZOEKT_CONTENT_KEY"
+		v = os.Getenv(env)
+	}
+	if v == "" {
+		return nil, fmt.Errorf("no content key for tenant %d: set ZOEKT_CONTENT_KEY_%d or ZOEKT_CONTENT_KEY", tenantID, tenantID)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	return key, nil
+}
+
+// CommandContentKeys gets the keys from a plugin, for example a wrapper
+// around a key management service. The command is run with the tenant ID as
+// its last argument, and prints the base64 encoded key of the tenant to
+// stdout. Keys are cached for the lifetime of the process.
+type CommandContentKeys struct {
+	Command []string
+
+	mu   sync.Mutex
+	keys map[int][]byte
+}
+
+func (c *CommandContentKeys) ContentKey(tenantID int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[tenantID]; ok {
+		return key, nil
+	}
+
+	args := append(c.Command[1:len(c.Command):len(c.Command)], strconv.Itoa(tenantID))
+	cmd := exec.Command(c.Command[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("content key command for tenant %d: %w: %s", tenantID, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("content key command for tenant %d: %w", tenantID, err)
+	}
+
+	if c.keys == nil {
+		c.keys = map[int][]byte{}
+	}
+	c.keys[tenantID] = key
+	return key, nil
+}
+
+var (
+	contentKeysMu sync.Mutex
+	contentKeys   ContentKeys
+)
+
+// SetContentKeys sets the keys used to write shards with Options.EncryptContents
+// and to read shards with encrypted contents. By default the keys come from
+// the plugin in ZOEKT_CONTENT_KEY_COMMAND if it is set, see
+// CommandContentKeys, and from the environment otherwise, see EnvContentKeys.
+func SetContentKeys(keys ContentKeys) {
+	contentKeysMu.Lock()
+	defer contentKeysMu.Unlock()
+	contentKeys = keys
+}
+
+func getContentKeys() ContentKeys {
+	contentKeysMu.Lock()
+	defer contentKeysMu.Unlock()
+	if contentKeys == nil {
+		if cmd := strings.Fields(os.Getenv("ZOEKT_CONTENT_KEY_COMMAND")); len(cmd) > 0 {
+			contentKeys = &CommandContentKeys{Command: cmd}
+		} else {
+			contentKeys = EnvContentKeys{}
+		}
+	}
+	return contentKeys
+}
+
+// contentCiphers holds the ciphers of the tenants of a shard.
+type contentCiphers map[int]cipher.AEAD
+
+// get returns the cipher of the tenant, creating it with keys if needed.
+func (c contentCiphers) get(keys ContentKeys, tenantID int) (cipher.AEAD, error) {
+	if aead, ok := c[tenantID]; ok {
+		return aead, nil
+	}
+	key, err := keys.ContentKey(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("content key of tenant %d: %w", tenantID, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c[tenantID] = aead
+	return aead, nil
+}
+
+// contentAdditionalData binds the encrypted content of a document to its
+// position in the shard, so the contents of two documents can't be swapped.
+func contentAdditionalData(doc uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, doc)
+}
+
+// encryptContent returns the nonce followed by the encrypted content of doc.
+func encryptContent(aead cipher.AEAD, doc uint32, content []byte) ([]byte, error) {
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(content)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out, content, contentAdditionalData(doc)), nil
+}
+
+// decryptContent reverses encryptContent.
+func decryptContent(aead cipher.AEAD, doc uint32, blob []byte) ([]byte, error) {
+	if len(blob) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted content of document %d is truncated", doc)
+	}
+	nonce, ciphertext := blob[:aead.NonceSize()], blob[aead.NonceSize():]
+	content, err := aead.Open(nil, nonce, ciphertext, contentAdditionalData(doc))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting document %d: %w", doc, err)
+	}
+	return content, nil
+}
+
+// writeEncryptedContents writes the encrypted file contents, and the
+// offsets of the plaintext contents which the rest of the shard refers to.
+func (b *ShardBuilder) writeEncryptedContents(w *writer, toc *indexTOC) error {
+	ciphers := contentCiphers{}
+	toc.fileContents.start(w)
+	var boundaries []uint64
+	var off uint64
+	for i, s := range b.contentStrings {
+		aead, err := ciphers.get(b.contentKeys, b.repoList[b.repos[i]].TenantID)
+		if err != nil {
+			return err
+		}
+		blob, err := encryptContent(aead, uint32(i), s.data)
+		if err != nil {
+			return err
+		}
+		toc.fileContents.addItem(w, blob)
+		boundaries = append(boundaries, off)
+		off += uint64(len(s.data))
+	}
+	toc.fileContents.end(w)
+
+	writeContentBoundaries(w, &toc.contentBoundaries, append(boundaries, off))
+	return nil
+}
+
+// writeContentBoundaries writes the offsets of the contents of the
+// documents, which are 64-bit if the contents are larger than 4GB.
+func writeContentBoundaries(w *writer, sec *simpleSection, boundaries []uint64) {
+	wide := boundaries[len(boundaries)-1] > math.MaxUint32
+	sec.start(w)
+	for _, b := range boundaries {
+		w.offset(b, wide)
+	}
+	sec.end(w)
+}
+
+// readContentBoundaries reads the section written by
+// writeContentBoundaries. It has an offset for each item of the
+// fileContents section plus the end of the contents, so its size tells the
+// width of the offsets.
+func readContentBoundaries(f IndexFile, toc *indexTOC) ([]uint64, error) {
+	wide := toc.contentBoundaries.sz == 8*uint64(len(toc.fileContents.offsets)+1)
+	return readSectionOffsets(f, toc.contentBoundaries, wide)
+}
+
+// readEncryptedContents sets up d to decrypt the file contents of a shard
+// written by writeEncryptedContents.
+func (d *indexData) readEncryptedContents(toc *indexTOC) error {
+	var err error
+	d.boundaries, err = readContentBoundaries(d.file, toc)
+	if err != nil {
+		return err
+	}
+	d.storedContentIndex = toc.fileContents.relativeIndex()
+
+	keys := getContentKeys()
+	d.contentCiphers = contentCiphers{}
+	for _, md := range d.repoMetaData {
+		if _, err := d.contentCiphers.get(keys, md.TenantID); err != nil {
+			return fmt.Errorf("shard has encrypted contents: %w", err)
+		}
+	}
+	return nil
+}
+
+// readDecryptedContents returns the decrypted contents of document i.
+func (d *indexData) readDecryptedContents(i uint32) ([]byte, error) {
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.boundariesStart + d.storedContentIndex[i],
+		sz:  d.storedContentIndex[i+1] - d.storedContentIndex[i],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decryptContent(d.contentCiphers[d.repoMetaData[d.repos[i]].TenantID], i, blob)
+}
+
+// verifyContentKeys decrypts the first document of each repository, so a
+// shard encrypted with other keys fails to load rather than failing to
+// match anything.
+func (d *indexData) verifyContentKeys() error {
+	seen := make(map[uint16]bool, len(d.repoMetaData))
+	for i, repo := range d.repos {
+		if seen[repo] {
+			continue
+		}
+		seen[repo] = true
+		if _, err := d.readDecryptedContents(uint32(i)); err != nil {
+			return fmt.Errorf("content key of tenant %d: %w", d.repoMetaData[repo].TenantID, err)
+		}
+	}
+	return nil
+}
+
+// HasEncryptedContents returns true if the file contents of the shard inf are
+// encrypted. They are encrypted with the keys of the tenants of its
+// repositories, so unlike other metadata, the tenants of such a shard can't
+// change without reindexing it.
+func HasEncryptedContents(inf IndexFile) (bool, error) {
+	rd := &reader{r: inf}
+	var toc indexTOC
+	if err := rd.readTOCSections(&toc, []string{"contentBoundaries", "contentSources"}); err != nil {
+		return false, err
+	}
+	return toc.contentBoundaries.sz > 0 && toc.contentSources.sz == 0, nil
+}
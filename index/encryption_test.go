@@ -0,0 +1,159 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type testContentKeys map[int][]byte
+
+func (k testContentKeys) ContentKey(tenantID int) ([]byte, error) {
+	if key, ok := k[tenantID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key for tenant %d", tenantID)
+}
+
+func setTestContentKeys(t *testing.T, keys ContentKeys) {
+	SetContentKeys(keys)
+	t.Cleanup(func() { SetContentKeys(nil) })
+}
+
+func TestEncryptedContents(t *testing.T) {
+	setTestContentKeys(t, testContentKeys{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 16),
+	})
+
+	repos := []*zoekt.Repository{
+		{Name: "repo1", TenantID: 1},
+		{Name: "repo2", TenantID: 2},
+	}
+	var docs [][]Document
+	for _, repo := range repos {
+		var repoDocs []Document
+		for i := range 20 {
+			repoDocs = append(repoDocs, Document{
+				Name:    fmt.Sprintf("%s/file%d.txt", repo.Name, i),
+				Content: []byte(fmt.Sprintf("grüße aus %s\n%s secret needle %d\n", repo.Name, strings.Repeat("ö", i*10), i)),
+			})
+		}
+		docs = append(docs, repoDocs)
+	}
+
+	plain := testShardBuilderCompound(t, repos, docs)
+	encrypted := testShardBuilderCompound(t, repos, docs)
+	encrypted.enableContentEncryption(getContentKeys())
+
+	var buf bytes.Buffer
+	if err := encrypted.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret needle")) {
+		t.Error("encrypted shard contains the plaintext contents")
+	}
+
+	search := func(s zoekt.Searcher) *zoekt.SearchResult {
+		t.Helper()
+		res, err := s.Search(context.Background(), &query.Substring{Pattern: "needle 1", Content: true}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res
+	}
+
+	want := search(searcherForTest(t, plain))
+	if len(want.Files) == 0 {
+		t.Fatal("no matches in the plaintext shard")
+	}
+	got := search(searcherForTest(t, encrypted))
+	if d := cmp.Diff(want.Files, got.Files); d != "" {
+		t.Errorf("search of encrypted shard (-want +got):\n%s", d)
+	}
+
+	t.Run("detect", func(t *testing.T) {
+		var plainBuf bytes.Buffer
+		if err := plain.Write(&plainBuf); err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			data []byte
+			want bool
+		}{
+			{plainBuf.Bytes(), false},
+			{buf.Bytes(), true},
+		} {
+			got, err := HasEncryptedContents(&memSeeker{tc.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("HasEncryptedContents: got %v, want %v", got, tc.want)
+			}
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		d := searcherForTest(t, encrypted).(*indexData)
+		merged, err := merge(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.contentKeys == nil {
+			t.Fatal("merging an encrypted shard gives an unencrypted shard")
+		}
+		got := search(searcherForTest(t, merged))
+		if d := cmp.Diff(want.Files, got.Files); d != "" {
+			t.Errorf("search of merged shard (-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		setTestContentKeys(t, testContentKeys{1: bytes.Repeat([]byte{1}, 32)})
+		if _, err := NewSearcher(&memSeeker{buf.Bytes()}); err == nil || !strings.Contains(err.Error(), "tenant 2") {
+			t.Errorf("got error %v, want missing key of tenant 2", err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		setTestContentKeys(t, testContentKeys{
+			1: bytes.Repeat([]byte{1}, 32),
+			2: bytes.Repeat([]byte{3}, 16),
+		})
+		if _, err := NewSearcher(&memSeeker{buf.Bytes()}); err == nil || !strings.Contains(err.Error(), "tenant 2") {
+			t.Errorf("got error %v, want wrong key of tenant 2", err)
+		}
+	})
+}
+
+func TestEnvContentKeys(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	t.Setenv("ZOEKT_CONTENT_KEY", base64.StdEncoding.EncodeToString(key1))
+	t.Setenv("ZOEKT_CONTENT_KEY_2", base64.StdEncoding.EncodeToString(key2))
+
+	for tenantID, want := range map[int][]byte{1: key1, 2: key2} {
+		got, err := EnvContentKeys{}.ContentKey(tenantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("tenant %d: got key %x, want %x", tenantID, got, want)
+		}
+	}
+
+	t.Setenv("ZOEKT_CONTENT_KEY", "")
+	if _, err := (EnvContentKeys{}).ContentKey(1); err == nil {
+		t.Error("got key of tenant 1 without ZOEKT_CONTENT_KEY")
+	}
+}
@@ -18,15 +18,19 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"regexp/syntax"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	enry_data "github.com/go-enry/go-enry/v2/data"
 	"github.com/grafana/regexp"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/languages"
 	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/query"
 )
@@ -53,7 +57,26 @@ func (d *indexData) simplifyMultiRepo(q query.Q, predicate func(*zoekt.Repositor
 	return &query.Const{Value: false}
 }
 
+// restrictCommits rewrites type:commit queries to match the documents
+// holding commit messages, and excludes those documents from all other
+// queries.
+func (d *indexData) restrictCommits(q query.Q) query.Q {
+	commitQuery := false
+	q = query.Map(q, func(q query.Q) query.Q {
+		if t, ok := q.(*query.Type); ok && t.Type == query.TypeCommit {
+			commitQuery = true
+			return query.NewAnd(&query.Language{Language: CommitLanguage}, t.Child)
+		}
+		return q
+	})
+	if _, ok := d.metaData.LanguageMap[CommitLanguage]; commitQuery || !ok {
+		return q
+	}
+	return query.NewAnd(q, &query.Not{Child: &query.Language{Language: CommitLanguage}})
+}
+
 func (d *indexData) simplify(in query.Q) query.Q {
+	in = d.restrictCommits(in)
 	eval := query.Map(in, func(q query.Q) query.Q {
 		switch r := q.(type) {
 		case *query.Repo:
@@ -78,18 +101,22 @@ func (d *indexData) simplify(in query.Q) query.Q {
 				return r.Set[repo.Name]
 			})
 		case query.RawConfig:
-			return d.simplifyMultiRepo(q, func(repo *zoekt.Repository) bool { return uint8(r)&encodeRawConfig(repo.RawConfig) == uint8(r) })
+			return d.simplifyMultiRepo(q, func(repo *zoekt.Repository) bool { return MatchRawConfig(r, repo.RawConfig) })
 		case *query.RepoIDs:
 			return d.simplifyMultiRepo(q, func(repo *zoekt.Repository) bool {
 				return r.Repos.Contains(repo.ID)
 			})
 		case *query.Language:
-			_, has := d.metaData.LanguageMap[r.Language]
+			_, has := d.languageCode(r.Language)
 			if !has && d.metaData.IndexFeatureVersion < 12 {
 				// For index files that haven't been re-indexed by go-enry,
 				// fall back to file-based matching and continue even if this
 				// repo doesn't have the specific language present.
-				extsForLang := enry_data.ExtensionsByLanguage[r.Language]
+				lang := r.Language
+				if canonical, ok := languages.GetLanguageByAlias(lang); ok {
+					lang = canonical
+				}
+				extsForLang := enry_data.ExtensionsByLanguage[lang]
 				if extsForLang != nil {
 					extFrags := make([]string, 0, len(extsForLang))
 					for _, ext := range extsForLang {
@@ -118,6 +145,14 @@ func (d *indexData) simplify(in query.Q) query.Q {
 			if !has {
 				return &query.Const{Value: false}
 			}
+		case *query.DocFlag:
+			if r.Flag == query.DocFlagTest && d.testDocs == nil {
+				return &query.Const{Value: false}
+			}
+		case *query.Meta:
+			if _, found := d.metaLabelSets(r); !found {
+				return &query.Const{Value: false}
+			}
 		}
 		return q
 	})
@@ -143,6 +178,10 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 	default:
 	}
 
+	if opts.PathSeparatorAgnostic {
+		q = query.Map(q, query.AnyPathSeparator)
+	}
+
 	q = d.simplify(q)
 	if c, ok := q.(*query.Const); ok && !c.Value {
 		return &res, nil
@@ -160,8 +199,30 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 		return nil, err
 	}
 
+	if opts.Within != nil {
+		docs, ok := opts.Within.Shards[d.resultSetKey()]
+		if !ok {
+			res.Stats.ShardsSkippedFilter++
+			return &res, nil
+		}
+		mt = &andMatchTree{children: []matchTree{
+			&docMatchTree{
+				reason:    "Within",
+				numDocs:   d.numDocs(),
+				docs:      docs,
+				predicate: docs.Contains,
+			},
+			mt,
+		}}
+	}
+
+	updateStats := updateMatchTreeStats
+	if opts.DetailedStats {
+		updateStats = updateAtomStats
+	}
+
 	// Capture the costs of construction before pruning
-	updateMatchTreeStats(mt, &res.Stats)
+	updateStats(mt, &res.Stats)
 
 	mt, err = pruneMatchTree(mt)
 	if err != nil {
@@ -190,6 +251,18 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 	docCount := uint32(len(d.fileBranchMasks))
 	lastDoc := int(-1)
 
+	// The average is the one of all shards searched, if the caller passed
+	// their statistics, so scores of different shards are comparable.
+	var averageFileLength float64
+	if opts.UseBM25Scoring {
+		averageFileLength = d.averageDocumentLength(ctx)
+	}
+
+	var resultSet *roaring.Bitmap
+	if opts.ReturnResultSet {
+		resultSet = roaring.New()
+	}
+
 nextFileMatch:
 	for {
 		canceled := false
@@ -206,26 +279,11 @@ nextFileMatch:
 
 		for ; nextDoc < docCount; nextDoc++ {
 			repoID := d.repos[nextDoc]
-			repoMetadata := &d.repoMetaData[repoID]
 
-			// Skip tombstoned repositories
-			if repoMetadata.Tombstone {
+			if !d.docVisible(ctx, nextDoc) {
 				continue
 			}
 
-			// 🚨 SECURITY: Skip documents that don't belong to the tenant. This check is
-			// necessary to prevent leaking data across tenants.
-			if !tenant.HasAccess(ctx, repoMetadata.TenantID) {
-				continue
-			}
-
-			// Skip documents that are tombstoned
-			if len(repoMetadata.FileTombstones) > 0 {
-				if _, tombstoned := repoMetadata.FileTombstones[string(d.fileName(nextDoc))]; tombstoned {
-					continue
-				}
-			}
-
 			// Skip documents over ShardRepoMaxMatchCount if specified.
 			if opts.ShardRepoMaxMatchCount > 0 {
 				if repoMatchCount >= opts.ShardRepoMaxMatchCount && repoID == lastRepoID {
@@ -251,6 +309,9 @@ nextFileMatch:
 
 		if canceled || (res.Stats.MatchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0) {
 			res.Stats.FilesSkipped += int(docCount - nextDoc)
+			if canceled {
+				res.Stats.ShardsInterrupted++
+			}
 			break
 		}
 
@@ -284,6 +345,11 @@ nextFileMatch:
 			FileName:           string(d.fileName(nextDoc)),
 			Checksum:           d.getChecksum(nextDoc),
 			Language:           d.languageMap[d.getLanguage(nextDoc)],
+			IndexTimeUnix:      md.IndexTime.Unix(),
+			Labels:             d.labels(nextDoc),
+		}
+		if opts.Provenance {
+			fileMatch.Provenance = d.provenance(cp)
 		}
 
 		if s := d.subRepos[nextDoc]; s > 0 {
@@ -307,25 +373,37 @@ nextFileMatch:
 		// Important invariant for performance: finalCands is sorted by offset and
 		// non-overlapping. gatherMatches respects this invariant and all later
 		// transformations respect this.
-		finalCands := d.gatherMatches(nextDoc, mt, known)
+		finalCands := d.gatherMatches(nextDoc, mt, known, opts.ScoreFieldClasses)
 
-		if opts.ChunkMatches {
+		if opts.FilesOnly {
+			// Only count the matches. Without LineMatches or ChunkMatches, the
+			// file is scored without per-line signals.
+			fileMatch.MatchCount = len(finalCands)
+		} else if opts.ChunkMatches {
 			fileMatch.ChunkMatches = cp.fillChunkMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts)
 		} else {
 			fileMatch.LineMatches = cp.fillMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts)
 		}
 
 		if opts.UseBM25Scoring {
-			d.scoreFilesUsingBM25(&fileMatch, nextDoc, finalCands, cp, opts)
+			d.scoreFilesUsingBM25(&fileMatch, nextDoc, finalCands, cp, averageFileLength, opts)
 		} else {
 			// Use the standard, non-experimental scoring method by default
-			d.scoreFile(&fileMatch, nextDoc, mt, known, opts)
+			var fcs *fieldClassScore
+			if opts.ScoreFieldClasses && !opts.FilesOnly && len(finalCands) > 0 {
+				s := cp.scoreFieldClasses(finalCands, fileMatch.Language, opts)
+				fcs = &s
+			}
+			d.scoreFile(&fileMatch, nextDoc, mt, known, fcs, opts)
+			if !opts.FilesOnly {
+				cp.scoreSnippets(&fileMatch, opts)
+			}
 		}
 
 		fileMatch.Branches = d.gatherBranches(nextDoc, mt, known)
 		sortMatchesByScore(fileMatch.LineMatches)
 		sortChunkMatchesByScore(fileMatch.ChunkMatches)
-		if opts.Whole {
+		if opts.Whole && !opts.FilesOnly {
 			fileMatch.Content = cp.data(false)
 		}
 
@@ -336,11 +414,16 @@ nextFileMatch:
 
 		repoMatchCount += len(fileMatch.LineMatches)
 		repoMatchCount += matchedChunkRanges
+		repoMatchCount += fileMatch.MatchCount
 
 		res.Files = append(res.Files, fileMatch)
+		if resultSet != nil {
+			resultSet.Add(nextDoc)
+		}
 
 		res.Stats.MatchCount += len(fileMatch.LineMatches)
 		res.Stats.MatchCount += matchedChunkRanges
+		res.Stats.MatchCount += fileMatch.MatchCount
 		res.Stats.FileCount++
 	}
 
@@ -352,14 +435,50 @@ nextFileMatch:
 		}
 	}
 
+	if resultSet != nil && !resultSet.IsEmpty() {
+		res.ResultSet = &zoekt.ResultSet{Shards: map[string]*roaring.Bitmap{d.resultSetKey(): resultSet}}
+	}
+
 	// Update stats based on work done during document search.
-	updateMatchTreeStats(mt, &res.Stats)
+	updateStats(mt, &res.Stats)
 
 	res.Stats.MatchTreeSearch = timer.Elapsed()
 
 	return &res, nil
 }
 
+// docVisible returns whether doc may be searched: it must not be tombstoned,
+// and must belong to the tenant of ctx.
+func (d *indexData) docVisible(ctx context.Context, doc uint32) bool {
+	repoMetadata := &d.repoMetaData[d.repos[doc]]
+
+	// Skip tombstoned repositories
+	if repoMetadata.Tombstone {
+		return false
+	}
+
+	// 🚨 SECURITY: Skip documents that don't belong to the tenant. This check is
+	// necessary to prevent leaking data across tenants.
+	if !tenant.HasAccess(ctx, repoMetadata.TenantID) {
+		return false
+	}
+
+	// Skip documents that are tombstoned
+	if len(repoMetadata.FileTombstones) > 0 {
+		if _, tombstoned := repoMetadata.FileTombstones[string(d.fileName(doc))]; tombstoned {
+			return false
+		}
+	}
+	return true
+}
+
+// resultSetKey identifies the shard in a zoekt.ResultSet. The shards of a
+// build share their metadata, so the key is the file name, and the index time
+// tells a rebuilt shard from the one it replaced.
+func (d *indexData) resultSetKey() string {
+	return filepath.Base(d.file.Name()) + "@" + strconv.FormatInt(d.metaData.IndexTime.UnixNano(), 10)
+}
+
 func addRepo(res *zoekt.SearchResult, repo *zoekt.Repository) {
 	if res.RepoURLs == nil {
 		res.RepoURLs = map[string]string{}
@@ -379,7 +498,10 @@ func addRepo(res *zoekt.SearchResult, repo *zoekt.Repository) {
 // If `merge` is set, overlapping and adjacent matches will be merged
 // into a single index. Otherwise, overlapping matches will be removed,
 // but adjacent matches will remain.
-func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchTree]bool) []*candidateMatch {
+//
+// If `preferSymbols` is set, symbol matches are kept over other matches of the
+// same range, as they know their symbol and field class.
+func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchTree]bool, preferSymbols bool) []*candidateMatch {
 	var cands []*candidateMatch
 	visitMatches(mt, known, 1, func(mt matchTree, scoreWeight float64) {
 		if smt, ok := mt.(*substrMatchTree); ok {
@@ -394,6 +516,9 @@ func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchT
 		if smt, ok := mt.(*symbolRegexpMatchTree); ok {
 			cands = append(cands, setScoreWeight(scoreWeight, smt.found)...)
 		}
+		if fzt, ok := mt.(*fuzzyMatchTree); ok {
+			cands = append(cands, setScoreWeight(scoreWeight, fzt.found)...)
+		}
 	})
 
 	// If we found no candidate matches at all, assume there must have been a match on filename.
@@ -413,7 +538,11 @@ func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchT
 
 	// Remove overlapping candidates. This guarantees that the matches
 	// are non-overlapping, but also preserves expected match counts.
-	sort.Sort((sortByOffsetSlice)(cands))
+	if preferSymbols {
+		sort.Sort(symbolsFirstByOffsetSlice{cands})
+	} else {
+		sort.Sort((sortByOffsetSlice)(cands))
+	}
 	res := cands[:0]
 	for i, c := range cands {
 		if i == 0 {
@@ -456,6 +585,18 @@ func (m sortByOffsetSlice) Less(i, j int) bool {
 	return m[i].byteOffset < m[j].byteOffset
 }
 
+// symbolsFirstByOffsetSlice sorts like sortByOffsetSlice, and puts symbol
+// matches before the other matches of the same range.
+type symbolsFirstByOffsetSlice struct{ sortByOffsetSlice }
+
+func (m symbolsFirstByOffsetSlice) Less(i, j int) bool {
+	a, b := m.sortByOffsetSlice[i], m.sortByOffsetSlice[j]
+	if a.fileName == b.fileName && a.byteOffset == b.byteOffset && a.byteMatchSz == b.byteMatchSz {
+		return a.symbol && !b.symbol
+	}
+	return m.sortByOffsetSlice.Less(i, j)
+}
+
 // setScoreWeight is a helper used by gatherMatches to set the weight based on
 // the score weight of the matchTree.
 func setScoreWeight(scoreWeight float64, cm []*candidateMatch) []*candidateMatch {
@@ -507,6 +648,7 @@ func (d *indexData) gatherBranches(docID uint32, mt matchTree, known map[matchTr
 		id <<= 1
 		mask >>= 1
 	}
+	sortBranches(branches, d.branchOrders[d.repos[docID]])
 
 	return branches
 }
@@ -601,8 +743,10 @@ func (d *indexData) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions
 // in place of the regex r. If singleLine = true, then the matchTree and all
 // its children only match terms on the same line. singleLine is used during
 // recursion to decide whether to return an andLineMatchTree (singleLine = true)
-// or a andMatchTree (singleLine = false).
-func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int, fileName bool, caseSensitive bool) (mt matchTree, isEqual bool, singleLine bool, err error) {
+// or a andMatchTree (singleLine = false). If symbol = true, r only matches
+// within symbols, so the literals are looked up in the symbol indexes of the
+// shard.
+func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int, fileName bool, caseSensitive bool, symbol bool) (mt matchTree, isEqual bool, singleLine bool, err error) {
 	// TODO - we could perhaps transform Begin/EndText in '\n'?
 	// TODO - we could perhaps transform CharClass in (OrQuery )
 	// if there are just a few runes, and part of a OpConcat?
@@ -611,21 +755,21 @@ func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int
 		s := string(r.Rune)
 		if len(s) >= minTextSize {
 			ignoreCase := syntax.FoldCase == (r.Flags & syntax.FoldCase)
-			mt, err := d.newSubstringMatchTree(&query.Substring{Pattern: s, FileName: fileName, CaseSensitive: !ignoreCase && caseSensitive})
+			mt, err := d.newSubstringMatchTree(&query.Substring{Pattern: s, FileName: fileName, CaseSensitive: !ignoreCase && caseSensitive}, symbol)
 			return mt, true, !strings.Contains(s, "\n"), err
 		}
 	case syntax.OpCapture:
-		return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
+		return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive, symbol)
 
 	case syntax.OpPlus:
-		return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
+		return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive, symbol)
 
 	case syntax.OpRepeat:
 		if r.Min == 1 {
-			return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
+			return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive, symbol)
 		} else if r.Min > 1 {
 			// (x){2,} can't be expressed precisely by the matchTree
-			mt, _, singleLine, err := d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
+			mt, _, singleLine, err := d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive, symbol)
 			return mt, false, singleLine, err
 		}
 	case syntax.OpConcat, syntax.OpAlternate:
@@ -633,7 +777,7 @@ func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int
 		isEq := true
 		singleLine = true
 		for _, sr := range r.Sub {
-			if sq, subIsEq, subSingleLine, err := d.regexpToMatchTreeRecursive(sr, minTextSize, fileName, caseSensitive); sq != nil {
+			if sq, subIsEq, subSingleLine, err := d.regexpToMatchTreeRecursive(sr, minTextSize, fileName, caseSensitive, symbol); sq != nil {
 				if err != nil {
 					return nil, false, false, err
 				}
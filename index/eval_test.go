@@ -64,7 +64,7 @@ func caseSensitiveSubstrMT(pattern string) matchTree {
 	mt, _ := d.newSubstringMatchTree(&query.Substring{
 		Pattern:       pattern,
 		CaseSensitive: true,
-	})
+	}, false)
 	return mt
 }
 
@@ -73,7 +73,7 @@ func substrMT(pattern string) matchTree {
 	mt, _ := d.newSubstringMatchTree(&query.Substring{
 		Pattern:       pattern,
 		CaseSensitive: false,
-	})
+	}, false)
 	return mt
 }
 
@@ -137,7 +137,7 @@ func TestRegexpParse(t *testing.T) {
 			Regexp:        r,
 			CaseSensitive: c.caseSensitive,
 		}
-		gotQuery, isEq, _, _ := d.regexpToMatchTreeRecursive(q.Regexp, 3, q.FileName, q.CaseSensitive)
+		gotQuery, isEq, _, _ := d.regexpToMatchTreeRecursive(q.Regexp, 3, q.FileName, q.CaseSensitive, false)
 		if !reflect.DeepEqual(c.query, gotQuery) {
 			printRegexp(t, r, 0)
 			t.Errorf("regexpToQuery(%q): got %v, want %v", c.in, gotQuery, c.query)
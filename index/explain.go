@@ -0,0 +1,201 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Explain writes an indented description of how the shard searcher s
+// evaluates q to w. It contains the simplified query, the match tree with the
+// ngrams chosen for each atom and the number of candidate documents per atom,
+// and the number of documents which were rejected after looking at their
+// content. s must be a searcher returned by NewSearcher.
+//
+// Explain is meant for debugging, it does the work of a full search and more.
+func Explain(ctx context.Context, w io.Writer, s zoekt.Searcher, q query.Q) error {
+	d, ok := s.(*indexData)
+	if !ok {
+		return fmt.Errorf("explain: %s is not a shard", s)
+	}
+	return d.explain(ctx, w, q)
+}
+
+func (d *indexData) explain(ctx context.Context, w io.Writer, q query.Q) error {
+	fmt.Fprintf(w, "%s: %d documents\n", d, d.numDocs())
+
+	sq := d.simplify(q)
+	fmt.Fprintf(w, "  simplified query:\n")
+	explainQuery(w, sq, 2)
+
+	if c, ok := sq.(*query.Const); ok && !c.Value {
+		fmt.Fprintf(w, "  pruned: no repository in the shard matches the repository, branch or language atoms\n")
+		return nil
+	}
+	if len(d.fileNameIndex) == 0 {
+		fmt.Fprintf(w, "  pruned: shard is empty\n")
+		return nil
+	}
+
+	sq = query.Map(sq, query.ExpandFileContent)
+
+	// We consume the iterators of the atoms to count candidates, so the match
+	// tree used for reporting is not used for searching.
+	mt, err := d.newMatchTree(sq, matchTreeOpt{})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "  match tree:\n")
+	d.explainMatchTree(w, mt, 2)
+
+	if pruned, err := pruneMatchTree(mt); err != nil {
+		return err
+	} else if pruned == nil {
+		fmt.Fprintf(w, "  pruned: an atom required by the query has no ngram candidates\n")
+		return nil
+	}
+
+	sr, err := d.Search(ctx, q, &zoekt.SearchOptions{})
+	if err != nil {
+		return err
+	}
+	st := sr.Stats
+	fmt.Fprintf(w, "  evaluation:\n")
+	fmt.Fprintf(w, "    documents considered: %d\n", st.FilesConsidered)
+	fmt.Fprintf(w, "    documents rejected after verification: %d\n", st.FilesConsidered-st.FileCount)
+	fmt.Fprintf(w, "    documents skipped: %d\n", st.FilesSkipped)
+	fmt.Fprintf(w, "    documents matched: %d (%d matches)\n", st.FileCount, st.MatchCount)
+	return nil
+}
+
+// explainQuery writes q as an indented tree.
+func explainQuery(w io.Writer, q query.Q, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch s := q.(type) {
+	case *query.And:
+		fmt.Fprintf(w, "%sand\n", indent)
+		for _, ch := range s.Children {
+			explainQuery(w, ch, depth+1)
+		}
+	case *query.Or:
+		fmt.Fprintf(w, "%sor\n", indent)
+		for _, ch := range s.Children {
+			explainQuery(w, ch, depth+1)
+		}
+	case *query.Not:
+		fmt.Fprintf(w, "%snot\n", indent)
+		explainQuery(w, s.Child, depth+1)
+	case *query.Boost:
+		fmt.Fprintf(w, "%sboost(%.2f)\n", indent, s.Boost)
+		explainQuery(w, s.Child, depth+1)
+	case *query.Budget:
+		fmt.Fprintf(w, "%sbudget(%s)\n", indent, s.Timeout)
+		explainQuery(w, s.Child, depth+1)
+	case *query.Type:
+		fmt.Fprintf(w, "%stype(%d)\n", indent, s.Type)
+		explainQuery(w, s.Child, depth+1)
+	default:
+		fmt.Fprintf(w, "%s%s\n", indent, q)
+	}
+}
+
+// explainMatchTree writes mt as an indented tree. Leaves backed by the ngram
+// index are annotated with the ngrams used and their candidate documents.
+func (d *indexData) explainMatchTree(w io.Writer, mt matchTree, depth int) {
+	indent := strings.Repeat("  ", depth)
+	children := func(label string, chs ...matchTree) {
+		fmt.Fprintf(w, "%s%s\n", indent, label)
+		for _, ch := range chs {
+			d.explainMatchTree(w, ch, depth+1)
+		}
+	}
+
+	switch s := mt.(type) {
+	case *andMatchTree:
+		children("and", s.children...)
+	case *andLineMatchTree:
+		children("and (same line)", s.children...)
+	case *orMatchTree:
+		children("or", s.children...)
+	case *notMatchTree:
+		children("not", s.child)
+	case *noVisitMatchTree:
+		children("filter (no matches collected)", s.matchTree)
+	case *fileNameMatchTree:
+		children("filename only", s.child)
+	case *boostMatchTree:
+		children(fmt.Sprintf("boost(%.2f)", s.boost), s.child)
+	case *budgetMatchTree:
+		children(fmt.Sprintf("budget(%s)", s.timeout), s.child)
+	case *symbolSubstrMatchTree:
+		children("symbol", s.substrMatchTree)
+	case *symbolRegexpMatchTree:
+		children(fmt.Sprintf("symbol re(%s)", s.regexp), s.matchTree)
+	case *substrMatchTree:
+		f := ""
+		if s.fileName {
+			f = "f"
+		}
+		fmt.Fprintf(w, "%s%ssubstr(%q) %s\n", indent, f, s.query.Pattern, d.explainCandidates(s.matchIterator))
+	case *fuzzyMatchTree:
+		if s.symbols == nil {
+			fmt.Fprintf(w, "%s%s: verified against symbols\n", indent, s)
+		} else {
+			fmt.Fprintf(w, "%s%s: %d documents\n", indent, s, len(s.symbols))
+		}
+	case *regexpMatchTree, *wordMatchTree:
+		fmt.Fprintf(w, "%s%s: verified against content\n", indent, s)
+	case *bruteForceMatchTree:
+		fmt.Fprintf(w, "%sall: %d candidates\n", indent, d.numDocs())
+	default:
+		fmt.Fprintf(w, "%s%s\n", indent, s)
+	}
+}
+
+// explainCandidates describes the ngrams chosen for it and counts its
+// candidate documents. It consumes it.
+func (d *indexData) explainCandidates(it matchIterator) string {
+	if res, ok := it.(*ngramIterationResults); ok {
+		it = res.matchIterator
+	}
+
+	switch s := it.(type) {
+	case *noMatchTree:
+		return fmt.Sprintf("no candidates (%s)", s.Why)
+	case *ngramDocIterator:
+		var ngrams []string
+		collectNgrams(s.iter, &ngrams)
+
+		count := 0
+		for doc := s.nextDoc(); doc < d.numDocs(); doc = s.nextDoc() {
+			count++
+			s.prepare(doc + 1)
+		}
+		return fmt.Sprintf("ngrams=[%s] candidates=%d", strings.Join(ngrams, " "), count)
+	default:
+		return fmt.Sprint(it)
+	}
+}
+
+func collectNgrams(it hitIterator, ngrams *[]string) {
+	switch s := it.(type) {
+	case *distanceHitIterator:
+		collectNgrams(s.i1, ngrams)
+		collectNgrams(s.i2, ngrams)
+	case *mergingIterator:
+		// case variants of the same ngram.
+		var variants []string
+		for _, it := range s.iters {
+			collectNgrams(it, &variants)
+		}
+		*ngrams = append(*ngrams, strings.Join(variants, "|"))
+	case *compressedPostingIterator:
+		*ngrams = append(*ngrams, fmt.Sprintf("%q", s.what.String()))
+	case *inMemoryIterator:
+		*ngrams = append(*ngrams, fmt.Sprintf("%q", s.what.String()))
+	}
+}
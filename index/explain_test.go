@@ -0,0 +1,56 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestExplain(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "f2", Content: []byte("needle\nhaystack")},
+		Document{Name: "f3", Content: []byte("nothing to see")},
+	)
+	s := searcherForTest(t, b)
+
+	explain := func(q query.Q) string {
+		t.Helper()
+		var sb strings.Builder
+		if err := Explain(context.Background(), &sb, s, q); err != nil {
+			t.Fatal(err)
+		}
+		return sb.String()
+	}
+
+	// f2 contains both literals, but not on the same line.
+	got := explain(&query.Regexp{Regexp: mustParseRE("needle.*haystack"), Content: true, CaseSensitive: true})
+	for _, want := range []string{
+		"3 documents",
+		`substr("needle") ngrams=`,
+		`substr("haystack") ngrams=`,
+		"candidates=2",
+		"documents considered: 2",
+		"documents rejected after verification: 1",
+		"documents matched: 1",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+
+	got = explain(&query.Substring{Pattern: "xyzzy"})
+	if !strings.Contains(got, "no candidates") || !strings.Contains(got, "pruned:") {
+		t.Errorf("expected shard to be pruned by the ngram filter:\n%s", got)
+	}
+
+	got = explain(query.NewAnd(&query.Repo{Regexp: regexp.MustCompile("other")}, &query.Substring{Pattern: "needle"}))
+	if !strings.Contains(got, "pruned: no repository") {
+		t.Errorf("expected shard to be pruned by repo filter:\n%s", got)
+	}
+}
@@ -0,0 +1,95 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ContentExtractor converts documents which are not text, like PDFs or office
+// documents, into text which is indexed instead of their content. The
+// extractor is a command which reads the content on stdin and writes the text
+// to stdout. Searches see the lines of the text, so line numbers of matches
+// refer to the extracted text rather than the original file.
+type ContentExtractor struct {
+	// Pattern is a glob pattern with the syntax of Options.LargeFiles. The
+	// extractor is applied to the documents whose names match it.
+	Pattern string
+
+	// Command is the extractor and its arguments. The name of the document
+	// is passed in the ZOEKT_DOCUMENT_NAME environment variable.
+	Command []string
+}
+
+// extractTimeout bounds the time an extractor may take for a document.
+const extractTimeout = time.Minute
+
+// ParseContentExtractor parses an extractor in the format of the
+// -content_extractor flag: the pattern, "=", and the command with its
+// arguments separated by spaces, eg. "**/*.pdf=pdftotext - -".
+func ParseContentExtractor(spec string) (ContentExtractor, error) {
+	pattern, command, ok := strings.Cut(spec, "=")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || pattern == "" || len(strings.Fields(command)) == 0 {
+		return ContentExtractor{}, fmt.Errorf("content extractor %q: want PATTERN=COMMAND", spec)
+	}
+	return ContentExtractor{Pattern: pattern, Command: strings.Fields(command)}, nil
+}
+
+// contentExtractors parses Options.ContentExtractors.
+func (o *Options) contentExtractors() ([]ContentExtractor, error) {
+	var extractors []ContentExtractor
+	for _, spec := range o.ContentExtractors {
+		e, err := ParseContentExtractor(spec)
+		if err != nil {
+			return nil, fmt.Errorf("builder: %w", err)
+		}
+		extractors = append(extractors, e)
+	}
+	return extractors, nil
+}
+
+// extract replaces the content of doc with the text of the first extractor
+// matching its name. It returns a skip reason if the extractor failed.
+func extract(extractors []ContentExtractor, doc *Document) string {
+	if doc.SkipReason != "" {
+		return ""
+	}
+	for _, e := range extractors {
+		if m, _ := doublestar.PathMatch(e.Pattern, doc.Name); !m {
+			continue
+		}
+		text, err := e.run(doc)
+		if err != nil {
+			return fmt.Sprintf("content extractor %s failed: %v", e.Command[0], err)
+		}
+		doc.Content = text
+		return ""
+	}
+	return ""
+}
+
+func (e ContentExtractor) run(doc *Document) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), extractTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Env = append(os.Environ(), "ZOEKT_DOCUMENT_NAME="+doc.Name)
+	cmd.Stdin = bytes.NewReader(doc.Content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
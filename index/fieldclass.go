@@ -0,0 +1,86 @@
+package index
+
+import (
+	"github.com/sourcegraph/zoekt"
+)
+
+// fieldClass is the class of the field a candidate match is in, see
+// zoekt.SearchOptions.ScoreFieldClasses. Higher classes win ties.
+type fieldClass int
+
+const (
+	fieldClassContent fieldClass = iota
+	fieldClassFileName
+	fieldClassSymbol
+)
+
+var fieldClassNames = [...]string{
+	fieldClassContent:  zoekt.FieldClassContent,
+	fieldClassFileName: zoekt.FieldClassFileName,
+	fieldClassSymbol:   zoekt.FieldClassSymbol,
+}
+
+// fieldClassWeights multiply the scores of the matches of each class. They
+// keep a symbol definition matched by sym: above the same match found by a
+// content query, which gets the same symbol signals.
+var fieldClassWeights = [...]float64{
+	fieldClassContent:  1,
+	fieldClassFileName: 1.1,
+	fieldClassSymbol:   1.2,
+}
+
+func (c fieldClass) String() string {
+	return fieldClassNames[c]
+}
+
+func candidateFieldClass(m *candidateMatch) fieldClass {
+	switch {
+	case m.fileName:
+		return fieldClassFileName
+	case m.symbol:
+		return fieldClassSymbol
+	default:
+		return fieldClassContent
+	}
+}
+
+// scoreFieldClasses returns the class whose best candidate match has the
+// highest weighted score, with that score. Unlike fillMatches, it also scores
+// the file name matches of files with content matches.
+//
+// Invariant: ms is sorted and non-overlapping, and len(ms) > 0.
+func (p *contentProvider) scoreFieldClasses(ms []*candidateMatch, language string, opts *zoekt.SearchOptions) fieldClassScore {
+	var byClass [len(fieldClassNames)][]*candidateMatch
+	for _, m := range ms {
+		c := candidateFieldClass(m)
+		byClass[c] = append(byClass[c], m)
+	}
+
+	best := fieldClassScore{class: fieldClassContent, score: -1}
+	for c, cands := range byClass {
+		if len(cands) == 0 {
+			continue
+		}
+		var score float64
+		if fieldClass(c) == fieldClassFileName {
+			ls, _ := p.scoreLine(cands, language, -1 /* must pass -1 for filenames */, opts)
+			score = ls.score
+		} else {
+			cs, _ := p.scoreChunk(cands, language, opts)
+			score = cs.score
+		}
+		// The classes are visited in increasing order, so higher classes win
+		// ties.
+		if score *= fieldClassWeights[c]; score >= best.score {
+			best = fieldClassScore{class: fieldClass(c), score: score}
+		}
+	}
+	return best
+}
+
+// fieldClassScore is the best weighted match of a file, see
+// scoreFieldClasses.
+type fieldClassScore struct {
+	class fieldClass
+	score float64
+}
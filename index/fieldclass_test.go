@@ -0,0 +1,70 @@
+package index
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestScoreFieldClasses(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "a.go", Content: []byte("func parseQuery() {}\n")},
+		Document{
+			Name:            "b.go",
+			Content:         []byte("func parseQuery() {}\n"),
+			Symbols:         []DocumentSection{{Start: 5, End: 15}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}},
+		},
+		Document{Name: "parseQuery.go", Content: []byte("package query\n")},
+		Document{Name: "c.go", Content: []byte("// calls parseQuery\n")},
+	)
+	q, err := query.Parse("sym:parseQuery or file:parseQuery or parseQuery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(opts zoekt.SearchOptions) []*zoekt.FileMatch {
+		t.Helper()
+		res, err := searcherForTest(t, b).Search(context.Background(), q, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []*zoekt.FileMatch
+		for i := range res.Files {
+			files = append(files, &res.Files[i])
+		}
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Score > files[j].Score })
+		return files
+	}
+
+	type result struct{ FileName, FieldClass string }
+	got := func(files []*zoekt.FileMatch) []result {
+		var rs []result
+		for _, f := range files {
+			rs = append(rs, result{f.FileName, f.FieldClass})
+		}
+		return rs
+	}
+
+	files := search(zoekt.SearchOptions{ScoreFieldClasses: true})
+	want := []result{
+		{"b.go", zoekt.FieldClassSymbol},
+		{"parseQuery.go", zoekt.FieldClassFileName},
+		{"a.go", zoekt.FieldClassContent},
+		{"c.go", zoekt.FieldClassContent},
+	}
+	if d := cmp.Diff(want, got(files)); d != "" {
+		t.Errorf("ScoreFieldClasses (-want +got):\n%s", d)
+	}
+
+	for _, f := range search(zoekt.SearchOptions{}) {
+		if f.FieldClass != "" {
+			t.Errorf("%s: got field class %q without ScoreFieldClasses", f.FileName, f.FieldClass)
+		}
+	}
+}
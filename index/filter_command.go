@@ -0,0 +1,95 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The decisions a filter command writes on the first line of its output.
+const (
+	// FilterIndex indexes the document unchanged.
+	FilterIndex = "index"
+	// FilterSkip skips the document, like a document which is too large. The
+	// rest of the line is the skip reason. The file name is still indexed.
+	FilterSkip = "skip"
+	// FilterTransform indexes the rest of the output instead of the content
+	// of the document.
+	FilterTransform = "transform"
+)
+
+// filterCommandTimeout bounds the time a filter command may take for a
+// document.
+const filterCommandTimeout = time.Minute
+
+// NewFilterCommand returns a DocumentFilter which asks an external command
+// whether to index, skip or transform each document, so policies excluding
+// content can be enforced without changing the builders. command is the
+// command and its arguments separated by spaces, as in Options.FilterCommand.
+//
+// The command is run once per document. It reads the content on stdin, and
+// gets the name of the document and repository in the ZOEKT_DOCUMENT_NAME and
+// ZOEKT_REPOSITORY_NAME environment variables. It writes FilterIndex,
+// FilterSkip or FilterTransform on the first line of stdout. Documents are
+// skipped if the command fails or its decision is unknown.
+func NewFilterCommand(command string, repository string) (DocumentFilter, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("filter command %q: no command", command)
+	}
+	return func(doc *Document) {
+		if doc.SkipReason != "" {
+			return
+		}
+		if reason := runFilterCommand(args, repository, doc); reason != "" {
+			doc.SkipReason = reason
+		}
+	}, nil
+}
+
+// runFilterCommand applies the decision of the command to doc. It returns a
+// skip reason if the document must not be indexed.
+func runFilterCommand(args []string, repository string, doc *Document) string {
+	ctx, cancel := context.WithTimeout(context.Background(), filterCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"ZOEKT_DOCUMENT_NAME="+doc.Name,
+		"ZOEKT_REPOSITORY_NAME="+repository,
+	)
+	cmd.Stdin = bytes.NewReader(doc.Content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return fmt.Sprintf("filter command %s failed: %v", args[0], err)
+	}
+
+	line, rest, _ := bytes.Cut(stdout.Bytes(), []byte("\n"))
+	decision, reason, _ := strings.Cut(strings.TrimSpace(string(line)), " ")
+	switch decision {
+	case FilterIndex:
+		return ""
+	case FilterSkip:
+		if reason = strings.TrimSpace(reason); reason == "" {
+			reason = "skipped by filter command"
+		}
+		return reason
+	case FilterTransform:
+		// The symbols of the original content don't fit the new one.
+		doc.Content = rest
+		doc.Symbols = nil
+		doc.SymbolsMetaData = nil
+		return ""
+	default:
+		return fmt.Sprintf("filter command %s failed: unknown decision %q", args[0], line)
+	}
+}
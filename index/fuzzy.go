@@ -0,0 +1,228 @@
+package index
+
+import (
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// fuzzyMaxRunes is the length of the longest symbol names in the fuzzy
+// symbol index. The neighborhood of a name grows with its length, and long
+// names are rarely searched for by hand.
+const fuzzyMaxRunes = 64
+
+// fuzzyNeighborhood returns the hashes of the deletion neighborhood of name:
+// the case folded name, and the folded name with one of its runes deleted.
+// Two names are at most one edit apart only if their neighborhoods
+// intersect, so the fuzzy symbol index maps the neighborhood of each symbol
+// name to the symbol.
+func fuzzyNeighborhood(name []byte) []uint64 {
+	folded := []rune(string(toLower(name)))
+	hashes := []uint64{hashSymbol([]byte(string(folded)))}
+	var buf []byte
+	for i := range folded {
+		// Deleting either of two equal adjacent runes gives the same name.
+		if i > 0 && folded[i] == folded[i-1] {
+			continue
+		}
+		buf = buf[:0]
+		for j, r := range folded {
+			if j != i {
+				buf = utf8.AppendRune(buf, r)
+			}
+		}
+		hashes = append(hashes, hashSymbol(buf))
+	}
+	return hashes
+}
+
+// fuzzySymbolEntries returns the entries of the fuzzy symbol index for the
+// symbol with index sym and name name.
+func fuzzySymbolEntries(name []byte, sym uint32) []symbolHashEntry {
+	if utf8.RuneCount(name) > fuzzyMaxRunes {
+		return nil
+	}
+	hashes := fuzzyNeighborhood(name)
+	entries := make([]symbolHashEntry, 0, len(hashes))
+	for _, h := range hashes {
+		entries = append(entries, symbolHashEntry{hash: h, sym: sym})
+	}
+	return entries
+}
+
+// withinOneEdit returns true if a and b differ by at most one inserted,
+// deleted or replaced rune, or by two swapped adjacent runes.
+func withinOneEdit(a, b []rune) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	switch len(b) - len(a) {
+	case 0:
+		if i == len(a) || slices.Equal(a[i+1:], b[i+1:]) {
+			return true
+		}
+		return i+1 < len(a) && a[i] == b[i+1] && a[i+1] == b[i] && slices.Equal(a[i+2:], b[i+2:])
+	case 1:
+		return slices.Equal(a[i:], b[i+1:])
+	}
+	return false
+}
+
+// fuzzyCandidates returns the indexes of the symbols in the fuzzy symbol
+// index which may be at most one edit away from pattern, by document.
+func (d *indexData) fuzzyCandidates(pattern string) map[uint32][]uint32 {
+	cands := map[uint32][]uint32{}
+	var syms []uint32
+	for _, h := range fuzzyNeighborhood([]byte(pattern)) {
+		syms = append(syms, d.fuzzySymbols.lookupHash(h)...)
+	}
+	slices.Sort(syms)
+	for _, sym := range slices.Compact(syms) {
+		doc, secIdx := d.symbolDoc(sym)
+		cands[doc] = append(cands[doc], secIdx)
+	}
+	return cands
+}
+
+// newFuzzyMatchTree returns the match tree of q. With a fuzzy symbol index,
+// the symbols it matches are found when it is built, by comparing the names
+// of the few candidates to the pattern. Without one, the symbols of each
+// document are compared when it is evaluated, so other atoms rule out
+// documents first and the search stops at its deadline.
+func (d *indexData) newFuzzyMatchTree(q *query.Fuzzy) (matchTree, error) {
+	pattern := []rune(string(toLower([]byte(q.Pattern))))
+	if d.fuzzySymbols == nil {
+		return &fuzzyMatchTree{
+			docMatchTree: docMatchTree{
+				reason:  q.String(),
+				numDocs: d.numDocs(),
+				predicate: func(docID uint32) bool {
+					return d.fileEndSymbol[docID+1] > d.fileEndSymbol[docID]
+				},
+			},
+			pattern: q.Pattern,
+			folded:  pattern,
+		}, nil
+	}
+
+	symbols := map[uint32][]uint32{}
+	docs := roaring.New()
+	for doc, secIdxs := range d.fuzzyCandidates(q.Pattern) {
+		secs, _, err := d.readDocSections(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		content, err := d.readContents(doc)
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(secIdxs)
+		for _, i := range secIdxs {
+			name := []rune(string(toLower(sectionSlice(content, secs[i]))))
+			if withinOneEdit(pattern, name) {
+				symbols[doc] = append(symbols[doc], i)
+			}
+		}
+		if len(symbols[doc]) > 0 {
+			docs.Add(doc)
+		}
+	}
+	if len(symbols) == 0 {
+		return &noMatchTree{Why: q.String()}, nil
+	}
+
+	return &fuzzyMatchTree{
+		docMatchTree: docMatchTree{
+			reason:  q.String(),
+			numDocs: d.numDocs(),
+			docs:    docs,
+			predicate: func(docID uint32) bool {
+				return len(symbols[docID]) > 0
+			},
+		},
+		pattern: q.Pattern,
+		folded:  pattern,
+		symbols: symbols,
+	}, nil
+}
+
+// fuzzyMatchTree matches the symbols at most one edit away from a fuzzy:
+// pattern.
+type fuzzyMatchTree struct {
+	docMatchTree
+
+	pattern string
+	folded  []rune
+
+	// symbols holds the indexes of the matching symbols of each document
+	// within the document. It is nil for shards without a fuzzy symbol
+	// index, whose symbols are compared to folded by matches.
+	symbols map[uint32][]uint32
+
+	// mutable
+	evaluated bool
+	found     []*candidateMatch
+}
+
+func (t *fuzzyMatchTree) prepare(doc uint32) {
+	t.found = t.found[:0]
+	t.evaluated = false
+	t.docMatchTree.prepare(doc)
+}
+
+func (t *fuzzyMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.evaluated {
+		return matchesStateForSlice(t.found)
+	}
+
+	sections := cp.docSections()
+	found := t.found[:0]
+	addSymbol := func(i uint32) {
+		sec := sections[i]
+		found = append(found, &candidateMatch{
+			byteOffset:  sec.Start,
+			byteMatchSz: sec.End - sec.Start,
+			symbol:      true,
+			symbolIdx:   i,
+		})
+	}
+
+	if t.symbols != nil {
+		for _, i := range t.symbols[cp.idx] {
+			addSymbol(i)
+		}
+	} else {
+		// The names of the symbols are in the content.
+		if cost < costContent {
+			return matchesRequiresHigherCost
+		}
+		// A name with n runes has between n and utf8.UTFMax*n bytes.
+		n := len(t.folded)
+		content := cp.data(false)
+		for i, sec := range sections {
+			sz := int(sec.End - sec.Start)
+			if sz < n-1 || sz > utf8.UTFMax*(n+1) {
+				continue
+			}
+			name := []rune(string(toLower(sectionSlice(content, sec))))
+			if withinOneEdit(t.folded, name) {
+				addSymbol(uint32(i))
+			}
+		}
+	}
+	t.found = found
+	t.evaluated = true
+	return matchesStateForSlice(found)
+}
+
+func (t *fuzzyMatchTree) String() string {
+	return fmt.Sprintf("fuzzy(%q)", t.pattern)
+}
@@ -0,0 +1,132 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestWithinOneEdit(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"parseQuery", "parseQuery", true},
+		{"parseQuery", "parseQury", true},
+		{"parseQuery", "parseQuuery", true},
+		{"parseQuery", "parseQuerx", true},
+		{"parseQuery", "parseQeury", true},
+		{"parseQuery", "aparseQuery", true},
+		{"parseQuery", "parseQueryX", true},
+		{"parseQuery", "parsQeury", false},
+		{"parseQuery", "parseQ", false},
+		{"parseQuery", "parseQxyry", false},
+		{"ab", "ba", true},
+		{"abc", "bca", false},
+		{"", "a", true},
+	} {
+		if got := withinOneEdit([]rune(tc.a), []rune(tc.b)); got != tc.want {
+			t.Errorf("withinOneEdit(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := withinOneEdit([]rune(tc.b), []rune(tc.a)); got != tc.want {
+			t.Errorf("withinOneEdit(%q, %q) = %v, want %v", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	docs := []Document{
+		{
+			Name:            "parse.go",
+			Content:         []byte("func parseQuery() {}\n"),
+			Symbols:         []DocumentSection{{Start: 5, End: 15}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}},
+		},
+		{
+			Name:            "Parse.java",
+			Content:         []byte("class ParseQuery {}\n"),
+			Symbols:         []DocumentSection{{Start: 6, End: 16}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "class"}},
+		},
+		{
+			Name:            "other.go",
+			Content:         []byte("func parseQueries() {}\n// parseQuery\n"),
+			Symbols:         []DocumentSection{{Start: 5, End: 17}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}},
+		},
+		{Name: "README.md", Content: []byte("parseQeury\n")},
+	}
+
+	plain := testShardBuilder(t, nil, docs...)
+	indexed, err := NewShardBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed.enableFuzzySymbols()
+	for _, d := range docs {
+		if err := indexed.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := searcherForTest(t, indexed).(*indexData); d.fuzzySymbols == nil {
+		t.Fatal("shard built with fuzzy symbols has no fuzzy symbol index")
+	}
+
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"parseQuery", []string{"Parse.java:ParseQuery", "parse.go:parseQuery"}},
+		{"parseQeury", []string{"Parse.java:ParseQuery", "parse.go:parseQuery"}},
+		{"parseQury", []string{"Parse.java:ParseQuery", "parse.go:parseQuery"}},
+		{"PARSEQUERYY", []string{"Parse.java:ParseQuery", "parse.go:parseQuery"}},
+		{"parseQueriez", []string{"other.go:parseQueries"}},
+		{"parsQeury", nil},
+	} {
+		for _, b := range []*ShardBuilder{plain, indexed} {
+			res := searchForTest(t, b, &query.Fuzzy{Pattern: tc.pattern})
+			var got []string
+			for _, f := range res.Files {
+				for _, lm := range f.LineMatches {
+					for _, fr := range lm.LineFragments {
+						if fr.SymbolInfo == nil {
+							t.Errorf("%s: match in %s is not a symbol", tc.pattern, f.FileName)
+						}
+						got = append(got, f.FileName+":"+string(lm.Line[fr.LineOffset:fr.LineOffset+fr.MatchLength]))
+					}
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("fuzzy:%s with index %v: got %v, want %v", tc.pattern, b == indexed, got, tc.want)
+			}
+		}
+	}
+
+	// fuzzy: combines with other atoms.
+	q := query.NewAnd(&query.Fuzzy{Pattern: "parseQeury"}, &query.Language{Language: "Go"})
+	res, err := searcherForTest(t, indexed).Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "parse.go" {
+		t.Errorf("fuzzy:parseQeury lang:Go: got %v", res.Files)
+	}
+
+	// Without a fuzzy index, the symbols of a document are only compared once
+	// the other atoms match it.
+	q = query.NewAnd(&query.Fuzzy{Pattern: "parseQeury"}, &query.Substring{Pattern: "class", Content: true})
+	res, err = searcherForTest(t, plain).Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "Parse.java" {
+		t.Errorf("fuzzy:parseQeury class: got %v", res.Files)
+	}
+	if res.Stats.FilesLoaded != 1 {
+		t.Errorf("fuzzy:parseQeury class: loaded %d files, want 1", res.Stats.FilesLoaded)
+	}
+}
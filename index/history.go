@@ -0,0 +1,215 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// RepoHistoryFile is the name of the file in the index directory holding the
+// history of the indexed repositories, one JSON RepoSnapshot per line.
+const RepoHistoryFile = "repo-history.jsonl"
+
+// maxRepoHistoryBytes bounds the size of the repository history. Beyond it,
+// the oldest snapshots are dropped, except the latest one of each
+// repository, until it is half as large.
+const maxRepoHistoryBytes = 8 << 20
+
+// RepoSnapshot is the indexed state of a repository from a point in time on,
+// eg. for incident reviews of which code was searchable when.
+type RepoSnapshot struct {
+	// Time is when the snapshot was taken: when new shards of the repository
+	// were written, or its shards were deleted or restored.
+	Time time.Time
+
+	Name string
+	ID   uint32 `json:",omitempty"`
+
+	// TenantID is the tenant of the repository, which only it may see the
+	// history of.
+	TenantID int `json:",omitempty"`
+
+	// Branches and IndexTime are those of the shards. They are unset for
+	// deletions.
+	Branches  []zoekt.RepositoryBranch `json:",omitempty"`
+	IndexTime time.Time
+
+	// Deleted is set once the shards of the repository were deleted.
+	Deleted bool `json:",omitempty"`
+}
+
+// key identifies the repository of s. IDs are only set by Sourcegraph.
+func (s *RepoSnapshot) key() string {
+	if s.ID != 0 {
+		return fmt.Sprint(s.ID)
+	}
+	return s.Name
+}
+
+// AppendRepoHistory adds s to the repository history in indexDir. It is safe
+// to call from concurrent processes.
+func AppendRepoHistory(indexDir string, s RepoSnapshot) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	lock, err := os.OpenFile(filepath.Join(indexDir, RepoHistoryFile+".lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	path := filepath.Join(indexDir, RepoHistoryFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Size() <= maxRepoHistoryBytes {
+		return err
+	}
+	return compactRepoHistory(path, maxRepoHistoryBytes/2)
+}
+
+// compactRepoHistory drops the oldest snapshots of the history in path until
+// it takes at most maxBytes. The latest snapshot of each repository is kept,
+// so the current state stays known.
+func compactRepoHistory(path string, maxBytes int) error {
+	snapshots, err := readRepoHistory(path)
+	if err != nil {
+		return err
+	}
+
+	latest := map[string]int{}
+	for i, s := range snapshots {
+		latest[s.key()] = i
+	}
+	lines := make([][]byte, len(snapshots))
+	size := 0
+	for i, s := range snapshots {
+		if lines[i], err = json.Marshal(s); err != nil {
+			return err
+		}
+		size += len(lines[i]) + 1
+	}
+	for i, s := range snapshots {
+		if size <= maxBytes {
+			break
+		}
+		if latest[s.key()] != i {
+			size -= len(lines[i]) + 1
+			lines[i] = nil
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if l != nil {
+			buf.Write(l)
+			buf.WriteByte('\n')
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadRepoHistory returns the snapshots of the repository history in
+// indexDir, oldest first.
+func ReadRepoHistory(indexDir string) ([]RepoSnapshot, error) {
+	snapshots, err := readRepoHistory(filepath.Join(indexDir, RepoHistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return snapshots, err
+}
+
+func readRepoHistory(path string) ([]RepoSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshots []RepoSnapshot
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var s RepoSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			// A line cut short by a crash.
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, scanner.Err()
+}
+
+// ReposAt returns the latest snapshot of each repository taken at or before
+// t, sorted by name, leaving out the deleted repositories. Since old
+// snapshots are dropped, the state long ago may lack repositories.
+func ReposAt(snapshots []RepoSnapshot, t time.Time) []RepoSnapshot {
+	latest := map[string]RepoSnapshot{}
+	for _, s := range snapshots {
+		if s.Time.After(t) {
+			continue
+		}
+		if prev, ok := latest[s.key()]; !ok || !s.Time.Before(prev.Time) {
+			latest[s.key()] = s
+		}
+	}
+
+	repos := make([]RepoSnapshot, 0, len(latest))
+	for _, s := range latest {
+		if !s.Deleted {
+			repos = append(repos, s)
+		}
+	}
+	slices.SortFunc(repos, func(a, b RepoSnapshot) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+	return repos
+}
+
+// recordRepoHistory adds the repository of the finished build to the
+// repository history. Failures are logged, since the shards are already in
+// place.
+func (b *Builder) recordRepoHistory() {
+	desc := b.opts.RepositoryDescription
+	err := AppendRepoHistory(b.opts.IndexDir, RepoSnapshot{
+		Time:      time.Now(),
+		Name:      desc.Name,
+		ID:        desc.ID,
+		TenantID:  desc.TenantID,
+		Branches:  desc.Branches,
+		IndexTime: b.indexTime,
+	})
+	if err != nil {
+		log.Printf("recording repository history in %s: %v", b.opts.IndexDir, err)
+	}
+}
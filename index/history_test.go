@@ -0,0 +1,133 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestRepoHistory(t *testing.T) {
+	dir := t.TempDir()
+	if got, err := ReadRepoHistory(dir); err != nil || got != nil {
+		t.Fatalf("got %v, %v, want no history", got, err)
+	}
+
+	t0 := time.Unix(1000, 0).UTC()
+	at := func(secs int) time.Time { return t0.Add(time.Duration(secs) * time.Second) }
+	snapshots := []RepoSnapshot{
+		{Time: at(0), Name: "a", ID: 1, Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}}, IndexTime: at(0)},
+		{Time: at(10), Name: "b", ID: 2, Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "w1"}}, IndexTime: at(10)},
+		{Time: at(20), Name: "a", ID: 1, Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "v2"}}, IndexTime: at(20)},
+		{Time: at(30), Name: "b", ID: 2, Deleted: true},
+	}
+	for _, s := range snapshots {
+		if err := AppendRepoHistory(dir, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ReadRepoHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(snapshots, got); d != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", d)
+	}
+
+	for _, tc := range []struct {
+		at   time.Time
+		want []RepoSnapshot
+	}{
+		{at(-1), []RepoSnapshot{}},
+		{at(10), []RepoSnapshot{snapshots[0], snapshots[1]}},
+		{at(25), []RepoSnapshot{snapshots[2], snapshots[1]}},
+		{at(30), []RepoSnapshot{snapshots[2]}},
+	} {
+		if d := cmp.Diff(tc.want, ReposAt(got, tc.at)); d != "" {
+			t.Errorf("ReposAt(%v) mismatch (-want +got):\n%s", tc.at, d)
+		}
+	}
+}
+
+func TestCompactRepoHistory(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 50 {
+		s := RepoSnapshot{Time: time.Unix(int64(i), 0).UTC(), Name: "busy", IndexTime: time.Unix(int64(i), 0).UTC()}
+		if i == 0 {
+			s.Name = "quiet"
+		}
+		if err := AppendRepoHistory(dir, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, RepoHistoryFile)
+	if err := compactRepoHistory(path, 500); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() > 500 {
+		t.Fatalf("got %v, %v, want at most 500 bytes", fi.Size(), err)
+	}
+
+	got, err := ReadRepoHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := ReposAt(got, time.Unix(100, 0))
+	if len(repos) != 2 || repos[0].Name != "busy" || repos[0].Time.Unix() != 49 || repos[1].Name != "quiet" {
+		t.Errorf("got %v, want the latest snapshots of busy and quiet", repos)
+	}
+}
+
+func TestBuilderRepoHistory(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			Name:     "repo",
+			Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "abc"}},
+		},
+		RepoHistory: true,
+	}
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("f.go", []byte("package main")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadRepoHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "repo" || got[0].Branches[0].Version != "abc" || got[0].IndexTime.IsZero() {
+		t.Fatalf("got %v, want one snapshot of repo", got)
+	}
+
+	// Without the option, nothing is recorded.
+	opts.IndexDir = t.TempDir()
+	opts.RepoHistory = false
+	b, err = NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(opts.IndexDir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), RepoHistoryFile) {
+			t.Errorf("found %s without RepoHistory", e.Name())
+		}
+	}
+}
@@ -91,16 +91,16 @@ func (i *distanceHitIterator) next(limit uint32) {
 	i.findNext()
 }
 
-func (d *indexData) newDistanceTrigramIter(ng1, ng2 ngram, dist uint32, caseSensitive, fileName bool) (hitIterator, error) {
+func (d *indexData) newDistanceTrigramIter(ng1, ng2 ngram, dist uint32, lookup ngramLookup) (hitIterator, error) {
 	if dist == 0 {
 		return nil, fmt.Errorf("d == 0")
 	}
 
-	i1, err := d.trigramHitIterator(ng1, caseSensitive, fileName)
+	i1, err := d.trigramHitIterator(ng1, lookup)
 	if err != nil {
 		return nil, err
 	}
-	i2, err := d.trigramHitIterator(ng2, caseSensitive, fileName)
+	i2, err := d.trigramHitIterator(ng2, lookup)
 	if err != nil {
 		return nil, err
 	}
@@ -111,17 +111,13 @@ func (d *indexData) newDistanceTrigramIter(ng1, ng2 ngram, dist uint32, caseSens
 	}, nil
 }
 
-func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (hitIterator, error) {
-	variants := []ngram{ng}
-	if !caseSensitive {
-		variants = generateCaseNgrams(ng)
-	}
+func (d *indexData) trigramHitIterator(ng ngram, lookup ngramLookup) (hitIterator, error) {
+	variants := lookup.variants(ng)
 
 	iters := make([]hitIterator, 0, len(variants))
 	ngramLookups := 0
-	ngrams := d.ngrams(fileName)
 	for _, v := range variants {
-		sec := ngrams.Get(v)
+		sec := lookup.index.Get(v)
 		ngramLookups++
 		blob, err := d.readSectionBlob(sec)
 		if err != nil {
@@ -182,6 +178,10 @@ type compressedPostingIterator struct {
 	ngramLookups     int
 	_first           uint32
 	what             ngram
+
+	// postingsSize is the size of the posting list in bytes. It is reported
+	// once in AtomStats.Ngrams.
+	postingsSize int
 }
 
 func newCompressedPostingIterator(b []byte, w ngram) *compressedPostingIterator {
@@ -191,6 +191,7 @@ func newCompressedPostingIterator(b []byte, w ngram) *compressedPostingIterator
 		blob:             b[sz:],
 		indexBytesLoaded: sz,
 		what:             w,
+		postingsSize:     len(b),
 	}
 }
 
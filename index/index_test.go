@@ -18,15 +18,19 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"maps"
 	"reflect"
 	"regexp/syntax"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/experiments"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -41,6 +45,7 @@ func clearScores(r *zoekt.SearchResult) {
 			r.Files[i].ChunkMatches[j].BestLineMatch = 0
 		}
 		r.Files[i].Checksum = nil
+		r.Files[i].IndexTimeUnix = 0
 		r.Files[i].Debug = ""
 	}
 }
@@ -192,12 +197,12 @@ func (s *memSeeker) Name() string {
 }
 
 func (s *memSeeker) Close() {}
-func (s *memSeeker) Read(off, sz uint32) ([]byte, error) {
+func (s *memSeeker) Read(off, sz uint64) ([]byte, error) {
 	return s.data[off : off+sz], nil
 }
 
-func (s *memSeeker) Size() (uint32, error) {
-	return uint32(len(s.data)), nil
+func (s *memSeeker) Size() (uint64, error) {
+	return uint64(len(s.data)), nil
 }
 
 func TestNewlines(t *testing.T) {
@@ -213,9 +218,13 @@ func TestNewlines(t *testing.T) {
 			FileName: "filename",
 			LineMatches: []zoekt.LineMatch{{
 				LineFragments: []zoekt.LineFragmentMatch{{
-					Offset:      8,
-					LineOffset:  2,
-					MatchLength: 3,
+					Offset:           8,
+					LineOffset:       2,
+					MatchLength:      3,
+					Column:           3,
+					EndColumn:        6,
+					DisplayColumn:    3,
+					EndDisplayColumn: 6,
 				}},
 				Line:       []byte("line2\n"),
 				LineStart:  6,
@@ -238,13 +247,14 @@ func TestNewlines(t *testing.T) {
 			ChunkMatches: []zoekt.ChunkMatch{{
 				Content: []byte("line2\n"),
 				ContentStart: zoekt.Location{
-					ByteOffset: 6,
-					LineNumber: 2,
-					Column:     1,
+					ByteOffset:    6,
+					LineNumber:    2,
+					Column:        1,
+					DisplayColumn: 1,
 				},
 				Ranges: []zoekt.Range{{
-					Start: zoekt.Location{ByteOffset: 8, LineNumber: 2, Column: 3},
-					End:   zoekt.Location{ByteOffset: 11, LineNumber: 2, Column: 6},
+					Start: zoekt.Location{ByteOffset: 8, LineNumber: 2, Column: 3, DisplayColumn: 3},
+					End:   zoekt.Location{ByteOffset: 11, LineNumber: 2, Column: 6, DisplayColumn: 6},
 				}},
 			}},
 		}}
@@ -255,6 +265,135 @@ func TestNewlines(t *testing.T) {
 	})
 }
 
+func TestMatchColumns(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		// The line of the match has tabs and runes of two bytes.
+		Document{Name: "filename", Content: []byte("x\n\tgrüß\tneedle\n")})
+	opts := zoekt.SearchOptions{TabWidth: 4}
+
+	t.Run("LineMatches", func(t *testing.T) {
+		sres := searchForTest(t, b, &query.Substring{Pattern: "needle"}, opts)
+		if len(sres.Files) != 1 || len(sres.Files[0].LineMatches) != 1 {
+			t.Fatalf("got %v, want 1 match in 1 file", sres.Files)
+		}
+
+		got := sres.Files[0].LineMatches[0].LineFragments
+		want := []zoekt.LineFragmentMatch{{
+			LineOffset:       8,
+			Offset:           10,
+			MatchLength:      6,
+			Column:           7,
+			EndColumn:        13,
+			DisplayColumn:    13,
+			EndDisplayColumn: 19,
+		}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("ChunkMatches", func(t *testing.T) {
+		chunkOpts := opts
+		chunkOpts.ChunkMatches = true
+		sres := searchForTest(t, b, &query.Substring{Pattern: "needle"}, chunkOpts)
+		if len(sres.Files) != 1 || len(sres.Files[0].ChunkMatches) != 1 {
+			t.Fatalf("got %v, want 1 match in 1 file", sres.Files)
+		}
+
+		got := sres.Files[0].ChunkMatches[0].Ranges
+		want := []zoekt.Range{{
+			Start: zoekt.Location{ByteOffset: 10, LineNumber: 2, Column: 7, DisplayColumn: 13},
+			End:   zoekt.Location{ByteOffset: 16, LineNumber: 2, Column: 13, DisplayColumn: 19},
+		}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestFileMatchIndexTime(t *testing.T) {
+	b := testShardBuilder(t, nil, Document{Name: "f", Content: []byte("content")})
+	b.IndexTime = time.Unix(1700000000, 0)
+
+	searcher := searcherForTest(t, b)
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "content"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(res.Files))
+	}
+	if got := res.Files[0].IndexTimeUnix; got != 1700000000 {
+		t.Errorf("got IndexTimeUnix %d, want 1700000000", got)
+	}
+	if got, want := res.Files[0].Checksum, b.checksums[:8]; !bytes.Equal(got, want) {
+		t.Errorf("got Checksum %x, want %x", got, want)
+	}
+}
+
+func TestCommitDocuments(t *testing.T) {
+	code := Document{Name: "fix.go", Content: []byte("// TICKET-1: handle nil\n")}
+	commit := Document{Name: "0123abcd", Content: []byte("Fix TICKET-1\n"), Language: CommitLanguage}
+
+	fileNames := func(b *ShardBuilder, q query.Q) []string {
+		t.Helper()
+		var names []string
+		for _, f := range searchForTest(t, b, q).Files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	withCommits := testShardBuilder(t, nil, code, commit)
+	withoutCommits := testShardBuilder(t, nil, code)
+
+	for _, tc := range []struct {
+		b    *ShardBuilder
+		q    query.Q
+		want []string
+	}{
+		{withCommits, &query.Substring{Pattern: "TICKET-1"}, []string{"fix.go"}},
+		{withCommits, &query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "TICKET-1"}}, []string{"0123abcd"}},
+		{withCommits, &query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "nil"}}, nil},
+		{withoutCommits, &query.Substring{Pattern: "TICKET-1"}, []string{"fix.go"}},
+		{withoutCommits, &query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "TICKET-1"}}, nil},
+	} {
+		if got := fileNames(tc.b, tc.q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestFileMatchIndexTimeCompound(t *testing.T) {
+	var ds []*indexData
+	for i, name := range []string{"repo-a", "repo-b"} {
+		b := testShardBuilder(t, &zoekt.Repository{Name: name}, Document{Name: "f", Content: []byte("content")})
+		b.IndexTime = time.Unix(int64(i+1)*1000000000, 0)
+		ds = append(ds, searcherForTest(t, b).(*indexData))
+	}
+	merged, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := merged.IndexTime, time.Unix(2000000000, 0); !got.Equal(want) {
+		t.Errorf("got compound shard index time %v, want the latest of its repositories %v", got, want)
+	}
+
+	// Every repository keeps the index time of its own shard.
+	res, err := searcherForTest(t, merged).Search(context.Background(), &query.Substring{Pattern: "content"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, f := range res.Files {
+		got[f.Repository] = f.IndexTimeUnix
+	}
+	want := map[string]int64{"repo-a": 1000000000, "repo-b": 2000000000}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected index times (-want, +got):\n%s", d)
+	}
+}
+
 // A result spanning multiple lines should have LineMatches that only cover
 // single lines.
 func TestQueryNewlines(t *testing.T) {
@@ -287,6 +426,116 @@ func TestQueryNewlines(t *testing.T) {
 	})
 }
 
+// Regular expressions which can match a newline must not be restricted to
+// candidates on a single line.
+func TestQueryNewlinesRegexp(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "filename", Content: []byte("func foo() {\n\treturn bar\n}\n")})
+
+	for _, re := range []string{`(?s)foo.*bar`, `foo\(\)\s+\{\s+return`, `\{\n\treturn`} {
+		q := &query.Regexp{Regexp: mustParseRE(re), Content: true}
+		sres := searchForTest(t, b, q, chunkOpts)
+		if len(sres.Files) != 1 || len(sres.Files[0].ChunkMatches) != 1 {
+			t.Fatalf("%s: got %#v, want exactly one chunk match", re, sres.Files)
+		}
+		cm := sres.Files[0].ChunkMatches[0]
+		if len(cm.Ranges) != 1 || cm.Ranges[0].Start.LineNumber != 1 || cm.Ranges[0].End.LineNumber != 2 {
+			t.Errorf("%s: got ranges %+v, want one range from line 1 to line 2", re, cm.Ranges)
+		}
+	}
+}
+
+func TestQueryCRLF(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "filename", Content: []byte("ab foo\r\nbar foo\r\nfoo")})
+
+	type match struct {
+		Line, Column, EndColumn, Length int
+	}
+	lineMatches := func(q query.Q) []match {
+		t.Helper()
+		sres := searchForTest(t, b, q)
+		if len(sres.Files) != 1 {
+			t.Fatalf("%s: got %d file matches, want exactly one", q, len(sres.Files))
+		}
+		var got []match
+		for _, lm := range sres.Files[0].LineMatches {
+			for _, f := range lm.LineFragments {
+				got = append(got, match{lm.LineNumber, int(f.Column), int(f.EndColumn), f.MatchLength})
+			}
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		re   string
+		want []match
+	}{
+		{`foo$`, []match{{1, 4, 7, 3}, {2, 5, 8, 3}, {3, 1, 4, 3}}},
+		{`o.*`, []match{{1, 5, 7, 2}, {2, 6, 8, 2}, {3, 2, 4, 2}}},
+		{`foo\s+bar`, []match{{1, 4, 7, 3}, {2, 1, 4, 3}}},
+		// Carriage returns are only matched if the pattern asks for them.
+		{`foo\r$`, []match{{1, 4, 8, 4}, {2, 5, 9, 4}}},
+	} {
+		q, err := query.Parse("case:yes " + tc.re)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(tc.want, lineMatches(q)); d != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.re, d)
+		}
+	}
+
+	q, err := query.Parse("foo$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sres := searchForTest(t, b, q, chunkOpts)
+	if len(sres.Files) != 1 || len(sres.Files[0].ChunkMatches) != 3 {
+		t.Fatalf("got %#v, want three chunk matches", sres.Files)
+	}
+	r := sres.Files[0].ChunkMatches[0].Ranges[0]
+	if r.Start.ByteOffset != 3 || r.End.ByteOffset != 6 || r.End.LineNumber != 1 {
+		t.Errorf("got range %+v, want bytes [3, 6) on line 1", r)
+	}
+}
+
+func TestPathSeparatorAgnostic(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: `src\main.go`, Content: []byte("package main\n")},
+		Document{Name: "src/util.go", Content: []byte("package main\n")})
+
+	for _, tc := range []struct {
+		q            string
+		wantStrict   []string
+		wantAgnostic []string
+	}{
+		{`file:src/`, []string{"src/util.go"}, []string{"src/util.go", `src\main.go`}},
+		{`file:src\\`, []string{`src\main.go`}, []string{"src/util.go", `src\main.go`}},
+		{`file:src/main`, nil, []string{`src\main.go`}},
+	} {
+		q, err := query.Parse(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, agnostic := range []bool{false, true} {
+			want := tc.wantStrict
+			if agnostic {
+				want = tc.wantAgnostic
+			}
+			sres := searchForTest(t, b, q, zoekt.SearchOptions{PathSeparatorAgnostic: agnostic})
+			var got []string
+			for _, f := range sres.Files {
+				got = append(got, f.FileName)
+			}
+			slices.Sort(got)
+			if d := cmp.Diff(want, got); d != "" {
+				t.Errorf("%s (agnostic=%v): mismatch (-want +got):\n%s", tc.q, agnostic, d)
+			}
+		}
+	}
+}
+
 var chunkOpts = zoekt.SearchOptions{ChunkMatches: true}
 
 func searchForTest(t *testing.T, b *ShardBuilder, q query.Q, o ...zoekt.SearchOptions) *zoekt.SearchResult {
@@ -390,12 +639,13 @@ func wordsAsSymbols(doc Document) Document {
 
 func TestSearchStats(t *testing.T) {
 	ctx := context.Background()
-	searcher := searcherForTest(t, testShardBuilder(t, nil,
+	b := testShardBuilder(t, nil,
 		wordsAsSymbols(Document{Name: "f1", Content: []byte("x banana y")}),
 		wordsAsSymbols(Document{Name: "f2", Content: []byte("x apple y")}),
 		wordsAsSymbols(Document{Name: "f3", Content: []byte("x banana apple y")}),
 		// --------------------------------------------------0123456789012345
-	))
+	)
+	searcher := searcherForTest(t, b)
 
 	andQuery := query.NewAnd(
 		&query.Substring{
@@ -590,6 +840,9 @@ func TestSearchStats(t *testing.T) {
 
 		for _, tc := range cases {
 			t.Run(tc.Name, func(t *testing.T) {
+				// A new searcher has no cached ngram selections, which would
+				// save lookups.
+				searcher := searcherForTest(t, b)
 				sres, err := searcher.Search(ctx, tc.Q, &chunkOpts)
 				if err != nil {
 					t.Fatal(err)
@@ -602,6 +855,66 @@ func TestSearchStats(t *testing.T) {
 	})
 }
 
+func TestDetailedStats(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("abcXYZdef")},
+		// rejected after comparing the content.
+		Document{Name: "f2", Content: []byte("abcQQQdef")},
+		// makes the ngrams between abc and def more frequent.
+		Document{Name: "f3", Content: []byte(strings.Repeat("bcX cXY XYZ YZd Zde ", 3))},
+		// hit crossing the boundary between f4 and f5.
+		Document{Name: "f4", Content: []byte("xxabcQQQ")},
+		Document{Name: "f5", Content: []byte("defxx")},
+	)
+	searcher := searcherForTest(t, b)
+
+	q := &query.Substring{Pattern: "abcXYZdef", CaseSensitive: true, Content: true}
+	sres, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{DetailedStats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sres.Files) != 1 || sres.Files[0].FileName != "f1" {
+		t.Fatalf("got files %v, want f1", sres.Files)
+	}
+
+	if len(sres.Stats.Atoms) != 1 {
+		t.Fatalf("got atoms %+v, want 1", sres.Stats.Atoms)
+	}
+	got := sres.Stats.Atoms[0]
+	for ng, sz := range got.Ngrams {
+		if sz <= 0 {
+			t.Errorf("ngram %q: got posting list size %d", ng, sz)
+		}
+	}
+	want := zoekt.AtomStats{
+		Atom:                  `case_content_substr:"abcXYZdef"`,
+		NgramLookups:          sres.Stats.NgramLookups,
+		IndexBytesLoaded:      sres.Stats.IndexBytesLoaded,
+		CandidatesOutOfBounds: 1,
+		NgramMatches:          2,
+		CandidatesRejected:    1,
+		FilesEvaluated:        2,
+		FilesMatched:          1,
+	}
+	if d := cmp.Diff(want, got, cmpopts.IgnoreFields(zoekt.AtomStats{}, "Ngrams")); d != "" {
+		t.Errorf("unexpected AtomStats (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff([]string{"abc", "def"}, slices.Sorted(maps.Keys(got.Ngrams))); d != "" {
+		t.Errorf("unexpected ngrams (-want +got):\n%s", d)
+	}
+
+	// The other statistics don't depend on DetailedStats. The search uses a new
+	// searcher, which has no cached ngram selections.
+	plain, err := searcherForTest(t, b).Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sres.Stats.Atoms = nil
+	if d := cmp.Diff(plain.Stats, sres.Stats, cmpopts.IgnoreFields(zoekt.Stats{}, "MatchTreeConstruction", "MatchTreeSearch")); d != "" {
+		t.Errorf("unexpected Stats (-want +got):\n%s", d)
+	}
+}
+
 func TestAndNegateSearch(t *testing.T) {
 	b := testShardBuilder(t, nil,
 		Document{Name: "f1", Content: []byte("x banana y")},
@@ -716,9 +1029,13 @@ func TestFileSearch(t *testing.T) {
 		want := zoekt.LineMatch{
 			Line: []byte("banana"),
 			LineFragments: []zoekt.LineFragmentMatch{{
-				Offset:      1,
-				LineOffset:  1,
-				MatchLength: 4,
+				Offset:           1,
+				LineOffset:       1,
+				MatchLength:      4,
+				Column:           2,
+				EndColumn:        6,
+				DisplayColumn:    2,
+				EndDisplayColumn: 6,
 			}},
 			FileName: true,
 		}
@@ -742,10 +1059,10 @@ func TestFileSearch(t *testing.T) {
 		got := matches[0].ChunkMatches[0]
 		want := zoekt.ChunkMatch{
 			Content:      []byte("banana"),
-			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
 			Ranges: []zoekt.Range{{
-				Start: zoekt.Location{ByteOffset: 1, LineNumber: 1, Column: 2},
-				End:   zoekt.Location{ByteOffset: 5, LineNumber: 1, Column: 6},
+				Start: zoekt.Location{ByteOffset: 1, LineNumber: 1, Column: 2, DisplayColumn: 2},
+				End:   zoekt.Location{ByteOffset: 5, LineNumber: 1, Column: 6, DisplayColumn: 6},
 			}},
 			FileName: true,
 		}
@@ -766,10 +1083,10 @@ func TestFileSearch(t *testing.T) {
 		got := matches[0].ChunkMatches[0]
 		want := zoekt.ChunkMatch{
 			Content:      []byte("banana"),
-			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
 			Ranges: []zoekt.Range{{
-				Start: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
-				End:   zoekt.Location{ByteOffset: 6, LineNumber: 1, Column: 7},
+				Start: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
+				End:   zoekt.Location{ByteOffset: 6, LineNumber: 1, Column: 7, DisplayColumn: 7},
 			}},
 			FileName: true,
 		}
@@ -995,6 +1312,47 @@ func TestSearchMatchAllRegexp(t *testing.T) {
 	})
 }
 
+func TestCorpusStats(t *testing.T) {
+	d := searcherForTest(t, testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle")},
+		Document{Name: "f2", Content: []byte("needle haystack")},
+	)).(*indexData)
+
+	want := CorpusStats{Documents: 2, ContentBytes: 21}
+	if got := d.CorpusStats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Shards written before the statistics were stored compute them.
+	d.metaData.Documents, d.metaData.ContentBytes = 0, 0
+	if got := d.CorpusStats(); got != want {
+		t.Errorf("got %+v without metadata, want %+v", got, want)
+	}
+
+	// The statistics in the context replace the ones of the shard.
+	q := &query.Substring{Pattern: "needle"}
+	score := func(ctx context.Context) float64 {
+		res, err := d.Search(ctx, q, &zoekt.SearchOptions{UseBM25Scoring: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range res.Files {
+			if f.FileName == "f2" {
+				return f.Score
+			}
+		}
+		t.Fatal("f2 not found")
+		return 0
+	}
+	own := score(context.Background())
+	if got := score(WithCorpusStats(context.Background(), want)); got != own {
+		t.Errorf("got score %f with the shard's statistics, want %f", got, own)
+	}
+	if got := score(WithCorpusStats(context.Background(), CorpusStats{Documents: 10, ContentBytes: 1000})); got <= own {
+		t.Errorf("got score %f in a corpus of longer documents, want more than %f", got, own)
+	}
+}
+
 func TestSearchBM25MatchScores(t *testing.T) {
 	ctx := context.Background()
 	searcher := searcherForTest(t, testShardBuilder(t, nil,
@@ -1382,9 +1740,13 @@ func TestRegexp(t *testing.T) {
 		got := sres.Files[0].LineMatches[0]
 		want := zoekt.LineMatch{
 			LineFragments: []zoekt.LineFragmentMatch{{
-				LineOffset:  3,
-				Offset:      3,
-				MatchLength: 11,
+				LineOffset:       3,
+				Offset:           3,
+				MatchLength:      11,
+				Column:           4,
+				EndColumn:        15,
+				DisplayColumn:    4,
+				EndDisplayColumn: 15,
 			}},
 			Line:       content,
 			FileName:   false,
@@ -1411,10 +1773,10 @@ func TestRegexp(t *testing.T) {
 		got := sres.Files[0].ChunkMatches[0]
 		want := zoekt.ChunkMatch{
 			Content:      content,
-			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
 			Ranges: []zoekt.Range{{
-				Start: zoekt.Location{ByteOffset: 3, LineNumber: 1, Column: 4},
-				End:   zoekt.Location{ByteOffset: 14, LineNumber: 1, Column: 15},
+				Start: zoekt.Location{ByteOffset: 3, LineNumber: 1, Column: 4, DisplayColumn: 4},
+				End:   zoekt.Location{ByteOffset: 14, LineNumber: 1, Column: 15, DisplayColumn: 15},
 			}},
 		}
 
@@ -2011,7 +2373,7 @@ func TestListRepos(t *testing.T) {
 					cmpopts.IgnoreFields(zoekt.RepoListEntry{}, "IndexMetadata"),
 					cmpopts.IgnoreFields(zoekt.RepoStats{}, "IndexBytes"),
 					cmpopts.IgnoreFields(zoekt.Repository{}, "SubRepoMap"),
-					cmpopts.IgnoreFields(zoekt.Repository{}, "priority"),
+					cmpopts.IgnoreFields(zoekt.Repository{}, "priority", "IndexTime"),
 				}
 				if diff := cmp.Diff(want, res, ignored...); diff != "" {
 					t.Fatalf("mismatch (-want +got):\n%s", diff)
@@ -2061,7 +2423,7 @@ func TestListRepos(t *testing.T) {
 				Repos:                      1,
 				Shards:                     1,
 				Documents:                  4,
-				IndexBytes:                 412,
+				IndexBytes:                 518,
 				ContentBytes:               68,
 				NewLinesCount:              4,
 				DefaultBranchNewLinesCount: 2,
@@ -2569,7 +2931,7 @@ func TestBuilderStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if got, want := b.ContentSize(), uint32(2+4*1024); got != want {
+	if got, want := b.ContentSize(), uint64(2+4*1024); got != want {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
@@ -2646,6 +3008,30 @@ func TestIOStats(t *testing.T) {
 	})
 }
 
+func TestBM25LineLengthExperiment(t *testing.T) {
+	// The first line has more matches, but is much longer than the other
+	// lines of the file.
+	content := "needle needle needle " + strings.Repeat("a", 39) + "\nneedle\n" + strings.Repeat("x\n", 20)
+	b := testShardBuilder(t, nil, Document{Name: "f1", Content: []byte(content)})
+	q := &query.Substring{Pattern: "needle", Content: true}
+
+	bestLine := func(opts zoekt.SearchOptions) int {
+		t.Helper()
+		res := searchForTest(t, b, q, opts)
+		if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 2 {
+			t.Fatalf("got %v, want two line matches", res.Files)
+		}
+		return res.Files[0].LineMatches[0].LineNumber
+	}
+
+	if got := bestLine(zoekt.SearchOptions{UseBM25Scoring: true}); got != 1 {
+		t.Errorf("got best line %d, want 1", got)
+	}
+	if got := bestLine(zoekt.SearchOptions{UseBM25Scoring: true, Experiments: []string{experiments.BM25LineLength}}); got != 2 {
+		t.Errorf("got best line %d with %s, want 2", got, experiments.BM25LineLength)
+	}
+}
+
 func TestStartLineAnchor(t *testing.T) {
 	b := testShardBuilder(t, nil,
 		Document{
@@ -2833,6 +3219,35 @@ func TestLang(t *testing.T) {
 	})
 }
 
+func TestPathDepth(t *testing.T) {
+	content := []byte("bla needle bla")
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "go.mod", Content: content},
+		Document{Name: "cmd/main.go", Content: content},
+		Document{Name: "cmd/tool/main.go", Content: content},
+	)
+
+	for _, tc := range []struct {
+		q    *query.PathDepth
+		want []string
+	}{
+		{&query.PathDepth{Min: 0, Max: 0}, []string{"go.mod"}},
+		{&query.PathDepth{Min: 0, Max: 1}, []string{"cmd/main.go", "go.mod"}},
+		{&query.PathDepth{Min: 1, Max: -1}, []string{"cmd/main.go", "cmd/tool/main.go"}},
+		{&query.PathDepth{Min: 3, Max: -1}, nil},
+	} {
+		res := searchForTest(t, b, query.NewAnd(&query.Substring{Pattern: "needle"}, tc.q))
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
 func TestLangShortcut(t *testing.T) {
 	content := []byte("bla needle bla")
 	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
@@ -3139,6 +3554,67 @@ func TestSymbolRegexpPartial(t *testing.T) {
 	})
 }
 
+// TestSymbolBranches checks that symbols are only found on the branches of
+// the version of the file defining them.
+func TestSymbolBranches(t *testing.T) {
+	repo := &zoekt.Repository{
+		Name:     "reponame",
+		Branches: []zoekt.RepositoryBranch{{Name: "main"}, {Name: "dev"}},
+	}
+	fn := []*zoekt.Symbol{{Kind: "function"}}
+	docs := []Document{
+		{Name: "a.go", Content: []byte("func Old() {}\n"), Branches: []string{"main"}, Symbols: []DocumentSection{{5, 8}}, SymbolsMetaData: fn},
+		{Name: "a.go", Content: []byte("func New() {}\n"), Branches: []string{"dev"}, Symbols: []DocumentSection{{5, 8}}, SymbolsMetaData: fn},
+		{Name: "dev.go", Content: []byte("func DevOnly() {}\n"), Branches: []string{"dev"}, Symbols: []DocumentSection{{5, 12}}, SymbolsMetaData: fn},
+		{Name: "both.go", Content: []byte("func Both() {}\n"), Branches: []string{"main", "dev"}, Symbols: []DocumentSection{{5, 9}}, SymbolsMetaData: fn},
+		// The same content as the dev version of a.go, without its symbol.
+		{Name: "copy.go", Content: []byte("func New() {}\n"), Branches: []string{"main"}},
+	}
+
+	plain := testShardBuilder(t, repo, docs...)
+	dedup := testShardBuilder(t, repo)
+	dedup.enableContentDedup()
+	for _, d := range docs {
+		if err := dedup.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merged, err := merge(searcherForTest(t, dedup).(*indexData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{q: "sym:New", want: []string{"a.go@dev"}},
+		{q: "sym:New branch:dev", want: []string{"a.go@dev"}},
+		{q: "sym:New branch:main", want: nil},
+		{q: "sym:Old branch:dev", want: nil},
+		{q: "sym:DevOnly branch:main", want: nil},
+		{q: "sym:DevOnly branch:dev", want: []string{"dev.go@dev"}},
+		{q: "sym:/Dev.*/ branch:main", want: nil},
+		{q: "sym:Both branch:dev", want: []string{"both.go@dev"}},
+		{q: "sym:Both", want: []string{"both.go@main,dev"}},
+		{q: "sym:New -branch:dev", want: nil},
+	} {
+		q, err := query.Parse(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, b := range map[string]*ShardBuilder{"plain": plain, "dedup": dedup, "merged": merged} {
+			var got []string
+			for _, f := range searchForTest(t, b, q).Files {
+				got = append(got, f.FileName+"@"+strings.Join(f.Branches, ","))
+			}
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("%s: %s: mismatch (-want +got):\n%s", name, tc.q, d)
+			}
+		}
+	}
+}
+
 func TestSymbolRegexpAll(t *testing.T) {
 	docs := []Document{
 		{
@@ -3713,6 +4189,29 @@ func TestSearchTypeLanguage(t *testing.T) {
 	})
 }
 
+func TestSearchLanguageByContentAndAlias(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "tex.cls", Content: []byte(`\DeclareOption*{`)},
+		Document{Name: "run", Content: []byte("#!/bin/sh\necho hi\n")},
+	)
+
+	for _, tc := range []struct {
+		lang string
+		want string
+	}{
+		// Extensionless files are found by their language too.
+		{lang: "Shell", want: "run"},
+		// Aliases resolve to the language, also for queries which weren't
+		// parsed, eg. from the gRPC API.
+		{lang: "tex", want: "tex.cls"},
+	} {
+		res := searchForTest(t, b, &query.Language{Language: tc.lang})
+		if len(res.Files) != 1 || res.Files[0].FileName != tc.want {
+			t.Errorf("lang %s: got %v, want %s", tc.lang, res.Files, tc.want)
+		}
+	}
+}
+
 func TestStats(t *testing.T) {
 	ignored := []cmp.Option{
 		cmpopts.EquateEmpty(),
@@ -3900,9 +4399,13 @@ func TestWordSearch(t *testing.T) {
 		got := sres.Files[0].LineMatches[0]
 		want := zoekt.LineMatch{
 			LineFragments: []zoekt.LineFragmentMatch{{
-				LineOffset:  7,
-				Offset:      7,
-				MatchLength: 3,
+				LineOffset:       7,
+				Offset:           7,
+				MatchLength:      3,
+				Column:           8,
+				EndColumn:        11,
+				DisplayColumn:    8,
+				EndDisplayColumn: 11,
 			}},
 			Line:       content,
 			FileName:   false,
@@ -3934,10 +4437,10 @@ func TestWordSearch(t *testing.T) {
 		got := sres.Files[0].ChunkMatches[0]
 		want := zoekt.ChunkMatch{
 			Content:      content,
-			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+			ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1, DisplayColumn: 1},
 			Ranges: []zoekt.Range{{
-				Start: zoekt.Location{ByteOffset: 7, LineNumber: 1, Column: 8},
-				End:   zoekt.Location{ByteOffset: 10, LineNumber: 1, Column: 11},
+				Start: zoekt.Location{ByteOffset: 7, LineNumber: 1, Column: 8, DisplayColumn: 8},
+				End:   zoekt.Location{ByteOffset: 10, LineNumber: 1, Column: 11, DisplayColumn: 11},
 			}},
 		}
 
@@ -3983,3 +4486,436 @@ func BenchmarkScoreChunkMatches(b *testing.B) {
 		}
 	})
 }
+
+func TestSymbolDebugScore(t *testing.T) {
+	content := []byte("func Handle() {}\n")
+	doc := Document{
+		Name:            "server_test.go",
+		Language:        "Go",
+		Content:         content,
+		Symbols:         []DocumentSection{{Start: 5, End: 11}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "func"}},
+	}
+
+	searcher := searcherForTest(t, testShardBuilder(t, nil, doc))
+	sres, err := searcher.Search(context.Background(),
+		&query.Symbol{Expr: &query.Substring{Pattern: "Handle", Content: true}},
+		&zoekt.SearchOptions{ChunkMatches: true, DebugScore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sres.Files) != 1 || len(sres.Files[0].ChunkMatches) != 1 {
+		t.Fatalf("got %v, want 1 chunk match", sres.Files)
+	}
+
+	got := sres.Files[0].ChunkMatches[0].DebugScore
+	for _, want := range []string{"kind:Go:func:800.00", "exported:50.00", "test-file:-170.00"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("DebugScore %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestFoldedNgrams(t *testing.T) {
+	docs := []Document{
+		{
+			Name:    "src/FooBar.go",
+			Content: []byte("func FooBar() {}\n// foobar is not a symbol here\n"),
+			Symbols: []DocumentSection{{Start: 5, End: 11}},
+		},
+		{Name: "docs/ÜBER_Straße.md", Content: []byte("Grüße\n")},
+		{Name: "main.go", Content: []byte("func main() { fooBAR() }\n")},
+	}
+	for i := range docs {
+		docs[i].SymbolsMetaData = make([]*zoekt.Symbol, len(docs[i].Symbols))
+		for j := range docs[i].SymbolsMetaData {
+			docs[i].SymbolsMetaData[j] = &zoekt.Symbol{Kind: "func"}
+		}
+	}
+
+	plain := testShardBuilder(t, nil, docs...)
+	folded, err := NewShardBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folded.enableFoldedNgrams()
+	for _, d := range docs {
+		if err := folded.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, q := range []query.Q{
+		&query.Substring{Pattern: "foobar", FileName: true},
+		&query.Substring{Pattern: "über_straße", FileName: true},
+		&query.Substring{Pattern: "FooBar", FileName: true, CaseSensitive: true},
+		&query.Symbol{Expr: &query.Substring{Pattern: "FOOBAR", Content: true}},
+		&query.Symbol{Expr: &query.Substring{Pattern: "ooba", Content: true}},
+		&query.Substring{Pattern: "FOOBAR", Content: true},
+	} {
+		want := searchForTest(t, plain, q)
+		got := searchForTest(t, folded, q)
+		if d := cmp.Diff(want.Files, got.Files); d != "" {
+			t.Errorf("%s: mismatch (-plain +folded):\n%s", q, d)
+		}
+		if len(want.Files) == 0 {
+			t.Errorf("%s: no matches", q)
+		}
+	}
+
+	// The folded indexes need one lookup per trigram instead of one per case
+	// variant.
+	q := &query.Substring{Pattern: "foobar", FileName: true}
+	if want, got := searchForTest(t, plain, q).Stats.NgramLookups, searchForTest(t, folded, q).Stats.NgramLookups; got >= want {
+		t.Errorf("got %d ngram lookups with folded index, want less than %d", got, want)
+	}
+
+	// Only symbols are in the folded symbol index.
+	d := searcherForTest(t, folded).(*indexData)
+	if sec := d.foldedSymbolNgrams.Get(stringToNGram("foo")); sec.sz == 0 {
+		t.Error("foo missing from the folded symbol index")
+	}
+	if sec := d.foldedSymbolNgrams.Get(stringToNGram("sym")); sec.sz != 0 {
+		t.Error("found trigram of a comment in the folded symbol index")
+	}
+
+	// Shards without the option do not write the folded sections.
+	if d := searcherForTest(t, plain).(*indexData); d.hasFoldedNgrams() {
+		t.Error("shard built without folded ngrams has folded indexes")
+	}
+}
+
+func TestSymbolNgrams(t *testing.T) {
+	docs := []Document{
+		{
+			Name:    "foo.go",
+			Content: []byte("func FooBar() {}\n// FooBar is not a symbol here\n"),
+			Symbols: []DocumentSection{{Start: 5, End: 11}},
+		},
+		{Name: "main.go", Content: []byte("func main() { FooBar() }\n")},
+	}
+	docs[0].SymbolsMetaData = []*zoekt.Symbol{{Kind: "func"}}
+
+	plain := testShardBuilder(t, nil, docs...)
+	sym, err := NewShardBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym.enableSymbolNgrams()
+	for _, d := range docs {
+		if err := sym.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, q := range []query.Q{
+		&query.Symbol{Expr: &query.Substring{Pattern: "FooBar", Content: true, CaseSensitive: true}},
+		&query.Symbol{Expr: &query.Substring{Pattern: "oba", Content: true}},
+		&query.Symbol{Expr: &query.Regexp{Regexp: mustParseRE("Foo.ar"), Content: true, CaseSensitive: true}},
+		&query.Substring{Pattern: "FooBar", Content: true, CaseSensitive: true},
+	} {
+		want := searchForTest(t, plain, q)
+		got := searchForTest(t, sym, q)
+		if d := cmp.Diff(want.Files, got.Files); d != "" {
+			t.Errorf("%s: mismatch (-plain +symbol):\n%s", q, d)
+		}
+		if len(want.Files) == 0 {
+			t.Errorf("%s: no matches", q)
+		}
+	}
+
+	// The content index finds the comment and the call as candidates, the
+	// symbol index only the symbol.
+	q := &query.Symbol{Expr: &query.Substring{Pattern: "FooBar", Content: true, CaseSensitive: true}}
+	if got := searchForTest(t, plain, q).Stats.NgramMatches; got != 3 {
+		t.Errorf("got %d ngram matches with content index, want 3", got)
+	}
+	if got := searchForTest(t, sym, q).Stats.NgramMatches; got != 1 {
+		t.Errorf("got %d ngram matches with symbol index, want 1", got)
+	}
+
+	// The literals of a regexp are looked up in the symbol index too, so
+	// main.go, which only calls FooBar, isn't even considered.
+	q = &query.Symbol{Expr: &query.Regexp{Regexp: mustParseRE("Foo.ar"), Content: true, CaseSensitive: true}}
+	want, got := searchForTest(t, plain, q).Stats, searchForTest(t, sym, q).Stats
+	if want.NgramMatches != 3 || got.NgramMatches != 1 {
+		t.Errorf("got %d ngram matches with content index and %d with symbol index, want 3 and 1", want.NgramMatches, got.NgramMatches)
+	}
+	if want.FilesConsidered != 2 || got.FilesConsidered != 1 {
+		t.Errorf("considered %d files with content index and %d with symbol index, want 2 and 1", want.FilesConsidered, got.FilesConsidered)
+	}
+
+	// Searches for text outside of symbols don't use the symbol index.
+	d := searcherForTest(t, sym).(*indexData)
+	if sec := d.symbolNgrams.Get(stringToNGram("sym")); sec.sz != 0 {
+		t.Error("found trigram of a comment in the symbol index")
+	}
+	comment := &query.Substring{Pattern: "symbol here", Content: true, CaseSensitive: true}
+	if res := searchForTest(t, sym, comment); len(res.Files) != 1 {
+		t.Errorf("%s: got %d files, want the comment in foo.go", comment, len(res.Files))
+	}
+
+	if d := searcherForTest(t, plain).(*indexData); d.symbolNgrams.bt != nil {
+		t.Error("shard built without symbol ngrams has a symbol index")
+	}
+}
+
+func TestScoreSnippets(t *testing.T) {
+	docs := []Document{
+		{Name: "density.go", Content: []byte("x := needle\ny := needle\nz := needle + needle\n")},
+		{Name: "distinct.go", Content: []byte("alpha()\nalpha()\nalpha()\nbeta()\n")},
+		{
+			Name:            "proximity.go",
+			Content:         []byte("a := 1 // needle\nb := 2 // needle\n\n\nfunc F() { // needle\n"),
+			Symbols:         []DocumentSection{{Start: 41, End: 42}},
+			SymbolsMetaData: []*zoekt.Symbol{{Kind: "func"}},
+		},
+	}
+	searcher := searcherForTest(t, testShardBuilder(t, nil, docs...))
+
+	for _, tc := range []struct {
+		file string
+		q    query.Q
+		want int
+	}{
+		{"density.go", &query.Substring{Pattern: "needle", Content: true}, 3},
+		{"distinct.go", query.NewOr(&query.Substring{Pattern: "alpha", Content: true}, &query.Substring{Pattern: "beta", Content: true}), 4},
+		{"proximity.go", &query.Substring{Pattern: "needle", Content: true}, 5},
+	} {
+		for _, chunks := range []bool{false, true} {
+			q := query.NewAnd(&query.Substring{Pattern: tc.file, FileName: true}, tc.q)
+			sres, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{ChunkMatches: chunks, DebugScore: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sres.Files) != 1 {
+				t.Fatalf("%s: got %d files, want 1", tc.file, len(sres.Files))
+			}
+			f := sres.Files[0]
+
+			var line int
+			var debug string
+			if chunks {
+				line, debug = int(f.ChunkMatches[0].Ranges[0].Start.LineNumber), f.ChunkMatches[0].DebugScore
+			} else {
+				line, debug = f.LineMatches[0].LineNumber, f.LineMatches[0].DebugScore
+			}
+			if line != tc.want {
+				t.Errorf("%s (chunks %v): got best line %d, want %d", tc.file, chunks, line, tc.want)
+			}
+			if !strings.Contains(debug, "snippet:") {
+				t.Errorf("%s (chunks %v): DebugScore %q does not contain the snippet score", tc.file, chunks, debug)
+			}
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo", Branches: []zoekt.RepositoryBranch{{Name: "main"}}},
+		Document{Name: "a.go", Content: []byte("needle one\nhay\nneedle two needle\n"), Branches: []string{"main"}},
+		Document{Name: "b.go", Content: []byte("needle\n"), Branches: []string{"main"}},
+	)
+	s := searcherForTest(t, b)
+	ctx := context.Background()
+	needle := &query.Substring{Pattern: "needle", Content: true}
+
+	doc, err := zoekt.Document(ctx, s, "repo", "a.go", "main", needle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc.Content) != "needle one\nhay\nneedle two needle\n" {
+		t.Errorf("got content %q", doc.Content)
+	}
+	var got []uint32
+	for _, r := range doc.Matches {
+		got = append(got, r.Start.ByteOffset)
+	}
+	if d := cmp.Diff([]uint32{0, 15, 26}, got); d != "" {
+		t.Errorf("match offsets (-want +got):\n%s", d)
+	}
+
+	doc, err = zoekt.Document(ctx, s, "repo", "a.go", "", &query.Substring{Pattern: "missing", Content: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc == nil || doc.FileName != "a.go" || len(doc.Matches) != 0 {
+		t.Errorf("got %+v, want a.go without matches", doc)
+	}
+
+	for _, tc := range []struct{ path, branch string }{{"c.go", ""}, {"a.go", "dev"}} {
+		doc, err = zoekt.Document(ctx, s, "repo", tc.path, tc.branch, needle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc != nil {
+			t.Errorf("%s@%s: got %+v, want no document", tc.path, tc.branch, doc)
+		}
+	}
+}
+
+func TestSearchWithin(t *testing.T) {
+	docs := []Document{
+		{Name: "a.go", Content: []byte("needle one\n")},
+		{Name: "b.go", Content: []byte("needle two\n")},
+		{Name: "c.go", Content: []byte("haystack two\n")},
+	}
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs...)
+	b.IndexTime = time.Unix(1, 0)
+	s := searcherForTest(t, b)
+	ctx := context.Background()
+
+	search := func(q query.Q, opts *zoekt.SearchOptions) *zoekt.SearchResult {
+		t.Helper()
+		res, err := s.Search(ctx, q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	fileNames := func(res *zoekt.SearchResult) []string {
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	res := search(&query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{ReturnResultSet: true})
+	if res.ResultSet == nil {
+		t.Fatal("got no result set")
+	}
+
+	// The result set survives the round trip through its text encoding.
+	text, err := res.ResultSet.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var within zoekt.ResultSet
+	if err := within.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	two := &query.Substring{Pattern: "two", Content: true}
+	if got, want := fileNames(search(two, &zoekt.SearchOptions{})), []string{"b.go", "c.go"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	res = search(two, &zoekt.SearchOptions{Within: &within, ReturnResultSet: true})
+	if got, want := fileNames(res), []string{"b.go"}; !slices.Equal(got, want) {
+		t.Errorf("got %v within the result set, want %v", got, want)
+	}
+	for _, docs := range res.ResultSet.Shards {
+		if got := docs.ToArray(); !slices.Equal(got, []uint32{1}) {
+			t.Errorf("got refined result set %v, want [1]", got)
+		}
+	}
+
+	// A rebuilt shard is not in the result set anymore.
+	b = testShardBuilder(t, &zoekt.Repository{Name: "repo"}, docs...)
+	b.IndexTime = time.Unix(2, 0)
+	s = searcherForTest(t, b)
+	res = search(two, &zoekt.SearchOptions{Within: &within})
+	if len(res.Files) != 0 || res.Stats.ShardsSkippedFilter != 1 {
+		t.Errorf("got %v and %d skipped shards for a rebuilt shard, want no files and 1", fileNames(res), res.Stats.ShardsSkippedFilter)
+	}
+}
+
+func TestSearchResultSetShardMaxMatchCount(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "a.go", Content: []byte("needle one\n")},
+		Document{Name: "b.go", Content: []byte("needle two\n")},
+		Document{Name: "c.go", Content: []byte("needle three\n")},
+	)
+	s := searcherForTest(t, b)
+
+	// The shard stops after the first match, so the result set only has the
+	// document found before that.
+	res, err := s.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{
+		ShardMaxMatchCount: 1,
+		ReturnResultSet:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.ResultSet == nil || len(res.ResultSet.Shards) != 1 {
+		t.Fatalf("got %d files and result set %v, want 1 file and 1 shard", len(res.Files), res.ResultSet)
+	}
+	for _, docs := range res.ResultSet.Shards {
+		if got := docs.ToArray(); !slices.Equal(got, []uint32{0}) {
+			t.Errorf("got result set %v, want [0]", got)
+		}
+	}
+}
+
+func TestSearchFilesOnly(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "a.go", Content: []byte("needle\nneedle needle\n")},
+		Document{Name: "b.go", Content: []byte("haystack needle\n")},
+		Document{Name: "c.go", Content: []byte("haystack\n")},
+	)
+	s := searcherForTest(t, b)
+
+	for _, chunkMatches := range []bool{false, true} {
+		res, err := s.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{
+			FilesOnly:    true,
+			ChunkMatches: chunkMatches,
+			Whole:        true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]int{}
+		for _, f := range res.Files {
+			if len(f.LineMatches) > 0 || len(f.ChunkMatches) > 0 || f.Content != nil {
+				t.Errorf("%s: got matches or content", f.FileName)
+			}
+			if f.Language != "Go" || f.Repository != "repo" {
+				t.Errorf("%s: got language %q and repository %q", f.FileName, f.Language, f.Repository)
+			}
+			got[f.FileName] = f.MatchCount
+		}
+		if d := cmp.Diff(map[string]int{"a.go": 3, "b.go": 1}, got); d != "" {
+			t.Errorf("unexpected match counts (-want +got):\n%s", d)
+		}
+		if res.Stats.MatchCount != 4 {
+			t.Errorf("got Stats.MatchCount %d, want 4", res.Stats.MatchCount)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "f2", Content: []byte("no match here")},
+		Document{Name: "f3", Content: []byte("needle and thread")},
+		Document{Name: "f4", Content: []byte("another needle, another haystack")},
+	)
+	expensive := &query.Regexp{Regexp: mustParseRE("n.*e"), Content: true}
+	budgeted := func(timeout time.Duration) query.Q {
+		return query.NewAnd(&query.Budget{Child: expensive, Timeout: timeout}, &query.Substring{Pattern: "needle"})
+	}
+
+	plain := searchForTest(t, b, query.NewAnd(expensive, &query.Substring{Pattern: "needle"}))
+	res := searchForTest(t, b, budgeted(time.Hour), zoekt.SearchOptions{DetailedStats: true})
+	if got, want := len(res.Files), len(plain.Files); got != want {
+		t.Fatalf("got %d files, want %d", got, want)
+	}
+	if res.Stats.BudgetsExhausted != 0 || res.Stats.MatchTreeBudgetSearch <= 0 {
+		t.Errorf("got %d budgets exhausted in %v, want none in a positive duration", res.Stats.BudgetsExhausted, res.Stats.MatchTreeBudgetSearch)
+	}
+	evaluated := -1
+	for _, a := range res.Stats.Atoms {
+		if strings.HasPrefix(a.Atom, "re(") {
+			evaluated = a.FilesEvaluated
+		}
+	}
+	if evaluated != 3 {
+		t.Errorf("regexp evaluated on %d files, want only the 3 files with the substring", evaluated)
+	}
+
+	res = searchForTest(t, b, budgeted(time.Nanosecond))
+	if len(res.Files) != 1 || res.Stats.BudgetsExhausted != 1 {
+		t.Errorf("got %d files and %d budgets exhausted, want 1 and 1", len(res.Files), res.Stats.BudgetsExhausted)
+	}
+}
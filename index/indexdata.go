@@ -22,9 +22,13 @@ import (
 	"log"
 	"math/bits"
 	"slices"
+	"sync"
 	"unicode/utf8"
 
+	"github.com/RoaringBitmap/roaring"
+
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/languages"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -38,11 +42,19 @@ type indexData struct {
 
 	contentNgrams btreeIndex
 
-	newlinesStart uint32
-	newlinesIndex []uint32
+	newlinesStart uint64
+	newlinesIndex []uint64
+
+	docSectionsStart uint64
+	docSectionsIndex []uint64
+
+	// The checkpoints of long lines, if the shard has any.
+	longLinesStart uint64
+	longLinesIndex []uint64
 
-	docSectionsStart uint32
-	docSectionsIndex []uint32
+	// The documentation of the symbols, if the shard has any.
+	symbolDocsStart uint64
+	symbolDocsIndex []uint64
 
 	runeDocSections []DocumentSection
 
@@ -50,16 +62,61 @@ type indexData struct {
 	runeOffsets runeOffsetMap
 
 	// offsets of file contents; includes end of last file
-	boundariesStart uint32
-	boundaries      []uint32
+	boundariesStart uint64
+	boundaries      []uint64
 
 	// rune offsets for the file content boundaries
 	fileEndRunes []uint32
 
 	fileNameContent []byte
-	fileNameIndex   []uint32
+	fileNameIndex   []uint64
 	fileNameNgrams  btreeIndex
 
+	// foldedFileNameNgrams and foldedSymbolNgrams index the trigrams of the
+	// lowercased file names and symbols. Their btree is nil for shards built
+	// without them.
+	foldedFileNameNgrams btreeIndex
+	foldedSymbolNgrams   btreeIndex
+
+	// symbolNgrams indexes the trigrams of the symbols. Its btree is nil for
+	// shards built without it.
+	symbolNgrams btreeIndex
+
+	// symbolHashes is the hash index of the symbol names. It is nil for
+	// shards built without it.
+	symbolHashes symbolHashIndex
+
+	// fuzzySymbols maps the deletion neighborhoods of the symbol names to
+	// the symbols, see fuzzyNeighborhood. It is nil for shards built without
+	// it.
+	fuzzySymbols symbolHashIndex
+
+	// repoBloom is the bloom filter of the repositories of compound shards,
+	// and nil for other shards.
+	repoBloom *RepoBloom
+
+	// cjkBigrams is the index of the documents containing each CJK bigram.
+	// It is nil for shards built without Options.CJKBigrams.
+	cjkBigrams *cjkBigramIndex
+
+	// stopNgrams are the content trigrams which are not in contentNgrams. It
+	// is nil for shards built without a maximum trigram frequency.
+	stopNgrams *stopNgrams
+
+	// contentCiphers decrypt the file contents of shards with encrypted
+	// contents, and is nil for other shards. Then boundaries holds the
+	// offsets of the plaintext contents, and storedContentIndex the
+	// offsets of the encrypted contents in the fileContents section.
+	contentCiphers     contentCiphers
+	storedContentIndex []uint64
+
+	// contentSources[i] is the document whose stored contents document i
+	// shares, for shards with deduplicated contents, and is nil for other
+	// shards. Then boundaries holds the offsets of the contents of all
+	// documents, and storedContentIndex the offsets of the stored contents
+	// in the fileContents section.
+	contentSources []uint32
+
 	// fileEndSymbol[i] is the index of the first symbol for document i.
 	fileEndSymbol []uint32
 
@@ -77,6 +134,10 @@ type indexData struct {
 	// name => mask (power of 2)
 	branchIDs []map[string]uint
 
+	// branchOrders holds the "branchOrder" of each repository, which sorts the
+	// branches of its files in results. It is nil for repositories without one.
+	branchOrders []map[string]int
+
 	metaData     zoekt.IndexMetadata
 	repoMetaData []zoekt.Repository
 
@@ -89,9 +150,30 @@ type indexData struct {
 	// languages for all the files.
 	languages []byte
 
+	// rankingSignals of all the files. It is nil if no file has one.
+	rankingSignals []float32
+
+	// labelSets are the distinct labels of the files, and docLabels the ID of
+	// the labels of each file, which is 0 for none or one more than their
+	// index in labelSets. Both are nil if no file has labels.
+	labelSets []map[string]string
+	docLabels []uint32
+
+	// ngramSelections caches the ngrams selected for substring queries.
+	ngramSelections boundedCache[ngramSelectionKey, ngramSelection]
+
 	// inverse of LanguageMap in metaData
 	languageMap map[uint16]string
 
+	// languageDocs holds the documents of each language code which was
+	// searched for, see languageDocuments.
+	languageDocsMu sync.Mutex
+	languageDocs   map[uint16]*roaring.Bitmap
+
+	// testDocs holds the test files, see isTestFile. It is nil if the shard
+	// has none.
+	testDocs *roaring.Bitmap
+
 	repoListEntry []zoekt.RepoListEntry
 
 	// repository indexes for all the files
@@ -108,7 +190,7 @@ type symbolData struct {
 	symIndex   []byte
 	// symKindContent is an enum of sym.Kind and sym.ParentKind
 	symKindContent []byte
-	symKindIndex   []uint32
+	symKindIndex   []uint64
 	// symMetadata is [4]uint32 0 Kind Parent ParentKind
 	symMetaData []byte
 }
@@ -172,6 +254,43 @@ func (d *indexData) getLanguage(idx uint32) uint16 {
 	return uint16(d.languages[idx*2]) | uint16(d.languages[idx*2+1])<<8
 }
 
+// languageCode returns the code of the language in the shard. Languages can
+// also be given by an alias, eg. "golang" for Go.
+func (d *indexData) languageCode(lang string) (uint16, bool) {
+	if code, ok := d.metaData.LanguageMap[lang]; ok {
+		return code, true
+	}
+	if canonical, ok := languages.GetLanguageByAlias(lang); ok {
+		code, ok := d.metaData.LanguageMap[canonical]
+		return code, ok
+	}
+	return 0, false
+}
+
+// languageDocuments returns the documents of the language code. The bitmap of
+// a language is built the first time it is searched for, so shards don't pay
+// for the languages nobody searches.
+func (d *indexData) languageDocuments(code uint16) *roaring.Bitmap {
+	d.languageDocsMu.Lock()
+	defer d.languageDocsMu.Unlock()
+	if bm, ok := d.languageDocs[code]; ok {
+		return bm
+	}
+
+	bm := roaring.New()
+	for doc := range d.numDocs() {
+		if d.getLanguage(doc) == code {
+			bm.Add(doc)
+		}
+	}
+	bm.RunOptimize()
+	if d.languageDocs == nil {
+		d.languageDocs = map[uint16]*roaring.Bitmap{}
+	}
+	d.languageDocs[code] = bm
+	return bm
+}
+
 // calculates stats for files in the range [start, end).
 func (d *indexData) calculateStatsForFileRange(start, end uint32) zoekt.RepoStats {
 	if start >= end {
@@ -295,14 +414,18 @@ func (d *indexData) String() string {
 func (d *indexData) memoryUse() int {
 	sz := 0
 	for _, a := range [][]uint32{
-		d.newlinesIndex, d.docSectionsIndex,
-		d.boundaries, d.fileNameIndex,
 		d.fileEndRunes, d.fileNameEndRunes,
-		d.fileEndSymbol, d.symbols.symKindIndex,
-		d.subRepos,
+		d.fileEndSymbol, d.subRepos, d.contentSources,
 	} {
 		sz += 4 * len(a)
 	}
+	for _, a := range [][]uint64{
+		d.newlinesIndex, d.docSectionsIndex, d.longLinesIndex, d.symbolDocsIndex,
+		d.boundaries, d.fileNameIndex, d.symbols.symKindIndex,
+		d.storedContentIndex,
+	} {
+		sz += 8 * len(a)
+	}
 	sz += d.runeOffsets.sizeBytes()
 	sz += d.fileNameRuneOffsets.sizeBytes()
 	sz += len(d.languages)
@@ -310,8 +433,27 @@ func (d *indexData) memoryUse() int {
 	sz += 2 * len(d.repos)
 	sz += 8 * len(d.runeDocSections)
 	sz += 8 * len(d.fileBranchMasks)
+	sz += 4 * len(d.rankingSignals)
+	sz += 4 * len(d.docLabels)
+	for _, s := range d.labelSets {
+		for k, v := range s {
+			sz += len(k) + len(v)
+		}
+	}
+	if d.testDocs != nil {
+		sz += int(d.testDocs.GetSizeInBytes())
+	}
 	sz += d.contentNgrams.SizeBytes()
 	sz += d.fileNameNgrams.SizeBytes()
+	if d.foldedFileNameNgrams.bt != nil {
+		sz += d.foldedFileNameNgrams.SizeBytes()
+	}
+	if d.foldedSymbolNgrams.bt != nil {
+		sz += d.foldedSymbolNgrams.SizeBytes()
+	}
+	if d.symbolNgrams.bt != nil {
+		sz += d.symbolNgrams.SizeBytes()
+	}
 	return sz
 }
 
@@ -365,11 +507,85 @@ func minFrequencyNgramOffsets(ngramOffs []runeNgramOff, frequencies []uint32) (f
 	return
 }
 
-func (data *indexData) ngrams(filename bool) btreeIndex {
-	if filename {
-		return data.fileNameNgrams
+// hasFoldedNgrams reports whether the shard was built with case-folded
+// trigram indexes.
+func (d *indexData) hasFoldedNgrams() bool {
+	return d.foldedFileNameNgrams.bt != nil || d.foldedSymbolNgrams.bt != nil
+}
+
+// ngramLookup finds the posting lists of the trigrams of a substring.
+type ngramLookup struct {
+	index btreeIndex
+
+	// folded is set if index contains the trigrams of lowercased text.
+	folded bool
+
+	caseSensitive bool
+
+	// stop are the trigrams left out of index.
+	stop *stopNgrams
+}
+
+// isStop returns whether a variant of ng is left out of the index, so the
+// index can't tell where ng occurs.
+func (l ngramLookup) isStop(ng ngram) bool {
+	if l.stop == nil {
+		return false
+	}
+	for _, v := range l.variants(ng) {
+		if l.stop.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// variants returns the trigrams of index which may match ng.
+func (l ngramLookup) variants(ng ngram) []ngram {
+	switch {
+	case l.caseSensitive:
+		return []ngram{ng}
+	case l.folded:
+		return []ngram{lowerNGram(ng)}
+	default:
+		return generateCaseNgrams(ng)
+	}
+}
+
+// ngramLookup returns the lookup for the trigrams of q. If symbol is set only
+// matches within symbols are needed, so the symbol index is used if the shard
+// has one. Case-insensitive searches use a folded index if the shard has one,
+// so they need one lookup per trigram instead of one per case variant.
+func (d *indexData) ngramLookup(q *query.Substring, symbol bool) ngramLookup {
+	l := ngramLookup{index: d.contentNgrams, caseSensitive: q.CaseSensitive}
+	switch {
+	case q.FileName && !q.CaseSensitive && d.foldedFileNameNgrams.bt != nil:
+		l.index, l.folded = d.foldedFileNameNgrams, true
+	case q.FileName:
+		l.index = d.fileNameNgrams
+	case symbol && !q.CaseSensitive && d.foldedSymbolNgrams.bt != nil:
+		l.index, l.folded = d.foldedSymbolNgrams, true
+	case symbol && d.symbolNgrams.bt != nil:
+		l.index = d.symbolNgrams
+	default:
+		l.stop = d.stopNgrams
 	}
-	return data.contentNgrams
+	return l
+}
+
+// onlyStopNgrams returns whether all trigrams of q are stop-ngrams, so the
+// index can't narrow down the candidates for q.
+func (d *indexData) onlyStopNgrams(q *query.Substring, symbol bool) bool {
+	lookup := d.ngramLookup(q, symbol)
+	if lookup.stop == nil {
+		return false
+	}
+	for _, o := range splitNGrams([]byte(q.Pattern)) {
+		if !lookup.isStop(o.ngram) {
+			return false
+		}
+	}
+	return true
 }
 
 type ngramIterationResults struct {
@@ -396,7 +612,10 @@ func (r *ngramIterationResults) candidates() []*candidateMatch {
 	return cs
 }
 
-func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResults, error) {
+// selectNgrams selects the ngrams of the pattern of query whose posting lists
+// are intersected to find its candidates, and returns the number of ngram
+// lookups it took.
+func (d *indexData) selectNgrams(query *query.Substring, symbol bool) (ngramSelection, int, error) {
 	str := query.Pattern
 
 	// Find the 2 least common ngrams from the string.
@@ -404,7 +623,7 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 
 	// protect against accidental searching of empty strings
 	if len(ngramOffs) == 0 {
-		return nil, errors.New("iterateNgrams needs non empty string")
+		return ngramSelection{}, 0, errors.New("iterateNgrams needs non empty string")
 	}
 
 	// PERF: Sort to increase the chances adjacent checks are in the same btree
@@ -413,36 +632,78 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 	frequencies := make([]uint32, 0, len(ngramOffs))
 	indexMap := make([]int, len(ngramOffs))
 	ngramLookups := 0
-	ngrams := d.ngrams(query.FileName)
+	lookup := d.ngramLookup(query, symbol)
+	stops := 0
 	for i, o := range ngramOffs {
+		// Stop-ngrams have no posting list, so they are only picked if
+		// there is no other trigram.
+		if lookup.isStop(o.ngram) {
+			frequencies = append(frequencies, maxUInt32)
+			indexMap[o.index] = i
+			stops++
+			continue
+		}
+
 		var freq uint32
-		if query.CaseSensitive {
-			freq = ngrams.Get(o.ngram).sz
+		for _, v := range lookup.variants(o.ngram) {
+			freq += uint32(lookup.index.Get(v).sz)
 			ngramLookups++
-		} else {
-			for _, v := range generateCaseNgrams(o.ngram) {
-				freq += ngrams.Get(v).sz
-				ngramLookups++
-			}
 		}
 
 		if freq == 0 {
-			return &ngramIterationResults{
-				matchIterator: &noMatchTree{
-					Why: "freq=0",
-					Stats: zoekt.Stats{
-						NgramLookups: ngramLookups,
-					},
-				},
-			}, nil
+			return ngramSelection{noMatch: true}, ngramLookups, nil
 		}
 
 		frequencies = append(frequencies, freq)
 		indexMap[o.index] = i
 	}
 
+	if stops == len(ngramOffs) {
+		return ngramSelection{}, ngramLookups, errors.New("iterateNgrams needs a trigram which is not a stop-ngram")
+	}
+
 	first, last := findSelectiveNgrams(ngramOffs, indexMap, frequencies)
+	if stops > 0 {
+		isStop := func(o runeNgramOff) bool { return frequencies[indexMap[o.index]] == maxUInt32 }
+		if isStop(first) || isStop(last) {
+			first, last = minFrequencyNgramOffsets(ngramOffs, frequencies)
+		}
+		if isStop(first) {
+			first = last
+		} else if isStop(last) {
+			last = first
+		}
+	}
+	return ngramSelection{first: first, last: last}, ngramLookups, nil
+}
+
+func (d *indexData) iterateNgrams(query *query.Substring, symbol bool) (*ngramIterationResults, error) {
+	str := query.Pattern
 
+	// ngramLookups stays zero if the selection is cached.
+	ngramLookups := 0
+	key := ngramSelectionKey{pattern: str, caseSensitive: query.CaseSensitive, fileName: query.FileName, symbol: symbol}
+	sel, err := d.ngramSelections.get(key, func() (sel ngramSelection, err error) {
+		sel, ngramLookups, err = d.selectNgrams(query, symbol)
+		return sel, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sel.noMatch {
+		return &ngramIterationResults{
+			matchIterator: &noMatchTree{
+				Why: "freq=0",
+				Stats: zoekt.Stats{
+					NgramLookups: ngramLookups,
+				},
+			},
+		}, nil
+	}
+
+	lookup := d.ngramLookup(query, symbol)
+	first, last := sel.first, sel.last
 	iter := &ngramDocIterator{
 		leftPad:      uint32(first.index),
 		rightPad:     uint32(utf8.RuneCountInString(str) - first.index),
@@ -456,14 +717,14 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 
 	if first != last {
 		runeDist := uint32(last.index - first.index)
-		i, err := d.newDistanceTrigramIter(first.ngram, last.ngram, runeDist, query.CaseSensitive, query.FileName)
+		i, err := d.newDistanceTrigramIter(first.ngram, last.ngram, runeDist, lookup)
 		if err != nil {
 			return nil, err
 		}
 
 		iter.iter = i
 	} else {
-		hitIter, err := d.trigramHitIterator(last.ngram, query.CaseSensitive, query.FileName)
+		hitIter, err := d.trigramHitIterator(last.ngram, lookup)
 		if err != nil {
 			return nil, err
 		}
@@ -499,6 +760,12 @@ const (
 	rawConfigNo  = 2
 )
 
+// MatchRawConfig returns true if a repository with the given rawConfig
+// satisfies all the flags set in rc.
+func MatchRawConfig(rc query.RawConfig, rawConfig map[string]string) bool {
+	return uint8(rc)&encodeRawConfig(rawConfig) == uint8(rc)
+}
+
 // encodeRawConfig encodes a rawConfig map into a uint8 mask.
 func encodeRawConfig(rawConfig map[string]string) uint8 {
 	var encoded uint8
@@ -26,12 +26,12 @@ import (
 
 type mmapedIndexFile struct {
 	name string
-	size uint32
+	size uint64
 	data []byte
 }
 
-func (f *mmapedIndexFile) Read(off, sz uint32) ([]byte, error) {
-	if off > off+sz || off+sz > uint32(len(f.data)) {
+func (f *mmapedIndexFile) Read(off, sz uint64) ([]byte, error) {
+	if off > off+sz || off+sz > uint64(len(f.data)) {
 		return nil, fmt.Errorf("out of bounds: %d, len %d, name %s", off+sz, len(f.data), f.name)
 	}
 	return f.data[off : off+sz], nil
@@ -41,10 +41,35 @@ func (f *mmapedIndexFile) Name() string {
 	return f.name
 }
 
-func (f *mmapedIndexFile) Size() (uint32, error) {
+func (f *mmapedIndexFile) Size() (uint64, error) {
 	return f.size, nil
 }
 
+func (f *mmapedIndexFile) advise(off, sz uint64, advice Advice) error {
+	if off > off+sz || off+sz > uint64(len(f.data)) {
+		return fmt.Errorf("out of bounds: %d, len %d, name %s", off+sz, len(f.data), f.name)
+	}
+
+	var flag int
+	switch advice {
+	case AdviceNormal:
+		flag = unix.MADV_NORMAL
+	case AdviceRandom:
+		flag = unix.MADV_RANDOM
+	case AdviceSequential:
+		flag = unix.MADV_SEQUENTIAL
+	case AdviceWillNeed:
+		flag = unix.MADV_WILLNEED
+	default:
+		return fmt.Errorf("unknown advice %d", advice)
+	}
+
+	// madvise requires a page aligned start address. Pages are larger than
+	// 4 KiB on some arm64 kernels.
+	start := off &^ uint64(os.Getpagesize()-1)
+	return unix.Madvise(f.data[start:off+sz], flag)
+}
+
 func (f *mmapedIndexFile) Close() {
 	if err := unix.Munmap(f.data); err != nil {
 		log.Printf("WARN failed to Munmap %s: %v", f.name, err)
@@ -61,13 +86,9 @@ func NewIndexFile(f *os.File) (IndexFile, error) {
 		return nil, err
 	}
 
-	sz := fi.Size()
-	if sz >= maxUInt32 {
-		return nil, fmt.Errorf("file %s too large: %d", f.Name(), sz)
-	}
 	r := &mmapedIndexFile{
 		name: f.Name(),
-		size: uint32(sz),
+		size: uint64(fi.Size()),
 	}
 
 	rounded := (r.size + 4095) &^ 4095
@@ -0,0 +1,229 @@
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// DocumentLabels are key/value labels of the files of a repository, eg. the
+// service a file belongs to, its owning team or its compliance tier. They are
+// read from a JSON sidecar file mapping paths to labels, like
+//
+//	{
+//	  "": {"tier": "2"},
+//	  "services/billing/": {"service": "billing", "team": "payments"},
+//	  "services/billing/card.go": {"tier": "1"}
+//	}
+//
+// A path ending in a slash labels the files below it, and the empty path all
+// files. Where the labels of a file and of its directories have the same key,
+// the most specific path wins. They are stored as the Labels of the
+// documents, and matched by meta.key:value queries.
+type DocumentLabels struct {
+	paths map[string]map[string]string
+}
+
+// LoadDocumentLabels reads the document labels in the JSON file path.
+func LoadDocumentLabels(path string) (*DocumentLabels, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l DocumentLabels
+	if err := json.Unmarshal(b, &l.paths); err != nil {
+		return nil, fmt.Errorf("parsing document labels %s: %w", path, err)
+	}
+	for p, labels := range l.paths {
+		for k := range labels {
+			if !validLabelKey(k) {
+				return nil, fmt.Errorf("document labels %s: invalid key %q for %q", path, k, p)
+			}
+		}
+	}
+	return &l, nil
+}
+
+// validLabelKey returns true if k can be searched for with meta.k:value.
+func validLabelKey(k string) bool {
+	return k != "" && !strings.ContainsAny(k, ": \t\r\n\"'`()")
+}
+
+// labels returns the labels of the file path, or nil.
+func (l *DocumentLabels) labels(path string) map[string]string {
+	var out map[string]string
+	add := func(p string) {
+		for k, v := range l.paths[p] {
+			if out == nil {
+				out = map[string]string{}
+			}
+			out[k] = v
+		}
+	}
+	add("")
+	for i, c := range path {
+		if c == '/' {
+			add(path[:i+1])
+		}
+	}
+	add(path)
+	return out
+}
+
+// addLabels adds the labels of l for doc to the labels it doesn't have.
+func (l *DocumentLabels) addLabels(doc *Document) {
+	labels := l.labels(doc.Name)
+	if len(labels) == 0 {
+		return
+	}
+	maps.Copy(labels, doc.Labels)
+	doc.Labels = labels
+}
+
+// encodeLabelSet encodes labels as their number followed by the keys and
+// values, sorted by key, each prefixed by its varint length.
+func encodeLabelSet(labels map[string]string) []byte {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(len(labels)))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(labels[k])))
+		buf = append(buf, labels[k]...)
+	}
+	return buf
+}
+
+// labelSetID returns the ID of labels in the docLabels section, adding them
+// to the distinct label sets of the shard if needed. Documents without
+// labels have ID 0.
+func (b *ShardBuilder) labelSetID(labels map[string]string) uint32 {
+	if len(labels) == 0 {
+		return 0
+	}
+	enc := encodeLabelSet(labels)
+	if id, ok := b.labelSetIDs[string(enc)]; ok {
+		return id
+	}
+	if b.labelSetIDs == nil {
+		b.labelSetIDs = map[string]uint32{}
+	}
+	b.labelSets = append(b.labelSets, enc)
+	id := uint32(len(b.labelSets))
+	b.labelSetIDs[string(enc)] = id
+	return id
+}
+
+// encodeDocLabels encodes the docLabels section. Since the documents of a
+// shard mostly share a few sets of labels, eg. those of their service, each
+// distinct set is stored once: the section holds the number of sets, the
+// sets, see encodeLabelSet, and then the varint set ID of each document.
+func encodeDocLabels(sets [][]byte, docLabels []uint32) []byte {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(len(sets)))
+	for _, s := range sets {
+		buf = append(buf, s...)
+	}
+	for _, id := range docLabels {
+		buf = binary.AppendUvarint(buf, uint64(id))
+	}
+	return buf
+}
+
+func decodeDocLabels(blob []byte, numDocs uint32) (sets []map[string]string, docLabels []uint32, err error) {
+	errCorrupt := fmt.Errorf("docLabels: corrupt section")
+	uvarint := func() (uint64, bool) {
+		v, n := binary.Uvarint(blob)
+		if n <= 0 {
+			return 0, false
+		}
+		blob = blob[n:]
+		return v, true
+	}
+	str := func() (string, bool) {
+		sz, ok := uvarint()
+		if !ok || sz > uint64(len(blob)) {
+			return "", false
+		}
+		s := string(blob[:sz])
+		blob = blob[sz:]
+		return s, true
+	}
+
+	numSets, ok := uvarint()
+	if !ok || numSets > uint64(len(blob)) {
+		return nil, nil, errCorrupt
+	}
+	sets = make([]map[string]string, numSets)
+	for i := range sets {
+		n, ok := uvarint()
+		if !ok || n > uint64(len(blob)) {
+			return nil, nil, errCorrupt
+		}
+		sets[i] = make(map[string]string, n)
+		for range n {
+			k, ok1 := str()
+			v, ok2 := str()
+			if !ok1 || !ok2 {
+				return nil, nil, errCorrupt
+			}
+			sets[i][k] = v
+		}
+	}
+
+	docLabels = make([]uint32, numDocs)
+	for i := range docLabels {
+		id, ok := uvarint()
+		if !ok || id > numSets {
+			return nil, nil, errCorrupt
+		}
+		docLabels[i] = uint32(id)
+	}
+	if len(blob) != 0 {
+		return nil, nil, errCorrupt
+	}
+	return sets, docLabels, nil
+}
+
+// labels returns a copy of the labels of document doc, or nil.
+func (d *indexData) labels(doc uint32) map[string]string {
+	if d.docLabels == nil || d.docLabels[doc] == 0 {
+		return nil
+	}
+	return maps.Clone(d.labelSets[d.docLabels[doc]-1])
+}
+
+// metaLabelSets returns which label set IDs match q, indexed by ID, and
+// whether any does.
+func (d *indexData) metaLabelSets(q *query.Meta) ([]bool, bool) {
+	want := make([]bool, len(d.labelSets)+1)
+	found := false
+	for i, s := range d.labelSets {
+		if v, ok := s[q.Key]; ok && v == q.Value {
+			want[i+1] = true
+			found = true
+		}
+	}
+	return want, found
+}
+
+// metaMatchTree returns the match tree of the documents with the label of q.
+func (d *indexData) metaMatchTree(q *query.Meta) matchTree {
+	want, found := d.metaLabelSets(q)
+	if !found {
+		return &noMatchTree{Why: q.String()}
+	}
+	return &docMatchTree{
+		reason:  q.String(),
+		numDocs: d.numDocs(),
+		predicate: func(docID uint32) bool {
+			return want[d.docLabels[docID]]
+		},
+	}
+}
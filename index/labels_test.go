@@ -0,0 +1,106 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestLoadDocumentLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labels.json")
+	if err := os.WriteFile(path, []byte(`{
+		"": {"tier": "2"},
+		"services/billing/": {"service": "billing", "team": "payments"},
+		"services/billing/card.go": {"tier": "1"}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadDocumentLabels(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		doc  Document
+		want map[string]string
+	}{
+		{Document{Name: "README.md"}, map[string]string{"tier": "2"}},
+		{Document{Name: "services/billing/api.go"}, map[string]string{"service": "billing", "team": "payments", "tier": "2"}},
+		{Document{Name: "services/billing/card.go"}, map[string]string{"service": "billing", "team": "payments", "tier": "1"}},
+		// The labels of the document take precedence.
+		{Document{Name: "services/billing/api.go", Labels: map[string]string{"team": "core"}}, map[string]string{"service": "billing", "team": "core", "tier": "2"}},
+	} {
+		doc := tc.doc
+		l.addLabels(&doc)
+		if diff := cmp.Diff(tc.want, doc.Labels); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", doc.Name, diff)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"main.go": {"team:x": "y"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDocumentLabels(path); err == nil {
+		t.Error("got no error for a key with a colon")
+	}
+}
+
+func TestSearchMeta(t *testing.T) {
+	content := []byte("needle")
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "api.go", Content: content, Labels: map[string]string{"service": "billing", "team": "payments"}},
+		Document{Name: "card.go", Content: content, Labels: map[string]string{"team": "payments", "service": "billing"}},
+		Document{Name: "search.go", Content: content, Labels: map[string]string{"team": "search"}},
+		Document{Name: "README.md", Content: content},
+	)
+	if len(b.labelSets) != 2 {
+		t.Errorf("got %d label sets, want 2", len(b.labelSets))
+	}
+
+	search := func(q query.Q) []string {
+		t.Helper()
+		res := searchForTest(t, b, query.NewAnd(&query.Substring{Pattern: "needle"}, q))
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	for _, tc := range []struct {
+		q    query.Q
+		want []string
+	}{
+		{&query.Meta{Key: "team", Value: "payments"}, []string{"api.go", "card.go"}},
+		{&query.Meta{Key: "team", Value: "search"}, []string{"search.go"}},
+		{&query.Not{Child: &query.Meta{Key: "team", Value: "payments"}}, []string{"README.md", "search.go"}},
+		{&query.Meta{Key: "team", Value: "Payments"}, nil},
+		{&query.Meta{Key: "owner", Value: "payments"}, nil},
+	} {
+		if got := search(tc.q); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	res := searchForTest(t, b, &query.Substring{Pattern: "needle"})
+	got := map[string]map[string]string{}
+	for _, f := range res.Files {
+		got[f.FileName] = f.Labels
+	}
+	want := map[string]map[string]string{
+		"api.go":    {"service": "billing", "team": "payments"},
+		"card.go":   {"service": "billing", "team": "payments"},
+		"search.go": {"team": "search"},
+		"README.md": nil,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FileMatch.Labels (-want +got):\n%s", diff)
+	}
+}
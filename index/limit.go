@@ -32,9 +32,13 @@ func NewDisplayTruncator(opts *zoekt.SearchOptions) (_ DisplayTruncator, hasLimi
 	matchLimit := opts.MaxMatchDisplayCount
 	matchLimited := matchLimit > 0
 
+	byteLimit := opts.MaxDisplayBytes
+	byteLimited := byteLimit > 0
+	keptFiles := 0
+
 	done := false
 
-	if !docLimited && !matchLimited {
+	if !docLimited && !matchLimited && !byteLimited {
 		return func(fm []zoekt.FileMatch) ([]zoekt.FileMatch, bool) {
 			return fm, true
 		}, false
@@ -54,21 +58,113 @@ func NewDisplayTruncator(opts *zoekt.SearchOptions) (_ DisplayTruncator, hasLimi
 		}
 
 		if matchLimited {
-			fm, matchLimit = limitMatches(fm, matchLimit, opts.ChunkMatches)
+			fm, matchLimit = limitMatches(fm, matchLimit, opts)
 			if matchLimit <= 0 {
 				done = true
 			}
 		}
 
+		if byteLimited {
+			for i := range fm {
+				size := int(fm[i].SizeBytes())
+				if size > byteLimit && keptFiles+i > 0 {
+					done = true
+					fm = fm[:i]
+					break
+				}
+				byteLimit -= size
+			}
+			keptFiles += len(fm)
+		}
+
 		return fm, !done
 	}, true
 }
 
-func limitMatches(files []zoekt.FileMatch, limit int, chunkMatches bool) ([]zoekt.FileMatch, int) {
-	var limiter func(file *zoekt.FileMatch, limit int) int
-	if chunkMatches {
+// RepoMatchLimiter is a stateful function which enforces
+// SearchOptions.MaxMatchesPerRepo over all the files it is called with. Files
+// are kept in order until their repository has used up its limit. The matches
+// it drops are added to the RepoStats.SuppressedMatches of stats.RepoStats.
+type RepoMatchLimiter func(files []zoekt.FileMatch, stats *zoekt.Stats) []zoekt.FileMatch
+
+// NewRepoMatchLimiter returns a RepoMatchLimiter for opts. If
+// opts.MaxMatchesPerRepo is not set, hasLimit is false and there is no need to
+// call RepoMatchLimiter.
+func NewRepoMatchLimiter(opts *zoekt.SearchOptions) (_ RepoMatchLimiter, hasLimit bool) {
+	limit := opts.MaxMatchesPerRepo
+	if limit <= 0 {
+		return func(files []zoekt.FileMatch, _ *zoekt.Stats) []zoekt.FileMatch {
+			return files
+		}, false
+	}
+
+	limiter := limitLineMatches
+	if opts.ChunkMatches {
 		limiter = limitChunkMatches
+	}
+
+	// repo => number of matches we kept.
+	kept := map[string]int{}
+
+	return func(files []zoekt.FileMatch, stats *zoekt.Stats) []zoekt.FileMatch {
+		suppress := func(repo string, n int) {
+			if stats.RepoStats == nil {
+				stats.RepoStats = map[string]zoekt.RepoStats{}
+			}
+			rs := stats.RepoStats[repo]
+			rs.SuppressedMatches += n
+			stats.RepoStats[repo] = rs
+		}
+
+		out := files[:0]
+		for _, f := range files {
+			// A file without matches still takes up a result.
+			n := max(countMatches(&f, opts.ChunkMatches), 1)
+			left := limit - kept[f.Repository]
+			if left <= 0 {
+				suppress(f.Repository, n)
+				continue
+			}
+			if n > left {
+				limiter(&f, left)
+				suppress(f.Repository, n-left)
+				n = left
+			}
+			kept[f.Repository] += n
+			out = append(out, f)
+		}
+		return out
+	}, true
+}
+
+// countMatches returns the number of matches in file the way limitLineMatches
+// and limitChunkMatches count them. Files of a SearchOptions.FilesOnly search
+// only have their MatchCount.
+func countMatches(file *zoekt.FileMatch, chunkMatches bool) int {
+	n := file.MatchCount
+	if chunkMatches {
+		for _, cm := range file.ChunkMatches {
+			n += len(cm.Ranges)
+		}
 	} else {
+		for _, lm := range file.LineMatches {
+			n += len(lm.LineFragments)
+		}
+	}
+	return n
+}
+
+func limitMatches(files []zoekt.FileMatch, limit int, opts *zoekt.SearchOptions) ([]zoekt.FileMatch, int) {
+	var limiter func(file *zoekt.FileMatch, limit int) int
+	switch {
+	case opts.FilesOnly:
+		// There are no matches to drop, so files are kept whole.
+		limiter = func(file *zoekt.FileMatch, limit int) int {
+			return limit - file.MatchCount
+		}
+	case opts.ChunkMatches:
+		limiter = limitChunkMatches
+	default:
 		limiter = limitLineMatches
 	}
 	for i := range files {
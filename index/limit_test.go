@@ -162,3 +162,108 @@ func TestLimitMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestLimitMatches_filesOnly(t *testing.T) {
+	files := []zoekt.FileMatch{{FileName: "a", MatchCount: 2}, {FileName: "b", MatchCount: 3}, {FileName: "c", MatchCount: 1}}
+
+	// Files have no matches to drop, so the one reaching the limit is kept.
+	got := SortAndTruncateFiles(files, &zoekt.SearchOptions{FilesOnly: true, MaxMatchDisplayCount: 4})
+	var names []string
+	for _, f := range got {
+		names = append(names, f.FileName)
+	}
+	if d := cmp.Diff([]string{"a", "b"}, names); d != "" {
+		t.Errorf("(-want +got):\n%s", d)
+	}
+}
+
+func TestDisplayTruncator_maxDisplayBytes(t *testing.T) {
+	file := func(name string, n int) zoekt.FileMatch {
+		return zoekt.FileMatch{FileName: name, LineMatches: []zoekt.LineMatch{{Line: bytes.Repeat([]byte("x"), n)}}}
+	}
+	small, large := file("small", 100), file("large", 1000)
+	budget := int(small.SizeBytes() + large.SizeBytes())
+
+	names := func(files []zoekt.FileMatch) []string {
+		var ns []string
+		for _, f := range files {
+			ns = append(ns, f.FileName)
+		}
+		return ns
+	}
+
+	truncator, hasLimits := NewDisplayTruncator(&zoekt.SearchOptions{MaxDisplayBytes: budget})
+	if !hasLimits {
+		t.Fatal("expected limits")
+	}
+	got, hasMore := truncator([]zoekt.FileMatch{small, large})
+	if d := cmp.Diff([]string{"small", "large"}, names(got)); d != "" || !hasMore {
+		t.Fatalf("got hasMore %v (-want +got):\n%s", hasMore, d)
+	}
+	// The budget is used up across calls.
+	got, hasMore = truncator([]zoekt.FileMatch{file("next", 1)})
+	if len(got) != 0 || hasMore {
+		t.Fatalf("got %v, %v, want no more files", names(got), hasMore)
+	}
+
+	// The first file is kept even if it doesn't fit.
+	got = SortAndTruncateFiles([]zoekt.FileMatch{large, small}, &zoekt.SearchOptions{MaxDisplayBytes: 10})
+	if d := cmp.Diff([]string{"large"}, names(got)); d != "" {
+		t.Errorf("(-want +got):\n%s", d)
+	}
+}
+
+func TestRepoMatchLimiter(t *testing.T) {
+	file := func(repo string, fragments ...int) zoekt.FileMatch {
+		fm := zoekt.FileMatch{Repository: repo}
+		for _, n := range fragments {
+			fm.LineMatches = append(fm.LineMatches, zoekt.LineMatch{LineFragments: make([]zoekt.LineFragmentMatch, n)})
+		}
+		return fm
+	}
+	summarize := func(files []zoekt.FileMatch) map[string][][]int {
+		got := map[string][][]int{}
+		for _, fm := range files {
+			var matches []int
+			for _, lm := range fm.LineMatches {
+				matches = append(matches, len(lm.LineFragments))
+			}
+			got[fm.Repository] = append(got[fm.Repository], matches)
+		}
+		return got
+	}
+
+	limiter, hasLimit := NewRepoMatchLimiter(&zoekt.SearchOptions{MaxMatchesPerRepo: 3})
+	if !hasLimit {
+		t.Fatal("expected a limit")
+	}
+
+	var stats zoekt.Stats
+	got := summarize(limiter([]zoekt.FileMatch{
+		file("a", 1, 1),
+		file("b", 1),
+		file("a", 2),
+	}, &stats))
+	// The limit carries over to the next call.
+	for repo, files := range summarize(limiter([]zoekt.FileMatch{
+		file("b", 1, 1, 1),
+		file("a", 1),
+	}, &stats)) {
+		got[repo] = append(got[repo], files...)
+	}
+
+	want := map[string][][]int{
+		"a": {{1, 1}, {1}},
+		"b": {{1}, {1, 1}},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected files (-want, +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]zoekt.RepoStats{"a": {SuppressedMatches: 2}, "b": {SuppressedMatches: 1}}, stats.RepoStats); d != "" {
+		t.Errorf("unexpected suppressed matches (-want, +got):\n%s", d)
+	}
+
+	if _, hasLimit := NewRepoMatchLimiter(&zoekt.SearchOptions{}); hasLimit {
+		t.Error("expected no limit without MaxMatchesPerRepo")
+	}
+}
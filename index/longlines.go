@@ -0,0 +1,166 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Long lines, mostly in minified or generated files, can be megabytes long.
+// Matches on them return a window of the line around the matches instead of
+// the whole line. For the columns of the matches, the runes before them in the
+// line would still have to be counted, so the shard stores checkpoints with
+// the column at regular offsets of every long line.
+
+const (
+	// longLineLength is the length in bytes above which a line is long.
+	longLineLength = 4096
+
+	// longLineCheckpointInterval is the distance in bytes between the
+	// checkpoints of a long line.
+	longLineCheckpointInterval = 1024
+
+	// longLineContext is the number of bytes of a long line returned before
+	// and after the matches on it.
+	longLineContext = 256
+)
+
+// longLineCheckpoint is an offset in a long line with its column.
+type longLineCheckpoint struct {
+	// offset is the byte offset in the document. It is the start of a rune.
+	offset uint32
+
+	// runes is the number of runes in the line before offset.
+	runes uint32
+}
+
+// longLineCheckpoints returns the checkpoints of the long lines of data.
+func longLineCheckpoints(data []byte) []longLineCheckpoint {
+	var cps []longLineCheckpoint
+	lineStart := 0
+	for lineStart < len(data) {
+		lineEnd := len(data)
+		if i := bytes.IndexByte(data[lineStart:], '\n'); i >= 0 {
+			lineEnd = lineStart + i
+		}
+		if lineEnd-lineStart > longLineLength {
+			var runes uint32
+			last := lineStart
+			for off := lineStart + longLineCheckpointInterval; off < lineEnd; off += longLineCheckpointInterval {
+				for off < lineEnd && !utf8.RuneStart(data[off]) {
+					off++
+				}
+				runes += uint32(utf8.RuneCount(data[last:off]))
+				last = off
+				cps = append(cps, longLineCheckpoint{offset: uint32(off), runes: runes})
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return cps
+}
+
+// encodeLongLineCheckpoints encodes checkpoints as their number followed by
+// the delta of each offset and its column.
+func encodeLongLineCheckpoints(cps []longLineCheckpoint) []byte {
+	if len(cps) == 0 {
+		return nil
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(cps)))
+	var last uint32
+	for _, cp := range cps {
+		buf = binary.AppendUvarint(buf, uint64(cp.offset-last))
+		buf = binary.AppendUvarint(buf, uint64(cp.runes))
+		last = cp.offset
+	}
+	return buf
+}
+
+func decodeLongLineCheckpoints(blob []byte) ([]longLineCheckpoint, error) {
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	n, m := binary.Uvarint(blob)
+	if m <= 0 || n > uint64(len(blob)) {
+		return nil, fmt.Errorf("longLines: invalid checkpoint count")
+	}
+	blob = blob[m:]
+
+	cps := make([]longLineCheckpoint, 0, n)
+	var last uint32
+	for range n {
+		delta, m := binary.Uvarint(blob)
+		if m <= 0 {
+			return nil, fmt.Errorf("longLines: truncated checkpoint")
+		}
+		blob = blob[m:]
+		runes, m := binary.Uvarint(blob)
+		if m <= 0 {
+			return nil, fmt.Errorf("longLines: truncated checkpoint")
+		}
+		blob = blob[m:]
+		last += uint32(delta)
+		cps = append(cps, longLineCheckpoint{offset: last, runes: uint32(runes)})
+	}
+	return cps, nil
+}
+
+// readLongLines returns the checkpoints of the long lines of document i. It
+// returns nil for shards written without them.
+func (d *indexData) readLongLines(i uint32) ([]longLineCheckpoint, error) {
+	if d.longLinesIndex == nil {
+		return nil, nil
+	}
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.longLinesStart + d.longLinesIndex[i],
+		sz:  d.longLinesIndex[i+1] - d.longLinesIndex[i],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeLongLineCheckpoints(blob)
+}
+
+// longLineWindow returns the window of the line [lineStart, lineEnd) which
+// is returned for matches in [start, end): the matches with longLineContext
+// bytes around them, cut to whole runes.
+func longLineWindow(data []byte, lineStart, lineEnd, start, end int) (int, int) {
+	ws := max(lineStart, start-longLineContext)
+	for ws < start && !utf8.RuneStart(data[ws]) {
+		ws++
+	}
+	we := min(lineEnd, end+longLineContext)
+	for we > end && we < len(data) && !utf8.RuneStart(data[we]) {
+		we--
+	}
+	return ws, we
+}
+
+// splitLongLineMatches groups the sorted candidates on a long line into the
+// groups which are returned in one window. Each group spans at most
+// longLineLength bytes, unless a single match is longer.
+func splitLongLineMatches(ms []*candidateMatch) [][]*candidateMatch {
+	var groups [][]*candidateMatch
+	for len(ms) > 0 {
+		start := ms[0].byteOffset
+		n := 1
+		for n < len(ms) && ms[n].byteOffset+ms[n].byteMatchSz-start <= longLineLength {
+			n++
+		}
+		groups = append(groups, ms[:n])
+		ms = ms[n:]
+	}
+	return groups
+}
+
+// lastCheckpoint returns the last checkpoint in (lineStart, offset], if
+// there is one.
+func lastCheckpoint(cps []longLineCheckpoint, lineStart, offset uint32) (longLineCheckpoint, bool) {
+	i := sort.Search(len(cps), func(i int) bool { return cps[i].offset > offset })
+	if i == 0 || cps[i-1].offset <= lineStart {
+		return longLineCheckpoint{}, false
+	}
+	return cps[i-1], true
+}
@@ -0,0 +1,122 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestLongLineCheckpoints(t *testing.T) {
+	// A short line, a long line with multibyte runes, and a long last line
+	// without newline.
+	data := []byte("short\n" + strings.Repeat("aé", 3000) + "\n" + strings.Repeat("b", 5000))
+
+	cps := longLineCheckpoints(data)
+	if len(cps) == 0 {
+		t.Fatal("got no checkpoints")
+	}
+	for _, cp := range cps {
+		if !utf8.RuneStart(data[cp.offset]) {
+			t.Errorf("checkpoint %d is not at a rune start", cp.offset)
+		}
+		lineStart := bytes.LastIndexByte(data[:cp.offset], '\n') + 1
+		if want := uint32(utf8.RuneCount(data[lineStart:cp.offset])); cp.runes != want {
+			t.Errorf("checkpoint %d: got %d runes, want %d", cp.offset, cp.runes, want)
+		}
+	}
+
+	got, err := decodeLongLineCheckpoints(encodeLongLineCheckpoints(cps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(cps, got, cmp.AllowUnexported(longLineCheckpoint{})); d != "" {
+		t.Errorf("round trip (-want +got):\n%s", d)
+	}
+
+	if cps := longLineCheckpoints([]byte("short\nlines\n")); cps != nil {
+		t.Errorf("got checkpoints %v for short lines", cps)
+	}
+}
+
+func TestSearchLongLine(t *testing.T) {
+	prefix := strings.Repeat("é", 50000)
+	content := "first\n" + prefix + "needle" + strings.Repeat("x", 50000) + "needle\n"
+	b := testShardBuilder(t, nil, Document{Name: "min.js", Content: []byte(content)})
+
+	lineStart := len("first\n")
+	first := lineStart + len(prefix)
+	second := first + len("needle") + 50000
+	wantColumn := uint32(utf8.RuneCountInString(prefix) + 1)
+
+	t.Run("Checkpoints", func(t *testing.T) {
+		d := searcherForTest(t, b).(*indexData)
+		got, err := d.readLongLines(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(longLineCheckpoints([]byte(content)), got, cmp.AllowUnexported(longLineCheckpoint{})); d != "" {
+			t.Errorf("(-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("LineMatches", func(t *testing.T) {
+		res := searchForTest(t, b, &query.Substring{Pattern: "needle", Content: true})
+		if len(res.Files) != 1 {
+			t.Fatalf("got %d files, want 1", len(res.Files))
+		}
+		lms := res.Files[0].LineMatches
+		if len(lms) != 2 {
+			t.Fatalf("got %d line matches, want one per match", len(lms))
+		}
+		for i, wantOffset := range []int{first, second} {
+			lm := lms[i]
+			if lm.LineNumber != 2 || lm.LineStart != lineStart || lm.LineEnd != len(content) {
+				t.Errorf("%d: got line %d [%d, %d), want line 2 [%d, %d)", i, lm.LineNumber, lm.LineStart, lm.LineEnd, lineStart, len(content))
+			}
+			if len(lm.Line) > 2*longLineContext+len("needle") {
+				t.Errorf("%d: got line of %d bytes, want a window", i, len(lm.Line))
+			}
+			f := lm.LineFragments[0]
+			if int(f.Offset) != wantOffset {
+				t.Errorf("%d: got offset %d, want %d", i, f.Offset, wantOffset)
+			}
+			if got := string(lm.Line[f.LineOffset : f.LineOffset+f.MatchLength]); got != "needle" {
+				t.Errorf("%d: got fragment %q, want needle", i, got)
+			}
+		}
+	})
+
+	t.Run("ChunkMatches", func(t *testing.T) {
+		res := searchForTest(t, b, &query.Substring{Pattern: "needle", Content: true}, chunkOpts)
+		if len(res.Files) != 1 {
+			t.Fatalf("got %d files, want 1", len(res.Files))
+		}
+		cms := res.Files[0].ChunkMatches
+		if len(cms) != 2 {
+			t.Fatalf("got %d chunk matches, want one per match", len(cms))
+		}
+		cm := cms[0]
+		if len(cm.Content) > 2*longLineContext+len("needle") {
+			t.Errorf("got content of %d bytes, want a window", len(cm.Content))
+		}
+		r := cm.Ranges[0]
+		if int(r.Start.ByteOffset) != first || r.Start.LineNumber != 2 || r.Start.Column != wantColumn {
+			t.Errorf("got start %+v, want offset %d, line 2, column %d", r.Start, first, wantColumn)
+		}
+		start := r.Start.ByteOffset - cm.ContentStart.ByteOffset
+		if got := string(cm.Content[start : start+6]); got != "needle" {
+			t.Errorf("got range content %q, want needle", got)
+		}
+		if want := wantColumn - uint32(utf8.RuneCount(cm.Content[:start])); cm.ContentStart.Column != want {
+			t.Errorf("got content start column %d, want %d", cm.ContentStart.Column, want)
+		}
+		if int(cms[1].Ranges[0].Start.ByteOffset) != second {
+			t.Errorf("got second match at %d, want %d", cms[1].Ranges[0].Start.ByteOffset, second)
+		}
+	})
+}
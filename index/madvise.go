@@ -0,0 +1,167 @@
+package index
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Advice is a hint about how a region of an index file will be accessed. For
+// mmapped index files it is passed on to the kernel via madvise(2).
+type Advice int
+
+const (
+	// AdviceNormal leaves the default readahead behaviour of the kernel in
+	// place.
+	AdviceNormal Advice = iota
+	// AdviceRandom disables readahead. This is useful for content on machines
+	// where the index does not fit into the page cache.
+	AdviceRandom
+	// AdviceSequential enables aggressive readahead.
+	AdviceSequential
+	// AdviceWillNeed asks the kernel to start reading the region into the page
+	// cache in the background.
+	AdviceWillNeed
+)
+
+var adviceNames = map[Advice]string{
+	AdviceNormal:     "normal",
+	AdviceRandom:     "random",
+	AdviceSequential: "sequential",
+	AdviceWillNeed:   "willneed",
+}
+
+func (a Advice) String() string {
+	if s, ok := adviceNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Advice(%d)", int(a))
+}
+
+func parseAdvice(s string) (Advice, error) {
+	for a, name := range adviceNames {
+		if name == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown madvise advice %q", s)
+}
+
+// adviser is implemented by IndexFiles which can pass access pattern hints on
+// to the operating system.
+type adviser interface {
+	advise(off, sz uint64, advice Advice) error
+}
+
+// LoadOptions tune how the sections of a shard are paged in after the shard
+// has been loaded. The zero value leaves everything to the kernel.
+type LoadOptions struct {
+	// Prefetch starts reading the ngram postings and the filename sections
+	// into the page cache when the shard is loaded. This avoids paying for the
+	// page faults on the first queries after a restart.
+	Prefetch bool
+
+	// ContentAdvice is applied to the file contents section.
+	ContentAdvice Advice
+
+	// PostingsAdvice is applied to the content and filename postings.
+	PostingsAdvice Advice
+
+	// NamesAdvice is applied to the filename contents.
+	NamesAdvice Advice
+}
+
+// ParseLoadOptions parses a comma separated list of key=value pairs. The
+// supported keys are:
+//
+//	prefetch: prefetch=1 enables LoadOptions.Prefetch.
+//
+//	content, postings, names: the advice for the respective sections. One of
+//	normal, random, sequential or willneed.
+//
+// The empty string returns the zero value.
+func ParseLoadOptions(v string) (LoadOptions, error) {
+	var opts LoadOptions
+	for _, kv := range strings.Split(v, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return LoadOptions{}, fmt.Errorf("invalid load option %q: want key=value", kv)
+		}
+
+		var err error
+		switch key {
+		case "prefetch":
+			opts.Prefetch, err = strconv.ParseBool(value)
+		case "content":
+			opts.ContentAdvice, err = parseAdvice(value)
+		case "postings":
+			opts.PostingsAdvice, err = parseAdvice(value)
+		case "names":
+			opts.NamesAdvice, err = parseAdvice(value)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return LoadOptions{}, fmt.Errorf("invalid load option %q: %w", kv, err)
+		}
+	}
+	return opts, nil
+}
+
+var metricShardPrefetchBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "zoekt_shard_prefetch_bytes_total",
+	Help: "The total number of bytes of shard sections we asked the kernel to prefetch.",
+})
+
+// apply passes the advice in o for the sections in toc on to f. It is a no-op
+// if f does not support advice. Errors are not fatal for loading a shard, so
+// the first error is returned for logging only after all sections have been
+// advised.
+func (o *LoadOptions) apply(f IndexFile, toc *indexTOC) error {
+	a, ok := f.(adviser)
+	if !ok {
+		return nil
+	}
+
+	var firstErr error
+	advise := func(advice Advice, secs ...simpleSection) {
+		for _, sec := range secs {
+			if sec.sz == 0 {
+				continue
+			}
+			if err := a.advise(sec.off, sec.sz, advice); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("madvise %s %s: %w", f.Name(), advice, err)
+			}
+			if advice == AdviceWillNeed {
+				metricShardPrefetchBytes.Add(float64(sec.sz))
+			}
+		}
+	}
+
+	postings := []simpleSection{toc.postings.data, toc.namePostings.data, toc.foldedNamePostings.data, toc.foldedSymbolPostings.data, toc.symbolPostings.data}
+	names := []simpleSection{toc.fileNames.data}
+
+	if o.ContentAdvice != AdviceNormal {
+		advise(o.ContentAdvice, toc.fileContents.data)
+	}
+	if o.PostingsAdvice != AdviceNormal {
+		advise(o.PostingsAdvice, postings...)
+	}
+	if o.NamesAdvice != AdviceNormal {
+		advise(o.NamesAdvice, names...)
+	}
+
+	// Prefetching is done last so it isn't undone by the per section advice.
+	if o.Prefetch {
+		advise(AdviceWillNeed, append(postings, names...)...)
+	}
+
+	return firstErr
+}
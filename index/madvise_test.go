@@ -0,0 +1,74 @@
+package index
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLoadOptions(t *testing.T) {
+	cases := map[string]LoadOptions{
+		"":           {},
+		"prefetch=1": {Prefetch: true},
+		"prefetch=true, content=random,postings=willneed,names=sequential": {
+			Prefetch:       true,
+			ContentAdvice:  AdviceRandom,
+			PostingsAdvice: AdviceWillNeed,
+			NamesAdvice:    AdviceSequential,
+		},
+	}
+	for v, want := range cases {
+		got, err := ParseLoadOptions(v)
+		if err != nil {
+			t.Fatalf("ParseLoadOptions(%q): %v", v, err)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("ParseLoadOptions(%q) mismatch (-want, +got):\n%s", v, d)
+		}
+	}
+
+	for _, v := range []string{"prefetch", "content=fast", "foo=1", "prefetch=maybe"} {
+		if _, err := ParseLoadOptions(v); err == nil {
+			t.Errorf("ParseLoadOptions(%q): expected error", v)
+		}
+	}
+}
+
+func TestNewSearcherOptions(t *testing.T) {
+	opts := LoadOptions{
+		Prefetch:       true,
+		ContentAdvice:  AdviceRandom,
+		PostingsAdvice: AdviceWillNeed,
+		NamesAdvice:    AdviceSequential,
+	}
+
+	for _, fn := range []string{
+		"../testdata/shards/repo_v16.00000.zoekt",
+		"../testdata/shards/repo17_v17.00000.zoekt",
+	} {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iFile, err := NewIndexFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rd := &reader{r: iFile}
+		var toc indexTOC
+		if err := rd.readTOC(&toc); err != nil {
+			t.Fatal(err)
+		}
+		if err := opts.apply(iFile, &toc); err != nil {
+			t.Fatalf("%s: apply: %v", fn, err)
+		}
+
+		s, err := NewSearcherOptions(iFile, opts)
+		if err != nil {
+			t.Fatalf("%s: NewSearcherOptions: %v", fn, err)
+		}
+		s.Close()
+	}
+}
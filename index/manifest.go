@@ -0,0 +1,108 @@
+package index
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Content-addressed shards are named by a hash of their content instead of
+// their number, so a new build never overwrites a shard a reader may have
+// open, and identical shards get the same name wherever they are built. They
+// are published with a manifest per repository listing its shards. Writing
+// the new shards doesn't change what is searched: readers only load the
+// content-addressed shards a manifest lists, and the manifest is replaced
+// with a rename once all of them are in place.
+
+// ShardManifest lists the published shards of a repository.
+type ShardManifest struct {
+	// Shards are the file names of the shards, relative to the directory of
+	// the manifest.
+	Shards []string
+}
+
+// contentHashLen is the length of the hex encoded content hash in the name
+// of a content-addressed shard.
+const contentHashLen = 32
+
+// ShardManifestName returns the name of the manifest for the shards with the
+// given prefix.
+func ShardManifestName(indexDir string, prefix string) string {
+	return filepath.Join(indexDir, escapeShardPrefix(prefix)+".manifest")
+}
+
+// ContentAddressedShardName returns the name of the shard with the given
+// content hash.
+func ContentAddressedShardName(indexDir string, prefix string, version int, hash []byte) string {
+	return filepath.Join(indexDir, fmt.Sprintf("%s_v%d.%s.zoekt", escapeShardPrefix(prefix), version, hex.EncodeToString(hash)[:contentHashLen]))
+}
+
+func escapeShardPrefix(prefix string) string {
+	prefix = url.QueryEscape(prefix)
+	if len(prefix) > 200 {
+		prefix = prefix[:200] + hashString(prefix)[:8]
+	}
+	return prefix
+}
+
+// IsContentAddressedShard returns whether path is the name of a
+// content-addressed shard. These are only searched once a manifest lists
+// them.
+func IsContentAddressedShard(path string) bool {
+	name, ok := strings.CutSuffix(filepath.Base(path), ".zoekt")
+	if !ok {
+		return false
+	}
+	dot := strings.LastIndexByte(name, '.')
+	if dot < 0 || len(name)-dot-1 != contentHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(name[dot+1:])
+	return err == nil
+}
+
+// ReadShardManifest reads the manifest in path.
+func ReadShardManifest(path string) (*ShardManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m ShardManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// writeShardManifest replaces the manifest in path with m. Readers see
+// either the previous or the new manifest.
+func writeShardManifest(path string, m *ShardManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if runtime.GOOS != "windows" {
+		if err := f.Chmod(0o666 &^ umask); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -126,6 +126,10 @@ type ngramDocIterator struct {
 	// mutable
 	fileIdx    uint32
 	matchCount int
+
+	// outOfBounds is the number of hits which were not candidates because
+	// the pattern would cross a document boundary.
+	outOfBounds int
 }
 
 // nextFileIndex returns the smallest index j of ends such that
@@ -196,6 +200,7 @@ func (i *ngramDocIterator) candidates() []*candidateMatch {
 		i.iter.next(p1)
 
 		if p1 < i.leftPad+fileStart || p1+i.rightPad > fileEnd {
+			i.outOfBounds++
 			continue
 		}
 
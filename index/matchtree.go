@@ -19,9 +19,12 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"slices"
 	"strings"
+	"time"
 	"unicode/utf8"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
@@ -135,6 +138,10 @@ type docMatchTree struct {
 
 	predicate func(docID uint32) bool
 
+	// docs optionally holds the documents for which predicate returns true,
+	// so nextDoc can skip to the next one instead of testing each document.
+	docs *roaring.Bitmap
+
 	// provides additional information about the reason why the docMatchTree was
 	// created.
 	reason string
@@ -142,6 +149,7 @@ type docMatchTree struct {
 	// mutable
 	firstDone bool
 	docID     uint32
+	docsIt    roaring.IntPeekable
 }
 
 type bruteForceMatchTree struct {
@@ -176,6 +184,22 @@ type boostMatchTree struct {
 	boost float64
 }
 
+// Evaluates child once the cheaper match trees have decided, and only for
+// the documents they leave. Once the evaluation took timeout, it matches no
+// more documents.
+type budgetMatchTree struct {
+	child   matchTree
+	timeout time.Duration
+
+	// mutable
+	spent     time.Duration
+	exhausted bool
+
+	// The time spent and exhaustion reported by updateStats.
+	reportedSpent     time.Duration
+	reportedExhausted bool
+}
+
 // Don't visit this subtree for collecting matches.
 type noVisitMatchTree struct {
 	matchTree
@@ -190,10 +214,18 @@ type regexpMatchTree struct {
 
 	fileName bool
 
+	// trimCR drops the carriage return of CRLF line endings from the
+	// matches.
+	trimCR bool
+
 	// mutable
 	reEvaluated bool
 	found       []*candidateMatch
 
+	// filesEvaluated and filesMatched are reported in AtomStats.
+	filesEvaluated int
+	filesMatched   int
+
 	// nextDoc, prepare.
 	bruteForceMatchTree
 }
@@ -204,11 +236,49 @@ func newRegexpMatchTree(s *query.Regexp) *regexpMatchTree {
 		prefix = "(?i)"
 	}
 
+	// In content, $ also matches before the \r of a CRLF line ending, unless
+	// the pattern looks for carriage returns itself.
+	re, trimCR := s.Regexp, false
+	if !s.FileName && !hasLiteralCR(s.Regexp) {
+		re, trimCR = endLineBeforeCR(s.Regexp), true
+	}
+
 	return &regexpMatchTree{
-		regexp:     regexp.MustCompile(prefix + syntaxutil.RegexpString(s.Regexp)),
+		regexp:     compileRegexp(prefix + syntaxutil.RegexpString(re)),
 		origRegexp: s.Regexp,
 		fileName:   s.FileName,
+		trimCR:     trimCR,
+	}
+}
+
+// hasLiteralCR returns true if re contains a literal carriage return.
+func hasLiteralCR(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral && slices.Contains(re.Rune, '\r') {
+		return true
 	}
+	return slices.ContainsFunc(re.Sub, hasLiteralCR)
+}
+
+// endLineBeforeCR returns a copy of re in which every $ may be preceded by a
+// carriage return, so it matches at the end of CRLF lines. The carriage
+// return becomes part of the match, and must be trimmed off it.
+func endLineBeforeCR(re *syntax.Regexp) *syntax.Regexp {
+	if re.Op == syntax.OpEndLine || re.Op == syntax.OpEndText && re.Flags&syntax.WasDollar != 0 {
+		return &syntax.Regexp{Op: syntax.OpConcat, Sub: []*syntax.Regexp{
+			{Op: syntax.OpQuest, Sub: []*syntax.Regexp{{Op: syntax.OpLiteral, Rune: []rune{'\r'}}}},
+			re,
+		}}
+	}
+	if len(re.Sub) == 0 {
+		return re
+	}
+	c := *re
+	c.Sub = make([]*syntax.Regexp, len(re.Sub))
+	c.Sub0 = [1]*syntax.Regexp{}
+	for i, sub := range re.Sub {
+		c.Sub[i] = endLineBeforeCR(sub)
+	}
+	return &c
 }
 
 // \bLITERAL\b
@@ -221,6 +291,10 @@ type wordMatchTree struct {
 	evaluated bool
 	found     []*candidateMatch
 
+	// filesEvaluated and filesMatched are reported in AtomStats.
+	filesEvaluated int
+	filesMatched   int
+
 	// nextDoc, prepare.
 	bruteForceMatchTree
 }
@@ -231,10 +305,17 @@ type substrMatchTree struct {
 	query         *query.Substring
 	caseSensitive bool
 	fileName      bool
+	symbol        bool
 
 	// mutable
 	current       []*candidateMatch
 	contEvaluated bool
+
+	// candidatesRejected, filesEvaluated and filesMatched are reported in
+	// AtomStats.
+	candidatesRejected int
+	filesEvaluated     int
+	filesMatched       int
 }
 
 type branchQueryMatchTree struct {
@@ -419,6 +500,10 @@ func (t *boostMatchTree) prepare(doc uint32) {
 	t.child.prepare(doc)
 }
 
+func (t *budgetMatchTree) prepare(doc uint32) {
+	t.child.prepare(doc)
+}
+
 func (t *substrMatchTree) prepare(nextDoc uint32) {
 	t.matchIterator.prepare(nextDoc)
 	t.current = t.matchIterator.candidates()
@@ -437,6 +522,16 @@ func (t *docMatchTree) nextDoc() uint32 {
 	if t.firstDone {
 		start = t.docID + 1
 	}
+	if t.docs != nil {
+		if t.docsIt == nil {
+			t.docsIt = t.docs.Iterator()
+		}
+		t.docsIt.AdvanceIfNeeded(start)
+		if t.docsIt.HasNext() {
+			return t.docsIt.PeekNext()
+		}
+		return maxUInt32
+	}
 	for i := start; i < t.numDocs; i++ {
 		if t.predicate(i) {
 			return i
@@ -486,6 +581,10 @@ func (t *boostMatchTree) nextDoc() uint32 {
 	return t.child.nextDoc()
 }
 
+func (t *budgetMatchTree) nextDoc() uint32 {
+	return t.child.nextDoc()
+}
+
 func (t *branchQueryMatchTree) nextDoc() uint32 {
 	var start uint32
 	if t.firstDone {
@@ -550,6 +649,10 @@ func (t *boostMatchTree) String() string {
 	return fmt.Sprintf("boost(%f, %v)", t.boost, t.child)
 }
 
+func (t *budgetMatchTree) String() string {
+	return fmt.Sprintf("budget(%s, %v)", t.timeout, t.child)
+}
+
 func (t *substrMatchTree) String() string {
 	f := ""
 	if t.fileName {
@@ -593,6 +696,10 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 		visitMatchTree(s.child, f)
 	case *boostMatchTree:
 		visitMatchTree(s.child, f)
+	case *budgetMatchTree:
+		// The budget reports its own stats.
+		f(s)
+		visitMatchTree(s.child, f)
 	case *symbolSubstrMatchTree:
 		visitMatchTree(s.substrMatchTree, f)
 	case *symbolRegexpMatchTree:
@@ -612,6 +719,80 @@ func updateMatchTreeStats(mt matchTree, stats *zoekt.Stats) {
 	})
 }
 
+// updateAtomStats is like updateMatchTreeStats, but additionally adds the
+// statistics of each substring, regexp and word atom to stats.Atoms.
+func updateAtomStats(mt matchTree, stats *zoekt.Stats) {
+	visitMatchTree(mt, func(mt matchTree) {
+		var a zoekt.AtomStats
+		switch s := mt.(type) {
+		case *substrMatchTree:
+			a.Atom = s.query.String()
+			if s.symbol {
+				a.Atom = "sym:" + a.Atom
+			}
+			a.CandidatesRejected, a.FilesEvaluated, a.FilesMatched = s.candidatesRejected, s.filesEvaluated, s.filesMatched
+			s.candidatesRejected, s.filesEvaluated, s.filesMatched = 0, 0, 0
+			addNgramAtomStats(s.matchIterator, &a)
+		case *regexpMatchTree:
+			a.Atom = s.String()
+			a.FilesEvaluated, a.FilesMatched = s.filesEvaluated, s.filesMatched
+			s.filesEvaluated, s.filesMatched = 0, 0
+		case *wordMatchTree:
+			a.Atom = s.String()
+			a.FilesEvaluated, a.FilesMatched = s.filesEvaluated, s.filesMatched
+			s.filesEvaluated, s.filesMatched = 0, 0
+		}
+
+		var atomStats zoekt.Stats
+		if atom, ok := mt.(interface{ updateStats(*zoekt.Stats) }); ok {
+			atom.updateStats(&atomStats)
+		}
+		stats.Add(atomStats)
+
+		if a.Atom != "" {
+			a.NgramLookups = atomStats.NgramLookups
+			a.IndexBytesLoaded = atomStats.IndexBytesLoaded
+			a.NgramMatches = atomStats.NgramMatches
+			stats.AddAtom(a)
+		}
+	})
+}
+
+// addNgramAtomStats adds the posting list sizes of the ngrams used by it and
+// the hits it rejected to a.
+func addNgramAtomStats(it matchIterator, a *zoekt.AtomStats) {
+	if res, ok := it.(*ngramIterationResults); ok {
+		it = res.matchIterator
+	}
+	ngramIt, ok := it.(*ngramDocIterator)
+	if !ok {
+		return
+	}
+
+	a.CandidatesOutOfBounds += ngramIt.outOfBounds
+	ngramIt.outOfBounds = 0
+
+	var visit func(hitIterator)
+	visit = func(it hitIterator) {
+		switch s := it.(type) {
+		case *distanceHitIterator:
+			visit(s.i1)
+			visit(s.i2)
+		case *mergingIterator:
+			for _, it := range s.iters {
+				visit(it)
+			}
+		case *compressedPostingIterator:
+			if a.Ngrams == nil {
+				a.Ngrams = map[string]int64{}
+			}
+			a.Ngrams[s.what.String()] += int64(s.postingsSize)
+			s.postingsSize = 0
+		}
+	}
+	visit(ngramIt.iter)
+}
+
 func visitMatchAtoms(t matchTree, known map[matchTree]bool, f func(matchTree)) {
 	visitMatches(t, known, 1, func(mt matchTree, _ float64) {
 		f(mt)
@@ -638,6 +819,8 @@ func visitMatches(t matchTree, known map[matchTree]bool, weight float64, f func(
 		}
 	case *boostMatchTree:
 		visitMatches(s.child, known, weight*s.boost, f)
+	case *budgetMatchTree:
+		visitMatches(s.child, known, weight, f)
 	case *symbolSubstrMatchTree:
 		visitMatches(s.substrMatchTree, known, weight, f)
 	case *notMatchTree:
@@ -799,12 +982,17 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 	}
 
 	cp.stats.RegexpsConsidered++
-	idxs := t.regexp.FindAllIndex(cp.data(t.fileName), -1)
+	data := cp.data(t.fileName)
+	idxs := t.regexp.FindAllIndex(data, -1)
 	found := t.found[:0]
 	for _, idx := range idxs {
+		end := idx[1]
+		if t.trimCR && end > idx[0] && data[end-1] == '\r' && (end == len(data) || data[end] == '\n') {
+			end--
+		}
 		cm := &candidateMatch{
 			byteOffset:  uint32(idx[0]),
-			byteMatchSz: uint32(idx[1] - idx[0]),
+			byteMatchSz: uint32(end - idx[0]),
 			fileName:    t.fileName,
 		}
 
@@ -812,6 +1000,10 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 	}
 	t.found = found
 	t.reEvaluated = true
+	t.filesEvaluated++
+	if len(found) > 0 {
+		t.filesMatched++
+	}
 
 	return matchesStateForSlice(t.found)
 }
@@ -851,6 +1043,10 @@ func (t *wordMatchTree) matches(cp *contentProvider, cost int, known map[matchTr
 
 	t.found = found
 	t.evaluated = true
+	t.filesEvaluated++
+	if len(found) > 0 {
+		t.filesMatched++
+	}
 
 	return matchesStateForSlice(t.found)
 }
@@ -866,14 +1062,19 @@ func breakMatchesOnNewlines(cms []*candidateMatch, text []byte) []*candidateMatc
 }
 
 // breakOnNewlines returns matches resulting from breaking cm on newlines
-// within text.
+// within text. The carriage return of a CRLF line ending is not part of the
+// match on the line.
 func breakOnNewlines(cm *candidateMatch, text []byte) []*candidateMatch {
 	var cms []*candidateMatch
 	addMe := &candidateMatch{}
 	*addMe = *cm
 	for i := uint32(cm.byteOffset); i < cm.byteOffset+cm.byteMatchSz; i++ {
 		if text[i] == '\n' {
-			addMe.byteMatchSz = i - addMe.byteOffset
+			end := i
+			if end > addMe.byteOffset && text[end-1] == '\r' {
+				end--
+			}
+			addMe.byteMatchSz = end - addMe.byteOffset
 			if addMe.byteMatchSz != 0 {
 				cms = append(cms, addMe)
 			}
@@ -927,6 +1128,29 @@ func (t *boostMatchTree) matches(cp *contentProvider, cost int, known map[matchT
 	return evalMatchTree(cp, cost, known, t.child)
 }
 
+func (t *budgetMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if cost < costMax {
+		return matchesRequiresHigherCost
+	}
+	if t.exhausted {
+		return matchesNone
+	}
+	start := time.Now()
+	ms := evalMatchTree(cp, cost, known, t.child)
+	t.spent += time.Since(start)
+	t.exhausted = t.spent >= t.timeout
+	return ms
+}
+
+func (t *budgetMatchTree) updateStats(s *zoekt.Stats) {
+	s.MatchTreeBudgetSearch += t.spent - t.reportedSpent
+	t.reportedSpent = t.spent
+	if t.exhausted && !t.reportedExhausted {
+		s.BudgetsExhausted++
+		t.reportedExhausted = true
+	}
+}
+
 func (t *substrMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	if t.contEvaluated {
 		return matchesStateForSlice(t.current)
@@ -953,6 +1177,11 @@ func (t *substrMatchTree) matches(cp *contentProvider, cost int, known map[match
 			pruned = append(pruned, m)
 		}
 	}
+	t.candidatesRejected += len(t.current) - len(pruned)
+	t.filesEvaluated++
+	if len(pruned) > 0 {
+		t.filesMatched++
+	}
 	t.current = pruned
 	t.contEvaluated = true
 
@@ -963,6 +1192,10 @@ type matchTreeOpt struct {
 	// DisableWordMatchOptimization is used to disable the use of wordMatchTree.
 	// This was added since we do not support wordMatchTree with symbol search.
 	DisableWordMatchOptimization bool
+
+	// symbol is set for the children of query.Symbol, which only match
+	// within symbols.
+	symbol bool
 }
 
 func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error) {
@@ -976,7 +1209,7 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 		// original regexp, it returns true. An equivalent matchTree has the same
 		// behaviour as the original regexp and can be used instead.
 		//
-		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(s.Regexp, ngramSize, s.FileName, s.CaseSensitive)
+		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(s.Regexp, ngramSize, s.FileName, s.CaseSensitive, opt.symbol)
 		if err != nil {
 			return nil, err
 		}
@@ -1001,15 +1234,21 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			},
 		}, nil
 	case *query.And:
-		var r []matchTree
+		// The children with a time budget go last, so the other children of
+		// the same cost rule out documents first.
+		var r, budgeted []matchTree
 		for _, ch := range s.Children {
 			ct, err := d.newMatchTree(ch, opt)
 			if err != nil {
 				return nil, err
 			}
-			r = append(r, ct)
+			if _, ok := ct.(*budgetMatchTree); ok {
+				budgeted = append(budgeted, ct)
+			} else {
+				r = append(r, ct)
+			}
 		}
-		return &andMatchTree{r}, nil
+		return &andMatchTree{append(r, budgeted...)}, nil
 	case *query.Or:
 		var r []matchTree
 		for _, ch := range s.Children {
@@ -1051,8 +1290,19 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			boost: s.Boost,
 		}, nil
 
+	case *query.Budget:
+		ct, err := d.newMatchTree(s.Child, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		return &budgetMatchTree{
+			child:   ct,
+			timeout: s.Timeout,
+		}, nil
+
 	case *query.Substring:
-		return d.newSubstringMatchTree(s)
+		return d.newSubstringMatchTree(s, opt.symbol)
 
 	case *query.Branch:
 		masks := make([]uint64, 0, len(d.repoMetaData))
@@ -1083,22 +1333,30 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			return &noMatchTree{Why: "const"}, nil
 		}
 	case *query.Language:
-		code, ok := d.metaData.LanguageMap[s.Language]
+		code, ok := d.languageCode(s.Language)
 		if !ok {
 			return &noMatchTree{Why: "lang"}, nil
 		}
 		return &docMatchTree{
 			reason:  "language",
 			numDocs: d.numDocs(),
+			docs:    d.languageDocuments(code),
 			predicate: func(docID uint32) bool {
 				return d.getLanguage(docID) == code
 			},
 		}, nil
 
+	case *query.DocFlag:
+		return d.docFlagMatchTree(s)
+
+	case *query.Meta:
+		return d.metaMatchTree(s), nil
+
 	case *query.Symbol:
 		// Disable WordMatchTree since we don't support it in symbols yet.
 		optCopy := opt
 		optCopy.DisableWordMatchOptimization = true
+		optCopy.symbol = true
 
 		subMT, err := d.newMatchTree(s.Expr, optCopy)
 		if err != nil {
@@ -1131,6 +1389,19 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			matchTree: subMT,
 		}, nil
 
+	case *query.Fuzzy:
+		return d.newFuzzyMatchTree(s)
+
+	case *query.PathDepth:
+		return &docMatchTree{
+			reason:  "PathDepth",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				depth := bytes.Count(d.fileName(docID), []byte{'/'})
+				return depth >= s.Min && (s.Max < 0 || depth <= s.Max)
+			},
+		}, nil
+
 	case *query.FileNameSet:
 		return &docMatchTree{
 			reason:  "FileNameSet",
@@ -1234,23 +1505,47 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 	return nil, nil
 }
 
-func (d *indexData) newSubstringMatchTree(s *query.Substring) (matchTree, error) {
+func (d *indexData) newSubstringMatchTree(s *query.Substring, symbol bool) (matchTree, error) {
 	st := &substrMatchTree{
 		query:         s,
 		caseSensitive: s.CaseSensitive,
 		fileName:      s.FileName,
+		symbol:        symbol,
 	}
 
-	if utf8.RuneCountInString(s.Pattern) < ngramSize {
-		return newRegexpMatchTree(&query.Regexp{
+	// Without trigrams to look up, we scan the documents for the pattern.
+	if utf8.RuneCountInString(s.Pattern) < ngramSize || d.onlyStopNgrams(s, symbol) {
+		mt := newRegexpMatchTree(&query.Regexp{
 			Regexp:        &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune(s.Pattern)},
 			FileName:      s.FileName,
 			Content:       s.Content,
 			CaseSensitive: s.CaseSensitive,
-		}), nil
+		})
+
+		// Only the documents with the CJK bigrams of the pattern need to
+		// be scanned.
+		docs, ok, err := d.cjkBigramDocs(s.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return mt, nil
+		}
+		if docs.IsEmpty() {
+			return &noMatchTree{Why: "cjk bigrams"}, nil
+		}
+		return &andMatchTree{children: []matchTree{
+			&docMatchTree{
+				reason:    "cjk bigrams",
+				numDocs:   d.numDocs(),
+				docs:      docs,
+				predicate: docs.Contains,
+			},
+			mt,
+		}}, nil
 	}
 
-	result, err := d.iterateNgrams(s)
+	result, err := d.iterateNgrams(s, symbol)
 	if err != nil {
 		return nil, err
 	}
@@ -1352,6 +1647,14 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 		if mt.child == nil {
 			return nil, nil
 		}
+	case *budgetMatchTree:
+		mt.child, err = pruneMatchTree(mt.child)
+		if err != nil {
+			return nil, err
+		}
+		if mt.child == nil {
+			return nil, nil
+		}
 	case *andLineMatchTree:
 		child, err := pruneMatchTree(&mt.andMatchTree)
 		if err != nil {
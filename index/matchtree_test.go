@@ -139,6 +139,26 @@ func Test_breakOnNewlines(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "crlf",
+			args: args{
+				cm: &candidateMatch{
+					byteOffset:  0,
+					byteMatchSz: 4,
+				},
+				text: []byte("a\r\nb"),
+			},
+			want: []*candidateMatch{
+				{
+					byteOffset:  0,
+					byteMatchSz: 1,
+				},
+				{
+					byteOffset:  3,
+					byteMatchSz: 1,
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -101,6 +101,53 @@ func merge(ds ...*indexData) (*ShardBuilder, error) {
 
 	sb := newShardBuilder()
 	sb.indexFormatVersion = NextIndexFormatVersion
+	// The documents were told apart from binary ones when they were first
+	// indexed, see Options.MaxNULBytes.
+	sb.skipBinaryCheck()
+	// Compound shards often hold forks and vendored copies of the same
+	// files.
+	sb.enableContentDedup()
+	for _, d := range ds {
+		if d.hasFoldedNgrams() {
+			sb.enableFoldedNgrams()
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.symbolNgrams.bt != nil {
+			sb.enableSymbolNgrams()
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.symbolHashes != nil {
+			sb.enableSymbolHashes()
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.fuzzySymbols != nil {
+			sb.enableFuzzySymbols()
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.cjkBigrams != nil {
+			sb.enableCJKBigrams()
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.contentCiphers != nil {
+			sb.enableContentEncryption(getContentKeys())
+			break
+		}
+	}
+	for _, d := range ds {
+		if d.stopNgrams != nil {
+			sb.enableStopNgrams(max(sb.maxTrigramFrequency, d.stopNgrams.maxFrequency))
+		}
+	}
 
 	for _, d := range ds {
 		lastRepoID := -1
@@ -123,6 +170,11 @@ func merge(ds ...*indexData) (*ShardBuilder, error) {
 				if err := sb.setRepository(&d.repoMetaData[repoID]); err != nil {
 					return nil, err
 				}
+				// The compound shard is as recent as its most recently
+				// indexed repository.
+				if t := d.repoMetaData[repoID].IndexTime; t.After(sb.IndexTime) {
+					sb.IndexTime = t
+				}
 			}
 
 			if err := addDocument(d, sb, repoID, docID); err != nil {
@@ -199,9 +251,32 @@ func explode(dstDir string, f IndexFile, ibFuncs ...shardBuilderFunc) (map[strin
 
 			sb = newShardBuilder()
 			sb.indexFormatVersion = IndexFormatVersion
+			sb.skipBinaryCheck()
+			if d.hasFoldedNgrams() {
+				sb.enableFoldedNgrams()
+			}
+			if d.symbolNgrams.bt != nil {
+				sb.enableSymbolNgrams()
+			}
+			if d.symbolHashes != nil {
+				sb.enableSymbolHashes()
+			}
+			if d.fuzzySymbols != nil {
+				sb.enableFuzzySymbols()
+			}
+			if d.cjkBigrams != nil {
+				sb.enableCJKBigrams()
+			}
+			if d.contentCiphers != nil {
+				sb.enableContentEncryption(getContentKeys())
+			}
+			if d.stopNgrams != nil {
+				sb.enableStopNgrams(d.stopNgrams.maxFrequency)
+			}
 			if err := sb.setRepository(&d.repoMetaData[repoID]); err != nil {
 				return shardNames, err
 			}
+			sb.IndexTime = d.repoMetaData[repoID].IndexTime
 		}
 
 		err := addDocument(d, sb, repoID, docID)
@@ -226,6 +301,8 @@ func addDocument(d *indexData, ib *ShardBuilder, repoID int, docID uint32) error
 		// Branches set below since it requires lookups
 		SubRepositoryPath: d.subRepoPaths[repoID][d.subRepos[docID]],
 		Language:          d.languageMap[d.getLanguage(docID)],
+		RankingSignal:     d.rankingSignal(docID),
+		Labels:            d.labels(docID),
 		// SkipReason not set, will be part of content from original indexer.
 	}
 
@@ -241,6 +318,9 @@ func addDocument(d *indexData, ib *ShardBuilder, repoID int, docID uint32) error
 	doc.SymbolsMetaData = make([]*zoekt.Symbol, len(doc.Symbols))
 	for i := range doc.SymbolsMetaData {
 		doc.SymbolsMetaData[i] = d.symbols.data(d.fileEndSymbol[docID] + uint32(i))
+		if doc.SymbolsMetaData[i] != nil {
+			doc.SymbolsMetaData[i].Doc = d.symbolDocumentation(docID, uint32(i))
+		}
 	}
 
 	// calculate branches
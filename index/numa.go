@@ -0,0 +1,11 @@
+package index
+
+// NUMANode returns the NUMA node most of the resident pages of the shard are
+// on, or -1 if it isn't known, eg. because none of its pages have been faulted
+// in yet or the platform doesn't tell.
+func (d *indexData) NUMANode() int {
+	if n, ok := d.file.(interface{ numaNode() int }); ok {
+		return n.numaNode()
+	}
+	return -1
+}
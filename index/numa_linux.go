@@ -0,0 +1,58 @@
+//go:build linux
+
+package index
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Flags of get_mempolicy(2) to return the node of the page at an address.
+const (
+	mpolFNode = 1 << 0
+	mpolFAddr = 1 << 1
+)
+
+// numaSamplePages is the number of resident pages numaNode looks up.
+const numaSamplePages = 64
+
+// numaNode returns the NUMA node most of a sample of the resident pages of f
+// are on, or -1 if none are resident. Pages which aren't resident are not
+// looked up, since looking them up faults them in.
+func (f *mmapedIndexFile) numaNode() int {
+	pageSize := os.Getpagesize()
+	pages := (len(f.data) + pageSize - 1) / pageSize
+	if pages == 0 {
+		return -1
+	}
+	resident := make([]byte, pages)
+	if _, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&f.data[0])), uintptr(len(f.data)), uintptr(unsafe.Pointer(&resident[0]))); errno != 0 {
+		return -1
+	}
+
+	// Look up the first resident page of each of numaSamplePages windows.
+	counts := map[int]int{}
+	step := max(pages/numaSamplePages, 1)
+	for start := 0; start < pages; start += step {
+		for p := start; p < min(start+step, pages); p++ {
+			if resident[p]&1 == 0 {
+				continue
+			}
+			var node int32
+			if _, _, errno := unix.Syscall6(unix.SYS_GET_MEMPOLICY, uintptr(unsafe.Pointer(&node)), 0, 0, uintptr(unsafe.Pointer(&f.data[p*pageSize])), mpolFNode|mpolFAddr, 0); errno == 0 {
+				counts[int(node)]++
+			}
+			break
+		}
+	}
+
+	best := -1
+	for node, n := range counts {
+		if best < 0 || n > counts[best] || n == counts[best] && node < best {
+			best = node
+		}
+	}
+	return best
+}
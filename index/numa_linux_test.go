@@ -0,0 +1,45 @@
+//go:build linux
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapedIndexFile_numaNode(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(fn, make([]byte, 3*os.Getpagesize()+1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Fault in the pages.
+	sum := 0
+	for _, b := range r.(*mmapedIndexFile).data[:3*os.Getpagesize()+1] {
+		sum += int(b)
+	}
+	if sum != 0 {
+		t.Fatal("got non-zero content")
+	}
+
+	node := r.(*mmapedIndexFile).numaNode()
+	if node == -1 {
+		t.Skip("get_mempolicy(2) is not available")
+	}
+	if _, err := os.Stat(filepath.Join("/sys/devices/system/node", fmt.Sprintf("node%d", node))); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	} else if os.IsNotExist(err) && node != 0 {
+		t.Errorf("got node %d, which doesn't exist", node)
+	}
+}
@@ -0,0 +1,169 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Overlay is a small set of changes to a repository of an index, eg. the
+// diff of an unmerged pull request.
+type Overlay struct {
+	// Repository is the name of the repository in the base index.
+	Repository string
+
+	// Branch is the branch the changed documents are reported on. It
+	// defaults to the first branch of the repository.
+	Branch string
+
+	// Version is the version the changed documents are reported with, eg.
+	// the head commit of the pull request. It defaults to the version of
+	// Branch in the base index.
+	Version string
+
+	// Documents are the added and modified documents. Their branches are
+	// ignored.
+	Documents []Document
+
+	// Deleted lists the paths of the documents which were removed.
+	Deleted []string
+}
+
+// NewOverlaySearcher returns a searcher which searches base as if the changes
+// of o were applied to it. The changed documents are indexed in memory, and
+// their matches replace the matches of the same paths in the repository of
+// base. The paths are replaced on all branches of the repository.
+//
+// Closing the searcher doesn't close base.
+func NewOverlaySearcher(ctx context.Context, base zoekt.Streamer, o Overlay) (zoekt.Streamer, error) {
+	rl, err := base.List(ctx, query.NewRepoSet(o.Repository), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rl.Repos) == 0 {
+		return nil, fmt.Errorf("overlay: %w: %s", zoekt.ErrRepositoryNotFound, o.Repository)
+	}
+
+	repo := rl.Repos[0].Repository
+	branch := zoekt.RepositoryBranch{Name: o.Branch, Version: o.Version}
+	for _, b := range repo.Branches {
+		if branch.Name == "" || b.Name == branch.Name {
+			branch.Name = b.Name
+			if branch.Version == "" {
+				branch.Version = b.Version
+			}
+			break
+		}
+	}
+	if branch.Name == "" {
+		return nil, fmt.Errorf("overlay: %s has no branches", o.Repository)
+	}
+	repo.Branches = []zoekt.RepositoryBranch{branch}
+
+	b, err := NewShardBuilder(&repo)
+	if err != nil {
+		return nil, err
+	}
+	paths := append([]string{}, o.Deleted...)
+	for _, doc := range o.Documents {
+		doc.Branches = []string{branch.Name}
+		if err := b.Add(doc); err != nil {
+			return nil, fmt.Errorf("overlay: adding %s: %w", doc.Name, err)
+		}
+		paths = append(paths, doc.Name)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		return nil, err
+	}
+	overlay, err := NewSearcher(&memIndexFile{name: "overlay of " + o.Repository, data: buf.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	var exclude query.Q = &query.Const{Value: true}
+	if len(paths) > 0 {
+		exclude = &query.Not{Child: query.NewAnd(query.NewRepoSet(o.Repository), query.NewFileNameSet(paths...))}
+	}
+	return &overlaySearcher{
+		base:    base,
+		overlay: overlay,
+		exclude: exclude,
+		repo:    o.Repository,
+	}, nil
+}
+
+type overlaySearcher struct {
+	base    zoekt.Streamer
+	overlay zoekt.Searcher
+
+	// exclude matches the documents of base outside of the overlay.
+	exclude query.Q
+	repo    string
+}
+
+func (s *overlaySearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	sr, err := s.base.Search(ctx, query.NewAnd(q, s.exclude), opts)
+	if err != nil {
+		return nil, err
+	}
+	osr, err := s.overlay.Search(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.Stats.Add(osr.Stats)
+	sr.Files = SortAndTruncateFiles(append(sr.Files, osr.Files...), opts)
+	return sr, nil
+}
+
+// StreamSearch sends the matches of the overlay before streaming the
+// matches of base.
+func (s *overlaySearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	osr, err := s.overlay.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(osr)
+	return s.base.StreamSearch(ctx, query.NewAnd(q, s.exclude), opts, sender)
+}
+
+// List lists the repositories of base. The overlay adds none.
+func (s *overlaySearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return s.base.List(ctx, q, opts)
+}
+
+func (s *overlaySearcher) Close() {
+	s.overlay.Close()
+}
+
+func (s *overlaySearcher) String() string {
+	return fmt.Sprintf("overlay(%s, %s)", s.repo, s.base)
+}
+
+// memIndexFile is an IndexFile held in memory.
+type memIndexFile struct {
+	name string
+	data []byte
+}
+
+func (f *memIndexFile) Read(off, sz uint64) ([]byte, error) {
+	if off > off+sz || off+sz > uint64(len(f.data)) {
+		return nil, fmt.Errorf("out of bounds: %d, len %d, name %s", off+sz, len(f.data), f.name)
+	}
+	return f.data[off : off+sz], nil
+}
+
+func (f *memIndexFile) Name() string {
+	return f.name
+}
+
+func (f *memIndexFile) Size() (uint64, error) {
+	return uint64(len(f.data)), nil
+}
+
+func (f *memIndexFile) Close() {}
@@ -0,0 +1,97 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// streamer streams the results of a Searcher in one event.
+type streamer struct {
+	zoekt.Searcher
+}
+
+func (s streamer) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sr, err := s.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(sr)
+	return nil
+}
+
+func TestOverlaySearcher(t *testing.T) {
+	repo := &zoekt.Repository{
+		Name:     "repo",
+		Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}},
+	}
+	onMain := []string{"main"}
+	base := streamer{searcherForTest(t, testShardBuilder(t, repo,
+		Document{Name: "same.go", Content: []byte("needle"), Branches: onMain},
+		Document{Name: "modified.go", Content: []byte("needle"), Branches: onMain},
+		Document{Name: "deleted.go", Content: []byte("needle"), Branches: onMain},
+	))}
+
+	s, err := NewOverlaySearcher(context.Background(), base, Overlay{
+		Repository: "repo",
+		Version:    "pr",
+		Documents: []Document{
+			{Name: "modified.go", Content: []byte("haystack needle")},
+			{Name: "added.go", Content: []byte("needle")},
+		},
+		Deleted: []string{"deleted.go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	versions := func(files []zoekt.FileMatch) map[string]string {
+		got := map[string]string{}
+		for _, f := range files {
+			got[f.FileName] = f.Version
+			if !slices.Equal(f.Branches, onMain) {
+				t.Errorf("%s: got branches %v, want %v", f.FileName, f.Branches, onMain)
+			}
+		}
+		return got
+	}
+
+	sr, err := s.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"same.go": "v1", "modified.go": "pr", "added.go": "pr"}
+	if got := versions(sr.Files); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only the overlay has the new content of modified.go.
+	sr, err = s.Search(context.Background(), &query.Substring{Pattern: "haystack"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(sr.Files); !maps.Equal(got, map[string]string{"modified.go": "pr"}) {
+		t.Errorf("got %v for the modified content", got)
+	}
+
+	var streamed []zoekt.FileMatch
+	err = s.StreamSearch(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		streamed = append(streamed, sr.Files...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(streamed); !maps.Equal(got, want) {
+		t.Errorf("streamed %v, want %v", got, want)
+	}
+
+	if _, err := NewOverlaySearcher(context.Background(), base, Overlay{Repository: "missing"}); !errors.Is(err, zoekt.ErrRepositoryNotFound) {
+		t.Errorf("got %v for a missing repository, want ErrRepositoryNotFound", err)
+	}
+}
@@ -0,0 +1,26 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// provenance returns where the document cp is set to was matched, see
+// zoekt.SearchOptions.Provenance. It loads the content of the document to
+// digest it.
+func (d *indexData) provenance(cp *contentProvider) *zoekt.Provenance {
+	sum := sha256.Sum256(cp.data(false))
+	return &zoekt.Provenance{
+		ShardFile:           filepath.Base(d.file.Name()),
+		ShardID:             d.metaData.ID,
+		IndexTime:           d.repoMetaData[d.repos[cp.idx]].IndexTime,
+		ZoektVersion:        d.metaData.ZoektVersion,
+		IndexFormatVersion:  d.metaData.IndexFormatVersion,
+		IndexFeatureVersion: d.metaData.IndexFeatureVersion,
+		Checksum:            hex.EncodeToString(d.getChecksum(cp.idx)),
+		ContentSHA256:       hex.EncodeToString(sum[:]),
+	}
+}
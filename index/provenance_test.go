@@ -0,0 +1,89 @@
+package index
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestProvenance(t *testing.T) {
+	content := []byte("func parseQuery() {}\n")
+	b := testShardBuilder(t, nil,
+		Document{Name: "a.go", Content: content},
+		Document{Name: "b.go", Content: []byte("package query\n")},
+	)
+	s := searcherForTest(t, b)
+	q := &query.Substring{Pattern: "parseQuery"}
+
+	res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{Provenance: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(res.Files))
+	}
+	f := res.Files[0]
+	p := f.Provenance
+	if p == nil {
+		t.Fatal("got no provenance with Provenance")
+	}
+
+	md := s.(*indexData).metaData
+	sum := sha256.Sum256(content)
+	want := zoekt.Provenance{
+		ShardFile:           p.ShardFile,
+		ShardID:             md.ID,
+		IndexTime:           md.IndexTime,
+		ZoektVersion:        md.ZoektVersion,
+		IndexFormatVersion:  md.IndexFormatVersion,
+		IndexFeatureVersion: md.IndexFeatureVersion,
+		Checksum:            hex.EncodeToString(f.Checksum),
+		ContentSHA256:       hex.EncodeToString(sum[:]),
+	}
+	if *p != want {
+		t.Errorf("got provenance %+v, want %+v", *p, want)
+	}
+	if p.ShardFile == "" {
+		t.Error("got no shard file")
+	}
+
+	res, err = s.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := res.Files[0].Provenance; p != nil {
+		t.Errorf("got provenance %+v without Provenance", p)
+	}
+
+	t.Run("compound", func(t *testing.T) {
+		repos := []*zoekt.Repository{
+			{Name: "repo1", IndexTime: time.Unix(1000, 0)},
+			{Name: "repo2", IndexTime: time.Unix(2000, 0)},
+		}
+		b := testShardBuilderCompound(t, repos, [][]Document{
+			{{Name: "a.go", Content: content}},
+			{{Name: "b.go", Content: content}},
+		})
+		res, err := searcherForTest(t, b).Search(context.Background(), q, &zoekt.SearchOptions{Provenance: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != len(repos) {
+			t.Fatalf("got %d files, want %d", len(res.Files), len(repos))
+		}
+		for _, f := range res.Files {
+			want := repos[0].IndexTime
+			if f.Repository == "repo2" {
+				want = repos[1].IndexTime
+			}
+			if got := f.Provenance.IndexTime; !got.Equal(want) {
+				t.Errorf("%s: got index time %v, want the one of the repository %v", f.Repository, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// RankingSignals are scores of the files of a repository computed offline,
+// eg. the centrality of the files in its import graph. They are read from a
+// JSON file like
+//
+//	{"default": 0.5, "paths": {"cmd/main.go": 12.5, "internal/util.go": 3}}
+//
+// The scores are normalized to [0, 1] by the largest one, and stored as the
+// RankingSignal of the documents.
+type RankingSignals struct {
+	// Default is the score of files missing from Paths. Low priority files,
+	// see IsLowPriority, get 0 instead.
+	Default float64 `json:"default"`
+
+	Paths map[string]float64 `json:"paths"`
+}
+
+// LoadRankingSignals reads and normalizes the ranking signals in the JSON
+// file path.
+func LoadRankingSignals(path string) (*RankingSignals, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r RankingSignals
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("parsing ranking signals %s: %w", path, err)
+	}
+
+	maxScore := r.Default
+	for p, s := range r.Paths {
+		if s < 0 || math.IsNaN(s) || math.IsInf(s, 0) {
+			return nil, fmt.Errorf("ranking signals %s: invalid score %v for %s", path, s, p)
+		}
+		maxScore = max(maxScore, s)
+	}
+	if r.Default < 0 || math.IsNaN(r.Default) || math.IsInf(r.Default, 0) {
+		return nil, fmt.Errorf("ranking signals %s: invalid default score %v", path, r.Default)
+	}
+	if maxScore > 0 {
+		r.Default /= maxScore
+		for p := range r.Paths {
+			r.Paths[p] /= maxScore
+		}
+	}
+	return &r, nil
+}
+
+// signal returns the ranking signal of the file path.
+func (r *RankingSignals) signal(path string, content []byte) float64 {
+	if s, ok := r.Paths[path]; ok {
+		return s
+	}
+	if IsLowPriority(path, content) {
+		return 0
+	}
+	return r.Default
+}
+
+func encodeRankingSignals(signals []float32) []byte {
+	buf := make([]byte, 0, 4*len(signals))
+	for _, s := range signals {
+		buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(s))
+	}
+	return buf
+}
+
+func decodeRankingSignals(blob []byte, numDocs uint32) ([]float32, error) {
+	if len(blob) != 4*int(numDocs) {
+		return nil, fmt.Errorf("rankingSignals: got %d bytes for %d documents", len(blob), numDocs)
+	}
+	signals := make([]float32, numDocs)
+	for i := range signals {
+		signals[i] = math.Float32frombits(binary.BigEndian.Uint32(blob[4*i:]))
+	}
+	return signals, nil
+}
+
+// rankingSignal returns the ranking signal of document doc.
+func (d *indexData) rankingSignal(doc uint32) float64 {
+	if d.rankingSignals == nil {
+		return 0
+	}
+	return float64(d.rankingSignals[doc])
+}
@@ -0,0 +1,73 @@
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestLoadRankingSignals(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signals.json")
+	if err := os.WriteFile(path, []byte(`{"default": 1, "paths": {"main.go": 4, "util.go": 2}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadRankingSignals(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path string
+		want float64
+	}{
+		{"main.go", 1},
+		{"util.go", 0.5},
+		{"other.go", 0.25},
+		{"other_test.go", 0},
+	} {
+		if got := r.signal(tc.path, nil); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.path, got, tc.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"paths": {"main.go": -1}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRankingSignals(path); err == nil {
+		t.Error("got no error for a negative score")
+	}
+}
+
+func TestSearchRankingSignals(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle")},
+		Document{Name: "f2", Content: []byte("needle"), RankingSignal: 1})
+
+	for _, tc := range []struct {
+		weight float64
+		want   string
+	}{
+		// Without a weight, the document order breaks the tie.
+		{weight: 0, want: "f1"},
+		{weight: 1, want: "f2"},
+	} {
+		searcher := searcherForTest(t, b)
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{RankingSignalsWeight: tc.weight})
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFiles(res.Files)
+		if len(res.Files) != 2 || res.Files[0].FileName != tc.want {
+			t.Errorf("weight %v: got %+v, want %s first", tc.weight, res.Files, tc.want)
+		}
+	}
+
+	d := searcherForTest(t, b).(*indexData)
+	if got := d.rankingSignal(1); got != 1 {
+		t.Errorf("got ranking signal %v, want 1", got)
+	}
+}
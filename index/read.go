@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"hash/crc64"
 	"log"
+	"math"
 	"os"
 	"slices"
 	"sort"
@@ -35,8 +36,8 @@ import (
 // IndexFile is a file suitable for concurrent read access. For performance
 // reasons, it allows a mmap'd implementation.
 type IndexFile interface {
-	Read(off uint32, sz uint32) ([]byte, error)
-	Size() (uint32, error)
+	Read(off uint64, sz uint64) ([]byte, error)
+	Size() (uint64, error)
 	Close()
 	Name() string
 }
@@ -44,10 +45,10 @@ type IndexFile interface {
 // reader is a stateful file
 type reader struct {
 	r   IndexFile
-	off uint32
+	off uint64
 }
 
-func (r *reader) seek(off uint32) {
+func (r *reader) seek(off uint64) {
 	r.off = off
 }
 
@@ -91,11 +92,11 @@ func (r *reader) Str() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	b, err := r.r.Read(r.off, uint32(slen))
+	b, err := r.r.Read(r.off, slen)
 	if err != nil {
 		return "", err
 	}
-	r.off += uint32(slen)
+	r.off += slen
 	return string(b), nil
 }
 
@@ -128,14 +129,15 @@ func (r *reader) readTOCSections(toc *indexTOC, tags []string) error {
 			}
 
 			skipSection := len(tags) > 0 && !slices.Contains(tags, tag)
+			secKind, wide := decodeKind(sectionKind(kind))
 			sec := secs[tag]
-			if sec == nil || sec.kind() != sectionKind(kind) {
+			if sec == nil || sec.kind() != secKind {
 				// If we don't recognize the section, we may be reading a newer index than the current version. Use
 				// a "dummy section" struct to skip over it.
 				skipSection = true
 				log.Printf("encountered unrecognized index section (%s), skipping over it", tag)
 
-				switch sectionKind(kind) {
+				switch secKind {
 				case sectionKindSimple:
 					sec = &simpleSection{}
 				case sectionKindCompound:
@@ -148,11 +150,11 @@ func (r *reader) readTOCSections(toc *indexTOC, tags []string) error {
 			}
 
 			if skipSection {
-				if err := sec.skip(r); err != nil {
+				if err := sec.skip(r, wide); err != nil {
 					return err
 				}
 			} else {
-				if err := sec.read(r); err != nil {
+				if err := sec.read(r, wide); err != nil {
 					return err
 				}
 			}
@@ -171,7 +173,7 @@ func (r *reader) readTOCSections(toc *indexTOC, tags []string) error {
 		}
 
 		for _, s := range secs {
-			if err := s.read(r); err != nil {
+			if err := s.read(r, false); err != nil {
 				return err
 			}
 		}
@@ -184,13 +186,28 @@ func (r *reader) readHeader() (simpleSection, uint32, error) {
 	if err != nil {
 		return simpleSection{}, 0, err
 	}
+	if sz < 8 {
+		return simpleSection{}, 0, fmt.Errorf("file too small: %d bytes", sz)
+	}
 	r.off = sz - 8
 
 	var tocSection simpleSection
-	if err := tocSection.read(r); err != nil {
+	if err := tocSection.read(r, false); err != nil {
 		return simpleSection{}, 0, err
 	}
 
+	if tocSection.off == math.MaxUint32 && tocSection.sz == math.MaxUint32 {
+		// The TOC ends beyond 4GB, so the trailer contains its 64-bit
+		// section followed by wideTrailerMarker.
+		if sz < wideTrailerSize {
+			return simpleSection{}, 0, fmt.Errorf("file too small: %d bytes", sz)
+		}
+		r.off = sz - wideTrailerSize
+		if err := tocSection.read(r, true); err != nil {
+			return simpleSection{}, 0, err
+		}
+	}
+
 	r.seek(tocSection.off)
 
 	sectionCount, err := r.U32()
@@ -220,6 +237,23 @@ func readSectionU32(f IndexFile, sec simpleSection) ([]uint32, error) {
 	return arr, nil
 }
 
+// readSectionOffsets reads a section of 32-bit or, if wide is set, 64-bit
+// offsets.
+func readSectionOffsets(f IndexFile, sec simpleSection, wide bool) ([]uint64, error) {
+	if wide {
+		return readSectionU64(f, sec)
+	}
+	arr32, err := readSectionU32(f, sec)
+	if err != nil {
+		return nil, err
+	}
+	arr := make([]uint64, len(arr32))
+	for i, o := range arr32 {
+		arr[i] = uint64(o)
+	}
+	return arr, nil
+}
+
 func readSectionU64(f IndexFile, sec simpleSection) ([]uint64, error) {
 	if sec.sz%8 != 0 {
 		return nil, fmt.Errorf("barf: section size %% 8 != 0: sz %d ", sec.sz)
@@ -254,9 +288,10 @@ func canReadVersion(md *zoekt.IndexMetadata) bool {
 
 func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 	d := indexData{
-		file:        r.r,
-		branchIDs:   []map[string]uint{},
-		branchNames: []map[uint]string{},
+		file:            r.r,
+		branchIDs:       []map[string]uint{},
+		branchNames:     []map[uint]string{},
+		ngramSelections: newBoundedCache[ngramSelectionKey, ngramSelection](ngramSelectionCacheSize, "ngrams"),
 	}
 
 	repos, md, err := r.parseMetadata(toc.metaData, toc.repoMetaData)
@@ -276,16 +311,34 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, fmt.Errorf("file is feature version %d, want feature version >= %d", d.metaData.IndexFeatureVersion, ReadMinFeatureVersion)
 	}
 
-	if d.metaData.IndexMinReaderVersion > FeatureVersion {
-		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, FeatureVersion)
+	if d.metaData.IndexMinReaderVersion > ReadMaxFeatureVersion {
+		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, ReadMaxFeatureVersion)
 	}
 
 	d.boundariesStart = toc.fileContents.data.off
-	d.boundaries = toc.fileContents.relativeIndex()
+	if toc.contentSources.sz > 0 {
+		if err := d.readDedupedContentsIndex(toc); err != nil {
+			return nil, err
+		}
+	} else if toc.contentBoundaries.sz > 0 {
+		if err := d.readEncryptedContents(toc); err != nil {
+			return nil, err
+		}
+	} else {
+		d.boundaries = toc.fileContents.relativeIndex()
+	}
 	d.newlinesStart = toc.newlines.data.off
 	d.newlinesIndex = toc.newlines.relativeIndex()
 	d.docSectionsStart = toc.fileSections.data.off
 	d.docSectionsIndex = toc.fileSections.relativeIndex()
+	if toc.longLines.data.sz > 0 {
+		d.longLinesStart = toc.longLines.data.off
+		d.longLinesIndex = toc.longLines.relativeIndex()
+	}
+	if toc.symbolDocs.data.sz > 0 {
+		d.symbolDocsStart = toc.symbolDocs.data.off
+		d.symbolDocsIndex = toc.symbolDocs.relativeIndex()
+	}
 
 	d.symbols.symKindIndex = toc.symbolKindMap.relativeIndex()
 	d.fileEndSymbol, err = readSectionU32(d.file, toc.fileEndSymbol)
@@ -338,6 +391,94 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	if toc.foldedNameNgramText.sz > 0 {
+		d.foldedFileNameNgrams, err = d.newBtreeIndex(toc.foldedNameNgramText, toc.foldedNamePostings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if toc.foldedSymbolNgramText.sz > 0 {
+		d.foldedSymbolNgrams, err = d.newBtreeIndex(toc.foldedSymbolNgramText, toc.foldedSymbolPostings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if toc.symbolNgramText.sz > 0 {
+		d.symbolNgrams, err = d.newBtreeIndex(toc.symbolNgramText, toc.symbolPostings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if toc.symbolHashes.sz > 0 {
+		blob, err := d.readSectionBlob(toc.symbolHashes)
+		if err != nil {
+			return nil, err
+		}
+		d.symbolHashes = symbolHashIndex(blob)
+	}
+	if toc.fuzzySymbols.sz > 0 {
+		blob, err := d.readSectionBlob(toc.fuzzySymbols)
+		if err != nil {
+			return nil, err
+		}
+		d.fuzzySymbols = symbolHashIndex(blob)
+	}
+	if toc.rankingSignals.sz > 0 {
+		blob, err := d.readSectionBlob(toc.rankingSignals)
+		if err != nil {
+			return nil, err
+		}
+		if d.rankingSignals, err = decodeRankingSignals(blob, d.numDocs()); err != nil {
+			return nil, err
+		}
+	}
+	if toc.docLabels.sz > 0 {
+		blob, err := d.readSectionBlob(toc.docLabels)
+		if err != nil {
+			return nil, err
+		}
+		if d.labelSets, d.docLabels, err = decodeDocLabels(blob, d.numDocs()); err != nil {
+			return nil, err
+		}
+	}
+	var docFlags []byte
+	if toc.docFlags.sz > 0 {
+		blob, err := d.readSectionBlob(toc.docFlags)
+		if err != nil {
+			return nil, err
+		}
+		if docFlags, err = decodeDocFlags(blob, d.numDocs()); err != nil {
+			return nil, err
+		}
+	}
+	if toc.repoBloom.sz > 0 {
+		blob, err := d.readSectionBlob(toc.repoBloom)
+		if err != nil {
+			return nil, err
+		}
+		if d.repoBloom, err = decodeRepoBloom(blob); err != nil {
+			return nil, err
+		}
+	}
+	if toc.cjkBigrams.sz > 0 {
+		blob, err := d.readSectionBlob(toc.cjkBigrams)
+		if err != nil {
+			return nil, err
+		}
+		if d.cjkBigrams, err = decodeCJKBigrams(blob); err != nil {
+			return nil, err
+		}
+	}
+	if toc.stopNgrams.sz > 0 {
+		blob, err := d.readSectionBlob(toc.stopNgrams)
+		if err != nil {
+			return nil, err
+		}
+		if d.stopNgrams, err = decodeStopNgrams(blob); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, md := range d.repoMetaData {
 		repoBranchIDs := make(map[string]uint, len(md.Branches))
 		repoBranchNames := make(map[uint]string, len(md.Branches))
@@ -348,6 +489,7 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		}
 		d.branchIDs = append(d.branchIDs, repoBranchIDs)
 		d.branchNames = append(d.branchNames, repoBranchNames)
+		d.branchOrders = append(d.branchOrders, branchOrder(&md))
 		d.rawConfigMasks = append(d.rawConfigMasks, encodeRawConfig(md.RawConfig))
 	}
 
@@ -358,14 +500,22 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 
 	d.runeDocSections = unmarshalDocSections(blob, nil)
 
-	var runeOffsets, fileNameRuneOffsets []uint32
-
-	for sect, dest := range map[simpleSection]*[]uint32{
-		toc.subRepos:        &d.subRepos,
+	var runeOffsets, fileNameRuneOffsets []uint64
+	for sect, dest := range map[simpleSection]*[]uint64{
 		toc.runeOffsets:     &runeOffsets,
 		toc.nameRuneOffsets: &fileNameRuneOffsets,
-		toc.nameEndRunes:    &d.fileNameEndRunes,
-		toc.fileEndRunes:    &d.fileEndRunes,
+	} {
+		if blob, err := d.readSectionBlob(sect); err != nil {
+			return nil, err
+		} else {
+			*dest = fromSizedDeltas64(blob, nil)
+		}
+	}
+
+	for sect, dest := range map[simpleSection]*[]uint32{
+		toc.subRepos:     &d.subRepos,
+		toc.nameEndRunes: &d.fileNameEndRunes,
+		toc.fileEndRunes: &d.fileEndRunes,
 	} {
 		if blob, err := d.readSectionBlob(sect); err != nil {
 			return nil, err
@@ -410,6 +560,14 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		d.repos = make([]uint16, len(d.fileBranchMasks))
 	}
 
+	if d.contentCiphers != nil {
+		if err := d.verifyContentKeys(); err != nil {
+			return nil, err
+		}
+	}
+
+	d.buildTestDocs(docFlags)
+
 	if err := d.calculateStats(); err != nil {
 		return nil, err
 	}
@@ -457,6 +615,14 @@ func (r *reader) parseMetadata(metaData simpleSection, repoMetaData simpleSectio
 		md.ID = backfillID(repos[0].Name)
 	}
 
+	// Shards written before the index time of their repositories was
+	// recorded only have the index time of the shard.
+	for _, repo := range repos {
+		if repo.IndexTime.IsZero() {
+			repo.IndexTime = md.IndexTime
+		}
+	}
+
 	return repos, &md, nil
 }
 
@@ -485,6 +651,7 @@ func (d *indexData) newBtreeIndex(ngramSec simpleSection, postings compoundSecti
 	// hold on to simple sections (8 bytes each)
 	bi.ngramSec = ngramSec
 	bi.postingIndex = postings.index
+	bi.widePostingIndex = postings.wideIndex
 
 	return bi, nil
 }
@@ -513,18 +680,26 @@ func (d *indexData) verify() error {
 }
 
 func (d *indexData) readContents(i uint32) ([]byte, error) {
+	if d.contentCiphers != nil {
+		return d.readDecryptedContents(i)
+	}
+	if d.contentSources != nil {
+		return d.readDedupedContents(i)
+	}
 	return d.readSectionBlob(simpleSection{
 		off: d.boundariesStart + d.boundaries[i],
 		sz:  d.boundaries[i+1] - d.boundaries[i],
 	})
 }
 
-func (d *indexData) readContentSlice(off uint32, sz uint32) ([]byte, error) {
+// readContentSlice reads sz bytes of the contents at offset off. It is only
+// valid for shards whose stored contents follow the boundaries.
+func (d *indexData) readContentSlice(off uint64, sz uint32) ([]byte, error) {
 	// TODO(hanwen): cap result if it is at the end of the content
 	// section.
 	return d.readSectionBlob(simpleSection{
 		off: d.boundariesStart + off,
-		sz:  sz,
+		sz:  uint64(sz),
 	})
 }
 
@@ -545,7 +720,7 @@ func (d *indexData) readNewlines(i uint32, buf []uint32) ([]uint32, uint32, erro
 	if nl == nil {
 		nl = make([]uint32, 0)
 	}
-	return nl, sec.sz, nil
+	return nl, uint32(sec.sz), nil
 }
 
 func (d *indexData) readDocSections(i uint32, buf []DocumentSection) ([]DocumentSection, uint32, error) {
@@ -566,7 +741,7 @@ func (d *indexData) readDocSections(i uint32, buf []DocumentSection) ([]Document
 		ds = make([]DocumentSection, 0)
 	}
 
-	return ds, sec.sz, nil
+	return ds, uint32(sec.sz), nil
 }
 
 // NewSearcher creates a Searcher for a single index file.  Search
@@ -574,6 +749,12 @@ func (d *indexData) readDocSections(i uint32, buf []DocumentSection) ([]Document
 // of the Searcher itself, ie. []byte members should be copied into
 // fresh buffers if the result is to survive closing the shard.
 func NewSearcher(r IndexFile) (zoekt.Searcher, error) {
+	return NewSearcherOptions(r, LoadOptions{})
+}
+
+// NewSearcherOptions is like NewSearcher, but additionally applies opts to
+// the sections of r once the index data has been read.
+func NewSearcherOptions(r IndexFile, opts LoadOptions) (zoekt.Searcher, error) {
 	rd := &reader{r: r}
 
 	var toc indexTOC
@@ -585,6 +766,11 @@ func NewSearcher(r IndexFile) (zoekt.Searcher, error) {
 		return nil, err
 	}
 	indexData.file = r
+
+	if err := opts.apply(r, &toc); err != nil {
+		log.Printf("WARN failed to apply load options: %v", err)
+	}
+
 	return indexData, nil
 }
 
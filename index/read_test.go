@@ -17,9 +17,11 @@ package index
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -79,6 +81,105 @@ func TestReadWrite(t *testing.T) {
 	}
 }
 
+// offsetSeeker is an IndexFile whose data starts at base. It lets us test
+// shards larger than 4GB without writing them to memory.
+type offsetSeeker struct {
+	base uint64
+	data []byte
+}
+
+func (s *offsetSeeker) Name() string { return "offsetseeker" }
+func (s *offsetSeeker) Close()       {}
+
+func (s *offsetSeeker) Read(off, sz uint64) ([]byte, error) {
+	if sz == 0 {
+		// Sections which were never written are empty and start at 0.
+		return nil, nil
+	}
+	if off < s.base || off+sz > s.base+uint64(len(s.data)) {
+		return nil, fmt.Errorf("out of bounds: off %d, sz %d", off, sz)
+	}
+	return s.data[off-s.base : off-s.base+sz], nil
+}
+
+func (s *offsetSeeker) Size() (uint64, error) {
+	return s.base + uint64(len(s.data)), nil
+}
+
+func TestReadWriteWide(t *testing.T) {
+	b, err := NewShardBuilder(nil)
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.AddFile("filename", []byte("abcde\nfghij")); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+
+	// Write the shard as if it was preceded by 5GB of data, so all its
+	// sections need 64-bit offsets.
+	const base = 5 << 30
+	var buf bytes.Buffer
+	w := &writer{w: &buf, off: base}
+	if err := b.write(w); err != nil {
+		t.Fatal(err)
+	}
+	f := &offsetSeeker{base: base, data: buf.Bytes()}
+
+	if got := binary.BigEndian.Uint64(buf.Bytes()[buf.Len()-8:]); got != wideTrailerMarker {
+		t.Fatalf("got trailer marker %x, want %x", got, uint64(wideTrailerMarker))
+	}
+
+	r := reader{r: f}
+	var toc indexTOC
+	if err := r.readTOC(&toc); err != nil {
+		t.Fatalf("readTOC: %v", err)
+	}
+	if !toc.postings.wideIndex || !toc.fileContents.wideIndex {
+		t.Errorf("compound sections were not read with 64-bit offsets")
+	}
+
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		t.Fatalf("NewSearcher: %v", err)
+	}
+	defer searcher.Close()
+
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "ghi", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+		t.Fatalf("got %v, want 1 line match", res.Files)
+	}
+	if got := string(res.Files[0].LineMatches[0].Line); got != "fghij" {
+		t.Errorf("got line %q, want %q", got, "fghij")
+	}
+}
+
+func TestSectionKinds(t *testing.T) {
+	narrow := &simpleSection{off: 10, sz: 20}
+	wide := &simpleSection{off: math.MaxUint32, sz: 1}
+
+	for _, tc := range []struct {
+		sec  section
+		want sectionKind
+	}{
+		{narrow, sectionKindSimple},
+		{wide, sectionKindSimple64},
+		{&compoundSection{}, sectionKindCompound},
+		{&compoundSection{wideIndex: true}, sectionKindCompound64},
+		{&lazyCompoundSection{compoundSection{wideIndex: true}}, sectionKindCompoundLazy64},
+	} {
+		got := encodedKind(tc.sec)
+		if got != tc.want {
+			t.Errorf("%T: got kind %d, want %d", tc.sec, got, tc.want)
+		}
+		if kind, isWide := decodeKind(got); kind != tc.sec.kind() || isWide != tc.sec.wide() {
+			t.Errorf("%T: decodeKind(%d) = %d, %v", tc.sec, got, kind, isWide)
+		}
+	}
+}
+
 func TestReadWriteNames(t *testing.T) {
 	b, err := NewShardBuilder(nil)
 	if err != nil {
@@ -109,7 +210,7 @@ func TestReadWriteNames(t *testing.T) {
 	if err != nil {
 		t.Fatalf("readIndexData: %v", err)
 	}
-	if !reflect.DeepEqual([]uint32{0, 4}, data.fileNameIndex) {
+	if !reflect.DeepEqual([]uint64{0, 4}, data.fileNameIndex) {
 		t.Errorf("got index %v, want {0,4}", data.fileNameIndex)
 	}
 
@@ -151,7 +252,7 @@ func TestGet(t *testing.T) {
 		t.Fatalf("readIndexData: %v", err)
 	}
 
-	var off uint32 = 96
+	var off uint64 = 96
 
 	cases := []struct {
 		ng              string
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// chunkFile is an IndexFile of the data written to it. Large writes are kept
+// without copying them, so shards with contents backed by a mapping without
+// memory can be written and read.
+type chunkFile struct {
+	offs   []uint64
+	chunks [][]byte
+	size   uint64
+}
+
+func (f *chunkFile) Write(b []byte) (int, error) {
+	if n := len(f.chunks); len(b) < 1<<20 && n > 0 && len(f.chunks[n-1]) < 1<<20 {
+		f.chunks[n-1] = append(f.chunks[n-1], b...)
+	} else {
+		if len(b) < 1<<20 {
+			b = append([]byte(nil), b...)
+		}
+		f.offs = append(f.offs, f.size)
+		f.chunks = append(f.chunks, b)
+	}
+	f.size += uint64(len(b))
+	return len(b), nil
+}
+
+func (f *chunkFile) Name() string { return "chunkfile" }
+func (f *chunkFile) Close()       {}
+
+func (f *chunkFile) Size() (uint64, error) { return f.size, nil }
+
+func (f *chunkFile) Read(off, sz uint64) ([]byte, error) {
+	if sz == 0 {
+		return nil, nil
+	}
+	if off+sz > f.size {
+		return nil, fmt.Errorf("out of bounds: off %d, sz %d", off, sz)
+	}
+	i := sort.Search(len(f.offs), func(i int) bool { return f.offs[i] > off }) - 1
+	if start := off - f.offs[i]; start+sz <= uint64(len(f.chunks[i])) {
+		return f.chunks[i][start : start+sz], nil
+	}
+
+	out := make([]byte, 0, sz)
+	for ; uint64(len(out)) < sz; i++ {
+		c := f.chunks[i]
+		if len(out) == 0 {
+			c = c[off-f.offs[i]:]
+		}
+		out = append(out, c[:min(uint64(len(c)), sz-uint64(len(out)))]...)
+	}
+	return out, nil
+}
+
+func TestReadWriteWideContents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes and reads more than 4GB of contents")
+	}
+
+	// The contents of the first two documents are an empty mapping, which
+	// takes no memory, so the contents of the shard are larger than 4GB.
+	const holeSize = 9 << 28
+	hole, err := unix.Mmap(-1, 0, holeSize, unix.PROT_READ, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		t.Skipf("Mmap: %v", err)
+	}
+	defer unix.Munmap(hole)
+
+	b, err := NewShardBuilder(nil)
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	// Index the holes as one byte documents. Their postings stay valid for
+	// the rest of the shard since it is plain ASCII, which maps rune offsets
+	// within a document to byte offsets.
+	for i, name := range []string{"hole1", "hole2"} {
+		if err := b.AddFile(name, []byte("x")); err != nil {
+			t.Fatalf("AddFile: %v", err)
+		}
+		b.contentStrings[i].data = hole
+		b.contentPostings.endByte += holeSize - 1
+	}
+	if err := b.AddFile("filename", []byte("abcde\nfghij")); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+
+	f := &chunkFile{}
+	if err := b.write(&writer{w: f}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reader{r: f}
+	var toc indexTOC
+	if err := r.readTOC(&toc); err != nil {
+		t.Fatalf("readTOC: %v", err)
+	}
+	if want := uint64(2*holeSize + 11); toc.fileContents.data.sz != want {
+		t.Fatalf("got contents size %d, want %d", toc.fileContents.data.sz, want)
+	}
+	data, err := r.readIndexData(&toc)
+	if err != nil {
+		t.Fatalf("readIndexData: %v", err)
+	}
+	if got, want := data.boundaries[2], uint64(2*holeSize); got != want {
+		t.Errorf("got boundary %d, want %d", got, want)
+	}
+	if got := data.metaData.IndexMinReaderVersion; got != wideContentsMinReaderVersion {
+		t.Errorf("got min reader version %d, want %d", got, wideContentsMinReaderVersion)
+	}
+
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		t.Fatalf("NewSearcher: %v", err)
+	}
+	defer searcher.Close()
+
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "ghi", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+		t.Fatalf("got %v, want 1 line match", res.Files)
+	}
+	if got := res.Files[0].FileName; got != "filename" {
+		t.Errorf("got file %q, want %q", got, "filename")
+	}
+	if got := string(res.Files[0].LineMatches[0].Line); got != "fghij" {
+		t.Errorf("got line %q, want %q", got, "fghij")
+	}
+}
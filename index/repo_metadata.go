@@ -0,0 +1,154 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// UpdateRepositoryMetadata applies u to the repository name in the shards of
+// indexDir and returns the updated repository. It only rewrites the ".meta"
+// files of the shards, so the change doesn't need a re-index. Searchers pick
+// it up once they reload the shards. It returns zoekt.ErrRepositoryNotFound if
+// no shard contains the repository.
+//
+// Like mergeMeta of the Sourcegraph indexserver, the update is best effort: if
+// renaming a ".meta" file fails, some shards may keep the old metadata.
+func UpdateRepositoryMetadata(indexDir, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	shards, err := repositoryShardCandidates(indexDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *zoekt.Repository
+	todo := map[string]string{}
+	defer func() {
+		for tmp := range todo {
+			os.Remove(tmp)
+		}
+	}()
+	for _, fn := range shards {
+		repos, md, err := ReadMetadataPath(fn)
+		if os.IsNotExist(err) {
+			// Deleted since we listed it.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		i := slices.IndexFunc(repos, func(r *zoekt.Repository) bool { return r.Name == name })
+		if i < 0 {
+			continue
+		}
+		repo := repos[i]
+
+		mutated, err := u.Apply(repo)
+		if err != nil {
+			return nil, err
+		}
+		updated = repo
+		if !mutated {
+			continue
+		}
+
+		var merged interface{}
+		if md.IndexFormatVersion >= 17 {
+			merged = repos
+		} else {
+			// <= v16 expects a single repo, not a list.
+			merged = repo
+		}
+
+		tmp, dst, err := JsonMarshalRepoMetaTemp(fn, merged)
+		if err != nil {
+			return nil, err
+		}
+		todo[tmp] = dst
+	}
+
+	if updated == nil {
+		return nil, fmt.Errorf("%w: %s", zoekt.ErrRepositoryNotFound, name)
+	}
+
+	var renameErr error
+	for tmp, dst := range todo {
+		if err := os.Rename(tmp, dst); err != nil {
+			renameErr = err
+		}
+	}
+	return updated, renameErr
+}
+
+// repositoryShardCandidates returns the shards of indexDir which may contain
+// the repository name: its simple shards, or the shards listed by its
+// manifest, and all compound shards.
+func repositoryShardCandidates(indexDir, name string) ([]string, error) {
+	o := Options{
+		IndexDir:              indexDir,
+		RepositoryDescription: zoekt.Repository{Name: name},
+	}
+
+	var shards []string
+	if listed, ok := o.manifestShards(); ok {
+		shards = listed
+	} else {
+		for _, v := range readVersions {
+			for i := 0; ; i++ {
+				fn := o.shardNameVersion(v.IndexFormatVersion, i)
+				if _, err := os.Stat(fn); err != nil {
+					break
+				}
+				shards = append(shards, fn)
+			}
+		}
+	}
+
+	compound, err := filepath.Glob(filepath.Join(indexDir, "compound-*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range compound {
+		if !slices.Contains(shards, fn) {
+			shards = append(shards, fn)
+		}
+	}
+	return shards, nil
+}
+
+// branchOrder returns the position of the branches of repo in its
+// "branchOrder", or nil if it has none.
+func branchOrder(repo *zoekt.Repository) map[string]int {
+	v := repo.RawConfig["branchOrder"]
+	if v == "" {
+		return nil
+	}
+	order := map[string]int{}
+	for i, name := range strings.Split(v, ",") {
+		if _, ok := order[name]; !ok {
+			order[name] = i
+		}
+	}
+	return order
+}
+
+// sortBranches sorts the branches of a file of the repository with
+// branchOrder order. Branches missing from it keep their index order after
+// the others.
+func sortBranches(branches []string, order map[string]int) {
+	if order == nil || len(branches) < 2 {
+		return
+	}
+	rank := func(b string) int {
+		if i, ok := order[b]; ok {
+			return i
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(branches, func(a, b string) int {
+		return rank(a) - rank(b)
+	})
+}
@@ -0,0 +1,122 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestUpdateRepositoryMetadata(t *testing.T) {
+	dir := t.TempDir()
+	shards := createTestShard(t, dir, zoekt.Repository{
+		Name:      "repo",
+		Branches:  []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}, {Name: "dev", Version: "v2"}},
+		RawConfig: map[string]string{"priority": "10"},
+	}, 2)
+	if len(shards) != 2 {
+		t.Fatalf("got %d shards, want 2", len(shards))
+	}
+	createTestCompoundShard(t, dir, []zoekt.Repository{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}})
+
+	readRepos := func(fn string) []*zoekt.Repository {
+		t.Helper()
+		repos, _, err := ReadMetadataPath(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}
+	oldRank := readRepos(shards[0])[0].Rank
+	postings := make(map[string][]byte)
+	for _, fn := range shards {
+		blob, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		postings[fn] = blob
+	}
+
+	priority, archived := 100.0, true
+	repo, err := UpdateRepositoryMetadata(dir, "repo", &zoekt.RepositoryMetadataUpdate{
+		Priority:    &priority,
+		Archived:    &archived,
+		Topics:      []string{"go", "search"},
+		BranchOrder: []string{"dev"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"priority": "100", "archived": "1", "topics": "go,search", "branchOrder": "dev"}
+	for _, fn := range shards {
+		got := readRepos(fn)[0]
+		for k, v := range want {
+			if got.RawConfig[k] != v {
+				t.Errorf("%s: got %s=%q, want %q", fn, k, got.RawConfig[k], v)
+			}
+		}
+		if got.Rank <= oldRank || got.GetPriority() != 100 {
+			t.Errorf("%s: got rank %d and priority %v, want a rank above %d", fn, got.Rank, got.GetPriority(), oldRank)
+		}
+
+		// Only the .meta file is written.
+		blob, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(blob, postings[fn]) {
+			t.Errorf("%s was rewritten", fn)
+		}
+	}
+	if repo.RawConfig["topics"] != "go,search" {
+		t.Errorf("got returned topics %q", repo.RawConfig["topics"])
+	}
+
+	// The branches of results follow the branch order.
+	s, err := loadShard(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sr, err := s.Search(context.Background(), &query.Substring{Pattern: "AAA"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 1 || !slices.Equal(sr.Files[0].Branches, []string{"dev", "main"}) {
+		t.Fatalf("got %+v, want one file on dev and main", sr.Files)
+	}
+
+	// Compound shards only change the updated repository.
+	if _, err := UpdateRepositoryMetadata(dir, "b", &zoekt.RepositoryMetadataUpdate{Archived: &archived}); err != nil {
+		t.Fatal(err)
+	}
+	compound, err := filepath.Glob(filepath.Join(dir, "compound-*.zoekt"))
+	if err != nil || len(compound) != 1 {
+		t.Fatalf("got compound shards %v, %v", compound, err)
+	}
+	for _, r := range readRepos(compound[0]) {
+		if wantArchived := r.Name == "b"; (r.RawConfig["archived"] == "1") != wantArchived {
+			t.Errorf("%s: got archived %q", r.Name, r.RawConfig["archived"])
+		}
+	}
+
+	if _, err := UpdateRepositoryMetadata(dir, "missing", &zoekt.RepositoryMetadataUpdate{Archived: &archived}); !errors.Is(err, zoekt.ErrRepositoryNotFound) {
+		t.Errorf("got %v for a missing repository, want ErrRepositoryNotFound", err)
+	}
+	if _, err := UpdateRepositoryMetadata(dir, "repo", &zoekt.RepositoryMetadataUpdate{BranchOrder: []string{"nope"}}); !errors.Is(err, zoekt.ErrInvalidMetadataUpdate) {
+		t.Errorf("got %v for an unknown branch, want ErrInvalidMetadataUpdate", err)
+	}
+
+	// An empty branch order restores the index order.
+	if _, err := UpdateRepositoryMetadata(dir, "repo", &zoekt.RepositoryMetadataUpdate{BranchOrder: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readRepos(shards[0])[0].RawConfig["branchOrder"]; ok {
+		t.Error("branchOrder wasn't removed")
+	}
+}
@@ -0,0 +1,137 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// Compound shards store a bloom filter of the names and IDs of their
+// repositories. A searcher given a set of repositories consults it to skip
+// the compound shards which contain none of them, instead of comparing the
+// set with each repository of each shard.
+
+// repoBloomBitsPerKey and repoBloomHashes give a false positive rate of
+// about 1%.
+const (
+	repoBloomBitsPerKey = 10
+	repoBloomHashes     = 7
+)
+
+// RepoBloom is a bloom filter of the repositories of a shard. The zero value
+// and nil contain nothing.
+type RepoBloom struct {
+	hashes uint32
+	bits   []uint64
+}
+
+// newRepoBloom returns the bloom filter of the names and IDs of repos.
+func newRepoBloom(repos []zoekt.Repository) *RepoBloom {
+	// Each repository has a name and an ID.
+	n := max(64, repoBloomBitsPerKey*2*len(repos))
+	b := &RepoBloom{
+		hashes: repoBloomHashes,
+		bits:   make([]uint64, (n+63)/64),
+	}
+	for _, r := range repos {
+		b.add(repoNameKey(r.Name))
+		if r.ID != 0 {
+			b.add(repoIDKey(r.ID))
+		}
+	}
+	return b
+}
+
+// repoNameKey and repoIDKey are the keys of a name and an ID. Names never
+// start with a NUL byte, so the keys of names and IDs can't collide.
+func repoNameKey(name string) []byte {
+	return []byte(name)
+}
+
+func repoIDKey(id uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{0}, id)
+}
+
+// repoBloomLocations returns the two hashes of key which, combined, give the
+// bits of key.
+func repoBloomLocations(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	// FNV mixes the last bytes of short keys poorly, so the hash is
+	// finalized like in MurmurHash3 before deriving the two hashes.
+	h1 := fmix64(h.Sum64())
+	h2 := fmix64(h1^0x9e3779b97f4a7c15) | 1
+	return h1, h2
+}
+
+// fmix64 is the 64-bit finalizer of MurmurHash3.
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (b *RepoBloom) add(key []byte) {
+	h1, h2 := repoBloomLocations(key)
+	n := uint64(len(b.bits)) * 64
+	for i := range uint64(b.hashes) {
+		bit := (h1 + i*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *RepoBloom) mayContain(key []byte) bool {
+	if b == nil || len(b.bits) == 0 {
+		return false
+	}
+	h1, h2 := repoBloomLocations(key)
+	n := uint64(len(b.bits)) * 64
+	for i := range uint64(b.hashes) {
+		bit := (h1 + i*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MayContainName returns false if the shard has no repository named name.
+func (b *RepoBloom) MayContainName(name string) bool {
+	return b.mayContain(repoNameKey(name))
+}
+
+// MayContainID returns false if the shard has no repository with the ID id.
+func (b *RepoBloom) MayContainID(id uint32) bool {
+	return b.mayContain(repoIDKey(id))
+}
+
+func encodeRepoBloom(b *RepoBloom) []byte {
+	buf := make([]byte, 4, 4+8*len(b.bits))
+	binary.BigEndian.PutUint32(buf, b.hashes)
+	for _, w := range b.bits {
+		buf = binary.BigEndian.AppendUint64(buf, w)
+	}
+	return buf
+}
+
+func decodeRepoBloom(blob []byte) (*RepoBloom, error) {
+	if len(blob) < 4 || (len(blob)-4)%8 != 0 {
+		return nil, fmt.Errorf("repoBloom section has invalid size %d", len(blob))
+	}
+	b := &RepoBloom{hashes: binary.BigEndian.Uint32(blob)}
+	for blob = blob[4:]; len(blob) > 0; blob = blob[8:] {
+		b.bits = append(b.bits, binary.BigEndian.Uint64(blob))
+	}
+	return b, nil
+}
+
+// RepoBloom returns the bloom filter of the repositories of the shard, or nil
+// if the shard has none.
+func (d *indexData) RepoBloom() *RepoBloom {
+	return d.repoBloom
+}
@@ -0,0 +1,65 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestRepoBloom(t *testing.T) {
+	d := compoundReposShard(t, "foo", "bar")
+	b := d.RepoBloom()
+	if b == nil {
+		t.Fatal("compound shard has no repo bloom filter")
+	}
+	for _, name := range []string{"foo", "bar"} {
+		if !b.MayContainName(name) {
+			t.Errorf("MayContainName(%q) = false", name)
+		}
+		if !b.MayContainID(hash(name)) {
+			t.Errorf("MayContainID(hash(%q)) = false", name)
+		}
+	}
+	if b.MayContainName("banana") {
+		t.Error("MayContainName(banana) = true")
+	}
+
+	single := searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "foo"})).(*indexData)
+	if single.RepoBloom() != nil {
+		t.Error("single repository shard has a repo bloom filter")
+	}
+	if single.RepoBloom().MayContainName("foo") {
+		t.Error("nil filter contains foo")
+	}
+}
+
+func TestRepoBloom_falsePositives(t *testing.T) {
+	var repos []zoekt.Repository
+	for i := range 1000 {
+		repos = append(repos, zoekt.Repository{ID: uint32(i + 1), Name: fmt.Sprintf("repo-%d", i)})
+	}
+	b, err := decodeRepoBloom(encodeRepoBloom(newRepoBloom(repos)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range repos {
+		if !b.MayContainName(r.Name) || !b.MayContainID(r.ID) {
+			t.Fatalf("filter misses %s", r.Name)
+		}
+	}
+	fp := 0
+	for i := range 10000 {
+		if b.MayContainName(fmt.Sprintf("other-%d", i)) {
+			fp++
+		}
+		if b.MayContainID(uint32(10000 + i)) {
+			fp++
+		}
+	}
+	// The filter is sized for 1% false positives.
+	if rate := float64(fp) / 20000; rate > 0.03 {
+		t.Errorf("false positive rate %.3f", rate)
+	}
+}
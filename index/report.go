@@ -0,0 +1,152 @@
+package index
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxReportedSkips bounds the skipped documents listed in a BuildReport, so
+// repositories with many vendored binaries don't produce huge reports.
+const maxReportedSkips = 1000
+
+// BuildReport summarizes a run of a Builder. It is written to
+// Options.ReportFile when the builder finishes.
+type BuildReport struct {
+	Repository string
+	Start      time.Time
+	Duration   time.Duration
+
+	// Documents is the number of documents added to the builder, including
+	// the skipped ones.
+	Documents int
+
+	// Skipped is the number of documents which were only indexed by name.
+	Skipped int
+
+	// SkipReasons counts the skipped documents by the kind of their reason:
+	// binary, size (above Options.SizeMax), text (too small, or too many
+	// trigrams), extractor, filter, or other (skipped by the caller).
+	SkipReasons map[string]int `json:",omitempty"`
+
+	// SkippedDocuments lists the first skipped documents with their reasons.
+	SkippedDocuments []SkippedDocument `json:",omitempty"`
+
+	// Shards are the shards written by the builder.
+	Shards []ShardReport `json:",omitempty"`
+
+	// CTagsFailures are the symbol parsing errors which were ignored, because
+	// Options.CTagsMustSucceed isn't set.
+	CTagsFailures []string `json:",omitempty"`
+
+	// Error is the error the build failed with, if any.
+	Error string `json:",omitempty"`
+}
+
+// SkippedDocument is a document which wasn't indexed, see
+// Document.SkipReason.
+type SkippedDocument struct {
+	Name   string
+	Reason string
+}
+
+// ShardReport describes a shard written by a Builder.
+type ShardReport struct {
+	Name string
+	Size int64
+}
+
+// ReadBuildReport reads a report written by a Builder.
+func ReadBuildReport(path string) (*BuildReport, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r BuildReport
+	if err := json.Unmarshal(blob, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// WriteBuildReport writes r to path. The file is replaced atomically, so
+// readers never see a partial report.
+func WriteBuildReport(path string, r *BuildReport) error {
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(blob); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// buildReport collects the BuildReport of a Builder. Shards are built
+// concurrently, so it is guarded by a mutex.
+type buildReport struct {
+	mu sync.Mutex
+	BuildReport
+}
+
+// addDocument adds doc, skipped for a reason of kind skipKind if it has a
+// SkipReason.
+func (r *buildReport) addDocument(doc *Document, skipKind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Documents++
+	if doc.SkipReason == "" {
+		return
+	}
+	r.Skipped++
+	if r.SkipReasons == nil {
+		r.SkipReasons = map[string]int{}
+	}
+	r.SkipReasons[skipKind]++
+	if len(r.SkippedDocuments) < maxReportedSkips {
+		r.SkippedDocuments = append(r.SkippedDocuments, SkippedDocument{Name: doc.Name, Reason: doc.SkipReason})
+	}
+}
+
+func (r *buildReport) addCTagsFailure(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.CTagsFailures = append(r.CTagsFailures, err.Error())
+}
+
+func (r *buildReport) addShard(name string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Shards = append(r.Shards, ShardReport{Name: name, Size: size})
+}
+
+// finish completes the report with the outcome of Builder.Finish and
+// returns a copy of it.
+func (r *buildReport) finish(err error) *BuildReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duration = time.Since(r.Start)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	report := r.BuildReport
+	report.SkipReasons = maps.Clone(r.SkipReasons)
+	report.Shards = slices.Clone(r.Shards)
+	slices.SortFunc(report.Shards, func(a, b ShardReport) int { return strings.Compare(a.Name, b.Name) })
+	return &report
+}
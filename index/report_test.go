@@ -0,0 +1,57 @@
+package index
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestBuildReport(t *testing.T) {
+	dir := t.TempDir()
+	reportFile := filepath.Join(dir, "report.json")
+	b, err := NewBuilder(Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		DisableCTags:          true,
+		SizeMax:               10,
+		ReportFile:            reportFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []Document{
+		{Name: "small.go", Content: []byte("package a")},
+		{Name: "large.go", Content: []byte(strings.Repeat("x", 100))},
+		{Name: "binary", Content: []byte("abc\x00def")},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ReadBuildReport(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Repository != "repo" || r.Documents != 3 || r.Skipped != 2 || r.Error != "" {
+		t.Errorf("got %+v, want 3 documents of repo with 2 skipped", r)
+	}
+	if r.SkipReasons[skipKindSize] != 1 || r.SkipReasons[skipKindBinary] != 1 || len(r.SkipReasons) != 2 {
+		t.Errorf("got skip reasons %v, want one size and one binary", r.SkipReasons)
+	}
+	if len(r.SkippedDocuments) != 2 || r.SkippedDocuments[0].Name != "large.go" || r.SkippedDocuments[0].Reason == "" {
+		t.Errorf("got skipped documents %+v", r.SkippedDocuments)
+	}
+	shards := b.opts.FindAllShards()
+	if len(r.Shards) != 1 || len(shards) != 1 || r.Shards[0].Name != shards[0] || r.Shards[0].Size == 0 {
+		t.Errorf("got shards %+v, want %v", r.Shards, shards)
+	}
+	if r.Duration <= 0 || r.Start.IsZero() {
+		t.Errorf("got start %v and duration %v", r.Start, r.Duration)
+	}
+}
@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/ctags"
+	"github.com/sourcegraph/zoekt/internal/experiments"
 )
 
 const (
@@ -96,7 +98,7 @@ type lineScore struct {
 // - There should be at least one input candidate, len(ms) > 0.
 func (p *contentProvider) scoreLine(ms []*candidateMatch, language string, lineNumber int, opts *zoekt.SearchOptions) (lineScore, []*zoekt.Symbol) {
 	if opts.UseBM25Scoring {
-		score, symbolInfo := p.scoreLineBM25(ms, lineNumber)
+		score, symbolInfo := p.scoreLineBM25(ms, lineNumber, opts)
 		ls := lineScore{score: score}
 		if opts.DebugScore {
 			ls.debugScore = fmt.Sprintf("tfScore:%.2f, ", score)
@@ -160,7 +162,11 @@ func (p *contentProvider) scoreLine(ms []*candidateMatch, language string, lineN
 				symbolKind := ctags.ParseSymbolKind(si.Kind)
 				sym := sectionSlice(data, sec)
 
-				addScore(fmt.Sprintf("kind:%s:%s", language, si.Kind), scoreSymbolKind(language, filename, sym, symbolKind))
+				ss := scoreSymbolDefinition(language, filename, sym, symbolKind)
+				addScore(fmt.Sprintf("kind:%s:%s", language, si.Kind), ss.kind)
+				addScore("exported", ss.exported)
+				addScore("test-file", ss.test)
+				addScore("file-rank", ss.fileRank)
 
 				// This is from a symbol tree, so we need to store the symbol
 				// information.
@@ -202,7 +208,7 @@ func (p *contentProvider) scoreLine(ms []*candidateMatch, language string, lineN
 // Notes:
 // - This BM25 calculation skips inverse document frequency (idf) to keep the implementation simple.
 // - It uses the same calculateTermFrequency method as BM25 file scoring, which boosts filename and symbol matches.
-func (p *contentProvider) scoreLineBM25(ms []*candidateMatch, lineNumber int) (float64, []*zoekt.Symbol) {
+func (p *contentProvider) scoreLineBM25(ms []*candidateMatch, lineNumber int, opts *zoekt.SearchOptions) (float64, []*zoekt.Symbol) {
 	// If this is a filename, then don't compute BM25. The score would not be comparable to line scores.
 	if lineNumber < 0 {
 		return 0, nil
@@ -215,7 +221,11 @@ func (p *contentProvider) scoreLineBM25(ms []*candidateMatch, lineNumber int) (f
 	// Usually the calculation would be based on terms, but using bytes should work fine, as we're just computing a ratio.
 	nl := p.newlines()
 	lineLength := nl.lineStart(lineNumber+1) - nl.lineStart(lineNumber)
-	L := float64(lineLength) / 100.0
+	averageLineLength := 100.0
+	if opts.HasExperiment(experiments.BM25LineLength) {
+		averageLineLength = max(float64(nl.fileSize)/float64(len(nl.locs)+1), 1)
+	}
+	L := float64(lineLength) / averageLineLength
 
 	score := 0.0
 	tfs := p.calculateTermFrequency(ms)
@@ -286,7 +296,9 @@ func boostScore(score float64, ms []*candidateMatch) float64 {
 
 // scoreFile computes a score for the file match using various scoring signals, like
 // whether there's an exact match on a symbol, the number of query clauses that matched, etc.
-func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTree, known map[matchTree]bool, opts *zoekt.SearchOptions) {
+// If fcs is not nil, the best match of the file is the one of fcs, see
+// zoekt.SearchOptions.ScoreFieldClasses.
+func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTree, known map[matchTree]bool, fcs *fieldClassScore, opts *zoekt.SearchOptions) {
 	atomMatchCount := 0
 	visitMatchAtoms(mt, known, func(mt matchTree) {
 		atomMatchCount++
@@ -322,7 +334,18 @@ func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTr
 	}
 
 	// Maintain ordering of input files. This strictly dominates the in-file ordering of the matches.
-	addScore("fragment", maxFileScore)
+	if fcs != nil {
+		fileMatch.FieldClass = fcs.class.String()
+		addScore("field-class:"+fileMatch.FieldClass, fcs.score)
+	} else {
+		addScore("fragment", maxFileScore)
+	}
+
+	if opts.RankingSignalsWeight > 0 {
+		if signal := d.rankingSignal(doc); signal > 0 {
+			addScore("ranking-signal", opts.RankingSignalsWeight*scoreRankingSignalFactor*signal)
+		}
+	}
 
 	// Truncate score to avoid overlap with the tiebreakers.
 	fileMatch.Score = math.Trunc(fileMatch.Score)
@@ -337,6 +360,11 @@ func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTr
 	}
 
 	fileMatch.Score = ScoreOffset*fileMatch.Score + scoreRepoRankFactor*float64(repoRank) + scoreFileOrderFactor*docOrderScore
+	if fcs != nil {
+		// Files with the same score are ordered by the class of their best
+		// match before the other tiebreakers.
+		fileMatch.Score += scoreFieldClassFactor * float64(fcs.class)
+	}
 }
 
 // scoreFilesUsingBM25 computes the score according to BM25, the most common scoring algorithm for text search:
@@ -346,19 +374,14 @@ func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTr
 // frequent it appears in the corpus.
 //
 // Unlike standard file scoring, this scoring strategy ignores the individual LineMatch and ChunkMatch scores, instead
-// calculating a score over all matches in the file.
-func (d *indexData) scoreFilesUsingBM25(fileMatch *zoekt.FileMatch, doc uint32, cands []*candidateMatch, cp *contentProvider, opts *zoekt.SearchOptions) {
+// calculating a score over all matches in the file. The length of the file is compared to averageFileLength, the
+// average of the corpus (see CorpusStats).
+func (d *indexData) scoreFilesUsingBM25(fileMatch *zoekt.FileMatch, doc uint32, cands []*candidateMatch, cp *contentProvider, averageFileLength float64, opts *zoekt.SearchOptions) {
 	tf := cp.calculateTermFrequency(cands)
 
 	// Use standard parameter defaults used in Lucene (https://lucene.apache.org/core/10_1_0/core/org/apache/lucene/search/similarities/BM25Similarity.html)
 	k, b := 1.2, 0.75
 
-	averageFileLength := float64(d.boundaries[d.numDocs()]) / float64(d.numDocs())
-	// This is very unlikely, but explicitly guard against division by zero.
-	if averageFileLength == 0 {
-		averageFileLength++
-	}
-
 	// Compute the file length ratio. Usually the calculation would be based on terms, but using
 	// bytes should work fine, as we're just computing a ratio.
 	fileLength := float64(d.boundaries[doc+1] - d.boundaries[doc])
@@ -401,3 +424,111 @@ func (d *indexData) scoreFilesUsingBM25(fileMatch *zoekt.FileMatch, doc uint32,
 		}
 	}
 }
+
+// snippet is a line or chunk match of a file, as seen by scoreSnippets.
+type snippet struct {
+	lineNumber int
+	content    []byte
+	matches    [][]byte
+}
+
+// scoreSnippets adds signals which only matter for choosing which line or
+// chunk matches of a file are shown once the file has more of them than the
+// display limits allow. The limits keep the matches with the highest scores,
+// and many matches in a file usually have the same score, so without these
+// the first lines of the file would win. We prefer
+//
+//   - lines with more matches (density),
+//   - lines close to a symbol definition (proximity),
+//   - lines whose matched text is rare in the file and which don't repeat an
+//     earlier line (distinctiveness).
+//
+// It must be called with the matches in file order, before they are sorted by
+// score.
+func (p *contentProvider) scoreSnippets(fileMatch *zoekt.FileMatch, opts *zoekt.SearchOptions) {
+	var snippets []snippet
+	for _, lm := range fileMatch.LineMatches {
+		if lm.FileName {
+			return
+		}
+		s := snippet{lineNumber: lm.LineNumber, content: lm.Line}
+		for _, f := range lm.LineFragments {
+			start := min(f.LineOffset, len(lm.Line))
+			s.matches = append(s.matches, lm.Line[start:min(start+f.MatchLength, len(lm.Line))])
+		}
+		snippets = append(snippets, s)
+	}
+	for _, cm := range fileMatch.ChunkMatches {
+		if cm.FileName || len(cm.Ranges) == 0 {
+			return
+		}
+		s := snippet{lineNumber: int(cm.Ranges[0].Start.LineNumber), content: cm.Content}
+		for _, r := range cm.Ranges {
+			start := min(int(r.Start.ByteOffset-cm.ContentStart.ByteOffset), len(cm.Content))
+			end := min(int(r.End.ByteOffset-cm.ContentStart.ByteOffset), len(cm.Content))
+			s.matches = append(s.matches, cm.Content[start:max(start, end)])
+		}
+		snippets = append(snippets, s)
+	}
+	if len(snippets) < 2 {
+		return
+	}
+
+	// The line numbers of the symbol definitions, in ascending order.
+	var symbolLines []int
+	nls := p.newlines()
+	for _, sec := range p.docSections() {
+		symbolLines = append(symbolLines, nls.atOffset(sec.Start))
+	}
+
+	counts := map[string]int{}
+	for _, s := range snippets {
+		for _, m := range s.matches {
+			counts[strings.ToLower(string(m))]++
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, s := range snippets {
+		density := scoreSnippetDensityFactor * (1 - 1/float64(max(len(s.matches), 1)))
+
+		proximity := 0.0
+		if len(symbolLines) > 0 {
+			j := sort.SearchInts(symbolLines, s.lineNumber)
+			dist := math.MaxInt
+			if j < len(symbolLines) {
+				dist = symbolLines[j] - s.lineNumber
+			}
+			if j > 0 {
+				dist = min(dist, s.lineNumber-symbolLines[j-1])
+			}
+			proximity = scoreSnippetProximityFactor / float64(1+dist)
+		}
+
+		distinct := 0.0
+		line := string(bytes.TrimSpace(s.content))
+		if !seen[line] {
+			seen[line] = true
+			rarest := math.MaxInt
+			for _, m := range s.matches {
+				rarest = min(rarest, counts[strings.ToLower(string(m))])
+			}
+			if rarest != math.MaxInt {
+				distinct = scoreSnippetDistinctFactor / float64(rarest)
+			}
+		}
+
+		score := density + proximity + distinct
+		var debug *string
+		if fileMatch.LineMatches != nil {
+			fileMatch.LineMatches[i].Score += score
+			debug = &fileMatch.LineMatches[i].DebugScore
+		} else {
+			fileMatch.ChunkMatches[i].Score += score
+			debug = &fileMatch.ChunkMatches[i].DebugScore
+		}
+		if opts.DebugScore {
+			*debug += fmt.Sprintf(", snippet:%.2f <- density:%.2f, symbol-proximity:%.2f, distinct:%.2f", score, density, proximity, distinct)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package index
+
+import (
+	"bytes"
+
+	"github.com/grafana/regexp"
+)
+
+// DocumentFilter is called by the Builder for every document before it is
+// added to a shard. It may modify the document, for example to redact parts of
+// its content, or set doc.SkipReason to keep the content out of the index.
+//
+// The content of doc may be shared with the caller of Builder.Add, so a filter
+// which changes the content must replace doc.Content instead of writing to it.
+type DocumentFilter func(doc *Document)
+
+// SecretAction is what a secret filter does with a document containing a
+// secret.
+type SecretAction int
+
+const (
+	// SecretRedact masks the bytes of each secret.
+	SecretRedact SecretAction = iota
+	// SecretRedactLine masks every line containing a secret.
+	SecretRedactLine
+	// SecretSkipFile skips documents containing a secret. The file name is
+	// still indexed.
+	SecretSkipFile
+)
+
+// SecretRule is a pattern matching a secret.
+type SecretRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultSecretRules matches common token formats which are never meant to be
+// committed.
+var DefaultSecretRules = []SecretRule{
+	{Name: "aws-access-key-id", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,255}\b`)},
+	{Name: "github-fine-grained-token", Pattern: regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`)},
+	{Name: "gitlab-token", Pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{Name: "google-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{Name: "stripe-secret-key", Pattern: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN[ A-Z]* PRIVATE KEY-----(?s:.*?)-----END[ A-Z]* PRIVATE KEY-----`)},
+}
+
+// secretMask replaces redacted bytes. Secrets are replaced by the same number
+// of bytes, so the offsets of symbols in the document stay valid.
+const secretMask = '*'
+
+// NewSecretFilter returns a DocumentFilter which applies action to the
+// secrets matched by rules.
+func NewSecretFilter(rules []SecretRule, action SecretAction) DocumentFilter {
+	return func(doc *Document) {
+		if doc.SkipReason != "" {
+			return
+		}
+
+		var secrets [][]int
+		skipReason := ""
+		for _, r := range rules {
+			idxs := r.Pattern.FindAllIndex(doc.Content, -1)
+			if len(idxs) > 0 && skipReason == "" {
+				skipReason = "document contains a secret (" + r.Name + ")"
+			}
+			secrets = append(secrets, idxs...)
+		}
+		if len(secrets) == 0 {
+			return
+		}
+
+		if action == SecretSkipFile {
+			doc.SkipReason = skipReason
+			return
+		}
+
+		content := bytes.Clone(doc.Content)
+		for _, s := range secrets {
+			start, end := s[0], s[1]
+			if action == SecretRedactLine {
+				start = bytes.LastIndexByte(content[:start], '\n') + 1
+				if i := bytes.IndexByte(content[end:], '\n'); i >= 0 {
+					end += i
+				} else {
+					end = len(content)
+				}
+			}
+			for i := start; i < end; i++ {
+				// Newlines are kept so line numbers don't change.
+				if content[i] != '\n' {
+					content[i] = secretMask
+				}
+			}
+		}
+		doc.Content = content
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/binary"
 	"io"
 	"log"
+	"math"
 )
 
 var _ = log.Println
@@ -26,7 +27,10 @@ var _ = log.Println
 type writer struct {
 	err error
 	w   io.Writer
-	off uint32
+	off uint64
+
+	// digest, if set, receives the bytes written.
+	digest io.Writer
 }
 
 func (w *writer) Write(b []byte) (int, error) {
@@ -36,11 +40,14 @@ func (w *writer) Write(b []byte) (int, error) {
 
 	var n int
 	n, w.err = w.w.Write(b)
-	w.off += uint32(n)
+	w.off += uint64(n)
+	if w.digest != nil {
+		w.digest.Write(b[:n])
+	}
 	return n, w.err
 }
 
-func (w *writer) Off() uint32 { return w.off }
+func (w *writer) Off() uint64 { return w.off }
 
 func (w *writer) B(b byte) {
 	s := []byte{b}
@@ -80,14 +87,20 @@ func (s *simpleSection) end(w *writer) {
 }
 
 // section is a range of bytes in the index file.
+//
+// Sections are written with 32-bit offsets unless they end beyond 4GB, in
+// which case they are written with 64-bit offsets and a wide section kind.
+// This keeps shards smaller than 4GB readable by older versions.
 type section interface {
-	read(*reader) error
+	read(r *reader, wide bool) error
 	// skip advances over the data in the section without reading it.
 	// NOTE: the section will not contain valid data after this call, and it should not be used.
-	skip(*reader) error
-	write(*writer)
+	skip(r *reader, wide bool) error
+	write(w *writer, wide bool)
 	// kind encodes whether the section is simple or compound, and is used in serialization
 	kind() sectionKind
+	// wide reports whether the section must be written with 64-bit offsets.
+	wide() bool
 }
 
 type sectionKind int
@@ -96,41 +109,87 @@ const (
 	sectionKindSimple       sectionKind = 0
 	sectionKindCompound     sectionKind = 1
 	sectionKindCompoundLazy sectionKind = 2
+
+	// The kinds of sections written with 64-bit offsets.
+	sectionKindSimple64       sectionKind = 3
+	sectionKindCompound64     sectionKind = 4
+	sectionKindCompoundLazy64 sectionKind = 5
 )
 
+// wideKindOffset is the difference between a section kind and the kind of
+// the same section written with 64-bit offsets.
+const wideKindOffset = sectionKindSimple64 - sectionKindSimple
+
+// encodedKind returns the kind recorded in the TOC for sec.
+func encodedKind(sec section) sectionKind {
+	if sec.wide() {
+		return sec.kind() + wideKindOffset
+	}
+	return sec.kind()
+}
+
+// decodeKind splits a kind read from the TOC into the kind of the section
+// and whether it was written with 64-bit offsets.
+func decodeKind(k sectionKind) (kind sectionKind, wide bool) {
+	if k >= sectionKindSimple64 {
+		return k - wideKindOffset, true
+	}
+	return k, false
+}
+
+func (r *reader) offset(wide bool) (uint64, error) {
+	if wide {
+		return r.U64()
+	}
+	v, err := r.U32()
+	return uint64(v), err
+}
+
+func (w *writer) offset(n uint64, wide bool) {
+	if wide {
+		w.U64(n)
+	} else {
+		w.U32(uint32(n))
+	}
+}
+
 // simpleSection is a simple range of bytes.
 type simpleSection struct {
-	off uint32
-	sz  uint32
+	off uint64
+	sz  uint64
 }
 
 func (s *simpleSection) kind() sectionKind {
 	return sectionKindSimple
 }
 
-func (s *simpleSection) read(r *reader) error {
+func (s *simpleSection) wide() bool {
+	return s.off+s.sz > math.MaxUint32
+}
+
+func (s *simpleSection) read(r *reader, wide bool) error {
 	var err error
-	s.off, err = r.U32()
+	s.off, err = r.offset(wide)
 	if err != nil {
 		return err
 	}
-	s.sz, err = r.U32()
+	s.sz, err = r.offset(wide)
 	return err
 }
 
-func (s *simpleSection) skip(r *reader) error {
+func (s *simpleSection) skip(r *reader, wide bool) error {
 	var err error
-	_, err = r.U32()
+	_, err = r.offset(wide)
 	if err != nil {
 		return err
 	}
-	_, err = r.U32()
+	_, err = r.offset(wide)
 	return err
 }
 
-func (s *simpleSection) write(w *writer) {
-	w.U32(s.off)
-	w.U32(s.sz)
+func (s *simpleSection) write(w *writer, wide bool) {
+	w.offset(s.off, wide)
+	w.offset(s.sz, wide)
 }
 
 // compoundSection is a range of bytes containg a list of variable
@@ -138,14 +197,21 @@ func (s *simpleSection) write(w *writer) {
 type compoundSection struct {
 	data simpleSection
 
-	offsets []uint32
+	offsets []uint64
 	index   simpleSection
+
+	// wideIndex is set if the offsets in index are 64-bit.
+	wideIndex bool
 }
 
 func (s *compoundSection) kind() sectionKind {
 	return sectionKindCompound
 }
 
+func (s *compoundSection) wide() bool {
+	return s.wideIndex
+}
+
 func (s *compoundSection) start(w *writer) {
 	s.data.start(w)
 }
@@ -153,8 +219,11 @@ func (s *compoundSection) start(w *writer) {
 func (s *compoundSection) end(w *writer) {
 	s.data.end(w)
 	s.index.start(w)
+	// The index is written with 64-bit offsets if it would end beyond 4GB
+	// with 32-bit offsets.
+	s.wideIndex = w.Off()+4*uint64(len(s.offsets)) > math.MaxUint32
 	for _, o := range s.offsets {
-		w.U32(o)
+		w.offset(o, s.wideIndex)
 	}
 	s.index.end(w)
 }
@@ -164,28 +233,29 @@ func (s *compoundSection) addItem(w *writer, item []byte) {
 	w.Write(item)
 }
 
-func (s *compoundSection) write(w *writer) {
-	s.data.write(w)
-	s.index.write(w)
+func (s *compoundSection) write(w *writer, wide bool) {
+	s.data.write(w, wide)
+	s.index.write(w, wide)
 }
 
-func (s *compoundSection) read(r *reader) error {
-	if err := s.data.read(r); err != nil {
+func (s *compoundSection) read(r *reader, wide bool) error {
+	if err := s.data.read(r, wide); err != nil {
 		return err
 	}
-	if err := s.index.read(r); err != nil {
+	if err := s.index.read(r, wide); err != nil {
 		return err
 	}
+	s.wideIndex = wide
 	var err error
-	s.offsets, err = readSectionU32(r.r, s.index)
+	s.offsets, err = readSectionOffsets(r.r, s.index, wide)
 	return err
 }
 
-func (s *compoundSection) skip(r *reader) error {
-	if err := s.data.skip(r); err != nil {
+func (s *compoundSection) skip(r *reader, wide bool) error {
+	if err := s.data.skip(r, wide); err != nil {
 		return err
 	}
-	if err := s.index.read(r); err != nil {
+	if err := s.index.read(r, wide); err != nil {
 		return err
 	}
 
@@ -195,8 +265,8 @@ func (s *compoundSection) skip(r *reader) error {
 
 // relativeIndex returns the relative offsets of the items (first
 // element is 0), plus a final marking the end of the last item.
-func (s *compoundSection) relativeIndex() []uint32 {
-	ri := make([]uint32, 0, len(s.offsets)+1)
+func (s *compoundSection) relativeIndex() []uint64 {
+	ri := make([]uint64, 0, len(s.offsets)+1)
 	for _, o := range s.offsets {
 		ri = append(ri, o-s.offsets[0])
 	}
@@ -214,11 +284,12 @@ func (s *lazyCompoundSection) kind() sectionKind {
 	return sectionKindCompoundLazy
 }
 
-func (s *lazyCompoundSection) read(r *reader) error {
+func (s *lazyCompoundSection) read(r *reader, wide bool) error {
 	// We do the same thing compoundSection.read does, except we don't read the
 	// offsets.
-	if err := s.data.read(r); err != nil {
+	if err := s.data.read(r, wide); err != nil {
 		return err
 	}
-	return s.index.read(r)
+	s.wideIndex = wide
+	return s.index.read(r, wide)
 }
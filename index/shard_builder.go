@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"hash/crc64"
 	"log"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -66,13 +67,24 @@ type postingsBuilder struct {
 	// offsets. As a first attempt, we sample regularly. The
 	// precise offset can be found by walking from the recorded
 	// offset to the desired rune.
-	runeOffsets []uint32
+	runeOffsets []uint64
 	runeCount   uint32
 
 	isPlainASCII bool
 
 	endRunes []uint32
-	endByte  uint32
+	endByte  uint64
+
+	// size approximates the memory of postings, see postingsEntryBytes.
+	size int
+
+	// runs hold the postings spilled to disk, oldest first. The complete
+	// postings are the runs merged with postings, see eachPostings.
+	runs []postingsRun
+
+	// stopNgrams are the trigrams removed from spilled postings, see
+	// removeStopNgrams.
+	stopNgrams map[ngram]bool
 }
 
 func newPostingsBuilder() *postingsBuilder {
@@ -87,6 +99,14 @@ func newPostingsBuilder() *postingsBuilder {
 // DocumentSections must correspond to rune boundaries in the UTF-8
 // data.
 func (s *postingsBuilder) newSearchableString(data []byte, byteSections []DocumentSection) (*searchableString, []DocumentSection, error) {
+	// Posting lists hold 32-bit rune offsets, so the runes of all strings
+	// must fit in them. Counting the runes is only needed close to the limit.
+	if uint64(s.runeCount)+uint64(len(data)) > math.MaxUint32 {
+		if runes := uint64(s.runeCount) + uint64(utf8.RuneCount(data)); runes > math.MaxUint32 {
+			return nil, nil, fmt.Errorf("content of %d runes exceeds the shard limit of %d runes", runes, uint64(math.MaxUint32))
+		}
+	}
+
 	dest := searchableString{
 		data: data,
 	}
@@ -95,7 +115,7 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 
 	var runeIndex uint32
 	byteCount := 0
-	dataSz := uint32(len(data))
+	dataSz := uint64(len(data))
 
 	byteSectionBoundaries := make([]uint32, 0, 2*len(byteSections))
 	for _, s := range byteSections {
@@ -114,7 +134,7 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 		runeGram[0], runeGram[1], runeGram[2] = runeGram[1], runeGram[2], c
 
 		if idx := s.runeCount + runeIndex; idx%runeOffsetFrequency == 0 {
-			s.runeOffsets = append(s.runeOffsets, s.endByte+uint32(byteCount))
+			s.runeOffsets = append(s.runeOffsets, s.endByte+uint64(byteCount))
 		}
 		for len(byteSectionBoundaries) > 0 && byteSectionBoundaries[0] == uint32(byteCount) {
 			runeSectionBoundaries = append(runeSectionBoundaries,
@@ -133,7 +153,7 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 		newOff := endRune + uint32(runeIndex) - 2
 
 		m := binary.PutUvarint(buf[:], uint64(newOff-lastOff))
-		s.postings[ng] = append(s.postings[ng], buf[:m]...)
+		s.addPosting(ng, buf[:m])
 		s.lastOffsets[ng] = newOff
 	}
 	s.runeCount += runeIndex
@@ -163,6 +183,44 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 	return &dest, runeSecs, nil
 }
 
+// addNgrams stores the trigram offsets for data, which starts at rune
+// runeStart of a string added with newSearchableString to another
+// postingsBuilder. Calls must be in increasing order of runeStart, and data
+// may not overlap.
+func (s *postingsBuilder) addNgrams(data []byte, runeStart uint32) {
+	var buf [8]byte
+	var runeGram [3]rune
+
+	var runeIndex uint32
+	for ; len(data) > 0; runeIndex++ {
+		c, sz := utf8.DecodeRune(data)
+		data = data[sz:]
+
+		runeGram[0], runeGram[1], runeGram[2] = runeGram[1], runeGram[2], c
+		if runeIndex < 2 {
+			continue
+		}
+
+		ng := runesToNGram(runeGram)
+		lastOff := s.lastOffsets[ng]
+		newOff := runeStart + runeIndex - 2
+
+		m := binary.PutUvarint(buf[:], uint64(newOff-lastOff))
+		s.addPosting(ng, buf[:m])
+		s.lastOffsets[ng] = newOff
+	}
+}
+
+// addPosting appends the encoded delta to the posting list of ng.
+func (s *postingsBuilder) addPosting(ng ngram, delta []byte) {
+	p, ok := s.postings[ng]
+	if !ok {
+		s.size += postingsEntryBytes
+	}
+	s.postings[ng] = append(p, delta...)
+	s.size += len(delta)
+}
+
 // ShardBuilder builds a single index shard.
 type ShardBuilder struct {
 	// The version we will write to disk. Sourcegraph Specific. This is to
@@ -194,6 +252,51 @@ type ShardBuilder struct {
 	contentPostings *postingsBuilder
 	namePostings    *postingsBuilder
 
+	// foldedNamePostings and foldedSymbolPostings contain the trigrams of
+	// the lowercased file names and symbols. They are nil unless
+	// enableFoldedNgrams was called.
+	foldedNamePostings   *postingsBuilder
+	foldedSymbolPostings *postingsBuilder
+
+	// symbolPostings contains the trigrams of the symbols. It is nil unless
+	// enableSymbolNgrams was called.
+	symbolPostings *postingsBuilder
+
+	// symbolHashes contains the entries of the symbol hash index. It is nil
+	// unless enableSymbolHashes was called.
+	symbolHashes []symbolHashEntry
+
+	// fuzzySymbols contains the entries of the fuzzy symbol index. It is
+	// nil unless enableFuzzySymbols was called.
+	fuzzySymbols []symbolHashEntry
+
+	// cjkBigrams holds the documents containing each CJK bigram. It is nil
+	// unless enableCJKBigrams was called.
+	cjkBigrams cjkBigramsBuilder
+
+	// contentKeys encrypts the file contents. It is nil unless
+	// enableContentEncryption was called.
+	contentKeys ContentKeys
+
+	// dedupContents stores identical file contents once. It is false unless
+	// enableContentDedup was called.
+	dedupContents bool
+
+	// binaryChecked indexes documents with NUL bytes. It is false unless
+	// skipBinaryCheck was called.
+	binaryChecked bool
+
+	// maxTrigramFrequency is the number of occurrences above which content
+	// trigrams become stop-ngrams. It is zero unless enableStopNgrams was
+	// called. stopNgrams are the trigrams removed from contentPostings.
+	maxTrigramFrequency uint32
+	stopNgrams          []ngram
+
+	// maxPostingsBytes is the memory above which postings are spilled to
+	// runs in spillDir. It is zero unless enablePostingsSpill was called.
+	maxPostingsBytes int
+	spillDir         string
+
 	// root repositories
 	repoList []zoekt.Repository
 
@@ -206,6 +309,22 @@ type ShardBuilder struct {
 	// language codes, uint16 encoded as little-endian
 	languages []uint8
 
+	// docID => Document.RankingSignal
+	rankingSignals []float32
+
+	// docID => flags, see classifyDoc
+	docFlags []byte
+
+	// docID => encoded documentation of the symbols, see encodeSymbolDocs.
+	symbolDocs [][]byte
+
+	// docID => ID of the labels of the document, see labelSetID.
+	docLabels []uint32
+
+	// the distinct encoded labels of the documents, by ID-1, and their IDs.
+	labelSets   [][]byte
+	labelSetIDs map[string]uint32
+
 	// IndexTime will be used as the time if non-zero. Otherwise
 	// time.Now(). This is useful for doing reproducible builds in tests.
 	IndexTime time.Time
@@ -254,7 +373,7 @@ func ParseTemplate(text string) (*template.Template, error) {
 }
 
 // ContentSize returns the number of content bytes so far ingested.
-func (b *ShardBuilder) ContentSize() uint32 {
+func (b *ShardBuilder) ContentSize() uint64 {
 	// Add the name too so we don't skip building index if we have
 	// lots of empty files.
 	return b.contentPostings.endByte + b.namePostings.endByte
@@ -293,6 +412,73 @@ func newShardBuilder() *ShardBuilder {
 	}
 }
 
+// enableFoldedNgrams makes the builder write case-folded trigram indexes for
+// file names and symbols, which speed up case-insensitive searches over them.
+// It must be called before the first document is added.
+func (b *ShardBuilder) enableFoldedNgrams() {
+	b.foldedNamePostings = newPostingsBuilder()
+	b.foldedSymbolPostings = newPostingsBuilder()
+}
+
+// enableSymbolNgrams makes the builder write a trigram index of the symbols,
+// so symbol searches don't need to consider matches outside of symbols. It
+// must be called before the first document is added.
+func (b *ShardBuilder) enableSymbolNgrams() {
+	b.symbolPostings = newPostingsBuilder()
+}
+
+// enableSymbolHashes makes the builder write a hash index of the symbol
+// names, so definitions can be looked up by exact name. It must be called
+// before the first document is added.
+func (b *ShardBuilder) enableSymbolHashes() {
+	b.symbolHashes = []symbolHashEntry{}
+}
+
+// enableFuzzySymbols makes the builder write a hash index of the deletion
+// neighborhoods of the symbol names, so fuzzy: queries can look up the
+// symbols one edit away from a pattern. It must be called before the first
+// document is added.
+func (b *ShardBuilder) enableFuzzySymbols() {
+	b.fuzzySymbols = []symbolHashEntry{}
+}
+
+// enableCJKBigrams makes the builder write an index of the documents
+// containing each pair of adjacent CJK characters, so searches for patterns
+// too short for trigrams don't scan every document. It must be called before
+// the first document is added.
+func (b *ShardBuilder) enableCJKBigrams() {
+	b.cjkBigrams = cjkBigramsBuilder{}
+}
+
+// skipBinaryCheck makes the builder index the documents with NUL bytes, for
+// callers which tell binary documents apart themselves and set their
+// SkipReason.
+func (b *ShardBuilder) skipBinaryCheck() {
+	b.binaryChecked = true
+}
+
+// enableContentEncryption makes the builder encrypt the file contents with
+// the keys of the tenants of its repositories. It must be called before the
+// shard is written.
+func (b *ShardBuilder) enableContentEncryption(keys ContentKeys) {
+	b.contentKeys = keys
+}
+
+// enableContentDedup makes the builder store byte-identical file contents
+// once, eg. the files shared by the forks in a compound shard. Encrypted
+// contents are never deduplicated, since they are bound to their document.
+// It must be called before the shard is written.
+func (b *ShardBuilder) enableContentDedup() {
+	b.dedupContents = true
+}
+
+// enableStopNgrams makes the builder leave the content trigrams which occur
+// more than maxFrequency times out of the index, see stopNgrams. It must be
+// called before the shard is written.
+func (b *ShardBuilder) enableStopNgrams(maxFrequency uint32) {
+	b.maxTrigramFrequency = maxFrequency
+}
+
 func (b *ShardBuilder) setRepository(desc *zoekt.Repository) error {
 	if err := verify(desc); err != nil {
 		return err
@@ -414,8 +600,10 @@ func DetermineLanguageIfUnknown(doc *Document) {
 func (b *ShardBuilder) Add(doc Document) error {
 	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
 
-	if idx := bytes.IndexByte(doc.Content, 0); idx >= 0 {
-		doc.SkipReason = fmt.Sprintf("binary content at byte offset %d", idx)
+	if !b.binaryChecked {
+		if idx := bytes.IndexByte(doc.Content, 0); idx >= 0 {
+			doc.SkipReason = fmt.Sprintf("binary content at byte offset %d", idx)
+		}
 	}
 
 	if doc.SkipReason != "" {
@@ -454,7 +642,43 @@ func (b *ShardBuilder) Add(doc Document) error {
 	if err != nil {
 		return err
 	}
+	if b.foldedNamePostings != nil {
+		// toLower maps every rune to one rune, so the positions of the
+		// folded trigrams match the positions in the original strings.
+		if _, _, err := b.foldedNamePostings.newSearchableString(toLower([]byte(doc.Name)), nil); err != nil {
+			return err
+		}
+		for i, sec := range doc.Symbols {
+			b.foldedSymbolPostings.addNgrams(toLower(doc.Content[sec.Start:sec.End]), runeSecs[i].Start)
+		}
+	}
+	if b.symbolPostings != nil {
+		for i, sec := range doc.Symbols {
+			b.symbolPostings.addNgrams(doc.Content[sec.Start:sec.End], runeSecs[i].Start)
+		}
+	}
+	if b.symbolHashes != nil {
+		first := uint32(len(b.runeDocSections))
+		for i, sec := range doc.Symbols {
+			b.symbolHashes = append(b.symbolHashes, symbolHashEntry{
+				hash: hashSymbol(doc.Content[sec.Start:sec.End]),
+				sym:  first + uint32(i),
+			})
+		}
+	}
+	if b.fuzzySymbols != nil {
+		first := uint32(len(b.runeDocSections))
+		for i, sec := range doc.Symbols {
+			b.fuzzySymbols = append(b.fuzzySymbols, fuzzySymbolEntries(doc.Content[sec.Start:sec.End], first+uint32(i))...)
+		}
+	}
+	if b.cjkBigrams != nil {
+		docID := uint32(len(b.contentStrings))
+		b.cjkBigrams.add(docID, doc.Content)
+		b.cjkBigrams.add(docID, []byte(doc.Name))
+	}
 	b.addSymbols(doc.SymbolsMetaData)
+	b.symbolDocs = append(b.symbolDocs, encodeSymbolDocs(doc.SymbolsMetaData))
 
 	repoIdx := len(b.repoList) - 1
 	subRepoIdx, ok := b.subRepoIndices[repoIdx][doc.SubRepositoryPath]
@@ -498,8 +722,11 @@ func (b *ShardBuilder) Add(doc Document) error {
 		b.languageMap[doc.Language] = langCode
 	}
 	b.languages = append(b.languages, uint8(langCode), uint8(langCode>>8))
+	b.rankingSignals = append(b.rankingSignals, float32(doc.RankingSignal))
+	b.docFlags = append(b.docFlags, classifyDoc(doc.Name, doc.Language))
+	b.docLabels = append(b.docLabels, b.labelSetID(doc.Labels))
 
-	return nil
+	return b.maybeSpill()
 }
 
 func (b *ShardBuilder) branchMask(br string) uint64 {
@@ -547,6 +774,19 @@ func (t *DocChecker) Check(content []byte, maxTrigramCount int, allowLargeFile b
 		return fmt.Errorf("binary data at byte offset %d", index)
 	}
 
+	return t.checkText(content, maxTrigramCount, allowLargeFile)
+}
+
+// checkText is Check without the check for binary data, see binarySniffer.
+func (t *DocChecker) checkText(content []byte, maxTrigramCount int, allowLargeFile bool) error {
+	if len(content) == 0 {
+		return nil
+	}
+
+	if len(content) < ngramSize {
+		return fmt.Errorf("file size smaller than %d", ngramSize)
+	}
+
 	// PERF: we only need to do the trigram check if the upperbound on content is greater than
 	// our threshold. Also skip the trigram check if the file is explicitly marked as allowed.
 	if trigramsUpperBound := len(content) - ngramSize + 1; trigramsUpperBound <= maxTrigramCount || allowLargeFile {
@@ -589,9 +829,5 @@ func (t *DocChecker) clearTrigrams(maxTrigramCount int) {
 // ShardName returns the name of the shard for the given prefix, version, and
 // shard number.
 func ShardName(indexDir string, prefix string, version, n int) string {
-	prefix = url.QueryEscape(prefix)
-	if len(prefix) > 200 {
-		prefix = prefix[:200] + hashString(prefix)[:8]
-	}
-	return filepath.Join(indexDir, fmt.Sprintf("%s_v%d.%05d.zoekt", prefix, version, n))
+	return filepath.Join(indexDir, fmt.Sprintf("%s_v%d.%05d.zoekt", escapeShardPrefix(prefix), version, n))
 }
@@ -0,0 +1,108 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Kinds of reasons for skipping documents, counted in
+// BuildReport.SkipReasons.
+const (
+	skipKindExtractor = "extractor"
+	skipKindSize      = "size"
+	skipKindBinary    = "binary"
+	skipKindText      = "text"
+	skipKindFilter    = "filter"
+	skipKindOther     = "other"
+)
+
+// binarySniffer tells binary documents, which are only indexed by name, from
+// text ones, as configured by Options.MaxNULBytes, Options.SniffMIME and
+// Options.TextExtensions.
+type binarySniffer struct {
+	maxNULBytes    int
+	sniffMIME      bool
+	textExtensions []string
+}
+
+func newBinarySniffer(o *Options) *binarySniffer {
+	s := &binarySniffer{
+		maxNULBytes: o.MaxNULBytes,
+		sniffMIME:   o.SniffMIME,
+	}
+	for _, ext := range o.TextExtensions {
+		s.textExtensions = append(s.textExtensions, normalizeExtension(ext))
+	}
+	return s
+}
+
+// normalizeExtension returns ext in lower case with a leading dot.
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// sniff returns why content of the file name is binary, or "" if it is text.
+func (s *binarySniffer) sniff(name string, content []byte) string {
+	if len(content) == 0 {
+		return ""
+	}
+	if ext := path.Ext(name); ext != "" && slices.Contains(s.textExtensions, strings.ToLower(ext)) {
+		return ""
+	}
+
+	if n := bytes.Count(content, []byte{0}); n > s.maxNULBytes {
+		if s.maxNULBytes == 0 {
+			return fmt.Sprintf("binary data at byte offset %d", bytes.IndexByte(content, 0))
+		}
+		return fmt.Sprintf("binary data with %d NUL bytes, more than %d", n, s.maxNULBytes)
+	}
+
+	if s.sniffMIME {
+		if t := http.DetectContentType(content); isBinaryMIMEType(t) {
+			return "binary content of type " + t
+		}
+	}
+	return ""
+}
+
+// textMIMETypes are the MIME types detected by http.DetectContentType which
+// are text although not text/*.
+var textMIMETypes = []string{"application/json", "application/xml", "image/svg+xml"}
+
+// isBinaryMIMEType returns true if t, as detected by http.DetectContentType,
+// is a binary format. application/octet-stream, which only means that the
+// content has control characters, is left to the NUL byte check.
+func isBinaryMIMEType(t string) bool {
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil || mt == "application/octet-stream" {
+		return false
+	}
+	return !strings.HasPrefix(mt, "text/") && !slices.Contains(textMIMETypes, mt)
+}
+
+type textExtensionsFlag struct{ *Options }
+
+func (f textExtensionsFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	return strings.Join(f.TextExtensions, ",")
+}
+
+func (f textExtensionsFlag) Set(value string) error {
+	for _, ext := range strings.Split(value, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			f.TextExtensions = append(f.TextExtensions, ext)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestBinarySniffer(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + "IHDR"
+	pdf := "%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n"
+	cases := []struct {
+		opts    Options
+		name    string
+		content string
+		want    string
+	}{
+		{name: "a.go", content: "package a"},
+		{name: "a.bin", content: "\x00abc", want: "binary data at byte offset 0"},
+		{name: "a.bin", content: "ab\x00c\x00", want: "binary data at byte offset 2"},
+		{opts: Options{MaxNULBytes: 2}, name: "a.bin", content: "ab\x00c\x00"},
+		{opts: Options{MaxNULBytes: 1}, name: "a.bin", content: "ab\x00c\x00", want: "binary data with 2 NUL bytes, more than 1"},
+		{name: "doc.pdf", content: pdf},
+		{opts: Options{SniffMIME: true}, name: "doc.pdf", content: pdf, want: "binary content of type application/pdf"},
+		{opts: Options{SniffMIME: true}, name: "icon.png", content: png, want: "binary content of type image/png"},
+		{opts: Options{SniffMIME: true}, name: "main.go", content: "package main\n\nfunc main() {}\n"},
+		{opts: Options{SniffMIME: true}, name: "index.html", content: "<!DOCTYPE html><html></html>"},
+		{opts: Options{SniffMIME: true}, name: "logo.svg", content: "<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"},
+		{opts: Options{SniffMIME: true, TextExtensions: []string{"PDF"}}, name: "doc.pdf", content: pdf},
+		{opts: Options{TextExtensions: []string{".dat"}}, name: "dir/x.DAT", content: "a\x00b"},
+		{opts: Options{TextExtensions: []string{".dat"}}, name: "x.dat.bin", content: "a\x00b", want: "binary data at byte offset 1"},
+	}
+	for _, tc := range cases {
+		if got := newBinarySniffer(&tc.opts).sniff(tc.name, []byte(tc.content)); got != tc.want {
+			t.Errorf("sniff(%q, %q) with %+v: got %q, want %q", tc.name, tc.content, tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestBuilderBinarySniffing(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewBuilder(Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		DisableCTags:          true,
+		MaxNULBytes:           1,
+		SniffMIME:             true,
+		TextExtensions:        []string{".dat"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []Document{
+		{Name: "one-nul.txt", Content: []byte("needle\x00one")},
+		{Name: "two-nuls.txt", Content: []byte("needle\x00two\x00")},
+		{Name: "data.dat", Content: []byte("needle\x00\x00\x00dat")},
+		{Name: "doc.pdf", Content: []byte("%PDF-1.7 needle")},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := loadShard(b.opts.FindAllShards()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	slices.Sort(got)
+	if want := []string{"data.dat", "one-nul.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got matches in %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// postingsEntryBytes approximates the memory a trigram takes in the postings
+// map of a postingsBuilder, besides its posting list.
+const postingsEntryBytes = 64
+
+// postingsRun is a file holding the posting lists of a postingsBuilder at the
+// time it was spilled, sorted by trigram. Each entry is the trigram, as a big
+// endian uint64, followed by the varint length and the bytes of its posting
+// list.
+type postingsRun struct {
+	name string
+}
+
+// maxRunFanIn is the most runs merged at once, which bounds the files open
+// while merging. It is a variable for tests.
+var maxRunFanIn = 64
+
+// spill writes the postings of s to a new run in dir and clears them. Since
+// the posting lists are delta encoded from s.lastOffsets, which are kept,
+// the lists of a trigram in the runs and in memory concatenate to its
+// complete list.
+func (s *postingsBuilder) spill(dir string) error {
+	keys := sortedKeys(s.postings)
+	run, err := createRun(dir, func(add func(ng ngram, p []byte)) error {
+		for _, k := range keys {
+			add(k, s.postings[k])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+
+	s.postings = map[ngram][]byte{}
+	s.size = 0
+	return nil
+}
+
+// createRun creates a new run in dir and calls write with a function adding
+// an entry to it. The entries must be added in increasing trigram order.
+func createRun(dir string, write func(add func(ng ngram, p []byte)) error) (postingsRun, error) {
+	f, err := os.CreateTemp(dir, "postings.*.tmp")
+	if err != nil {
+		return postingsRun{}, err
+	}
+	defer f.Close()
+	run := postingsRun{name: f.Name()}
+
+	// Errors of w are sticky, so they are only checked on Flush.
+	w := bufio.NewWriter(f)
+	var buf [binary.MaxVarintLen64]byte
+	err = write(func(ng ngram, p []byte) {
+		w.Write(binary.BigEndian.AppendUint64(buf[:0], uint64(ng)))
+		w.Write(binary.AppendUvarint(buf[:0], uint64(len(p))))
+		w.Write(p)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(run.name)
+		return postingsRun{}, err
+	}
+	return run, nil
+}
+
+// removeRuns removes the run files of s.
+func (s *postingsBuilder) removeRuns() {
+	for _, r := range s.runs {
+		os.Remove(r.name)
+	}
+	s.runs = nil
+}
+
+// runReader reads the entries of a postingsRun in order.
+type runReader struct {
+	f *os.File
+	r *bufio.Reader
+
+	// idx is the position of the run among the merged runs.
+	idx int
+
+	// The current entry. done is set once the run is exhausted.
+	ng   ngram
+	p    []byte
+	done bool
+}
+
+func (r *runReader) next() error {
+	var buf [8]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err == io.EOF {
+		r.done = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	r.ng = ngram(binary.BigEndian.Uint64(buf[:]))
+	sz, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	r.p = slices.Grow(r.p[:0], int(sz))[:sz]
+	_, err = io.ReadFull(r.r, r.p)
+	return err
+}
+
+// runHeap orders the runs being merged by their current trigram, and runs
+// with the same trigram by their position, so that their posting lists
+// concatenate in order.
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].ng != h[j].ng {
+		return h[i].ng < h[j].ng
+	}
+	return h[i].idx < h[j].idx
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns calls f with the trigrams of runs and postings in increasing
+// order and their posting lists, which are the lists of the runs in order
+// followed by the list in postings. The posting list passed to f is only
+// valid during the call.
+func mergeRuns(runs []postingsRun, postings map[ngram][]byte, f func(ng ngram, p []byte)) error {
+	keys := sortedKeys(postings)
+
+	var h runHeap
+	defer func() {
+		for _, r := range h {
+			r.f.Close()
+		}
+	}()
+	for i, run := range runs {
+		fd, err := os.Open(run.name)
+		if err != nil {
+			return err
+		}
+		r := &runReader{f: fd, r: bufio.NewReader(fd), idx: i}
+		if err := r.next(); err != nil {
+			fd.Close()
+			return fmt.Errorf("%s: %w", run.name, err)
+		}
+		if r.done {
+			fd.Close()
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	var merged []byte
+	for len(h) > 0 || len(keys) > 0 {
+		// The next trigram is the smallest of the current entries of the
+		// runs and the postings in memory.
+		var ng ngram
+		switch {
+		case len(h) == 0:
+			ng = keys[0]
+		case len(keys) == 0:
+			ng = h[0].ng
+		default:
+			ng = min(h[0].ng, keys[0])
+		}
+
+		merged = merged[:0]
+		for len(h) > 0 && h[0].ng == ng {
+			r := h[0]
+			merged = append(merged, r.p...)
+			if err := r.next(); err != nil {
+				return fmt.Errorf("%s: %w", r.f.Name(), err)
+			}
+			if r.done {
+				r.f.Close()
+				heap.Pop(&h)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+		if len(keys) > 0 && keys[0] == ng {
+			merged = append(merged, postings[ng]...)
+			keys = keys[1:]
+		}
+		f(ng, merged)
+	}
+	return nil
+}
+
+// compactRuns merges the runs of s in passes, each merging groups of at most
+// maxRunFanIn consecutive runs into one, until at most maxRunFanIn runs are
+// left.
+func (s *postingsBuilder) compactRuns() error {
+	for len(s.runs) > maxRunFanIn {
+		var compacted []postingsRun
+		for i := 0; i < len(s.runs); i += maxRunFanIn {
+			group := s.runs[i:min(i+maxRunFanIn, len(s.runs))]
+			if len(group) == 1 {
+				compacted = append(compacted, group[0])
+				continue
+			}
+			run, err := createRun(filepath.Dir(group[0].name), func(add func(ng ngram, p []byte)) error {
+				return mergeRuns(group, nil, add)
+			})
+			if err != nil {
+				// Keep all runs, so that removeRuns removes them.
+				s.runs = append(compacted, s.runs[i:]...)
+				return err
+			}
+			for _, r := range group {
+				os.Remove(r.name)
+			}
+			compacted = append(compacted, run)
+		}
+		s.runs = compacted
+	}
+	return nil
+}
+
+// eachPostings calls f with the trigrams of s in increasing order and their
+// posting lists, merging the runs of s with the postings in memory. Trigrams
+// removed as stop ngrams are skipped. The posting list passed to f is only
+// valid during the call.
+func (s *postingsBuilder) eachPostings(f func(ng ngram, p []byte)) error {
+	if err := s.compactRuns(); err != nil {
+		return err
+	}
+	return mergeRuns(s.runs, s.postings, func(ng ngram, p []byte) {
+		if !s.stopNgrams[ng] {
+			f(ng, p)
+		}
+	})
+}
+
+func sortedKeys(postings map[ngram][]byte) ngramSlice {
+	keys := make(ngramSlice, 0, len(postings))
+	for k := range postings {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// enablePostingsSpill makes the builder spill its posting lists to runs in
+// temporary files in dir once they take more than maxBytes, which bounds the
+// memory of building a shard. The runs are merged when the shard is written.
+// It must be called before the first document is added, and removeSpills
+// must be called once the builder is done.
+func (b *ShardBuilder) enablePostingsSpill(dir string, maxBytes int) {
+	b.spillDir = dir
+	b.maxPostingsBytes = maxBytes
+}
+
+// maybeSpill spills the largest postings of the builder if its postings take
+// more than maxPostingsBytes.
+func (b *ShardBuilder) maybeSpill() error {
+	if b.maxPostingsBytes <= 0 {
+		return nil
+	}
+	var largest *postingsBuilder
+	total := 0
+	for _, s := range b.allPostings() {
+		total += s.size
+		if largest == nil || s.size > largest.size {
+			largest = s
+		}
+	}
+	if total <= b.maxPostingsBytes {
+		return nil
+	}
+	return largest.spill(b.spillDir)
+}
+
+// removeSpills removes the runs the builder spilled its postings to.
+func (b *ShardBuilder) removeSpills() {
+	for _, s := range b.allPostings() {
+		s.removeRuns()
+	}
+}
+
+func (b *ShardBuilder) allPostings() []*postingsBuilder {
+	all := []*postingsBuilder{b.contentPostings, b.namePostings}
+	for _, s := range []*postingsBuilder{b.foldedNamePostings, b.foldedSymbolPostings, b.symbolPostings} {
+		if s != nil {
+			all = append(all, s)
+		}
+	}
+	return all
+}
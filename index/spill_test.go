@@ -0,0 +1,99 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestPostingsSpill(t *testing.T) {
+	var docs []Document
+	for i := range 50 {
+		docs = append(docs, Document{
+			Name:    fmt.Sprintf("dir%d/file%d.go", i%3, i),
+			Content: []byte(fmt.Sprintf("package main\n\nfunc f%d() { println(\"needle %d\") }\n", i, i*i)),
+			Symbols: []DocumentSection{{Start: 19, End: 20 + uint32(len(fmt.Sprint(i)))}},
+		})
+	}
+
+	build := func(spillDir string) *ShardBuilder {
+		b, err := NewShardBuilder(&zoekt.Repository{Name: "repo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.IndexTime = time.Unix(0, 0)
+		b.ID = "id"
+		b.enableFoldedNgrams()
+		b.enableSymbolNgrams()
+		b.enableStopNgrams(40)
+		if spillDir != "" {
+			// Spill after every document.
+			b.enablePostingsSpill(spillDir, 1)
+		}
+		for _, d := range docs {
+			if err := b.Add(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b
+	}
+
+	var want bytes.Buffer
+	if err := build("").Write(&want); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	b := build(dir)
+	if runs, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(runs) < len(docs) {
+		t.Fatalf("got %d runs, want at least one per document", len(runs))
+	}
+	var got bytes.Buffer
+	if err := b.Write(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Error("the shard built with spilled postings differs")
+	}
+	if len(b.stopNgrams) == 0 {
+		t.Error("found no stop ngrams")
+	}
+
+	b.removeSpills()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("runs left after removeSpills: %v", entries)
+	}
+
+	// With a small fan-in, the runs are merged in several passes.
+	defer func(n int) { maxRunFanIn = n }(maxRunFanIn)
+	maxRunFanIn = 3
+	dir = t.TempDir()
+	b = build(dir)
+	got.Reset()
+	if err := b.Write(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Error("the shard built with compacted runs differs")
+	}
+	for _, s := range b.allPostings() {
+		if len(s.runs) > maxRunFanIn {
+			t.Errorf("got %d runs after merging, want at most %d", len(s.runs), maxRunFanIn)
+		}
+	}
+	b.removeSpills()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("runs left after removeSpills: %v", entries)
+	}
+
+	res := searchForTest(t, build(t.TempDir()), &query.Substring{Pattern: "needle 49"})
+	if len(res.Files) != 1 || res.Files[0].FileName != "dir1/file7.go" {
+		t.Errorf("got %v, want dir1/file7.go", res.Files)
+	}
+}
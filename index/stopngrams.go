@@ -0,0 +1,89 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Stop-ngrams are content trigrams which occur so often in a shard, eg. in
+// minified JavaScript, that their posting lists would make the shard large
+// and produce a candidate for almost every position. They are left out of
+// the content index. Searches don't use them to find candidates, and fall
+// back to scanning the documents if a pattern has no other trigrams.
+
+// stopNgrams is the decoded stopNgrams section: the cutoff the shard was
+// built with, followed by the sorted stop-ngrams.
+type stopNgrams struct {
+	maxFrequency uint32
+	ngrams       []ngram
+}
+
+// contains returns whether ng is a stop-ngram.
+func (s *stopNgrams) contains(ng ngram) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := slices.BinarySearch(s.ngrams, ng)
+	return ok
+}
+
+func encodeStopNgrams(maxFrequency uint32, ngrams []ngram) []byte {
+	buf := make([]byte, 4, 4+8*len(ngrams))
+	binary.BigEndian.PutUint32(buf, maxFrequency)
+	for _, ng := range ngrams {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(ng))
+	}
+	return buf
+}
+
+func decodeStopNgrams(blob []byte) (*stopNgrams, error) {
+	if len(blob) < 4 || (len(blob)-4)%8 != 0 {
+		return nil, fmt.Errorf("stopNgrams section has invalid size %d", len(blob))
+	}
+	s := &stopNgrams{maxFrequency: binary.BigEndian.Uint32(blob)}
+	for blob = blob[4:]; len(blob) > 0; blob = blob[8:] {
+		s.ngrams = append(s.ngrams, ngram(binary.BigEndian.Uint64(blob)))
+	}
+	return s, nil
+}
+
+// removeStopNgrams removes the trigrams which occur more than maxFrequency
+// times from the postings, and returns them sorted.
+func (s *postingsBuilder) removeStopNgrams(maxFrequency uint32) ([]ngram, error) {
+	var stop []ngram
+	if len(s.runs) > 0 {
+		// The counts of spilled trigrams are only known once their runs are
+		// merged, so they are skipped when the postings are written.
+		err := s.eachPostings(func(ng ngram, p []byte) {
+			if postingsCount(p) > maxFrequency {
+				stop = append(stop, ng)
+			}
+		})
+		s.stopNgrams = map[ngram]bool{}
+		for _, ng := range stop {
+			s.stopNgrams[ng] = true
+		}
+		return stop, err
+	}
+	for ng, p := range s.postings {
+		if postingsCount(p) > maxFrequency {
+			stop = append(stop, ng)
+			delete(s.postings, ng)
+		}
+	}
+	slices.Sort(stop)
+	return stop, nil
+}
+
+// postingsCount returns the number of varint deltas in a posting list. The
+// last byte of a varint is its only byte with the high bit unset.
+func postingsCount(p []byte) uint32 {
+	var n uint32
+	for _, b := range p {
+		if b < 0x80 {
+			n++
+		}
+	}
+	return n
+}
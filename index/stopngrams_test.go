@@ -0,0 +1,89 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestStopNgrams(t *testing.T) {
+	docs := []Document{
+		{Name: "min.js", Content: []byte(strings.Repeat("x=1;", 50) + "needle\n")},
+		{Name: "a.js", Content: []byte("x=1;needle x=2\n")},
+		{Name: "b.js", Content: []byte("hello world\n")},
+	}
+	repo := &zoekt.Repository{Name: "repo"}
+
+	plain := testShardBuilder(t, repo, docs...)
+	stop := testShardBuilder(t, repo, docs...)
+	stop.enableStopNgrams(10)
+
+	d := searcherForTest(t, stop).(*indexData)
+	if d.stopNgrams == nil || d.stopNgrams.maxFrequency != 10 {
+		t.Fatalf("got stop-ngrams %+v, want cutoff 10", d.stopNgrams)
+	}
+	for _, s := range []string{"x=1", "=1;", "1;x", ";x="} {
+		ng := stringToNGram(s)
+		if !d.stopNgrams.contains(ng) {
+			t.Errorf("%q is not a stop-ngram", s)
+		}
+		if sz := d.contentNgrams.Get(ng).sz; sz != 0 {
+			t.Errorf("stop-ngram %q has a posting list of %d bytes", s, sz)
+		}
+	}
+	if d.stopNgrams.contains(stringToNGram("nee")) {
+		t.Error("infrequent trigram is a stop-ngram")
+	}
+
+	queries := []query.Q{
+		// Only stop-ngrams.
+		&query.Substring{Pattern: "x=1;x", Content: true, CaseSensitive: true},
+		&query.Substring{Pattern: "X=1;", Content: true},
+		// Stop-ngrams and others.
+		&query.Substring{Pattern: "x=1;needle", Content: true, CaseSensitive: true},
+		&query.Substring{Pattern: "1;NEEDLE", Content: true},
+		&query.Regexp{Regexp: mustParseRE("x=1;x=1"), Content: true, CaseSensitive: true},
+		&query.Regexp{Regexp: mustParseRE("x=1;n.*x=2"), Content: true, CaseSensitive: true},
+		// No stop-ngrams.
+		&query.Substring{Pattern: "needle", Content: true},
+		&query.Substring{Pattern: "x=2", Content: true},
+	}
+
+	search := func(s zoekt.Searcher, q query.Q) []zoekt.FileMatch {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res.Files
+	}
+
+	merged, err := merge(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.maxTrigramFrequency != 10 {
+		t.Errorf("merged shard has cutoff %d, want 10", merged.maxTrigramFrequency)
+	}
+
+	plainSearcher := searcherForTest(t, plain)
+	mergedSearcher := searcherForTest(t, merged)
+	for _, q := range queries {
+		want := search(plainSearcher, q)
+		if len(want) == 0 {
+			t.Fatalf("%s: no matches without stop-ngrams", q)
+		}
+		if diff := cmp.Diff(want, search(d, q)); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", q, diff)
+		}
+		if diff := cmp.Diff(want, search(mergedSearcher, q)); diff != "" {
+			t.Errorf("%s in merged shard (-want +got):\n%s", q, diff)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/languages"
+)
+
+// maxSymbolDocBytes bounds the documentation stored per symbol. Hover
+// previews only show the start of long comments.
+const maxSymbolDocBytes = 2048
+
+// maxDeclarationLines is how many lines after a symbol we look for the end of
+// its declaration, before the docstring.
+const maxDeclarationLines = 10
+
+// symbolDocStyle is the comment syntax of a language, which determines where
+// the documentation of its symbols is.
+type symbolDocStyle struct {
+	// linePrefixes start line comments, the longest first.
+	linePrefixes []string
+
+	// blockStart and blockEnd enclose block comments, if the language has
+	// them.
+	blockStart, blockEnd string
+
+	// docstrings are string literals starting the body of a declaration,
+	// like in Python. They take precedence over comments.
+	docstrings bool
+
+	// attributePrefixes start the lines between a comment and its symbol
+	// which don't separate them, eg. annotations and decorators.
+	attributePrefixes []string
+}
+
+var (
+	slashDocStyle = symbolDocStyle{
+		linePrefixes:      []string{"///", "//!", "//"},
+		blockStart:        "/*",
+		blockEnd:          "*/",
+		attributePrefixes: []string{"@", "#[", "["},
+	}
+	hashDocStyle = symbolDocStyle{
+		linePrefixes:      []string{"#"},
+		attributePrefixes: []string{"@"},
+	}
+	dashDocStyle = symbolDocStyle{
+		linePrefixes: []string{"---", "--"},
+	}
+)
+
+// symbolDocStyles are the languages whose symbol documentation can be
+// extracted, by their lowercase go-enry names.
+var symbolDocStyles = map[string]symbolDocStyle{
+	"c":               slashDocStyle,
+	"c#":              slashDocStyle,
+	"c++":             slashDocStyle,
+	"dart":            slashDocStyle,
+	"go":              slashDocStyle,
+	"groovy":          slashDocStyle,
+	"java":            slashDocStyle,
+	"javascript":      slashDocStyle,
+	"kotlin":          slashDocStyle,
+	"objective-c":     slashDocStyle,
+	"php":             slashDocStyle,
+	"protocol buffer": slashDocStyle,
+	"rust":            slashDocStyle,
+	"scala":           slashDocStyle,
+	"swift":           slashDocStyle,
+	"tsx":             slashDocStyle,
+	"typescript":      slashDocStyle,
+	"zig":             slashDocStyle,
+
+	"python": {
+		linePrefixes:      hashDocStyle.linePrefixes,
+		attributePrefixes: hashDocStyle.attributePrefixes,
+		docstrings:        true,
+	},
+	"coffeescript": hashDocStyle,
+	"crystal":      hashDocStyle,
+	"elixir":       hashDocStyle,
+	"perl":         hashDocStyle,
+	"powershell":   hashDocStyle,
+	"r":            hashDocStyle,
+	"ruby":         hashDocStyle,
+	"shell":        hashDocStyle,
+
+	"ada":     dashDocStyle,
+	"elm":     dashDocStyle,
+	"haskell": dashDocStyle,
+	"lua":     dashDocStyle,
+	"sql":     dashDocStyle,
+}
+
+// parseSymbolDocLanguages parses Options.SymbolDocs into the comment styles
+// of the languages, keyed like symbolDocStyles. It returns nil if no
+// documentation is extracted.
+func parseSymbolDocLanguages(s string) (map[string]symbolDocStyle, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s == "all" {
+		return symbolDocStyles, nil
+	}
+	styles := map[string]symbolDocStyle{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if canonical, ok := languages.GetLanguageByAlias(name); ok {
+			name = canonical
+		}
+		name = strings.ToLower(name)
+		style, ok := symbolDocStyles[name]
+		if !ok {
+			return nil, fmt.Errorf("symbol docs: unsupported language %q", name)
+		}
+		styles[name] = style
+	}
+	return styles, nil
+}
+
+// addSymbolDocs sets the Doc of the symbols of todo in the languages of
+// styles to their documentation comment.
+func addSymbolDocs(todo []*Document, styles map[string]symbolDocStyle) {
+	for _, doc := range todo {
+		if len(doc.Symbols) == 0 || len(doc.Symbols) != len(doc.SymbolsMetaData) {
+			continue
+		}
+		DetermineLanguageIfUnknown(doc)
+		style, ok := styles[strings.ToLower(doc.Language)]
+		if !ok {
+			continue
+		}
+		for i, sec := range doc.Symbols {
+			if sym := doc.SymbolsMetaData[i]; sym != nil && sym.Doc == "" {
+				sym.Doc = symbolDoc(doc.Content, sec.Start, style)
+			}
+		}
+	}
+}
+
+// symbolDoc returns the documentation of the symbol at offset start of
+// content: its docstring, or else the comment right above its line.
+func symbolDoc(content []byte, start uint32, style symbolDocStyle) string {
+	var doc string
+	if style.docstrings {
+		doc = docstring(content, start)
+	}
+	if doc == "" {
+		doc = precedingComment(content, start, style)
+	}
+	return truncateSymbolDoc(doc)
+}
+
+// precedingComment returns the text of the comment lines right above the
+// line of offset start. Attribute lines may come in between.
+func precedingComment(content []byte, start uint32, style symbolDocStyle) string {
+	end := bytes.LastIndexByte(content[:start], '\n')
+	var lines []string // bottom up
+	inBlock, blockClosed := false, false
+	for end > 0 {
+		lineStart := bytes.LastIndexByte(content[:end], '\n') + 1
+		line := strings.TrimSpace(string(content[lineStart:end]))
+		end = lineStart - 1
+
+		if inBlock {
+			if i := strings.Index(line, style.blockStart); i >= 0 {
+				lines = append(lines, line[i+len(style.blockStart):])
+				blockClosed = true
+				break
+			}
+			lines = append(lines, line)
+			continue
+		}
+
+		if p := linePrefix(line, style.linePrefixes); p != "" {
+			lines = append(lines, line[len(p):])
+			continue
+		}
+		if len(lines) > 0 {
+			break
+		}
+		if linePrefix(line, style.attributePrefixes) != "" {
+			continue
+		}
+		if style.blockEnd != "" && strings.HasSuffix(line, style.blockEnd) {
+			line = strings.TrimSuffix(line, style.blockEnd)
+			if i := strings.Index(line, style.blockStart); i >= 0 {
+				lines = append(lines, line[i+len(style.blockStart):])
+				break
+			}
+			lines = append(lines, line)
+			inBlock = true
+			continue
+		}
+		break
+	}
+	if inBlock && !blockClosed {
+		// Not a block comment, eg. the end of a nested one.
+		return ""
+	}
+
+	slices.Reverse(lines)
+	if style.blockStart != "" {
+		// Javadoc style blocks start their lines with '*'.
+		for i, l := range lines {
+			lines[i] = strings.TrimLeft(strings.TrimSpace(l), "*")
+		}
+	}
+	return cleanDoc(lines)
+}
+
+func linePrefix(line string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(line, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// docstring returns the string literal starting the body of the function or
+// class declared at offset start, like Python's __doc__.
+func docstring(content []byte, start uint32) string {
+	lineStart := bytes.LastIndexByte(content[:start], '\n') + 1
+	decl := bytes.TrimSpace(content[lineStart:start])
+	if !slices.ContainsFunc([]string{"def", "async def", "class"}, func(kw string) bool { return string(decl) == kw }) {
+		return ""
+	}
+
+	rest := content[start:]
+	for i := 0; ; i++ {
+		nl := bytes.IndexByte(rest, '\n')
+		if nl < 0 || i == maxDeclarationLines {
+			return ""
+		}
+		line := bytes.TrimSpace(rest[:nl])
+		rest = rest[nl+1:]
+		if bytes.HasSuffix(line, []byte(":")) {
+			break
+		}
+	}
+
+	body := bytes.TrimLeft(rest, " \t\r\n")
+	if len(body) > 0 && bytes.IndexByte([]byte("rRuU"), body[0]) >= 0 {
+		body = body[1:]
+	}
+	for _, quote := range []string{`"""`, `'''`} {
+		if !bytes.HasPrefix(body, []byte(quote)) {
+			continue
+		}
+		body = body[len(quote):]
+		end := bytes.Index(body, []byte(quote))
+		if end < 0 {
+			return ""
+		}
+		lines := strings.Split(string(body[:end]), "\n")
+		// Like inspect.cleandoc, the first line isn't indented.
+		lines[0] = strings.TrimSpace(lines[0])
+		dedent(lines[1:])
+		return strings.Trim(strings.Join(lines, "\n"), "\n")
+	}
+	return ""
+}
+
+// cleanDoc removes the common indentation of lines and the blank lines
+// around them, and joins them.
+func cleanDoc(lines []string) string {
+	dedent(lines)
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// dedent removes the common indentation and the trailing space of lines.
+func dedent(lines []string) {
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, l := range lines {
+		if len(l) >= indent && indent > 0 {
+			l = l[indent:]
+		}
+		lines[i] = strings.TrimRight(l, " \t\r")
+	}
+}
+
+func truncateSymbolDoc(doc string) string {
+	if len(doc) <= maxSymbolDocBytes {
+		return doc
+	}
+	doc = doc[:maxSymbolDocBytes]
+	for len(doc) > 0 && !utf8.ValidString(doc) {
+		doc = doc[:len(doc)-1]
+	}
+	return doc
+}
+
+// encodeSymbolDocs encodes the documentation of the symbols of a document as
+// their number followed by the length and text of each. It returns nil if
+// none of the symbols is documented.
+func encodeSymbolDocs(syms []*zoekt.Symbol) []byte {
+	if !slices.ContainsFunc(syms, func(s *zoekt.Symbol) bool { return s != nil && s.Doc != "" }) {
+		return nil
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(syms)))
+	for _, s := range syms {
+		var doc string
+		if s != nil {
+			doc = s.Doc
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(doc)))
+		buf = append(buf, doc...)
+	}
+	return buf
+}
+
+// decodeSymbolDoc returns the documentation of symbol i of a document from
+// its encodeSymbolDocs blob.
+func decodeSymbolDoc(blob []byte, i uint32) (string, error) {
+	if len(blob) == 0 {
+		return "", nil
+	}
+	n, m := binary.Uvarint(blob)
+	if m <= 0 {
+		return "", fmt.Errorf("symbolDocs: invalid symbol count")
+	}
+	if uint64(i) >= n {
+		return "", nil
+	}
+	blob = blob[m:]
+	for j := uint32(0); ; j++ {
+		sz, m := binary.Uvarint(blob)
+		if m <= 0 || uint64(len(blob)-m) < sz {
+			return "", fmt.Errorf("symbolDocs: truncated documentation")
+		}
+		blob = blob[m:]
+		if j == i {
+			return string(blob[:sz]), nil
+		}
+		blob = blob[sz:]
+	}
+}
+
+// symbolDocumentation returns the documentation of symbol i of document doc.
+// It returns "" for shards written without symbol documentation.
+func (d *indexData) symbolDocumentation(doc, i uint32) string {
+	if d.symbolDocsIndex == nil {
+		return ""
+	}
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.symbolDocsStart + d.symbolDocsIndex[doc],
+		sz:  d.symbolDocsIndex[doc+1] - d.symbolDocsIndex[doc],
+	})
+	if err != nil {
+		return ""
+	}
+	text, err := decodeSymbolDoc(blob, i)
+	if err != nil {
+		return ""
+	}
+	return text
+}
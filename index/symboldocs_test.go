@@ -0,0 +1,173 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSymbolDoc(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		lang    string
+		content string
+		sym     string
+		want    string
+	}{{
+		name:    "line comment",
+		lang:    "go",
+		content: "package p\n\n// Answer returns\n// the answer.\nfunc Answer() int { return 42 }\n",
+		sym:     "Answer()",
+		want:    "Answer returns\nthe answer.",
+	}, {
+		name:    "blank line",
+		lang:    "go",
+		content: "package p\n\n// Unrelated.\n\nfunc Answer() int { return 42 }\n",
+		sym:     "Answer()",
+		want:    "",
+	}, {
+		name:    "javadoc",
+		lang:    "java",
+		content: "class A {\n  /**\n   * Says hello.\n   *\n   * @param name who\n   */\n  @Override\n  public void hello(String name) {}\n}\n",
+		sym:     "hello(",
+		want:    "Says hello.\n\n@param name who",
+	}, {
+		name:    "one line block",
+		lang:    "c",
+		content: "/* Adds one. */\nint inc(int x);\n",
+		sym:     "inc",
+		want:    "Adds one.",
+	}, {
+		name:    "end of nested block",
+		lang:    "go",
+		content: "var x = 1 */\nfunc f() {}\n",
+		sym:     "f()",
+		want:    "",
+	}, {
+		name:    "rust attribute",
+		lang:    "rust",
+		content: "/// A point.\n#[derive(Debug)]\nstruct Point;\n",
+		sym:     "Point",
+		want:    "A point.",
+	}, {
+		name:    "docstring",
+		lang:    "python",
+		content: "# Not the doc.\ndef f(x,\n      y):\n    \"\"\"Return x.\n\n    Twice.\n    \"\"\"\n    return x\n",
+		sym:     "f(",
+		want:    "Return x.\n\nTwice.",
+	}, {
+		name:    "comment without docstring",
+		lang:    "python",
+		content: "# Comment on g.\n@cache\ndef g():\n    pass\n",
+		sym:     "g(",
+		want:    "Comment on g.",
+	}, {
+		name:    "docstring of a call",
+		lang:    "python",
+		content: "x = g(\n    1):\n    \"\"\"Not a docstring.\"\"\"\n",
+		sym:     "g(",
+		want:    "",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := strings.Index(tc.content, tc.sym)
+			got := symbolDoc([]byte(tc.content), uint32(start), symbolDocStyles[tc.lang])
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSymbolDocTruncated(t *testing.T) {
+	content := "// " + strings.Repeat("é", maxSymbolDocBytes) + "\nfunc f() {}\n"
+	got := symbolDoc([]byte(content), uint32(strings.Index(content, "f()")), slashDocStyle)
+	if len(got) != maxSymbolDocBytes || !strings.HasPrefix(got, "éé") {
+		t.Errorf("got %d bytes, want %d", len(got), maxSymbolDocBytes)
+	}
+}
+
+func TestParseSymbolDocLanguages(t *testing.T) {
+	styles, err := parseSymbolDocLanguages("Go, python,golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(styles) != 2 || !styles["python"].docstrings {
+		t.Errorf("got %v, want go and python", styles)
+	}
+	if styles, err := parseSymbolDocLanguages(""); styles != nil || err != nil {
+		t.Errorf("got %v, %v for no languages", styles, err)
+	}
+	if _, err := parseSymbolDocLanguages("go,cobol"); err == nil {
+		t.Error("got no error for an unsupported language")
+	}
+}
+
+func TestEncodeSymbolDocs(t *testing.T) {
+	if blob := encodeSymbolDocs([]*zoekt.Symbol{{Kind: "function"}, nil}); blob != nil {
+		t.Errorf("got %v for undocumented symbols, want nil", blob)
+	}
+
+	syms := []*zoekt.Symbol{{Doc: "first"}, nil, {}, {Doc: "fourth"}}
+	blob := encodeSymbolDocs(syms)
+	for i, want := range []string{"first", "", "", "fourth", ""} {
+		got, err := decodeSymbolDoc(blob, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("symbol %d: got %q, want %q", i, got, want)
+		}
+	}
+	if _, err := decodeSymbolDoc(blob[:len(blob)-1], 3); err == nil {
+		t.Error("got no error for truncated documentation")
+	}
+}
+
+func TestSearchSymbolDocs(t *testing.T) {
+	content := []byte("package p\n\n// Answer returns the answer.\nfunc Answer() int { return 42 }\n\nfunc Question() {}\n")
+	doc := Document{
+		Name:            "answer.go",
+		Content:         content,
+		Symbols:         []DocumentSection{{Start: 46, End: 52}, {Start: 79, End: 87}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}, {Kind: "function"}},
+	}
+	addSymbolDocs([]*Document{&doc}, symbolDocStyles)
+
+	b := testShardBuilder(t, nil, doc, Document{Name: "other.go", Content: []byte("package p\n")})
+	searcher := searcherForTest(t, b)
+	if d := searcher.(*indexData); d.symbolDocsIndex == nil {
+		t.Fatal("shard has no symbol documentation")
+	}
+
+	res, err := searcher.Search(context.Background(),
+		&query.Symbol{Expr: &query.Substring{Pattern: "Answer", CaseSensitive: true}},
+		&zoekt.SearchOptions{ChunkMatches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || len(res.Files[0].ChunkMatches) != 1 {
+		t.Fatalf("got %+v, want one chunk match", res.Files)
+	}
+	si := res.Files[0].ChunkMatches[0].SymbolInfo
+	if len(si) != 1 || si[0] == nil || si[0].Doc != "Answer returns the answer." {
+		t.Errorf("got symbol info %+v, want the doc comment", si)
+	}
+
+	defs, err := zoekt.Definitions(context.Background(), searcher, "Question", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Symbol.Doc != "" {
+		t.Errorf("got definitions %+v, want one without doc", defs)
+	}
+	defs, err = zoekt.Definitions(context.Background(), searcher, "Answer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Symbol.Doc != "Answer returns the answer." {
+		t.Errorf("got definitions %+v, want the doc comment", defs)
+	}
+}
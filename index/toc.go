@@ -43,7 +43,9 @@ const IndexFormatVersion = 16
 // 10: Compound shards; more flexible TOC format.
 // 11: Bloom filters for file names & contents
 // 12: go-enry for identifying file languages
-const FeatureVersion = 12
+// 13: 64-bit section offsets for shards larger than 4GB
+// 14: docFlags section, also for shards without flagged documents
+const FeatureVersion = 14
 
 // WriteMinFeatureVersion and ReadMinFeatureVersion constrain forwards and backwards
 // compatibility. For example, if a new way to encode filenameNgrams on disk is
@@ -65,6 +67,36 @@ const WriteMinFeatureVersion = 10
 // load a file with a FeatureVersion below it.
 const ReadMinFeatureVersion = 8
 
+// ReadMaxFeatureVersion is the highest IndexMinReaderVersion of the files
+// this reader loads. It is above FeatureVersion for features which only some
+// shards use, so adding them doesn't require reindexing the other shards.
+// 14: Encrypted file contents
+// 15: Stop-ngrams
+// 16: Deduplicated file contents
+// 17: 64-bit offsets within the contents
+const ReadMaxFeatureVersion = 17
+
+// encryptedContentsMinReaderVersion is the IndexMinReaderVersion of shards
+// with encrypted file contents, so readers which can't decrypt them refuse
+// them instead of serving the ciphertext.
+const encryptedContentsMinReaderVersion = 14
+
+// stopNgramsMinReaderVersion is the IndexMinReaderVersion of shards with
+// stop-ngrams. Readers which don't know about them would miss the matches of
+// patterns containing a stop-ngram.
+const stopNgramsMinReaderVersion = 15
+
+// dedupedContentsMinReaderVersion is the IndexMinReaderVersion of shards
+// with deduplicated file contents. Readers which don't know about them would
+// read the contents of the wrong documents.
+const dedupedContentsMinReaderVersion = 16
+
+// wideContentsMinReaderVersion is the IndexMinReaderVersion of shards whose
+// contents, or the data of the sections indexed per document, are larger
+// than 4GB. Readers which don't know about them truncate the offsets within
+// the contents to 32 bits.
+const wideContentsMinReaderVersion = 17
+
 // 17: compound shard (multi repo)
 const NextIndexFormatVersion = 17
 
@@ -100,6 +132,54 @@ type indexTOC struct {
 	reposIDsBitmap simpleSection
 
 	ranks simpleSection
+
+	// Optional trigram indexes of the lowercased file names and symbols.
+	foldedNameNgramText   simpleSection
+	foldedNamePostings    compoundSection
+	foldedSymbolNgramText simpleSection
+	foldedSymbolPostings  compoundSection
+
+	// Optional trigram index of the symbols.
+	symbolNgramText simpleSection
+	symbolPostings  compoundSection
+
+	// Optional hash index of the symbol names.
+	symbolHashes simpleSection
+
+	// Optional hash index of the deletion neighborhoods of the symbol names.
+	fuzzySymbols simpleSection
+
+	// Optional offsets of the plaintext file contents, for shards whose
+	// fileContents are encrypted or deduplicated.
+	contentBoundaries simpleSection
+
+	// Optional documents whose stored contents each document shares, for
+	// shards whose fileContents are deduplicated.
+	contentSources simpleSection
+
+	// Optional list of the content trigrams left out of the postings.
+	stopNgrams simpleSection
+
+	// Optional checkpoints of the long lines of each document.
+	longLines compoundSection
+
+	// Optional precomputed ranking signal of each document.
+	rankingSignals simpleSection
+
+	// Optional documentation of the symbols of each document.
+	symbolDocs compoundSection
+
+	// Optional bloom filter of the names and IDs of the repositories.
+	repoBloom simpleSection
+
+	// Optional documents containing each pair of adjacent CJK characters.
+	cjkBigrams simpleSection
+
+	// Optional flags of each document, see classifyDoc.
+	docFlags simpleSection
+
+	// Optional labels of each document, see encodeDocLabels.
+	docLabels simpleSection
 }
 
 func (t *indexTOC) sections() []section {
@@ -151,6 +231,48 @@ func (t *indexTOC) sectionsTagged() map[string]section {
 	for _, ent := range t.sectionsTaggedCompatibilityList() {
 		out[ent.tag] = ent.sec
 	}
+	for _, ent := range t.sectionsFoldedNgrams() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsSymbolNgrams() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsSymbolHashes() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsFuzzySymbols() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsEncryptedContents() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsDedupedContents() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsStopNgrams() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsLongLines() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsRankingSignals() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsSymbolDocs() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsRepoBloom() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsCJKBigrams() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsDocFlags() {
+		out[ent.tag] = ent.sec
+	}
+	for _, ent := range t.sectionsDocLabels() {
+		out[ent.tag] = ent.sec
+	}
 	return out
 }
 
@@ -192,6 +314,124 @@ func (t *indexTOC) sectionsTaggedList() []taggedSection {
 	}
 }
 
+// sectionsFoldedNgrams returns the sections of the optional case-folded
+// trigram indexes. They are only written if the shard builder produced them.
+func (t *indexTOC) sectionsFoldedNgrams() []taggedSection {
+	return []taggedSection{
+		{"foldedNameNgramText", &t.foldedNameNgramText},
+		{"foldedNamePostings", &t.foldedNamePostings},
+		{"foldedSymbolNgramText", &t.foldedSymbolNgramText},
+		{"foldedSymbolPostings", &t.foldedSymbolPostings},
+	}
+}
+
+// sectionsSymbolNgrams returns the sections of the optional symbol trigram
+// index. They are only written if the shard builder produced them.
+func (t *indexTOC) sectionsSymbolNgrams() []taggedSection {
+	return []taggedSection{
+		{"symbolNgramText", &t.symbolNgramText},
+		{"symbolPostings", &t.symbolPostings},
+	}
+}
+
+// sectionsSymbolHashes returns the section of the optional symbol hash index.
+// It is only written if the shard builder produced it.
+func (t *indexTOC) sectionsSymbolHashes() []taggedSection {
+	return []taggedSection{
+		{"symbolHashes", &t.symbolHashes},
+	}
+}
+
+// sectionsFuzzySymbols returns the section of the optional fuzzy symbol
+// index. It is only written if the shard builder produced it.
+func (t *indexTOC) sectionsFuzzySymbols() []taggedSection {
+	return []taggedSection{
+		{"fuzzySymbols", &t.fuzzySymbols},
+	}
+}
+
+// sectionsEncryptedContents returns the section of the plaintext content
+// offsets. It is only written for shards with encrypted or deduplicated
+// contents.
+func (t *indexTOC) sectionsEncryptedContents() []taggedSection {
+	return []taggedSection{
+		{"contentBoundaries", &t.contentBoundaries},
+	}
+}
+
+// sectionsDedupedContents returns the section of the content sources. It is
+// only written for shards with deduplicated contents.
+func (t *indexTOC) sectionsDedupedContents() []taggedSection {
+	return []taggedSection{
+		{"contentSources", &t.contentSources},
+	}
+}
+
+// sectionsStopNgrams returns the section of the stop-ngrams. It is only
+// written for shards built with a maximum trigram frequency.
+func (t *indexTOC) sectionsStopNgrams() []taggedSection {
+	return []taggedSection{
+		{"stopNgrams", &t.stopNgrams},
+	}
+}
+
+// sectionsLongLines returns the section of the long line checkpoints. It is
+// only written for shards with long lines.
+func (t *indexTOC) sectionsLongLines() []taggedSection {
+	return []taggedSection{
+		{"longLines", &t.longLines},
+	}
+}
+
+// sectionsRankingSignals returns the section of the ranking signals. It is
+// only written for shards with documents which have a ranking signal.
+func (t *indexTOC) sectionsRankingSignals() []taggedSection {
+	return []taggedSection{
+		{"rankingSignals", &t.rankingSignals},
+	}
+}
+
+// sectionsSymbolDocs returns the section of the symbol documentation. It is
+// only written for shards with documented symbols.
+func (t *indexTOC) sectionsSymbolDocs() []taggedSection {
+	return []taggedSection{
+		{"symbolDocs", &t.symbolDocs},
+	}
+}
+
+// sectionsRepoBloom returns the section of the repository bloom filter. It is
+// only written for compound shards.
+func (t *indexTOC) sectionsRepoBloom() []taggedSection {
+	return []taggedSection{
+		{"repoBloom", &t.repoBloom},
+	}
+}
+
+// sectionsCJKBigrams returns the section of the CJK bigram index. It is only
+// written for shards built with Options.CJKBigrams.
+func (t *indexTOC) sectionsCJKBigrams() []taggedSection {
+	return []taggedSection{
+		{"cjkBigrams", &t.cjkBigrams},
+	}
+}
+
+// sectionsDocFlags returns the section of the document flags. From
+// docFlagsFeatureVersion on, it is written for every shard, and is empty if
+// no document has flags. Older shards are classified when they are loaded.
+func (t *indexTOC) sectionsDocFlags() []taggedSection {
+	return []taggedSection{
+		{"docFlags", &t.docFlags},
+	}
+}
+
+// sectionsDocLabels returns the section of the document labels. It is only
+// written for shards with labeled documents.
+func (t *indexTOC) sectionsDocLabels() []taggedSection {
+	return []taggedSection{
+		{"docLabels", &t.docLabels},
+	}
+}
+
 // sectionsTaggedCompatibilityList returns a list of sections that will be
 // handled or converted for backwards compatiblity, but aren't written by
 // the current iteration of the indexer.
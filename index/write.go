@@ -17,10 +17,13 @@ package index
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"slices"
 	"sort"
 	"time"
 
@@ -42,13 +45,87 @@ func (w *writer) writeTOC(toc *indexTOC) {
 	// compoundSections have different lengths.
 	w.U32(0)
 	secs := toc.sectionsTaggedList()
+	if toc.foldedNamePostings.data.off > 0 {
+		secs = append(secs, toc.sectionsFoldedNgrams()...)
+	}
+	if toc.symbolPostings.data.off > 0 {
+		secs = append(secs, toc.sectionsSymbolNgrams()...)
+	}
+	if toc.symbolHashes.off > 0 {
+		secs = append(secs, toc.sectionsSymbolHashes()...)
+	}
+	if toc.fuzzySymbols.off > 0 {
+		secs = append(secs, toc.sectionsFuzzySymbols()...)
+	}
+	if toc.contentBoundaries.off > 0 {
+		secs = append(secs, toc.sectionsEncryptedContents()...)
+	}
+	if toc.contentSources.off > 0 {
+		secs = append(secs, toc.sectionsDedupedContents()...)
+	}
+	if toc.stopNgrams.off > 0 {
+		secs = append(secs, toc.sectionsStopNgrams()...)
+	}
+	if toc.longLines.data.off > 0 {
+		secs = append(secs, toc.sectionsLongLines()...)
+	}
+	if toc.rankingSignals.off > 0 {
+		secs = append(secs, toc.sectionsRankingSignals()...)
+	}
+	if toc.symbolDocs.data.off > 0 {
+		secs = append(secs, toc.sectionsSymbolDocs()...)
+	}
+	if toc.repoBloom.off > 0 {
+		secs = append(secs, toc.sectionsRepoBloom()...)
+	}
+	if toc.cjkBigrams.off > 0 {
+		secs = append(secs, toc.sectionsCJKBigrams()...)
+	}
+	if toc.docFlags.off > 0 {
+		secs = append(secs, toc.sectionsDocFlags()...)
+	}
+	if toc.docLabels.off > 0 {
+		secs = append(secs, toc.sectionsDocLabels()...)
+	}
 	for _, s := range secs {
 		w.String(s.tag)
-		w.Varint(uint32(s.sec.kind()))
-		s.sec.write(w)
+		w.Varint(uint32(encodedKind(s.sec)))
+		s.sec.write(w, s.sec.wide())
 	}
 }
 
+// wideTrailerMarker marks a trailer with a 64-bit TOC section. Readers which
+// don't know about it see a TOC section which is out of bounds.
+const wideTrailerMarker = math.MaxUint64
+
+// wideTrailerSize is the size of a trailer with a 64-bit TOC section.
+const wideTrailerSize = 24
+
+// writeTrailer writes the section of the TOC at the end of the file. If the
+// TOC ends beyond 4GB, its 64-bit section is followed by wideTrailerMarker.
+func (w *writer) writeTrailer(toc simpleSection) {
+	if !toc.wide() {
+		toc.write(w, false)
+		return
+	}
+	toc.write(w, true)
+	w.U64(wideTrailerMarker)
+}
+
+// hasWideDocumentData reports whether the data of a section which readers
+// index by the relative offsets of its items is larger than 4GB.
+func (t *indexTOC) hasWideDocumentData() bool {
+	for _, s := range []*compoundSection{
+		&t.fileContents, &t.fileNames, &t.fileSections, &t.newlines,
+		&t.longLines, &t.symbolDocs, &t.symbolKindMap,
+	} {
+		if s.data.sz > math.MaxUint32 {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *compoundSection) writeStrings(w *writer, strs []*searchableString) {
 	s.start(w)
 	for _, f := range strs {
@@ -79,6 +156,24 @@ func writeUint32Bitmap(w *writer, dat []uint32) {
 func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 	charOffsets *simpleSection, postings *compoundSection, endRunes *simpleSection,
 ) {
+	writeNgrams(w, s, ngramText, postings)
+
+	charOffsets.start(w)
+	w.Write(toSizedDeltas64(s.runeOffsets))
+	charOffsets.end(w)
+
+	endRunes.start(w)
+	w.Write(toSizedDeltas(s.endRunes))
+	endRunes.end(w)
+}
+
+// writeNgrams writes the trigrams in s and their posting lists.
+func writeNgrams(w *writer, s *postingsBuilder, ngramText *simpleSection, postings *compoundSection) {
+	if len(s.runs) > 0 {
+		writeSpilledNgrams(w, s, ngramText, postings)
+		return
+	}
+
 	keys := make(ngramSlice, 0, len(s.postings))
 	for k := range s.postings {
 		keys = append(keys, k)
@@ -98,32 +193,99 @@ func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 		postings.addItem(w, s.postings[k])
 	}
 	postings.end(w)
+}
 
-	charOffsets.start(w)
-	w.Write(toSizedDeltas(s.runeOffsets))
-	charOffsets.end(w)
+// writeSpilledNgrams is writeNgrams for postings with runs. The trigrams and
+// the posting lists are written in two passes over the merged runs, so the
+// merged postings are never held in memory.
+func writeSpilledNgrams(w *writer, s *postingsBuilder, ngramText *simpleSection, postings *compoundSection) {
+	ngramText.start(w)
+	err := s.eachPostings(func(ng ngram, p []byte) {
+		w.U64(uint64(ng))
+	})
+	ngramText.end(w)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
 
-	endRunes.start(w)
-	w.Write(toSizedDeltas(s.endRunes))
-	endRunes.end(w)
+	postings.start(w)
+	err = s.eachPostings(func(ng ngram, p []byte) {
+		postings.addItem(w, p)
+	})
+	postings.end(w)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
 }
 
 func (b *ShardBuilder) Write(out io.Writer) error {
-	next := b.indexFormatVersion == NextIndexFormatVersion
-
 	buffered := bufio.NewWriterSize(out, 1<<20)
 	defer buffered.Flush()
 
-	w := &writer{w: buffered}
+	return b.write(&writer{w: buffered})
+}
+
+// writeHashed is like Write, and also returns the content hash of the shard.
+// It leaves out the shard metadata, so shards built from the same documents
+// with the same options get the same hash. Shards with encrypted contents
+// never do, since every build encrypts them with new nonces.
+func (b *ShardBuilder) writeHashed(out io.Writer) ([]byte, error) {
+	buffered := bufio.NewWriterSize(out, 1<<20)
+	digest := sha256.New()
+	if err := b.write(&writer{w: buffered, digest: digest}); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+// write writes the shard to w. Offsets in the shard are relative to the
+// start of w, which is w.Off() bytes before the first byte written.
+func (b *ShardBuilder) write(w *writer) error {
+	next := b.indexFormatVersion == NextIndexFormatVersion
+
 	toc := indexTOC{}
 
-	toc.fileContents.writeStrings(w, b.contentStrings)
+	var dedup dedupStats
+	if b.contentKeys != nil {
+		if err := b.writeEncryptedContents(w, &toc); err != nil {
+			return err
+		}
+	} else if b.dedupContents {
+		dedup = b.writeDedupedContents(w, &toc)
+	} else {
+		toc.fileContents.writeStrings(w, b.contentStrings)
+	}
 	toc.newlines.start(w)
 	for _, f := range b.contentStrings {
 		toc.newlines.addItem(w, toSizedDeltas(newLinesIndices(f.data)))
 	}
 	toc.newlines.end(w)
 
+	longLines := make([][]byte, len(b.contentStrings))
+	hasLongLines := false
+	for i, f := range b.contentStrings {
+		longLines[i] = encodeLongLineCheckpoints(longLineCheckpoints(f.data))
+		hasLongLines = hasLongLines || longLines[i] != nil
+	}
+	if hasLongLines {
+		toc.longLines.start(w)
+		for _, cps := range longLines {
+			toc.longLines.addItem(w, cps)
+		}
+		toc.longLines.end(w)
+	}
+
+	if slices.ContainsFunc(b.symbolDocs, func(blob []byte) bool { return blob != nil }) {
+		toc.symbolDocs.start(w)
+		for _, blob := range b.symbolDocs {
+			toc.symbolDocs.addItem(w, blob)
+		}
+		toc.symbolDocs.end(w)
+	}
+
 	toc.fileEndSymbol.start(w)
 	for _, m := range b.fileEndSymbol {
 		w.U32(m)
@@ -150,6 +312,14 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 	}
 	toc.fileSections.end(w)
 
+	if b.maxTrigramFrequency > 0 {
+		stop, err := b.contentPostings.removeStopNgrams(b.maxTrigramFrequency)
+		if err != nil {
+			return err
+		}
+		b.stopNgrams = append(b.stopNgrams, stop...)
+		slices.Sort(b.stopNgrams)
+	}
 	writePostings(w, b.contentPostings, &toc.ngramText, &toc.runeOffsets, &toc.postings, &toc.fileEndRunes)
 
 	// names.
@@ -157,6 +327,29 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 
 	writePostings(w, b.namePostings, &toc.nameNgramText, &toc.nameRuneOffsets, &toc.namePostings, &toc.nameEndRunes)
 
+	if b.foldedNamePostings != nil {
+		writeNgrams(w, b.foldedNamePostings, &toc.foldedNameNgramText, &toc.foldedNamePostings)
+		writeNgrams(w, b.foldedSymbolPostings, &toc.foldedSymbolNgramText, &toc.foldedSymbolPostings)
+	}
+	if b.symbolPostings != nil {
+		writeNgrams(w, b.symbolPostings, &toc.symbolNgramText, &toc.symbolPostings)
+	}
+	if b.symbolHashes != nil {
+		toc.symbolHashes.start(w)
+		w.Write(encodeSymbolHashes(b.symbolHashes))
+		toc.symbolHashes.end(w)
+	}
+	if b.fuzzySymbols != nil {
+		toc.fuzzySymbols.start(w)
+		w.Write(encodeSymbolHashes(b.fuzzySymbols))
+		toc.fuzzySymbols.end(w)
+	}
+	if b.maxTrigramFrequency > 0 {
+		toc.stopNgrams.start(w)
+		w.Write(encodeStopNgrams(b.maxTrigramFrequency, b.stopNgrams))
+		toc.stopNgrams.end(w)
+	}
+
 	toc.subRepos.start(w)
 	w.Write(toSizedDeltas(b.subRepos))
 	toc.subRepos.end(w)
@@ -173,6 +366,26 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 	w.Write(marshalDocSections(b.runeDocSections))
 	toc.runeDocSections.end(w)
 
+	if slices.ContainsFunc(b.rankingSignals, func(s float32) bool { return s != 0 }) {
+		toc.rankingSignals.start(w)
+		w.Write(encodeRankingSignals(b.rankingSignals))
+		toc.rankingSignals.end(w)
+	}
+
+	if b.featureVersion >= docFlagsFeatureVersion {
+		toc.docFlags.start(w)
+		if slices.ContainsFunc(b.docFlags, func(f byte) bool { return f != 0 }) {
+			w.Write(b.docFlags)
+		}
+		toc.docFlags.end(w)
+	}
+
+	if len(b.labelSets) > 0 {
+		toc.docLabels.start(w)
+		w.Write(encodeDocLabels(b.labelSets, b.docLabels))
+		toc.docLabels.end(w)
+	}
+
 	if next {
 		toc.repos.start(w)
 		w.Write(toSizedDeltas16(b.repos))
@@ -185,43 +398,103 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 		toc.reposIDsBitmap.end(w)
 	}
 
+	if next && len(b.repoList) > 1 {
+		toc.repoBloom.start(w)
+		w.Write(encodeRepoBloom(newRepoBloom(b.repoList)))
+		toc.repoBloom.end(w)
+	}
+
+	if b.cjkBigrams != nil {
+		blob, err := encodeCJKBigrams(b.cjkBigrams)
+		if err != nil {
+			return err
+		}
+		toc.cjkBigrams.start(w)
+		w.Write(blob)
+		toc.cjkBigrams.end(w)
+	}
+
 	indexTime := b.IndexTime
 	if indexTime.IsZero() {
 		indexTime = time.Now().UTC()
 	}
 
+	minReaderVersion := WriteMinFeatureVersion
+	if len(b.stopNgrams) > 0 {
+		minReaderVersion = stopNgramsMinReaderVersion
+	}
+	if b.contentKeys != nil {
+		minReaderVersion = max(minReaderVersion, encryptedContentsMinReaderVersion)
+	}
+	if dedup.documents > 0 {
+		minReaderVersion = max(minReaderVersion, dedupedContentsMinReaderVersion)
+	}
+	if b.contentPostings.endByte > math.MaxUint32 || toc.hasWideDocumentData() {
+		minReaderVersion = max(minReaderVersion, wideContentsMinReaderVersion)
+	}
+
+	// The metadata differs between builds of the same documents, eg. in its
+	// index time, and the TOC has its offsets, so neither is part of the
+	// content hash.
+	digest := w.digest
+	w.digest = nil
 	if err := b.writeJSON(&zoekt.IndexMetadata{
 		IndexFormatVersion:    b.indexFormatVersion,
 		IndexTime:             indexTime,
 		IndexFeatureVersion:   b.featureVersion,
-		IndexMinReaderVersion: WriteMinFeatureVersion,
+		IndexMinReaderVersion: minReaderVersion,
 		PlainASCII:            b.contentPostings.isPlainASCII && b.namePostings.isPlainASCII,
 		LanguageMap:           b.languageMap,
 		ZoektVersion:          Version,
 		ID:                    b.ID,
+		Documents:             len(b.contentStrings),
+		ContentBytes:          int64(b.contentPostings.endByte),
+		DuplicateDocuments:    dedup.documents,
+		DuplicateContentBytes: dedup.bytes,
 	}, &toc.metaData, w); err != nil {
 		return err
 	}
-
-	if next {
-		if err := b.writeJSON(b.repoList, &toc.repoMetaData, w); err != nil {
-			return err
+	w.digest = digest
+
+	// Repositories without an index time were indexed into this shard. Like
+	// the one of the shard, their index time is not part of the content
+	// hash.
+	repos := slices.Clone(b.repoList)
+	hashedRepos := slices.Clone(b.repoList)
+	for i := range repos {
+		if repos[i].IndexTime.IsZero() {
+			repos[i].IndexTime = indexTime
 		}
-	} else {
+		hashedRepos[i].IndexTime = time.Time{}
+	}
+	var repoMetaData, hashedRepoMetaData any = repos, hashedRepos
+	if !next {
 		if len(b.repoList) != 1 {
 			return fmt.Errorf("have %d repos, but only support 1 in index format version %d", len(b.repoList), b.indexFormatVersion)
 		}
-		if err := b.writeJSON(b.repoList[0], &toc.repoMetaData, w); err != nil {
+		repoMetaData, hashedRepoMetaData = repos[0], hashedRepos[0]
+	}
+	w.digest = nil
+	if err := b.writeJSON(repoMetaData, &toc.repoMetaData, w); err != nil {
+		return err
+	}
+	w.digest = digest
+	if digest != nil {
+		blob, err := json.Marshal(hashedRepoMetaData)
+		if err != nil {
 			return err
 		}
+		digest.Write(blob)
 	}
 
+	w.digest = nil
+
 	var tocSection simpleSection
 
 	tocSection.start(w)
 	w.writeTOC(&toc)
 	tocSection.end(w)
-	tocSection.write(w)
+	w.writeTrailer(tocSection)
 	return w.err
 }
 
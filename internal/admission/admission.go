@@ -0,0 +1,294 @@
+// Package admission limits the number of requests zoekt-webserver serves at
+// once. Requests beyond the limit wait in a bounded queue for a free slot,
+// and are rejected once the queue is full or they waited too long, so a burst
+// of queries is turned away early instead of slowing down every request.
+package admission
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_admission_in_flight_requests",
+		Help: "The number of requests being served.",
+	})
+	metricQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_admission_queued_requests",
+		Help: "The number of requests waiting to be served.",
+	})
+	metricQueueDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zoekt_admission_queue_duration_seconds",
+		Help:    "The time requests waited to be served.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+	metricRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_admission_rejected_requests_total",
+		Help: "The number of requests rejected without being served, by reason.",
+	}, []string{"reason"})
+)
+
+// Options configures a Limiter.
+type Options struct {
+	// MaxConcurrent is the number of requests served at once. If it is 0,
+	// requests are not limited.
+	MaxConcurrent int
+
+	// MaxQueue is the number of requests which may wait for a free slot.
+	// Requests arriving while the queue is full are rejected.
+	MaxQueue int
+
+	// MaxQueueTime is how long a request may wait for a free slot. If it is
+	// 0, requests wait until their context is done.
+	MaxQueueTime time.Duration
+
+	// Timeout bounds the time to serve a request, including the time it
+	// waited. It is optional.
+	Timeout time.Duration
+
+	// Exempt lists path prefixes which are served without a slot, like
+	// health checks and metrics.
+	Exempt []string
+}
+
+func (o Options) equal(other Options) bool {
+	return o.MaxConcurrent == other.MaxConcurrent &&
+		o.MaxQueue == other.MaxQueue &&
+		o.MaxQueueTime == other.MaxQueueTime &&
+		o.Timeout == other.Timeout &&
+		slices.Equal(o.Exempt, other.Exempt)
+}
+
+var (
+	// ErrQueueFull is returned by Acquire if the queue has no room for
+	// another request.
+	ErrQueueFull = errors.New("too many requests queued")
+
+	// ErrQueueTimeout is returned by Acquire if no slot became free within
+	// Options.MaxQueueTime.
+	ErrQueueTimeout = errors.New("timed out waiting in the request queue")
+)
+
+// Limiter admits requests into a fixed number of slots.
+type Limiter struct {
+	limits atomic.Pointer[limits]
+}
+
+// limits are the options of a Limiter with their slots. SetOptions replaces
+// them, so requests release their slot into the limits they acquired it from.
+type limits struct {
+	opts Options
+
+	// slots has a value for every request being served. It is nil if
+	// requests are not limited.
+	slots chan struct{}
+
+	// queue has a value for every request waiting for a slot.
+	queue chan struct{}
+}
+
+// New returns a Limiter for opts. It returns nil if opts.MaxConcurrent is 0.
+// The methods of a nil Limiter don't limit requests.
+func New(opts Options) *Limiter {
+	if opts.MaxConcurrent <= 0 {
+		return nil
+	}
+	return NewAdjustable(opts)
+}
+
+// NewAdjustable is like New, but always returns a Limiter, whose options can
+// be changed with SetOptions.
+func NewAdjustable(opts Options) *Limiter {
+	l := &Limiter{}
+	l.SetOptions(opts)
+	return l
+}
+
+// SetOptions replaces the options of l. Requests which are being served or
+// queued keep the slots and queue of the previous options, so the number of
+// requests served at once may exceed either limit until they are done.
+// Setting the options l already has keeps its slots, so reloading an
+// unchanged configuration doesn't admit more requests. It is safe to call
+// while l is in use.
+func (l *Limiter) SetOptions(opts Options) {
+	if cur := l.limits.Load(); cur != nil && cur.opts.equal(opts) {
+		return
+	}
+	lim := &limits{opts: opts}
+	if opts.MaxConcurrent > 0 {
+		lim.slots = make(chan struct{}, opts.MaxConcurrent)
+		lim.queue = make(chan struct{}, max(opts.MaxQueue, 0))
+	}
+	l.limits.Store(lim)
+}
+
+// Acquire waits for a free slot. The caller must call release once it is
+// done with the request. It returns ErrQueueFull or ErrQueueTimeout if the
+// request is turned away, or the error of ctx if it is done first.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	return l.limits.Load().acquire(ctx)
+}
+
+func (l *limits) acquire(ctx context.Context) (release func(), err error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.acquired(), nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		metricRejected.WithLabelValues("queue_full").Inc()
+		return nil, ErrQueueFull
+	}
+	metricQueued.Inc()
+	start := time.Now()
+	defer func() {
+		<-l.queue
+		metricQueued.Dec()
+		metricQueueDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	var timeout <-chan time.Time
+	if l.opts.MaxQueueTime > 0 {
+		t := time.NewTimer(l.opts.MaxQueueTime)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.acquired(), nil
+	case <-timeout:
+		metricRejected.WithLabelValues("queue_timeout").Inc()
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		metricRejected.WithLabelValues("canceled").Inc()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *limits) acquired() func() {
+	metricInFlight.Inc()
+	return func() {
+		metricInFlight.Dec()
+		<-l.slots
+	}
+}
+
+// withTimeout applies Options.Timeout to ctx.
+func (l *Limiter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l == nil {
+		return ctx, func() {}
+	}
+	// Like the other options, the timeout only applies to limited requests.
+	if lim := l.limits.Load(); lim.slots != nil && lim.opts.Timeout > 0 {
+		return context.WithTimeout(ctx, lim.opts.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// Middleware wraps next so it only serves requests with a slot. Rejected
+// requests get 429 Too Many Requests, with a Retry-After header.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range l.limits.Load().opts.Exempt {
+			if strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ctx, cancel := l.withTimeout(r.Context())
+		defer cancel()
+
+		release, err := l.Acquire(ctx)
+		if err != nil {
+			if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout) || errors.Is(err, context.DeadlineExceeded) {
+				w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+			}
+			// The client is gone otherwise.
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// retryAfter is the number of seconds rejected clients are asked to wait.
+func (l *Limiter) retryAfter() int {
+	return max(1, int(l.limits.Load().opts.MaxQueueTime.Round(time.Second)/time.Second))
+}
+
+// UnaryServerInterceptor only serves gRPC calls with a slot. Rejected calls
+// fail with codes.ResourceExhausted.
+func (l *Limiter) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, cancel := l.withTimeout(ctx)
+	defer cancel()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	defer release()
+
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streams, which
+// hold their slot until they are done.
+func (l *Limiter) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := l.withTimeout(ss.Context())
+	defer cancel()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer release()
+
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+func grpcError(err error) error {
+	if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.FromContextError(err).Err()
+}
+
+// contextStream is a grpc.ServerStream with the context of the timeout.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
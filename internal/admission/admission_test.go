@@ -0,0 +1,187 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAcquire(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1, MaxQueue: 1})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second request waits for the first one.
+	acquired := make(chan func())
+	go func() {
+		release, err := l.Acquire(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	for len(l.limits.Load().queue) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The third one doesn't fit into the queue.
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+
+	release()
+	(<-acquired)()
+
+	// All slots are free again.
+	release, err = l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1, MaxQueue: 1, MaxQueueTime: 10 * time.Millisecond})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("got %v, want ErrQueueTimeout", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(l.limits.Load().queue) != 0 {
+		t.Fatalf("%d requests left in the queue", len(l.limits.Load().queue))
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := New(Options{})
+	if l != nil {
+		t.Fatal("got a limiter without MaxConcurrent")
+	}
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	if l.Middleware(h) == nil {
+		t.Fatal("got no handler")
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	l := NewAdjustable(Options{})
+	ctx := context.Background()
+
+	// Without MaxConcurrent, requests are not limited.
+	for range 3 {
+		release, err := l.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+	}
+
+	l.SetOptions(Options{MaxConcurrent: 1})
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+
+	// Setting the same options keeps the slots.
+	l.SetOptions(Options{MaxConcurrent: 1})
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v after setting the same options, want ErrQueueFull", err)
+	}
+
+	// The request admitted before the change keeps its slot, which it
+	// releases into the previous slots.
+	l.SetOptions(Options{MaxConcurrent: 1, Timeout: time.Second})
+	release2, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	release2()
+	if n := len(l.limits.Load().slots); n != 0 {
+		t.Fatalf("%d slots taken, want 0", n)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1, Timeout: time.Second, Exempt: []string{"/healthz"}})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok && r.URL.Path != "/healthz" {
+			t.Error("request has no deadline")
+		}
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := serve("/search"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	w := serve("/search")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("got status %d and Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve("/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d for an exempt path, want 200", w.Code)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1})
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	resp, err := l.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	_, err = l.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted", err)
+	}
+}
@@ -45,7 +45,7 @@ func (a *tarArchive) Next() (*File, error) {
 
 		return &File{
 			ReadCloser: io.NopCloser(a.tr),
-			Name:       hdr.Name,
+			Name:       normalizeName(hdr.Name),
 			Size:       hdr.Size,
 			ModTime:    hdr.ModTime,
 		}, nil
@@ -72,7 +72,7 @@ func (a *zipArchive) Next() (*File, error) {
 
 	return &File{
 		ReadCloser: r,
-		Name:       f.Name,
+		Name:       normalizeName(f.Name),
 		Size:       int64(f.UncompressedSize64),
 		ModTime:    f.Modified,
 	}, nil
@@ -97,10 +97,11 @@ func newZipArchive(r io.Reader, closer io.Closer) (*zipArchive, error) {
 		return nil, err
 	}
 
-	// Filter out non files
+	// Filter out non files. Directories of archives created on Windows may
+	// end in a backslash instead of a slash.
 	files := zr.File[:0]
 	for _, f := range zr.File {
-		if f.Mode().IsRegular() {
+		if f.Mode().IsRegular() && !strings.HasSuffix(f.Name, `\`) {
 			files = append(files, f)
 		}
 	}
@@ -111,6 +112,12 @@ func newZipArchive(r io.Reader, closer io.Closer) (*zipArchive, error) {
 	}, nil
 }
 
+// normalizeName returns the name of an archive entry with slashes as path
+// separators. Archives created on Windows may use backslashes.
+func normalizeName(name string) string {
+	return strings.ReplaceAll(name, `\`, "/")
+}
+
 func detectContentType(r io.Reader) (string, io.Reader, error) {
 	var buf [512]byte
 	n, err := io.ReadFull(r, buf[:])
@@ -258,3 +258,40 @@ func testLatestCommitDate(t *testing.T, format string) {
 	require.Len(t, repos, 1)
 	require.True(t, repos[0].LatestCommitDate.Equal(modTime))
 }
+
+func TestBackslashPaths(t *testing.T) {
+	for _, format := range []string{"tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			archive, err := os.CreateTemp("", "TestBackslashPaths")
+			require.NoError(t, err)
+			defer os.Remove(archive.Name())
+
+			err = writeArchive(archive, format, map[string]string{
+				`repo-main\src\main.go`: "package main\r\n",
+			})
+			require.NoError(t, err)
+			archive.Close()
+
+			indexDir := t.TempDir()
+			opts := Options{
+				Archive: archive.Name(),
+				Name:    "repo",
+				Branch:  "master",
+				Commit:  "cccccccccccccccccccccccccccccccccccccccc",
+				Strip:   1,
+			}
+			require.NoError(t, Index(opts, index.Options{IndexDir: indexDir}))
+
+			ss, err := shards.NewDirectorySearcher(indexDir)
+			require.NoError(t, err)
+			defer ss.Close()
+
+			q, err := query.Parse("file:^src/main.go$")
+			require.NoError(t, err)
+			result, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+			require.NoError(t, err)
+			require.Len(t, result.Files, 1)
+			require.Equal(t, "src/main.go", result.Files[0].FileName)
+		})
+	}
+}
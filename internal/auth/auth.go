@@ -0,0 +1,206 @@
+// Package auth authenticates requests to zoekt-webserver. It supports static
+// basic-auth users and OpenID Connect logins. The identity of an
+// authenticated request is stored in its context, where authorization checks
+// and audit logs can read it with FromContext.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	sglog "github.com/sourcegraph/log"
+)
+
+// Identity is the authenticated user of a request.
+type Identity struct {
+	// Subject identifies the user. It is the user name for basic auth and
+	// the "sub" claim for OpenID Connect.
+	Subject string
+
+	// Email is the email address of the user, if known.
+	Email string
+
+	// Provider is the method used to authenticate the user, "basic" or
+	// "oidc".
+	Provider string
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx which carries id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored in ctx by WithIdentity.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// credentialCacheSize is the number of credentials an authenticator caches
+// at most.
+const credentialCacheSize = 1024
+
+// credentialCache caches the identities of verified credentials for a while,
+// so they aren't verified again on every request. Credentials are keyed by
+// their SHA-256 digest, so the cache doesn't hold them. Once it holds
+// credentialCacheSize credentials, the one expiring first is dropped for a
+// new one.
+type credentialCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cachedIdentity
+}
+
+type cachedIdentity struct {
+	id      *Identity
+	expires time.Time
+}
+
+func newCredentialCache(ttl time.Duration) *credentialCache {
+	return &credentialCache{ttl: ttl, entries: map[[sha256.Size]byte]cachedIdentity{}}
+}
+
+// get returns the identity cached for credentials at now.
+func (c *credentialCache) get(credentials string, now time.Time) (*Identity, bool) {
+	key := sha256.Sum256([]byte(credentials))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return nil, false
+	}
+	return e.id, true
+}
+
+// add caches the identity id of credentials verified at now.
+func (c *credentialCache) add(credentials string, id *Identity, now time.Time) {
+	key := sha256.Sum256([]byte(credentials))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= credentialCacheSize {
+		var oldest [sha256.Size]byte
+		var oldestExpires time.Time
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			} else if oldestExpires.IsZero() || e.expires.Before(oldestExpires) {
+				oldest, oldestExpires = k, e.expires
+			}
+		}
+		if len(c.entries) >= credentialCacheSize {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = cachedIdentity{id: id, expires: now.Add(c.ttl)}
+}
+
+// errUnauthenticated is returned by authenticators which found credentials
+// in a request that are not valid.
+var errUnauthenticated = errors.New("invalid credentials")
+
+// Authenticator authenticates HTTP requests.
+type Authenticator interface {
+	// Authenticate returns the identity of the user making r. It returns a
+	// nil identity and a nil error if r does not carry credentials for this
+	// authenticator.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Options configures Middleware.
+type Options struct {
+	// Basic authenticates requests with static users. It is optional.
+	Basic *BasicAuth
+
+	// OIDC authenticates requests with OpenID Connect sessions and bearer
+	// tokens. If set, its login and callback handlers are served below
+	// /auth/ and unauthenticated browsers are redirected to the login page.
+	OIDC *OIDC
+
+	// Public lists the paths which are served without authentication.
+	Public []string
+
+	Logger sglog.Logger
+}
+
+// Enabled reports whether any authentication method is configured.
+func (o Options) Enabled() bool {
+	return o.Basic != nil || o.OIDC != nil
+}
+
+// Middleware returns a function which wraps handlers so they only serve
+// authenticated requests. Handlers see the identity of the user in the
+// context of the request. If no authentication method is configured, handlers
+// are returned unchanged.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	if !opts.Enabled() {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	var authenticators []Authenticator
+	if opts.Basic != nil {
+		authenticators = append(authenticators, opts.Basic)
+	}
+	if opts.OIDC != nil {
+		authenticators = append(authenticators, opts.OIDC)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range opts.Public {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if opts.OIDC != nil && strings.HasPrefix(r.URL.Path, oidcPathPrefix) {
+				opts.OIDC.ServeHTTP(w, r)
+				return
+			}
+
+			// A request which fails one method may still carry valid
+			// credentials for another, eg. a session cookie next to stale
+			// basic auth credentials, so every method is tried.
+			for _, a := range authenticators {
+				id, err := a.Authenticate(r)
+				if err != nil {
+					opts.Logger.Warn("authentication failed",
+						sglog.String("path", r.URL.Path),
+						sglog.String("remoteAddr", r.RemoteAddr),
+						sglog.Error(err))
+					continue
+				}
+				if id != nil {
+					next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+					return
+				}
+			}
+
+			if opts.OIDC != nil && isBrowser(r) {
+				http.Redirect(w, r, oidcLoginPath+"?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			if opts.Basic != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="zoekt", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
+
+// isBrowser reports whether r is a page load by a browser, as opposed to an
+// API or gRPC call.
+func isBrowser(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		!strings.HasPrefix(r.URL.Path, "/api/") &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sglog "github.com/sourcegraph/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// whoami serves the subject of the identity of the request.
+var whoami = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	id, ok := FromContext(r.Context())
+	if !ok {
+		http.Error(w, "no identity", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(id.Provider + ":" + id.Subject))
+})
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := parseHtpasswd(strings.NewReader("# users\n\nalice:" + string(hash) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := NewBasicAuth(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Middleware(Options{Basic: basic, Public: []string{"/healthz"}, Logger: sglog.NoOp()})(whoami)
+
+	req := httptest.NewRequest("GET", "/api/search", nil)
+	w := serve(h, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("no credentials: got %d, headers %v", w.Code, w.Header())
+	}
+
+	for _, password := range []string{"s3cret", "s3cret"} {
+		req = httptest.NewRequest("GET", "/api/search", nil)
+		req.SetBasicAuth("alice", password)
+		if w := serve(h, req); w.Code != http.StatusOK || w.Body.String() != "basic:alice" {
+			t.Fatalf("valid credentials: got %d %q", w.Code, w.Body.String())
+		}
+	}
+
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"bob", "s3cret"}} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(creds[0], creds[1])
+		if w := serve(h, req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%v: got %d, want 401", creds, w.Code)
+		}
+	}
+
+	// Public paths are served without credentials or identity.
+	public := Middleware(Options{Basic: basic, Public: []string{"/healthz"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if w := serve(public, httptest.NewRequest("GET", "/healthz", nil)); w.Code != http.StatusOK {
+		t.Fatalf("public path: got %d", w.Code)
+	}
+
+	if _, err := parseHtpasswd(strings.NewReader("no-colon\n")); err == nil {
+		t.Fatal("expected error for line without hash")
+	}
+	if _, err := NewBasicAuth(map[string]string{"bob": "plaintext"}); err == nil {
+		t.Fatal("expected error for password which is not a bcrypt hash")
+	}
+}
+
+func TestBasicAuthSetUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := NewBasicAuth(map[string]string{"alice": string(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Middleware(Options{Basic: basic, Logger: sglog.NoOp()})(whoami)
+	code := func(user string) int {
+		req := httptest.NewRequest("GET", "/api/search", nil)
+		req.SetBasicAuth(user, "s3cret")
+		return serve(h, req).Code
+	}
+	if got := code("alice"); got != http.StatusOK {
+		t.Fatalf("alice: got %d, want 200", got)
+	}
+
+	// The cached credentials of alice don't outlive her removal.
+	bob, err := NewBasicAuth(map[string]string{"bob": string(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic.SetUsers(bob)
+	if got := code("alice"); got != http.StatusUnauthorized {
+		t.Fatalf("alice after her removal: got %d, want 401", got)
+	}
+	if got := code("bob"); got != http.StatusOK {
+		t.Fatalf("bob: got %d, want 200", got)
+	}
+}
+
+// fakeProvider is a minimal OpenID provider which logs in everyone as alice.
+func fakeProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			UserinfoEndpoint:      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=the-code&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "the-code" {
+			http.Error(w, "bad code", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"the-token","token_type":"Bearer"}`))
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer the-token" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"sub":"alice","email":"alice@example.com"}`))
+	})
+	return srv
+}
+
+func TestOIDC(t *testing.T) {
+	provider := fakeProvider(t)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	o, err := NewOIDC(context.Background(), OIDCConfig{
+		Issuer:       provider.URL,
+		ClientID:     "zoekt",
+		ClientSecret: "secret",
+		RedirectURL:  srv.URL + oidcCallbackPath,
+		SessionKey:   []byte("key"),
+		Logger:       sglog.NoOp(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/", Middleware(Options{OIDC: o, Logger: sglog.NoOp()})(whoami))
+
+	// A browser is sent through the login flow and back to the page it
+	// asked for.
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	req, _ := http.NewRequest("GET", srv.URL+"/search?q=foo", nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.RequestURI() != "/search?q=foo" {
+		t.Fatalf("login flow ended with %d at %s", resp.StatusCode, resp.Request.URL)
+	}
+
+	// The session cookie authenticates further requests.
+	resp, err = client.Get(srv.URL + "/api/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "oidc:alice" {
+		t.Fatalf("with session: got %d %q", resp.StatusCode, body)
+	}
+
+	// API clients without credentials are rejected rather than redirected.
+	resp, err = http.Get(srv.URL + "/api/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without credentials: got %d, want 401", resp.StatusCode)
+	}
+
+	// API clients can authenticate with an access token.
+	for _, token := range []string{"the-token", "the-token"} {
+		req := httptest.NewRequest("GET", "/api/search", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if w := serve(mux, req); w.Body.String() != "oidc:alice" {
+			t.Fatalf("bearer token: got %d %q", w.Code, w.Body.String())
+		}
+	}
+	req = httptest.NewRequest("GET", "/api/search", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	if w := serve(mux, req); w.Code != http.StatusUnauthorized {
+		t.Fatalf("bad bearer token: got %d, want 401", w.Code)
+	}
+
+	// A tampered session cookie is rejected.
+	req = httptest.NewRequest("GET", "/api/search", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: o.sign([]byte(`{"sub":"alice","exp":1}`)) + "x"})
+	if w := serve(mux, req); w.Code != http.StatusUnauthorized {
+		t.Fatalf("tampered session: got %d, want 401", w.Code)
+	}
+}
+
+func TestMiddlewareTriesEveryMethod(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := NewBasicAuth(map[string]string{"bob": string(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewOIDC(context.Background(), OIDCConfig{
+		Issuer:      fakeProvider(t).URL,
+		ClientID:    "zoekt",
+		RedirectURL: "http://zoekt" + oidcCallbackPath,
+		SessionKey:  []byte("key"),
+		Logger:      sglog.NoOp(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Middleware(Options{Basic: basic, OIDC: o, Logger: sglog.NoOp()})(whoami)
+
+	// Wrong basic auth credentials don't hide a valid session.
+	payload, err := json.Marshal(session{Subject: "alice", Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/search", nil)
+	req.SetBasicAuth("bob", "wrong")
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: o.sign(payload)})
+	if w := serve(h, req); w.Code != http.StatusOK || w.Body.String() != "oidc:alice" {
+		t.Fatalf("wrong basic auth with a session: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	now := time.Now()
+	c := newCredentialCache(time.Minute)
+	alice := &Identity{Subject: "alice"}
+	c.add("alice", alice, now)
+	if id, ok := c.get("alice", now.Add(time.Second)); !ok || id != alice {
+		t.Fatalf("got %v %v, want alice", id, ok)
+	}
+	if _, ok := c.get("alice", now.Add(time.Minute)); ok {
+		t.Fatal("got expired credentials")
+	}
+
+	// A full cache drops the credentials expiring first.
+	for i := range credentialCacheSize + 10 {
+		c.add(strconv.Itoa(i), &Identity{Subject: strconv.Itoa(i)}, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if n := len(c.entries); n != credentialCacheSize {
+		t.Fatalf("got %d cached credentials, want %d", n, credentialCacheSize)
+	}
+	if _, ok := c.get("alice", now); ok {
+		t.Error("the oldest credentials are still cached")
+	}
+	if _, ok := c.get(strconv.Itoa(credentialCacheSize+9), now); !ok {
+		t.Error("the newest credentials are not cached")
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	for target, want := range map[string]string{
+		"/search?q=a":          "/search?q=a",
+		"":                     "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+	} {
+		if got := safeRedirect(target); got != want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
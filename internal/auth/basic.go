@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth authenticates requests with HTTP basic auth against a static set
+// of users with bcrypt hashed passwords.
+type BasicAuth struct {
+	mu    sync.Mutex
+	users map[string][]byte
+
+	// verified caches the credentials which passed bcrypt, which is
+	// deliberately slow, so API clients don't pay for it on every request.
+	verified *credentialCache
+}
+
+// basicCacheTTL is how long credentials which passed bcrypt are cached.
+const basicCacheTTL = 5 * time.Minute
+
+var _ Authenticator = &BasicAuth{}
+
+// NewBasicAuth returns a BasicAuth for users, which maps user names to bcrypt
+// password hashes.
+func NewBasicAuth(users map[string]string) (*BasicAuth, error) {
+	b := &BasicAuth{
+		users:    make(map[string][]byte, len(users)),
+		verified: newCredentialCache(basicCacheTTL),
+	}
+	for user, hash := range users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("user %q: %w", user, err)
+		}
+		b.users[user] = []byte(hash)
+	}
+	return b, nil
+}
+
+// LoadBasicAuth reads users from an htpasswd style file. Each line contains a
+// user name and a bcrypt password hash separated by a colon, as generated by
+// "htpasswd -B". Empty lines and lines starting with # are ignored.
+func LoadBasicAuth(path string) (*BasicAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users, err := parseHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewBasicAuth(users)
+}
+
+func parseHtpasswd(r io.Reader) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: want user:hash", n)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// SetUsers replaces the users of b with those of other, eg. after the
+// htpasswd file changed. The credentials verified with the previous users
+// are verified again. It is safe to call while b is in use.
+func (b *BasicAuth) SetUsers(other *BasicAuth) {
+	other.mu.Lock()
+	users := other.users
+	other.mu.Unlock()
+
+	b.mu.Lock()
+	b.users = users
+	b.verified = newCredentialCache(basicCacheTTL)
+	b.mu.Unlock()
+}
+
+func (b *BasicAuth) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	credentials := user + ":" + password
+	now := time.Now()
+	b.mu.Lock()
+	hash, known := b.users[user]
+	verified := b.verified
+	b.mu.Unlock()
+
+	if !known {
+		return nil, fmt.Errorf("basic auth: unknown user %q: %w", user, errUnauthenticated)
+	}
+	if id, ok := verified.get(credentials, now); ok {
+		return id, nil
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("basic auth: user %q: %w", user, errUnauthenticated)
+	}
+	// If the users changed meanwhile, hash may be stale, so the credentials
+	// are only cached for the users they were verified with.
+	id := &Identity{Subject: user, Provider: "basic"}
+	verified.add(credentials, id, now)
+	return id, nil
+}
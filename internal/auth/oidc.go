@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	sglog "github.com/sourcegraph/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcPathPrefix   = "/auth/"
+	oidcLoginPath    = "/auth/login"
+	oidcCallbackPath = "/auth/callback"
+	oidcLogoutPath   = "/auth/logout"
+
+	sessionCookie = "zoekt_session"
+	stateCookie   = "zoekt_oidc_state"
+
+	// bearerCacheTTL is how long the identity of a bearer token is cached
+	// before the token is checked with the provider again.
+	bearerCacheTTL = 5 * time.Minute
+)
+
+// OIDCConfig configures an OIDC authenticator.
+type OIDCConfig struct {
+	// Issuer is the URL of the OpenID provider. Its configuration is read
+	// from Issuer/.well-known/openid-configuration.
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the external URL of /auth/callback on this server.
+	RedirectURL string
+
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+
+	// SessionKey signs session cookies. Sessions are invalidated when it
+	// changes.
+	SessionKey []byte
+
+	// SessionTTL is how long a login is valid. It defaults to 24 hours.
+	SessionTTL time.Duration
+
+	Logger sglog.Logger
+}
+
+// OIDC authenticates users with the OpenID Connect authorization code flow.
+// Browsers are authenticated with a signed session cookie set after login. API
+// and gRPC clients can instead send an access token of the provider as a
+// bearer token, which is verified with the userinfo endpoint of the provider.
+type OIDC struct {
+	cfg         OIDCConfig
+	oauth       oauth2.Config
+	userinfoURL string
+
+	bearer *credentialCache
+}
+
+var _ Authenticator = &OIDC{}
+
+// providerMetadata is the subset of the OpenID provider configuration we use.
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// NewOIDC discovers the configuration of the provider at cfg.Issuer and
+// returns an authenticator for it.
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+	if len(cfg.SessionKey) == 0 {
+		return nil, errors.New("oidc: session key is required")
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 24 * time.Hour
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery: %s returned %s", wellKnown, resp.Status)
+	}
+
+	var md providerMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", md.Issuer, cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.UserinfoEndpoint == "" {
+		return nil, errors.New("oidc: discovery: provider does not advertise authorization, token and userinfo endpoints")
+	}
+
+	return &OIDC{
+		cfg: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       append([]string{"openid", "email"}, cfg.Scopes...),
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  md.AuthorizationEndpoint,
+				TokenURL: md.TokenEndpoint,
+			},
+		},
+		userinfoURL: md.UserinfoEndpoint,
+		bearer:      newCredentialCache(bearerCacheTTL),
+	}, nil
+}
+
+// session is the payload of the session cookie.
+type session struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+func (o *OIDC) Authenticate(r *http.Request) (*Identity, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return o.authenticateBearer(r.Context(), token)
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	payload, ok := o.verify(c.Value)
+	if !ok {
+		return nil, fmt.Errorf("oidc: bad session cookie: %w", errUnauthenticated)
+	}
+	var s session
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, fmt.Errorf("oidc: bad session cookie: %w", errUnauthenticated)
+	}
+	if time.Now().Unix() > s.Expires {
+		// An expired session is treated like a missing one, so browsers
+		// are sent to the login page again.
+		return nil, nil
+	}
+	return &Identity{Subject: s.Subject, Email: s.Email, Provider: "oidc"}, nil
+}
+
+func (o *OIDC) authenticateBearer(ctx context.Context, token string) (*Identity, error) {
+	now := time.Now()
+	if id, ok := o.bearer.get(token, now); ok {
+		return id, nil
+	}
+
+	id, err := o.userinfo(ctx, &oauth2.Token{AccessToken: token, TokenType: "Bearer"})
+	if err != nil {
+		return nil, err
+	}
+	o.bearer.add(token, id, now)
+	return id, nil
+}
+
+// userinfo returns the identity of the owner of token.
+func (o *OIDC) userinfo(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.userinfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	token.SetAuthHeader(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: userinfo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: userinfo returned %s: %w", resp.Status, errUnauthenticated)
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: userinfo: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("oidc: userinfo without subject: %w", errUnauthenticated)
+	}
+	return &Identity{Subject: claims.Subject, Email: claims.Email, Provider: "oidc"}, nil
+}
+
+// ServeHTTP serves the login, callback and logout pages.
+func (o *OIDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case oidcLoginPath:
+		o.serveLogin(w, r)
+	case oidcCallbackPath:
+		o.serveCallback(w, r)
+	case oidcLogoutPath:
+		http.SetCookie(w, o.cookie(r, sessionCookie, "", -1))
+		fmt.Fprintln(w, "logged out")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (o *OIDC) serveLogin(w http.ResponseWriter, r *http.Request) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b[:])
+
+	// The state cookie remembers where to send the user after login. It is
+	// signed so the redirect can't be changed.
+	value := o.sign([]byte(state + "\n" + safeRedirect(r.URL.Query().Get("redirect"))))
+	http.SetCookie(w, o.cookie(r, stateCookie, value, int((10*time.Minute).Seconds())))
+	http.Redirect(w, r, o.oauth.AuthCodeURL(state), http.StatusFound)
+}
+
+func (o *OIDC) serveCallback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "missing login state", http.StatusBadRequest)
+		return
+	}
+	payload, ok := o.verify(c.Value)
+	if !ok {
+		http.Error(w, "bad login state", http.StatusBadRequest)
+		return
+	}
+	state, redirect, _ := strings.Cut(string(payload), "\n")
+	if r.URL.Query().Get("state") != state {
+		http.Error(w, "login state mismatch", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := o.oauth.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		o.cfg.Logger.Warn("oidc code exchange failed", sglog.Error(err))
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+	id, err := o.userinfo(r.Context(), token)
+	if err != nil {
+		o.cfg.Logger.Warn("oidc userinfo failed", sglog.Error(err))
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	sessionJSON, err := json.Marshal(session{
+		Subject: id.Subject,
+		Email:   id.Email,
+		Expires: time.Now().Add(o.cfg.SessionTTL).Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o.cfg.Logger.Info("login", sglog.String("subject", id.Subject), sglog.String("email", id.Email))
+
+	http.SetCookie(w, o.cookie(r, stateCookie, "", -1))
+	http.SetCookie(w, o.cookie(r, sessionCookie, o.sign(sessionJSON), int(o.cfg.SessionTTL.Seconds())))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (o *OIDC) cookie(r *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(o.cfg.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sign returns payload and its HMAC, encoded for use in a cookie.
+func (o *OIDC) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, o.cfg.SessionKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the payload of a value created by sign if its HMAC is valid.
+func (o *OIDC) verify(value string) ([]byte, bool) {
+	p, s, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, o.cfg.SessionKey)
+	mac.Write(payload)
+	return payload, hmac.Equal(sig, mac.Sum(nil))
+}
+
+// safeRedirect returns target if it is a path on this server, and "/"
+// otherwise, so the login page can't be used to redirect to other sites.
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
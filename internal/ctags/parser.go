@@ -25,6 +25,18 @@ import (
 
 type Entry = goctags.Entry
 
+// Symbol is a symbol found by TreeSitterParser. Unlike an Entry, it has the
+// exact byte range of its name.
+type Symbol struct {
+	Name       string
+	Kind       string
+	Parent     string
+	ParentKind string
+
+	// Start and End are the byte offsets of the name in the content.
+	Start, End uint32
+}
+
 // CTagsParser wraps go-ctags and delegates to the right process (like universal-ctags or scip-ctags).
 // It is only safe for single-threaded use. This wrapper also enforces a timeout on parsing a single
 // document, which is important since documents can occasionally hang universal-ctags.
@@ -28,6 +28,11 @@ const (
 	NoCTags
 	UniversalCTags
 	ScipCTags
+
+	// TreeSitterCTags finds symbols with the tree-sitter grammars built into
+	// zoekt instead of a ctags binary. Languages without a grammar use
+	// universal-ctags.
+	TreeSitterCTags
 )
 
 const debug = false
@@ -44,6 +49,8 @@ func ParserToString(parser CTagsParserType) string {
 		return "universal"
 	case ScipCTags:
 		return "scip"
+	case TreeSitterCTags:
+		return "tree-sitter"
 	default:
 		panic("Reached impossible CTagsParserType state")
 	}
@@ -57,6 +64,8 @@ func StringToParser(str string) CTagsParserType {
 		return UniversalCTags
 	case "scip":
 		return ScipCTags
+	case "tree-sitter":
+		return TreeSitterCTags
 	default:
 		return UniversalCTags
 	}
@@ -73,9 +82,13 @@ func NewParserBinMap(
 	validBins := make(map[CTagsParserType]string)
 	requiredBins := map[CTagsParserType]string{UniversalCTags: ctagsPath}
 	for _, parserType := range languageMap {
-		if parserType == ScipCTags {
+		switch parserType {
+		case ScipCTags:
 			requiredBins[ScipCTags] = scipCTagsPath
-			break
+		case TreeSitterCTags:
+			if !TreeSitterSupported() && cTagsMustSucceed {
+				return nil, fmt.Errorf("ctags.NewParserBinMap: the tree-sitter parser type needs a build with cgo and the treesitter build tag")
+			}
 		}
 	}
 
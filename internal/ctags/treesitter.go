@@ -0,0 +1,294 @@
+//go:build treesitter && cgo
+
+package ctags
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/cpp"
+	"github.com/smacker/go-tree-sitter/csharp"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/java"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/ruby"
+	"github.com/smacker/go-tree-sitter/rust"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+// treeSitterGrammar is the grammar of a language and the query which finds
+// its symbols. In the query, @name captures the name of a symbol, and
+// @definition.KIND the whole definition, which is the scope of the symbols
+// inside it. @scope.KIND is a scope which isn't a symbol itself, like a Rust
+// impl block. If several patterns match the same name, the first one wins,
+// so specific patterns come before general ones.
+type treeSitterGrammar struct {
+	lang  func() *sitter.Language
+	query string
+}
+
+const cQuery = `
+(function_definition declarator: (function_declarator declarator: (identifier) @name)) @definition.function
+(function_definition declarator: (pointer_declarator declarator: (function_declarator declarator: (identifier) @name))) @definition.function
+(struct_specifier name: (type_identifier) @name body: (_)) @definition.struct
+(union_specifier name: (type_identifier) @name body: (_)) @definition.union
+(enum_specifier name: (type_identifier) @name body: (_)) @definition.enum
+(enumerator name: (identifier) @name) @definition.enumerator
+(type_definition declarator: (type_identifier) @name) @definition.typedef
+(field_declaration declarator: (field_identifier) @name) @definition.member
+(preproc_def name: (identifier) @name) @definition.define
+(preproc_function_def name: (identifier) @name) @definition.define
+(translation_unit (declaration declarator: (init_declarator declarator: (identifier) @name)) @definition.variable)
+`
+
+const javaScriptQuery = `
+(function_declaration name: (identifier) @name) @definition.function
+(generator_function_declaration name: (identifier) @name) @definition.function
+(method_definition name: (property_identifier) @name) @definition.method
+(program (lexical_declaration (variable_declarator name: (identifier) @name value: [(arrow_function) (function_expression)]) @definition.function))
+(program (export_statement (lexical_declaration (variable_declarator name: (identifier) @name value: [(arrow_function) (function_expression)]) @definition.function)))
+(program (lexical_declaration (variable_declarator name: (identifier) @name) @definition.variable))
+(program (export_statement (lexical_declaration (variable_declarator name: (identifier) @name) @definition.variable)))
+(program (variable_declaration (variable_declarator name: (identifier) @name) @definition.variable))
+`
+
+const typeScriptQuery = javaScriptQuery + `
+(class_declaration name: (type_identifier) @name) @definition.class
+(abstract_class_declaration name: (type_identifier) @name) @definition.class
+(interface_declaration name: (type_identifier) @name) @definition.interface
+(type_alias_declaration name: (type_identifier) @name) @definition.typealias
+(enum_declaration name: (identifier) @name) @definition.enum
+(method_signature name: (property_identifier) @name) @definition.method
+(abstract_method_signature name: (property_identifier) @name) @definition.method
+(public_field_definition name: (property_identifier) @name) @definition.field
+(property_signature name: (property_identifier) @name) @definition.field
+(internal_module name: (identifier) @name) @definition.namespace
+`
+
+// treeSitterGrammars are the grammars by the normalized language names of
+// LanguageMap.
+var treeSitterGrammars = map[string]treeSitterGrammar{
+	"c": {c.GetLanguage, cQuery},
+	"c++": {cpp.GetLanguage, cQuery + `
+(class_specifier name: (type_identifier) @name body: (_)) @definition.class
+(namespace_definition name: (namespace_identifier) @name) @definition.namespace
+(function_definition declarator: (function_declarator declarator: (field_identifier) @name)) @definition.method
+(function_definition declarator: (function_declarator declarator: (qualified_identifier name: (identifier) @name))) @definition.function
+(field_declaration declarator: (function_declarator declarator: (field_identifier) @name)) @definition.prototype
+(alias_declaration name: (type_identifier) @name) @definition.typealias
+`},
+	"c_sharp": {csharp.GetLanguage, `
+(class_declaration name: (identifier) @name) @definition.class
+(record_declaration name: (identifier) @name) @definition.class
+(interface_declaration name: (identifier) @name) @definition.interface
+(struct_declaration name: (identifier) @name) @definition.struct
+(enum_declaration name: (identifier) @name) @definition.enum
+(enum_member_declaration name: (identifier) @name) @definition.enumerator
+(method_declaration name: (identifier) @name) @definition.method
+(constructor_declaration name: (identifier) @name) @definition.method
+(property_declaration name: (identifier) @name) @definition.property
+(field_declaration (variable_declaration (variable_declarator (identifier) @name))) @definition.field
+(namespace_declaration name: (_) @name) @definition.namespace
+`},
+	"go": {golang.GetLanguage, `
+(package_clause (package_identifier) @name) @definition.package
+(function_declaration name: (identifier) @name) @definition.function
+(method_declaration name: (field_identifier) @name) @definition.method
+(type_spec name: (type_identifier) @name type: (struct_type)) @definition.struct
+(type_spec name: (type_identifier) @name type: (interface_type)) @definition.interface
+(type_spec name: (type_identifier) @name) @definition.type
+(type_alias name: (type_identifier) @name) @definition.typealias
+(field_declaration name: (field_identifier) @name) @definition.field
+(method_elem name: (field_identifier) @name) @definition.methodSpec
+(source_file (const_declaration (const_spec name: (identifier) @name) @definition.constant))
+(source_file (var_declaration (var_spec name: (identifier) @name) @definition.variable))
+`},
+	"java": {java.GetLanguage, `
+(class_declaration name: (identifier) @name) @definition.class
+(record_declaration name: (identifier) @name) @definition.class
+(interface_declaration name: (identifier) @name) @definition.interface
+(enum_declaration name: (identifier) @name) @definition.enum
+(enum_constant name: (identifier) @name) @definition.enumconstant
+(method_declaration name: (identifier) @name) @definition.method
+(constructor_declaration name: (identifier) @name) @definition.method
+(field_declaration declarator: (variable_declarator name: (identifier) @name)) @definition.field
+`},
+	"javascript": {javascript.GetLanguage, javaScriptQuery + `
+(class_declaration name: (identifier) @name) @definition.class
+`},
+	"python": {python.GetLanguage, `
+(class_definition name: (identifier) @name) @definition.class
+(function_definition name: (identifier) @name) @definition.function
+(module (expression_statement (assignment left: (identifier) @name) @definition.variable))
+`},
+	"ruby": {ruby.GetLanguage, `
+(class name: (_) @name) @definition.class
+(module name: (_) @name) @definition.module
+(singleton_method name: (_) @name) @definition.singletonmethod
+(method name: (_) @name) @definition.method
+(assignment left: (constant) @name) @definition.constant
+`},
+	"rust": {rust.GetLanguage, `
+(function_item name: (identifier) @name) @definition.function
+(function_signature_item name: (identifier) @name) @definition.method
+(struct_item name: (type_identifier) @name) @definition.struct
+(enum_item name: (type_identifier) @name) @definition.enum
+(enum_variant name: (identifier) @name) @definition.enumerator
+(union_item name: (type_identifier) @name) @definition.union
+(trait_item name: (type_identifier) @name) @definition.trait
+(type_item name: (type_identifier) @name) @definition.typealias
+(const_item name: (identifier) @name) @definition.constant
+(static_item name: (identifier) @name) @definition.variable
+(mod_item name: (identifier) @name) @definition.module
+(macro_definition name: (identifier) @name) @definition.macro
+(field_declaration name: (field_identifier) @name) @definition.field
+(impl_item type: (type_identifier) @name) @scope.implementation
+(impl_item type: (generic_type type: (type_identifier) @name)) @scope.implementation
+`},
+	"tsx":        {tsx.GetLanguage, typeScriptQuery},
+	"typescript": {typescript.GetLanguage, typeScriptQuery},
+}
+
+// methodScopes are the kinds of scopes which turn the functions defined in
+// them into methods.
+var methodScopes = map[string]bool{
+	"class":          true,
+	"struct":         true,
+	"interface":      true,
+	"trait":          true,
+	"implementation": true,
+}
+
+// TreeSitterParser finds symbols with the tree-sitter grammars of the most
+// common languages. It is only safe for single-threaded use.
+type TreeSitterParser struct {
+	parser  *sitter.Parser
+	queries map[string]*sitter.Query
+}
+
+// TreeSitterSupported returns true if zoekt was built with tree-sitter
+// support, which needs cgo and the treesitter build tag.
+func TreeSitterSupported() bool {
+	return true
+}
+
+func NewTreeSitterParser() *TreeSitterParser {
+	return &TreeSitterParser{parser: sitter.NewParser(), queries: map[string]*sitter.Query{}}
+}
+
+// treeSitterDefinition is a match of the query of a grammar.
+type treeSitterDefinition struct {
+	sym        Symbol
+	start, end uint32
+	pattern    uint16
+	scope      bool
+}
+
+// Parse returns the symbols of content, sorted by their position. language
+// is a language name as normalized for LanguageMap. ok is false if there is
+// no grammar for language.
+func (p *TreeSitterParser) Parse(language string, content []byte) (syms []Symbol, ok bool, err error) {
+	g, ok := treeSitterGrammars[language]
+	if !ok {
+		return nil, false, nil
+	}
+	q := p.queries[language]
+	if q == nil {
+		if q, err = sitter.NewQuery([]byte(g.query), g.lang()); err != nil {
+			return nil, true, fmt.Errorf("tree-sitter query for %s: %w", language, err)
+		}
+		p.queries[language] = q
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), parseTimeout)
+	defer cancel()
+	p.parser.SetLanguage(g.lang())
+	tree, err := p.parser.ParseCtx(ctx, nil, content)
+	if err != nil {
+		return nil, true, fmt.Errorf("tree-sitter: %w", err)
+	}
+	defer tree.Close()
+
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, tree.RootNode())
+
+	defs := map[[2]uint32]treeSitterDefinition{}
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		var name, node *sitter.Node
+		d := treeSitterDefinition{pattern: m.PatternIndex}
+		for _, c := range m.Captures {
+			capture := q.CaptureNameForId(c.Index)
+			if capture == "name" {
+				name = c.Node
+			} else if kind, ok := strings.CutPrefix(capture, "definition."); ok {
+				node, d.sym.Kind = c.Node, kind
+			} else if kind, ok := strings.CutPrefix(capture, "scope."); ok {
+				node, d.sym.Kind, d.scope = c.Node, kind, true
+			}
+		}
+		if name == nil || node == nil {
+			continue
+		}
+		key := [2]uint32{name.StartByte(), name.EndByte()}
+		if old, ok := defs[key]; ok && old.pattern <= d.pattern {
+			continue
+		}
+		d.sym.Name = string(content[key[0]:key[1]])
+		d.sym.Start, d.sym.End = key[0], key[1]
+		d.start, d.end = node.StartByte(), node.EndByte()
+		defs[key] = d
+	}
+
+	// Outer definitions come before the definitions inside them.
+	sorted := make([]treeSitterDefinition, 0, len(defs))
+	for _, d := range defs {
+		sorted = append(sorted, d)
+	}
+	slices.SortFunc(sorted, func(a, b treeSitterDefinition) int {
+		if a.start != b.start {
+			return int(a.start) - int(b.start)
+		}
+		if a.end != b.end {
+			return int(b.end) - int(a.end)
+		}
+		return int(a.sym.Start) - int(b.sym.Start)
+	})
+
+	var scopes []treeSitterDefinition
+	for _, d := range sorted {
+		for len(scopes) > 0 && scopes[len(scopes)-1].end < d.end {
+			scopes = scopes[:len(scopes)-1]
+		}
+		if len(scopes) > 0 {
+			parent := scopes[len(scopes)-1].sym
+			d.sym.Parent, d.sym.ParentKind = parent.Name, parent.Kind
+			if d.sym.Kind == "function" && methodScopes[parent.Kind] {
+				d.sym.Kind = "method"
+			}
+		}
+		scopes = append(scopes, d)
+		if !d.scope {
+			syms = append(syms, d.sym)
+		}
+	}
+	slices.SortFunc(syms, func(a, b Symbol) int { return int(a.Start) - int(b.Start) })
+	return syms, true, nil
+}
+
+func (p *TreeSitterParser) Close() {
+	for _, q := range p.queries {
+		q.Close()
+	}
+	p.parser.Close()
+}
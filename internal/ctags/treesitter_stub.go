@@ -0,0 +1,24 @@
+//go:build !treesitter || !cgo
+
+package ctags
+
+// TreeSitterParser finds no symbols in builds without tree-sitter support,
+// so all languages fall back to universal-ctags.
+type TreeSitterParser struct{}
+
+// TreeSitterSupported returns true if zoekt was built with tree-sitter
+// support, which needs cgo and the treesitter build tag.
+func TreeSitterSupported() bool {
+	return false
+}
+
+func NewTreeSitterParser() *TreeSitterParser {
+	return &TreeSitterParser{}
+}
+
+// Parse returns ok false, since there are no grammars.
+func (p *TreeSitterParser) Parse(language string, content []byte) (syms []Symbol, ok bool, err error) {
+	return nil, false, nil
+}
+
+func (p *TreeSitterParser) Close() {}
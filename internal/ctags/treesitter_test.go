@@ -0,0 +1,122 @@
+//go:build treesitter && cgo
+
+package ctags
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTreeSitterParser(t *testing.T) {
+	p := NewTreeSitterParser()
+	defer p.Close()
+
+	for _, tc := range []struct {
+		language string
+		content  string
+		want     []Symbol
+	}{
+		{
+			language: "go",
+			content: `package main
+
+type Server struct {
+	addr string
+}
+
+type Handler interface {
+	Serve()
+}
+
+func (s *Server) Serve() {}
+
+func main() {
+	var x = 1
+}
+`,
+			want: []Symbol{
+				{Name: "main", Kind: "package", Start: 8, End: 12},
+				{Name: "Server", Kind: "struct", Start: 19, End: 25},
+				{Name: "addr", Kind: "field", Parent: "Server", ParentKind: "struct", Start: 36, End: 40},
+				{Name: "Handler", Kind: "interface", Start: 56, End: 63},
+				{Name: "Serve", Kind: "methodSpec", Parent: "Handler", ParentKind: "interface", Start: 77, End: 82},
+				{Name: "Serve", Kind: "method", Start: 105, End: 110},
+				{Name: "main", Kind: "function", Start: 122, End: 126},
+			},
+		},
+		{
+			language: "python",
+			content: `LIMIT = 10
+
+class Parser:
+    def parse(self):
+        pass
+
+def main():
+    pass
+`,
+			want: []Symbol{
+				{Name: "LIMIT", Kind: "variable", Start: 0, End: 5},
+				{Name: "Parser", Kind: "class", Start: 18, End: 24},
+				{Name: "parse", Kind: "method", Parent: "Parser", ParentKind: "class", Start: 34, End: 39},
+				{Name: "main", Kind: "function", Start: 65, End: 69},
+			},
+		},
+		{
+			language: "typescript",
+			content: `interface Shape { area(): number }
+class Circle implements Shape {
+  area() { return 1 }
+}
+export const draw = () => {}
+`,
+			want: []Symbol{
+				{Name: "Shape", Kind: "interface", Start: 10, End: 15},
+				{Name: "area", Kind: "method", Parent: "Shape", ParentKind: "interface", Start: 18, End: 22},
+				{Name: "Circle", Kind: "class", Start: 41, End: 47},
+				{Name: "area", Kind: "method", Parent: "Circle", ParentKind: "class", Start: 69, End: 73},
+				{Name: "draw", Kind: "function", Start: 104, End: 108},
+			},
+		},
+		{
+			language: "rust",
+			content: `struct Point { x: i32 }
+impl Point {
+    fn new() -> Point { Point { x: 0 } }
+}
+`,
+			want: []Symbol{
+				{Name: "Point", Kind: "struct", Start: 7, End: 12},
+				{Name: "x", Kind: "field", Parent: "Point", ParentKind: "struct", Start: 15, End: 16},
+				{Name: "new", Kind: "method", Parent: "Point", ParentKind: "implementation", Start: 44, End: 47},
+			},
+		},
+	} {
+		t.Run(tc.language, func(t *testing.T) {
+			got, ok, err := p.Parse(tc.language, []byte(tc.content))
+			if err != nil || !ok {
+				t.Fatalf("got ok=%v, err=%v", ok, err)
+			}
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("mismatch (-want +got):\n%s", d)
+			}
+			for _, s := range got {
+				if tc.content[s.Start:s.End] != s.Name {
+					t.Errorf("range of %s holds %q", s.Name, tc.content[s.Start:s.End])
+				}
+			}
+		})
+	}
+
+	// All grammars and queries of the supported languages load.
+	for language := range treeSitterGrammars {
+		if _, ok, err := p.Parse(language, []byte("\n")); !ok || err != nil {
+			t.Errorf("%s: got ok=%v, err=%v", language, ok, err)
+		}
+	}
+
+	if _, ok, _ := p.Parse("haskell", []byte("main = pure ()\n")); ok {
+		t.Error("parsed a language without a grammar")
+	}
+}
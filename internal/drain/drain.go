@@ -0,0 +1,162 @@
+// Package drain lets zoekt-webserver finish the requests it is serving before
+// it exits. Once draining starts, health checks fail so load balancers stop
+// sending traffic, new searches are turned away with a retryable error, and
+// the server waits for the requests in flight to complete.
+package drain
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_drain_in_flight_requests",
+		Help: "The number of requests shutdown waits for.",
+	})
+	metricRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_drain_rejected_requests_total",
+		Help: "The number of requests rejected because the server is shutting down.",
+	})
+)
+
+// errDraining is the message of requests rejected while draining.
+const errDraining = "server is shutting down"
+
+// Options configures a Drainer.
+type Options struct {
+	// Health lists the paths of health checks, which fail with 503 Service
+	// Unavailable while draining.
+	Health []string
+
+	// Exempt lists path prefixes which are served while draining without
+	// being waited for, like metrics and debug pages.
+	Exempt []string
+}
+
+// Drainer tracks the requests in flight, so Drain can wait for them.
+type Drainer struct {
+	opts Options
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	// idle is closed once draining and no requests are in flight.
+	idle chan struct{}
+}
+
+// New returns a Drainer which serves requests until Drain is called.
+func New(opts Options) *Drainer {
+	return &Drainer{
+		opts: opts,
+		idle: make(chan struct{}),
+	}
+}
+
+// begin admits a request, which must call end once it is done. It returns
+// false if the server is draining.
+func (d *Drainer) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		metricRejected.Inc()
+		return false
+	}
+	d.inFlight++
+	metricInFlight.Inc()
+	return true
+}
+
+func (d *Drainer) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	metricInFlight.Dec()
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// Draining returns true once Drain was called.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Drain rejects new requests and waits until the requests in flight are
+// done. It returns the error of ctx if it is done first. Drain may be called
+// only once.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Middleware wraps next so Drain waits for its requests. Requests arriving
+// while draining get 503 Service Unavailable and are asked to close the
+// connection, so clients retry on another replica.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range d.opts.Health {
+			if r.URL.Path == p && d.Draining() {
+				http.Error(w, errDraining, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		for _, p := range d.opts.Exempt {
+			if strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if !d.begin() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, errDraining, http.StatusServiceUnavailable)
+			return
+		}
+		defer d.end()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor lets Drain wait for gRPC calls. Calls arriving while
+// draining fail with codes.Unavailable.
+func (d *Drainer) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !d.begin() {
+		return nil, status.Error(codes.Unavailable, errDraining)
+	}
+	defer d.end()
+
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streams.
+func (d *Drainer) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !d.begin() {
+		return status.Error(codes.Unavailable, errDraining)
+	}
+	defer d.end()
+
+	return handler(srv, ss)
+}
@@ -0,0 +1,110 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDrain(t *testing.T) {
+	d := New(Options{Health: []string{"/healthz"}, Exempt: []string{"/metrics"}})
+
+	// A search is in flight when the server starts draining.
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search" {
+			close(started)
+			<-finish
+		}
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := serve("/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d for /healthz before draining, want 200", w.Code)
+	}
+
+	inFlight := make(chan int)
+	go func() { inFlight <- serve("/search").Code }()
+	<-started
+
+	drained := make(chan error)
+	go func() { drained <- d.Drain(context.Background()) }()
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := serve("/search"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Connection") != "close" {
+		t.Fatalf("got status %d and Connection %q for a new search, want 503 and close", w.Code, w.Header().Get("Connection"))
+	}
+	if w := serve("/healthz"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d for /healthz while draining, want 503", w.Code)
+	}
+	if w := serve("/metrics"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d for an exempt path, want 200", w.Code)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned %v with a request in flight", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(finish)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Fatalf("got status %d for the search in flight, want 200", code)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	d := New(Options{})
+	if !d.begin() {
+		t.Fatal("request rejected before draining")
+	}
+	defer d.end()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	d := New(Options{})
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	streamHandler := func(srv any, ss grpc.ServerStream) error { return nil }
+
+	resp, err := d.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	if err := d.StreamServerInterceptor(nil, nil, &grpc.StreamServerInfo{}, streamHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want Unavailable", err)
+	}
+	if err := d.StreamServerInterceptor(nil, nil, &grpc.StreamServerInfo{}, streamHandler); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want Unavailable", err)
+	}
+}
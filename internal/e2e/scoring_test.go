@@ -451,16 +451,16 @@ func TestPython(t *testing.T) {
 			content:  examplePython,
 			query:    &query.Substring{Content: true, Pattern: "C1"},
 			language: "Python",
-			// 7000 (symbol) + 1000 (Python class) + 500 (word)
-			wantScore: 8500,
+			// 7000 (symbol) + 1000 (Python class) + 50 (exported) + 500 (word)
+			wantScore: 8550,
 		},
 		{
 			fileName: "example.py",
 			content:  examplePython,
 			query:    &query.Substring{Content: true, Pattern: "g"},
 			language: "Python",
-			// 7000 (symbol) + 800 (Python function) + 500 (word)
-			wantScore: 8300,
+			// 7000 (symbol) + 800 (Python function) + 50 (exported) + 500 (word)
+			wantScore: 8350,
 		},
 	}
 
@@ -476,8 +476,8 @@ func TestPython(t *testing.T) {
 		content:  examplePython,
 		query:    &query.Substring{Content: true, Pattern: "__init__"},
 		language: "Python",
-		// 7000 (symbol) + 800 (Python method) + 50 (partial word)
-		wantScore: 7850,
+		// 7000 (symbol) + 800 (Python method) + 50 (exported) + 50 (partial word)
+		wantScore: 7900,
 	}
 
 	checkScoring(t, scipOnlyCase, false, ctags.ScipCTags)
@@ -0,0 +1,251 @@
+// Package experiments toggles code paths of searches per request, for A/B
+// relevance experiments without redeploys.
+//
+// Clients enable experiment flags in the zoekt-experiments metadata of gRPC
+// requests, or the X-Zoekt-Experiments header of HTTP requests, separated by
+// commas, eg. "bm25,chunk_merge_adjacent". The Searcher enables the known
+// flags in SearchOptions.Experiments of the searches of the request, which the
+// code paths under experiment check, and echoes them in Stats.Experiments. Unknown flags are ignored, so clients can send flags
+// before all servers know them, and tell from the stats which did. Servers
+// can also enable flags for all searches with Searcher.SetDefaults.
+package experiments
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/grafana/regexp"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/grpc/propagator"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	// MetadataKey is the gRPC metadata key of the experiment flags.
+	MetadataKey = "zoekt-experiments"
+
+	// HeaderKey is the HTTP header of the experiment flags.
+	HeaderKey = "X-Zoekt-Experiments"
+)
+
+// The experiment flags which toggle code paths, see
+// zoekt.SearchOptions.HasExperiment.
+const (
+	// BM25LineLength makes BM25 compare the length of a matching line with
+	// the average line length of its file, instead of 100 bytes, when it
+	// scores the line.
+	BM25LineLength = "bm25_line_length"
+
+	// ChunkMergeAdjacent merges chunk matches whose context lines are
+	// adjacent, instead of only those whose context lines overlap.
+	ChunkMergeAdjacent = "chunk_merge_adjacent"
+)
+
+// Flags are the known experiment flags, by name. The flags which also change
+// other options of a search have a function doing so.
+var Flags = map[string]func(*zoekt.SearchOptions){
+	BM25LineLength:     nil,
+	ChunkMergeAdjacent: nil,
+
+	// bm25 scores files with BM25 instead of the default scoring.
+	"bm25": func(o *zoekt.SearchOptions) { o.UseBM25Scoring = true },
+
+	// chunk_matches returns chunks of matching lines instead of lines. Only
+	// clients which handle ChunkMatches should enable it.
+	"chunk_matches": func(o *zoekt.SearchOptions) { o.ChunkMatches = true },
+
+	// file_names_first ranks the files whose names match above the others.
+	"file_names_first": func(o *zoekt.SearchOptions) { o.FileNamesFirst = true },
+
+	// ranking_signals blends the ranking signals computed at index time into
+	// the scores.
+	"ranking_signals": func(o *zoekt.SearchOptions) {
+		if o.RankingSignalsWeight == 0 {
+			o.RankingSignalsWeight = 1
+		}
+	},
+}
+
+type contextKey struct{}
+
+// WithFlags returns a context which enables the known flags of names for the
+// searches with it.
+func WithFlags(ctx context.Context, names []string) context.Context {
+	var flags []string
+	for _, n := range names {
+		if _, ok := Flags[n]; ok && !slices.Contains(flags, n) {
+			flags = append(flags, n)
+		}
+	}
+	if len(flags) == 0 {
+		return ctx
+	}
+	slices.Sort(flags)
+	return context.WithValue(ctx, contextKey{}, flags)
+}
+
+// FromContext returns the sorted experiment flags enabled in ctx.
+func FromContext(ctx context.Context) []string {
+	flags, _ := ctx.Value(contextKey{}).([]string)
+	return flags
+}
+
+// parse returns the flags in the values of the metadata or header.
+func parse(values []string) []string {
+	var names []string
+	for _, v := range values {
+		for _, n := range strings.Split(v, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// Propagator propagates the experiment flags of searches across RPC calls,
+// eg. to peers.
+type Propagator struct{}
+
+var _ propagator.Propagator = Propagator{}
+
+func (Propagator) FromContext(ctx context.Context) metadata.MD {
+	flags := FromContext(ctx)
+	if len(flags) == 0 {
+		return metadata.MD{}
+	}
+	return metadata.Pairs(MetadataKey, strings.Join(flags, ","))
+}
+
+func (Propagator) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	return WithFlags(ctx, parse(md.Get(MetadataKey))), nil
+}
+
+// Middleware enables the experiment flags in the X-Zoekt-Experiments header
+// of HTTP requests.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if values := r.Header.Values(HeaderKey); len(values) > 0 {
+			r = r.WithContext(WithFlags(r.Context(), parse(values)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Searcher applies the experiment flags of the context of searches to their
+// options, and reports the flags in the stats of their results.
+type Searcher struct {
+	zoekt.Streamer
+
+	// defaults are the flags enabled for all searches, see SetDefaults.
+	defaults atomic.Pointer[[]string]
+}
+
+// SetDefaults enables the flags names for all searches, in addition to the
+// flags of their context. It returns an error, and keeps the previous
+// defaults, if a flag is unknown. It is safe to call while s is in use.
+func (s *Searcher) SetDefaults(names []string) error {
+	var flags []string
+	for _, n := range names {
+		if _, ok := Flags[n]; !ok {
+			return fmt.Errorf("unknown experiment flag %q", n)
+		}
+		if !slices.Contains(flags, n) {
+			flags = append(flags, n)
+		}
+	}
+	slices.Sort(flags)
+	s.defaults.Store(&flags)
+	return nil
+}
+
+// Flags returns the sorted flags enabled for the searches of ctx.
+func (s *Searcher) Flags(ctx context.Context) []string {
+	flags := FromContext(ctx)
+	defaults := s.defaults.Load()
+	if defaults == nil || len(*defaults) == 0 {
+		return flags
+	}
+	if len(flags) == 0 {
+		return *defaults
+	}
+	all := slices.Concat(flags, *defaults)
+	slices.Sort(all)
+	return slices.Compact(all)
+}
+
+// apply returns opts with the flags enabled.
+func apply(flags []string, opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	o := *opts
+	o.Experiments = flags
+	for _, f := range flags {
+		if set := Flags[f]; set != nil {
+			set(&o)
+		}
+	}
+	return &o
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	flags := s.Flags(ctx)
+	if len(flags) == 0 {
+		return s.Streamer.Search(ctx, q, opts)
+	}
+	sr, err := s.Streamer.Search(ctx, q, apply(flags, opts))
+	if sr != nil {
+		sr.Stats.Experiments = flags
+	}
+	return sr, err
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	flags := s.Flags(ctx)
+	if len(flags) == 0 {
+		return s.Streamer.StreamSearch(ctx, q, opts, sender)
+	}
+	return s.Streamer.StreamSearch(ctx, q, apply(flags, opts), zoekt.SenderFunc(func(r *zoekt.SearchResult) {
+		r.Stats.Experiments = flags
+		sender.Send(r)
+	}))
+}
+
+// StreamList implements zoekt.ListStreamer.
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *Searcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s *Searcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s *Searcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	return zoekt.UpdateRepositoryMetadata(ctx, s.Streamer, name, u)
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s *Searcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	return zoekt.ShardFiles(ctx, s.Streamer, repos)
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *Searcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	return zoekt.OpenShardFile(ctx, s.Streamer, name)
+}
+
+func (s *Searcher) String() string {
+	return fmt.Sprintf("experiments(%s)", s.Streamer)
+}
@@ -0,0 +1,158 @@
+package experiments
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// optsSearcher records the options of its searches.
+type optsSearcher struct {
+	opts *zoekt.SearchOptions
+}
+
+func (s *optsSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.opts = opts
+	return &zoekt.SearchResult{}, nil
+}
+
+func (s *optsSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	s.opts = opts
+	sender.Send(&zoekt.SearchResult{})
+	sender.Send(&zoekt.SearchResult{})
+	return nil
+}
+
+func (s *optsSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{}, nil
+}
+
+func (s *optsSearcher) Close()         {}
+func (s *optsSearcher) String() string { return "opts" }
+
+func TestWithFlags(t *testing.T) {
+	ctx := WithFlags(context.Background(), []string{"chunk_matches", "unknown", "bm25", "bm25"})
+	if diff := cmp.Diff([]string{"bm25", "chunk_matches"}, FromContext(ctx)); diff != "" {
+		t.Errorf("flags (-want +got):\n%s", diff)
+	}
+	if got := FromContext(WithFlags(context.Background(), []string{"unknown"})); got != nil {
+		t.Errorf("got flags %v for unknown names", got)
+	}
+}
+
+func TestPropagator(t *testing.T) {
+	ctx := WithFlags(context.Background(), []string{"bm25", "file_names_first"})
+	md := Propagator{}.FromContext(ctx)
+	if diff := cmp.Diff([]string{"bm25,file_names_first"}, md.Get(MetadataKey)); diff != "" {
+		t.Errorf("metadata (-want +got):\n%s", diff)
+	}
+
+	got, err := Propagator{}.InjectContext(context.Background(), metadata.Pairs(MetadataKey, " bm25 , unknown", MetadataKey, "chunk_matches"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"bm25", "chunk_matches"}, FromContext(got)); diff != "" {
+		t.Errorf("injected flags (-want +got):\n%s", diff)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got []string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.Header.Set(HeaderKey, "ranking_signals,bm25")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if diff := cmp.Diff([]string{"bm25", "ranking_signals"}, got); diff != "" {
+		t.Errorf("flags (-want +got):\n%s", diff)
+	}
+}
+
+func TestSearcher(t *testing.T) {
+	inner := &optsSearcher{}
+	s := &Searcher{Streamer: inner}
+	opts := &zoekt.SearchOptions{MaxWallTime: 1}
+
+	sr, err := s.Search(context.Background(), &query.Const{Value: true}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.opts != opts || sr.Stats.Experiments != nil {
+		t.Errorf("searches without flags changed: %+v, %v", inner.opts, sr.Stats.Experiments)
+	}
+
+	ctx := WithFlags(context.Background(), []string{"bm25", "chunk_matches"})
+	sr, err = s.Search(ctx, &query.Const{Value: true}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inner.opts.UseBM25Scoring || !inner.opts.ChunkMatches || inner.opts.MaxWallTime != 1 {
+		t.Errorf("got options %+v, want the flags applied", inner.opts)
+	}
+	if diff := cmp.Diff([]string{"bm25", "chunk_matches"}, inner.opts.Experiments); diff != "" {
+		t.Errorf("SearchOptions.Experiments (-want +got):\n%s", diff)
+	}
+	if opts.UseBM25Scoring || opts.ChunkMatches {
+		t.Errorf("the options of the caller changed: %+v", opts)
+	}
+	if diff := cmp.Diff([]string{"bm25", "chunk_matches"}, sr.Stats.Experiments); diff != "" {
+		t.Errorf("Stats.Experiments (-want +got):\n%s", diff)
+	}
+
+	var stats zoekt.Stats
+	err = s.StreamSearch(ctx, &query.Const{Value: true}, opts, zoekt.SenderFunc(func(r *zoekt.SearchResult) {
+		stats.Add(r.Stats)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"bm25", "chunk_matches"}, stats.Experiments); diff != "" {
+		t.Errorf("streamed Stats.Experiments (-want +got):\n%s", diff)
+	}
+}
+
+func TestSearcherDefaults(t *testing.T) {
+	inner := &optsSearcher{}
+	s := &Searcher{Streamer: inner}
+	if err := s.SetDefaults([]string{"file_names_first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithFlags(context.Background(), []string{"bm25", "file_names_first"})
+	sr, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inner.opts.UseBM25Scoring || !inner.opts.FileNamesFirst {
+		t.Errorf("got options %+v, want the flags and defaults applied", inner.opts)
+	}
+
+	ctx = WithFlags(context.Background(), []string{ChunkMergeAdjacent})
+	if _, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.opts.HasExperiment(ChunkMergeAdjacent) || !inner.opts.HasExperiment("file_names_first") || inner.opts.HasExperiment("bm25") {
+		t.Errorf("got experiments %v, want %s and the defaults", inner.opts.Experiments, ChunkMergeAdjacent)
+	}
+	if diff := cmp.Diff([]string{"bm25", "file_names_first"}, sr.Stats.Experiments); diff != "" {
+		t.Errorf("Stats.Experiments (-want +got):\n%s", diff)
+	}
+
+	if err := s.SetDefaults([]string{"unknown"}); err == nil {
+		t.Fatal("SetDefaults accepted an unknown flag")
+	}
+	if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.opts.FileNamesFirst {
+		t.Error("the defaults changed after a failed SetDefaults")
+	}
+}
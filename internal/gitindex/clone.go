@@ -16,6 +16,7 @@ package gitindex
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"log"
 	"maps"
@@ -23,7 +24,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/dustin/go-humanize"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 )
@@ -49,11 +53,66 @@ func updateZoektGitConfig(repoDest string, settings map[string]string) error {
 	return nil
 }
 
+// cloneDepthKey is the git config setting in which CloneRepo records the
+// depth of a shallow clone, so fetches keep it shallow.
+const cloneDepthKey = "zoekt.clonedepth"
+
+// CloneOptions reduce the disk space and network traffic of clones made by
+// CloneRepo. Indexing only needs the trees and blobs of the indexed
+// branches, not their history.
+type CloneOptions struct {
+	// Depth, if positive, makes a shallow clone with this many commits of
+	// every branch.
+	Depth int
+
+	// Filter, if set, makes a partial clone with this object filter. With
+	// "blob:none" no blobs are cloned, and the blobs of the indexed
+	// branches are fetched when indexing.
+	Filter string
+
+	// The limits of a Cloner. Concurrency is the number of clones running
+	// at a time, and HostConcurrency the number running at a time per
+	// host. Bandwidth and HostBandwidth, if positive, limit the average
+	// number of bytes cloned per second, in total and per host, by delaying
+	// the start of clones after large ones. A running clone isn't
+	// throttled.
+	Concurrency     int
+	HostConcurrency int
+	Bandwidth       uint64
+	HostBandwidth   uint64
+}
+
+// Flags adds flags for the clone options to fs.
+func (o *CloneOptions) Flags(fs *flag.FlagSet) {
+	fs.IntVar(&o.Depth, "clone_depth", 0, "if positive, make shallow clones with this many commits per branch.")
+	fs.StringVar(&o.Filter, "clone_filter", "", "if set, make partial clones with this filter, eg. blob:none. Missing blobs are fetched when indexing.")
+	fs.IntVar(&o.Concurrency, "clone_concurrency", 1, "number of repositories to clone at a time.")
+	fs.IntVar(&o.HostConcurrency, "clone_host_concurrency", 0, "if positive, number of repositories to clone at a time from a single host.")
+	fs.Func("clone_bandwidth", "if set, delay clones to keep the average clone bandwidth to this many bytes per second, eg. 10MB. Running clones aren't throttled.", bytesFlag(&o.Bandwidth))
+	fs.Func("clone_host_bandwidth", "if set, delay clones to keep the average clone bandwidth per host to this many bytes per second, eg. 10MB. Running clones aren't throttled.", bytesFlag(&o.HostBandwidth))
+}
+
+func bytesFlag(v *uint64) func(string) error {
+	return func(s string) error {
+		n, err := humanize.ParseBytes(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+		return nil
+	}
+}
+
 // CloneRepo clones one repository, adding the given config
 // settings. It returns the bare repo directory. The `name` argument
 // determines where the repo is stored relative to `destDir`. Returns
 // the directory of the repository.
-func CloneRepo(destDir, name, cloneURL string, settings map[string]string) (string, error) {
+//
+// The repository is fetched into a temporary repository next to its
+// directory, which is renamed once the fetch is done. If the clone fails or
+// is interrupted, the next CloneRepo resumes it, reusing the objects fetched
+// so far.
+func CloneRepo(destDir, name, cloneURL string, settings map[string]string, opts CloneOptions) (string, error) {
 	parent := filepath.Join(destDir, filepath.Dir(name))
 	if err := os.MkdirAll(parent, 0o755); err != nil {
 		return "", err
@@ -70,36 +129,153 @@ func CloneRepo(destDir, name, cloneURL string, settings map[string]string) (stri
 		return "", nil
 	}
 
-	var keys []string
-	for k := range settings {
-		keys = append(keys, k)
+	tmpDest := repoDest + ".tmp"
+	if _, err := os.Lstat(tmpDest); err == nil {
+		log.Printf("resuming clone into %s", repoDest)
+	} else if err := exec.Command("git", "init", "--bare", "--quiet", tmpDest).Run(); err != nil {
+		return "", fmt.Errorf("git init %s: %w", tmpDest, err)
 	}
-	sort.Strings(keys)
 
-	var config []string
-	for _, k := range keys {
-		if settings[k] != "" {
-			config = append(config, "--config", k+"="+settings[k])
+	settings = maps.Clone(settings)
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	settings["remote.origin.url"] = cloneURL
+	settings["remote.origin.fetch"] = "+refs/heads/*:refs/heads/*"
+	if opts.Depth > 0 {
+		settings[cloneDepthKey] = strconv.Itoa(opts.Depth)
+	}
+	if err := updateZoektGitConfig(tmpDest, settings); err != nil {
+		return "", fmt.Errorf("failed to set repository settings: %w", err)
+	}
+
+	head, err := remoteHead(tmpDest)
+	if err != nil {
+		return "", err
+	}
+
+	// We fetch the default branch first. Most objects are usually
+	// reachable from it, so they are not fetched again if fetching the
+	// other branches is interrupted.
+	fetch := func(refspecs ...string) error {
+		cmd := exec.Command("git", "--git-dir", tmpDest, "fetch", "--verbose", "--progress")
+		if opts.Depth > 0 {
+			cmd.Args = append(cmd.Args, "--depth="+strconv.Itoa(opts.Depth))
+		}
+		if opts.Filter != "" {
+			cmd.Args = append(cmd.Args, "--filter="+opts.Filter)
+		}
+		cmd.Args = append(cmd.Args, "origin")
+		cmd.Args = append(cmd.Args, refspecs...)
+
+		// Prevent prompting
+		cmd.Stdin = &bytes.Buffer{}
+		log.Println("running:", cmd.Args)
+		return cmd.Run()
+	}
+	if head != "" {
+		if err := fetch("+" + head + ":" + head); err != nil {
+			return "", err
+		}
+		if err := exec.Command("git", "--git-dir", tmpDest, "symbolic-ref", "HEAD", head).Run(); err != nil {
+			return "", fmt.Errorf("setting HEAD: %w", err)
 		}
 	}
+	if err := fetch(); err != nil {
+		return "", err
+	}
 
-	cmd := exec.Command(
-		"git", "clone", "--bare", "--verbose", "--progress",
-	)
-	cmd.Args = append(cmd.Args, config...)
-	cmd.Args = append(cmd.Args, cloneURL, repoDest)
+	if err := os.Rename(tmpDest, repoDest); err != nil {
+		return "", err
+	}
+	return repoDest, nil
+}
 
-	// Prevent prompting
+// remoteHead returns the branch the HEAD of the origin remote of repoDir
+// points to, or "" if the remote has no branches.
+func remoteHead(repoDir string) (string, error) {
+	cmd := exec.Command("git", "--git-dir", repoDir, "ls-remote", "--symref", "origin", "HEAD")
 	cmd.Stdin = &bytes.Buffer{}
-	log.Println("running:", cmd.Args)
-	if err := cmd.Run(); err != nil {
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if ref, ok := strings.CutPrefix(line, "ref: "); ok {
+			ref, _, _ = strings.Cut(ref, "\t")
+			return ref, nil
+		}
+	}
+	return "", nil
+}
+
+// FetchArgs returns the arguments for git which fetch updates of the clone
+// in repoDir. Shallow clones made by CloneRepo are fetched with the depth
+// they were cloned with.
+func FetchArgs(repoDir string) []string {
+	args := []string{"--git-dir", repoDir, "fetch", "origin", "--prune"}
+	out, err := exec.Command("git", "--git-dir", repoDir, "config", "--get", cloneDepthKey).Output()
+	if depth := strings.TrimSpace(string(out)); err == nil && depth != "" {
+		args = append(args, "--depth="+depth)
+	}
+	return args
+}
+
+// partialCloneFilter returns the object filter of the origin remote if
+// repo is a partial clone.
+func partialCloneFilter(repo *git.Repository) (string, error) {
+	cfg, err := repo.Config()
+	if err != nil {
 		return "", err
 	}
+	rm := cfg.Raw.Section("remote").Subsection("origin")
+	if rm.Option("promisor") != "true" {
+		return "", nil
+	}
+	return rm.Option("partialclonefilter"), nil
+}
 
-	if err := setFetch(repoDest, "origin", "+refs/heads/*:refs/heads/*"); err != nil {
-		log.Printf("addFetch: %v", err)
+// fetchMissingBlobs fetches the blobs of the trees of commits which are
+// missing in a partial clone without blobs. Git would fetch them one by one
+// when they are read, and go-git does not fetch them at all. It returns true
+// if it fetched blobs.
+//
+// Partial clones with other filters, eg. "blob:limit=1m", are left alone,
+// since the blobs they lack are too large to index anyway.
+func fetchMissingBlobs(repo *git.Repository, repoDir string, commits []string) (bool, error) {
+	filter, err := partialCloneFilter(repo)
+	if err != nil || filter != "blob:none" || len(commits) == 0 {
+		return false, err
 	}
-	return repoDest, nil
+
+	// --no-walk lists the objects of the commits, not of their history.
+	args := append([]string{"-C", repoDir, "rev-list", "--objects", "--no-walk", "--missing=print"}, commits...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return false, fmt.Errorf("listing missing blobs: %w", err)
+	}
+
+	var missing bytes.Buffer
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if id, ok := bytes.CutPrefix(line, []byte("?")); ok {
+			missing.Write(id)
+			missing.WriteByte('\n')
+		}
+	}
+	if missing.Len() == 0 {
+		return false, nil
+	}
+
+	// These are the arguments git uses to fetch missing objects from the
+	// promisor remote.
+	cmd := exec.Command("git", "-C", repoDir, "-c", "fetch.negotiationAlgorithm=noop",
+		"fetch", "origin", "--no-tags", "--no-write-fetch-head", "--recurse-submodules=no",
+		"--filter=blob:none", "--stdin")
+	cmd.Stdin = &missing
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("fetching missing blobs: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return true, nil
 }
 
 func setFetch(repoDir, remote, refspec string) error {
@@ -15,10 +15,21 @@
 package gitindex
 
 import (
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 func TestSetRemote(t *testing.T) {
@@ -56,3 +67,136 @@ git clone orig/.git clone.git
 		t.Fatalf("got %q want %q", got, want)
 	}
 }
+
+func TestCloneRepoPartial(t *testing.T) {
+	dir := t.TempDir()
+
+	script := `mkdir orig
+cd orig
+git init -b master
+git config user.name Thomas
+git config user.email thomas@google.com
+git config uploadpack.allowFilter true
+git config uploadpack.allowAnySHA1InWant true
+echo old > file.txt
+git add file.txt
+git commit -m old
+echo needle > file.txt
+git add file.txt
+git commit -m new
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	repoDir, err := CloneRepo(filepath.Join(dir, "clones"), "repo", "file://"+filepath.Join(dir, "orig"), nil, CloneOptions{Depth: 1, Filter: "blob:none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repoDir, "shallow")); err != nil {
+		t.Errorf("clone is not shallow: %v", err)
+	}
+	if got, want := FetchArgs(repoDir), []string{"--git-dir", repoDir, "fetch", "origin", "--prune", "--depth=1"}; !slices.Equal(got, want) {
+		t.Errorf("got fetch args %q, want %q", got, want)
+	}
+
+	// Indexing fetches the blob of the branch, but not the one of the
+	// previous commit.
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir:  repoDir,
+		Branches: []string{"master"},
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              indexDir,
+		},
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+	result, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 {
+		t.Errorf("got %d files, want 1", len(result.Files))
+	}
+
+	out, err := exec.Command("git", "-C", repoDir, "rev-list", "--objects", "--all", "--missing=print").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "?") {
+		t.Errorf("blobs of the branch are still missing:\n%s", out)
+	}
+}
+
+func TestCloneRepoResume(t *testing.T) {
+	dir := t.TempDir()
+
+	script := `mkdir orig
+cd orig
+git init -b main
+git config user.name Thomas
+git config user.email thomas@google.com
+echo main > file.txt
+git add file.txt
+git commit -m main
+git checkout -b other
+echo other > file.txt
+git commit -am other
+git checkout main
+cd ..
+git init --bare clones/repo.git.tmp
+git --git-dir clones/repo.git.tmp fetch "$PWD/orig" main:main
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	repoDir, err := CloneRepo(filepath.Join(dir, "clones"), "repo", "file://"+filepath.Join(dir, "orig"), map[string]string{"zoekt.name": "repo"}, CloneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "clones", "repo.git"); repoDir != want {
+		t.Errorf("got repo dir %q, want %q", repoDir, want)
+	}
+	if _, err := os.Stat(repoDir + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary clone still exists: %v", err)
+	}
+
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Reference("HEAD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := head.Target().String(); got != "refs/heads/main" {
+		t.Errorf("got HEAD %q, want refs/heads/main", got)
+	}
+	for _, b := range []string{"main", "other"} {
+		if _, err := repo.Reference(plumbing.NewBranchReferenceName(b), false); err != nil {
+			t.Errorf("branch %s: %v", b, err)
+		}
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Raw.Section("zoekt").Option("name"); got != "repo" {
+		t.Errorf("got zoekt.name %q, want repo", got)
+	}
+}
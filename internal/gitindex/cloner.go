@@ -0,0 +1,224 @@
+package gitindex
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cloner clones repositories in the background within the limits of its
+// CloneOptions. The mirror commands use it to clone the repositories of a
+// code host without opening more connections or using more bandwidth than
+// the host allows. Bandwidth isn't limited while a clone runs: clones are
+// started at a pace which keeps their average bandwidth within the limits,
+// see clonePacer.
+type Cloner struct {
+	// KeepGoing makes the Cloner log failed clones and continue with the
+	// other repositories instead of stopping at the first failure.
+	KeepGoing bool
+
+	opts CloneOptions
+
+	sem  chan struct{}
+	pace *clonePacer
+
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+	err   error
+}
+
+// hostLimit holds the limits of the clones from a single host.
+type hostLimit struct {
+	sem  chan struct{}
+	pace *clonePacer
+}
+
+// NewCloner returns a Cloner which clones with opts.
+func NewCloner(opts CloneOptions) *Cloner {
+	return &Cloner{
+		opts:  opts,
+		sem:   make(chan struct{}, max(opts.Concurrency, 1)),
+		pace:  &clonePacer{rate: opts.Bandwidth},
+		hosts: map[string]*hostLimit{},
+	}
+}
+
+func (c *Cloner) host(cloneURL string) *hostLimit {
+	var host string
+	if u, err := url.Parse(cloneURL); err == nil {
+		host = u.Host
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &hostLimit{pace: &clonePacer{rate: c.opts.HostBandwidth}}
+		if c.opts.HostConcurrency > 0 {
+			h.sem = make(chan struct{}, c.opts.HostConcurrency)
+		}
+		c.hosts[host] = h
+	}
+	return h
+}
+
+// Clone starts cloning a repository like CloneRepo, and prints the
+// directory of the repository to stdout if it was cloned. Unless KeepGoing
+// is set, the following clones are skipped once a clone failed, and Clone
+// and Wait return the first error.
+func (c *Cloner) Clone(destDir, name, cloneURL string, settings map[string]string) error {
+	if err := c.firstErr(); err != nil {
+		return err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := c.clone(destDir, name, cloneURL, settings); err != nil {
+			if c.KeepGoing {
+				log.Print(err)
+				return
+			}
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (c *Cloner) clone(destDir, name, cloneURL string, settings map[string]string) error {
+	h := c.host(cloneURL)
+	release := c.acquire(h)
+	defer release()
+
+	if err := c.firstErr(); err != nil {
+		return nil
+	}
+
+	dest, err := CloneRepo(destDir, name, cloneURL, settings, c.opts)
+	if err != nil {
+		return fmt.Errorf("cloning %s: %w", name, err)
+	}
+	if dest == "" {
+		return nil
+	}
+
+	size, err := dirSize(dest)
+	if err != nil {
+		log.Printf("dirSize(%s): %v", dest, err)
+	}
+	now := time.Now()
+	c.pace.add(now, size)
+	h.pace.add(now, size)
+
+	c.mu.Lock()
+	fmt.Println(dest)
+	c.mu.Unlock()
+	return nil
+}
+
+// acquire waits until a clone from host h may start, and takes its slots.
+// It waits for the pace before taking the slots, so clones waiting for the
+// pace don't keep other hosts from cloning. Clones which finished while it
+// waited for the slots may have charged the pace again, so it then checks
+// the pace once more.
+func (c *Cloner) acquire(h *hostLimit) (release func()) {
+	for {
+		time.Sleep(max(c.pace.delay(time.Now()), h.pace.delay(time.Now())))
+
+		if h.sem != nil {
+			h.sem <- struct{}{}
+		}
+		c.sem <- struct{}{}
+		release = func() {
+			<-c.sem
+			if h.sem != nil {
+				<-h.sem
+			}
+		}
+
+		if max(c.pace.delay(time.Now()), h.pace.delay(time.Now())) == 0 {
+			return release
+		}
+		release()
+	}
+}
+
+func (c *Cloner) firstErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Wait waits for the started clones to finish, and returns the error of the
+// first clone that failed.
+func (c *Cloner) Wait() error {
+	c.wg.Wait()
+	return c.firstErr()
+}
+
+// clonePacer paces the start of clones to keep their average bandwidth
+// within a rate. It doesn't throttle the transfer of a clone, which git
+// fetches as fast as it can. We only know how much a clone fetched once it
+// is done, so the clone is charged afterwards, and the next clone waits
+// until the bytes of the previous ones would have been fetched at the rate.
+// Clones running at the same time are only charged once they finish, so
+// with a Concurrency above one, the bandwidth may exceed the rate for a
+// while.
+type clonePacer struct {
+	rate uint64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// delay returns how long to wait at now before starting a clone.
+func (b *clonePacer) delay(now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.next.Sub(now), 0)
+}
+
+// add charges n bytes fetched at now.
+func (b *clonePacer) add(now time.Time, n int64) {
+	if b.rate == 0 || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(float64(n) / float64(b.rate) * float64(time.Second)))
+}
+
+// dirSize returns the size of the files in dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,92 @@
+package gitindex
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClonePacer(t *testing.T) {
+	now := time.Now()
+	b := &clonePacer{rate: 1000}
+	if d := b.delay(now); d != 0 {
+		t.Errorf("got delay %v before any clone, want 0", d)
+	}
+
+	b.add(now, 2000)
+	if d, want := b.delay(now), 2*time.Second; d != want {
+		t.Errorf("got delay %v, want %v", d, want)
+	}
+	b.add(now, 500)
+	if d, want := b.delay(now.Add(time.Second)), 1500*time.Millisecond; d != want {
+		t.Errorf("got delay %v, want %v", d, want)
+	}
+
+	// Idle time is not saved up for later clones.
+	later := now.Add(time.Minute)
+	b.add(later, 1000)
+	if d, want := b.delay(later), time.Second; d != want {
+		t.Errorf("got delay %v, want %v", d, want)
+	}
+
+	unlimited := &clonePacer{}
+	unlimited.add(now, 1<<30)
+	if d := unlimited.delay(now); d != 0 {
+		t.Errorf("got delay %v without a limit, want 0", d)
+	}
+}
+
+func TestCloner(t *testing.T) {
+	dir := t.TempDir()
+
+	script := `git init -b master orig
+cd orig
+git config user.name Thomas
+git config user.email thomas@google.com
+echo hello > file.txt
+git add file.txt
+git commit -m hello
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+	origURL := "file://" + filepath.Join(dir, "orig")
+
+	destDir := filepath.Join(dir, "clones")
+	c := NewCloner(CloneOptions{Concurrency: 2, HostConcurrency: 1, Bandwidth: 1 << 30})
+	for _, name := range []string{"a", "b", "c"} {
+		if err := c.Clone(destDir, name, origURL, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(filepath.Join(destDir, name+".git", "HEAD")); err != nil {
+			t.Errorf("repo %s was not cloned: %v", name, err)
+		}
+	}
+
+	c = NewCloner(CloneOptions{})
+	if err := c.Clone(destDir, "missing", "file://"+filepath.Join(dir, "missing"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Wait(); err == nil {
+		t.Fatal("cloning a missing repository succeeded")
+	}
+	if err := c.Clone(destDir, "d", origURL, nil); err == nil {
+		t.Error("Clone succeeded after a failed clone")
+	}
+
+	c = NewCloner(CloneOptions{})
+	c.KeepGoing = true
+	c.Clone(destDir, "missing", "file://"+filepath.Join(dir, "missing"), nil) // nolint:errcheck
+	if err := c.Wait(); err != nil {
+		t.Errorf("got error %v with KeepGoing", err)
+	}
+}
@@ -0,0 +1,70 @@
+package gitindex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// commitDocuments returns the documents holding the messages of the limit
+// most recent commits of each branch. A commit reachable from several
+// branches is a single document on all of them.
+func commitDocuments(repo *git.Repository, branches []zoekt.RepositoryBranch, limit int) ([]index.Document, error) {
+	var hashes []plumbing.Hash
+	commits := map[plumbing.Hash]*object.Commit{}
+	commitBranches := map[plumbing.Hash][]string{}
+
+	for _, b := range branches {
+		iter, err := repo.Log(&git.LogOptions{
+			From:  plumbing.NewHash(b.Version),
+			Order: git.LogOrderCommitterTime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("log %s: %w", b.Name, err)
+		}
+
+		n := 0
+		err = iter.ForEach(func(c *object.Commit) error {
+			if n == limit {
+				return storer.ErrStop
+			}
+			n++
+
+			if _, ok := commits[c.Hash]; !ok {
+				commits[c.Hash] = c
+				hashes = append(hashes, c.Hash)
+			}
+			commitBranches[c.Hash] = append(commitBranches[c.Hash], b.Name)
+			return nil
+		})
+		iter.Close()
+		if err != nil {
+			return nil, fmt.Errorf("log %s: %w", b.Name, err)
+		}
+	}
+
+	docs := make([]index.Document, 0, len(hashes))
+	for _, h := range hashes {
+		docs = append(docs, index.Document{
+			Name:     h.String(),
+			Content:  commitContent(commits[h]),
+			Branches: commitBranches[h],
+			Language: index.CommitLanguage,
+		})
+	}
+	return docs, nil
+}
+
+// commitContent formats a commit like git log, so that the author and date
+// can be searched along with the message.
+func commitContent(c *object.Commit) []byte {
+	return fmt.Appendf(nil, "commit %s\nAuthor: %s <%s>\nDate:   %s\n\n%s",
+		c.Hash, c.Author.Name, c.Author.Email, c.Author.When.Format(time.RFC3339), c.Message)
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/sourcegraph/zoekt"
@@ -109,3 +110,71 @@ func TestIgnore(t *testing.T) {
 		}
 	}
 }
+
+func TestZoektIgnore(t *testing.T) {
+	dir := t.TempDir()
+	script := `git init -b master
+mkdir build src
+echo gen > src/a.gen.go
+echo keep > src/keep.gen.go
+echo out > build/out.js
+echo main > src/main.go
+printf '# generated\n*.gen.go\n!keep.gen.go\nbuild/\n' > .zoektignore
+git add .
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git commit -am amsg
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := index.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+	if _, err := IndexGitRepo(Options{
+		RepoDir:      dir,
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+	}); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	res, err := searcher.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	slices.Sort(got)
+	if want := []string{".zoektignore", "src/keep.gen.go", "src/main.go"}; !slices.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	rl, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 {
+		t.Fatalf("got %d repositories, want 1", len(rl.Repos))
+	}
+	if got, want := rl.Repos[0].Repository.RawConfig[zoektIgnoreKey], "*.gen.go\n!keep.gen.go\nbuild/"; got != want {
+		t.Errorf("got recorded patterns %q, want %q", got, want)
+	}
+}
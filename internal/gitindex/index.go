@@ -28,6 +28,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -293,6 +294,28 @@ func SetTemplatesFromOrigin(desc *zoekt.Repository, u *url.URL) error {
 	}
 }
 
+// ConfiguredBranches returns the branches listed in the zoekt.branches git
+// config setting of the repository, separated by commas. Mirror commands set
+// it for repositories which should be indexed with other branches than HEAD.
+func ConfiguredBranches(repoDir string) ([]string, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	for _, b := range strings.Split(cfg.Raw.Section("zoekt").Options.Get("branches"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			branches = append(branches, b)
+		}
+	}
+	return branches, nil
+}
+
 // The Options structs controls details of the indexing process.
 type Options struct {
 	// The repository to be indexed.
@@ -320,6 +343,20 @@ type Options struct {
 	// List of branch names to index, e.g. []string{"HEAD", "stable"}
 	Branches []string
 
+	// List of tags to index, e.g. []string{"v1.0.0", "v2.*"}. Patterns
+	// containing "*" select all matching tags. Tags are indexed like
+	// branches and named after the tag, so queries can select them with
+	// rev:.
+	Tags []string
+
+	// If positive, also index the messages of up to this many of the most
+	// recent commits of each branch. They are stored as documents of
+	// language index.CommitLanguage, which are only searched by queries
+	// with type:commit. A delta build replaces the messages of the last
+	// build, which it assumes indexed as many, so change it with a normal
+	// build.
+	CommitMessages int
+
 	// DeltaShardNumberFallbackThreshold defines an upper limit (inclusive) on the number of preexisting shards
 	// that can exist before attempting another delta build. If the number of preexisting shards exceeds this threshold,
 	// then a normal build will be performed instead.
@@ -327,6 +364,61 @@ type Options struct {
 	// If DeltaShardNumberFallbackThreshold is 0, then this fallback behavior is disabled:
 	// a delta build will always be performed regardless of the number of preexisting shards.
 	DeltaShardNumberFallbackThreshold uint64
+
+	// IncludePaths and ExcludePaths are glob patterns of the files to index,
+	// following the rules of the patterns of ignore files, eg. to only index
+	// some subtrees of a monorepo. If IncludePaths is empty, all files which
+	// are not excluded are indexed. If both are unset, they are read from the
+	// zoekt.include-paths and zoekt.exclude-paths git config settings.
+	IncludePaths []string
+	ExcludePaths []string
+
+	// If positive, index the objects of Git LFS pointer files of up to this
+	// many bytes instead of the pointers. Objects are read from the LFS
+	// store of the repository, or else fetched with "git lfs smudge". Files
+	// of submodules are not resolved.
+	LFSMaxSize int64
+}
+
+// expandTags returns the tag names selected by patterns.
+func expandTags(repo *git.Repository, patterns []string) ([]string, error) {
+	var result []string
+	var globs []string
+	for _, p := range patterns {
+		if strings.Contains(p, "*") {
+			globs = append(globs, p)
+		} else {
+			result = append(result, p)
+		}
+	}
+	if len(globs) == 0 {
+		return result, nil
+	}
+
+	iter, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var matches []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		for _, g := range globs {
+			if matched, err := filepath.Match(g, name); err != nil {
+				return err
+			} else if matched {
+				matches = append(matches, name)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return append(result, matches...), nil
 }
 
 func expandBranches(repo *git.Repository, bs []string, prefix string) ([]string, error) {
@@ -407,28 +499,31 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 
 	opts.BuildOptions.RepositoryDescription.Source = opts.RepoDir
 
-	var repo *git.Repository
-	// TODO: this now defaults to on since we found a bug in it. Once we have
-	// fixed openRepo default to false.
-	legacyRepoOpen := cmp.Or(os.Getenv("ZOEKT_DISABLE_GOGIT_OPTIMIZATION"), "true")
-	if b, err := strconv.ParseBool(legacyRepoOpen); b || err != nil {
-		repo, err = git.PlainOpen(opts.RepoDir)
-		if err != nil {
-			return false, fmt.Errorf("git.PlainOpen: %w", err)
-		}
-	} else {
-		var repoCloser io.Closer
-		repo, repoCloser, err = openRepo(opts.RepoDir)
-		if err != nil {
-			return false, fmt.Errorf("openRepo: %w", err)
-		}
-		defer repoCloser.Close()
+	repo, repoCloser, err := openIndexRepo(opts.RepoDir)
+	if err != nil {
+		return false, err
 	}
+	defer func() { repoCloser.Close() }()
 
 	if err := setTemplatesFromConfig(&opts.BuildOptions.RepositoryDescription, opts.RepoDir); err != nil {
 		log.Printf("setTemplatesFromConfig(%s): %s", opts.RepoDir, err)
 	}
 
+	if err := setPathFilters(&opts, repo); err != nil {
+		return false, fmt.Errorf("setPathFilters: %w", err)
+	}
+	filter, err := newPathFilter(opts.IncludePaths, opts.ExcludePaths)
+	if err != nil {
+		return false, fmt.Errorf("newPathFilter: %w", err)
+	}
+
+	// Tags are indexed as branches from here on.
+	tags, err := expandTags(repo, opts.Tags)
+	if err != nil {
+		return false, fmt.Errorf("expandTags: %w", err)
+	}
+	opts.Branches = append(slices.Clip(opts.Branches), tags...)
+
 	branches, err := expandBranches(repo, opts.Branches, opts.BranchPrefix)
 	if err != nil {
 		return false, fmt.Errorf("expandBranches: %w", err)
@@ -453,10 +548,30 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 		}
 	}
 
-	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() {
+	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() && !pathFiltersChanged(&opts.BuildOptions) {
 		return false, nil
 	}
 
+	var commits []string
+	for _, b := range opts.BuildOptions.RepositoryDescription.Branches {
+		commits = append(commits, b.Version)
+	}
+	if fetched, err := fetchMissingBlobs(repo, opts.RepoDir, commits); err != nil {
+		return false, fmt.Errorf("fetchMissingBlobs: %w", err)
+	} else if fetched {
+		// go-git does not pick up packfiles added after opening the
+		// repository.
+		repoCloser.Close()
+		repo, repoCloser, err = openIndexRepo(opts.RepoDir)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := setIgnorePatterns(&opts.BuildOptions.RepositoryDescription, repo); err != nil {
+		return false, fmt.Errorf("setIgnorePatterns: %w", err)
+	}
+
 	// branch => (path, sha1) => repo.
 	var repos map[fileKey]BlobLocation
 
@@ -527,12 +642,23 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 		builder.MarkFileAsChangedOrRemoved(f)
 	}
 
+	var commitDocs []index.Document
+	if opts.CommitMessages > 0 {
+		commitDocs, err = commitDocuments(repo, opts.BuildOptions.RepositoryDescription.Branches, opts.CommitMessages)
+		if err != nil {
+			return false, fmt.Errorf("commitDocuments: %w", err)
+		}
+	}
+
 	var names []string
 	fileKeys := map[string][]fileKey{}
 	totalFiles := 0
 
 	for key := range repos {
 		n := key.FullPath()
+		if !filter.Include(n) {
+			continue
+		}
 		fileKeys[n] = append(fileKeys[n], key)
 		names = append(names, n)
 		totalFiles++
@@ -541,12 +667,17 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 	sort.Strings(names)
 	names = uniq(names)
 
+	var lfs *lfsResolver
+	if opts.LFSMaxSize > 0 {
+		lfs = newLFSResolver(opts.RepoDir, opts.LFSMaxSize)
+	}
+
 	log.Printf("attempting to index %d total files", totalFiles)
 	for idx, name := range names {
 		keys := fileKeys[name]
 
 		for _, key := range keys {
-			doc, err := createDocument(key, repos, opts.BuildOptions)
+			doc, err := createDocument(key, repos, opts.BuildOptions, lfs)
 			if err != nil {
 				return false, err
 			}
@@ -560,9 +691,40 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 			}
 		}
 	}
+
+	for _, doc := range commitDocs {
+		if err := builder.Add(doc); err != nil {
+			return false, fmt.Errorf("error adding commit message %s: %w", doc.Name, err)
+		}
+	}
 	return true, builder.Finish()
 }
 
+// openIndexRepo opens the repository to index. The closer must be called
+// once indexing is done.
+func openIndexRepo(repoDir string) (*git.Repository, io.Closer, error) {
+	// TODO: this now defaults to on since we found a bug in it. Once we have
+	// fixed openRepo default to false.
+	legacyRepoOpen := cmp.Or(os.Getenv("ZOEKT_DISABLE_GOGIT_OPTIMIZATION"), "true")
+	if b, err := strconv.ParseBool(legacyRepoOpen); b || err != nil {
+		repo, err := git.PlainOpen(repoDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("git.PlainOpen: %w", err)
+		}
+		return repo, nopCloser{}, nil
+	}
+
+	repo, closer, err := openRepo(repoDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("openRepo: %w", err)
+	}
+	return repo, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
 // openRepo opens a git repository in a way that's optimized for indexing.
 //
 // It copies the relevant logic from git.PlainOpen, and tweaks certain filesystem options.
@@ -617,19 +779,75 @@ func (r repoPathRanks) rank(path string, content []byte) float64 {
 	}
 }
 
+// newIgnoreMatcher returns a matcher for the ignore.IgnoreFile and the
+// ignore.ZoektIgnoreFile at the root of tree.
 func newIgnoreMatcher(tree *object.Tree) (*ignore.Matcher, error) {
-	ignoreFile, err := tree.File(ignore.IgnoreFile)
-	if err == object.ErrFileNotFound {
-		return &ignore.Matcher{}, nil
+	ig := &ignore.Matcher{}
+	for _, name := range []string{ignore.IgnoreFile, ignore.ZoektIgnoreFile} {
+		ignoreFile, err := tree.File(name)
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := ignoreFile.Contents()
+		if err != nil {
+			return nil, err
+		}
+		parse := ignore.ParseIgnoreFile
+		if name == ignore.ZoektIgnoreFile {
+			parse = ignore.ParseGitIgnoreFile
+		}
+		m, err := parse(strings.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ig = ig.Union(m)
 	}
-	if err != nil {
-		return nil, err
+	return ig, nil
+}
+
+// zoektIgnoreKey is the key of the RawConfig of a repository recording the
+// patterns of the ignore.ZoektIgnoreFile of its branches, one per line.
+const zoektIgnoreKey = "zoektignore"
+
+// setIgnorePatterns records the patterns of the ignore.ZoektIgnoreFile of
+// the branches of the repository in its RawConfig, so the index tells which
+// files were left out.
+func setIgnorePatterns(desc *zoekt.Repository, repo *git.Repository) error {
+	delete(desc.RawConfig, zoektIgnoreKey)
+
+	var patterns []string
+	seen := map[string]bool{}
+	for _, b := range desc.Branches {
+		commit, err := repo.CommitObject(plumbing.NewHash(b.Version))
+		if err != nil {
+			return err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		ig, err := newIgnoreMatcher(tree)
+		if err != nil {
+			return err
+		}
+		for _, p := range ig.Patterns() {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
 	}
-	content, err := ignoreFile.Contents()
-	if err != nil {
-		return nil, err
+	if len(patterns) == 0 {
+		return nil
+	}
+	if desc.RawConfig == nil {
+		desc.RawConfig = map[string]string{}
 	}
-	return ignore.ParseIgnoreFile(strings.NewReader(content))
+	desc.RawConfig[zoektIgnoreKey] = strings.Join(patterns, "\n")
+	return nil
 }
 
 // prepareDeltaBuildFunc is a function that calculates the necessary metadata for preparing
@@ -711,12 +929,21 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 		return nil, nil, nil, fmt.Errorf("one or more index options previously stored for repository %s (ID: %d) does not match the index options for this requested build; These index option updates are incompatible with delta build. new index options: %+v", existingRepository.Name, existingRepository.ID, options.BuildOptions.HashOptions())
 	}
 
+	// The files of other path filters than the existing shards' are not
+	// in the changed files.
+	if !pathFiltersEqual(existingRepository, &options.BuildOptions.RepositoryDescription) {
+		return nil, nil, nil, fmt.Errorf("path filters previously stored for repository %s (ID: %d) do not match the path filters of this build", existingRepository.Name, existingRepository.ID)
+	}
+
 	// branch => (path, sha1) => repo.
 	repos = map[fileKey]BlobLocation{}
 
 	// branch name -> git worktree at most current commit
 	branchToCurrentTree := make(map[string]*object.Tree, len(options.Branches))
 
+	// branch name -> ignore files of the current worktree
+	branchToIgnore := make(map[string]*ignore.Matcher, len(options.Branches))
+
 	for _, b := range options.Branches {
 		commit, err := getCommit(repository, options.BranchPrefix, b)
 		if err != nil {
@@ -729,6 +956,11 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 		}
 
 		branchToCurrentTree[b] = tree
+
+		branchToIgnore[b], err = newIgnoreMatcher(tree)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("newIgnoreMatcher for branch %q: %w", b, err)
+		}
 	}
 
 	rawURL := options.BuildOptions.RepositoryDescription.URL
@@ -769,16 +1001,19 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 				newFileRelativeRootPath := c.To.Name
 
 				// TODO@ggilmore: HACK - remove once ignore files are supported in delta builds
-				if newFileRelativeRootPath == ignore.IgnoreFile {
-					return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
+				if newFileRelativeRootPath == ignore.IgnoreFile || newFileRelativeRootPath == ignore.ZoektIgnoreFile {
+					return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", newFileRelativeRootPath)
 				}
 
 				// either file is added or renamed, so we need to add the new version to the build
 				file := fileKey{Path: newFileRelativeRootPath, ID: newFile.Hash}
-				if existing, ok := repos[file]; ok {
+				switch existing, ok := repos[file]; {
+				case branchToIgnore[branch.Name].Match(newFileRelativeRootPath):
+					// ignored files are left out of the build
+				case ok:
 					existing.Branches = append(existing.Branches, branch.Name)
 					repos[file] = existing
-				} else {
+				default:
 					repos[file] = BlobLocation{
 						GitRepo:  repository,
 						URL:      u,
@@ -796,13 +1031,17 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 			// change's "Name" field is the only way that ggilmore saw to get the full path relative to the root
 			oldFileRelativeRootPath := c.From.Name
 
-			if oldFileRelativeRootPath == ignore.IgnoreFile {
-				return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
+			if oldFileRelativeRootPath == ignore.IgnoreFile || oldFileRelativeRootPath == ignore.ZoektIgnoreFile {
+				return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", oldFileRelativeRootPath)
 			}
 
 			// The file is either modified or deleted. So, we need to add ALL versions
 			// of the old file (across all branches) to the build.
 			for b, currentTree := range branchToCurrentTree {
+				if branchToIgnore[b].Match(oldFileRelativeRootPath) {
+					continue
+				}
+
 				f, err := currentTree.File(oldFileRelativeRootPath)
 				if err != nil {
 					// the file doesn't exist in this branch
@@ -830,6 +1069,20 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 		}
 	}
 
+	// The commit messages of the last build are replaced: those of commits
+	// which are still recent are added again with their current branches,
+	// and the others are stale. This assumes the last build indexed as many
+	// commit messages.
+	if options.CommitMessages > 0 {
+		docs, err := commitDocuments(repository, existingRepository.Branches, options.CommitMessages)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("commit messages of the last build: %w", err)
+		}
+		for _, doc := range docs {
+			changedOrDeletedPaths = append(changedOrDeletedPaths, doc.Name)
+		}
+	}
+
 	// we need to de-duplicate the branch map before returning it - it's possible for the same
 	// branch to have been added multiple times if a file has been modified across multiple commits
 	for _, info := range repos {
@@ -894,6 +1147,7 @@ func prepareNormalBuild(options Options, repository *git.Repository) (repos map[
 func createDocument(key fileKey,
 	repos map[fileKey]BlobLocation,
 	opts index.Options,
+	lfs *lfsResolver,
 ) (index.Document, error) {
 	repo := repos[key]
 	blob, err := repo.GitRepo.BlobObject(key.ID)
@@ -918,6 +1172,13 @@ func createDocument(key fileKey,
 		return index.Document{}, err
 	}
 
+	if lfs != nil && key.SubRepoPath == "" && blob.Size <= lfsPointerMaxSize {
+		contents = lfs.resolve(keyFullPath, contents)
+		if len(contents) > opts.SizeMax && !opts.IgnoreSizeMax(keyFullPath) {
+			return skippedLargeDoc(key, branches, opts), nil
+		}
+	}
+
 	return index.Document{
 		SubRepositoryPath: key.SubRepoPath,
 		Name:              keyFullPath,
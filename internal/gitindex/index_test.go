@@ -172,6 +172,9 @@ func TestIndexDeltaBasic(t *testing.T) {
 	emptySourcegraphIgnore := index.Document{Name: ignore.IgnoreFile}
 	sourcegraphIgnoreWithContent := index.Document{Name: ignore.IgnoreFile, Content: []byte("good_content.txt")}
 
+	zoektIgnore := index.Document{Name: ignore.ZoektIgnoreFile, Content: []byte("*.log\n")}
+	debugLog := index.Document{Name: "debug.log", Content: []byte("ignored")}
+
 	for _, test := range []struct {
 		name     string
 		branches []string
@@ -548,6 +551,31 @@ func TestIndexDeltaBasic(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "delta build leaves out files ignored by the zoekt ignore file",
+			branches: []string{"main"},
+			steps: []step{
+				{
+					name: "setup",
+					addedDocuments: branchToDocumentMap{
+						"main": []index.Document{zoektIgnore, foo},
+					},
+
+					expectedDocuments: []index.Document{zoektIgnore, foo},
+				},
+				{
+					name: "add ignored file",
+					addedDocuments: branchToDocumentMap{
+						"main": []index.Document{debugLog, fruitV1},
+					},
+					optFn: func(t *testing.T, o *Options) {
+						o.BuildOptions.IsDelta = true
+					},
+
+					expectedDocuments: []index.Document{zoektIgnore, foo, fruitV1},
+				},
+			},
+		},
 		{
 			name:     "should fallback to a full, normal build if the repository has more than the specified threshold of shards",
 			branches: []string{"main"},
@@ -994,3 +1022,273 @@ func BenchmarkPrepareNormalBuild(b *testing.B) {
 		b.Fatalf("Unexpected empty results")
 	}
 }
+
+func TestIndexTags(t *testing.T) {
+	dir := t.TempDir()
+	script := `git init -b main repo
+cd repo
+git config user.name Thomas
+git config user.email thomas@google.com
+echo one > file.txt
+git add file.txt
+git commit -m one
+git tag v1.0.0
+echo two > file.txt
+git commit -am two
+git tag -a v2.0.0 -m "release two"
+echo three > file.txt
+git commit -am three
+git tag other
+`
+	cmd := exec.Command("sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir:  filepath.Join(dir, "repo"),
+		Branches: []string{"main"},
+		Tags:     []string{"v*"},
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              indexDir,
+		},
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	for qStr, want := range map[string][]string{
+		"rev:v1.0.0 file:file": {"v1.0.0"},
+		"one":                  {"v1.0.0"},
+		"two":                  {"v2.0.0"},
+		"three":                {"main"},
+		"rev:v2.0.0 three":     nil,
+		"rev:v2 file:file":     nil,
+	} {
+		q, err := query.Parse(qStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.Branches...)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("%s: unexpected branches (-want, +got):\n%s", qStr, d)
+		}
+	}
+}
+
+func TestIndexCommitMessages(t *testing.T) {
+	dir := t.TempDir()
+	script := `git init -b main repo
+cd repo
+git config user.name Thomas
+git config user.email thomas@google.com
+echo one > file.txt
+git add file.txt
+git commit -m "add file for TICKET-1"
+git checkout -b dev
+echo two > file.txt
+git commit -am "TICKET-2: change file"
+git checkout main
+echo TICKET-1 > other.txt
+git add other.txt
+git commit -m "add other"
+`
+	cmd := exec.Command("sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir:        filepath.Join(dir, "repo"),
+		Branches:       []string{"main", "dev"},
+		CommitMessages: 2,
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              indexDir,
+		},
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	search := func(qStr string) []zoekt.FileMatch {
+		t.Helper()
+		q, err := query.Parse(qStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Files
+	}
+
+	// Code searches don't match the commit messages.
+	if files := search("TICKET-1"); len(files) != 1 || files[0].FileName != "other.txt" {
+		t.Errorf("TICKET-1: got %v, want other.txt", files)
+	}
+
+	for qStr, want := range map[string][]string{
+		"type:commit TICKET-1":             {"main", "dev"},
+		"type:commit TICKET-2":             {"dev"},
+		"type:commit Author:.Thomas other": {"main"},
+		"type:commit two":                  nil,
+	} {
+		var got []string
+		for _, f := range search(qStr) {
+			if f.Language != index.CommitLanguage {
+				t.Errorf("%s: got language %q, want %q", qStr, f.Language, index.CommitLanguage)
+			}
+			got = append(got, f.Branches...)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("%s: unexpected branches (-want, +got):\n%s", qStr, d)
+		}
+	}
+
+	// A delta build removes the messages of the commits which are no
+	// longer among the recent ones.
+	script = `echo three > third.txt
+git add third.txt
+git commit -m "add third"
+echo four > fourth.txt
+git add fourth.txt
+git commit -m "add fourth"
+`
+	cmd = exec.Command("sh", "-euxc", script)
+	cmd.Dir = opts.RepoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+	opts.BuildOptions.IsDelta = true
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatal(err)
+	}
+	if shards := opts.BuildOptions.FindAllShards(); len(shards) != 2 {
+		t.Fatalf("got shards %v, want a delta shard", shards)
+	}
+	searcher.Close()
+	if searcher, err = shards.NewDirectorySearcher(indexDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for qStr, want := range map[string][]string{
+		"type:commit TICKET-1": {"dev"},
+		"type:commit other":    nil,
+		"type:commit fourth":   {"main"},
+	} {
+		var got []string
+		for _, f := range search(qStr) {
+			got = append(got, f.Branches...)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("after delta build, %s: unexpected branches (-want, +got):\n%s", qStr, d)
+		}
+	}
+}
+
+func TestIndexPathFilters(t *testing.T) {
+	dir := t.TempDir()
+	script := `git init -b main repo
+cd repo
+git config user.name Thomas
+git config user.email thomas@google.com
+git config zoekt.include-paths services
+mkdir -p services/a services/b lib
+echo needle > services/a/a.go
+echo needle > services/b/b.go
+echo needle > lib/lib.go
+git add .
+git commit -m initial
+`
+	cmd := exec.Command("sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	indexAndSearch := func(opts Options) ([]string, map[string]string) {
+		t.Helper()
+		opts.RepoDir = filepath.Join(dir, "repo")
+		opts.Branches = []string{"main"}
+		opts.Incremental = true
+		opts.BuildOptions = index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              indexDir,
+		}
+		if _, err := IndexGitRepo(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		searcher, err := shards.NewDirectorySearcher(indexDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer searcher.Close()
+
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, f := range res.Files {
+			files = append(files, f.FileName)
+		}
+		sort.Strings(files)
+
+		rlist, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rlist.Repos) != 1 {
+			t.Fatalf("got %d repos, want 1", len(rlist.Repos))
+		}
+		cfg := rlist.Repos[0].Repository.RawConfig
+		return files, map[string]string{includePathsKey: cfg[includePathsKey], excludePathsKey: cfg[excludePathsKey]}
+	}
+
+	// The filters are read from the git config.
+	files, filters := indexAndSearch(Options{})
+	if d := cmp.Diff([]string{"services/a/a.go", "services/b/b.go"}, files); d != "" {
+		t.Errorf("unexpected files with the configured filters (-want, +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]string{includePathsKey: "services", excludePathsKey: ""}, filters); d != "" {
+		t.Errorf("unexpected recorded filters (-want, +got):\n%s", d)
+	}
+
+	// The options replace them, and changing them reindexes the repository
+	// even though its commits are indexed already.
+	files, filters = indexAndSearch(Options{IncludePaths: []string{"services", "lib"}, ExcludePaths: []string{"services/b/**"}})
+	if d := cmp.Diff([]string{"lib/lib.go", "services/a/a.go"}, files); d != "" {
+		t.Errorf("unexpected files with the filter options (-want, +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]string{includePathsKey: "services,lib", excludePathsKey: "services/b/**"}, filters); d != "" {
+		t.Errorf("unexpected recorded filters (-want, +got):\n%s", d)
+	}
+}
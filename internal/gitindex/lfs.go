@@ -0,0 +1,122 @@
+package gitindex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// lfsPointerMaxSize is the maximum size of a Git LFS pointer file.
+const lfsPointerMaxSize = 1024
+
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointer is the object a Git LFS pointer file points to.
+type lfsPointer struct {
+	oid  string // hex SHA-256
+	size int64
+}
+
+// parseLFSPointer parses a Git LFS pointer file. It returns false if content
+// is not one.
+func parseLFSPointer(content []byte) (lfsPointer, bool) {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return lfsPointer{}, false
+	}
+
+	var p lfsPointer
+	sizeSet := false
+	for _, line := range bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))[1:] {
+		key, value, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return lfsPointer{}, false
+		}
+		switch string(key) {
+		case "oid":
+			oid, ok := bytes.CutPrefix(value, []byte("sha256:"))
+			if !ok || len(oid) != 2*sha256.Size {
+				return lfsPointer{}, false
+			}
+			if _, err := hex.DecodeString(string(oid)); err != nil {
+				return lfsPointer{}, false
+			}
+			p.oid = string(oid)
+		case "size":
+			size, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil || size < 0 {
+				return lfsPointer{}, false
+			}
+			p.size, sizeSet = size, true
+		}
+	}
+	return p, p.oid != "" && sizeSet
+}
+
+// lfsResolver replaces Git LFS pointer files by the objects they point to.
+type lfsResolver struct {
+	// gitDir is the git directory of the repository, which holds the local
+	// LFS objects in lfs/objects.
+	gitDir string
+
+	// maxSize is the size of the largest object which is resolved.
+	maxSize int64
+
+	// fetch fetches an object which is not stored locally.
+	fetch func(gitDir string, pointer []byte) ([]byte, error)
+}
+
+func newLFSResolver(repoDir string, maxSize int64) *lfsResolver {
+	gitDir := repoDir
+	if fi, err := os.Stat(filepath.Join(repoDir, ".git")); err == nil && fi.IsDir() {
+		gitDir = filepath.Join(repoDir, ".git")
+	}
+	return &lfsResolver{gitDir: gitDir, maxSize: maxSize, fetch: gitLFSSmudge}
+}
+
+// gitLFSSmudge fetches the object of pointer with "git lfs smudge", which
+// downloads it from the LFS server of the repository.
+func gitLFSSmudge(gitDir string, pointer []byte) ([]byte, error) {
+	cmd := exec.Command("git", "--git-dir", gitDir, "lfs", "smudge")
+	cmd.Stdin = bytes.NewReader(pointer)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git lfs smudge: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// resolve returns the object content points to, if content is a Git LFS
+// pointer to an object of at most maxSize bytes. Otherwise, or if the object
+// can't be read or fetched, it returns content.
+func (r *lfsResolver) resolve(name string, content []byte) []byte {
+	p, ok := parseLFSPointer(content)
+	if !ok || p.size > r.maxSize {
+		return content
+	}
+
+	object, err := os.ReadFile(filepath.Join(r.gitDir, "lfs", "objects", p.oid[0:2], p.oid[2:4], p.oid))
+	if err != nil {
+		object, err = r.fetch(r.gitDir, content)
+	}
+	if err == nil && !p.matches(object) {
+		err = fmt.Errorf("object does not match oid %s and size %d", p.oid, p.size)
+	}
+	if err != nil {
+		log.Printf("indexing Git LFS pointer %s instead of its object: %v", name, err)
+		return content
+	}
+	return object
+}
+
+func (p lfsPointer) matches(object []byte) bool {
+	sum := sha256.Sum256(object)
+	return int64(len(object)) == p.size && hex.EncodeToString(sum[:]) == p.oid
+}
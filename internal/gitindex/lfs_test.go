@@ -0,0 +1,147 @@
+package gitindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func lfsPointerFor(object string) (string, string) {
+	sum := sha256.Sum256([]byte(object))
+	oid := hex.EncodeToString(sum[:])
+	return oid, fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(object))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid, pointer := lfsPointerFor("openapi: 3.0.0\n")
+	if p, ok := parseLFSPointer([]byte(pointer)); !ok || p != (lfsPointer{oid: oid, size: 15}) {
+		t.Errorf("got %+v, %v", p, ok)
+	}
+
+	for _, content := range []string{
+		"",
+		"openapi: 3.0.0\n",
+		lfsPointerVersion + "\nsize 15\n",
+		lfsPointerVersion + "\noid sha256:" + oid + "\n",
+		lfsPointerVersion + "\noid sha256:abc\nsize 15\n",
+		lfsPointerVersion + "\noid md5:" + oid + "\nsize 15\n",
+		lfsPointerVersion + "\noid sha256:" + oid + "\nsize -1\n",
+	} {
+		if _, ok := parseLFSPointer([]byte(content)); ok {
+			t.Errorf("parsed %q as a pointer", content)
+		}
+	}
+}
+
+func TestLFSResolverFetch(t *testing.T) {
+	object := "fetched spec\n"
+	_, pointer := lfsPointerFor(object)
+	fetched := 0
+	r := &lfsResolver{gitDir: t.TempDir(), maxSize: 100, fetch: func(gitDir string, p []byte) ([]byte, error) {
+		fetched++
+		return []byte(object), nil
+	}}
+	if got := r.resolve("spec.yaml", []byte(pointer)); string(got) != object || fetched != 1 {
+		t.Errorf("got %q after %d fetches, want the fetched object", got, fetched)
+	}
+
+	// Objects which don't match the pointer, or fail to fetch, are not
+	// indexed.
+	r.fetch = func(string, []byte) ([]byte, error) { return []byte("other\n"), nil }
+	if got := r.resolve("spec.yaml", []byte(pointer)); string(got) != pointer {
+		t.Errorf("got %q for a mismatching object, want the pointer", got)
+	}
+	r.fetch = func(string, []byte) ([]byte, error) { return nil, errors.New("offline") }
+	if got := r.resolve("spec.yaml", []byte(pointer)); string(got) != pointer {
+		t.Errorf("got %q for a failed fetch, want the pointer", got)
+	}
+}
+
+func TestIndexLFS(t *testing.T) {
+	dir := t.TempDir()
+	repoDir := filepath.Join(dir, "repo")
+	runScript(t, repoDir, "git init -b main")
+
+	spec := "openapi: 3.0.0\npaths: /needle\n"
+	specOID, specPointer := lfsPointerFor(spec)
+	_, largePointer := lfsPointerFor("a large needle object, which is above the size limit\n")
+	_, missingPointer := lfsPointerFor("missing needle\n")
+	for name, content := range map[string]string{
+		"api.yaml":    specPointer,
+		"large.bin":   largePointer,
+		"missing.txt": missingPointer,
+	} {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objDir := filepath.Join(repoDir, ".git", "lfs", "objects", specOID[0:2], specOID[2:4])
+	if err := os.MkdirAll(objDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objDir, specOID), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runScript(t, repoDir, `git add . && git -c user.name=Thomas -c user.email=thomas@google.com commit -m initial`)
+
+	search := func(lfsMaxSize int64) map[string]string {
+		t.Helper()
+		indexDir := t.TempDir()
+		opts := Options{
+			RepoDir:    repoDir,
+			Branches:   []string{"main"},
+			LFSMaxSize: lfsMaxSize,
+			BuildOptions: index.Options{
+				RepositoryDescription: zoekt.Repository{Name: "repo"},
+				IndexDir:              indexDir,
+			},
+		}
+		if _, err := IndexGitRepo(opts); err != nil {
+			t.Fatal(err)
+		}
+		searcher, err := shards.NewDirectorySearcher(indexDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer searcher.Close()
+
+		res, err := searcher.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{Whole: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{}
+		for _, f := range res.Files {
+			files[f.FileName] = string(f.Content)
+		}
+		return files
+	}
+
+	want := map[string]string{
+		"api.yaml":    specPointer,
+		"large.bin":   largePointer,
+		"missing.txt": missingPointer,
+	}
+	if diff := cmp.Diff(want, search(0)); diff != "" {
+		t.Errorf("without LFS resolution (-want +got):\n%s", diff)
+	}
+
+	// The spec is resolved from the local LFS store. The large object is
+	// above the limit, and the missing one can't be fetched without a
+	// remote.
+	want["api.yaml"] = spec
+	if diff := cmp.Diff(want, search(int64(len(spec)))); diff != "" {
+		t.Errorf("with LFS resolution (-want +got):\n%s", diff)
+	}
+}
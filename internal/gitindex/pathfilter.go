@@ -0,0 +1,117 @@
+package gitindex
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/ignore"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// The path filters of a repository are read from the zoekt.include-paths and
+// zoekt.exclude-paths git config settings, separated by commas, unless they
+// are set in the Options. The filters used are recorded in the RawConfig of
+// the repository under the same keys.
+const (
+	includePathsKey = "include-paths"
+	excludePathsKey = "exclude-paths"
+)
+
+// pathFilter selects the files to index, eg. the subtrees of a monorepo which
+// are checked out sparsely.
+type pathFilter struct {
+	include, exclude *ignore.Matcher
+}
+
+// newPathFilter creates a filter for the glob patterns include and exclude,
+// which follow the rules of the patterns of ignore files. If include is
+// empty, all files which are not excluded are included.
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	f := &pathFilter{}
+	var err error
+	if len(include) > 0 {
+		f.include, err = ignore.ParseIgnoreFile(strings.NewReader(strings.Join(include, "\n")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.exclude, err = ignore.ParseIgnoreFile(strings.NewReader(strings.Join(exclude, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Include returns true if the file path passes the filter.
+func (f *pathFilter) Include(path string) bool {
+	if f.include != nil && !f.include.Match(path) {
+		return false
+	}
+	return !f.exclude.Match(path)
+}
+
+// splitPaths splits the comma separated patterns of a config setting.
+func splitPaths(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// setPathFilters fills in the path filters of opts from the config of repo,
+// if they are unset, and records them in the RawConfig of the repository.
+func setPathFilters(opts *Options, repo *git.Repository) error {
+	if len(opts.IncludePaths) == 0 && len(opts.ExcludePaths) == 0 {
+		cfg, err := repo.Config()
+		if err != nil {
+			return err
+		}
+		sec := cfg.Raw.Section("zoekt")
+		opts.IncludePaths = splitPaths(sec.Options.Get(includePathsKey))
+		opts.ExcludePaths = splitPaths(sec.Options.Get(excludePathsKey))
+	}
+
+	desc := &opts.BuildOptions.RepositoryDescription
+
+	delete(desc.RawConfig, includePathsKey)
+	delete(desc.RawConfig, excludePathsKey)
+	if len(opts.IncludePaths) == 0 && len(opts.ExcludePaths) == 0 {
+		return nil
+	}
+	if desc.RawConfig == nil {
+		desc.RawConfig = map[string]string{}
+	}
+	if len(opts.IncludePaths) > 0 {
+		desc.RawConfig[includePathsKey] = strings.Join(opts.IncludePaths, ",")
+	}
+	if len(opts.ExcludePaths) > 0 {
+		desc.RawConfig[excludePathsKey] = strings.Join(opts.ExcludePaths, ",")
+	}
+	return nil
+}
+
+// pathFiltersEqual returns true if the path filters recorded for the
+// repositories are the same.
+func pathFiltersEqual(a, b *zoekt.Repository) bool {
+	for _, key := range []string{includePathsKey, excludePathsKey} {
+		if !slices.Equal(splitPaths(a.RawConfig[key]), splitPaths(b.RawConfig[key])) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathFiltersChanged returns true if the path filters of the repository
+// differ from the ones recorded in its index, so the index has other files.
+func pathFiltersChanged(opts *index.Options) bool {
+	existing, _, ok, err := opts.FindRepositoryMetadata()
+	if err != nil || !ok {
+		return false
+	}
+	return !pathFiltersEqual(existing, &opts.RepositoryDescription)
+}
@@ -0,0 +1,115 @@
+package gitindex
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitTransport is an http.RoundTripper which retries the requests a
+// code host API rejected because of its rate limit. It waits as long as the
+// response asks for with Retry-After or X-RateLimit-Reset, and otherwise
+// backs off exponentially from MinBackoff to MaxBackoff.
+type RateLimitTransport struct {
+	// Base is the transport making the requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewRateLimitTransport returns a RateLimitTransport wrapping base with
+// defaults suitable for the APIs of the code hosts we mirror.
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	return &RateLimitTransport{
+		Base:       base,
+		MaxRetries: 8,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Hour,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		if err != nil || attempt >= t.MaxRetries || !rateLimited(resp) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		wait := t.backoff(resp, attempt, time.Now())
+		log.Printf("rate limited by %s, retrying in %v", req.URL.Host, wait)
+		io.Copy(io.Discard, resp.Body) // nolint:errcheck
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// rateLimited returns whether resp rejects a request because of a rate
+// limit. GitHub and Gitea answer 403 rather than 429 once the limit is
+// exhausted, so we also look at the remaining requests.
+func rateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		if resp.Header.Get("Retry-After") != "" {
+			return true
+		}
+		for _, h := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+			if v := resp.Header.Get(h); v != "" {
+				return v == "0"
+			}
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait at now before retrying the request
+// rejected by resp.
+func (t *RateLimitTransport) backoff(resp *http.Response, attempt int, now time.Time) time.Duration {
+	wait := t.MinBackoff << attempt
+	if wait <= 0 || wait > t.MaxBackoff {
+		wait = t.MaxBackoff
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			wait = time.Duration(secs) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil {
+			wait = at.Sub(now)
+		}
+	} else if v := resp.Header.Get("X-RateLimit-Reset"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			wait = time.Unix(secs, 0).Sub(now)
+		}
+	}
+	return min(max(wait, t.MinBackoff), t.MaxBackoff)
+}
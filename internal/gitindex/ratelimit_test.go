@@ -0,0 +1,88 @@
+package gitindex
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch calls.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Write(body) // nolint:errcheck
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &RateLimitTransport{
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("got %d %q, want 200 \"hello\"", resp.StatusCode, body)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+
+	// A forbidden request which is not rate limited is not retried.
+	calls.Store(0)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.WriteHeader(http.StatusForbidden)
+	})
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || calls.Load() != 1 {
+		t.Errorf("got %d after %d requests, want 403 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	tr := &RateLimitTransport{MinBackoff: time.Second, MaxBackoff: time.Hour}
+	now := time.Unix(1000, 0)
+
+	for _, tc := range []struct {
+		header  http.Header
+		attempt int
+		want    time.Duration
+	}{
+		{http.Header{}, 0, time.Second},
+		{http.Header{}, 3, 8 * time.Second},
+		{http.Header{}, 40, time.Hour},
+		{http.Header{"Retry-After": {"30"}}, 0, 30 * time.Second},
+		{http.Header{"Retry-After": {now.Add(time.Minute).UTC().Format(http.TimeFormat)}}, 0, time.Minute},
+		{http.Header{"X-Ratelimit-Reset": {strconv.Itoa(1000 + 120)}}, 0, 2 * time.Minute},
+		{http.Header{"X-Ratelimit-Reset": {strconv.Itoa(1000 + 24*3600)}}, 0, time.Hour},
+		{http.Header{"X-Ratelimit-Reset": {"500"}}, 0, time.Second},
+	} {
+		if got := tr.backoff(&http.Response{Header: tc.header}, tc.attempt, now); got != tc.want {
+			t.Errorf("%v attempt %d: got %v, want %v", tc.header, tc.attempt, got, tc.want)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package json
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/reposearch"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -14,22 +16,42 @@ import (
 // take. This is the same default used by Sourcegraph.
 const defaultTimeout = 20 * time.Second
 
-func JSONServer(searcher zoekt.Searcher) http.Handler {
-	s := jsonSearcher{searcher}
+// JSONServer serves the JSON API for searcher. Queries are parsed with
+// rewriter, and searches may call the query templates of templates. Both may
+// be nil.
+func JSONServer(searcher zoekt.Searcher, rewriter *query.Rewriter, templates *query.Templates) http.Handler {
+	s := jsonSearcher{Searcher: searcher, Rewriter: rewriter, Templates: templates}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/search", s.jsonSearch)
 	mux.HandleFunc("/list", s.jsonList)
+	mux.HandleFunc("/count", s.jsonCount)
+	mux.HandleFunc("/document", s.jsonDocument)
+	mux.HandleFunc("/contents", s.jsonContents)
+	mux.HandleFunc("/repo/metadata", s.jsonRepoMetadata)
+	mux.HandleFunc("/repo/search", s.jsonRepoSearch)
 	return mux
 }
 
 type jsonSearcher struct {
-	Searcher zoekt.Searcher
+	Searcher  zoekt.Searcher
+	Rewriter  *query.Rewriter
+	Templates *query.Templates
 }
 
 type jsonSearchArgs struct {
 	Q       string
 	RepoIDs *[]uint32
 	Opts    *zoekt.SearchOptions
+
+	// Template is the name of a query template to search for instead of Q.
+	// Args are the arguments of its parameters.
+	Template string
+	Args     map[string]string
+
+	// Hint describes the room the client has for results. If set, it
+	// replaces the display limits and context lines of Opts, see
+	// zoekt.SearchOptions.ApplyDisplayHint.
+	Hint *zoekt.DisplayHint
 }
 
 type jsonSearchReply struct {
@@ -45,6 +67,69 @@ type jsonListReply struct {
 	List *zoekt.RepoList
 }
 
+type jsonCountArgs struct {
+	Q    string
+	Opts *zoekt.CountOptions
+}
+
+type jsonCountReply struct {
+	Count *zoekt.CountResult
+}
+
+type jsonDocumentArgs struct {
+	Repo   string
+	Path   string
+	Branch string
+
+	// Q is the query whose matches are returned with the document. It is
+	// optional.
+	Q string
+}
+
+type jsonDocumentReply struct {
+	Document *zoekt.DocumentResult
+}
+
+type jsonContentsArgs struct {
+	Repo   string
+	Path   string
+	Branch string
+	Ranges []zoekt.LineRange
+}
+
+type jsonContentsReply struct {
+	Contents *zoekt.ContentsResult
+}
+
+type jsonRepoMetadataArgs struct {
+	Repo string
+	zoekt.RepositoryMetadataUpdate
+}
+
+type jsonRepoMetadataReply struct {
+	Repository *zoekt.Repository
+}
+
+// defaultRepoSearchLimit is the number of repositories returned by
+// /repo/search without a limit.
+const defaultRepoSearchLimit = 20
+
+type jsonRepoSearchArgs struct {
+	// Q is the text matched against the names, topics and descriptions of
+	// the repositories.
+	Q string
+
+	// Filter is an optional query restricting the repositories, eg.
+	// "archived:no".
+	Filter string
+
+	Limit int
+}
+
+type jsonRepoSearchReply struct {
+	Repos []reposearch.Result
+}
+
 func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	w.Header().Add("Content-Type", "application/json")
@@ -60,6 +145,17 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if searchArgs.Template != "" {
+		if searchArgs.Q != "" {
+			jsonError(w, http.StatusBadRequest, "query and template are mutually exclusive")
+			return
+		}
+		searchArgs.Q, err = s.Templates.Expand(searchArgs.Template, searchArgs.Args)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	if searchArgs.Q == "" {
 		jsonError(w, http.StatusBadRequest, "missing query")
 		return
@@ -67,8 +163,15 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	if searchArgs.Opts == nil {
 		searchArgs.Opts = &zoekt.SearchOptions{}
 	}
+	if searchArgs.Hint != nil {
+		if searchArgs.Hint.Lines < 0 || searchArgs.Hint.MaxBytes < 0 {
+			jsonError(w, http.StatusBadRequest, "hint must not be negative")
+			return
+		}
+		searchArgs.Opts.ApplyDisplayHint(*searchArgs.Hint)
+	}
 
-	q, err := query.Parse(searchArgs.Q)
+	q, err := s.Rewriter.Parse(searchArgs.Q)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
@@ -160,7 +263,7 @@ func (s *jsonSearcher) jsonList(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	query, err := query.Parse(listArgs.Q)
+	query, err := s.Rewriter.Parse(listArgs.Q)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
@@ -178,3 +281,235 @@ func (s *jsonSearcher) jsonList(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 }
+
+func (s *jsonSearcher) jsonCount(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	countArgs := jsonCountArgs{}
+	err := json.NewDecoder(req.Body).Decode(&countArgs)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if countArgs.Q == "" {
+		jsonError(w, http.StatusBadRequest, "missing query")
+		return
+	}
+
+	q, err := s.Rewriter.Parse(countArgs.Q)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	count, err := zoekt.Count(ctx, s.Searcher, q, countArgs.Opts)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		jsonError(w, http.StatusNotImplemented, err.Error())
+		return
+	case err != nil:
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonCountReply{count})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *jsonSearcher) jsonDocument(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	docArgs := jsonDocumentArgs{}
+	err := json.NewDecoder(req.Body).Decode(&docArgs)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if docArgs.Repo == "" || docArgs.Path == "" {
+		jsonError(w, http.StatusBadRequest, "missing repo or path")
+		return
+	}
+
+	var q query.Q
+	if docArgs.Q != "" {
+		q, err = s.Rewriter.Parse(docArgs.Q)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	doc, err := zoekt.Document(ctx, s.Searcher, docArgs.Repo, docArgs.Path, docArgs.Branch, q)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if doc == nil {
+		jsonError(w, http.StatusNotFound, "document not found")
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonDocumentReply{doc})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *jsonSearcher) jsonContents(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	contentsArgs := jsonContentsArgs{}
+	err := json.NewDecoder(req.Body).Decode(&contentsArgs)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if contentsArgs.Repo == "" || contentsArgs.Path == "" {
+		jsonError(w, http.StatusBadRequest, "missing repo or path")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	contents, err := zoekt.Contents(ctx, s.Searcher, contentsArgs.Repo, contentsArgs.Path, contentsArgs.Branch, contentsArgs.Ranges)
+	if errors.Is(err, zoekt.ErrInvalidLineRange) {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if contents == nil {
+		jsonError(w, http.StatusNotFound, "document not found")
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonContentsReply{contents})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *jsonSearcher) jsonRepoSearch(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	args := jsonRepoSearchArgs{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if args.Limit < 0 {
+		jsonError(w, http.StatusBadRequest, "limit must not be negative")
+		return
+	}
+	if args.Limit == 0 {
+		args.Limit = defaultRepoSearchLimit
+	}
+
+	var filter query.Q = &query.Const{Value: true}
+	if args.Filter != "" {
+		filter, err = s.Rewriter.Parse(args.Filter)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	list, err := s.Searcher.List(ctx, filter, nil)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	repos := reposearch.Rank(list.Repos, args.Q, time.Now())
+	if len(repos) > args.Limit {
+		repos = repos[:args.Limit]
+	}
+
+	err = json.NewEncoder(w).Encode(jsonRepoSearchReply{repos})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (s *jsonSearcher) jsonRepoMetadata(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	args := jsonRepoMetadataArgs{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if args.Repo == "" {
+		jsonError(w, http.StatusBadRequest, "missing repo")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	repo, err := zoekt.UpdateRepositoryMetadata(ctx, s.Searcher, args.Repo, &args.RepositoryMetadataUpdate)
+	switch {
+	case errors.Is(err, zoekt.ErrRepositoryNotFound):
+		jsonError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, zoekt.ErrInvalidMetadataUpdate):
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, errors.ErrUnsupported):
+		jsonError(w, http.StatusNotImplemented, err.Error())
+		return
+	case err != nil:
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonRepoMetadataReply{repo})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
@@ -2,6 +2,7 @@ package json_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"math"
@@ -39,7 +40,7 @@ func TestClientServer(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(zjson.JSONServer(mock))
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
 	defer ts.Close()
 
 	searchBody, err := json.Marshal(struct{ Q string }{Q: searchQuery})
@@ -100,7 +101,7 @@ func TestClientServerWithRepoIDsProvided(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(zjson.JSONServer(mock))
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
 	defer ts.Close()
 
 	searchBody := "{\"Q\":\"hello\",\"RepoIDs\":[1,3,5,7]}"
@@ -137,7 +138,7 @@ func TestClientServerWithEmptyRepoIDsProvided(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(zjson.JSONServer(mock))
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
 	defer ts.Close()
 
 	searchBody := "{\"Q\":\"hello\",\"RepoIDs\":[]}"
@@ -175,7 +176,7 @@ func TestProgressNotEncodedInSearch(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(zjson.JSONServer(mock))
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
 	defer ts.Close()
 
 	searchBody, err := json.Marshal(struct{ Q string }{Q: searchQuery})
@@ -193,6 +194,373 @@ func TestProgressNotEncodedInSearch(t *testing.T) {
 	}
 }
 
+type optsSearcher struct {
+	*mockSearcher.MockSearcher
+	opts *zoekt.SearchOptions
+}
+
+func (s *optsSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if !opts.EstimateDocCount {
+		s.opts = opts
+	}
+	return s.MockSearcher.Search(ctx, q, opts)
+}
+
+func TestSearchDisplayHint(t *testing.T) {
+	s := &optsSearcher{MockSearcher: &mockSearcher.MockSearcher{
+		WantSearch:   mustParse("hello"),
+		SearchResult: &zoekt.SearchResult{},
+	}}
+	ts := httptest.NewServer(zjson.JSONServer(s, nil, nil))
+	defer ts.Close()
+
+	post := func(body string) int {
+		t.Helper()
+		r, err := http.Post(ts.URL+"/search", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	if code := post(`{"Q": "hello", "Opts": {"MaxDocDisplayCount": 1000, "NumContextLines": 5}, "Hint": {"Lines": 20, "MaxBytes": 5000}}`); code != 200 {
+		t.Fatalf("got status code %d", code)
+	}
+	var want zoekt.SearchOptions
+	want.ApplyDisplayHint(zoekt.DisplayHint{Lines: 20, MaxBytes: 5000})
+	if s.opts.MaxDocDisplayCount != want.MaxDocDisplayCount || s.opts.MaxMatchDisplayCount != want.MaxMatchDisplayCount ||
+		s.opts.NumContextLines != want.NumContextLines || s.opts.MaxDisplayBytes != 5000 {
+		t.Errorf("got options %s, want the limits of %s", s.opts, &want)
+	}
+
+	if code := post(`{"Q": "hello", "Hint": {"Lines": -1}}`); code != http.StatusBadRequest {
+		t.Errorf("got status code %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestSearchTemplate(t *testing.T) {
+	templates, err := query.NewTemplates([]query.Template{
+		{Name: "deprecation_check", Params: []string{"pkg"}, Query: `"import ${pkg}"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockSearcher.MockSearcher{
+		WantSearch:   mustParse(`"import log4j\\.core"`),
+		SearchResult: &zoekt.SearchResult{},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, templates))
+	defer ts.Close()
+
+	for body, wantStatus := range map[string]int{
+		`{"Template": "deprecation_check", "Args": {"pkg": "log4j.core"}}`:             http.StatusOK,
+		`{"Template": "deprecation_check"}`:                                            http.StatusBadRequest,
+		`{"Template": "unknown"}`:                                                      http.StatusBadRequest,
+		`{"Q": "foo", "Template": "deprecation_check", "Args": {"pkg": "log4j.core"}}`: http.StatusBadRequest,
+	} {
+		r, err := http.Post(ts.URL+"/search", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		if r.StatusCode != wantStatus {
+			t.Errorf("%s: got status code %d, want %d: %s", body, r.StatusCode, wantStatus, msg)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("main.go"), mustParse("needle")),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{{
+				Repository: "foo/bar",
+				FileName:   "main.go",
+				Content:    []byte("needle\n"),
+				ChunkMatches: []zoekt.ChunkMatch{{
+					Ranges: []zoekt.Range{{Start: zoekt.Location{ByteOffset: 0}, End: zoekt.Location{ByteOffset: 6}}},
+				}},
+			}},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
+	defer ts.Close()
+
+	r, err := http.Post(ts.URL+"/document", "application/json", bytes.NewBufferString(`{"Repo": "foo/bar", "Path": "main.go", "Q": "needle"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+
+	var reply struct{ Document *zoekt.DocumentResult }
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.DocumentResult{
+		Repository: "foo/bar",
+		FileName:   "main.go",
+		Content:    []byte("needle\n"),
+		Matches:    mock.SearchResult.Files[0].ChunkMatches[0].Ranges,
+	}
+	if !reflect.DeepEqual(reply.Document, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", reply.Document, want)
+	}
+
+	r, err = http.Post(ts.URL+"/document", "application/json", bytes.NewBufferString(`{"Repo": "foo/bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status code %d without path, want %d", r.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestContents(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("main.go")),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{{
+				Repository: "foo/bar",
+				FileName:   "main.go",
+				Content:    []byte("one\ntwo\nthree\n"),
+			}},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock, nil, nil))
+	defer ts.Close()
+
+	r, err := http.Post(ts.URL+"/contents", "application/json", bytes.NewBufferString(`{"Repo": "foo/bar", "Path": "main.go", "Ranges": [{"Start": 2, "End": 5}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+
+	var reply struct{ Contents *zoekt.ContentsResult }
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.ContentsResult{
+		Repository: "foo/bar",
+		FileName:   "main.go",
+		LineCount:  3,
+		Ranges:     []zoekt.LineRangeContent{{Start: 2, End: 3, Content: []byte("two\nthree\n")}},
+	}
+	if !reflect.DeepEqual(reply.Contents, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", reply.Contents, want)
+	}
+
+	for body, wantStatus := range map[string]int{
+		`{"Repo": "foo/bar"}`: http.StatusBadRequest,
+		`{"Repo": "foo/bar", "Path": "main.go", "Ranges": [{"Start": 0, "End": 1}]}`: http.StatusBadRequest,
+	} {
+		r, err := http.Post(ts.URL+"/contents", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != wantStatus {
+			t.Errorf("%s: got status code %d, want %d", body, r.StatusCode, wantStatus)
+		}
+	}
+}
+
+type metadataSearcher struct {
+	*mockSearcher.MockSearcher
+	updates map[string]*zoekt.RepositoryMetadataUpdate
+}
+
+func (s *metadataSearcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	if name != "foo/bar" {
+		return nil, zoekt.ErrRepositoryNotFound
+	}
+	s.updates[name] = u
+	repo := &zoekt.Repository{Name: name}
+	_, err := u.Apply(repo)
+	return repo, err
+}
+
+func TestRepoMetadata(t *testing.T) {
+	s := &metadataSearcher{MockSearcher: &mockSearcher.MockSearcher{}, updates: map[string]*zoekt.RepositoryMetadataUpdate{}}
+	ts := httptest.NewServer(zjson.JSONServer(s, nil, nil))
+	defer ts.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		r, err := http.Post(ts.URL+"/repo/metadata", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Body.Close() })
+		return r
+	}
+
+	r := post(`{"Repo": "foo/bar", "Priority": 5, "Topics": []}`)
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+	var reply struct{ Repository *zoekt.Repository }
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Repository.RawConfig["priority"] != "5" {
+		t.Errorf("got RawConfig %v", reply.Repository.RawConfig)
+	}
+	u := s.updates["foo/bar"]
+	if u.Priority == nil || *u.Priority != 5 || u.Archived != nil || u.Topics == nil || u.BranchOrder != nil {
+		t.Errorf("got update %+v, want only priority and topics set", u)
+	}
+
+	for body, want := range map[string]int{
+		`{"Repo": "other"}`:                            http.StatusNotFound,
+		`{"Priority": 5}`:                              http.StatusBadRequest,
+		`{"Repo": "foo/bar", "Topics": ["a,b"]}`:       http.StatusBadRequest,
+		`{"Repo": "foo/bar", "BranchOrder": ["main"]}`: http.StatusBadRequest,
+	} {
+		if r := post(body); r.StatusCode != want {
+			t.Errorf("%s: got status code %d, want %d", body, r.StatusCode, want)
+		}
+	}
+
+	// Searchers which can't update metadata.
+	ts2 := httptest.NewServer(zjson.JSONServer(&mockSearcher.MockSearcher{}, nil, nil))
+	defer ts2.Close()
+	r2, err := http.Post(ts2.URL+"/repo/metadata", "application/json", bytes.NewBufferString(`{"Repo": "foo/bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.Body.Close()
+	if r2.StatusCode != http.StatusNotImplemented {
+		t.Errorf("got status code %d, want %d", r2.StatusCode, http.StatusNotImplemented)
+	}
+}
+
+func TestRepoSearch(t *testing.T) {
+	s := &mockSearcher.MockSearcher{
+		WantList: &query.Const{Value: true},
+		RepoList: &zoekt.RepoList{Repos: []*zoekt.RepoListEntry{
+			{Repository: zoekt.Repository{Name: "github.com/org/zoekt-tools"}},
+			{Repository: zoekt.Repository{Name: "github.com/org/zoekt"}},
+			{Repository: zoekt.Repository{Name: "github.com/org/other"}},
+		}},
+	}
+	ts := httptest.NewServer(zjson.JSONServer(s, nil, nil))
+	defer ts.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		r, err := http.Post(ts.URL+"/repo/search", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Body.Close() })
+		return r
+	}
+
+	r := post(`{"Q": "zoekt"}`)
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+	var reply struct {
+		Repos []struct {
+			Repository *zoekt.Repository
+			Matched    []string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Repos) != 2 || reply.Repos[0].Repository.Name != "github.com/org/zoekt" || !reflect.DeepEqual(reply.Repos[0].Matched, []string{"name"}) {
+		t.Errorf("got %+v, want github.com/org/zoekt and github.com/org/zoekt-tools", reply.Repos)
+	}
+
+	reply.Repos = nil
+	if err := json.NewDecoder(post(`{"Limit": 1}`).Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Repos) != 1 || reply.Repos[0].Repository.Name != "github.com/org/other" {
+		t.Errorf("got %+v, want only the first repository by name", reply.Repos)
+	}
+
+	for body, want := range map[string]int{
+		`{"Limit": -1}`:        http.StatusBadRequest,
+		`{"Filter": "repo:("}`: http.StatusBadRequest,
+		// The filter is passed to List, which only expects the query
+		// matching everything.
+		`{"Filter": "repo:foo"}`: http.StatusInternalServerError,
+	} {
+		if r := post(body); r.StatusCode != want {
+			t.Errorf("%s: got status code %d, want %d", body, r.StatusCode, want)
+		}
+	}
+}
+
+type countSearcher struct {
+	*mockSearcher.MockSearcher
+	q    query.Q
+	opts *zoekt.CountOptions
+}
+
+func (s *countSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	s.q, s.opts = q, opts
+	return &zoekt.CountResult{MatchCount: 3, FileCount: 2, RepoCount: 1, Estimated: opts.Estimate}, nil
+}
+
+func TestCount(t *testing.T) {
+	s := &countSearcher{MockSearcher: &mockSearcher.MockSearcher{}}
+	ts := httptest.NewServer(zjson.JSONServer(s, nil, nil))
+	defer ts.Close()
+
+	r, err := http.Post(ts.URL+"/count", "application/json", bytes.NewBufferString(`{"Q": "needle", "Opts": {"Estimate": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+
+	var reply struct{ Count *zoekt.CountResult }
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.CountResult{MatchCount: 3, FileCount: 2, RepoCount: 1, Estimated: true}
+	if !reflect.DeepEqual(reply.Count, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", reply.Count, want)
+	}
+	if s.q.String() != mustParse("needle").String() || !s.opts.Estimate {
+		t.Errorf("got query %s and options %+v", s.q, s.opts)
+	}
+
+	// Searchers which can't count.
+	ts2 := httptest.NewServer(zjson.JSONServer(&mockSearcher.MockSearcher{}, nil, nil))
+	defer ts2.Close()
+	r2, err := http.Post(ts2.URL+"/count", "application/json", bytes.NewBufferString(`{"Q": "needle"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.Body.Close()
+	if r2.StatusCode != http.StatusNotImplemented {
+		t.Errorf("got status code %d, want %d", r2.StatusCode, http.StatusNotImplemented)
+	}
+}
+
 func mustParse(s string) query.Q {
 	q, err := query.Parse(s)
 	if err != nil {
@@ -14,6 +14,9 @@ type MockSearcher struct {
 
 	WantList query.Q
 	RepoList *zoekt.RepoList
+
+	WantDefinitions string
+	DefinitionList  []zoekt.Definition
 }
 
 func (s *MockSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
@@ -30,6 +33,13 @@ func (s *MockSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOpti
 	return s.RepoList, nil
 }
 
+func (s *MockSearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	if name != s.WantDefinitions {
+		return nil, fmt.Errorf("got name %s != %s", name, s.WantDefinitions)
+	}
+	return s.DefinitionList, nil
+}
+
 func (*MockSearcher) Close() {}
 
 func (*MockSearcher) String() string {
@@ -0,0 +1,369 @@
+// Package peers routes searches between replicas of zoekt-webserver which
+// serve the same shards. Each search is forwarded to the replica owning its
+// query on a consistent hash ring of the replicas, so repeated searches for
+// the same query hit the replica whose page cache and ngram selection cache are
+// already warm for it, instead of warming the caches of every replica.
+//
+// Routing is best effort: a search is served locally if it was forwarded by
+// another replica, if its owner is unreachable, or if the owner sheds it
+// because its admission queue is full.
+package peers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grafana/regexp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/grpc/capabilities"
+	"github.com/sourcegraph/zoekt/grpc/propagator"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/experiments"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// ForwardedKey is the gRPC metadata key of searches forwarded by a peer. Its
+// value is the address of the peer. Forwarded searches are always served
+// locally, so they are never forwarded twice. The key is only accepted from
+// a connection of the peer it names, so clients can't make a replica serve
+// searches it doesn't own.
+const ForwardedKey = "zoekt-peer-forwarded"
+
+// peerAddrsTTL is how long the addresses a peer's host resolves to are
+// cached.
+const peerAddrsTTL = time.Minute
+
+var metricSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_peer_searches_total",
+	Help: "The number of searches by how they were routed between peers.",
+}, []string{
+	// "owned" (served locally because this replica owns the query),
+	// "received" (served locally for the peer which forwarded it),
+	// "rejected" (ForwardedKey was set by a client which isn't the peer),
+	// "forwarded" (served by the owner), "fallback" (the owner failed, so it
+	// was served locally) or "peer_down" (served locally while the owner is
+	// skipped).
+	"route",
+})
+
+// Options configures a Searcher.
+type Options struct {
+	// Self is the address of this replica. It must be one of Peers.
+	Self string
+
+	// Peers are the gRPC addresses of all the replicas, including this one.
+	// Every replica must have the same list of peers, in any order.
+	Peers []string
+
+	// Backoff is how long the searches of a peer which can't be reached are
+	// served locally before it is tried again.
+	Backoff time.Duration
+
+	// DialOptions are added to the options of the connections to the peers.
+	DialOptions []grpc.DialOption
+}
+
+// Searcher forwards the searches of its streamer to the peers owning their
+// queries. Other calls, like List, are served by the streamer.
+type Searcher struct {
+	zoekt.Streamer
+
+	self    string
+	ring    *Ring
+	backoff time.Duration
+	peers   map[string]*peer
+}
+
+type peer struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client proto.WebserverServiceClient
+
+	mu        sync.Mutex
+	downUntil time.Time
+
+	// ips are the addresses the host of addr resolved to until ipsExpire.
+	ipsMu     sync.Mutex
+	ips       []string
+	ipsExpire time.Time
+}
+
+// New returns a Searcher which serves the searches this replica owns, and
+// those it can't forward, with local.
+func New(local zoekt.Streamer, opts Options) (*Searcher, error) {
+	if !slices.Contains(opts.Peers, opts.Self) {
+		return nil, fmt.Errorf("peers %v don't contain this replica %q", opts.Peers, opts.Self)
+	}
+
+	// Like the webserver, peers propagate the tenant and the baggage of
+	// searches.
+	prop := propagator.Chain(tenant.Propagator{}, experiments.Propagator{}, propagator.NewBaggage(propagator.DefaultBaggageKeys...))
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			propagator.UnaryClientPropagator(prop),
+			capabilities.UnaryClientInterceptor,
+		),
+		grpc.WithChainStreamInterceptor(
+			propagator.StreamClientPropagator(prop),
+			capabilities.StreamClientInterceptor,
+		),
+	}, opts.DialOptions...)
+
+	s := &Searcher{
+		Streamer: local,
+		self:     opts.Self,
+		ring:     NewRing(opts.Peers),
+		backoff:  opts.Backoff,
+		peers:    map[string]*peer{},
+	}
+	for _, addr := range opts.Peers {
+		if addr == opts.Self || s.peers[addr] != nil {
+			continue
+		}
+		// Connections are established lazily, so peers which are still
+		// starting don't fail this one.
+		conn, err := grpc.NewClient(addr, dialOpts...)
+		if err != nil {
+			s.closePeers()
+			return nil, fmt.Errorf("peer %q: %w", addr, err)
+		}
+		s.peers[addr] = &peer{
+			addr:   addr,
+			conn:   conn,
+			client: proto.NewWebserverServiceClient(conn),
+		}
+	}
+	return s, nil
+}
+
+// route returns the peer to forward a search for q to, or nil if it is
+// served locally.
+func (s *Searcher) route(ctx context.Context, q query.Q) *peer {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(ForwardedKey)) > 0 {
+		if s.forwardedByPeer(ctx, md.Get(ForwardedKey)) {
+			metricSearches.WithLabelValues("received").Inc()
+			return nil
+		}
+		metricSearches.WithLabelValues("rejected").Inc()
+	}
+
+	owner := s.ring.Owner(q.String())
+	if owner == s.self {
+		metricSearches.WithLabelValues("owned").Inc()
+		return nil
+	}
+	p := s.peers[owner]
+	if p.isDown() {
+		metricSearches.WithLabelValues("peer_down").Inc()
+		return nil
+	}
+	return p
+}
+
+// forwardedByPeer returns true if the search of ctx, whose ForwardedKey is
+// from, comes from a connection of the peer from names.
+func (s *Searcher) forwardedByPeer(ctx context.Context, from []string) bool {
+	if len(from) != 1 {
+		return false
+	}
+	p := s.peers[from[0]]
+	if p == nil {
+		return false
+	}
+	remote, ok := grpcpeer.FromContext(ctx)
+	if !ok || remote.Addr == nil {
+		return false
+	}
+	ip, _, err := net.SplitHostPort(remote.Addr.String())
+	if err != nil {
+		return false
+	}
+	return slices.Contains(p.addrs(ctx), ip)
+}
+
+// addrs returns the IP addresses the host of p resolves to.
+func (p *peer) addrs(ctx context.Context) []string {
+	p.ipsMu.Lock()
+	defer p.ipsMu.Unlock()
+	if time.Now().Before(p.ipsExpire) {
+		return p.ips
+	}
+	host, _, err := net.SplitHostPort(p.addr)
+	if err != nil {
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		log.Printf("peers: resolving %s: %v", p.addr, err)
+		return nil
+	}
+	p.ips, p.ipsExpire = ips, time.Now().Add(peerAddrsTTL)
+	return ips
+}
+
+// failed records that forwarding to p failed with err. Peers which are
+// unavailable, eg. because they are shutting down, are skipped for the
+// backoff. Peers which shed the search get the next search again.
+func (s *Searcher) failed(p *peer, err error) {
+	metricSearches.WithLabelValues("fallback").Inc()
+	if status.Code(err) != codes.Unavailable {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.downUntil) {
+		return
+	}
+	log.Printf("peers: skipping %s for %v: %v", p.addr, s.backoff, err)
+	p.downUntil = time.Now().Add(s.backoff)
+}
+
+func (p *peer) isDown() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.downUntil)
+}
+
+func (s *Searcher) outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, ForwardedKey, s.self)
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	p := s.route(ctx, q)
+	if p == nil {
+		return s.Streamer.Search(ctx, q, opts)
+	}
+
+	resp, err := p.client.Search(s.outgoing(ctx), &proto.SearchRequest{
+		Query: query.QToProto(q),
+		Opts:  opts.ToProto(),
+	})
+	if err == nil {
+		metricSearches.WithLabelValues("forwarded").Inc()
+		return zoekt.SearchResultFromProto(resp, nil, nil)
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	s.failed(p, err)
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	p := s.route(ctx, q)
+	if p == nil {
+		return s.Streamer.StreamSearch(ctx, q, opts, sender)
+	}
+
+	sent, err := p.streamSearch(s.outgoing(ctx), q, opts, sender)
+	if err == nil {
+		metricSearches.WithLabelValues("forwarded").Inc()
+		return nil
+	}
+	// Once the owner sent results, serving the search again would send
+	// them twice.
+	if ctx.Err() != nil || sent {
+		return err
+	}
+	s.failed(p, err)
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+// streamSearch sends the results of the search by p to sender. It returns
+// whether it sent any.
+func (p *peer) streamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := p.client.StreamSearch(ctx, &proto.StreamSearchRequest{
+		Request: &proto.SearchRequest{
+			Query: query.QToProto(q),
+			Opts:  opts.ToProto(),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	sent := false
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+		sr, err := zoekt.SearchResultFromStreamProto(resp, nil, nil)
+		if err != nil {
+			return sent, err
+		}
+		sender.Send(sr)
+		sent = true
+	}
+}
+
+// StreamList implements zoekt.ListStreamer.
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *Searcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher. Counts are served locally.
+func (s *Searcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s *Searcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	return zoekt.UpdateRepositoryMetadata(ctx, s.Streamer, name, u)
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s *Searcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	return zoekt.ShardFiles(ctx, s.Streamer, repos)
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *Searcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	return zoekt.OpenShardFile(ctx, s.Streamer, name)
+}
+
+func (s *Searcher) closePeers() {
+	for _, p := range s.peers {
+		p.conn.Close()
+	}
+}
+
+// Close closes the connections to the peers and the streamer.
+func (s *Searcher) Close() {
+	s.closePeers()
+	s.Streamer.Close()
+}
+
+func (s *Searcher) String() string {
+	return fmt.Sprintf("peers(%s, %s)", s.self, s.Streamer)
+}
@@ -0,0 +1,172 @@
+package peers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/cmd/zoekt-webserver/grpc/server"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// replica is a searcher whose results name it.
+type replica struct {
+	name string
+}
+
+func (r *replica) result() *zoekt.SearchResult {
+	return &zoekt.SearchResult{Files: []zoekt.FileMatch{{Repository: r.name, FileName: "main.go"}}}
+}
+
+func (r *replica) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return r.result(), nil
+}
+
+func (r *replica) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sender.Send(r.result())
+	return nil
+}
+
+func (r *replica) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{}, nil
+}
+
+func (r *replica) Close()         {}
+func (r *replica) String() string { return r.name }
+
+// startReplica serves the gRPC API of a replica, and returns its address.
+func startReplica(t *testing.T, name string) (string, *grpc.Server) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	proto.RegisterWebserverServiceServer(gs, server.NewServer(&replica{name: name}))
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+	return l.Addr().String(), gs
+}
+
+// queryOwnedBy returns a query which r assigns to owner.
+func queryOwnedBy(t *testing.T, r *Ring, owner string) query.Q {
+	t.Helper()
+	for i := range 1000 {
+		q := &query.Substring{Pattern: fmt.Sprintf("needle%d", i), Content: true}
+		if r.Owner(q.String()) == owner {
+			return q
+		}
+	}
+	t.Fatalf("found no query owned by %s", owner)
+	return nil
+}
+
+func repositories(sr *zoekt.SearchResult) []string {
+	var repos []string
+	for _, f := range sr.Files {
+		repos = append(repos, f.Repository)
+	}
+	return repos
+}
+
+func TestSearcher(t *testing.T) {
+	remote, gs := startReplica(t, "remote")
+	self := "127.0.0.1:1"
+
+	s, err := New(&replica{name: "local"}, Options{
+		Self:    self,
+		Peers:   []string{self, remote},
+		Backoff: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	search := func(ctx context.Context, q query.Q) []string {
+		t.Helper()
+		sr, err := s.Search(ctx, q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repositories(sr)
+	}
+	streamSearch := func(ctx context.Context, q query.Q) []string {
+		t.Helper()
+		var repos []string
+		err := s.StreamSearch(ctx, q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+			repos = append(repos, repositories(sr)...)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}
+
+	owned := queryOwnedBy(t, s.ring, self)
+	forwarded := queryOwnedBy(t, s.ring, remote)
+	fromAddr := func(addr string) context.Context {
+		t.Helper()
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return grpcpeer.NewContext(ctx, &grpcpeer.Peer{Addr: tcpAddr})
+	}
+	forwardedBy := func(ctx context.Context, peer string) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(ForwardedKey, peer))
+	}
+	// The connections of a peer come from another port than the one it
+	// listens on.
+	remoteHost, _, _ := net.SplitHostPort(remote)
+	received := forwardedBy(fromAddr(net.JoinHostPort(remoteHost, "40000")), remote)
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		q    query.Q
+		want string
+	}{
+		{name: "owned", ctx: ctx, q: owned, want: "local"},
+		{name: "forwarded", ctx: ctx, q: forwarded, want: "remote"},
+		{name: "received", ctx: received, q: forwarded, want: "local"},
+		{name: "without connection", ctx: forwardedBy(ctx, remote), q: forwarded, want: "remote"},
+		{name: "from a client", ctx: forwardedBy(fromAddr("10.1.2.3:40000"), remote), q: forwarded, want: "remote"},
+		{name: "unknown peer", ctx: forwardedBy(fromAddr("127.0.0.1:40000"), "127.0.0.1:2"), q: forwarded, want: "remote"},
+	} {
+		if got := search(tc.ctx, tc.q); len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%s: Search served by %v, want %s", tc.name, got, tc.want)
+		}
+		if got := streamSearch(tc.ctx, tc.q); len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%s: StreamSearch served by %v, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	// Once the owner is gone, its searches are served locally, and it is
+	// skipped for the backoff.
+	gs.Stop()
+	if got := search(ctx, forwarded); len(got) != 1 || got[0] != "local" {
+		t.Errorf("Search served by %v without the owner, want local", got)
+	}
+	if !s.peers[remote].isDown() {
+		t.Error("unreachable peer is not skipped")
+	}
+	if got := streamSearch(ctx, forwarded); len(got) != 1 || got[0] != "local" {
+		t.Errorf("StreamSearch served by %v without the owner, want local", got)
+	}
+}
+
+func TestNewWithoutSelf(t *testing.T) {
+	if _, err := New(&replica{name: "local"}, Options{Self: "a:6070", Peers: []string{"b:6070"}}); err == nil {
+		t.Fatal("New succeeded without this replica in the peers")
+	}
+}
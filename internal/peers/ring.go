@@ -0,0 +1,63 @@
+package peers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points of each peer on the ring. With fewer
+// points, the share of the keys of each peer varies a lot.
+const virtualNodes = 128
+
+// Ring assigns keys to peers by consistent hashing: each peer owns the keys
+// which hash to just before one of its points on the ring. When a peer is
+// added or removed, only the keys of its points move. Every replica with the
+// same list of peers assigns each key to the same peer, regardless of the
+// order of the list.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash uint64
+	peer string
+}
+
+// NewRing returns the ring of peers.
+func NewRing(peers []string) *Ring {
+	r := &Ring{points: make([]point, 0, len(peers)*virtualNodes)}
+	for _, p := range peers {
+		for i := range virtualNodes {
+			r.points = append(r.points, point{hash: hash(p + "#" + strconv.Itoa(i)), peer: p})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		// Break ties the same way on every replica.
+		return r.points[i].peer < r.points[j].peer
+	})
+	return r
+}
+
+// Owner returns the peer owning key, or "" if the ring has no peers.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].peer
+}
+
+// hash must be the same on every replica, so it can't be seeded per process.
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,44 @@
+package peers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	peers := []string{"a:6070", "b:6070", "c:6070"}
+	r := NewRing(peers)
+	reversed := NewRing([]string{"c:6070", "b:6070", "a:6070"})
+
+	keys := make([]string, 3000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("needle%d", i)
+	}
+
+	counts := map[string]int{}
+	for _, k := range keys {
+		owner := r.Owner(k)
+		if got := reversed.Owner(k); got != owner {
+			t.Fatalf("%s: owner depends on the order of the peers: %s != %s", k, owner, got)
+		}
+		counts[owner]++
+	}
+	for _, p := range peers {
+		// Each peer should own about a third of the keys.
+		if counts[p] < len(keys)/5 {
+			t.Errorf("%s owns %d of %d keys", p, counts[p], len(keys))
+		}
+	}
+
+	// Removing a peer only moves its keys.
+	smaller := NewRing([]string{"a:6070", "b:6070"})
+	for _, k := range keys {
+		if owner := r.Owner(k); owner != "c:6070" && smaller.Owner(k) != owner {
+			t.Fatalf("%s moved from %s to %s", k, owner, smaller.Owner(k))
+		}
+	}
+
+	if got := NewRing(nil).Owner("needle"); got != "" {
+		t.Errorf("empty ring has owner %q", got)
+	}
+}
@@ -0,0 +1,210 @@
+// Package querylog records the searches a webserver serves as JSON lines, so
+// they can be replayed against another index with zoekt-replay.
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sourcegraph/zoekt"
+	querypb "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Entry is a logged search.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// Tenant is the ID of the tenant which searched, or 0 if the search
+	// had no tenant.
+	Tenant int `json:"tenant,omitempty"`
+
+	// Query is the query in its readable form. Q holds the query itself,
+	// encoded as a protobuf, because the readable form can't always be
+	// parsed back.
+	Query   string               `json:"query"`
+	Q       []byte               `json:"q"`
+	Options *zoekt.SearchOptions `json:"options"`
+
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Stats    *zoekt.Stats  `json:"stats,omitempty"`
+
+	// Files are the first results, as repository/file name.
+	Files []string `json:"files,omitempty"`
+
+	// Stream is set for streaming searches. Their Files are in the order
+	// they were sent rather than ranked.
+	Stream bool `json:"stream,omitempty"`
+}
+
+// Search returns the query and options of the logged search.
+func (e *Entry) Search() (query.Q, *zoekt.SearchOptions, error) {
+	var p querypb.Q
+	if err := proto.Unmarshal(e.Q, &p); err != nil {
+		return nil, nil, fmt.Errorf("query %q: %w", e.Query, err)
+	}
+	q, err := query.QFromProto(&p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query %q: %w", e.Query, err)
+	}
+	opts := e.Options
+	if opts == nil {
+		opts = &zoekt.SearchOptions{}
+	}
+	return q, opts, nil
+}
+
+// Logger writes the entries of the searches it samples to a writer.
+type Logger struct {
+	// Sample is the fraction of searches which are logged.
+	Sample float64
+
+	// Slow searches, which take at least Slow, are logged regardless of
+	// Sample. If zero, only Sample decides.
+	Slow time.Duration
+
+	// MaxFiles is the number of results logged per search.
+	MaxFiles int
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// DefaultMaxFiles is the default Logger.MaxFiles.
+const DefaultMaxFiles = 100
+
+// NewLogger returns a Logger which logs all searches to w.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{
+		Sample:   1,
+		MaxFiles: DefaultMaxFiles,
+		enc:      json.NewEncoder(w),
+	}
+}
+
+// Search is a search which is being logged.
+type Search struct {
+	l     *Logger
+	start time.Time
+	q     query.Q
+	opts  *zoekt.SearchOptions
+	e     Entry
+}
+
+// Start starts logging a search. The methods of Search are no-ops if l is
+// nil, so callers don't need to check whether logging is enabled.
+func (l *Logger) Start(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) *Search {
+	if l == nil {
+		return nil
+	}
+	s := &Search{l: l, start: time.Now(), q: q, opts: opts}
+	if t, err := tenant.FromContext(ctx); err == nil {
+		s.e.Tenant = t.ID()
+	}
+	return s
+}
+
+// StartStream is Start for streaming searches.
+func (l *Logger) StartStream(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) *Search {
+	s := l.Start(ctx, q, opts)
+	if s != nil {
+		s.e.Stream = true
+	}
+	return s
+}
+
+// AddFiles records the file matches of a (partial) result.
+func (s *Search) AddFiles(files []zoekt.FileMatch) {
+	if s == nil {
+		return
+	}
+	for _, f := range files {
+		if len(s.e.Files) >= s.l.MaxFiles {
+			return
+		}
+		s.e.Files = append(s.e.Files, f.Repository+"/"+f.FileName)
+	}
+}
+
+// Done logs the search if it is sampled or slow.
+func (s *Search) Done(stats *zoekt.Stats, err error) {
+	if s == nil {
+		return
+	}
+	s.e.Duration = time.Since(s.start)
+	if rand.Float64() >= s.l.Sample && (s.l.Slow == 0 || s.e.Duration < s.l.Slow) {
+		return
+	}
+
+	s.e.Time = s.start
+	s.e.Query = s.q.String()
+	s.e.Stats = stats
+	if err != nil {
+		s.e.Error = err.Error()
+	}
+
+	// The span context belongs to the original request.
+	opts := *s.opts
+	opts.SpanContext = nil
+	s.e.Options = &opts
+
+	// If the query can't be encoded, we still log its readable form.
+	if b, err := encodeQuery(s.q); err == nil {
+		s.e.Q = b
+	}
+
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	_ = s.l.enc.Encode(&s.e)
+}
+
+// encodeQuery returns the protobuf encoding of q. Query nodes without a
+// protobuf representation make QToProto panic, which we turn into an error.
+func encodeQuery(q query.Q) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encoding query: %v", r)
+		}
+	}()
+	return proto.Marshal(query.QToProto(q))
+}
+
+// Reader reads the entries written by a Logger.
+type Reader struct {
+	sc *bufio.Scanner
+}
+
+// NewReader returns a Reader of the entries in r.
+func NewReader(r io.Reader) *Reader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	return &Reader{sc: sc}
+}
+
+// Next returns the next entry, or io.EOF after the last one.
+func (r *Reader) Next() (*Entry, error) {
+	for r.sc.Scan() {
+		if len(r.sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(r.sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		return &e, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,120 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/mockSearcher"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func files(names ...string) []zoekt.FileMatch {
+	var fms []zoekt.FileMatch
+	for _, n := range names {
+		fms = append(fms, zoekt.FileMatch{Repository: "repo", FileName: n})
+	}
+	return fms
+}
+
+func TestLogAndReplay(t *testing.T) {
+	q := query.NewAnd(&query.Substring{Pattern: "needle", Content: true}, &query.Repo{Regexp: regexp.MustCompile("repo")})
+	opts := &zoekt.SearchOptions{MaxDocDisplayCount: 10, SpanContext: map[string]string{"trace": "1"}}
+
+	var buf bytes.Buffer
+	l := NewLogger(&buf)
+	s := l.Start(context.Background(), q, opts)
+	s.AddFiles(files("a.go", "b.go"))
+	s.Done(&zoekt.Stats{FileCount: 2}, nil)
+
+	e, err := NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"repo/a.go", "repo/b.go"}, e.Files); d != "" {
+		t.Errorf("files (-want +got):\n%s", d)
+	}
+	if e.Options.MaxDocDisplayCount != 10 || e.Options.SpanContext != nil {
+		t.Errorf("got options %+v", e.Options)
+	}
+	gotQ, _, err := e.Search()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotQ.String() != q.String() {
+		t.Errorf("got query %s, want %s", gotQ, q)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		files   []string
+		changed bool
+		added   []string
+		removed []string
+	}{
+		{name: "same", files: []string{"a.go", "b.go"}},
+		{name: "reordered", files: []string{"b.go", "a.go"}, changed: true},
+		{name: "added", files: []string{"a.go", "b.go", "c.go"}, changed: true, added: []string{"repo/c.go"}},
+		{name: "removed", files: []string{"b.go"}, changed: true, removed: []string{"repo/a.go"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			searcher := &mockSearcher.MockSearcher{
+				WantSearch:   q,
+				SearchResult: &zoekt.SearchResult{Files: files(tc.files...)},
+			}
+			rp, err := ReplayEntry(context.Background(), searcher, e, DefaultMaxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rp.Error != "" {
+				t.Fatal(rp.Error)
+			}
+			if got := rp.Changed(DefaultMaxFiles); got != tc.changed {
+				t.Errorf("Changed() = %v, want %v", got, tc.changed)
+			}
+			if d := cmp.Diff(tc.added, rp.Added); d != "" {
+				t.Errorf("added (-want +got):\n%s", d)
+			}
+			if d := cmp.Diff(tc.removed, rp.Removed); d != "" {
+				t.Errorf("removed (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestLoggerSample(t *testing.T) {
+	q := &query.Substring{Pattern: "needle"}
+
+	var buf bytes.Buffer
+	l := NewLogger(&buf)
+	l.Sample = 0
+	l.Slow = time.Hour
+	l.Start(context.Background(), q, &zoekt.SearchOptions{}).Done(nil, nil)
+	if buf.Len() > 0 {
+		t.Errorf("logged search which is neither sampled nor slow: %s", buf.String())
+	}
+
+	l.Slow = time.Nanosecond
+	s := l.Start(context.Background(), q, &zoekt.SearchOptions{})
+	time.Sleep(time.Millisecond)
+	s.Done(nil, nil)
+	r := NewReader(&buf)
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("slow search wasn't logged: %v", err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+
+	// A nil Logger doesn't log anything.
+	var nilLogger *Logger
+	s = nilLogger.Start(context.Background(), q, &zoekt.SearchOptions{})
+	s.AddFiles(files("a.go"))
+	s.Done(nil, nil)
+}
@@ -0,0 +1,91 @@
+package querylog
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+// Replay is the result of replaying a logged search.
+type Replay struct {
+	Entry *Entry
+
+	Duration time.Duration
+	Error    string
+	Stats    zoekt.Stats
+
+	// Files are the first results of the replay, like Entry.Files.
+	Files []string
+
+	// Added and Removed are the files which are only in the results of the
+	// replay, or only in the logged results.
+	Added, Removed []string
+}
+
+// ReplayEntry runs the search of e against s. Like the Logger which logged
+// e, it keeps the first maxFiles results, so the results are comparable.
+func ReplayEntry(ctx context.Context, s zoekt.Searcher, e *Entry, maxFiles int) (*Replay, error) {
+	q, opts, err := e.Search()
+	if err != nil {
+		return nil, err
+	}
+	if e.Tenant != 0 {
+		if ctx, err = tenant.ReplayContext(ctx, e.Tenant); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Replay{Entry: e}
+	start := time.Now()
+	sr, err := s.Search(ctx, q, opts)
+	r.Duration = time.Since(start)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if sr != nil {
+		r.Stats = sr.Stats
+		for _, f := range sr.Files[:min(len(sr.Files), maxFiles)] {
+			r.Files = append(r.Files, f.Repository+"/"+f.FileName)
+		}
+	}
+
+	r.Added = difference(r.Files, e.Files)
+	r.Removed = difference(e.Files, r.Files)
+	return r, nil
+}
+
+// Changed returns whether the replay found other results than the logged
+// search: other files, the same files in another order, or an error where
+// there was none or vice versa. The files of streaming searches are logged
+// unordered, so for them only the set of files counts, or only the number
+// of files if the log has just the first ones.
+func (r *Replay) Changed(maxFiles int) bool {
+	if r.Error != r.Entry.Error {
+		return true
+	}
+	if !r.Entry.Stream {
+		return !slices.Equal(r.Files, r.Entry.Files)
+	}
+	if len(r.Entry.Files) >= maxFiles {
+		return r.Entry.Stats != nil && r.Entry.Stats.FileCount != r.Stats.FileCount
+	}
+	return len(r.Added) > 0 || len(r.Removed) > 0
+}
+
+// difference returns the elements of a which are not in b.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+var (
+	metricWarmupSearches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_warmup_searches",
+		Help: "The number of logged searches the warmup replays after startup.",
+	})
+	metricWarmupReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_warmup_searches_replayed_total",
+		Help: "The number of searches the warmup has replayed.",
+	})
+	metricWarmupDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_warmup_duration_seconds",
+		Help: "How long the warmup took, or 0 while it is running.",
+	})
+)
+
+// NewTailReader returns a Reader of the entries in the last maxBytes of f.
+// The entry which starts before them is skipped.
+func NewTailReader(f *os.File, maxBytes int64) (*Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() <= maxBytes {
+		return NewReader(f), nil
+	}
+
+	// Seek to the byte before the tail, so a tail which starts with an
+	// entry doesn't lose it.
+	if _, err := f.Seek(fi.Size()-maxBytes-1, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if _, err := br.ReadBytes('\n'); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return NewReader(br), nil
+}
+
+// TopSearches returns the n searches which were logged most often since
+// since, most frequent first. Searches without a replayable query or which
+// failed are ignored. For each search, the last logged entry is returned.
+func TopSearches(r *Reader, since time.Time, n int) ([]*Entry, error) {
+	type search struct {
+		e     *Entry
+		count int
+	}
+	// Tenants see different results, so their searches are different
+	// searches.
+	type key struct {
+		tenant int
+		q      string
+	}
+	searches := map[key]*search{}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(e.Q) == 0 || e.Error != "" || e.Time.Before(since) {
+			continue
+		}
+		k := key{tenant: e.Tenant, q: string(e.Q)}
+		s, ok := searches[k]
+		if !ok {
+			s = &search{}
+			searches[k] = s
+		}
+		s.e = e
+		s.count++
+	}
+
+	top := make([]*search, 0, len(searches))
+	for _, s := range searches {
+		top = append(top, s)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].e.Time.After(top[j].e.Time)
+	})
+
+	entries := make([]*Entry, 0, min(n, len(top)))
+	for _, s := range top[:min(n, len(top))] {
+		entries = append(entries, s.e)
+	}
+	return entries, nil
+}
+
+// Warmup replays entries against s one after the other, so the pages of the
+// shards which the searches read are in memory before users search them
+// again, eg. after a restart. It stops early once ctx is done. Its progress
+// is exported as metrics.
+func Warmup(ctx context.Context, s zoekt.Searcher, entries []*Entry) {
+	metricWarmupSearches.Set(float64(len(entries)))
+	metricWarmupDuration.Set(0)
+
+	start := time.Now()
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		// The results don't matter, the search only has to read the shards.
+		if _, err := ReplayEntry(ctx, s, e, 0); err != nil {
+			log.Printf("warmup: skipping %q: %v", e.Query, err)
+		}
+		metricWarmupReplayed.Inc()
+	}
+	metricWarmupDuration.Set(time.Since(start).Seconds())
+}
@@ -0,0 +1,111 @@
+package querylog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// countingSearcher counts the searches per query.
+type countingSearcher struct {
+	zoekt.Searcher
+	searches map[string]int
+}
+
+func (s *countingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.searches[q.String()]++
+	return &zoekt.SearchResult{}, nil
+}
+
+func TestTopSearchesAndWarmup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// An old search which is the most frequent, but not recent.
+	old, err := encodeQuery(&query.Substring{Pattern: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for range 5 {
+		if err := enc.Encode(&Entry{Time: time.Now().Add(-48 * time.Hour), Query: "old", Q: old}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := NewLogger(f)
+	for _, tc := range []struct {
+		pattern string
+		n       int
+		err     error
+	}{
+		{pattern: "rare", n: 1},
+		{pattern: "hot", n: 3},
+		{pattern: "warm", n: 2},
+		{pattern: "broken", n: 4, err: errors.New("boom")},
+	} {
+		for range tc.n {
+			l.Start(context.Background(), &query.Substring{Pattern: tc.pattern}, &zoekt.SearchOptions{}).Done(nil, tc.err)
+		}
+	}
+
+	top := func(maxBytes int64) []string {
+		t.Helper()
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewTailReader(f, maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := TopSearches(r, time.Now().Add(-time.Hour), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var queries []string
+		for _, e := range entries {
+			queries = append(queries, e.Query)
+		}
+		return queries
+	}
+
+	if d := cmp.Diff([]string{`substr:"hot"`, `substr:"warm"`}, top(1<<20)); d != "" {
+		t.Errorf("top searches (-want +got):\n%s", d)
+	}
+
+	// The tail starts in the middle of an entry, which is skipped.
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := top(fi.Size() - 10); len(got) != 2 {
+		t.Errorf("got %d top searches from the tail, want 2", len(got))
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := TopSearches(NewReader(f), time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &countingSearcher{searches: map[string]int{}}
+	Warmup(context.Background(), s, entries)
+	want := map[string]int{`substr:"old"`: 1, `substr:"hot"`: 1, `substr:"warm"`: 1, `substr:"rare"`: 1}
+	if d := cmp.Diff(want, s.searches); d != "" {
+		t.Errorf("warmup searches (-want +got):\n%s", d)
+	}
+}
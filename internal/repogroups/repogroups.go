@@ -0,0 +1,259 @@
+// Package repogroups interleaves the ranked results of groups of
+// repositories, so that no group dominates the top results. For example,
+// with a group of first-party repositories of weight 7 and one of
+// third-party repositories of weight 3, about 7 of every 10 top files are
+// first-party as long as both groups have matches left.
+package repogroups
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/grafana/regexp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Group is a group of repositories whose files are interleaved with those of
+// the other groups.
+type Group struct {
+	// Name identifies the group in the debug output of scores.
+	Name string `yaml:"name"`
+
+	// Repos is a regular expression matching the names of the repositories
+	// of the group. A repository belongs to the first group matching it. An
+	// empty Repos matches all repositories, so the last group can hold the
+	// rest of them.
+	Repos string `yaml:"repos"`
+
+	// Weight is the share of the results of the group, relative to the
+	// weights of the other groups.
+	Weight int `yaml:"weight"`
+}
+
+// Load reads the groups from a YAML or JSON file, eg.
+//
+//	groups:
+//	- name: first-party
+//	  repos: ^github\.com/acme/
+//	  weight: 7
+//	- name: third-party
+//	  weight: 3
+func Load(path string) ([]Group, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Groups []Group `yaml:"groups"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg.Groups, nil
+}
+
+type group struct {
+	Group
+	re *regexp.Regexp
+}
+
+// Searcher interleaves the results of the searches of its streamer by the
+// groups of their repositories. The files of repositories in no group come
+// after those of the groups.
+//
+// Since the interleaved order must hold across all results, searches are
+// run without display limits, and streamed searches send their results at
+// once when the search is done. The interleaved files get the scores of the
+// files in their ranks, so that clients which sort by score keep the order.
+type Searcher struct {
+	zoekt.Streamer
+
+	groups []group
+}
+
+// New returns a Searcher which interleaves the results of s by groups.
+func New(s zoekt.Streamer, groups []Group) (*Searcher, error) {
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no repository groups")
+	}
+	seen := map[string]bool{}
+	res := &Searcher{Streamer: s}
+	for _, g := range groups {
+		if g.Name == "" || seen[g.Name] {
+			return nil, fmt.Errorf("group %q: missing or duplicate name", g.Name)
+		}
+		seen[g.Name] = true
+		if g.Weight <= 0 {
+			return nil, fmt.Errorf("group %q: weight must be positive", g.Name)
+		}
+		re, err := regexp.Compile(g.Repos)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", g.Name, err)
+		}
+		res.groups = append(res.groups, group{Group: g, re: re})
+	}
+	return res, nil
+}
+
+// unlimited returns opts without display limits, which are applied after
+// interleaving.
+func unlimited(opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	o := *opts
+	o.MaxDocDisplayCount = 0
+	o.MaxMatchDisplayCount = 0
+	o.MaxDisplayBytes = 0
+	return &o
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	sr, err := s.Streamer.Search(ctx, q, unlimited(opts))
+	if err != nil {
+		return nil, err
+	}
+	s.finish(sr, opts)
+	return sr, nil
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sr := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	err := s.Streamer.StreamSearch(ctx, q, unlimited(opts), zoekt.SenderFunc(func(r *zoekt.SearchResult) {
+		sr.Stats.Add(r.Stats)
+		sr.Progress = r.Progress
+		sr.Files = append(sr.Files, r.Files...)
+		maps.Copy(sr.RepoURLs, r.RepoURLs)
+		maps.Copy(sr.LineFragments, r.LineFragments)
+	}))
+	if err != nil {
+		return err
+	}
+	s.finish(sr, opts)
+	sender.Send(sr)
+	return nil
+}
+
+// finish interleaves the files of sr and applies the display limits of opts.
+func (s *Searcher) finish(sr *zoekt.SearchResult, opts *zoekt.SearchOptions) {
+	index.SortFiles(sr.Files)
+	sr.Files = s.interleave(sr.Files, opts.DebugScore)
+	truncate, _ := index.NewDisplayTruncator(opts)
+	sr.Files, _ = truncate(sr.Files)
+}
+
+// interleave returns the ranked files in the order of a smooth weighted
+// round robin of their groups, keeping the order within each group.
+func (s *Searcher) interleave(files []zoekt.FileMatch, debug bool) []zoekt.FileMatch {
+	if len(files) == 0 {
+		return files
+	}
+
+	// The last bucket holds the files of repositories in no group.
+	buckets := make([][]zoekt.FileMatch, len(s.groups)+1)
+	groupOf := map[string]int{}
+	for _, f := range files {
+		i, ok := groupOf[f.Repository]
+		if !ok {
+			i = slices.IndexFunc(s.groups, func(g group) bool { return g.re.MatchString(f.Repository) })
+			if i < 0 {
+				i = len(s.groups)
+			}
+			groupOf[f.Repository] = i
+		}
+		buckets[i] = append(buckets[i], f)
+	}
+
+	scores := make([]float64, 0, len(files))
+	for _, f := range files {
+		scores = append(scores, f.Score)
+	}
+	slices.SortFunc(scores, func(a, b float64) int { return cmp.Compare(b, a) })
+
+	out := make([]zoekt.FileMatch, 0, len(files))
+	current := make([]int, len(s.groups))
+	for {
+		pick, total := -1, 0
+		for i, g := range s.groups {
+			if len(buckets[i]) == 0 {
+				continue
+			}
+			current[i] += g.Weight
+			total += g.Weight
+			if pick < 0 || current[i] > current[pick] {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		current[pick] -= total
+
+		f := buckets[pick][0]
+		buckets[pick] = buckets[pick][1:]
+		if debug {
+			f.Debug += fmt.Sprintf(", group %s", s.groups[pick].Name)
+		}
+		out = append(out, f)
+	}
+	out = append(out, buckets[len(s.groups)]...)
+
+	// The scores strictly decrease, so that sorting by score, which is not
+	// stable, keeps the order.
+	for i := range out {
+		score := scores[i]
+		if i > 0 && score >= out[i-1].Score {
+			score = math.Nextafter(out[i-1].Score, math.Inf(-1))
+		}
+		out[i].Score = score
+	}
+	return out
+}
+
+// StreamList implements zoekt.ListStreamer.
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *Searcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s *Searcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s *Searcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	return zoekt.UpdateRepositoryMetadata(ctx, s.Streamer, name, u)
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s *Searcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	return zoekt.ShardFiles(ctx, s.Streamer, repos)
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *Searcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	return zoekt.OpenShardFile(ctx, s.Streamer, name)
+}
+
+func (s *Searcher) String() string {
+	return fmt.Sprintf("repogroups(%s)", s.Streamer)
+}
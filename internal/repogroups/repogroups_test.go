@@ -0,0 +1,177 @@
+package repogroups
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// rankedSearcher returns its files, sending them in two halves when
+// streaming.
+type rankedSearcher struct {
+	files []zoekt.FileMatch
+	opts  *zoekt.SearchOptions
+}
+
+func (s *rankedSearcher) result(files []zoekt.FileMatch) *zoekt.SearchResult {
+	return &zoekt.SearchResult{
+		Files:    append([]zoekt.FileMatch(nil), files...),
+		Stats:    zoekt.Stats{FileCount: len(files)},
+		RepoURLs: map[string]string{},
+	}
+}
+
+func (s *rankedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.opts = opts
+	return s.result(s.files), nil
+}
+
+func (s *rankedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	s.opts = opts
+	half := len(s.files) / 2
+	sender.Send(s.result(s.files[half:]))
+	sender.Send(s.result(s.files[:half]))
+	return nil
+}
+
+func (s *rankedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{}, nil
+}
+
+func (s *rankedSearcher) Close()         {}
+func (s *rankedSearcher) String() string { return "ranked" }
+
+func repos(files []zoekt.FileMatch) []string {
+	var res []string
+	for _, f := range files {
+		res = append(res, f.Repository)
+	}
+	return res
+}
+
+func TestSearcher(t *testing.T) {
+	// The first-party files all rank above the third-party ones, and the
+	// vendored files in no group rank first.
+	var files []zoekt.FileMatch
+	for i := range 3 {
+		files = append(files, zoekt.FileMatch{Repository: "vendor/lib", FileName: fmt.Sprintf("v%d.go", i), Score: float64(300 - i)})
+	}
+	for i := range 10 {
+		files = append(files, zoekt.FileMatch{Repository: "acme/app", FileName: fmt.Sprintf("a%d.go", i), Score: float64(200 - i)})
+		files = append(files, zoekt.FileMatch{Repository: "other/lib", FileName: fmt.Sprintf("o%d.go", i), Score: float64(100 - i)})
+	}
+
+	inner := &rankedSearcher{files: files}
+	s, err := New(inner, []Group{
+		{Name: "first-party", Repos: "^acme/", Weight: 7},
+		{Name: "third-party", Repos: "^other/", Weight: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &zoekt.SearchOptions{MaxDocDisplayCount: 10}
+	sr, err := s.Search(context.Background(), &query.Const{Value: true}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.opts.MaxDocDisplayCount != 0 {
+		t.Errorf("searched with MaxDocDisplayCount %d, want no limit", inner.opts.MaxDocDisplayCount)
+	}
+
+	a, o := "acme/app", "other/lib"
+	want := []string{a, o, a, a, a, o, a, a, o, a}
+	if diff := cmp.Diff(want, repos(sr.Files)); diff != "" {
+		t.Fatalf("interleaved repositories (-want +got):\n%s", diff)
+	}
+	if sr.Files[0].FileName != "a0.go" || sr.Files[1].FileName != "o0.go" || sr.Files[2].FileName != "a1.go" {
+		t.Errorf("order within the groups changed: %v", sr.Files[:3])
+	}
+
+	// Sorting by score keeps the interleaved order.
+	sorted := append([]zoekt.FileMatch(nil), sr.Files...)
+	index.SortFiles(sorted)
+	if diff := cmp.Diff(want, repos(sorted)); diff != "" {
+		t.Errorf("sorted by score (-want +got):\n%s", diff)
+	}
+	if sr.Files[0].Score != 300 {
+		t.Errorf("got top score %v, want the top score of all files", sr.Files[0].Score)
+	}
+
+	// Streamed results are sent at once, interleaved the same way. Without
+	// a display limit, the files in no group come last.
+	var sent []*zoekt.SearchResult
+	err = s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(r *zoekt.SearchResult) {
+		sent = append(sent, r)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("sent %d results, want 1", len(sent))
+	}
+	got := repos(sent[0].Files)
+	if diff := cmp.Diff(want, got[:10]); diff != "" {
+		t.Errorf("streamed repositories (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"vendor/lib", "vendor/lib", "vendor/lib"}, got[20:]); diff != "" {
+		t.Errorf("files in no group (-want +got):\n%s", diff)
+	}
+	if sent[0].Stats.FileCount != len(files) {
+		t.Errorf("got FileCount %d, want %d", sent[0].Stats.FileCount, len(files))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "groups.yaml")
+	if err := os.WriteFile(fn, []byte(`
+groups:
+- name: first-party
+  repos: ^github\.com/acme/
+  weight: 7
+- name: rest
+  weight: 3
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := Load(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Group{
+		{Name: "first-party", Repos: `^github\.com/acme/`, Weight: 7},
+		{Name: "rest", Weight: 3},
+	}
+	if diff := cmp.Diff(want, groups); diff != "" {
+		t.Errorf("groups (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(fn, []byte("groups:\n- name: a\n  weigth: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(fn); err == nil {
+		t.Error("loaded a group with an unknown field")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, groups := range [][]Group{
+		nil,
+		{{Name: "a", Weight: 0}},
+		{{Name: "", Weight: 1}},
+		{{Name: "a", Weight: 1}, {Name: "a", Weight: 1}},
+		{{Name: "a", Repos: "(", Weight: 1}},
+	} {
+		if _, err := New(&rankedSearcher{}, groups); err == nil {
+			t.Errorf("New(%v) succeeded", groups)
+		}
+	}
+}
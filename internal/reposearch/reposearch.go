@@ -0,0 +1,196 @@
+// Package reposearch ranks repositories by how well their name, topics and
+// description match a text, for repository pickers. Unlike file searches,
+// which match the contents and paths of documents, it only looks at the
+// metadata of repositories, and breaks ties by priority and recency.
+//
+// The topics are the comma separated "topics" of Repository.RawConfig, and
+// the description is its "description", eg. from the zoekt.topics and
+// zoekt.description git config of the indexed repository.
+package reposearch
+
+import (
+	"cmp"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// The fields of a repository a text can match, as listed in Result.Matched.
+const (
+	FieldName        = "name"
+	FieldTopics      = "topics"
+	FieldDescription = "description"
+)
+
+// Scores of the ways a term can match a repository. A term adds the highest
+// score of the ways it matches.
+const (
+	scoreExactName       = 10 // the name or its last element
+	scoreNamePrefix      = 6  // a prefix of the last element of the name
+	scoreNameWord        = 4  // the start of a word of the name
+	scoreNameSubstring   = 2  // anywhere in the name
+	scoreExactTopic      = 3  // a topic
+	scoreTopicPrefix     = 1.5
+	scoreDescriptionWord = 1 // the start of a word of the description
+	scoreDescriptionText = 0.5
+)
+
+const (
+	// priorityWeight scales the logarithm of the priority of a repository.
+	priorityWeight = 0.5
+
+	// recencyWeight is the score of a repository committed to right now. It
+	// halves every recencyHalfLife.
+	recencyWeight   = 2
+	recencyHalfLife = 180 * 24 * time.Hour
+)
+
+// Result is a repository matching a text.
+type Result struct {
+	Repository *zoekt.Repository
+
+	// Score is the sum of the scores of the terms of the text, and of the
+	// priority and the recency of the repository.
+	Score float64
+
+	// Matched are the fields matching terms of the text, in the order of
+	// FieldName, FieldTopics and FieldDescription.
+	Matched []string `json:",omitempty"`
+}
+
+// Rank returns the repositories of entries matching all the whitespace
+// separated terms of text, case-insensitively, ranked by decreasing score.
+// If text has no terms, all repositories match and are ranked by priority
+// and recency. now is the time recency is relative to.
+func Rank(entries []*zoekt.RepoListEntry, text string, now time.Time) []Result {
+	terms := strings.Fields(strings.ToLower(text))
+
+	results := make([]Result, 0, len(entries))
+	for _, e := range entries {
+		r, ok := score(e, terms, now)
+		if ok {
+			results = append(results, r)
+		}
+	}
+	slices.SortFunc(results, func(a, b Result) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			strings.Compare(a.Repository.Name, b.Repository.Name),
+		)
+	})
+	return results
+}
+
+func score(e *zoekt.RepoListEntry, terms []string, now time.Time) (Result, bool) {
+	repo := &e.Repository
+	name := strings.ToLower(repo.Name)
+	topics := Topics(repo)
+	description := strings.ToLower(repo.RawConfig["description"])
+
+	r := Result{Repository: repo}
+	matched := map[string]bool{}
+	for _, term := range terms {
+		nameScore := scoreName(name, term)
+		topicScore := scoreTopics(topics, term)
+		descriptionScore := scoreText(description, term)
+		best := max(nameScore, topicScore, descriptionScore)
+		if best == 0 {
+			return Result{}, false
+		}
+		r.Score += best
+		matched[FieldName] = matched[FieldName] || nameScore > 0
+		matched[FieldTopics] = matched[FieldTopics] || topicScore > 0
+		matched[FieldDescription] = matched[FieldDescription] || descriptionScore > 0
+	}
+	for _, f := range []string{FieldName, FieldTopics, FieldDescription} {
+		if matched[f] {
+			r.Matched = append(r.Matched, f)
+		}
+	}
+
+	if p := repo.GetPriority(); p > 0 {
+		r.Score += priorityWeight * math.Log1p(p)
+	}
+	last := repo.LatestCommitDate
+	if last.IsZero() {
+		last = e.IndexMetadata.IndexTime
+	}
+	if !last.IsZero() {
+		age := max(now.Sub(last), 0)
+		r.Score += recencyWeight * math.Exp2(-float64(age)/float64(recencyHalfLife))
+	}
+	return r, true
+}
+
+// Topics returns the topics of repo in lower case.
+func Topics(repo *zoekt.Repository) []string {
+	var topics []string
+	for _, t := range strings.Split(repo.RawConfig["topics"], ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, strings.ToLower(t))
+		}
+	}
+	return topics
+}
+
+func scoreName(name, term string) float64 {
+	base := path.Base(name)
+	switch {
+	case name == term || base == term:
+		return scoreExactName
+	case strings.HasPrefix(base, term):
+		return scoreNamePrefix
+	case hasWordPrefix(name, term):
+		return scoreNameWord
+	case strings.Contains(name, term):
+		return scoreNameSubstring
+	}
+	return 0
+}
+
+func scoreTopics(topics []string, term string) float64 {
+	s := 0.0
+	for _, t := range topics {
+		if t == term {
+			return scoreExactTopic
+		}
+		if strings.HasPrefix(t, term) {
+			s = scoreTopicPrefix
+		}
+	}
+	return s
+}
+
+func scoreText(text, term string) float64 {
+	switch {
+	case hasWordPrefix(text, term):
+		return scoreDescriptionWord
+	case strings.Contains(text, term):
+		return scoreDescriptionText
+	}
+	return 0
+}
+
+// hasWordPrefix returns true if term occurs in s at the start of s or after
+// a character which isn't a letter or digit.
+func hasWordPrefix(s, term string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], term)
+		if j < 0 {
+			return false
+		}
+		i += j
+		if i == 0 || !isWordByte(s[i-1]) {
+			return true
+		}
+		i++
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c >= 0x80
+}
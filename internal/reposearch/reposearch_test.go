@@ -0,0 +1,91 @@
+package reposearch
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func entry(t *testing.T, name string, rawConfig map[string]string, latest time.Time) *zoekt.RepoListEntry {
+	t.Helper()
+	// The priority is only parsed when decoding repositories.
+	b, err := json.Marshal(zoekt.Repository{Name: name, RawConfig: rawConfig, LatestCommitDate: latest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &zoekt.RepoListEntry{}
+	if err := json.Unmarshal(b, &e.Repository); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func names(results []Result) []string {
+	var ns []string
+	for _, r := range results {
+		ns = append(ns, r.Repository.Name)
+	}
+	return ns
+}
+
+func TestRank(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*zoekt.RepoListEntry{
+		entry(t, "github.com/org/zoekt", map[string]string{"topics": "search,Go"}, now.AddDate(0, -1, 0)),
+		entry(t, "github.com/other/zoekt-tools", nil, now),
+		entry(t, "github.com/org/search-ui", map[string]string{"description": "The web UI of code search"}, time.Time{}),
+		entry(t, "github.com/org/researcher", map[string]string{"priority": "100"}, time.Time{}),
+		entry(t, "github.com/org/unrelated", nil, now),
+	}
+
+	for _, tc := range []struct {
+		text string
+		want []string
+	}{
+		// An exact name ranks above a prefix, even of a recent repository.
+		{"zoekt", []string{"github.com/org/zoekt", "github.com/other/zoekt-tools"}},
+		{"ZOEKT tools", []string{"github.com/other/zoekt-tools"}},
+		// Name prefixes, topics, words of names and substrings.
+		{"search", []string{"github.com/org/search-ui", "github.com/org/zoekt", "github.com/org/researcher"}},
+		{"go search", []string{"github.com/org/zoekt"}},
+		{"web", []string{"github.com/org/search-ui"}},
+		{"nothing", nil},
+	} {
+		if got := names(Rank(entries, tc.text, now)); !slices.Equal(got, tc.want) {
+			t.Errorf("Rank(%q): got %v, want %v", tc.text, got, tc.want)
+		}
+	}
+
+	// Without terms, repositories are ranked by priority and recency.
+	got := names(Rank(entries, " ", now))
+	want := []string{"github.com/org/researcher", "github.com/org/unrelated", "github.com/other/zoekt-tools", "github.com/org/zoekt", "github.com/org/search-ui"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Rank without terms: got %v, want %v", got, want)
+	}
+
+	r := Rank(entries, "go zoekt", now)
+	if len(r) != 1 || !slices.Equal(r[0].Matched, []string{FieldName, FieldTopics}) {
+		t.Errorf("got %+v, want github.com/org/zoekt matched by name and topics", r)
+	}
+}
+
+func TestHasWordPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		s, term string
+		want    bool
+	}{
+		{"search-ui", "search", true},
+		{"code search", "search", true},
+		{"researcher", "search", false},
+		{"research search", "search", true},
+		{"a/b", "b", true},
+		{"abc", "", true},
+	} {
+		if got := hasWordPrefix(tc.s, tc.term); got != tc.want {
+			t.Errorf("hasWordPrefix(%q, %q): got %v, want %v", tc.s, tc.term, got, tc.want)
+		}
+	}
+}
@@ -34,6 +34,11 @@ func newCollectSender(opts *zoekt.SearchOptions) *collectSender {
 // Send aggregates the new search result by adding it stats and ranking
 // and truncating its files according to the input SearchOptions.
 func (c *collectSender) Send(r *zoekt.SearchResult) {
+	// Progress events describe the search, not its results.
+	if r.IsProgressEvent() {
+		return
+	}
+
 	if c.aggregate == nil {
 		c.aggregate = &zoekt.SearchResult{
 			RepoURLs:      map[string]string{},
@@ -43,9 +48,27 @@ func (c *collectSender) Send(r *zoekt.SearchResult) {
 
 	c.aggregate.Stats.Add(r.Stats)
 
+	// The result set has the files truncated below, so it is added up
+	// separately.
+	if r.ResultSet != nil {
+		if c.aggregate.ResultSet == nil {
+			c.aggregate.ResultSet = &zoekt.ResultSet{}
+		}
+		c.aggregate.ResultSet.Add(r.ResultSet)
+	}
+
 	if len(r.Files) > 0 {
 		c.aggregate.Files = append(c.aggregate.Files, r.Files...)
 
+		// The repository limit is applied before truncating, so the files
+		// it drops make room for files of other repositories. The files
+		// it dropped before are not in the aggregate anymore, so every
+		// dropped match is counted exactly once.
+		if limiter, ok := index.NewRepoMatchLimiter(c.opts); ok {
+			index.SortFiles(c.aggregate.Files)
+			c.aggregate.Files = limiter(c.aggregate.Files, &c.aggregate.Stats)
+		}
+
 		c.aggregate.Files = index.SortAndTruncateFiles(c.aggregate.Files, c.opts)
 
 		for k, v := range r.RepoURLs {
@@ -139,7 +162,9 @@ func newFlushCollectSender(opts *zoekt.SearchOptions, sender zoekt.Sender) (zoek
 
 	return zoekt.SenderFunc(func(event *zoekt.SearchResult) {
 		mu.Lock()
-		if collectSender != nil {
+		// Progress events are sent right away, so clients can show the
+		// progress of the search while we collect.
+		if collectSender != nil && !event.IsProgressEvent() {
 			collectSender.Send(event)
 		} else {
 			sender.Send(event)
@@ -161,6 +186,15 @@ func limitSender(cancel context.CancelFunc, sender zoekt.Sender, truncator index
 	})
 }
 
+// repoLimitSender wraps a sender and drops the matches exceeding the limit of
+// their repository.
+func repoLimitSender(sender zoekt.Sender, limiter index.RepoMatchLimiter) zoekt.Sender {
+	return zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		result.Files = limiter(result.Files, &result.Stats)
+		sender.Send(result)
+	})
+}
+
 func copyFileSender(sender zoekt.Sender) zoekt.Sender {
 	return zoekt.SenderFunc(func(result *zoekt.SearchResult) {
 		copyFiles(result)
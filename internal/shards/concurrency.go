@@ -0,0 +1,137 @@
+package shards
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricSearchShardConcurrency = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "zoekt_search_shard_concurrency",
+	Help: "The number of shards a search searches concurrently",
+})
+
+const (
+	// shortLatencyWeight and longLatencyWeight are the weights of a new
+	// observation in the moving averages of the recent and the usual shard
+	// search latency.
+	shortLatencyWeight = 0.2
+	longLatencyWeight  = 0.01
+
+	// When the recent latency exceeds the usual latency by more than
+	// latencyBackoff, the shards compete for the CPU and we search fewer of
+	// them concurrently. While it stays below latencyProbe, we try searching
+	// one more.
+	latencyBackoff = 1.5
+	latencyProbe   = 1.1
+
+	// memoryBackoff is the fraction of the Go memory limit in use above which
+	// we halve the concurrency.
+	memoryBackoff = 0.9
+)
+
+// concurrencyController adapts the number of shards a search searches
+// concurrently. Searching is mostly CPU bound, so we start at GOMAXPROCS.
+// Because shards also wait for page faults, a big host may do better with
+// more. Under a load spike the concurrent searches compete for the CPU and
+// each of them should use less. The controller notices both from the latency
+// of shard searches: it adds a shard while their latency stays at its usual
+// level and backs off when it rises. It also backs off when the heap gets
+// close to the Go memory limit.
+//
+// A nil controller always returns GOMAXPROCS.
+type concurrencyController struct {
+	min, max int
+
+	// memoryPressure returns the fraction of the memory limit in use, or 0
+	// if there is no limit.
+	memoryPressure func() float64
+
+	mu      sync.Mutex
+	limit   int
+	short   float64 // moving average of the recent latency in seconds
+	long    float64 // moving average of the usual latency in seconds
+	samples int     // number of observations since the last adjustment
+}
+
+func newConcurrencyController(procs int) *concurrencyController {
+	procs = max(procs, 1)
+	metricSearchShardConcurrency.Set(float64(procs))
+	return &concurrencyController{
+		min:            1,
+		max:            2 * procs,
+		memoryPressure: heapMemoryPressure,
+		limit:          procs,
+	}
+}
+
+// Limit returns the number of shards a search should search concurrently.
+func (c *concurrencyController) Limit() int {
+	if c == nil {
+		return runtime.GOMAXPROCS(0)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// Observe records that searching a shard took d. Searches which failed or
+// were canceled should not be observed, since they return early.
+func (c *concurrencyController) Observe(d time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := d.Seconds()
+	if c.long == 0 {
+		c.short, c.long = s, s
+	} else {
+		c.short += shortLatencyWeight * (s - c.short)
+		c.long += longLatencyWeight * (s - c.long)
+	}
+
+	// Adjust once per round of shard searches at the current limit, so that
+	// the averages see the effect of the last adjustment.
+	c.samples++
+	if c.samples < c.limit {
+		return
+	}
+	c.samples = 0
+
+	switch {
+	case c.memoryPressure() > memoryBackoff:
+		c.limit /= 2
+	case c.short > latencyBackoff*c.long:
+		c.limit = c.limit * 3 / 4
+	case c.short < latencyProbe*c.long:
+		c.limit++
+	}
+	c.limit = min(max(c.limit, c.min), c.max)
+	metricSearchShardConcurrency.Set(float64(c.limit))
+}
+
+// heapMemoryPressure returns the fraction of the Go memory limit used by the
+// live heap, or 0 if no limit is set.
+func heapMemoryPressure() float64 {
+	samples := []metrics.Sample{
+		{Name: "/gc/heap/live:bytes"},
+		{Name: "/gc/gomemlimit:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	live, limit := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+	if limit == 0 || limit == math.MaxInt64 {
+		return 0
+	}
+	return float64(live) / float64(limit)
+}
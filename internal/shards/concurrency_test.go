@@ -0,0 +1,50 @@
+package shards
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyController(t *testing.T) {
+	var pressure float64
+	c := newConcurrencyController(4)
+	c.memoryPressure = func() float64 { return pressure }
+
+	observe := func(d time.Duration, n int) {
+		for i := 0; i < n; i++ {
+			c.Observe(d)
+		}
+	}
+
+	// Steady latency probes for more concurrency up to twice the procs.
+	observe(10*time.Millisecond, 100)
+	if got := c.Limit(); got != 8 {
+		t.Fatalf("steady latency: got limit %d, want 8", got)
+	}
+
+	// Rising latency backs off.
+	observe(50*time.Millisecond, 20)
+	if got := c.Limit(); got >= 8 {
+		t.Fatalf("rising latency: got limit %d, want less than 8", got)
+	}
+
+	// Memory pressure halves the limit until it reaches one.
+	pressure = 0.95
+	observe(10*time.Millisecond, 100)
+	if got := c.Limit(); got != 1 {
+		t.Fatalf("memory pressure: got limit %d, want 1", got)
+	}
+
+	// Once the pressure is gone, we probe again.
+	pressure = 0
+	observe(10*time.Millisecond, 100)
+	if got := c.Limit(); got <= 1 {
+		t.Fatalf("after memory pressure: got limit %d, want more than 1", got)
+	}
+
+	var nilController *concurrencyController
+	nilController.Observe(time.Second)
+	if nilController.Limit() < 1 {
+		t.Fatal("nil controller should return GOMAXPROCS")
+	}
+}
@@ -2,6 +2,9 @@ package shards
 
 import (
 	"context"
+	"io"
+
+	"github.com/grafana/regexp"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/tenant"
@@ -97,6 +100,98 @@ func (s *typeRepoSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	return s.Streamer.List(ctx, q, opts)
 }
 
+// StreamList implements zoekt.ListStreamer.
+func (s *typeRepoSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (rl *zoekt.RepoList, err error) {
+	tr, ctx := trace.New(ctx, "typeRepoSearcher.StreamList", "")
+	tr.LazyLog(q, true)
+	tr.LazyPrintf("opts: %s", opts)
+	if tenant.EnforceTenant() {
+		tenant.Log(ctx, tr)
+	}
+	defer func() {
+		if rl != nil {
+			tr.LazyPrintf("crashes=%d stats=%+v", rl.Crashes, rl.Stats)
+		}
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	q, err = s.eval(ctx, tr, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *typeRepoSearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s *typeRepoSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (cr *zoekt.CountResult, err error) {
+	tr, ctx := trace.New(ctx, "typeRepoSearcher.Count", "")
+	tr.LazyLog(q, true)
+	tr.LazyPrintf("opts: %+v", opts)
+	if tenant.EnforceTenant() {
+		tenant.Log(ctx, tr)
+	}
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	q, err = s.eval(ctx, tr, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s *typeRepoSearcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	return zoekt.UpdateRepositoryMetadata(ctx, s.Streamer, name, u)
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s *typeRepoSearcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	return zoekt.ShardFiles(ctx, s.Streamer, repos)
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *typeRepoSearcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	return zoekt.OpenShardFile(ctx, s.Streamer, name)
+}
+
+// FreezeShards implements ShardFreezer.
+func (s *typeRepoSearcher) FreezeShards(frozen bool) {
+	if f, ok := s.Streamer.(ShardFreezer); ok {
+		f.FreezeShards(frozen)
+	}
+}
+
+// ShardsFrozen implements ShardFreezer.
+func (s *typeRepoSearcher) ShardsFrozen() bool {
+	f, ok := s.Streamer.(ShardFreezer)
+	return ok && f.ShardsFrozen()
+}
+
+// WaitUntilReady implements ShardsWaiter.
+func (s *typeRepoSearcher) WaitUntilReady() error {
+	if w, ok := s.Streamer.(ShardsWaiter); ok {
+		return w.WaitUntilReady()
+	}
+	return nil
+}
+
 func (s *typeRepoSearcher) eval(ctx context.Context, tr *trace.Trace, q query.Q) (query.Q, error) {
 	var err error
 	q = query.Map(q, func(q query.Q) query.Q {
@@ -117,3 +117,39 @@ func TestSearchTypeRepo(t *testing.T) {
 		&query.Substring{Pattern: "file"}))
 	wantSingleMatch(res, "f2:8")
 }
+
+func TestSearchHasFile(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-go"},
+			index.Document{Name: "go.mod", Content: []byte("module example.com/go")},
+			index.Document{Name: "main.go", Content: []byte("needle")})),
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-js"},
+			index.Document{Name: "package.json", Content: []byte("{}")},
+			index.Document{Name: "main.js", Content: []byte("needle")})),
+	})
+	searcher := &typeRepoSearcher{ss}
+
+	for qStr, want := range map[string][]string{
+		`has.file:go\.mod needle`:                  {"repo-go"},
+		`-has.file:go\.mod needle`:                 {"repo-js"},
+		`has.content:module needle`:                {"repo-go"},
+		`has.file:package\.json -file:\.js needle`: nil,
+	} {
+		q, err := query.Parse(qStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.Repository)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got repositories %v, want %v", qStr, got, want)
+		}
+	}
+}
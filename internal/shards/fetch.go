@@ -0,0 +1,124 @@
+package shards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+// localShardDir returns the directory of the shards of s, or an error if
+// their files can't be sent.
+func (s *directorySearcher) localShardDir() (string, error) {
+	ls, ok := s.directoryWatcher.storage.(*localStorage)
+	if !ok {
+		return "", fmt.Errorf("fetching shards needs the shards in a local directory: %w", errors.ErrUnsupported)
+	}
+	if tenant.EnforceTenant() {
+		// Compound shards may hold the repositories of several tenants.
+		return "", fmt.Errorf("fetching shards is not supported with tenant enforcement: %w", errors.ErrUnsupported)
+	}
+	return ls.dir, nil
+}
+
+// ShardFiles implements zoekt.ShardFetcher. It returns the loaded shards
+// holding a repository matching repos, their ".meta" files and the manifests
+// listing them. Compound shards are sent whole, with their other
+// repositories.
+func (s *directorySearcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	dir, err := s.localShardDir()
+	if err != nil {
+		return nil, err
+	}
+	ss, ok := s.Streamer.(*shardedSearcher)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support fetching shards: %w", s.Streamer, errors.ErrUnsupported)
+	}
+
+	var names []string
+	ss.mu.Lock()
+	for key, shard := range ss.shards {
+		if slices.ContainsFunc(shard.repos, func(r *zoekt.Repository) bool {
+			return !r.Tombstone && repos.MatchString(r.Name)
+		}) {
+			names = append(names, filepath.Base(key))
+		}
+	}
+	ss.mu.Unlock()
+
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+		if _, err := os.Stat(filepath.Join(dir, name+".meta")); err == nil {
+			selected[name+".meta"] = true
+		}
+	}
+
+	// Content-addressed shards are only loaded if a manifest lists them.
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.manifest"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range manifests {
+		m, err := index.ReadShardManifest(fn)
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(m.Shards, func(name string) bool { return selected[name] }) {
+			selected[filepath.Base(fn)] = true
+		}
+	}
+
+	files := make([]zoekt.ShardFile, 0, len(selected))
+	for name := range selected {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			// Deleted since it was loaded.
+			continue
+		}
+		files = append(files, zoekt.ShardFile{Name: name, Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b zoekt.ShardFile) int { return strings.Compare(a.Name, b.Name) })
+	return files, nil
+}
+
+// isShardFileName returns true if name is the name of a file ShardFiles
+// returns, without directories.
+func isShardFileName(name string) bool {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta") || strings.HasSuffix(name, ".manifest")
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s *directorySearcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	dir, err := s.localShardDir()
+	if err != nil {
+		return nil, zoekt.ShardFile{}, err
+	}
+	if !isShardFileName(name) {
+		return nil, zoekt.ShardFile{}, fmt.Errorf("%q is not a shard file: %w", name, os.ErrNotExist)
+	}
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return nil, zoekt.ShardFile{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, zoekt.ShardFile{}, err
+	}
+	return f, zoekt.ShardFile{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
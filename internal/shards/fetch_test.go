@@ -0,0 +1,76 @@
+package shards
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestShardFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"repo-a", "repo-b"} {
+		b, err := index.NewBuilder(index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{Name: name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile("main.go", []byte("package main")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards, err := filepath.Glob(filepath.Join(dir, "repo-a_*.zoekt"))
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("got shards %v, %v, want one shard of repo-a", shards, err)
+	}
+	shard := filepath.Base(shards[0])
+	if err := os.WriteFile(shards[0]+".meta", []byte(`{"Name": "repo-a"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	ctx := context.Background()
+	files, err := zoekt.ShardFiles(ctx, ss, regexp.MustCompile("^repo-a$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != shard || files[1].Name != shard+".meta" {
+		t.Fatalf("got %v, want %s and its .meta file", files, shard)
+	}
+
+	r, f, err := zoekt.OpenShardFile(ctx, ss, shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != files[0] || int64(len(data)) != f.Size {
+		t.Errorf("got %v with %d bytes, want %v", f, len(data), files[0])
+	}
+
+	for _, name := range []string{"../" + shard, ".hidden.zoekt", "repo-a.txt", ""} {
+		if _, _, err := zoekt.OpenShardFile(ctx, ss, name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("OpenShardFile(%q): got %v, want os.ErrNotExist", name, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package shards
+
+import (
+	"context"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// streamFileNamesFirst streams the search for SearchOptions.FileNamesFirst.
+// The first phase streams the files matching fileNamesQuery(q), which only
+// needs the file names and symbols of the shards. The second phase searches
+// the contents for q at the same time, and sends all of its results, ranked,
+// in a single final event once the first phase is done. The final event has
+// the stats of the second phase, which covers the files of the first, so the
+// events of the first phase don't have stats.
+func (ss *shardedSearcher) streamFileNamesFirst(ctx context.Context, proc *process, loaded loaded, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	// The content phase runs with proc, which may only yield from one
+	// goroutine, so the first phase runs in the timeslices of proc without
+	// yielding.
+	type contentResult struct {
+		final *zoekt.SearchResult
+		done  func()
+		err   error
+	}
+	contentc := make(chan contentResult, 1)
+	go func() {
+		collectSender := newCollectSender(opts)
+		done, err := streamSearch(index.WithCorpusStats(ctx, loaded.corpus), proc, ss.concurrency, q, opts, loaded.shards, collectSender)
+		final, _ := collectSender.Done()
+		contentc <- contentResult{final: final, done: done, err: err}
+	}()
+
+	var err error
+	if fq := query.Simplify(fileNamesQuery(q)); !isConstFalse(fq) {
+		first := zoekt.SenderFunc(func(event *zoekt.SearchResult) {
+			event.Stats = zoekt.Stats{}
+			sender.Send(event)
+		})
+		err = ss.streamResults(ctx, &process{releaseFunc: func() {}}, loaded, fq, opts, first)
+	}
+
+	content := <-contentc
+	defer content.done()
+	if err != nil {
+		return err
+	}
+	if content.err != nil {
+		return content.err
+	}
+
+	final := content.final
+	if final == nil {
+		final = &zoekt.SearchResult{
+			RepoURLs:      map[string]string{},
+			LineFragments: map[string]string{},
+		}
+	}
+	copyFiles(final)
+	final.Final = true
+	sender.Send(final)
+	return nil
+}
+
+// fileNamesQuery returns a query which only matches files matching q, by
+// restricting the content atoms of q to the file names. Atoms which only
+// match content can't match and negated atoms are kept, so the files which
+// match are a subset of the files matching q. Symbol atoms are kept, as they
+// are cheap to evaluate.
+func fileNamesQuery(q query.Q) query.Q {
+	switch s := q.(type) {
+	case *query.And:
+		children := make([]query.Q, len(s.Children))
+		for i, ch := range s.Children {
+			children[i] = fileNamesQuery(ch)
+		}
+		return &query.And{Children: children}
+	case *query.Or:
+		children := make([]query.Q, len(s.Children))
+		for i, ch := range s.Children {
+			children[i] = fileNamesQuery(ch)
+		}
+		return &query.Or{Children: children}
+	case *query.Type:
+		return &query.Type{Child: fileNamesQuery(s.Child), Type: s.Type}
+	case *query.Boost:
+		return &query.Boost{Child: fileNamesQuery(s.Child), Boost: s.Boost}
+	case *query.Budget:
+		return &query.Budget{Child: fileNamesQuery(s.Child), Timeout: s.Timeout}
+	case *query.Substring:
+		if s.FileName {
+			return s
+		}
+		if s.Content {
+			return &query.Const{Value: false}
+		}
+		c := *s
+		c.FileName = true
+		return &c
+	case *query.Regexp:
+		if s.FileName {
+			return s
+		}
+		if s.Content {
+			return &query.Const{Value: false}
+		}
+		c := *s
+		c.FileName = true
+		return &c
+	}
+	// Negations, symbols and the atoms which don't match content.
+	return q
+}
+
+func isConstFalse(q query.Q) bool {
+	c, ok := q.(*query.Const)
+	return ok && !c.Value
+}
@@ -0,0 +1,167 @@
+package shards
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricNUMAPlacement = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_shard_search_numa_placement_total",
+	Help: "The number of shard searches by the NUMA node of the shard and where they ran: local on a worker pinned to the node, busy on the searching goroutine since all workers of the node were busy, or unknown if the node isn't known yet.",
+}, []string{"node", "placement"})
+
+// numaRecheckInterval is how often the NUMA node of a shard is looked up
+// while it isn't known.
+const numaRecheckInterval = 10 * time.Second
+
+// numaPlacement runs shard searches on workers pinned to the CPUs of the NUMA
+// node the pages of the shard were faulted in on. On hosts with several
+// sockets, this saves reading the index across the interconnect between
+// them.
+//
+// A nil placement runs searches on the calling goroutine.
+type numaPlacement struct {
+	pools map[int]chan func()
+}
+
+// numa returns the placement of shard searches, which is nil unless
+// ZOEKTSCHED=numa=1 is set and the host has more than one NUMA node.
+var numa = sync.OnceValue(func() *numaPlacement {
+	if zoektSched["numa"] != 1 {
+		return nil
+	}
+	nodes, err := numaTopology()
+	if err != nil {
+		log.Printf("ZOEKTSCHED=numa=1 specified, but the NUMA topology is unknown: %v", err)
+		return nil
+	}
+	if len(nodes) < 2 {
+		log.Printf("ZOEKTSCHED=numa=1 specified, but there are %d NUMA nodes. Not pinning shard searches.", len(nodes))
+		return nil
+	}
+	log.Printf("ZOEKTSCHED=numa=1 specified. Pinning shard searches to the CPUs of %d NUMA nodes.", len(nodes))
+	return newNUMAPlacement(nodes)
+})
+
+// newNUMAPlacement starts a worker for each CPU of nodes, which maps NUMA
+// nodes to their CPUs, and returns once they are pinned. The workers run
+// until the process exits.
+func newNUMAPlacement(nodes map[int][]int) *numaPlacement {
+	p := &numaPlacement{pools: make(map[int]chan func(), len(nodes))}
+	var pinned sync.WaitGroup
+	for node, cpus := range nodes {
+		tasks := make(chan func())
+		for range cpus {
+			pinned.Add(1)
+			go numaWorker(node, cpus, tasks, pinned.Done)
+		}
+		p.pools[node] = tasks
+	}
+	pinned.Wait()
+	return p
+}
+
+func numaWorker(node int, cpus []int, tasks <-chan func(), pinned func()) {
+	// The thread stays pinned, so it is never handed back to the runtime.
+	runtime.LockOSThread()
+	if err := pinThread(cpus); err != nil {
+		log.Printf("[WARN] pinning shard search worker to the CPUs of NUMA node %d: %v", node, err)
+	}
+	pinned()
+	for f := range tasks {
+		f()
+	}
+}
+
+// run calls f, which searches s, on an idle worker of the NUMA node of s and
+// waits for it to return. If the node isn't known or all its workers are
+// busy, f runs on the calling goroutine. It returns true if f ran on a
+// worker.
+func (p *numaPlacement) run(s *rankedShard, f func()) bool {
+	if p == nil {
+		f()
+		return false
+	}
+	node := s.numaNode()
+	tasks, ok := p.pools[node]
+	if !ok {
+		metricNUMAPlacement.WithLabelValues("unknown", "unknown").Inc()
+		f()
+		return false
+	}
+
+	done := make(chan struct{})
+	select {
+	case tasks <- func() {
+		defer close(done)
+		f()
+	}:
+		metricNUMAPlacement.WithLabelValues(strconv.Itoa(node), "local").Inc()
+		<-done
+		return true
+	default:
+		metricNUMAPlacement.WithLabelValues(strconv.Itoa(node), "busy").Inc()
+		f()
+		return false
+	}
+}
+
+// numaNode returns the NUMA node most of the resident pages of the shard are
+// on, or -1. The pages are faulted in by searches, so until the node is known
+// it is looked up again at most every numaRecheckInterval.
+func (s *rankedShard) numaNode() int {
+	if n := s.numaNodePlusOne.Load(); n > 0 {
+		return int(n - 1)
+	}
+	n, ok := s.Searcher.(interface{ NUMANode() int })
+	if !ok {
+		return -1
+	}
+	now := time.Now().UnixNano()
+	last := s.numaChecked.Load()
+	if last != 0 && now-last < int64(numaRecheckInterval) || !s.numaChecked.CompareAndSwap(last, now) {
+		return -1
+	}
+	node := n.NUMANode()
+	if node >= 0 {
+		s.numaNodePlusOne.Store(int32(node + 1))
+	}
+	return node
+}
+
+// parseCPUList parses a list of CPUs in the format of the cpulist files of
+// sysfs, eg. "0-3,8-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(r, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list %q: %w", s, err)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("invalid CPU list %q: %w", s, err)
+			}
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("invalid CPU list %q: range %s is reversed", s, r)
+		}
+		for c := lo; c <= hi; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package shards
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// numaTopology returns the CPUs of each NUMA node which the process may run
+// on.
+func numaTopology() (map[int][]int, error) {
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return nil, err
+	}
+	dirs, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[int][]int{}
+	for _, dir := range dirs {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		cpus = slices.DeleteFunc(cpus, func(c int) bool { return !allowed.IsSet(c) })
+		if len(cpus) > 0 {
+			nodes[node] = cpus
+		}
+	}
+	return nodes, nil
+}
+
+// pinThread restricts the thread of the calling goroutine, which must be
+// locked to it, to cpus.
+func pinThread(cpus []int) error {
+	var set unix.CPUSet
+	for _, c := range cpus {
+		set.Set(c)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package shards
+
+import "errors"
+
+func numaTopology() (map[int][]int, error) {
+	return nil, errors.New("NUMA placement is only supported on linux")
+}
+
+func pinThread(cpus []int) error {
+	return nil
+}
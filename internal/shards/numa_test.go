@@ -0,0 +1,103 @@
+package shards
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []int
+	}{
+		{"0", []int{0}},
+		{"0-3,8-9\n", []int{0, 1, 2, 3, 8, 9}},
+		{"", nil},
+	} {
+		got, err := parseCPUList(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("parseCPUList(%q): got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"a", "3-1", "1-"} {
+		if _, err := parseCPUList(in); err == nil {
+			t.Errorf("parseCPUList(%q): got no error", in)
+		}
+	}
+}
+
+type numaSearcher struct {
+	zoekt.Searcher
+	node  int
+	calls int
+}
+
+func (s *numaSearcher) NUMANode() int {
+	s.calls++
+	return s.node
+}
+
+func TestNUMAPlacement(t *testing.T) {
+	p := newNUMAPlacement(map[int][]int{7: {0}})
+	placed := func(node, placement string) float64 {
+		return testutil.ToFloat64(metricNUMAPlacement.WithLabelValues(node, placement))
+	}
+
+	// Shards of unknown nodes are searched right away.
+	unknown := &numaSearcher{node: -1}
+	before := placed("unknown", "unknown")
+	ran := false
+	p.run(&rankedShard{Searcher: unknown}, func() { ran = true })
+	if !ran || placed("unknown", "unknown") != before+1 {
+		t.Errorf("got ran=%v, want the search of a shard of an unknown node to run unpinned", ran)
+	}
+	// The unknown node of a shard isn't looked up again right away.
+	s := &rankedShard{Searcher: unknown}
+	p.run(s, func() {})
+	p.run(s, func() {})
+	if unknown.calls != 2 {
+		t.Errorf("got %d lookups, want 2", unknown.calls)
+	}
+
+	// The only worker of the node is busy.
+	known := &numaSearcher{node: 7}
+	shard := &rankedShard{Searcher: known}
+	release := make(chan struct{})
+	p.pools[7] <- func() { <-release }
+	before = placed("7", "busy")
+	ran = false
+	if p.run(shard, func() { ran = true }) || !ran || placed("7", "busy") != before+1 {
+		t.Errorf("got ran=%v, want the search to run unpinned while the worker is busy", ran)
+	}
+	close(release)
+
+	// Once the worker is idle again, it searches the shard.
+	before = placed("7", "local")
+	pinned := false
+	for i := 0; i < 100 && !pinned; i++ {
+		pinned = p.run(shard, func() {})
+		time.Sleep(time.Millisecond)
+	}
+	if !pinned || placed("7", "local") != before+1 {
+		t.Error("want the search to run on the worker of node 7")
+	}
+	// The node of a shard is cached once known.
+	if known.calls != 1 {
+		t.Errorf("got %d lookups, want 1", known.calls)
+	}
+
+	// A nil placement runs searches on the calling goroutine.
+	ran = false
+	(*numaPlacement)(nil).run(shard, func() { ran = true })
+	if !ran {
+		t.Error("nil placement didn't run the search")
+	}
+}
@@ -37,6 +37,13 @@ type scheduler interface {
 //	queries to run in the larger interactive queue for Xs before moving them
 //	to the batch queue.
 //
+//	fixedconcurrency: setting fixedconcurrency=1 will search GOMAXPROCS shards
+//	of a search concurrently instead of adapting the number to the load.
+//
+//	numa: setting numa=1 will search shards on workers pinned to the CPUs of
+//	the NUMA node the pages of the shard are on, if the host has several
+//	nodes. See numaPlacement.
+//
 // Note: these tuneables should be regarded as temporary while we experiment
 // with our scheduler in production. They should not be relied upon in
 // customers/sourcegraph.com in a permanent manor (only temporary).
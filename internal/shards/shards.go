@@ -16,6 +16,7 @@ package shards
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -28,6 +29,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
+	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/index"
 	"golang.org/x/sync/semaphore"
 
@@ -36,6 +39,7 @@ import (
 	"go.uber.org/atomic"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
 	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/query"
@@ -126,6 +130,10 @@ var (
 		Name: "zoekt_search_regexps_considered_total",
 		Help: "Total number of times regexp was called on files that we evaluated",
 	})
+	metricSearchBudgetsExhaustedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_budgets_exhausted_total",
+		Help: "Total number of shards in which a query ran out of its time budget",
+	})
 
 	metricListRunning = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "zoekt_list_running",
@@ -185,6 +193,24 @@ type rankedShard struct {
 	//
 	// repos is nil only if that call failed.
 	repos []*zoekt.Repository
+
+	// corpus are the statistics of the documents in the shard, if the
+	// searcher has them.
+	corpus index.CorpusStats
+
+	// repoBloom is the bloom filter of the repositories of a compound shard,
+	// or nil.
+	repoBloom *index.RepoBloom
+
+	// repoIDs are the IDs of repos for compound shards whose repos all have
+	// an ID, and nil otherwise.
+	repoIDs *roaring.Bitmap
+
+	// numaNodePlusOne is one more than the NUMA node of the shard, or 0 if it
+	// isn't known yet. numaChecked is when it was last looked up, in unix
+	// nanoseconds. See numaNode.
+	numaNodePlusOne atomic.Int32
+	numaChecked     atomic.Int64
 }
 
 // loaded stores the state we compute when updating the state of shards from
@@ -197,6 +223,11 @@ type loaded struct {
 	// ready is true if sharded searcher has finished loading all initial
 	// shards on startup.
 	ready bool
+
+	// corpus are the statistics of the documents of all shards. Shards
+	// score with them, so scores of documents in different shards are
+	// comparable.
+	corpus index.CorpusStats
 }
 
 type shardedSearcher struct {
@@ -206,11 +237,16 @@ type shardedSearcher struct {
 	// pressure.
 	sched scheduler
 
+	// concurrency adapts the number of shards a search searches
+	// concurrently. It is nil if ZOEKTSCHED=fixedconcurrency=1 is set.
+	concurrency *concurrencyController
+
 	mu     sync.Mutex // protects writes to shards
 	shards map[string]*rankedShard
 
 	ready  atomic.Bool
 	ranked atomic.Value
+	corpus atomic.Pointer[index.CorpusStats]
 }
 
 func newShardedSearcher(n int64) *shardedSearcher {
@@ -218,6 +254,9 @@ func newShardedSearcher(n int64) *shardedSearcher {
 		shards: make(map[string]*rankedShard),
 		sched:  newScheduler(n),
 	}
+	if zoektSched["fixedconcurrency"] != 1 {
+		ss.concurrency = newConcurrencyController(runtime.GOMAXPROCS(0))
+	}
 	return ss
 }
 
@@ -237,12 +276,23 @@ func NewDirectorySearcherFast(dir string) (zoekt.Streamer, error) {
 	return newDirectorySearcher(dir, false)
 }
 
+// NewStorageSearcherFast is like NewDirectorySearcherFast, but searches the
+// shards in storage.
+func NewStorageSearcherFast(storage ShardStorage) (zoekt.Streamer, error) {
+	return newStorageSearcher(storage, false)
+}
+
 func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, error) {
+	return newStorageSearcher(&localStorage{dir: dir}, waitUntilReady)
+}
+
+func newStorageSearcher(storage ShardStorage, waitUntilReady bool) (zoekt.Streamer, error) {
 	ss := newShardedSearcher(int64(runtime.GOMAXPROCS(0)))
 	tl := &loader{
-		ss: ss,
+		ss:      ss,
+		storage: storage,
 	}
-	dw, err := newDirectoryWatcher(dir, tl)
+	dw, err := newStorageWatcher(storage, tl)
 	if err != nil {
 		return nil, err
 	}
@@ -261,12 +311,73 @@ func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, erro
 	return &typeRepoSearcher{Streamer: ds}, nil
 }
 
+// ShardFreezer is implemented by the searchers which watch a directory or a
+// storage for shards. While the shards are frozen, the searcher keeps
+// searching the shards it has loaded, and ignores new, changed and deleted
+// shards until it is unfrozen.
+type ShardFreezer interface {
+	FreezeShards(frozen bool)
+	ShardsFrozen() bool
+}
+
+// ShardsWaiter is implemented by the searchers which load the shards in the
+// background, like the ones of NewDirectorySearcherFast.
+type ShardsWaiter interface {
+	// WaitUntilReady blocks until the shards present at startup are loaded.
+	WaitUntilReady() error
+}
+
 type directorySearcher struct {
 	zoekt.Streamer
 
 	directoryWatcher *DirectoryWatcher
 }
 
+// StreamList implements zoekt.ListStreamer.
+func (s *directorySearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s *directorySearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Streamer, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s *directorySearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Streamer, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater. It
+// rewrites the ".meta" files of the shards, which the directory watcher then
+// reloads.
+func (s *directorySearcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	ls, ok := s.directoryWatcher.storage.(*localStorage)
+	if !ok {
+		return nil, fmt.Errorf("metadata updates need the shards in a local directory: %w", errors.ErrUnsupported)
+	}
+	if tenant.EnforceTenant() {
+		// Repository names are only unique within a tenant.
+		return nil, fmt.Errorf("metadata updates are not supported with tenant enforcement: %w", errors.ErrUnsupported)
+	}
+	return index.UpdateRepositoryMetadata(ls.dir, name, u)
+}
+
+// FreezeShards implements ShardFreezer.
+func (s *directorySearcher) FreezeShards(frozen bool) {
+	s.directoryWatcher.Freeze(frozen)
+}
+
+// ShardsFrozen implements ShardFreezer.
+func (s *directorySearcher) ShardsFrozen() bool {
+	return s.directoryWatcher.Frozen()
+}
+
+// WaitUntilReady implements ShardsWaiter.
+func (s *directorySearcher) WaitUntilReady() error {
+	return s.directoryWatcher.WaitUntilReady()
+}
+
 func (s *directorySearcher) Close() {
 	// We need to Stop directoryWatcher first since it calls load/unload on
 	// Searcher.
@@ -275,7 +386,8 @@ func (s *directorySearcher) Close() {
 }
 
 type loader struct {
-	ss *shardedSearcher
+	ss      *shardedSearcher
+	storage ShardStorage
 }
 
 func (tl *loader) load(keys ...string) {
@@ -323,7 +435,7 @@ func (tl *loader) load(keys ...string) {
 			defer sem.Release(1)
 			defer wg.Done()
 
-			shard, err := loadShard(key)
+			shard, err := tl.loadShard(key)
 			if err != nil {
 				metricShardsLoadFailedTotal.Inc()
 				log.Printf("[ERROR] reloading: %s, err %v ", key, err)
@@ -348,6 +460,19 @@ func (tl *loader) drop(keys ...string) {
 		shards[key] = nil
 	}
 	tl.ss.replace(shards)
+
+	for _, key := range keys {
+		tl.storage.Evict(key)
+	}
+}
+
+// loadShard fetches the shard key from the storage and loads it.
+func (tl *loader) loadShard(key string) (zoekt.Searcher, error) {
+	fn, err := tl.storage.Fetch(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	return loadShard(fn)
 }
 
 func (ss *shardedSearcher) String() string {
@@ -408,22 +533,53 @@ func doSelectRepoSet(shards []*rankedShard, and *query.And) ([]*rankedShard, que
 	for i, c := range and.Children {
 		var setSize int
 		var hasRepos func([]*zoekt.Repository) (bool, bool)
+		// repoIDs, if set, returns the IDs of the repos in the set. Sets of IDs
+		// are compared with the IDs of compound shards as bitmaps, which is
+		// much faster for large sets than checking each repo.
+		var repoIDs func() *roaring.Bitmap
+		// mayHaveRepos, if set, returns false if a shard with the bloom
+		// filter has none of the repos in the set. It costs a lookup per repo
+		// in the set, so it is only worth it for sets smaller than the shard.
+		var mayHaveRepos func(*index.RepoBloom) bool
 		switch setQuery := c.(type) {
 		case *query.RepoSet:
 			setSize = len(setQuery.Set)
 			hasRepos = hasReposForPredicate(func(repo *zoekt.Repository) bool {
 				return setQuery.Set[repo.Name]
 			})
+			mayHaveRepos = func(b *index.RepoBloom) bool {
+				for name := range setQuery.Set {
+					if b.MayContainName(name) {
+						return true
+					}
+				}
+				return false
+			}
 		case *query.RepoIDs:
 			setSize = int(setQuery.Repos.GetCardinality())
 			hasRepos = hasReposForPredicate(func(repo *zoekt.Repository) bool {
 				return setQuery.Repos.Contains(repo.ID)
 			})
+			repoIDs = func() *roaring.Bitmap { return setQuery.Repos }
+			mayHaveRepos = func(b *index.RepoBloom) bool {
+				it := setQuery.Repos.Iterator()
+				for it.HasNext() {
+					if b.MayContainID(it.Next()) {
+						return true
+					}
+				}
+				return false
+			}
 		case *query.Repo:
 			setSize = 0
 			hasRepos = hasReposForPredicate(func(repo *zoekt.Repository) bool {
 				return setQuery.Regexp.MatchString(repo.Name)
 			})
+		case query.RawConfig:
+			setSize = 0
+			hasRepos = hasReposForPredicate(func(repo *zoekt.Repository) bool {
+				return index.MatchRawConfig(setQuery, repo.RawConfig)
+			})
 		case *query.BranchesRepos:
 			for _, br := range setQuery.List {
 				setSize += int(br.Repos.GetCardinality())
@@ -437,10 +593,33 @@ func doSelectRepoSet(shards []*rankedShard, and *query.And) ([]*rankedShard, que
 				}
 				return false
 			})
+			repoIDs = sync.OnceValue(func() *roaring.Bitmap {
+				if len(setQuery.List) == 1 {
+					return setQuery.List[0].Repos
+				}
+				bms := make([]*roaring.Bitmap, 0, len(setQuery.List))
+				for _, br := range setQuery.List {
+					bms = append(bms, br.Repos)
+				}
+				return roaring.FastOr(bms...)
+			})
+			mayHaveRepos = func(b *index.RepoBloom) bool {
+				for _, br := range setQuery.List {
+					it := br.Repos.Iterator()
+					for it.HasNext() {
+						if b.MayContainID(it.Next()) {
+							return true
+						}
+					}
+				}
+				return false
+			}
 		default:
 			continue
 		}
 
+		lookups := setSize
+
 		// setSize may be larger than the number of shards we have. The size of
 		// filtered is bounded by min(len(set), len(shards))
 		if setSize > len(shards) {
@@ -451,13 +630,24 @@ func doSelectRepoSet(shards []*rankedShard, and *query.And) ([]*rankedShard, que
 		filteredAll := true
 
 		for _, s := range shards {
-			if s.repos == nil {
+			var any, all bool
+			switch {
+			case s.repos == nil:
 				// repos is nil if we failed to List the shard. This shouldn't
 				// happen, but if it does we don't know what is in it and must search
 				// it without simplifying the query.
 				filtered = append(filtered, s)
 				filteredAll = false
-			} else if any, all := hasRepos(s.repos); any {
+				continue
+			case repoIDs != nil && s.repoIDs != nil:
+				n := repoIDs().AndCardinality(s.repoIDs)
+				any, all = n > 0, n == s.repoIDs.GetCardinality()
+			case mayHaveRepos != nil && s.repoBloom != nil && lookups < len(s.repos) && !mayHaveRepos(s.repoBloom):
+				continue
+			default:
+				any, all = hasRepos(s.repos)
+			}
+			if any {
 				filtered = append(filtered, s)
 				filteredAll = filteredAll && all
 			}
@@ -487,7 +677,7 @@ func doSelectRepoSet(shards []*rankedShard, and *query.And) ([]*rankedShard, que
 		// shard indexData.simplify will simplify to (and true (content baz)) ->
 		// (content baz). This work can be done now once, rather than per shard.
 		switch c := c.(type) {
-		case *query.RepoSet, *query.RepoIDs, *query.Repo:
+		case *query.RepoSet, *query.RepoIDs, *query.Repo, query.RawConfig:
 			and.Children[i] = &query.Const{Value: true}
 			return filtered, query.Simplify(and)
 
@@ -545,7 +735,8 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	start = time.Now()
 
 	loaded := ss.getLoaded()
-	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, collectSender)
+	ctx = index.WithCorpusStats(ctx, loaded.corpus)
+	done, err := streamSearch(ctx, proc, ss.concurrency, q, opts, loaded.shards, collectSender)
 	defer done()
 	if err != nil {
 		return nil, err
@@ -614,12 +805,55 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 		},
 	})
 
+	if opts.FileNamesFirst {
+		return ss.streamFileNamesFirst(ctx, proc, loaded, q, opts, sender)
+	}
+	if opts.Anytime {
+		return ss.streamAnytime(ctx, proc, loaded, q, opts, sender)
+	}
+	return ss.streamResults(ctx, proc, loaded, q, opts, sender)
+}
+
+// streamAnytime searches the loaded shards for q, keeping the best results
+// found so far, and sends them once all shards are searched or the search is
+// canceled. Unlike streamResults, the display limits don't cancel the search
+// once they are reached, since a later shard can have better results.
+func (ss *shardedSearcher) streamAnytime(ctx context.Context, proc *process, loaded loaded, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sender = copyFileSender(sender)
+	collectSender := newCollectSender(opts)
+
+	ctx = index.WithCorpusStats(ctx, loaded.corpus)
+	done, err := streamSearch(ctx, proc, ss.concurrency, q, opts, loaded.shards, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		// Progress events are sent right away, so clients can show the
+		// progress of the search while we collect.
+		if sr.IsProgressEvent() {
+			sender.Send(sr)
+			return
+		}
+		collectSender.Send(sr)
+	}))
+
+	// The results are sent even if the search failed, like streamResults
+	// sends the results of the shards searched before the error.
+	if agg, ok := collectSender.Done(); ok {
+		metricFinalAggregateSize.WithLabelValues(zoekt.FlushReasonFinalFlush.String()).Observe(float64(len(agg.Files)))
+		agg.FlushReason = zoekt.FlushReasonFinalFlush
+		sender.Send(agg)
+	}
+	done()
+
+	return err
+}
+
+// streamResults streams the results of searching the loaded shards for q.
+func (ss *shardedSearcher) streamResults(ctx context.Context, proc *process, loaded loaded, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
 	// Matches flow from the shards up the stack in the following order:
 	//
 	// 1. Search shards
 	// 2. flushCollectSender (aggregate)
-	// 3. limitSender (limit)
-	// 4. copyFileSender (copy)
+	// 3. repoLimitSender (limit per repository)
+	// 4. limitSender (limit)
+	// 5. copyFileSender (copy)
 	//
 	// For streaming, the wrapping has to happen in the inverted order.
 	sender = copyFileSender(sender)
@@ -631,9 +865,14 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 		sender = limitSender(cancel, sender, truncator)
 	}
 
+	if limiter, hasLimit := index.NewRepoMatchLimiter(opts); hasLimit {
+		sender = repoLimitSender(sender, limiter)
+	}
+
 	sender, flush := newFlushCollectSender(opts, sender)
 
-	done, err := streamSearch(ctx, proc, q, opts, shards, sender)
+	ctx = index.WithCorpusStats(ctx, loaded.corpus)
+	done, err := streamSearch(ctx, proc, ss.concurrency, q, opts, loaded.shards, sender)
 
 	// Even though streaming is done, we may have results sitting in a buffer we
 	// need to flush. So we need to send those before calling done.
@@ -651,7 +890,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 // collector can't see. Calling done informs the garbage collector it is free
 // to collect those shards. The caller must call copyFiles on any
 // SearchResults it returns/streams out before calling done.
-func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, sender zoekt.Sender) (done func(), err error) {
+func streamSearch(ctx context.Context, proc *process, concurrency *concurrencyController, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, sender zoekt.Sender) (done func(), err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.streamSearch", "")
 	overallStart := time.Now()
 	metricSearchRunning.Inc()
@@ -688,12 +927,11 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 
 	defer cancel()
 
-	// We set the number of workers to GOMAXPROCS, or the number of shards,
-	// whichever is smaller.
-	workers := runtime.GOMAXPROCS(0)
-	if workers > len(shards) {
-		workers = len(shards)
-	}
+	// We set the number of workers to the concurrency limit, or the number of
+	// shards, whichever is smaller.
+	workers := min(concurrency.Limit(), len(shards))
+
+	placement := numa()
 
 	type result struct {
 		priority float64
@@ -720,7 +958,17 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		go func() {
 			defer wg.Done()
 			for s := range search {
-				sr, err := searchOneShard(ctx, s, q, opts)
+				start := time.Now()
+				var (
+					sr  *zoekt.SearchResult
+					err error
+				)
+				placement.run(s, func() {
+					sr, err = searchOneShard(ctx, s, q, opts)
+				})
+				if err == nil && ctx.Err() == nil {
+					concurrency.Observe(time.Since(start))
+				}
 				r := &result{priority: s.priority, SearchResult: sr, err: err}
 				results <- r
 			}
@@ -754,6 +1002,29 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 	// tracked so we can stop when we hit TotalMaxMatchCount
 	var totalMatchCount int
 
+	// tracked for progress events if opts.ProgressInterval is set
+	var (
+		progressTick    <-chan time.Time
+		shardsCompleted int
+		filesConsidered int
+	)
+	sendProgress := func() {
+		sender.Send(&zoekt.SearchResult{
+			Progress: zoekt.Progress{
+				Priority:           math.Inf(-1),
+				MaxPendingPriority: pending.max(),
+				ShardsCompleted:    shardsCompleted,
+				ShardsTotal:        len(shards),
+				FilesConsidered:    filesConsidered,
+			},
+		})
+	}
+	if opts.ProgressInterval > 0 {
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		progressTick = ticker.C
+	}
+
 search:
 	for {
 		// At the top of each iteration, have the proc associated with this search yield its won "timeslice"
@@ -778,6 +1049,11 @@ search:
 			// delete this result's priority from pending before computing the new max pending priority
 			pending.remove(r.priority)
 
+			shardsCompleted++
+			if r.SearchResult != nil {
+				filesConsidered += r.SearchResult.Stats.FilesConsidered
+			}
+
 			if r.err != nil {
 				// Set final error and stop searching new shards, but consume any pending
 				// search results.
@@ -799,9 +1075,15 @@ search:
 			r.MaxPendingPriority = pending.max()
 
 			sendByRepository(r.SearchResult, opts, sender) // send the result back to the client
+		case <-progressTick:
+			sendProgress()
 		}
 	}
 
+	if opts.ProgressInterval > 0 {
+		sendProgress()
+	}
+
 	return func() { runtime.KeepAlive(shards) }, err
 }
 
@@ -818,7 +1100,7 @@ func sendByRepository(result *zoekt.SearchResult, opts *zoekt.SearchOptions, sen
 		return
 	}
 
-	send := func(repoName string, a, b int, stats zoekt.Stats) {
+	send := func(repoName string, a, b int, stats zoekt.Stats, resultSet *zoekt.ResultSet) {
 		index.SortFiles(result.Files[a:b])
 		sender.Send(&zoekt.SearchResult{
 			Stats: stats,
@@ -829,6 +1111,7 @@ func sendByRepository(result *zoekt.SearchResult, opts *zoekt.SearchOptions, sen
 			Files:         result.Files[a:b],
 			RepoURLs:      map[string]string{repoName: result.RepoURLs[repoName]},
 			LineFragments: map[string]string{repoName: result.LineFragments[repoName]},
+			ResultSet:     resultSet,
 		})
 	}
 
@@ -840,8 +1123,8 @@ func sendByRepository(result *zoekt.SearchResult, opts *zoekt.SearchOptions, sen
 	for endIndex, fm = range result.Files {
 		if curRepoID != fm.RepositoryID {
 			// Stats must stay aggregate-able, hence we sent the aggregate stats with the
-			// last event.
-			send(curRepoName, startIndex, endIndex, zoekt.Stats{})
+			// last event. The same goes for the result set.
+			send(curRepoName, startIndex, endIndex, zoekt.Stats{}, nil)
 
 			startIndex = endIndex
 			curRepoID = fm.RepositoryID
@@ -849,7 +1132,7 @@ func sendByRepository(result *zoekt.SearchResult, opts *zoekt.SearchOptions, sen
 		}
 	}
 
-	send(curRepoName, startIndex, endIndex+1, result.Stats)
+	send(curRepoName, startIndex, endIndex+1, result.Stats, result.ResultSet)
 }
 
 func observeMetrics(sr *zoekt.SearchResult) {
@@ -866,6 +1149,7 @@ func observeMetrics(sr *zoekt.SearchResult) {
 	metricSearchNgramMatchesTotal.Add(float64(sr.Stats.NgramMatches))
 	metricSearchNgramLookupsTotal.Add(float64(sr.Stats.NgramLookups))
 	metricSearchRegexpsConsideredTotal.Add(float64(sr.Stats.RegexpsConsidered))
+	metricSearchBudgetsExhaustedTotal.Add(float64(sr.Stats.BudgetsExhausted))
 }
 
 func copySlice(src *[]byte) {
@@ -912,22 +1196,25 @@ func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoek
 type shardListResult struct {
 	rl  *zoekt.RepoList
 	err error
+
+	// repos are the repositories of the shard, see rankedShard.repos.
+	repos []*zoekt.Repository
 }
 
-func listOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.ListOptions, sink chan shardListResult) {
+func listOneShard(ctx context.Context, s *rankedShard, q query.Q, opts *zoekt.ListOptions, sink chan shardListResult) {
 	metricListShardRunning.Inc()
 	defer func() {
 		metricListShardRunning.Dec()
 		if r := recover(); r != nil {
 			log.Printf("[ERROR] crashed shard: %s: %s, %s", s.String(), r, debug.Stack())
 			sink <- shardListResult{
-				&zoekt.RepoList{Crashes: 1}, nil,
+				&zoekt.RepoList{Crashes: 1}, nil, s.repos,
 			}
 		}
 	}()
 
 	ms, err := s.List(ctx, q, opts)
-	sink <- shardListResult{ms, err}
+	sink <- shardListResult{ms, err, s.repos}
 }
 
 func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (rl *zoekt.RepoList, err error) {
@@ -945,6 +1232,50 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 		tr.Finish()
 	}()
 
+	agg := zoekt.RepoList{
+		ReposMap: zoekt.ReposMap{},
+		Repos:    []*zoekt.RepoListEntry{},
+	}
+	totals, err := ss.streamList(ctx, tr, q, opts, func(rl *zoekt.RepoList) error {
+		agg.Repos = append(agg.Repos, rl.Repos...)
+		for id, r := range rl.ReposMap {
+			agg.ReposMap[id] = r
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	agg.Crashes = totals.Crashes
+	agg.Stats = totals.Stats
+	return &agg, nil
+}
+
+// StreamList implements zoekt.ListStreamer. A repository is sent as soon as
+// all the shards which hold it are listed, while the other shards are still
+// being listed.
+func (ss *shardedSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (rl *zoekt.RepoList, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.StreamList", "")
+	metricListRunning.Inc()
+	defer func() {
+		metricListRunning.Dec()
+		if rl != nil {
+			tr.LazyPrintf("crashes=%d stats=%+v", rl.Crashes, rl.Stats)
+		}
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	return ss.streamList(ctx, tr, q, opts, send)
+}
+
+// streamList lists the repositories matching q for List and StreamList. It
+// calls send with the repositories of each shard which are complete, and
+// returns the crashes and stats of the whole list.
+func (ss *shardedSearcher) streamList(ctx context.Context, tr *trace.Trace, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
 	q = query.Simplify(q)
 	isAll := false
 	if c, ok := q.(*query.Const); ok {
@@ -969,9 +1300,7 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 		stillLoadingCrashes++
 	}
 	agg := zoekt.RepoList{
-		Crashes:  stillLoadingCrashes,
-		ReposMap: zoekt.ReposMap{},
-		Repos:    []*zoekt.RepoListEntry{},
+		Crashes: stillLoadingCrashes,
 	}
 
 	// PERF: Select the subset of shards that we will search over for the given
@@ -988,9 +1317,24 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 		return &agg, nil
 	}
 
+	// pending is the number of shards of each repository which are not
+	// listed yet. The entries of a repository with several shards are
+	// merged, so it is only sent once all of them are listed. If the
+	// repositories of a shard are unknown, everything is sent at the end.
+	pending := map[string]int{}
+	for _, s := range shards {
+		if s.repos == nil {
+			pending = nil
+			break
+		}
+		for _, r := range s.repos {
+			pending[r.Name]++
+		}
+	}
+
 	shardCount := len(shards)
 	all := make(chan shardListResult, shardCount)
-	feeder := make(chan zoekt.Searcher, len(shards))
+	feeder := make(chan *rankedShard, len(shards))
 	for _, s := range shards {
 		feeder <- s
 	}
@@ -1005,6 +1349,26 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	}
 
 	uniq := map[string]*zoekt.RepoListEntry{}
+	seen := map[uint32]struct{}{}
+
+	// The number of repositories which were sent, and their stats for the
+	// metrics of listing all repositories.
+	sent := 0
+	listedAll := false
+	var sentStats zoekt.RepoStats
+	sendBatch := func(batch *zoekt.RepoList) error {
+		if len(batch.Repos) == 0 && len(batch.ReposMap) == 0 {
+			return nil
+		}
+		sent += len(batch.Repos) + len(batch.ReposMap)
+		if isAll && len(batch.Repos) > 0 {
+			listedAll = true
+			for _, r := range batch.Repos {
+				sentStats.Add(&r.Stats)
+			}
+		}
+		return send(batch)
+	}
 
 	for range shards {
 		r := <-all
@@ -1025,39 +1389,249 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 			}
 		}
 
+		batch := &zoekt.RepoList{}
 		for id, r := range r.rl.ReposMap {
-			_, ok := agg.ReposMap[id]
-			if !ok {
-				agg.ReposMap[id] = r
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				if batch.ReposMap == nil {
+					batch.ReposMap = zoekt.ReposMap{}
+				}
+				batch.ReposMap[id] = r
+			}
+		}
+		if pending != nil {
+			for _, repo := range r.repos {
+				pending[repo.Name]--
+				if e, ok := uniq[repo.Name]; ok && pending[repo.Name] == 0 {
+					batch.Repos = append(batch.Repos, e)
+					delete(uniq, repo.Name)
+				}
 			}
 		}
+		if err := sendBatch(batch); err != nil {
+			return nil, err
+		}
 	}
 
-	agg.Repos = make([]*zoekt.RepoListEntry, 0, len(uniq))
+	rest := &zoekt.RepoList{}
 	for _, r := range uniq {
-		agg.Repos = append(agg.Repos, r)
+		rest.Repos = append(rest.Repos, r)
+	}
+	if err := sendBatch(rest); err != nil {
+		return nil, err
 	}
 
-	// Only one of these fields is populated and in all cases the size of that
-	// field is the number of Repos.
+	// Only one of Repos and ReposMap is populated and in all cases the size
+	// of that field is the number of Repos.
 	//
 	// Note: we don't just add individual Stats.Repos since a repository can
 	// have multiple shards.
-	agg.Stats.Repos = len(uniq) + len(agg.ReposMap)
+	agg.Stats.Repos = sent
 
-	if isAll && len(agg.Repos) > 0 {
-		reportListAllMetrics(agg.Repos)
+	if listedAll {
+		reportListAllMetrics(sentStats)
 	}
 
 	return &agg, nil
 }
 
-func reportListAllMetrics(repos []*zoekt.RepoListEntry) {
-	var stats zoekt.RepoStats
-	for _, r := range repos {
-		stats.Add(&r.Stats)
+// Definitions implements zoekt.DefinitionSearcher. It looks up the
+// definitions in all shards and returns them ranked across shards.
+func (ss *shardedSearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) (defs []zoekt.Definition, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.Definitions", name)
+	defer func() {
+		tr.LazyPrintf("definitions=%d", len(defs))
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	if opts == nil {
+		opts = &zoekt.DefinitionOptions{}
+	}
+	var repoFilter *regexp.Regexp
+	if opts.RepoFilter != "" {
+		repoFilter, err = regexp.Compile(opts.RepoFilter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Release()
+
+	// Skip the shards which have no repository matching the filters.
+	var shards []zoekt.DefinitionSearcher
+	for _, s := range ss.getLoaded().shards {
+		ds, ok := s.Searcher.(zoekt.DefinitionSearcher)
+		if !ok {
+			continue
+		}
+		if s.repos != nil && !slices.ContainsFunc(s.repos, func(r *zoekt.Repository) bool {
+			return definitionsMayMatch(r, repoFilter, opts.Branch)
+		}) {
+			continue
+		}
+		shards = append(shards, ds)
+	}
+	tr.LazyPrintf("shards=%d", len(shards))
+
+	type result struct {
+		defs []zoekt.Definition
+		err  error
+	}
+	feeder := make(chan zoekt.DefinitionSearcher, len(shards))
+	for _, s := range shards {
+		feeder <- s
+	}
+	close(feeder)
+
+	results := make(chan result, len(shards))
+	for i := 0; i < min(runtime.GOMAXPROCS(0), len(shards)); i++ {
+		go func() {
+			for s := range feeder {
+				defs, err := s.Definitions(ctx, name, opts)
+				results <- result{defs: defs, err: err}
+			}
+		}()
+	}
+
+	for range shards {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		defs = append(defs, r.defs...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zoekt.SortDefinitions(defs)
+	if opts.MaxResults > 0 && len(defs) > opts.MaxResults {
+		defs = defs[:opts.MaxResults]
+	}
+	return defs, nil
+}
+
+// definitionsMayMatch returns whether the definitions in repo can match the
+// repository filter and the branch of the options.
+func definitionsMayMatch(repo *zoekt.Repository, repoFilter *regexp.Regexp, branch string) bool {
+	if repoFilter != nil && !repoFilter.MatchString(repo.Name) {
+		return false
+	}
+	return branch == "" || slices.ContainsFunc(repo.Branches, func(b zoekt.RepositoryBranch) bool {
+		return b.Name == branch
+	})
+}
+
+// Count implements zoekt.CountSearcher. It counts the matches in all shards
+// in parallel and adds them up.
+func (ss *shardedSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (cr *zoekt.CountResult, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.Count", "")
+	tr.LazyLog(q, true)
+	tr.LazyPrintf("opts: %+v", opts)
+	defer func() {
+		if cr != nil {
+			tr.LazyPrintf("result: %+v", *cr)
+		}
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Release()
+
+	loaded := ss.getLoaded()
+	agg := &zoekt.CountResult{Estimated: opts.Estimate}
+	if !loaded.ready {
+		// We may have missed matches due to not being fully loaded.
+		agg.Crashes++
+	}
+
+	shards, q := selectRepoSet(loaded.shards, query.Simplify(q))
+	tr.LazyPrintf("shards=%d", len(shards))
+
+	type result struct {
+		shard *rankedShard
+		cr    *zoekt.CountResult
+		err   error
+	}
+	feeder := make(chan *rankedShard, len(shards))
+	for _, s := range shards {
+		feeder <- s
+	}
+	close(feeder)
+
+	results := make(chan result, len(shards))
+	for i := 0; i < min(runtime.GOMAXPROCS(0), len(shards)); i++ {
+		go func() {
+			for s := range feeder {
+				cr, err := countOneShard(ctx, s.Searcher, q, opts)
+				results <- result{shard: s, cr: cr, err: err}
+			}
+		}()
+	}
+
+	// The shards of a large repository each count it, so single repository
+	// shards are counted by repository. Compound shards hold whole
+	// repositories.
+	type repoKey struct {
+		tenant int
+		name   string
+	}
+	repos := map[repoKey]struct{}{}
+	for range shards {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		if r.cr == nil {
+			continue
+		}
+		agg.MatchCount += r.cr.MatchCount
+		agg.FileCount += r.cr.FileCount
+		agg.Crashes += r.cr.Crashes
+		if len(r.shard.repos) == 1 && r.cr.RepoCount > 0 {
+			repos[repoKey{tenant: r.shard.repos[0].TenantID, name: r.shard.repos[0].Name}] = struct{}{}
+		} else {
+			agg.RepoCount += r.cr.RepoCount
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	agg.RepoCount += len(repos)
+	return agg, nil
+}
+
+func countOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.CountOptions) (cr *zoekt.CountResult, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("[ERROR] crashed shard: %s: %#v, %s", s, e, debug.Stack())
+			cr, err = &zoekt.CountResult{Crashes: 1}, nil
+		}
+	}()
+
+	cs, ok := s.(zoekt.CountSearcher)
+	if !ok {
+		return nil, nil
 	}
+	return cs.Count(ctx, q, opts)
+}
 
+func reportListAllMetrics(stats zoekt.RepoStats) {
 	metricListAllRepos.Set(float64(stats.Repos))
 	metricListAllIndexBytes.Set(float64(stats.IndexBytes))
 	metricListAllContentBytes.Set(float64(stats.ContentBytes))
@@ -1076,9 +1650,14 @@ func (s *shardedSearcher) getLoaded() loaded {
 	// ranked is loaded after ready to avoid a race were ready is true but
 	// ranked is still not the final set of shards.
 	ranked, _ := s.ranked.Load().([]*rankedShard)
+	var corpus index.CorpusStats
+	if c := s.corpus.Load(); c != nil {
+		corpus = *c
+	}
 	return loaded{
 		shards: ranked,
 		ready:  ready,
+		corpus: corpus,
 	}
 }
 
@@ -1108,11 +1687,29 @@ func mkRankedShard(s zoekt.Searcher) *rankedShard {
 		}
 	}
 
-	return &rankedShard{
+	r := &rankedShard{
 		Searcher: s,
 		repos:    repos,
 		priority: maxPriority,
 	}
+	if c, ok := s.(interface{ CorpusStats() index.CorpusStats }); ok {
+		r.corpus = c.CorpusStats()
+	}
+	if b, ok := s.(interface{ RepoBloom() *index.RepoBloom }); ok {
+		r.repoBloom = b.RepoBloom()
+	}
+	if len(repos) > 1 {
+		ids := roaring.New()
+		for _, repo := range repos {
+			if repo.ID == 0 {
+				ids = nil
+				break
+			}
+			ids.Add(repo.ID)
+		}
+		r.repoIDs = ids
+	}
+	return r
 }
 
 // markReady should be called once all shards have been passed into replace on
@@ -1176,8 +1773,10 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	}
 
 	ranked := make([]*rankedShard, 0, len(s.shards))
+	var corpus index.CorpusStats
 	for _, r := range s.shards {
 		ranked = append(ranked, r)
+		corpus.Add(r.corpus)
 	}
 
 	sort.Slice(ranked, func(i, j int) bool {
@@ -1193,11 +1792,34 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 		return ranked[i].repos[0].Name < ranked[j].repos[0].Name
 	})
 
+	s.corpus.Store(&corpus)
 	s.ranked.Store(ranked)
 
 	metricShardsLoaded.Set(float64(len(ranked)))
 }
 
+// The ZOEKT_SHARD_MADVISE environment variable controls how the sections of
+// a shard are paged in after loading. It is a comma-separated list of
+// name=val pairs, see index.ParseLoadOptions. For example
+//
+//	ZOEKT_SHARD_MADVISE=prefetch=1,content=random
+//
+// prefetches the postings and filenames of each shard on load to reduce the
+// latency of the first queries after a restart, while disabling readahead
+// for content.
+var shardLoadOptions = func() index.LoadOptions {
+	v := os.Getenv("ZOEKT_SHARD_MADVISE")
+	opts, err := index.ParseLoadOptions(v)
+	if err != nil {
+		log.Printf("ignoring ZOEKT_SHARD_MADVISE=%q: %v", v, err)
+		return index.LoadOptions{}
+	}
+	if v != "" {
+		log.Printf("ZOEKT_SHARD_MADVISE=%q specified.", v)
+	}
+	return opts
+}()
+
 func loadShard(fn string) (zoekt.Searcher, error) {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -1208,7 +1830,7 @@ func loadShard(fn string) (zoekt.Searcher, error) {
 	if err != nil {
 		return nil, err
 	}
-	s, err := index.NewSearcher(iFile)
+	s, err := index.NewSearcherOptions(iFile, shardLoadOptions)
 	if err != nil {
 		iFile.Close()
 		return nil, fmt.Errorf("NewSearcher(%s): %v", fn, err)
@@ -1220,7 +1842,8 @@ func loadShard(fn string) (zoekt.Searcher, error) {
 // prioritySlice is a trivial implementation of an array that provides three
 // things: appending a value, removing a value, and getting the array's max.
 // Operations take O(n) time, which is acceptable because N is restricted to
-// GOMAXPROCS (i.e., number of cpu cores) by the shardedSearcher interface.
+// the concurrency limit (at most twice the number of cpu cores) by the
+// shardedSearcher interface.
 type prioritySlice []float64
 
 func (p *prioritySlice) append(pri float64) {
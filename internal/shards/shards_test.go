@@ -17,6 +17,7 @@ package shards
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"hash/fnv"
@@ -28,6 +29,7 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"testing/quick"
 	"time"
@@ -401,12 +403,12 @@ func (s *memSeeker) Name() string {
 }
 
 func (s *memSeeker) Close() {}
-func (s *memSeeker) Read(off, sz uint32) ([]byte, error) {
+func (s *memSeeker) Read(off, sz uint64) ([]byte, error) {
 	return s.data[off : off+sz], nil
 }
 
-func (s *memSeeker) Size() (uint32, error) {
-	return uint32(len(s.data)), nil
+func (s *memSeeker) Size() (uint64, error) {
+	return uint64(len(s.data)), nil
 }
 
 func TestUnloadIndex(t *testing.T) {
@@ -599,7 +601,7 @@ func TestShardedSearcher_List(t *testing.T) {
 				cmpopts.IgnoreFields(zoekt.RepoListEntry{}, "IndexMetadata"),
 				cmpopts.IgnoreFields(zoekt.RepoStats{}, "IndexBytes"),
 				cmpopts.IgnoreFields(zoekt.Repository{}, "SubRepoMap"),
-				cmpopts.IgnoreFields(zoekt.Repository{}, "priority"),
+				cmpopts.IgnoreFields(zoekt.Repository{}, "priority", "IndexTime"),
 			}
 
 			if diff := cmp.Diff(tc.want, res, ignored...); diff != "" {
@@ -609,6 +611,151 @@ func TestShardedSearcher_List(t *testing.T) {
 	}
 }
 
+func TestShardedSearcher_StreamList(t *testing.T) {
+	repoA := &zoekt.Repository{ID: 1, Name: "repo-a", Branches: []zoekt.RepositoryBranch{{Name: "main"}}}
+	repoB := &zoekt.Repository{ID: 2, Name: "repo-b", Branches: []zoekt.RepositoryBranch{{Name: "main"}}}
+	doc := index.Document{Name: "foo.go", Content: []byte("bar\nbaz"), Branches: []string{"main"}}
+
+	ss := newShardedSearcher(4)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, repoA, doc)),
+		"2": searcherForTest(t, testShardBuilder(t, repoA)),
+		"3": searcherForTest(t, testShardBuilder(t, repoB, doc)),
+	})
+	ss.markReady()
+
+	q := &query.Repo{Regexp: regexp.MustCompile("repo")}
+	want, err := ss.List(context.Background(), q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []*zoekt.ListOptions{nil, {Field: zoekt.RepoListFieldReposMap}} {
+		got := &zoekt.RepoList{ReposMap: zoekt.ReposMap{}}
+		totals, err := ss.StreamList(context.Background(), q, opts, func(rl *zoekt.RepoList) error {
+			got.Repos = append(got.Repos, rl.Repos...)
+			for id, r := range rl.ReposMap {
+				if _, ok := got.ReposMap[id]; ok {
+					t.Errorf("repository %d was sent twice", id)
+				}
+				got.ReposMap[id] = r
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Crashes = totals.Crashes
+		got.Stats = totals.Stats
+
+		wantList, err := ss.List(context.Background(), q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(got.Repos, func(i, j int) bool { return got.Repos[i].Repository.Name < got.Repos[j].Repository.Name })
+		sort.Slice(wantList.Repos, func(i, j int) bool { return wantList.Repos[i].Repository.Name < wantList.Repos[j].Repository.Name })
+		if diff := cmp.Diff(wantList, got, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(zoekt.Repository{})); diff != "" {
+			t.Errorf("StreamList(%v) mismatch with List (-want +got):\n%s", opts, diff)
+		}
+	}
+
+	// The entries of a repository with several shards are merged.
+	for _, r := range want.Repos {
+		if r.Repository.Name == "repo-a" && r.Stats.Shards != 2 {
+			t.Errorf("got %d shards for repo-a, want 2", r.Stats.Shards)
+		}
+	}
+
+	// The error of send stops the list.
+	errStop := errors.New("stop")
+	if _, err := ss.StreamList(context.Background(), q, nil, func(*zoekt.RepoList) error { return errStop }); err != errStop {
+		t.Errorf("got %v, want the error of send", err)
+	}
+}
+
+func TestShardedSearcher_Definitions(t *testing.T) {
+	doc := index.Document{
+		Name:            "server.go",
+		Content:         []byte("package web\n\ntype Server struct{}\n"),
+		Symbols:         []index.DocumentSection{{Start: 18, End: 24}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "struct"}},
+	}
+	docOnMain := doc
+	docOnMain.Branches = []string{"main"}
+
+	ss := newShardedSearcher(4)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-a", RawConfig: map[string]string{"priority": "1"}}, doc)),
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{
+			Name:      "repo-b",
+			RawConfig: map[string]string{"priority": "2"},
+			Branches:  []zoekt.RepositoryBranch{{Name: "main"}},
+		}, docOnMain)),
+		"3": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-c"})),
+	})
+
+	definitions := func(opts *zoekt.DefinitionOptions) []string {
+		t.Helper()
+		defs, err := ss.Definitions(context.Background(), "Server", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range defs {
+			got = append(got, d.Repository)
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		opts *zoekt.DefinitionOptions
+		want []string
+	}{
+		{nil, []string{"repo-b", "repo-a"}},
+		{&zoekt.DefinitionOptions{MaxResults: 1}, []string{"repo-b"}},
+		{&zoekt.DefinitionOptions{RepoFilter: "-a$"}, []string{"repo-a"}},
+		{&zoekt.DefinitionOptions{Language: "Python"}, nil},
+		{&zoekt.DefinitionOptions{Branch: "main"}, []string{"repo-b"}},
+		{&zoekt.DefinitionOptions{Branch: "dev"}, nil},
+	} {
+		if d := cmp.Diff(tc.want, definitions(tc.opts)); d != "" {
+			t.Errorf("%+v: mismatch (-want +got):\n%s", tc.opts, d)
+		}
+	}
+}
+
+func TestShardedSearcher_Count(t *testing.T) {
+	doc := index.Document{Name: "main.go", Content: []byte("needle needle\n")}
+
+	ss := newShardedSearcher(4)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-a"}, doc)),
+		// A second shard of the same repository.
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-a"}, doc)),
+		"3": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-b"}, doc)),
+		"4": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo-c"})),
+	})
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "needle"}
+	got, err := ss.Count(context.Background(), q, &zoekt.CountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.CountResult{MatchCount: 6, FileCount: 3, RepoCount: 2}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	got, err = ss.Count(context.Background(), query.NewAnd(query.NewRepoSet("repo-b"), q), &zoekt.CountOptions{Estimate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FileCount != 1 || got.RepoCount != 1 || !got.Estimated {
+		t.Errorf("got %+v, want an estimate of one file in one repository", got)
+	}
+}
+
 func testShardBuilder(t testing.TB, repo *zoekt.Repository, docs ...index.Document) *index.ShardBuilder {
 	b, err := index.NewShardBuilder(repo)
 	if err != nil {
@@ -1134,6 +1281,252 @@ func TestUseBM25Scoring(t *testing.T) {
 	}
 }
 
+func TestMaxMatchesPerRepo(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+			index.Document{Name: "f1", Content: []byte("needle needle")},
+			index.Document{Name: "f2", Content: []byte("needle")},
+			index.Document{Name: "f3", Content: []byte("needle")})),
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+			index.Document{Name: "f4", Content: []byte("needle")})),
+	})
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{
+		MaxMatchesPerRepo: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int{}
+	for _, f := range res.Files {
+		for _, lm := range f.LineMatches {
+			got[f.Repository] += len(lm.LineFragments)
+		}
+	}
+	if d := cmp.Diff(map[string]int{"repo-a": 2, "repo-b": 1}, got); d != "" {
+		t.Errorf("unexpected matches per repo (-want, +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]zoekt.RepoStats{"repo-a": {SuppressedMatches: 2}}, res.Stats.RepoStats); d != "" {
+		t.Errorf("unexpected suppressed matches (-want, +got):\n%s", d)
+	}
+}
+
+func TestResultSet(t *testing.T) {
+	a := testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+		index.Document{Name: "f1", Content: []byte("needle one")},
+		index.Document{Name: "f2", Content: []byte("haystack one")})
+	a.IndexTime = time.Unix(1, 0)
+	b := testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+		index.Document{Name: "f3", Content: []byte("needle two")},
+		index.Document{Name: "f4", Content: []byte("haystack one")})
+	b.IndexTime = time.Unix(2, 0)
+
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, a),
+		"2": searcherForTest(t, b),
+	})
+
+	// The result set has the documents which were not displayed.
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{
+		ReturnResultSet:    true,
+		MaxDocDisplayCount: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.ResultSet == nil || len(res.ResultSet.Shards) != 2 {
+		t.Fatalf("got %d files and result set %v, want 1 file and 2 shards", len(res.Files), res.ResultSet)
+	}
+
+	res, err = ss.Search(context.Background(), &query.Substring{Pattern: "one"}, &zoekt.SearchOptions{
+		Within: res.ResultSet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	if d := cmp.Diff([]string{"f1"}, got); d != "" {
+		t.Errorf("unexpected files within the result set (-want, +got):\n%s", d)
+	}
+}
+
+func TestBM25CorpusStats(t *testing.T) {
+	// The same document is in a shard of its own and in a shard with a much
+	// larger document. Scored with the statistics of its shard, it would be
+	// short in one and long in the other.
+	a := testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+		index.Document{Name: "f1", Content: []byte("needle haystack")},
+		index.Document{Name: "f2", Content: bytes.Repeat([]byte("haystack\n"), 100)})
+	b := testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+		index.Document{Name: "f1", Content: []byte("needle haystack")})
+
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, a),
+		"2": searcherForTest(t, b),
+	})
+
+	opts := &zoekt.SearchOptions{UseBM25Scoring: true}
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(res.Files))
+	}
+	if res.Files[0].Score != res.Files[1].Score {
+		t.Errorf("got scores %f and %f for the same document, want equal scores", res.Files[0].Score, res.Files[1].Score)
+	}
+}
+
+func TestStreamSearchProgress(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+			index.Document{Name: "f1", Content: []byte("needle")},
+			index.Document{Name: "f2", Content: []byte("needle")})),
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+			index.Document{Name: "f3", Content: []byte("needle")})),
+		"3": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 3, Name: "repo-c"},
+			index.Document{Name: "f4", Content: []byte("haystack")})),
+	})
+
+	for _, flush := range []time.Duration{0, time.Minute} {
+		var progress []zoekt.Progress
+		files := 0
+		sender := zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+			if result.IsProgressEvent() {
+				progress = append(progress, result.Progress)
+				return
+			}
+			files += len(result.Files)
+		})
+
+		opts := &zoekt.SearchOptions{ProgressInterval: time.Hour, FlushWallTime: flush}
+		if err := ss.StreamSearch(context.Background(), &query.Substring{Pattern: "needle"}, opts, sender); err != nil {
+			t.Fatal(err)
+		}
+
+		if files != 3 {
+			t.Errorf("flush %v: got %d files, want 3", flush, files)
+		}
+		// The interval is longer than the search, so we only get the final
+		// progress event.
+		if len(progress) != 1 {
+			t.Fatalf("flush %v: got %d progress events, want 1", flush, len(progress))
+		}
+		if p := progress[0]; p.ShardsCompleted != 3 || p.ShardsTotal != 3 || p.FilesConsidered != 3 {
+			t.Errorf("flush %v: unexpected final progress %+v", flush, p)
+		}
+	}
+
+	// Without ProgressInterval there are no progress events.
+	err := ss.StreamSearch(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{},
+		zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+			if result.IsProgressEvent() {
+				t.Errorf("unexpected progress event %+v", result.Progress)
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Search ignores progress events.
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{ProgressInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 3 || res.ShardsTotal != 0 {
+		t.Errorf("Search: got %d files, progress %+v", len(res.Files), res.Progress)
+	}
+}
+
+// scoreSearcher is a shard whose only file has the given score. If slow is
+// set, it only returns once the search is canceled, like a shard interrupted
+// by the deadline.
+type scoreSearcher struct {
+	name     string
+	priority string
+	score    float64
+	slow     bool
+}
+
+func (s *scoreSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	stats := zoekt.Stats{ShardsScanned: 1, MatchCount: 1, FileCount: 1}
+	if s.slow {
+		<-ctx.Done()
+		stats.ShardsInterrupted = 1
+	}
+	return &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{Repository: s.name, FileName: s.name, Score: s.score}},
+		Stats: stats,
+	}, nil
+}
+
+func (s *scoreSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{Repos: []*zoekt.RepoListEntry{{
+		Repository: zoekt.Repository{Name: s.name, RawConfig: map[string]string{"priority": s.priority}},
+	}}}, nil
+}
+
+func (s *scoreSearcher) Close()         {}
+func (s *scoreSearcher) String() string { return s.name }
+
+func TestStreamSearchAnytime(t *testing.T) {
+	ss := newShardedSearcher(1)
+	// The shards are searched by priority, so the best file is not in the
+	// first shard, and the last shard is interrupted by the deadline.
+	ss.replace(map[string]zoekt.Searcher{
+		"1": &scoreSearcher{name: "first", priority: "3", score: 1},
+		"2": &scoreSearcher{name: "best", priority: "2", score: 10},
+		"3": &scoreSearcher{name: "slow", priority: "1", score: 5, slow: true},
+	})
+	opts := &zoekt.SearchOptions{MaxDocDisplayCount: 1, MaxWallTime: 50 * time.Millisecond, Anytime: true}
+	q := &query.Substring{Pattern: "needle"}
+
+	var (
+		files  []string
+		events int
+		stats  zoekt.Stats
+	)
+	err := ss.StreamSearch(context.Background(), q, opts, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		stats.Add(sr.Stats)
+		if len(sr.Files) > 0 {
+			events++
+		}
+		for _, f := range sr.Files {
+			files = append(files, f.FileName)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 || !reflect.DeepEqual(files, []string{"best"}) {
+		t.Errorf("got files %v in %d events, want [best] in 1 event", files, events)
+	}
+	if stats.ShardsInterrupted != 1 {
+		t.Errorf("got %d interrupted shards, want 1", stats.ShardsInterrupted)
+	}
+	if c := stats.Completeness(); c >= 1 || c <= 0 {
+		t.Errorf("got completeness %v, want between 0 and 1", c)
+	}
+
+	// Search keeps the best files too.
+	res, err := ss.Search(context.Background(), q, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "best" {
+		t.Errorf("Search: got %v, want the best file", res.Files)
+	}
+}
+
 func testShardedStreamSearch(t *testing.T, q query.Q, ib *index.ShardBuilder, useDocumentRanks bool) []zoekt.FileMatch {
 	ss := newShardedSearcher(1)
 	searcher := searcherForTest(t, ib)
@@ -1248,3 +1641,330 @@ func waitForPredicate(deadline time.Time, tick time.Duration, pred func() bool)
 
 	return pred()
 }
+
+func TestSelectRepoSet_RawConfig(t *testing.T) {
+	public := &rankedShard{repos: []*zoekt.Repository{{Name: "public", RawConfig: map[string]string{"public": "1"}}}}
+	private := &rankedShard{repos: []*zoekt.Repository{{Name: "private"}}}
+	mixed := &rankedShard{repos: []*zoekt.Repository{
+		{Name: "mixed-public", RawConfig: map[string]string{"public": "1"}},
+		{Name: "mixed-private"},
+	}}
+	shards := []*rankedShard{public, private, mixed}
+
+	sub := &query.Substring{Pattern: "foo"}
+	names := func(shards []*rankedShard) []string {
+		var names []string
+		for _, s := range shards {
+			names = append(names, s.repos[0].Name)
+		}
+		return names
+	}
+
+	// Only shards with public repos remain, but since mixed still contains a private
+	// repo we can't drop the RawConfig atom from the query.
+	got, q := selectRepoSet(shards, query.NewAnd(query.RcOnlyPublic, sub))
+	if d := cmp.Diff([]string{"public", "mixed-public"}, names(got)); d != "" {
+		t.Errorf("shards mismatch (-want, +got):\n%s", d)
+	}
+	if want := query.NewAnd(query.RcOnlyPublic, sub); q.String() != want.String() {
+		t.Errorf("got query %s, want %s", q, want)
+	}
+
+	// All remaining shards are private, so the query is simplified.
+	got, q = selectRepoSet([]*rankedShard{public, private}, query.NewAnd(query.RcOnlyPrivate, sub))
+	if d := cmp.Diff([]string{"private"}, names(got)); d != "" {
+		t.Errorf("shards mismatch (-want, +got):\n%s", d)
+	}
+	if q.String() != sub.String() {
+		t.Errorf("got query %s, want %s", q, sub)
+	}
+}
+
+func TestStreamSearchFileNamesFirst(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+			index.Document{Name: "needle.go", Content: []byte("haystack")},
+			index.Document{Name: "f2", Content: []byte("needle")})),
+		"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+			index.Document{Name: "f3", Content: []byte("needle")})),
+	})
+
+	var first, final []string
+	finals := 0
+	sender := zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		if result.Final {
+			finals++
+			for _, f := range result.Files {
+				final = append(final, f.FileName)
+			}
+			return
+		}
+		if finals > 0 {
+			t.Errorf("got event after the final event")
+		}
+		for _, f := range result.Files {
+			first = append(first, f.FileName)
+		}
+	})
+
+	err := ss.StreamSearch(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{FileNamesFirst: true}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finals != 1 {
+		t.Fatalf("got %d final events, want 1", finals)
+	}
+	if d := cmp.Diff([]string{"needle.go"}, first); d != "" {
+		t.Errorf("unexpected files of the first phase (-want, +got):\n%s", d)
+	}
+	sort.Strings(final)
+	if d := cmp.Diff([]string{"f2", "f3", "needle.go"}, final); d != "" {
+		t.Errorf("unexpected files of the final event (-want, +got):\n%s", d)
+	}
+}
+
+func TestStreamSearchFileNamesFirstStats(t *testing.T) {
+	// The shards cache the ngrams of the queries, which leaves them out of
+	// the stats of later searches, so each search gets its own shards.
+	newSearcher := func() *shardedSearcher {
+		ss := newShardedSearcher(2)
+		ss.replace(map[string]zoekt.Searcher{
+			"1": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+				index.Document{Name: "needle.go", Content: []byte("haystack")},
+				index.Document{Name: "f2", Content: []byte("needle")})),
+			"2": searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+				index.Document{Name: "needle.txt", Content: []byte("needle")})),
+		})
+		return ss
+	}
+
+	q := &query.Substring{Pattern: "needle"}
+	var got zoekt.Stats
+	sender := zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		got.Add(result.Stats)
+	})
+	if err := newSearcher().StreamSearch(context.Background(), q, &zoekt.SearchOptions{FileNamesFirst: true}, sender); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := newSearcher().Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The phases share the cached ngrams of the file name atoms, so only
+	// one of them looks them up.
+	ignored := cmpopts.IgnoreFields(zoekt.Stats{}, "Duration", "Wait", "MatchTreeConstruction", "MatchTreeSearch", "FlushReason", "NgramLookups")
+	if d := cmp.Diff(want.Stats, got, ignored, cmpopts.EquateEmpty()); d != "" {
+		t.Errorf("stats mismatch (-want, +got):\n%s", d)
+	}
+}
+
+// phaseSearcher is a shard whose file name search only returns once its
+// content search started.
+type phaseSearcher struct {
+	contentStarted chan struct{}
+	once           sync.Once
+}
+
+func (s *phaseSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if sq, ok := q.(*query.Substring); ok && sq.FileName {
+		select {
+		case <-s.contentStarted:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		s.once.Do(func() { close(s.contentStarted) })
+	}
+	return &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{Repository: "repo", FileName: "needle.go"}},
+		Stats: zoekt.Stats{ShardsScanned: 1, MatchCount: 1, FileCount: 1},
+	}, nil
+}
+
+func (s *phaseSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{Name: "repo"}}}}, nil
+}
+
+func (s *phaseSearcher) Close()         {}
+func (s *phaseSearcher) String() string { return "phaseSearcher" }
+
+func TestStreamSearchFileNamesFirstConcurrent(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": &phaseSearcher{contentStarted: make(chan struct{})},
+	})
+
+	// The file name phase waits for the content phase, so the search only
+	// finishes if they run at the same time.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var events []bool
+	sender := zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		if len(result.Files) > 0 {
+			events = append(events, result.Final)
+		}
+	})
+	if err := ss.StreamSearch(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{FileNamesFirst: true}, sender); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]bool{false, true}, events); d != "" {
+		t.Errorf("want the file name result before the final event (-want, +got):\n%s", d)
+	}
+}
+
+func TestFileNamesQuery(t *testing.T) {
+	for _, tc := range []struct {
+		q    query.Q
+		want string
+	}{
+		{&query.Substring{Pattern: "foo"}, `file_substr:"foo"`},
+		{&query.Substring{Pattern: "foo", Content: true}, "FALSE"},
+		{&query.And{Children: []query.Q{
+			&query.Substring{Pattern: "foo"},
+			&query.Not{Child: &query.Substring{Pattern: "bar"}},
+		}}, `(and file_substr:"foo" (not substr:"bar"))`},
+		{&query.Or{Children: []query.Q{
+			&query.Substring{Pattern: "foo", Content: true},
+			&query.Symbol{Expr: &query.Substring{Pattern: "bar"}},
+		}}, `sym:substr:"bar"`},
+		{&query.Language{Language: "go"}, "lang:go"},
+	} {
+		if got := query.Simplify(fileNamesQuery(tc.q)).String(); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestSelectRepoSet_RepoBloom(t *testing.T) {
+	var files []index.IndexFile
+	for _, name := range []string{"foo", "bar", "baz"} {
+		b := testShardBuilder(t, &zoekt.Repository{ID: hash(name), Name: name},
+			index.Document{Name: "f", Content: []byte(name)})
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &memSeeker{buf.Bytes()})
+	}
+	tmpName, _, err := index.Merge(t.TempDir(), files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadShard(tmpName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	compound := mkRankedShard(s)
+	if compound.repoBloom == nil {
+		t.Fatal("compound shard has no repo bloom filter")
+	}
+	// The shard is skipped by its bloom filter without comparing the set with
+	// its repos. To tell, it lists a repo which is not in the filter.
+	compound.repos = append(compound.repos, &zoekt.Repository{ID: 1234, Name: "ghost"})
+	// Sets of IDs are compared with the bitmap of the IDs of the repos before
+	// the bloom filter.
+	compound.repoIDs = nil
+
+	sub := &query.Substring{Pattern: "foo"}
+	for _, tc := range []struct {
+		q    query.Q
+		want int
+	}{
+		{q: &query.RepoSet{Set: map[string]bool{"foo": true}}, want: 1},
+		{q: &query.RepoSet{Set: map[string]bool{"ghost": true}}, want: 0},
+		{q: query.NewSingleBranchesRepos("HEAD", hash("bar")), want: 1},
+		{q: query.NewSingleBranchesRepos("HEAD", 1234), want: 0},
+		{q: query.NewRepoIDs(hash("baz")), want: 1},
+		{q: query.NewRepoIDs(1234), want: 0},
+		// The filter is not consulted for sets as large as the shard.
+		{q: query.NewRepoIDs(1, 2, 3, 1234), want: 1},
+	} {
+		got, _ := selectRepoSet([]*rankedShard{compound}, query.NewAnd(tc.q, sub))
+		if len(got) != tc.want {
+			t.Errorf("%s: got %d shards, want %d", tc.q, len(got), tc.want)
+		}
+	}
+}
+
+func TestSelectRepoSet_RepoIDsBitmap(t *testing.T) {
+	repos := func(ids ...uint32) []*zoekt.Repository {
+		var rs []*zoekt.Repository
+		for _, id := range ids {
+			rs = append(rs, &zoekt.Repository{ID: id, Name: fmt.Sprintf("repo-%d", id)})
+		}
+		return rs
+	}
+	compound := &rankedShard{repos: repos(1, 2, 3), repoIDs: roaring.BitmapOf(1, 2, 3)}
+	single := &rankedShard{repos: repos(4)}
+	shards := []*rankedShard{compound, single}
+
+	sub := &query.Substring{Pattern: "foo"}
+	for _, tc := range []struct {
+		q          query.Q
+		want       int
+		simplified bool
+	}{
+		{q: query.NewRepoIDs(2), want: 1},
+		{q: query.NewRepoIDs(1, 2, 3, 4), want: 2, simplified: true},
+		{q: query.NewRepoIDs(5, 6), want: 0},
+		{q: query.NewSingleBranchesRepos("HEAD", 1, 2, 3), want: 1, simplified: true},
+		{q: &query.BranchesRepos{List: []query.BranchRepos{
+			{Branch: "main", Repos: roaring.BitmapOf(1)},
+			{Branch: "dev", Repos: roaring.BitmapOf(4)},
+		}}, want: 2},
+	} {
+		got, q := selectRepoSet(shards, query.NewAnd(tc.q, sub))
+		if len(got) != tc.want {
+			t.Errorf("%s: got %d shards, want %d", tc.q, len(got), tc.want)
+		}
+		if simplified := q.String() != query.NewAnd(tc.q, sub).String(); simplified != tc.simplified {
+			t.Errorf("%s: got query %s", tc.q, q)
+		}
+	}
+}
+
+func BenchmarkSelectRepoSet_RepoIDs(b *testing.B) {
+	// 100 compound shards of 1000 repos, searched with a set of 100k repos
+	// like the permissions of a user.
+	var shards []*rankedShard
+	for i := range 100 {
+		s := &rankedShard{repoIDs: roaring.New()}
+		for j := range 1000 {
+			id := uint32(i*1000 + j + 1)
+			s.repos = append(s.repos, &zoekt.Repository{ID: id, Name: fmt.Sprintf("repo-%d", id)})
+			s.repoIDs.Add(id)
+		}
+		shards = append(shards, s)
+	}
+	set := roaring.New()
+	for id := uint32(1); id <= 200_000; id += 2 {
+		set.Add(id)
+	}
+
+	for _, bb := range []struct {
+		name   string
+		shards []*rankedShard
+	}{
+		{"bitmap", shards},
+		{"per repo", func() []*rankedShard {
+			var noIDs []*rankedShard
+			for _, s := range shards {
+				noIDs = append(noIDs, &rankedShard{repos: s.repos})
+			}
+			return noIDs
+		}()},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			q := query.NewAnd(&query.RepoIDs{Repos: set}, &query.Substring{Pattern: "foo"})
+			for range b.N {
+				if got, _ := selectRepoSet(bb.shards, q); len(got) != len(shards) {
+					b.Fatalf("got %d shards", len(got))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ShardStorage is where a searcher finds its shards. Shards are always
+// searched from a local file, so storages which are not backed by a local
+// directory copy shards to a local cache before they are loaded.
+type ShardStorage interface {
+	// List returns the names of the shards, their ".meta" files and the shard
+	// manifests in the storage, mapped to their modification time.
+	List(ctx context.Context) (map[string]time.Time, error)
+
+	// Fetch returns the path of a local copy of the shard name, including its
+	// ".meta" file if there is one.
+	Fetch(ctx context.Context, name string) (string, error)
+
+	// Evict is called once the shard name is not searched anymore. It may
+	// release the local copy returned by Fetch.
+	Evict(name string)
+
+	String() string
+}
+
+// NewShardStorage returns the storage for location. A location of the form
+// s3://bucket/prefix or gs://bucket/prefix is an object store, from which
+// shards are copied into cacheDir when they are loaded. Any other location is
+// a local directory which is searched in place.
+//
+// The object stores are configured with the usual environment variables of
+// their providers. For S3 these are AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL_S3. GCS uses the
+// application default credentials and STORAGE_EMULATOR_HOST.
+func NewShardStorage(ctx context.Context, location, cacheDir string) (ShardStorage, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		return &localStorage{dir: location}, nil
+	}
+
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("shard storage %q: missing bucket", location)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var store objectStore
+	var err error
+	switch scheme {
+	case "s3":
+		store, err = newS3Store(bucket, prefix)
+	case "gs":
+		store, err = newGCSStore(ctx, bucket, prefix)
+	default:
+		return nil, fmt.Errorf("shard storage %q: unsupported scheme %q", location, scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("shard storage %q: %w", location, err)
+	}
+
+	if cacheDir == "" {
+		return nil, fmt.Errorf("shard storage %q: a cache directory is required", location)
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	return newCachedStorage(location, store, cacheDir), nil
+}
+
+// localStorage is a directory of shards.
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) List(context.Context) (map[string]time.Time, error) {
+	// NOTE: if you change which file extensions are read, please update the
+	// watch implementation.
+	var fs []string
+	for _, pattern := range []string{"*.zoekt", "*.zoekt.meta", "*.manifest"} {
+		matches, err := filepath.Glob(filepath.Join(s.dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, matches...)
+	}
+
+	ts := make(map[string]time.Time, len(fs))
+	for _, fn := range fs {
+		fi, err := os.Lstat(fn)
+		if err != nil {
+			continue
+		}
+		ts[fn] = fi.ModTime()
+	}
+	return ts, nil
+}
+
+func (s *localStorage) Fetch(_ context.Context, name string) (string, error) {
+	return name, nil
+}
+
+func (s *localStorage) Evict(string) {}
+
+func (s *localStorage) String() string {
+	return s.dir
+}
+
+// objectStore is a bucket of an object store, restricted to the objects below
+// a prefix.
+type objectStore interface {
+	// list returns the objects below the prefix, keyed by their name without
+	// the prefix.
+	list(ctx context.Context) (map[string]objectInfo, error)
+
+	// get writes the content of the object name to w.
+	get(ctx context.Context, name string, w io.Writer) error
+}
+
+type objectInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+var metricShardStorageFetchBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "zoekt_shard_storage_fetch_bytes_total",
+	Help: "The total number of bytes copied from the shard storage into the local cache.",
+})
+
+// cachedStorage copies the shards of an object store into a local directory
+// when they are fetched. A cached copy is reused as long as its size and
+// modification time match the object.
+type cachedStorage struct {
+	location string
+	store    objectStore
+	dir      string
+
+	mu      sync.Mutex
+	objects map[string]objectInfo // as of the last call to List
+}
+
+func newCachedStorage(location string, store objectStore, dir string) *cachedStorage {
+	return &cachedStorage{
+		location: location,
+		store:    store,
+		dir:      dir,
+		objects:  map[string]objectInfo{},
+	}
+}
+
+func (s *cachedStorage) List(ctx context.Context) (map[string]time.Time, error) {
+	objects, err := s.store.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := make(map[string]time.Time, len(objects))
+	for name, o := range objects {
+		// Shards are cached by name, so we ignore objects in sub directories.
+		if strings.Contains(name, "/") {
+			continue
+		}
+		if strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta") || strings.HasSuffix(name, ".manifest") {
+			ts[name] = o.modTime
+		}
+	}
+
+	s.mu.Lock()
+	s.objects = objects
+	s.mu.Unlock()
+
+	return ts, nil
+}
+
+func (s *cachedStorage) Fetch(ctx context.Context, name string) (string, error) {
+	s.mu.Lock()
+	shard, ok := s.objects[name]
+	meta, hasMeta := s.objects[name+".meta"]
+	s.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("%s: shard %s not found", s.location, name)
+	}
+
+	// The meta file is fetched first, so a concurrent reader never sees a new
+	// shard with a stale meta file.
+	if hasMeta {
+		if err := s.fetchObject(ctx, name+".meta", meta); err != nil {
+			return "", err
+		}
+	} else {
+		_ = os.Remove(s.path(name + ".meta"))
+	}
+	if err := s.fetchObject(ctx, name, shard); err != nil {
+		return "", err
+	}
+	return s.path(name), nil
+}
+
+// fetchObject copies the object name into the cache unless the cache already
+// has a copy of o.
+func (s *cachedStorage) fetchObject(ctx context.Context, name string, o objectInfo) error {
+	dst := s.path(name)
+	if fi, err := os.Stat(dst); err == nil && fi.Size() == o.size && fi.ModTime().Equal(o.modTime) {
+		return nil
+	}
+
+	f, err := os.CreateTemp(s.dir, filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := s.store.get(ctx, name, f); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: get %s: %w", s.location, name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(f.Name(), o.modTime, o.modTime); err != nil {
+		return err
+	}
+
+	// We rename over the old copy, so shards which still have the old copy
+	// mapped are not affected.
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return err
+	}
+	metricShardStorageFetchBytes.Add(float64(o.size))
+	return nil
+}
+
+func (s *cachedStorage) Evict(name string) {
+	for _, fn := range []string{s.path(name), s.path(name + ".meta")} {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] evicting %s: %v", fn, err)
+		}
+	}
+}
+
+func (s *cachedStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *cachedStorage) String() string {
+	return s.location
+}
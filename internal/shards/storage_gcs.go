@@ -0,0 +1,125 @@
+package shards
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// gcsStore reads objects from Google Cloud Storage using the JSON API.
+type gcsStore struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+func newGCSStore(ctx context.Context, bucket, prefix string) (*gcsStore, error) {
+	s := &gcsStore{
+		endpoint: "https://storage.googleapis.com",
+		bucket:   bucket,
+		prefix:   prefix,
+	}
+
+	// The emulator does not require authentication.
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		s.endpoint = strings.TrimSuffix(host, "/")
+		s.client = http.DefaultClient
+		return s, nil
+	}
+
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return s, nil
+}
+
+type gcsListResult struct {
+	Items []struct {
+		Name    string
+		Size    string
+		Updated time.Time
+	}
+	NextPageToken string
+}
+
+func (s *gcsStore) list(ctx context.Context) (map[string]objectInfo, error) {
+	objects := map[string]objectInfo{}
+	token := ""
+	for {
+		q := url.Values{
+			"prefix": {s.prefix},
+			"fields": {"items(name,size,updated),nextPageToken"},
+		}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		resp, err := s.do(ctx, "/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var res gcsListResult
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("gcs list: %w", err)
+		}
+
+		for _, item := range res.Items {
+			size, err := strconv.ParseInt(item.Size, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("gcs list: object %s: invalid size %q", item.Name, item.Size)
+			}
+			objects[strings.TrimPrefix(item.Name, s.prefix)] = objectInfo{size: size, modTime: item.Updated}
+		}
+		if res.NextPageToken == "" {
+			return objects, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+func (s *gcsStore) get(ctx context.Context, name string, w io.Writer) error {
+	resp, err := s.do(ctx, "/storage/v1/b/"+url.PathEscape(s.bucket)+"/o/"+url.PathEscape(s.prefix+name)+"?alt=media")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// do sends a GET request for the path and query in pathQuery and returns the
+// response if it succeeded.
+func (s *gcsStore) do(ctx context.Context, pathQuery string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.endpoint+pathQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("gcs GET %s: %s: %s", pathQuery, resp.Status, body)
+	}
+	return resp, nil
+}
@@ -0,0 +1,257 @@
+package shards
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store reads objects from S3 or a compatible object store using path style
+// requests signed with AWS signature version 4.
+type s3Store struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	now func() time.Time
+}
+
+func newS3Store(bucket, prefix string) (*s3Store, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	s := &s3Store{
+		client:       http.DefaultClient,
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		now:          time.Now,
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return s, nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) list(ctx context.Context) (map[string]objectInfo, error) {
+	objects := map[string]objectInfo{}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, "/"+s.bucket, q)
+		if err != nil {
+			return nil, err
+		}
+
+		var res s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+
+		for _, c := range res.Contents {
+			objects[strings.TrimPrefix(c.Key, s.prefix)] = objectInfo{size: c.Size, modTime: c.LastModified}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (s *s3Store) get(ctx context.Context, name string, w io.Writer) error {
+	resp, err := s.do(ctx, "/"+s.bucket+"/"+s.prefix+name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// do sends a signed GET request and returns the response if it succeeded.
+func (s *s3Store) do(ctx context.Context, path string, q url.Values) (*http.Response, error) {
+	u := s.endpoint + awsURIEscape(path, false)
+	if len(q) > 0 {
+		u += "?" + awsCanonicalQuery(q)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The payload of a GET request is empty.
+	req.Header.Set("X-Amz-Content-Sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	signAWSv4(req, s.now(), s.region, "s3", s.accessKey, s.secretKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 GET %s: %s: %s", path, resp.Status, body)
+	}
+	return resp, nil
+}
+
+// signAWSv4 adds the Authorization header for AWS signature version 4 to req.
+// The host and all X-Amz-* headers of req are signed. req must not have a
+// body.
+func signAWSv4(req *http.Request, now time.Time, region, service, accessKey, secretKey string) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = hex.EncodeToString(sha256Sum(""))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEscape(req.URL.Path, false),
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(sha256Sum(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Sum(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return h[:]
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// awsCanonicalQuery returns q sorted by key and escaped the way AWS signature
+// version 4 expects it.
+func awsCanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsURIEscape(k, true)+"="+awsURIEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEscape escapes every byte of s but the unreserved characters of RFC
+// 3986. Slashes are only escaped if encodeSlash is set.
+func awsURIEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
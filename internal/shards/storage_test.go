@@ -0,0 +1,264 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type memObjectStore struct {
+	objects map[string]string
+	modTime map[string]time.Time
+	gets    []string
+}
+
+func (s *memObjectStore) list(context.Context) (map[string]objectInfo, error) {
+	objects := map[string]objectInfo{}
+	for name, content := range s.objects {
+		objects[name] = objectInfo{size: int64(len(content)), modTime: s.modTime[name]}
+	}
+	return objects, nil
+}
+
+func (s *memObjectStore) get(_ context.Context, name string, w io.Writer) error {
+	s.gets = append(s.gets, name)
+	content, ok := s.objects[name]
+	if !ok {
+		return fmt.Errorf("%s not found", name)
+	}
+	_, err := io.WriteString(w, content)
+	return err
+}
+
+func TestCachedStorage(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memObjectStore{
+		objects: map[string]string{
+			"a_v16.00000.zoekt":      "a",
+			"a_v16.00000.zoekt.meta": "meta",
+			"b_v16.00000.zoekt":      "b",
+			"sub/c_v16.00000.zoekt":  "c",
+			"README":                 "readme",
+		},
+		modTime: map[string]time.Time{
+			"a_v16.00000.zoekt":      t0,
+			"a_v16.00000.zoekt.meta": t0.Add(time.Hour),
+			"b_v16.00000.zoekt":      t0,
+		},
+	}
+	dir := t.TempDir()
+	s := newCachedStorage("mem://", store, dir)
+	ctx := context.Background()
+
+	listing, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{
+		"a_v16.00000.zoekt":      t0,
+		"a_v16.00000.zoekt.meta": t0.Add(time.Hour),
+		"b_v16.00000.zoekt":      t0,
+	}
+	if d := cmp.Diff(want, listing); d != "" {
+		t.Fatalf("unexpected listing (-want, +got):\n%s", d)
+	}
+
+	fn, err := s.Fetch(ctx, "a_v16.00000.zoekt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn != filepath.Join(dir, "a_v16.00000.zoekt") {
+		t.Fatalf("got path %s", fn)
+	}
+	for name, content := range map[string]string{fn: "a", fn + ".meta": "meta"} {
+		if b, err := os.ReadFile(name); err != nil || string(b) != content {
+			t.Fatalf("%s: got %q, %v, want %q", name, b, err, content)
+		}
+	}
+
+	// An unchanged object is served from the cache.
+	if _, err := s.Fetch(ctx, "a_v16.00000.zoekt"); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"a_v16.00000.zoekt.meta", "a_v16.00000.zoekt"}, store.gets); d != "" {
+		t.Fatalf("unexpected gets (-want, +got):\n%s", d)
+	}
+
+	// A changed object is fetched again.
+	store.objects["a_v16.00000.zoekt"] = "a2"
+	if _, err := s.List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Fetch(ctx, "a_v16.00000.zoekt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(fn); string(b) != "a2" {
+		t.Fatalf("got %q after change, want %q", b, "a2")
+	}
+
+	s.Evict("a_v16.00000.zoekt")
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("cache not empty after evict: %v", entries)
+	}
+
+	if _, err := s.Fetch(ctx, "missing.zoekt"); err == nil {
+		t.Fatal("expected error for missing shard")
+	}
+}
+
+func TestStorageWatcher(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memObjectStore{
+		objects: map[string]string{
+			"foo_v16.00000.zoekt": "",
+			"foo_v17.00000.zoekt": "",
+			"bar_v16.00000.zoekt": "",
+		},
+		modTime: map[string]time.Time{},
+	}
+	for name := range store.objects {
+		store.modTime[name] = t0
+	}
+
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+	dw := &DirectoryWatcher{
+		storage:    newCachedStorage("mem://", store, t.TempDir()),
+		timestamps: map[string]time.Time{},
+		loader:     logger,
+	}
+	if err := dw.scan(); err != nil {
+		t.Fatal(err)
+	}
+	close(logger.loads)
+
+	var loads []string
+	for key := range logger.loads {
+		loads = append(loads, key)
+	}
+	sort.Strings(loads)
+	if d := cmp.Diff([]string{"bar_v16.00000.zoekt", "foo_v17.00000.zoekt"}, loads); d != "" {
+		t.Fatalf("unexpected loads (-want, +got):\n%s", d)
+	}
+}
+
+func TestSignAWSv4(t *testing.T) {
+	// get-vanilla from the AWS signature version 4 test suite.
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSv4(req, now, "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/bucket" && r.URL.Query().Get("continuation-token") == "":
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>shards/a.zoekt</Key><LastModified>2024-01-01T00:00:00.000Z</LastModified><Size>1</Size></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`)
+		case r.URL.Path == "/bucket":
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>shards/b.zoekt</Key><LastModified>2024-01-02T00:00:00.000Z</LastModified><Size>2</Size></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		case r.URL.Path == "/bucket/shards/a.zoekt":
+			fmt.Fprint(w, "a")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL_S3", ts.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := newS3Store("bucket", "shards/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := s.list(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]objectInfo{
+		"a.zoekt": {size: 1, modTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"b.zoekt": {size: 2, modTime: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	if d := cmp.Diff(want, objects, cmp.AllowUnexported(objectInfo{})); d != "" {
+		t.Fatalf("unexpected objects (-want, +got):\n%s", d)
+	}
+
+	var b strings.Builder
+	if err := s.get(context.Background(), "a.zoekt", &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "a" {
+		t.Fatalf("got %q, want %q", b.String(), "a")
+	}
+	if err := s.get(context.Background(), "missing.zoekt", &b); err == nil {
+		t.Fatal("expected error for missing object")
+	}
+}
+
+func TestGCSStore(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/storage/v1/b/bucket/o" && r.URL.Query().Get("pageToken") == "":
+			fmt.Fprint(w, `{"items": [{"name": "shards/a.zoekt", "size": "1", "updated": "2024-01-01T00:00:00Z"}], "nextPageToken": "next"}`)
+		case r.URL.Path == "/storage/v1/b/bucket/o":
+			fmt.Fprint(w, `{"items": [{"name": "shards/b.zoekt", "size": "2", "updated": "2024-01-02T00:00:00Z"}]}`)
+		case r.URL.EscapedPath() == "/storage/v1/b/bucket/o/shards%2Fa.zoekt" && r.URL.Query().Get("alt") == "media":
+			fmt.Fprint(w, "a")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", ts.URL)
+	s, err := newGCSStore(context.Background(), "bucket", "shards/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := s.list(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]objectInfo{
+		"a.zoekt": {size: 1, modTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"b.zoekt": {size: 2, modTime: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	if d := cmp.Diff(want, objects, cmp.AllowUnexported(objectInfo{})); d != "" {
+		t.Fatalf("unexpected objects (-want, +got):\n%s", d)
+	}
+
+	var b strings.Builder
+	if err := s.get(context.Background(), "a.zoekt", &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "a" {
+		t.Fatalf("got %q, want %q", b.String(), "a")
+	}
+}
@@ -15,14 +15,16 @@
 package shards
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -36,7 +38,7 @@ type shardLoader interface {
 }
 
 type DirectoryWatcher struct {
-	dir        string
+	storage    ShardStorage
 	timestamps map[string]time.Time
 	loader     shardLoader
 
@@ -49,6 +51,12 @@ type DirectoryWatcher struct {
 	quit chan struct{}
 	// stopped is closed once the directory watcher has stopped.
 	stopped chan struct{}
+
+	// frozen is set while the watcher doesn't load or drop shards, see
+	// Freeze.
+	frozen atomic.Bool
+	// thawed is signaled by Freeze when the watcher is unfrozen.
+	thawed chan struct{}
 }
 
 func (sw *DirectoryWatcher) Stop() {
@@ -59,13 +67,21 @@ func (sw *DirectoryWatcher) Stop() {
 }
 
 func newDirectoryWatcher(dir string, loader shardLoader) (*DirectoryWatcher, error) {
+	return newStorageWatcher(&localStorage{dir: dir}, loader)
+}
+
+// newStorageWatcher is like newDirectoryWatcher, but watches the shards in
+// storage. Only local storage is watched for changes, other storages are
+// polled.
+func newStorageWatcher(storage ShardStorage, loader shardLoader) (*DirectoryWatcher, error) {
 	sw := &DirectoryWatcher{
-		dir:        dir,
+		storage:    storage,
 		timestamps: map[string]time.Time{},
 		loader:     loader,
 		ready:      make(chan struct{}),
 		quit:       make(chan struct{}),
 		stopped:    make(chan struct{}),
+		thawed:     make(chan struct{}, 1),
 	}
 
 	go func() {
@@ -90,8 +106,26 @@ func (s *DirectoryWatcher) WaitUntilReady() error {
 	return s.readyErr
 }
 
+// Freeze stops loading new and changed shards and dropping deleted ones while
+// frozen is true, so the loaded shards stay the same, eg. for debugging or
+// benchmarks. The initial scan always loads the shards. Unfreezing scans the
+// shards right away.
+func (s *DirectoryWatcher) Freeze(frozen bool) {
+	if was := s.frozen.Swap(frozen); was && !frozen {
+		select {
+		case s.thawed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Frozen returns whether the watcher is frozen, see Freeze.
+func (s *DirectoryWatcher) Frozen() bool {
+	return s.frozen.Load()
+}
+
 func (s *DirectoryWatcher) String() string {
-	return fmt.Sprintf("shardWatcher(%s)", s.dir)
+	return fmt.Sprintf("shardWatcher(%s)", s.storage)
 }
 
 // versionFromPath extracts url encoded repository name and
@@ -117,12 +151,30 @@ func versionFromPath(path string) (string, int) {
 }
 
 func (s *DirectoryWatcher) scan() error {
-	// NOTE: if you change which file extensions are read, please update the
-	// watch implementation.
-	fs, err := filepath.Glob(filepath.Join(s.dir, "*.zoekt"))
+	listing, err := s.storage.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var fs, manifests []string
+	for fn := range listing {
+		switch {
+		case strings.HasSuffix(fn, ".zoekt"):
+			fs = append(fs, fn)
+		case strings.HasSuffix(fn, ".manifest"):
+			manifests = append(manifests, fn)
+		}
+	}
+
+	// Content-addressed shards are only loaded once a manifest lists them.
+	// If a manifest can't be read, we keep the shards we have.
+	published, err := s.publishedShards(manifests)
 	if err != nil {
 		return err
 	}
+	fs = slices.DeleteFunc(fs, func(fn string) bool {
+		return index.IsContentAddressedShard(fn) && !published[filepath.Base(fn)]
+	})
 
 	latest := map[string]int{}
 	for _, fn := range fs {
@@ -145,19 +197,9 @@ func (s *DirectoryWatcher) scan() error {
 			continue
 		}
 
-		fi, err := os.Lstat(fn)
-		if err != nil {
-			continue
-		}
-
-		ts[fn] = fi.ModTime()
-
-		fiMeta, err := os.Lstat(fn + ".meta")
-		if err != nil {
-			continue
-		}
-		if fiMeta.ModTime().After(fi.ModTime()) {
-			ts[fn] = fiMeta.ModTime()
+		ts[fn] = listing[fn]
+		if metaTime, ok := listing[fn+".meta"]; ok && metaTime.After(ts[fn]) {
+			ts[fn] = metaTime
 		}
 	}
 
@@ -188,6 +230,26 @@ func (s *DirectoryWatcher) scan() error {
 	return nil
 }
 
+// publishedShards returns the names of the shards listed in manifests.
+func (s *DirectoryWatcher) publishedShards(manifests []string) (map[string]bool, error) {
+	published := map[string]bool{}
+	for _, fn := range manifests {
+		path, err := s.storage.Fetch(context.Background(), fn)
+		if err != nil {
+			return nil, err
+		}
+		m, err := index.ReadShardManifest(path)
+		s.storage.Evict(fn)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range m.Shards {
+			published[name] = true
+		}
+	}
+	return published, nil
+}
+
 func humanTruncateList(paths []string, max int) string {
 	sort.Strings(paths)
 	var b strings.Builder
@@ -205,12 +267,23 @@ func humanTruncateList(paths []string, max int) string {
 }
 
 func (s *DirectoryWatcher) watch() error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
-	if err := watcher.Add(s.dir); err != nil {
-		return err
+	// Only local storage supports notifications. Other storages are just
+	// polled, receiving from their nil channels blocks forever.
+	var (
+		events   chan fsnotify.Event
+		errs     chan error
+		closeAll = func() {}
+	)
+	if ls, ok := s.storage.(*localStorage); ok {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		if err := watcher.Add(ls.dir); err != nil {
+			return err
+		}
+		events, errs = watcher.Events, watcher.Errors
+		closeAll = func() { watcher.Close() }
 	}
 
 	// intermediate signal channel so if there are multiple watcher.Events we
@@ -229,18 +302,22 @@ func (s *DirectoryWatcher) watch() error {
 
 		for {
 			select {
-			case event := <-watcher.Events:
+			case event := <-events:
 				// Only notify if a file we read in has changed. This is important to
 				// avoid all the events writing to temporary files.
-				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") {
+				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") || strings.HasSuffix(event.Name, ".manifest") {
 					notify()
 				}
 
 			case <-ticker.C:
-				// Periodically just double check the disk
+				// Periodically just double check the storage
 				notify()
 
-			case err := <-watcher.Errors:
+			case <-s.thawed:
+				// Catch up with the changes we ignored while frozen.
+				notify()
+
+			case err := <-errs:
 				// Ignore ErrEventOverflow since we rely on the presence of events so
 				// safe to ignore.
 				if err != nil && err != fsnotify.ErrEventOverflow {
@@ -248,7 +325,7 @@ func (s *DirectoryWatcher) watch() error {
 				}
 
 			case <-s.quit:
-				watcher.Close()
+				closeAll()
 				ticker.Stop()
 				close(signal)
 				return
@@ -259,6 +336,9 @@ func (s *DirectoryWatcher) watch() error {
 	go func() {
 		defer close(s.stopped)
 		for range signal {
+			if s.frozen.Load() {
+				continue
+			}
 			if err := s.scan(); err != nil {
 				log.Println("[ERROR] watcher error:", err)
 			}
@@ -15,6 +15,8 @@
 package shards
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -131,6 +133,63 @@ func TestDirWatcherLoadEmpty(t *testing.T) {
 	}
 }
 
+func TestDirWatcherFreeze(t *testing.T) {
+	dir := t.TempDir()
+
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+	shard := filepath.Join(dir, "foo.zoekt")
+	if err := os.WriteFile(shard, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dw, err := newDirectoryWatcher(dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Stop()
+	if err := dw.WaitUntilReady(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-logger.loads; got != shard {
+		t.Fatalf("got load event %v, want %v", got, shard)
+	}
+
+	dw.Freeze(true)
+	if !dw.Frozen() {
+		t.Fatal("watcher isn't frozen")
+	}
+	added := filepath.Join(dir, "bar.zoekt")
+	advanceFS()
+	if err := os.WriteFile(added, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(shard); err != nil {
+		t.Fatal(err)
+	}
+	advanceFS()
+	advanceFS()
+
+	select {
+	case k := <-logger.loads:
+		t.Fatalf("load of %q while frozen", k)
+	case k := <-logger.drops:
+		t.Fatalf("drop of %q while frozen", k)
+	default:
+	}
+
+	// Unfreezing catches up with the changes.
+	dw.Freeze(false)
+	if got := <-logger.drops; got != shard {
+		t.Fatalf("got drop event %v, want %v", got, shard)
+	}
+	if got := <-logger.loads; got != added {
+		t.Fatalf("got load event %v, want %v", got, added)
+	}
+}
+
 func TestVersionFromPath(t *testing.T) {
 	cases := map[string]struct {
 		name    string
@@ -215,6 +274,70 @@ func TestDirWatcherLoadLatest(t *testing.T) {
 	}
 }
 
+func TestDirWatcherManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+
+	oldShard := index.ContentAddressedShardName(dir, "foo", index.IndexFormatVersion, bytes.Repeat([]byte{1}, 16))
+	newShard := index.ContentAddressedShardName(dir, "foo", index.IndexFormatVersion, bytes.Repeat([]byte{2}, 16))
+	for _, shard := range []string{oldShard, newShard} {
+		if err := os.WriteFile(shard, []byte("hello"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	writeManifest := func(shards ...string) {
+		t.Helper()
+		m := index.ShardManifest{}
+		for _, shard := range shards {
+			m.Shards = append(m.Shards, filepath.Base(shard))
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(index.ShardManifestName(dir, "foo"), b, 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	writeManifest(oldShard)
+
+	dw, err := newDirectoryWatcher(dir, logger)
+	if err != nil {
+		t.Fatalf("NewDirectoryWatcher: %v", err)
+	}
+	defer dw.Stop()
+
+	// The new shard is not published yet.
+	if got := <-logger.loads; got != oldShard {
+		t.Fatalf("got load event %v, want %v", got, oldShard)
+	}
+
+	advanceFS()
+	writeManifest(newShard)
+
+	if got := <-logger.drops; got != oldShard {
+		t.Fatalf("got drops event %v, want %v", got, oldShard)
+	}
+	if got := <-logger.loads; got != newShard {
+		t.Fatalf("got load event %v, want %v", got, newShard)
+	}
+
+	advanceFS()
+	dw.Stop()
+
+	select {
+	case k := <-logger.loads:
+		t.Errorf("spurious load of %q", k)
+	case k := <-logger.drops:
+		t.Errorf("spurious drops of %q", k)
+	default:
+	}
+}
+
 func TestHumanTruncateList(t *testing.T) {
 	paths := []string{
 		"dir/1",
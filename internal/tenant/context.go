@@ -73,3 +73,14 @@ func shouldLogNoTenant() bool {
 		return false
 	}
 }
+
+// ReplayContext returns a context of the tenant with the ID, for tools which
+// replay the requests the tenant made earlier, like zoekt-replay. Never use
+// this for user requests.
+func ReplayContext(ctx context.Context, id int) (context.Context, error) {
+	tnt, err := tenanttype.FromID(id)
+	if err != nil {
+		return nil, err
+	}
+	return tenanttype.WithTenant(ctx, tnt), nil
+}
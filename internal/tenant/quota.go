@@ -0,0 +1,85 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Quota limits what a tenant can index. Zero fields are unlimited.
+type Quota struct {
+	// MaxRepos is the maximum number of indexed repositories.
+	MaxRepos int `json:"max_repos,omitempty"`
+
+	// MaxBytes is the maximum total size of the shards of the repositories.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// Usage is what a tenant has indexed.
+type Usage struct {
+	Repos int
+	Bytes int64
+}
+
+// Quotas are the quotas of all tenants.
+type Quotas struct {
+	// Default is the quota of tenants without one in Tenants.
+	Default Quota `json:"default"`
+
+	Tenants map[int]Quota `json:"tenants,omitempty"`
+}
+
+// LoadQuotas reads quotas from a JSON file like
+//
+//	{"default": {"max_repos": 1000}, "tenants": {"42": {"max_repos": 10, "max_bytes": 1073741824}}}
+func LoadQuotas(path string) (*Quotas, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var q Quotas
+	if err := json.Unmarshal(b, &q); err != nil {
+		return nil, fmt.Errorf("parsing tenant quotas %s: %w", path, err)
+	}
+	return &q, nil
+}
+
+// For returns the quota of tenant id. A nil Quotas is unlimited.
+func (q *Quotas) For(id int) Quota {
+	if q == nil {
+		return Quota{}
+	}
+	if quota, ok := q.Tenants[id]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// Check returns a *QuotaExceededError if tenant id can't index another
+// repository. others is the usage of the tenant without the repository, whose
+// shards are replaced by indexing it.
+func (q Quota) Check(id int, others Usage) error {
+	if (q.MaxRepos > 0 && others.Repos >= q.MaxRepos) || (q.MaxBytes > 0 && others.Bytes >= q.MaxBytes) {
+		return &QuotaExceededError{TenantID: id, Quota: q, Usage: others}
+	}
+	return nil
+}
+
+// QuotaExceededError is returned for repositories which are not indexed
+// because their tenant is at its quota.
+type QuotaExceededError struct {
+	TenantID int
+	Quota    Quota
+
+	// Usage is the usage of the tenant without the repository.
+	Usage Usage
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Quota.MaxRepos > 0 && e.Usage.Repos >= e.Quota.MaxRepos {
+		return fmt.Sprintf("tenant %d is at its quota of %d repositories", e.TenantID, e.Quota.MaxRepos)
+	}
+	return fmt.Sprintf("tenant %d is at its quota of %s with %s indexed", e.TenantID, humanize.IBytes(uint64(e.Quota.MaxBytes)), humanize.IBytes(uint64(e.Usage.Bytes)))
+}
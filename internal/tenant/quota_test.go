@@ -0,0 +1,62 @@
+package tenant
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	cases := []struct {
+		name   string
+		quota  Quota
+		others Usage
+		want   string
+	}{
+		{name: "unlimited", others: Usage{Repos: 1000, Bytes: 1 << 40}},
+		{name: "below", quota: Quota{MaxRepos: 2, MaxBytes: 100}, others: Usage{Repos: 1, Bytes: 99}},
+		{name: "repos", quota: Quota{MaxRepos: 2}, others: Usage{Repos: 2}, want: "tenant 42 is at its quota of 2 repositories"},
+		{name: "bytes", quota: Quota{MaxBytes: 1024}, others: Usage{Repos: 1, Bytes: 2048}, want: "tenant 42 is at its quota of 1.0 KiB with 2.0 KiB indexed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.quota.Check(42, tc.others)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			var qe *QuotaExceededError
+			if !errors.As(err, &qe) || qe.TenantID != 42 {
+				t.Fatalf("got %v, want a QuotaExceededError for tenant 42", err)
+			}
+			if err.Error() != tc.want {
+				t.Errorf("got %q, want %q", err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	if err := os.WriteFile(path, []byte(`{"default": {"max_repos": 10}, "tenants": {"42": {"max_bytes": 1024}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := LoadQuotas(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.For(1); got != (Quota{MaxRepos: 10}) {
+		t.Errorf("got default quota %+v", got)
+	}
+	if got := q.For(42); got != (Quota{MaxBytes: 1024}) {
+		t.Errorf("got quota %+v for tenant 42", got)
+	}
+
+	var unlimited *Quotas
+	if got := unlimited.For(42); got != (Quota{}) {
+		t.Errorf("got quota %+v without quotas", got)
+	}
+}
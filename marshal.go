@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"unsafe"
+
+	"github.com/RoaringBitmap/roaring"
 )
 
 // Wire-format of map[uint32]MinimalRepoListEntry is pretty straightforward:
@@ -154,6 +159,65 @@ func reposMapDecode(b []byte) (ReposMap, error) {
 	return m, r.err
 }
 
+// Wire-format of ResultSet:
+//
+// byte(1) version
+// uvarint(len(shards))
+// for shard, docs in shards:
+//   str(shard)
+//   uvarint(len(bitmap))
+//   bitmap, a serialized roaring bitmap of docs
+
+func resultSetEncode(r *ResultSet) ([]byte, error) {
+	var b bytes.Buffer
+	var enc [binary.MaxVarintLen64]byte
+	varint := func(n int) {
+		m := binary.PutUvarint(enc[:], uint64(n))
+		b.Write(enc[:m])
+	}
+
+	b.WriteByte(1)
+	varint(len(r.Shards))
+	for _, shard := range slices.Sorted(maps.Keys(r.Shards)) {
+		docs := r.Shards[shard]
+		varint(len(shard))
+		b.WriteString(shard)
+		varint(int(docs.GetSerializedSizeInBytes()))
+		if _, err := docs.WriteTo(&b); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func resultSetDecode(b []byte) (map[string]*roaring.Bitmap, error) {
+	r := binaryReader{typ: "ResultSet", b: b}
+	if v := r.byt(); r.err == nil && v != 1 {
+		return nil, fmt.Errorf("unsupported ResultSet encoding version %d", v)
+	}
+
+	l := r.uvarint()
+	shards := make(map[string]*roaring.Bitmap, min(l, len(r.b)))
+	for i := 0; i < l && r.err == nil; i++ {
+		// The strings of binaryReader point into b, which we don't own.
+		shard := strings.Clone(r.str())
+		n := r.uvarint()
+		if r.err != nil {
+			break
+		}
+		if n > len(r.b) {
+			return nil, fmt.Errorf("malformed %s", r.typ)
+		}
+		docs := roaring.New()
+		if err := docs.UnmarshalBinary(r.b[:n]); err != nil {
+			return nil, err
+		}
+		r.b = r.b[n:]
+		shards[shard] = docs
+	}
+	return shards, r.err
+}
+
 type binaryReader struct {
 	typ string
 	b   []byte
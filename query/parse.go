@@ -19,6 +19,10 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/internal/languages"
@@ -59,6 +63,18 @@ loop:
 	return lit, len(in) - len(left), nil
 }
 
+// parseVerbatimString parses a string enclosed in the delimiter at the start
+// of in, such as a backtick, and taken verbatim, without escape sequences. It
+// consumes the delimiters too. It reports false if the string is empty or
+// unterminated, in which case the delimiter is an ordinary character.
+func parseVerbatimString(in []byte) (lit []byte, n int, ok bool) {
+	end := bytes.IndexByte(in[1:], in[0])
+	if end <= 0 {
+		return nil, 0, false
+	}
+	return in[1 : end+1], end + 2, true
+}
+
 // orOperator is a placeholder intermediate so we can represent [A,
 // or, B] before we convert it to Or{A, B}
 type orOperator struct{}
@@ -73,19 +89,23 @@ func isSpace(c byte) bool {
 
 // Parse parses a string into a query.
 func Parse(qStr string) (Q, error) {
-	b := []byte(qStr)
-
-	qs, _, err := parseExprList(b)
+	q, err := parse(qStr)
 	if err != nil {
 		return nil, err
 	}
+	return Simplify(q), nil
+}
 
-	q, err := parseOperators(qs)
+// parse parses a string into a query without simplifying it.
+func parse(qStr string) (Q, error) {
+	b := []byte(qStr)
+
+	qs, _, err := parseExprList(b)
 	if err != nil {
 		return nil, err
 	}
 
-	return Simplify(q), nil
+	return parseOperators(qs)
 }
 
 // parseExpr parses a single expression, returning the result, and the
@@ -97,6 +117,21 @@ func parseExpr(in []byte) (Q, int, error) {
 		b = b[1:]
 	}
 
+	if m := boostRegexp.FindSubmatch(b); m != nil {
+		q, n, err := parseBoost(b[len(m[0]):], string(m[1]))
+		if err != nil {
+			return nil, 0, err
+		}
+		return q, len(in) - len(b) + len(m[0]) + n, nil
+	}
+	if m := budgetRegexp.FindSubmatch(b); m != nil {
+		q, n, err := parseBudget(b[len(m[0]):], string(m[1]))
+		if err != nil {
+			return nil, 0, err
+		}
+		return q, len(in) - len(b) + len(m[0]) + n, nil
+	}
+
 	tok, err := nextToken(b)
 	if err != nil {
 		return nil, 0, err
@@ -107,6 +142,14 @@ func parseExpr(in []byte) (Q, int, error) {
 	b = b[len(tok.Input):]
 
 	text := string(tok.Text)
+	if tok.Literal {
+		// Literal patterns match verbatim, also in the atoms which take a
+		// regexp. regex: keeps interpreting them as a regexp.
+		switch tok.Type {
+		case tokText, tokFile, tokContent, tokHasFile, tokHasContent, tokSym, tokRepo:
+			text = regexp.QuoteMeta(text)
+		}
+	}
 	switch tok.Type {
 	case tokCase:
 		switch text {
@@ -151,8 +194,22 @@ func parseExpr(in []byte) (Q, int, error) {
 		default:
 			return nil, 0, fmt.Errorf("query: unknown public argument %q, want {yes,no}", text)
 		}
+	case tokVisibility:
+		switch text {
+		case "public":
+			expr = RawConfig(RcOnlyPublic)
+		case "private":
+			expr = RawConfig(RcOnlyPrivate)
+		default:
+			return nil, 0, fmt.Errorf("query: unknown visibility argument %q, want {public,private}", text)
+		}
 	case tokBranch:
 		expr = &Branch{Pattern: text}
+	case tokRev:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the rev: atom must have an argument")
+		}
+		expr = &Branch{Pattern: text, Exact: true}
 	case tokText, tokRegex:
 		q, err := RegexpQuery(text, false, false)
 		if err != nil {
@@ -171,6 +228,17 @@ func parseExpr(in []byte) (Q, int, error) {
 			return nil, 0, err
 		}
 		expr = q
+	case tokHasFile, tokHasContent:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the has.file: and has.content: atoms must have an argument")
+		}
+		q, err := RegexpQuery(text, tok.Type == tokHasContent, tok.Type == tokHasFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The repositories with a matching file are evaluated before the
+		// query, like type:repo.
+		expr = &Type{Type: TypeRepo, Child: q}
 	case tokLang:
 		canonical, ok := languages.GetLanguageByAlias(text)
 		if !ok {
@@ -234,16 +302,168 @@ func parseExpr(in []byte) (Q, int, error) {
 			t = TypeFileName
 		case "repo":
 			t = TypeRepo
+		case "commit":
+			t = TypeCommit
 		default:
-			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo}", text)
+			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo,commit}", text)
 		}
 		// Later we will lift this into a root, like we do for caseQ
 		expr = &Type{Type: t, Child: nil}
+
+	case tokMeta:
+		key, value, _ := strings.Cut(text, ":")
+		if key == "" || value == "" {
+			return nil, 0, fmt.Errorf("query: meta. wants a key and a value, eg. meta.team:search, got %q", text)
+		}
+		expr = &Meta{Key: key, Value: value}
+
+	case tokFuzzy:
+		if utf8.RuneCountInString(text) < FuzzyMinLength {
+			return nil, 0, fmt.Errorf("query: fuzzy: needs at least %d characters, got %q", FuzzyMinLength, text)
+		}
+		expr = &Fuzzy{Pattern: text}
+
+	case tokDepth:
+		q, err := parsePathDepth(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = q
+
+	case tokIs:
+		switch text {
+		case DocFlagTest:
+			expr = &DocFlag{Flag: text}
+		default:
+			return nil, 0, fmt.Errorf("query: unknown is argument %q, want {test}", text)
+		}
 	}
 
 	return expr, len(in) - len(b), nil
 }
 
+// parsePathDepth parses the argument of depth:, which is a number of path
+// separators, optionally preceded by a comparison, eg. "<3", or a range of
+// them, eg. "1..3".
+func parsePathDepth(text string) (*PathDepth, error) {
+	atoi := func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("query: depth: wants a number of path separators, got %q", text)
+		}
+		return n, nil
+	}
+
+	if lo, hi, ok := strings.Cut(text, ".."); ok {
+		min, err := atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		max, err := atoi(hi)
+		if err != nil {
+			return nil, err
+		}
+		return &PathDepth{Min: min, Max: max}, nil
+	}
+
+	for _, op := range []string{"<=", ">=", "<", ">"} {
+		rest, ok := strings.CutPrefix(text, op)
+		if !ok {
+			continue
+		}
+		n, err := atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<=":
+			return &PathDepth{Min: 0, Max: n}, nil
+		case ">=":
+			return &PathDepth{Min: n, Max: -1}, nil
+		case "<":
+			if n == 0 {
+				return nil, fmt.Errorf("query: depth:<0 matches no files")
+			}
+			return &PathDepth{Min: 0, Max: n - 1}, nil
+		default:
+			return &PathDepth{Min: n + 1, Max: -1}, nil
+		}
+	}
+
+	n, err := atoi(text)
+	if err != nil {
+		return nil, err
+	}
+	return &PathDepth{Min: n, Max: n}, nil
+}
+
+// boostRegexp matches the start of boost(weight, query). Text like "boost("
+// which is not followed by a number and a comma is searched for as usual.
+var boostRegexp = regexp.MustCompile(`^boost\(\s*([0-9.eE+-]+)\s*,`)
+
+// parseBoost parses the query of boost(weight, query) up to and including
+// the close paren, returning the result, and the number of bytes consumed.
+func parseBoost(in []byte, weight string) (Q, int, error) {
+	boost, err := strconv.ParseFloat(weight, 64)
+	if err != nil || boost <= 0 {
+		return nil, 0, fmt.Errorf("query: boost weight must be a positive number, got %q", weight)
+	}
+
+	child, n, err := parseWrapped(in, "boost")
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Boost{Boost: boost, Child: child}, n, nil
+}
+
+// budgetRegexp matches the start of budget(timeout, query), eg.
+// budget(100ms, re:a.*b).
+var budgetRegexp = regexp.MustCompile(`^budget\(\s*([0-9.]+[a-zµ]+)\s*,`)
+
+// parseBudget parses the query of budget(timeout, query) up to and including
+// the close paren, returning the result, and the number of bytes consumed.
+func parseBudget(in []byte, timeout string) (Q, int, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return nil, 0, fmt.Errorf("query: budget timeout must be a positive duration, got %q", timeout)
+	}
+
+	child, n, err := parseWrapped(in, "budget")
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Budget{Timeout: d, Child: child}, n, nil
+}
+
+// parseWrapped parses the query of a function like boost(weight, query) up
+// to and including the close paren, returning the result, and the number of
+// bytes consumed.
+func parseWrapped(in []byte, name string) (Q, int, error) {
+	qs, n, err := parseExprList(in)
+	if err != nil {
+		return nil, 0, err
+	}
+	b := in[n:]
+
+	pTok, err := nextToken(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pTok == nil || pTok.Type != tokParenClose {
+		return nil, 0, fmt.Errorf("query: missing close paren of %s, got token %v", name, pTok)
+	}
+	b = b[len(pTok.Input):]
+	if len(qs) == 0 {
+		return nil, 0, fmt.Errorf("query: %s needs a query", name)
+	}
+
+	child, err := parseOperators(qs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return child, len(in) - len(b), nil
+}
+
 const regexpFlags syntax.Flags = syntax.ClassNL | syntax.PerlX | syntax.UnicodeGroups
 
 // RegexpQuery parses an atom into either a regular expression, or a
@@ -340,7 +560,11 @@ func parseExprList(in []byte) ([]Q, int, error) {
 		case *caseQ:
 			setCase = s.Flavor
 		case *Type:
-			if s.Type < typeT {
+			// Types with a child are predicates, eg. has.file:, rather
+			// than the type: of the query.
+			if s.Child != nil {
+				newQS = append(newQS, q)
+			} else if s.Type < typeT {
 				typeT = s.Type
 			}
 		default:
@@ -366,6 +590,10 @@ type token struct {
 
 	// The input that we consumed to form the token.
 	Input []byte
+
+	// Literal is set if Text came from a raw string or a literal: atom, and
+	// must not be interpreted as a regexp.
+	Literal bool
 }
 
 func (t *token) String() string {
@@ -392,15 +620,28 @@ const (
 	tokArchived   = 15
 	tokPublic     = 16
 	tokFork       = 17
+	tokVisibility = 18
+	tokRev        = 19
+	tokHasFile    = 20
+	tokHasContent = 21
+	tokIs         = 22
+	tokDepth      = 23
+	tokMeta       = 24
+	tokFuzzy      = 25
 )
 
 var tokNames = map[int]string{
 	tokArchived:   "Archived",
 	tokBranch:     "Branch",
 	tokCase:       "Case",
+	tokDepth:      "Depth",
 	tokError:      "Error",
 	tokFile:       "File",
 	tokFork:       "Fork",
+	tokFuzzy:      "Fuzzy",
+	tokHasContent: "HasContent",
+	tokHasFile:    "HasFile",
+	tokIs:         "Is",
 	tokNegate:     "Negate",
 	tokOr:         "Or",
 	tokParenClose: "ParenClose",
@@ -408,32 +649,46 @@ var tokNames = map[int]string{
 	tokPublic:     "Public",
 	tokRegex:      "Regex",
 	tokRepo:       "Repo",
+	tokRev:        "Rev",
 	tokText:       "Text",
 	tokLang:       "Language",
+	tokMeta:       "Meta",
 	tokSym:        "Symbol",
 	tokType:       "Type",
+	tokVisibility: "Visibility",
 }
 
 var prefixes = map[string]int{
-	"archived:": tokArchived,
-	"b:":        tokBranch,
-	"branch:":   tokBranch,
-	"c:":        tokContent,
-	"case:":     tokCase,
-	"content:":  tokContent,
-	"f:":        tokFile,
-	"file:":     tokFile,
-	"fork:":     tokFork,
-	"public:":   tokPublic,
-	"r:":        tokRepo,
-	"regex:":    tokRegex,
-	"repo:":     tokRepo,
-	"lang:":     tokLang,
-	"sym:":      tokSym,
-	"t:":        tokType,
-	"type:":     tokType,
+	"archived:":    tokArchived,
+	"b:":           tokBranch,
+	"branch:":      tokBranch,
+	"c:":           tokContent,
+	"case:":        tokCase,
+	"content:":     tokContent,
+	"depth:":       tokDepth,
+	"f:":           tokFile,
+	"file:":        tokFile,
+	"fork:":        tokFork,
+	"fuzzy:":       tokFuzzy,
+	"has.content:": tokHasContent,
+	"has.file:":    tokHasFile,
+	"is:":          tokIs,
+	"public:":      tokPublic,
+	"r:":           tokRepo,
+	"regex:":       tokRegex,
+	"repo:":        tokRepo,
+	"rev:":         tokRev,
+	"lang:":        tokLang,
+	"sym:":         tokSym,
+	"t:":           tokType,
+	"type:":        tokType,
+	"visibility:":  tokVisibility,
 }
 
+// metaPrefix starts the meta.key:value atoms, whose prefix depends on the
+// key.
+const metaPrefix = "meta."
+
 var reservedWords = map[string]int{
 	"or": tokOr,
 }
@@ -442,10 +697,10 @@ func (t *token) setType() {
 	// After we consumed the input, we have to interpret some of the text,
 	// eg. to distinguish between ")" the text and ) the query grouping
 	// parenthesis.
-	if len(t.Text) == 1 && t.Text[0] == '(' {
+	if len(t.Text) == 1 && t.Text[0] == '(' && !t.Literal {
 		t.Type = tokParenOpen
 	}
-	if len(t.Text) == 1 && t.Text[0] == ')' {
+	if len(t.Text) == 1 && t.Text[0] == ')' && !t.Literal {
 		t.Type = tokParenClose
 	}
 
@@ -463,10 +718,38 @@ func (t *token) setType() {
 
 		t.Text = t.Text[len(pref):]
 		t.Type = typ
-		break
+		return
+	}
+
+	if isMetaAtom(t.Input) {
+		t.Text = t.Text[len(metaPrefix):]
+		t.Type = tokMeta
 	}
 }
 
+// isMetaAtom returns true if in, the input of a token, is a meta.key:value
+// atom: a key of letters, digits, '_' and '-' directly followed by ':' and
+// a value. Anything else, eg. meta.json: or meta.go:12, which reads like a
+// file name and a line number, is text. A value of digits has to be quoted,
+// eg. meta.tier:"1".
+func isMetaAtom(in []byte) bool {
+	rest, ok := bytes.CutPrefix(in, []byte(metaPrefix))
+	if !ok {
+		return false
+	}
+	key, value, ok := bytes.Cut(rest, []byte(":"))
+	if !ok || len(key) == 0 || len(value) == 0 {
+		return false
+	}
+	for i, c := range key {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		if !letter && (i == 0 || c != '-' && (c < '0' || c > '9')) {
+			return false
+		}
+	}
+	return bytes.ContainsFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+}
+
 // nextToken returns the next token from the given input.
 func nextToken(in []byte) (*token, error) {
 	left := in[:]
@@ -484,6 +767,10 @@ func nextToken(in []byte) (*token, error) {
 		}, nil
 	}
 
+	if bytes.HasPrefix(left, []byte(literalPrefix)) {
+		return nextLiteral(in)
+	}
+
 	foundSpace := false
 
 loop:
@@ -514,6 +801,19 @@ loop:
 			}
 			cur.Text = append(cur.Text, t...)
 			left = left[n:]
+		case '`':
+			// A raw string directly follows a field, eg. file:`a+b`.
+			// Elsewhere backticks are ordinary characters.
+			if _, ok := prefixes[string(in[:len(in)-len(left)])]; ok {
+				if t, n, ok := parseVerbatimString(left); ok {
+					cur.Text = append(cur.Text, t...)
+					cur.Literal = true
+					left = left[n:]
+					break loop
+				}
+			}
+			cur.Text = append(cur.Text, c)
+			left = left[1:]
 		case '\\':
 			left = left[1:]
 			if len(left) == 0 {
@@ -547,3 +847,48 @@ loop:
 	cur.setType()
 	return &cur, nil
 }
+
+const literalPrefix = "literal:"
+
+// nextLiteral returns the token of the literal: atom at the start of in. Its
+// argument is a raw or a quoted string, or the text up to the next space, all
+// of them without escape sequences. Like other text, the text ends at an
+// unbalanced ")".
+func nextLiteral(in []byte) (*token, error) {
+	left := in[len(literalPrefix):]
+	var text []byte
+	if len(left) > 0 && (left[0] == '`' || left[0] == '"') {
+		if t, n, ok := parseVerbatimString(left); ok {
+			text = t
+			left = left[n:]
+		}
+	}
+	if text == nil {
+		parenCount := 0
+	loop:
+		for len(left) > 0 {
+			switch left[0] {
+			case ' ', '\n', '\t':
+				break loop
+			case '(':
+				parenCount++
+			case ')':
+				if parenCount == 0 {
+					break loop
+				}
+				parenCount--
+			}
+			text = append(text, left[0])
+			left = left[1:]
+		}
+	}
+	if len(text) == 0 {
+		return nil, fmt.Errorf("the literal: atom must have an argument")
+	}
+	return &token{
+		Type:    tokText,
+		Text:    text,
+		Input:   in[:len(in)-len(left)],
+		Literal: true,
+	}, nil
+}
@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"regexp/syntax"
 	"testing"
+	"time"
 
 	"github.com/grafana/regexp"
 )
@@ -64,6 +65,7 @@ func TestParseQuery(t *testing.T) {
 		{"abccase:yes", &Substring{Pattern: "abccase:yes"}},
 		{"file:abc", &Substring{Pattern: "abc", FileName: true}},
 		{"branch:pqr", &Branch{Pattern: "pqr"}},
+		{"rev:v1.0.0", &Branch{Pattern: "v1.0.0", Exact: true}},
 		{"((x|y) )", &Regexp{Regexp: mustParseRE("[xy]")}},
 		{"archived:yes", RawConfig(RcOnlyArchived)},
 		{"archived:no", RawConfig(RcNoArchived)},
@@ -71,6 +73,8 @@ func TestParseQuery(t *testing.T) {
 		{"fork:no", RawConfig(RcNoForks)},
 		{"public:yes", RawConfig(RcOnlyPublic)},
 		{"public:no", RawConfig(RcOnlyPrivate)},
+		{"visibility:public", RawConfig(RcOnlyPublic)},
+		{"visibility:private", RawConfig(RcOnlyPrivate)},
 		{"file:helpers\\.go byte", NewAnd(
 			&Substring{Pattern: "helpers.go", FileName: true},
 			&Substring{Pattern: "byte"})},
@@ -111,19 +115,116 @@ func TestParseQuery(t *testing.T) {
 
 		// type
 		{"type:repo abc", &Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}},
+		{"type:commit abc", &Type{Type: TypeCommit, Child: &Substring{Pattern: "abc"}}},
 		{"type:file abc def", &Type{Type: TypeFileName, Child: NewAnd(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
 		{"(type:repo abc) def", NewAnd(&Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}, &Substring{Pattern: "def"})},
 
+		// has
+		{"has.file:go\\.mod", &Type{Type: TypeRepo, Child: &Substring{Pattern: "go.mod", FileName: true}}},
+		{"has.content:TODO abc", NewAnd(
+			&Type{Type: TypeRepo, Child: &Substring{Pattern: "TODO", Content: true, CaseSensitive: true}},
+			&Substring{Pattern: "abc"})},
+		{"-has.file:go\\.mod type:file abc", &Type{Type: TypeFileName, Child: NewAnd(
+			&Not{Child: &Type{Type: TypeRepo, Child: &Substring{Pattern: "go.mod", FileName: true}}},
+			&Substring{Pattern: "abc"})}},
+		{"has.file:Makefile case:no", &Type{Type: TypeRepo, Child: &Substring{Pattern: "Makefile", FileName: true}}},
+
+		// literal
+		{"literal:foo(bar", &Substring{Pattern: "foo(bar"}},
+		{"literal:a\\b.*", &Substring{Pattern: "a\\b.*"}},
+		{"literal:`if (x) {` abc", NewAnd(&Substring{Pattern: "if (x) {"}, &Substring{Pattern: "abc"})},
+		{"literal:\"a b\"", &Substring{Pattern: "a b"}},
+		{"(literal:f(x y) abc", NewAnd(&Substring{Pattern: "f(x"}, &Substring{Pattern: "y"}, &Substring{Pattern: "abc"})},
+		{"-literal:[x]", &Not{Child: &Substring{Pattern: "[x]"}}},
+		{"literal:`fmt.Println(\"x\")`", &Substring{Pattern: `fmt.Println("x")`, CaseSensitive: true}},
+		{`literal:"a\nb"`, &Substring{Pattern: `a\nb`}},
+		{"literal:`abc", &Substring{Pattern: "`abc"}},
+		{"literal:``", &Substring{Pattern: "``"}},
+		{"file:`a+b.go`", &Substring{Pattern: "a+b.go", FileName: true}},
+		{"sym:`operator()`", &Symbol{&Substring{Pattern: "operator()"}}},
+		{"repo:`a.b`", &Repo{regexp.MustCompile(`a\.b`)}},
+		{"regex:`\\d+(x|y)`", &Regexp{Regexp: mustParseRE(`\d+[xy]`)}},
+		{"file:``", &Substring{Pattern: "``", FileName: true}},
+		{"file:`abc", &Substring{Pattern: "`abc", FileName: true}},
+		{"a`b", &Substring{Pattern: "a`b"}},
+
+		// backticks outside of a field are ordinary characters
+		{"```go", &Substring{Pattern: "```go"}},
+		{"`foo`", &Substring{Pattern: "`foo`"}},
+		{"echo `date`", NewAnd(&Substring{Pattern: "echo"}, &Substring{Pattern: "`date`"})},
+		{"`", &Substring{Pattern: "`"}},
+
+		// boost
+		{"boost(2.5, abc)", &Boost{Boost: 2.5, Child: &Substring{Pattern: "abc"}}},
+		{"boost(2,abc) or boost(0.5, f:def ghi)", NewOr(
+			&Boost{Boost: 2, Child: &Substring{Pattern: "abc"}},
+			&Boost{Boost: 0.5, Child: NewAnd(&Substring{Pattern: "def", FileName: true}, &Substring{Pattern: "ghi"})})},
+		{"boost(3, abc or def) ghi", NewAnd(
+			&Boost{Boost: 3, Child: NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})},
+			&Substring{Pattern: "ghi"})},
+		{"boost(abc)", &Substring{Pattern: "boostabc"}},
+
+		// budget
+		{"budget(100ms, abc) def", NewAnd(
+			&Budget{Timeout: 100 * time.Millisecond, Child: &Substring{Pattern: "abc"}},
+			&Substring{Pattern: "def"})},
+		{"budget(1.5s, abc or def)", &Budget{Timeout: 1500 * time.Millisecond, Child: NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
+
+		// document flags
+		{"abc -is:test", NewAnd(&Substring{Pattern: "abc"}, &Not{&DocFlag{Flag: DocFlagTest}})},
+
+		// path depth
+		{"depth:0", &PathDepth{Min: 0, Max: 0}},
+		{"depth:<3 abc", NewAnd(&PathDepth{Min: 0, Max: 2}, &Substring{Pattern: "abc"})},
+		{"depth:<=3", &PathDepth{Min: 0, Max: 3}},
+		{"depth:>1", &PathDepth{Min: 2, Max: -1}},
+		{"depth:>=1", &PathDepth{Min: 1, Max: -1}},
+		{"depth:1..3", &PathDepth{Min: 1, Max: 3}},
+
+		// fuzzy identifiers
+		{"fuzzy:parseQeury", &Fuzzy{Pattern: "parseQeury"}},
+		{`fuzzy:"parse query" abc`, NewAnd(&Fuzzy{Pattern: "parse query"}, &Substring{Pattern: "abc"})},
+
+		// document labels
+		{"meta.team:search", &Meta{Key: "team", Value: "search"}},
+		{`meta.service:"billing api" abc`, NewAnd(&Meta{Key: "service", Value: "billing api"}, &Substring{Pattern: "abc"})},
+		{`-meta.tier:"1"`, &Not{&Meta{Key: "tier", Value: "1"}}},
+		{"meta.on-call:yes", &Meta{Key: "on-call", Value: "yes"}},
+		{"meta.go", &Regexp{Regexp: mustParseRE("meta.go")}},
+		{"meta.go:12", &Regexp{Regexp: mustParseRE("meta.go:12")}},
+		{"meta.json:", &Regexp{Regexp: mustParseRE("meta.json:")}},
+		{"meta.:search", &Regexp{Regexp: mustParseRE("meta.:search")}},
+		{"meta.a/b:c", &Regexp{Regexp: mustParseRE("meta.a/b:c")}},
+
 		// errors.
 		{"--", nil},
 		{"\"abc", nil},
 		{"\"a\\", nil},
 		{"case:foo", nil},
+		{"literal:", nil},
 
 		{"sym:", nil},
+		{"rev:", nil},
+		{"has.file:", nil},
+		{"is:tests", nil},
+		{"depth:", nil},
+		{"depth:<0", nil},
+		{"depth:-1", nil},
+		{"depth:<a", nil},
+		{"depth:1..", nil},
+		{"fuzzy:", nil},
+		{"fuzzy:ab", nil},
+		{`meta.team:""`, nil},
 		{"abc or", nil},
 		{"or abc", nil},
 		{"def or or abc", nil},
+		{"boost(0, abc)", nil},
+		{"boost(1.2.3, abc)", nil},
+		{"boost(2, abc", nil},
+		{"boost(2, )", nil},
+		{"budget(0s, abc)", nil},
+		{"budget(10xs, abc)", nil},
+		{"budget(1s, abc", nil},
 
 		{"", &Const{Value: true}},
 	} {
@@ -163,6 +264,11 @@ func TestTokenize(t *testing.T) {
 		{"o\"r\" bla", tokText, "or"},
 		{"or bla", tokOr, "or"},
 		{"ar bla", tokText, "ar"},
+		{"literal:`(` bla", tokText, "("},
+		{"literal:`or` bla", tokText, "or"},
+		{"(`a` b)", tokParenOpen, "("},
+		{"`a` b", tokText, "`a`"},
+		{"literal:a\\b) ", tokText, "a\\b"},
 	}
 	for _, c := range cases {
 		tok, err := nextToken([]byte(c.in))
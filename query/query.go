@@ -20,9 +20,11 @@ import (
 	"log"
 	"reflect"
 	"regexp/syntax"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
@@ -144,6 +146,63 @@ func (l *Language) String() string {
 	return "lang:" + l.Language
 }
 
+// DocFlagTest is the flag of test files.
+const DocFlagTest = "test"
+
+// DocFlag matches documents with a flag, which the indexer classifies
+// documents by, eg. DocFlagTest for test files.
+type DocFlag struct {
+	Flag string
+}
+
+func (q *DocFlag) String() string {
+	return "is:" + q.Flag
+}
+
+// Meta matches documents whose label Key has the value Value, see
+// index.Document.Labels.
+type Meta struct {
+	Key, Value string
+}
+
+func (q *Meta) String() string {
+	return fmt.Sprintf("meta.%s:%q", q.Key, q.Value)
+}
+
+// FuzzyMinLength is the minimum number of characters of Fuzzy patterns.
+// One edit away from shorter patterns is nearly every short name.
+const FuzzyMinLength = 3
+
+// Fuzzy matches the symbols whose names are at most one edit away from
+// Pattern, ignoring case. An edit inserts, deletes or replaces a character,
+// or swaps two adjacent ones, so fuzzy:parseQeury finds parseQuery.
+type Fuzzy struct {
+	Pattern string
+}
+
+func (q *Fuzzy) String() string {
+	return fmt.Sprintf("fuzzy:%q", q.Pattern)
+}
+
+// PathDepth matches files by the number of path separators in their names,
+// eg. 0 for the files at the top level of a repository. Min and Max are
+// inclusive, and a negative Max means no upper bound.
+type PathDepth struct {
+	Min, Max int
+}
+
+func (q *PathDepth) String() string {
+	switch {
+	case q.Max < 0:
+		return fmt.Sprintf("depth:>=%d", q.Min)
+	case q.Min == q.Max:
+		return fmt.Sprintf("depth:%d", q.Min)
+	case q.Min <= 0:
+		return fmt.Sprintf("depth:<=%d", q.Max)
+	}
+	return fmt.Sprintf("depth:%d..%d", q.Min, q.Max)
+}
+
 type Const struct {
 	Value bool
 }
@@ -352,6 +411,9 @@ const (
 	TypeFileMatch uint8 = iota
 	TypeFileName
 	TypeRepo
+	// TypeCommit matches the commit messages indexed alongside the code
+	// instead of the files.
+	TypeCommit
 )
 
 // Type changes the result type returned.
@@ -368,6 +430,8 @@ func (q *Type) String() string {
 		return fmt.Sprintf("(type:filename %s)", q.Child)
 	case TypeRepo:
 		return fmt.Sprintf("(type:repo %s)", q.Child)
+	case TypeCommit:
+		return fmt.Sprintf("(type:commit %s)", q.Child)
 	default:
 		return fmt.Sprintf("(type:UNKNOWN %s)", q.Child)
 	}
@@ -386,6 +450,20 @@ func (q *Boost) String() string {
 	return fmt.Sprintf("(boost %0.2f %s)", q.Boost, q.Child)
 }
 
+// Budget marks an expensive query, eg. a broad regexp. It is evaluated after
+// the cheaper parts of the query, for the documents they leave, and stops
+// matching once its evaluation took Timeout in a shard.
+type Budget struct {
+	Child Q
+	// Timeout is the time the evaluation of Child may take in each shard.
+	// Once it is spent, Child matches no more documents of the shard.
+	Timeout time.Duration
+}
+
+func (q *Budget) String() string {
+	return fmt.Sprintf("(budget %s %s)", q.Timeout, q.Child)
+}
+
 // Substring is the most basic query: a query for a substring.
 type Substring struct {
 	Pattern       string
@@ -562,6 +640,9 @@ func flatten(q Q) (Q, bool) {
 	case *Boost:
 		child, changed := flatten(s.Child)
 		return &Boost{Child: child, Boost: s.Boost}, changed
+	case *Budget:
+		child, changed := flatten(s.Child)
+		return &Budget{Child: child, Timeout: s.Timeout}, changed
 	default:
 		return q, false
 	}
@@ -639,6 +720,12 @@ func evalConstants(q Q) Q {
 			return ch
 		}
 		return &Boost{Boost: s.Boost, Child: ch}
+	case *Budget:
+		ch := evalConstants(s.Child)
+		if _, ok := ch.(*Const); ok {
+			return ch
+		}
+		return &Budget{Timeout: s.Timeout, Child: ch}
 	case *Substring:
 		if len(s.Pattern) == 0 {
 			return &Const{true}
@@ -670,6 +757,13 @@ func evalConstants(q Q) Q {
 		if len(s.Set) == 0 {
 			return &Const{false}
 		}
+	case *PathDepth:
+		if s.Max >= 0 && s.Max < s.Min {
+			return &Const{false}
+		}
+		if s.Min <= 0 && s.Max < 0 {
+			return &Const{true}
+		}
 	}
 	return q
 }
@@ -700,6 +794,8 @@ func Map(q Q, f func(q Q) Q) Q {
 		q = &Type{Type: s.Type, Child: Map(s.Child, f)}
 	case *Boost:
 		q = &Boost{Boost: s.Boost, Child: Map(s.Child, f)}
+	case *Budget:
+		q = &Budget{Timeout: s.Timeout, Child: Map(s.Child, f)}
 	}
 	return f(q)
 }
@@ -732,6 +828,90 @@ func ExpandFileContent(q Q) Q {
 	return q
 }
 
+// AnyPathSeparator rewrites file name queries so every / or \ in their
+// pattern matches either path separator. Use it with Map.
+func AnyPathSeparator(q Q) Q {
+	switch s := q.(type) {
+	case *Substring:
+		if !s.FileName || s.Content || !strings.ContainsAny(s.Pattern, `/\`) {
+			return q
+		}
+		r, _ := anyPathSeparator(&syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune(s.Pattern)})
+		return &Regexp{
+			Regexp:        r,
+			FileName:      true,
+			CaseSensitive: s.CaseSensitive,
+		}
+	case *Regexp:
+		if !s.FileName || s.Content {
+			return q
+		}
+		r, changed := anyPathSeparator(s.Regexp)
+		if !changed {
+			return q
+		}
+		c := *s
+		c.Regexp = r
+		return &c
+	}
+	return q
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// anyPathSeparator returns a copy of re in which the path separators of
+// literals are replaced by a class of both separators.
+func anyPathSeparator(re *syntax.Regexp) (*syntax.Regexp, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if !slices.ContainsFunc(re.Rune, isPathSeparator) {
+			return re, false
+		}
+		concat := &syntax.Regexp{Op: syntax.OpConcat, Flags: re.Flags}
+		start := 0
+		for i, r := range re.Rune {
+			if !isPathSeparator(r) {
+				continue
+			}
+			if start < i {
+				concat.Sub = append(concat.Sub, &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: re.Rune[start:i]})
+			}
+			concat.Sub = append(concat.Sub, &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{'/', '/', '\\', '\\'}})
+			start = i + 1
+		}
+		if start < len(re.Rune) {
+			concat.Sub = append(concat.Sub, &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: re.Rune[start:]})
+		}
+		if len(concat.Sub) == 1 {
+			return concat.Sub[0], true
+		}
+		return concat, true
+	case syntax.OpCharClass:
+		return re, false
+	}
+
+	var subs []*syntax.Regexp
+	for i, sub := range re.Sub {
+		r, changed := anyPathSeparator(sub)
+		if !changed {
+			continue
+		}
+		if subs == nil {
+			subs = slices.Clone(re.Sub)
+		}
+		subs[i] = r
+	}
+	if subs == nil {
+		return re, false
+	}
+	c := *re
+	c.Sub = subs
+	c.Sub0 = [1]*syntax.Regexp{}
+	return &c, true
+}
+
 // VisitAtoms runs `v` on all atom queries within `q`.
 func VisitAtoms(q Q, v func(q Q)) {
 	Map(q, func(iQ Q) Q {
@@ -741,6 +921,7 @@ func VisitAtoms(q Q, v func(q Q)) {
 		case *Not:
 		case *Type:
 		case *Boost:
+		case *Budget:
 		default:
 			v(iQ)
 		}
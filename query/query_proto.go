@@ -6,6 +6,7 @@ import (
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 )
@@ -48,6 +49,16 @@ func QToProto(q Q) *proto.Q {
 		return &proto.Q{Query: &proto.Q_Branch{Branch: v.ToProto()}}
 	case *Boost:
 		return &proto.Q{Query: &proto.Q_Boost{Boost: v.ToProto()}}
+	case *Budget:
+		return &proto.Q{Query: &proto.Q_Budget{Budget: v.ToProto()}}
+	case *DocFlag:
+		return &proto.Q{Query: &proto.Q_DocFlag{DocFlag: v.ToProto()}}
+	case *PathDepth:
+		return &proto.Q{Query: &proto.Q_PathDepth{PathDepth: v.ToProto()}}
+	case *Meta:
+		return &proto.Q{Query: &proto.Q_Meta{Meta: v.ToProto()}}
+	case *Fuzzy:
+		return &proto.Q{Query: &proto.Q_Fuzzy{Fuzzy: v.ToProto()}}
 	default:
 		// The following nodes do not have a proto representation:
 		// - caseQ: only used internally, not by the RPC layer
@@ -93,6 +104,16 @@ func QFromProto(p *proto.Q) (Q, error) {
 		return BranchFromProto(v.Branch), nil
 	case *proto.Q_Boost:
 		return BoostFromProto(v.Boost)
+	case *proto.Q_Budget:
+		return BudgetFromProto(v.Budget)
+	case *proto.Q_DocFlag:
+		return DocFlagFromProto(v.DocFlag), nil
+	case *proto.Q_PathDepth:
+		return PathDepthFromProto(v.PathDepth), nil
+	case *proto.Q_Meta:
+		return MetaFromProto(v.Meta), nil
+	case *proto.Q_Fuzzy:
+		return FuzzyFromProto(v.Fuzzy), nil
 	default:
 		panic(fmt.Sprintf("unknown query node %T", p.Query))
 	}
@@ -296,6 +317,8 @@ func TypeFromProto(p *proto.Type) (*Type, error) {
 		kind = TypeFileName
 	case proto.Type_KIND_REPO:
 		kind = TypeRepo
+	case proto.Type_KIND_COMMIT:
+		kind = TypeCommit
 	}
 
 	return &Type{
@@ -314,6 +337,8 @@ func (q *Type) ToProto() *proto.Type {
 		kind = proto.Type_KIND_FILE_NAME
 	case TypeRepo:
 		kind = proto.Type_KIND_REPO
+	case TypeCommit:
+		kind = proto.Type_KIND_COMMIT
 	}
 
 	return &proto.Type{
@@ -382,6 +407,56 @@ func (q *Boost) ToProto() *proto.Boost {
 	}
 }
 
+func BudgetFromProto(p *proto.Budget) (*Budget, error) {
+	child, err := QFromProto(p.GetChild())
+	if err != nil {
+		return nil, err
+	}
+	return &Budget{
+		Child:   child,
+		Timeout: p.GetTimeout().AsDuration(),
+	}, nil
+}
+
+func (q *Budget) ToProto() *proto.Budget {
+	return &proto.Budget{
+		Child:   QToProto(q.Child),
+		Timeout: durationpb.New(q.Timeout),
+	}
+}
+
+func DocFlagFromProto(p *proto.DocFlag) *DocFlag {
+	return &DocFlag{Flag: p.GetFlag()}
+}
+
+func (q *DocFlag) ToProto() *proto.DocFlag {
+	return &proto.DocFlag{Flag: q.Flag}
+}
+
+func PathDepthFromProto(p *proto.PathDepth) *PathDepth {
+	return &PathDepth{Min: int(p.GetMin()), Max: int(p.GetMax())}
+}
+
+func (q *PathDepth) ToProto() *proto.PathDepth {
+	return &proto.PathDepth{Min: int32(q.Min), Max: int32(q.Max)}
+}
+
+func MetaFromProto(p *proto.Meta) *Meta {
+	return &Meta{Key: p.GetKey(), Value: p.GetValue()}
+}
+
+func (q *Meta) ToProto() *proto.Meta {
+	return &proto.Meta{Key: q.Key, Value: q.Value}
+}
+
+func FuzzyFromProto(p *proto.Fuzzy) *Fuzzy {
+	return &Fuzzy{Pattern: p.GetPattern()}
+}
+
+func (q *Fuzzy) ToProto() *proto.Fuzzy {
+	return &proto.Fuzzy{Pattern: q.Pattern}
+}
+
 func NotFromProto(p *proto.Not) (*Not, error) {
 	child, err := QFromProto(p.GetChild())
 	if err != nil {
@@ -3,6 +3,7 @@ package query
 import (
 	"regexp/syntax"
 	"testing"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/google/go-cmp/cmp"
@@ -87,6 +88,14 @@ func TestQueryRoundtrip(t *testing.T) {
 			},
 			Boost: 20,
 		},
+		&Budget{
+			Child:   &Regexp{Regexp: regexpMustParse("foo.*bar"), Content: true},
+			Timeout: 250 * time.Millisecond,
+		},
+		&DocFlag{Flag: DocFlagTest},
+		&PathDepth{Min: 1, Max: -1},
+		&Meta{Key: "team", Value: "search"},
+		&Fuzzy{Pattern: "parseQeury"},
 	}
 
 	for _, q := range testCases {
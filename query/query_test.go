@@ -40,6 +40,45 @@ func TestQueryString(t *testing.T) {
 	}
 }
 
+func TestPathDepthString(t *testing.T) {
+	for _, tt := range []struct {
+		q    *PathDepth
+		want string
+	}{
+		{&PathDepth{Min: 0, Max: 0}, "depth:0"},
+		{&PathDepth{Min: 0, Max: 2}, "depth:<=2"},
+		{&PathDepth{Min: 2, Max: -1}, "depth:>=2"},
+		{&PathDepth{Min: 1, Max: 3}, "depth:1..3"},
+	} {
+		if got := tt.q.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+		// The string of a PathDepth parses back to it.
+		if q, err := Parse(tt.want); err != nil || !reflect.DeepEqual(q, tt.q) {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.want, q, err, tt.q)
+		}
+	}
+}
+
+func TestMetaString(t *testing.T) {
+	for _, tt := range []struct {
+		q    *Meta
+		want string
+	}{
+		{&Meta{Key: "team", Value: "search"}, `meta.team:"search"`},
+		{&Meta{Key: "service", Value: "billing api"}, `meta.service:"billing api"`},
+		{&Meta{Key: "tier", Value: "1"}, `meta.tier:"1"`},
+	} {
+		if got := tt.q.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+		// The string of a Meta parses back to it.
+		if q, err := Parse(tt.want); err != nil || !reflect.DeepEqual(q, tt.q) {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.want, q, err, tt.q)
+		}
+	}
+}
+
 func TestSimplify(t *testing.T) {
 	type testcase struct {
 		in   Q
@@ -90,6 +129,9 @@ func TestSimplify(t *testing.T) {
 				NewSingleBranchesRepos("HEAD", 1),
 				&Not{&Type{Type: TypeRepo, Child: &Substring{Pattern: "hi"}}}),
 		},
+		{in: &PathDepth{Min: 3, Max: 2}, want: &Const{false}},
+		{in: &PathDepth{Min: 0, Max: -1}, want: &Const{true}},
+		{in: &PathDepth{Min: 1, Max: -1}, want: &PathDepth{Min: 1, Max: -1}},
 	}
 
 	for _, c := range cases {
@@ -178,3 +220,28 @@ func TestExpandFileContent(t *testing.T) {
 		})
 	}
 }
+
+func TestAnyPathSeparator(t *testing.T) {
+	cases := []struct {
+		q    string
+		want string
+	}{
+		{`file:src/main\.go`, `file_regex:"src[/\\\\]main\\.go"`},
+		{`case:yes file:src\\main\.go`, `case_file_regex:"src[/\\\\]main\\.go"`},
+		{`file:^/src/`, `file_regex:"(?m:^)[/\\\\]src[/\\\\]"`},
+		{`file:main.go`, `file_regex:"main(?-s:.)go"`},
+		{`file:(cmd|internal)/main`, `file_regex:"(?:cmd|internal)[/\\\\]main"`},
+		{`src/main`, `substr:"src/main"`},
+		{`content:src/main`, `content_substr:"src/main"`},
+	}
+
+	for _, tt := range cases {
+		q, err := Parse(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Map(q, AnyPathSeparator).String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/grafana/regexp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sourcegraph/zoekt/internal/syntaxutil"
+)
+
+// RewriteRule rewrites atoms of the form "field:value" which the parser does
+// not know about. Exactly one of Query, Repos and Rename must be set.
+type RewriteRule struct {
+	// Field is the name of the field before the colon, eg. "team".
+	Field string `yaml:"field"`
+
+	// Value is the value after the colon, eg. "payments". It must be set
+	// for Query and Repos rules.
+	Value string `yaml:"value,omitempty"`
+
+	// Query replaces the atom with this query, eg. "repo:^acme/billing$ or
+	// repo:^acme/checkout$".
+	Query string `yaml:"query,omitempty"`
+
+	// Repos replaces the atom with a RepoSet of these repositories.
+	Repos []string `yaml:"repos,omitempty"`
+
+	// Rename replaces the field name, keeping the value. It is used for
+	// deprecated fields, eg. "path:foo" with Rename "file" becomes
+	// "file:foo".
+	Rename string `yaml:"rename,omitempty"`
+}
+
+func (r *RewriteRule) validate() error {
+	if r.Field == "" || strings.ContainsAny(r.Field, ": \t") {
+		return fmt.Errorf("invalid field %q", r.Field)
+	}
+
+	set := 0
+	for _, ok := range []bool{r.Query != "", len(r.Repos) > 0, r.Rename != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("field %q: want exactly one of query, repos and rename", r.Field)
+	}
+
+	if r.Rename != "" {
+		if r.Value != "" {
+			return fmt.Errorf("field %q: rename applies to all values, value must be empty", r.Field)
+		}
+		if _, ok := prefixes[r.Rename+":"]; !ok {
+			return fmt.Errorf("field %q: unknown field %q to rename to", r.Field, r.Rename)
+		}
+		return nil
+	}
+
+	if r.Value == "" {
+		return fmt.Errorf("field %q: missing value", r.Field)
+	}
+	if r.Query != "" {
+		if _, err := parse(r.Query); err != nil {
+			return fmt.Errorf("field %q value %q: %w", r.Field, r.Value, err)
+		}
+	}
+	return nil
+}
+
+// Rewriter rewrites parsed queries with a list of rules, for example to
+// expand aliases for groups of repositories. Rules are applied after parsing
+// and before simplification. The result of a rule is not rewritten again.
+type Rewriter struct {
+	rules []RewriteRule
+}
+
+// NewRewriter returns a Rewriter for rules. Earlier rules take precedence.
+func NewRewriter(rules []RewriteRule) (*Rewriter, error) {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return &Rewriter{rules: rules}, nil
+}
+
+// LoadRewriter reads rules from a YAML or JSON file of the form
+//
+//	rules:
+//	- field: team
+//	  value: payments
+//	  repos: [github.com/acme/billing, github.com/acme/checkout]
+//	- field: path
+//	  rename: file
+func LoadRewriter(path string) (*Rewriter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Rules []RewriteRule `yaml:"rules"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	r, err := NewRewriter(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Parse parses qStr, rewrites it and simplifies the result. A nil Rewriter
+// behaves like the Parse function.
+func (r *Rewriter) Parse(qStr string) (Q, error) {
+	q, err := parse(qStr)
+	if err != nil {
+		return nil, err
+	}
+	q, err = r.Rewrite(q)
+	if err != nil {
+		return nil, err
+	}
+	return Simplify(q), nil
+}
+
+// Rewrite applies the rules to the atoms of q.
+func (r *Rewriter) Rewrite(q Q) (Q, error) {
+	if r == nil || len(r.rules) == 0 {
+		return q, nil
+	}
+
+	var err error
+	q = Map(q, func(q Q) Q {
+		if err != nil {
+			return q
+		}
+		var rewritten Q
+		rewritten, err = r.rewriteAtom(q)
+		return rewritten
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (r *Rewriter) rewriteAtom(q Q) (Q, error) {
+	var text string
+	var literal, caseSensitive bool
+	switch s := q.(type) {
+	case *Substring:
+		if s.FileName || s.Content {
+			return q, nil
+		}
+		text, literal, caseSensitive = s.Pattern, true, s.CaseSensitive
+	case *Regexp:
+		if s.FileName || s.Content {
+			return q, nil
+		}
+		text, caseSensitive = syntaxutil.RegexpString(s.Regexp), s.CaseSensitive
+	default:
+		return q, nil
+	}
+
+	field, value, ok := strings.Cut(text, ":")
+	if !ok {
+		return q, nil
+	}
+
+	for _, rule := range r.rules {
+		if rule.Field != field {
+			continue
+		}
+
+		if rule.Rename != "" {
+			if literal {
+				value = regexp.QuoteMeta(value)
+			}
+			return reparse(rule.Rename+":"+quote(value), caseSensitive)
+		}
+
+		if !literal || rule.Value != value {
+			continue
+		}
+		if len(rule.Repos) > 0 {
+			return NewRepoSet(rule.Repos...), nil
+		}
+		return parse(rule.Query)
+	}
+	return q, nil
+}
+
+// reparse parses qStr, keeping the case sensitivity of the atom it
+// replaces.
+func reparse(qStr string, caseSensitive bool) (Q, error) {
+	q, err := parse(qStr)
+	if err != nil {
+		return nil, err
+	}
+	return Map(q, func(q Q) Q {
+		switch s := q.(type) {
+		case *Substring:
+			s.CaseSensitive = caseSensitive
+		case *Regexp:
+			s.CaseSensitive = caseSensitive
+		}
+		return q
+	}), nil
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quote quotes s so the tokenizer reads it back as a single token.
+func quote(s string) string {
+	return `"` + quoteReplacer.Replace(s) + `"`
+}
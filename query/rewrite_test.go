@@ -0,0 +1,99 @@
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriter(t *testing.T) {
+	r, err := NewRewriter([]RewriteRule{
+		{Field: "team", Value: "payments", Repos: []string{"acme/billing", "acme/checkout"}},
+		{Field: "team", Value: "search", Query: "repo:^acme/search$ or repo:^acme/indexer$"},
+		{Field: "path", Rename: "file"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"foo team:payments", `(and substr:"foo" (reposet acme/billing acme/checkout))`},
+		{"foo team:search", `(and substr:"foo" (or repo:^acme/search$ repo:^acme/indexer$))`},
+		{"-team:search bar", `(and (not (or repo:^acme/search$ repo:^acme/indexer$)) substr:"bar")`},
+		{"path:main.go", `file_regex:"main(?-s:.)go"`},
+		{"path:README", `case_file_substr:"README"`},
+		{`path:"a b"`, `file_substr:"a b"`},
+		{"team:unknown", `substr:"team:unknown"`},
+		{"file:team:search", `file_substr:"team:search"`},
+		{"foo", `substr:"foo"`},
+	} {
+		q, err := r.Parse(tc.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if got := q.String(); got != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	// A nil Rewriter parses like Parse.
+	var nilRewriter *Rewriter
+	q, err := nilRewriter.Parse("team:search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.String(), `substr:"team:search"`; got != want {
+		t.Errorf("nil rewriter: got %s, want %s", got, want)
+	}
+}
+
+func TestRewriteRuleValidate(t *testing.T) {
+	for _, rule := range []RewriteRule{
+		{Value: "payments", Repos: []string{"acme/billing"}},
+		{Field: "team", Repos: []string{"acme/billing"}},
+		{Field: "team", Value: "payments"},
+		{Field: "team", Value: "payments", Query: "repo:x", Repos: []string{"acme/billing"}},
+		{Field: "team", Value: "payments", Query: "(repo:x"},
+		{Field: "path", Rename: "nofield"},
+		{Field: "path", Value: "x", Rename: "file"},
+	} {
+		if _, err := NewRewriter([]RewriteRule{rule}); err == nil {
+			t.Errorf("%+v: expected error", rule)
+		}
+	}
+}
+
+func TestLoadRewriter(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"rules.yaml": "rules:\n- field: team\n  value: payments\n  repos: [acme/billing]\n",
+		"rules.json": `{"rules": [{"field": "team", "value": "payments", "repos": ["acme/billing"]}]}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		r, err := LoadRewriter(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		q, err := r.Parse("team:payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := q.(*RepoSet); !ok {
+			t.Errorf("%s: got %s, want RepoSet", name, q)
+		}
+	}
+
+	path := filepath.Join(dir, "typo.yaml")
+	if err := os.WriteFile(path, []byte("rules:\n- field: team\n  valeu: payments\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRewriter(path); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
@@ -0,0 +1,207 @@
+package query
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/grafana/regexp"
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a stored query with parameters, eg. a vetted query which finds
+// uses of a deprecated package. Placeholders of the form ${param} stand for
+// the whole value of an atom, eg. "file:${path}", or for a part of a quoted
+// value, eg. `"import ${pkg}"`.
+type Template struct {
+	// Name is the name the template is called with, eg. "deprecation_check".
+	Name string `yaml:"name"`
+
+	// Params are the names of the parameters of the template.
+	Params []string `yaml:"params,omitempty"`
+
+	// Query is the query with placeholders for the parameters, eg.
+	// `"import ${pkg}" or "require ${pkg}"`.
+	Query string `yaml:"query"`
+}
+
+var (
+	templateNameRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderRe   = regexp.MustCompile(`\$\{([^}]*)\}`)
+	placeholderOnly = regexp.MustCompile(`^\$\{([^}]*)\}$`)
+)
+
+func (t *Template) validate() error {
+	if !templateNameRe.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+
+	args := map[string]string{}
+	for _, p := range t.Params {
+		if !templateNameRe.MatchString(p) {
+			return fmt.Errorf("template %q: invalid parameter name %q", t.Name, p)
+		}
+		if _, ok := args[p]; ok {
+			return fmt.Errorf("template %q: duplicate parameter %q", t.Name, p)
+		}
+		args[p] = "x"
+	}
+
+	used := map[string]bool{}
+	for _, m := range placeholderRe.FindAllStringSubmatch(t.Query, -1) {
+		used[m[1]] = true
+	}
+	for _, p := range t.Params {
+		if !used[p] {
+			return fmt.Errorf("template %q: parameter %q is not used", t.Name, p)
+		}
+	}
+
+	qStr, err := t.expand(args)
+	if err != nil {
+		return err
+	}
+	if _, err := parse(qStr); err != nil {
+		return fmt.Errorf("template %q: %w", t.Name, err)
+	}
+	return nil
+}
+
+// expand returns the query string of t with the placeholders replaced by
+// args. The arguments are quoted, so they are matched literally.
+func (t *Template) expand(args map[string]string) (string, error) {
+	for name := range args {
+		if !slices.Contains(t.Params, name) {
+			return "", fmt.Errorf("template %q: unknown parameter %q", t.Name, name)
+		}
+	}
+
+	var out strings.Builder
+	b := []byte(t.Query)
+	for len(b) > 0 {
+		n := 0
+		for n < len(b) && isSpace(b[n]) {
+			n++
+		}
+		out.Write(b[:n])
+		b = b[n:]
+
+		tok, err := nextToken(b)
+		if err != nil {
+			return "", fmt.Errorf("template %q: %w", t.Name, err)
+		}
+		if tok == nil {
+			break
+		}
+		b = b[len(tok.Input):]
+
+		input := string(tok.Input)
+		if !strings.Contains(input, "${") {
+			out.WriteString(input)
+			continue
+		}
+
+		prefix := ""
+		for pref, typ := range prefixes {
+			if typ == tok.Type && strings.HasPrefix(input, pref) {
+				prefix = pref
+				break
+			}
+		}
+		value := input[len(prefix):]
+		if !strings.HasPrefix(value, `"`) {
+			if !placeholderOnly.MatchString(value) {
+				return "", fmt.Errorf("template %q: placeholder in %q must be the whole value of an atom or be quoted", t.Name, input)
+			}
+			// Quoting the placeholder makes its argument a single token.
+			value = `"` + value + `"`
+		}
+
+		var missing string
+		value = placeholderRe.ReplaceAllStringFunc(value, func(p string) string {
+			name := placeholderRe.FindStringSubmatch(p)[1]
+			arg, ok := args[name]
+			if !ok {
+				missing = cmp.Or(missing, name)
+				return p
+			}
+			switch tok.Type {
+			case tokText, tokRegex, tokFile, tokContent, tokSym, tokRepo:
+				arg = regexp.QuoteMeta(arg)
+			}
+			return quoteReplacer.Replace(arg)
+		})
+		if missing != "" {
+			return "", fmt.Errorf("template %q: missing argument for parameter %q", t.Name, missing)
+		}
+		out.WriteString(prefix + value)
+	}
+	return out.String(), nil
+}
+
+// Templates is a set of query templates, eg. the templates a server offers
+// to its clients.
+type Templates struct {
+	byName map[string]*Template
+}
+
+// NewTemplates returns the set of templates ts. Their names must be unique.
+func NewTemplates(ts []Template) (*Templates, error) {
+	s := &Templates{byName: map[string]*Template{}}
+	for i := range ts {
+		t := &ts[i]
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("template %d: %w", i, err)
+		}
+		if _, ok := s.byName[t.Name]; ok {
+			return nil, fmt.Errorf("template %d: duplicate name %q", i, t.Name)
+		}
+		s.byName[t.Name] = t
+	}
+	return s, nil
+}
+
+// LoadTemplates reads templates from a YAML or JSON file of the form
+//
+//	templates:
+//	- name: deprecation_check
+//	  params: [pkg]
+//	  query: '"import ${pkg}" or "require ${pkg}"'
+func LoadTemplates(path string) (*Templates, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		Templates []Template `yaml:"templates"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s, err := NewTemplates(cfg.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Expand returns the query string of the template name with its parameters
+// set to args. Every parameter must have an argument. The arguments are
+// matched literally, eg. "a.b" does not match "axb".
+func (s *Templates) Expand(name string, args map[string]string) (string, error) {
+	var t *Template
+	if s != nil {
+		t = s.byName[name]
+	}
+	if t == nil {
+		return "", fmt.Errorf("unknown query template %q", name)
+	}
+	return t.expand(args)
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplatesExpand(t *testing.T) {
+	ts, err := NewTemplates([]Template{
+		{Name: "deprecation_check", Params: []string{"pkg"}, Query: `"import ${pkg}" or "require ${pkg}"`},
+		{Name: "in_file", Params: []string{"path", "lang"}, Query: "file:${path} lang:${lang} -${path}"},
+		{Name: "todos", Query: "TODO case:yes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args map[string]string
+		want string
+	}{
+		{"deprecation_check", map[string]string{"pkg": "log4j.core"}, `(or substr:"import log4j.core" substr:"require log4j.core")`},
+		{"deprecation_check", map[string]string{"pkg": `a"b\c`}, `(or substr:"import a\"b\\c" substr:"require a\"b\\c")`},
+		{"in_file", map[string]string{"path": "a (b).go", "lang": "C++"}, `(and file_substr:"a (b).go" lang:C++ (not substr:"a (b).go"))`},
+		{"todos", nil, `case_substr:"TODO"`},
+	} {
+		qStr, err := ts.Expand(tc.name, tc.args)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tc.name, tc.args, err)
+		}
+		q, err := Parse(qStr)
+		if err != nil {
+			t.Fatalf("%s %v: parsing %s: %v", tc.name, tc.args, qStr, err)
+		}
+		if got := q.String(); got != tc.want {
+			t.Errorf("%s %v: got %s, want %s", tc.name, tc.args, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		args map[string]string
+	}{
+		{"unknown", nil},
+		{"deprecation_check", nil},
+		{"deprecation_check", map[string]string{"pkg": "x", "other": "y"}},
+	} {
+		if _, err := ts.Expand(tc.name, tc.args); err == nil {
+			t.Errorf("%s %v: expected error", tc.name, tc.args)
+		}
+	}
+
+	// Nil templates have no templates.
+	var nilTemplates *Templates
+	if _, err := nilTemplates.Expand("todos", nil); err == nil {
+		t.Error("expected error for nil templates")
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	for _, ts := range [][]Template{
+		{{Name: "bad name", Query: "foo"}},
+		{{Name: "t", Params: []string{"a-b"}, Query: "${a-b}"}},
+		{{Name: "t", Params: []string{"p", "p"}, Query: "${p}"}},
+		{{Name: "t", Params: []string{"p"}, Query: "foo"}},
+		{{Name: "t", Query: "${undeclared}"}},
+		{{Name: "t", Params: []string{"p"}, Query: "foo${p}"}},
+		{{Name: "t", Params: []string{"p"}, Query: "(${p}"}},
+		{{Name: "t", Query: "a"}, {Name: "t", Query: "b"}},
+	} {
+		if _, err := NewTemplates(ts); err == nil {
+			t.Errorf("%+v: expected error", ts)
+		}
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"templates.yaml": "templates:\n- name: deprecation_check\n  params: [pkg]\n  query: '\"import ${pkg}\"'\n",
+		"templates.json": `{"templates": [{"name": "deprecation_check", "params": ["pkg"], "query": "\"import ${pkg}\""}]}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		ts, err := LoadTemplates(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		qStr, err := ts.Expand("deprecation_check", map[string]string{"pkg": "fmt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `"import fmt"`; qStr != want {
+			t.Errorf("%s: got %s, want %s", name, qStr, want)
+		}
+	}
+}
@@ -35,6 +35,9 @@ type LastInput struct {
 
 	// If true, the next search will run in debug mode.
 	Debug bool
+
+	// If true, the next search counts the facets of its results.
+	Facets bool
 }
 
 // Result holds the data provided to the search results template.
@@ -45,6 +48,11 @@ type ResultInput struct {
 	Stats       zoekt.Stats
 	Duration    time.Duration
 	FileMatches []*FileMatch
+
+	// Facets refine the query to files with a common property. They are
+	// counted over all matching files, not just the displayed ones, if the
+	// search was run with facets=true.
+	Facets []Facet
 }
 
 // FileMatch holds the per file data provided to search results template
@@ -17,12 +17,17 @@ package web
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"sort"
 	"strings"
@@ -34,6 +39,7 @@ import (
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/query"
+	"golang.org/x/time/rate"
 )
 
 // TODO(hanwen): cut & paste from ../ . Should create internal test
@@ -43,12 +49,12 @@ type memSeeker struct {
 }
 
 func (s *memSeeker) Close() {}
-func (s *memSeeker) Read(off, sz uint32) ([]byte, error) {
+func (s *memSeeker) Read(off, sz uint64) ([]byte, error) {
 	return s.data[off : off+sz], nil
 }
 
-func (s *memSeeker) Size() (uint32, error) {
-	return uint32(len(s.data)), nil
+func (s *memSeeker) Size() (uint64, error) {
+	return uint64(len(s.data)), nil
 }
 
 func (s *memSeeker) Name() string {
@@ -74,6 +80,16 @@ type adapter struct {
 	zoekt.Searcher
 }
 
+// Search ranks and truncates the files like the sharded searcher does.
+func (a adapter) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	sr, err := a.Searcher.Search(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	sr.Files = index.SortAndTruncateFiles(sr.Files, opts)
+	return sr, nil
+}
+
 func (a adapter) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (err error) {
 	sr, err := a.Searcher.Search(ctx, q, opts)
 	if err != nil {
@@ -568,6 +584,48 @@ func TestContextLines(t *testing.T) {
 				},
 			},
 		},
+		"/search?q=snippet%5Cnthird&format=json&ctx=1": {
+			"match spanning lines returns a single match",
+			FileMatch{
+				FileName: "f2",
+				Repo:     "name",
+				Matches: []Match{
+					{
+						FileName: "f2",
+						LineNum:  2,
+						Fragments: []Fragment{
+							{
+								Pre:   "second ",
+								Match: "snippet\nthird",
+								Post:  " thing\n",
+							},
+						},
+						Before: "one line\n",
+						After:  "fourth\n",
+					},
+				},
+			},
+		},
+		"/search?q=%28%3Fs%29block.%2Aexample&format=json&ctx=0": {
+			"regex spanning lines returns a single match",
+			FileMatch{
+				FileName: "f2",
+				Repo:     "name",
+				Matches: []Match{
+					{
+						FileName: "f2",
+						LineNum:  5,
+						Fragments: []Fragment{
+							{
+								Pre:   "fifth ",
+								Match: "block\nsixth example",
+								Post:  "\n",
+							},
+						},
+					},
+				},
+			},
+		},
 		"/search?q=pastures&format=json&ctx=1": {
 			"context returns empty end line",
 			FileMatch{
@@ -715,6 +773,12 @@ func (s *crashSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Searc
 	return &res, nil
 }
 
+func (s *crashSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	res, _ := s.Search(ctx, q, opts)
+	sender.Send(res)
+	return nil
+}
+
 func TestCrash(t *testing.T) {
 	srv := Server{
 		Searcher: &crashSearcher{},
@@ -798,6 +862,131 @@ func TestHostCustomization(t *testing.T) {
 	}
 }
 
+func TestSetSettings(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "file", Content: []byte("needle")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+		RPC:      true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path, host string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	if code, _ := get("/export?q=needle", ""); code != http.StatusNotFound {
+		t.Fatalf("export without ExportMaxResults: got status %d, want 404", code)
+	}
+
+	rewriter, err := query.NewRewriter([]query.RewriteRule{{Field: "team", Value: "pins", Query: "needle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetSettings(Settings{
+		HostCustomQueries: map[string]string{"myproject.io": "r:myproject"},
+		QueryRewriter:     rewriter,
+		ExportMaxResults:  10,
+	})
+
+	if _, body := get("/", "myproject.io"); !strings.Contains(body, "r:myproject") {
+		t.Errorf("search box: got %s, want the custom query", body)
+	}
+	if code, body := get("/export?q=team:pins", ""); code != http.StatusOK || !strings.Contains(body, "file") {
+		t.Errorf("export: got status %d and %q, want the match of the rewritten query", code, body)
+	}
+	res, err := http.Post(ts.URL+"/api/search", "application/json", strings.NewReader(`{"Q":"team:pins"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `"FileName":"file"`) {
+		t.Errorf("JSON API: got %s, want the match of the rewritten query", body)
+	}
+}
+
+func TestAutoNum(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for i := range 40 {
+		if err := b.Add(index.Document{
+			Name:    fmt.Sprintf("file%02d", i),
+			Content: []byte("before\nneedle\nafter\n"),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	search := func(params string) *ResultInput {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/search?q=needle&format=json&" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r ApiSearchResult
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Result
+	}
+
+	var want zoekt.SearchOptions
+	want.ApplyDisplayHint(zoekt.DisplayHint{Lines: 100})
+	r := search("num=auto&lines=100")
+	if len(r.FileMatches) != want.MaxDocDisplayCount || r.Last.Num != want.MaxDocDisplayCount || r.Last.Ctx != want.NumContextLines {
+		t.Errorf("got %d files, num %d, ctx %d, want %d files with %d context lines",
+			len(r.FileMatches), r.Last.Num, r.Last.Ctx, want.MaxDocDisplayCount, want.NumContextLines)
+	}
+
+	// An explicit ctx wins over the hint.
+	if r := search("num=auto&lines=100&ctx=0"); r.Last.Ctx != 0 || len(r.FileMatches[0].Matches[0].Before) != 0 {
+		t.Errorf("got ctx %d, want 0", r.Last.Ctx)
+	}
+}
+
 func TestDupResult(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name: "name",
@@ -963,6 +1152,52 @@ func TestHealthz(t *testing.T) {
 	}
 }
 
+func TestSetTemplates(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "f2", Content: []byte("to carry water in the no later bla")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	custom := func(results string) *template.Template {
+		top, err := NewTop()
+		if err != nil {
+			t.Fatalf("NewTop: %v", err)
+		}
+		if _, err := top.New("results").Parse(results); err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		return top
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      custom(`custom {{.QueryStr}}`),
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=water", []string{"custom water"})
+
+	if err := srv.SetTemplates(custom(`reloaded {{.QueryStr}}`)); err != nil {
+		t.Fatalf("SetTemplates: %v", err)
+	}
+	checkNeedles(t, ts, "/search?q=water", []string{"reloaded water"})
+	checkNeedles(t, ts, "/", []string{"Search examples"})
+
+	if err := srv.SetTemplates(template.New("top")); err == nil {
+		t.Fatal("SetTemplates accepted templates without results template")
+	}
+	checkNeedles(t, ts, "/search?q=water", []string{"reloaded water"})
+}
+
 func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
 	t.Helper()
 
@@ -980,3 +1215,415 @@ func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
 		t.Fatalf("unexpected results (-want, +got):\n%s", d)
 	}
 }
+
+func TestFacets(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for _, doc := range []index.Document{
+		{Name: "cmd/a/main.go", Language: "Go"},
+		{Name: "cmd/b/main.go", Language: "Go"},
+		{Name: "cmd/b/util.go", Language: "Go"},
+		{Name: "docs/README.md", Language: "Markdown"},
+		{Name: "0123abcd", Language: index.CommitLanguage},
+	} {
+		doc.Content = []byte("needle in a haystack")
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	search := func(q string) *ResultInput {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/search?format=json&num=1&facets=true&q=" + url.QueryEscape(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var result ApiSearchResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Result
+	}
+
+	// The facets count all matching files, not only the displayed one. The
+	// repository facet is left out because all files are in one repository.
+	res := search("needle")
+	if len(res.FileMatches) != 1 {
+		t.Fatalf("got %d file matches, want 1", len(res.FileMatches))
+	}
+	want := []Facet{{
+		Name: "Language",
+		Values: []FacetValue{
+			{Value: "Go", Count: 3, Query: `needle lang:"Go"`},
+			{Value: "Markdown", Count: 1, Query: `needle lang:"Markdown"`},
+		},
+	}, {
+		Name: "Directory",
+		Values: []FacetValue{
+			{Value: "cmd", Count: 3, Query: "needle file:^cmd/"},
+			{Value: "docs", Count: 1, Query: "needle file:^docs/"},
+		},
+	}, {
+		Name: "Extension",
+		Values: []FacetValue{
+			{Value: ".go", Count: 3, Query: `needle file:\.go$`},
+			{Value: ".md", Count: 1, Query: `needle file:\.md$`},
+		},
+	}}
+	if d := cmp.Diff(want, res.Facets); d != "" {
+		t.Errorf("unexpected facets (-want, +got):\n%s", d)
+	}
+
+	// Commit messages are not a language.
+	commits := search("needle or (type:commit needle)")
+	if len(commits.Facets) == 0 || commits.Facets[0].Name != "Language" {
+		t.Fatalf("got facets %v, want the languages first", commits.Facets)
+	}
+	for _, v := range commits.Facets[0].Values {
+		if v.Value == index.CommitLanguage {
+			t.Errorf("got language facet %v for commit messages", v)
+		}
+	}
+
+	// Refining by a directory shows its subdirectories.
+	res = search(res.Facets[1].Values[0].Query)
+	want = []Facet{{
+		Name: "Directory",
+		Values: []FacetValue{
+			{Value: "cmd/b", Count: 2, Query: "needle file:^cmd/ file:^cmd/b/"},
+			{Value: "cmd/a", Count: 1, Query: "needle file:^cmd/ file:^cmd/a/"},
+		},
+	}}
+	if d := cmp.Diff(want, res.Facets); d != "" {
+		t.Errorf("unexpected facets (-want, +got):\n%s", d)
+	}
+
+	// The HTML page links to the refined queries.
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	body := get("/search?num=1&facets=true&q=needle")
+	if want := `<a href="search?q=needle%20file%3a%5ecmd%2f&num=1&facets=true" title="needle file:^cmd/">cmd</a>`; !strings.Contains(body, want) {
+		t.Errorf("result did not have %q: %s", want, body)
+	}
+
+	// Without facets=true, the search is not streamed and the page links to
+	// the facets instead.
+	body = get("/search?num=1&q=needle")
+	if strings.Contains(body, `class="col-md-2 facets"`) {
+		t.Errorf("result has facets: %s", body)
+	}
+	if want := `<a rel="nofollow" href="search?q=needle&num=1&facets=true">Show facets</a>`; !strings.Contains(body, want) {
+		t.Errorf("result did not have %q: %s", want, body)
+	}
+
+	// A disjunction is grouped before refining it.
+	res = search("needle or haystack")
+	if got, want := res.Facets[0].Values[0].Query, `(needle or haystack) lang:"Go"`; got != want {
+		t.Errorf("got query %s, want %s", got, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for _, doc := range []index.Document{
+		{Name: "a.go", Content: []byte("first needle\r\nno match\nsecond needle, \"quoted\"\n")},
+		{Name: "needle.txt", Content: []byte("hay")},
+		{Name: "formula.csv", Content: []byte("=needle()\n")},
+	} {
+		doc.Branches = []string{"main"}
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	srv := Server{
+		Searcher:         searcherForTest(t, b),
+		Top:              Top,
+		ExportMaxResults: 2,
+		ExportLimiter:    rate.NewLimiter(rate.Every(time.Hour), 6),
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	export := func(params string) (*http.Response, string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/export?" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	res, body := export("q=needle+f:a.go")
+	if got := res.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if got := res.Header.Get("Content-Disposition"); got != `attachment; filename="zoekt-export.csv"` {
+		t.Errorf("got Content-Disposition %q", got)
+	}
+	wantCSV := "repository,branches,file_name,line_number,line\n" +
+		"name,main,a.go,1,first needle\n" +
+		"name,main,a.go,3,\"second needle, \"\"quoted\"\"\"\n"
+	if diff := cmp.Diff(wantCSV, body); diff != "" {
+		t.Errorf("csv (-want +got):\n%s", diff)
+	}
+
+	// The export is capped at ExportMaxResults, and num lowers the cap.
+	_, body = export("format=jsonl&num=1&q=needle+f:a.go")
+	wantJSONL := `{"repository":"name","branches":["main"],"file_name":"a.go","line_number":1,"line":"first needle"}` + "\n"
+	if diff := cmp.Diff(wantJSONL, body); diff != "" {
+		t.Errorf("jsonl (-want +got):\n%s", diff)
+	}
+
+	if res, _ := export("format=xml&q=needle"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: got status %d, want %d", res.StatusCode, http.StatusBadRequest)
+	} else if got := res.Header.Get("Content-Disposition"); got != "" {
+		t.Errorf("unknown format: got Content-Disposition %q", got)
+	}
+
+	// Cells which spreadsheets would evaluate as formulas are quoted.
+	_, body = export("q=needle+f:formula")
+	if want := "repository,branches,file_name,line_number,line\nname,main,formula.csv,1,'=needle()\n"; body != want {
+		t.Errorf("formula: got %q, want %q", body, want)
+	}
+
+	// With provenance, each match has the digest of the content it was found
+	// in.
+	_, body = export("format=jsonl&num=1&provenance=true&q=needle+f:a.go")
+	var row exportRow
+	if err := json.Unmarshal([]byte(body), &row); err != nil {
+		t.Fatalf("provenance: %v in %q", err, body)
+	}
+	sum := sha256.Sum256([]byte("first needle\r\nno match\nsecond needle, \"quoted\"\n"))
+	if p := row.Provenance; p == nil || p.ContentSHA256 != hex.EncodeToString(sum[:]) || p.Checksum == "" || p.ShardFile == "" {
+		t.Errorf("provenance: got %+v", p)
+	}
+	_, body = export("provenance=1&q=needle+f:a.go")
+	if header, _, _ := strings.Cut(body, "\n"); header != "repository,branches,file_name,line_number,line,"+strings.Join(exportProvenanceColumns, ",") {
+		t.Errorf("provenance: got csv header %q", header)
+	}
+
+	// The limiter allowed six exports.
+	res, _ = export("q=needle")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("missing Retry-After")
+	}
+}
+
+type errSearcher struct {
+	zoekt.Streamer
+}
+
+func (s *errSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return errors.New("search failed")
+}
+
+func TestExportError(t *testing.T) {
+	srv := Server{
+		Searcher:         &errSearcher{},
+		Top:              Top,
+		ExportMaxResults: 10,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/export?q=needle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusInternalServerError)
+	}
+	if got := res.Header.Get("Content-Disposition"); got != "" {
+		t.Errorf("got Content-Disposition %q on an error", got)
+	}
+}
+
+func TestResultsAndPreview(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	lines[14] = "line 15 needle"
+	for _, doc := range []index.Document{
+		{Name: "a.txt", Content: []byte(strings.Join(lines, "\n") + "\n")},
+		{Name: "b.txt", Content: []byte("needle")},
+		{Name: "c.txt", Content: []byte("needle needle")},
+	} {
+		doc.Branches = []string{"main"}
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(req string, v any) int {
+		t.Helper()
+		res, err := http.Get(ts.URL + req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	// The results page carries what the keyboard navigation needs.
+	checkNeedles(t, ts, "/search?q=needle&num=2", []string{
+		`class="table table-hover table-condensed file-result" data-repo="name" data-file=`,
+		`data-branch="main" data-line="1"`,
+		`id="preview"`,
+		`data-num="2" data-ctx="0"`,
+		`data-more="true"`,
+		`<script src="results.js">`,
+	})
+	checkNeedles(t, ts, "/results.js", []string{
+		`document.addEventListener("keydown"`,
+		`if (!r || !safeURL(r.dataset.url)) { return; }`,
+	})
+
+	// All pages together have the files of a single search, in order.
+	var all ResultsPage
+	if status := get("/results?q=needle&num=10", &all); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if len(all.FileMatches) != 3 || all.More {
+		t.Fatalf("got %d files, more %v, want 3 files", len(all.FileMatches), all.More)
+	}
+	var huge ResultsPage
+	if status := get(fmt.Sprintf("/results?q=needle&num=%d", 1<<40), &huge); status != http.StatusOK || len(huge.FileMatches) != 3 {
+		t.Fatalf("huge page: got status %d with %d files, want 3 files", status, len(huge.FileMatches))
+	}
+	var paged []string
+	for offset := 0; ; offset += 2 {
+		var page ResultsPage
+		if status := get(fmt.Sprintf("/results?q=needle&num=2&offset=%d", offset), &page); status != http.StatusOK {
+			t.Fatalf("offset %d: got status %d", offset, status)
+		}
+		if page.Offset != offset {
+			t.Fatalf("got offset %d, want %d", page.Offset, offset)
+		}
+		for _, fm := range page.FileMatches {
+			paged = append(paged, fm.FileName)
+		}
+		if !page.More {
+			break
+		}
+	}
+	var want []string
+	for _, fm := range all.FileMatches {
+		want = append(want, fm.FileName)
+	}
+	if diff := cmp.Diff(want, paged); diff != "" {
+		t.Errorf("paged results (-want +got):\n%s", diff)
+	}
+
+	var p Preview
+	if status := get("/preview?r=name&f=a.txt&b=main&l=15&ctx=2", &p); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	wantPreview := Preview{
+		Repository: "name",
+		FileName:   "a.txt",
+		Line:       15,
+		Start:      13,
+		Lines:      []string{"line 13", "line 14", "line 15 needle", "line 16", "line 17"},
+		LineCount:  30,
+	}
+	if diff := cmp.Diff(wantPreview, p); diff != "" {
+		t.Errorf("preview (-want +got):\n%s", diff)
+	}
+
+	// The preview is cut off at the start and the end of the file.
+	if get("/preview?r=name&f=a.txt&l=29&ctx=5", &p); p.Start != 24 || len(p.Lines) != 7 {
+		t.Errorf("got preview from line %d with %d lines, want 24 and 7", p.Start, len(p.Lines))
+	}
+	if get("/preview?r=name&f=a.txt&l=2", &p); p.Start != 1 || len(p.Lines) != 12 {
+		t.Errorf("got preview from line %d with %d lines, want 1 and 12", p.Start, len(p.Lines))
+	}
+
+	for req, want := range map[string]int{
+		"/preview?r=name&f=missing.txt": http.StatusNotFound,
+		"/preview?r=name":               http.StatusBadRequest,
+		"/preview?r=name&f=a.txt&ctx=a": http.StatusBadRequest,
+		"/results?q=needle&offset=-1":   http.StatusBadRequest,
+		"/results?num=2":                http.StatusBadRequest,
+	} {
+		if got := getHttpStatusCode(t, ts, req); got != want {
+			t.Errorf("%s: got status %d, want %d", req, got, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// exportRow is a line match of an exported search.
+type exportRow struct {
+	Repository string   `json:"repository"`
+	Branches   []string `json:"branches"`
+	FileName   string   `json:"file_name"`
+	// LineNumber is 1-based. It is 0 for matches on the file name.
+	LineNumber int    `json:"line_number"`
+	Line       string `json:"line"`
+
+	// Provenance is only set if the export was requested with provenance.
+	Provenance *exportProvenance `json:"provenance,omitempty"`
+}
+
+// exportProvenance is the zoekt.Provenance of the file of an exported match.
+type exportProvenance struct {
+	ShardFile     string    `json:"shard_file"`
+	ShardID       string    `json:"shard_id"`
+	IndexTime     time.Time `json:"index_time"`
+	ZoektVersion  string    `json:"zoekt_version"`
+	Checksum      string    `json:"checksum"`
+	ContentSHA256 string    `json:"content_sha256"`
+}
+
+func newExportProvenance(p *zoekt.Provenance) *exportProvenance {
+	if p == nil {
+		return nil
+	}
+	return &exportProvenance{
+		ShardFile:     p.ShardFile,
+		ShardID:       p.ShardID,
+		IndexTime:     p.IndexTime.UTC(),
+		ZoektVersion:  p.ZoektVersion,
+		Checksum:      p.Checksum,
+		ContentSHA256: p.ContentSHA256,
+	}
+}
+
+// exportProvenanceColumns are the CSV columns of exportProvenance.
+var exportProvenanceColumns = []string{"shard_file", "shard_id", "index_time", "zoekt_version", "checksum", "content_sha256"}
+
+// exportWriter writes the rows of an export in one of the export formats.
+type exportWriter interface {
+	write(exportRow) error
+	flush() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+
+	// provenance adds the exportProvenanceColumns.
+	provenance  bool
+	wroteHeader bool
+}
+
+// writeHeader writes the header row, unless it was written before.
+func (e *csvExportWriter) writeHeader() error {
+	if e.wroteHeader {
+		return nil
+	}
+	e.wroteHeader = true
+	header := []string{"repository", "branches", "file_name", "line_number", "line"}
+	if e.provenance {
+		header = append(header, exportProvenanceColumns...)
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvExportWriter) write(row exportRow) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	record := []string{
+		csvCell(row.Repository),
+		csvCell(strings.Join(row.Branches, ",")),
+		csvCell(row.FileName),
+		strconv.Itoa(row.LineNumber),
+		csvCell(row.Line),
+	}
+	if e.provenance {
+		p := row.Provenance
+		if p == nil {
+			p = &exportProvenance{}
+		}
+		var indexTime string
+		if !p.IndexTime.IsZero() {
+			indexTime = p.IndexTime.Format(time.RFC3339)
+		}
+		record = append(record, csvCell(p.ShardFile), p.ShardID, indexTime, csvCell(p.ZoektVersion), p.Checksum, p.ContentSHA256)
+	}
+	return e.w.Write(record)
+}
+
+// csvCell neutralizes a cell which spreadsheets would evaluate as a formula,
+// by prefixing it with a single quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (e *csvExportWriter) flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlExportWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonlExportWriter) write(row exportRow) error {
+	return e.enc.Encode(row)
+}
+
+func (e *jsonlExportWriter) flush() error {
+	return e.buf.Flush()
+}
+
+// serveExport streams the line matches of the query q as a download, in the
+// format given by the format parameter: "csv" (the default) or "jsonl". It
+// exports at most ExportMaxResults matches, or num if that is smaller. The
+// matches are in the order the shards find them, not ranked. With the
+// provenance parameter set to a true value, each match also has the
+// zoekt.Provenance of its file, for audits which must show which index
+// produced a match. CSV cells starting with one of "=+-@" are prefixed with a
+// single quote, so spreadsheets don't evaluate them as formulas.
+//
+// The download headers are only set once the export starts, so an error
+// before the first row is a plain error response.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	st := s.settings.Load()
+	if st.ExportMaxResults <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if st.ExportLimiter != nil && !st.ExportLimiter.Allow() {
+		retry := 1.0
+		if l := float64(st.ExportLimiter.Limit()); l > 0 {
+			retry = math.Ceil(1 / l)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+		http.Error(w, "too many exports, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	qvals := r.URL.Query()
+	queryStr := qvals.Get("q")
+	if queryStr == "" {
+		http.Error(w, "no query found", http.StatusBadRequest)
+		return
+	}
+	q, err := st.QueryRewriter.Parse(queryStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	num := st.ExportMaxResults
+	if n, err := strconv.Atoi(qvals.Get("num")); err == nil && n > 0 && n < num {
+		num = n
+	}
+
+	provenance := false
+	if v := qvals.Get("provenance"); v != "" {
+		if provenance, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid provenance parameter "+strconv.Quote(v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := qvals.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var (
+		ew          exportWriter
+		contentType string
+	)
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		ew = &csvExportWriter{w: csv.NewWriter(w), provenance: provenance}
+	case "jsonl":
+		contentType = "application/jsonl; charset=utf-8"
+		buf := bufio.NewWriter(w)
+		ew = &jsonlExportWriter{buf: buf, enc: json.NewEncoder(buf)}
+	default:
+		http.Error(w, "unknown export format "+strconv.Quote(format), http.StatusBadRequest)
+		return
+	}
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="zoekt-export.`+format+`"`)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	opts := zoekt.SearchOptions{
+		ShardMaxMatchCount: num,
+		TotalMaxMatchCount: num,
+		MaxWallTime:        time.Minute,
+		Provenance:         provenance,
+	}
+
+	// Senders are called serially, so n and writeErr need no locking.
+	var (
+		n        int
+		writeErr error
+	)
+	sender := zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		for _, f := range sr.Files {
+			for _, lm := range f.LineMatches {
+				if n >= num || writeErr != nil {
+					cancel()
+					return
+				}
+				row := exportRow{
+					Repository: f.Repository,
+					Branches:   f.Branches,
+					FileName:   f.FileName,
+					Provenance: newExportProvenance(f.Provenance),
+				}
+				if !lm.FileName {
+					row.LineNumber = lm.LineNumber
+					row.Line = strings.TrimRight(string(lm.Line), "\r\n")
+				}
+				start()
+				writeErr = ew.write(row)
+				n++
+			}
+		}
+	})
+
+	err = traceAwareSearcher{s.Searcher}.StreamSearch(ctx, q, &opts, sender)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		if n == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The response has started, so all we can do is end it early.
+		log.Printf("export of %q: %v", queryStr, err)
+	}
+	if writeErr == nil {
+		start()
+		writeErr = ew.flush()
+	}
+	// Writes fail once the client went away, which is not worth a log line.
+	if writeErr != nil && r.Context().Err() == nil {
+		log.Printf("export of %q: %v", queryStr, writeErr)
+	}
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// maxFacetValues is the number of values we show per facet.
+const maxFacetValues = 10
+
+// Facet is a property of the matching files, eg. their language, with its
+// most common values.
+type Facet struct {
+	Name   string
+	Values []FacetValue
+}
+
+// FacetValue is a value of a facet and the number of matching files which
+// have it.
+type FacetValue struct {
+	Value string
+	Count int
+
+	// Query is the query string refined to the files with this value.
+	Query string
+}
+
+// facetCounter counts the values of the facets over the files of a search as
+// they are streamed.
+type facetCounter struct {
+	files      int
+	languages  map[string]int
+	repos      map[string]int
+	dirs       map[string]int
+	extensions map[string]int
+}
+
+func newFacetCounter() *facetCounter {
+	return &facetCounter{
+		languages:  map[string]int{},
+		repos:      map[string]int{},
+		dirs:       map[string]int{},
+		extensions: map[string]int{},
+	}
+}
+
+func (c *facetCounter) add(files []zoekt.FileMatch) {
+	for i := range files {
+		f := &files[i]
+		c.files++
+		// Commit messages aren't in a language, and lang: doesn't narrow
+		// a type:commit search down any further.
+		if f.Language != "" && f.Language != index.CommitLanguage {
+			c.languages[f.Language]++
+		}
+		c.repos[f.Repository]++
+		c.dirs[path.Dir(f.FileName)]++
+		if ext := path.Ext(f.FileName); ext != "" {
+			c.extensions[ext]++
+		}
+	}
+}
+
+// facets returns the facets of the files counted so far. The queries of the
+// values add a filter to queryStr. If group is set, queryStr is put in
+// parentheses first, eg. because it is a disjunction.
+func (c *facetCounter) facets(queryStr string, group bool) []Facet {
+	if group {
+		queryStr = "(" + queryStr + ")"
+	}
+
+	var facets []Facet
+	add := func(name string, counts map[string]int, atom func(string) string) {
+		values := topFacetValues(counts)
+		// A single value which all files have doesn't refine the results.
+		if len(values) == 0 || len(values) == 1 && values[0].Count == c.files {
+			return
+		}
+		for i := range values {
+			values[i].Query = queryStr + " " + atom(values[i].Value)
+		}
+		facets = append(facets, Facet{Name: name, Values: values})
+	}
+
+	add("Language", c.languages, func(v string) string {
+		return fmt.Sprintf("lang:%q", v)
+	})
+	add("Repository", c.repos, func(v string) string {
+		return "repo:^" + regexp.QuoteMeta(v) + "$"
+	})
+	add("Directory", subdirCounts(c.dirs), func(v string) string {
+		return "file:^" + regexp.QuoteMeta(v+"/")
+	})
+	add("Extension", c.extensions, func(v string) string {
+		return "file:" + regexp.QuoteMeta(v) + "$"
+	})
+	return facets
+}
+
+// topFacetValues returns the most common values of counts.
+func topFacetValues(counts map[string]int) []FacetValue {
+	values := make([]FacetValue, 0, len(counts))
+	for v, n := range counts {
+		values = append(values, FacetValue{Value: v, Count: n})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	return values[:min(len(values), maxFacetValues)]
+}
+
+// subdirCounts groups the file counts of dirs by the subdirectories of the
+// directory all of them have in common. Refining the query to one of them
+// then shows the subdirectories one level further down. Files directly in the
+// common directory are not counted.
+func subdirCounts(dirs map[string]int) map[string]int {
+	var common []string
+	first := true
+	for d := range dirs {
+		parts := splitDir(d)
+		if first {
+			common, first = parts, false
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+
+	counts := map[string]int{}
+	for d, n := range dirs {
+		parts := splitDir(d)
+		if len(parts) > len(common) {
+			counts[strings.Join(parts[:len(common)+1], "/")] += n
+		}
+	}
+	return counts
+}
+
+func splitDir(d string) []string {
+	if d == "." || d == "/" {
+		return nil
+	}
+	return strings.Split(d, "/")
+}
+
+// streamCollector collects the results of a streamed search and counts the
+// facets of all files it receives.
+type streamCollector struct {
+	result zoekt.SearchResult
+	facets *facetCounter
+}
+
+func newStreamCollector() *streamCollector {
+	return &streamCollector{
+		result: zoekt.SearchResult{
+			RepoURLs:      map[string]string{},
+			LineFragments: map[string]string{},
+		},
+		facets: newFacetCounter(),
+	}
+}
+
+func (c *streamCollector) Send(r *zoekt.SearchResult) {
+	c.result.Stats.Add(r.Stats)
+	if len(r.Files) == 0 {
+		return
+	}
+
+	c.facets.add(r.Files)
+	c.result.Files = append(c.result.Files, r.Files...)
+	maps.Copy(c.result.RepoURLs, r.RepoURLs)
+	maps.Copy(c.result.LineFragments, r.LineFragments)
+}
+
+// searchWithFacets runs the search and returns its result and the facets of
+// all matching files. The shards stream their files without display limits,
+// so the facets cover all of them, and the files are ranked and truncated to
+// the display limits of opts once the search is done.
+func (s *Server) searchWithFacets(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, *facetCounter, error) {
+	streamOpts := *opts
+	streamOpts.MaxDocDisplayCount = 0
+	streamOpts.MaxMatchDisplayCount = 0
+	streamOpts.MaxDisplayBytes = 0
+
+	start := time.Now()
+	c := newStreamCollector()
+	if err := s.Searcher.StreamSearch(ctx, q, &streamOpts, c); err != nil {
+		return nil, nil, err
+	}
+	c.result.Files = index.SortAndTruncateFiles(c.result.Files, opts)
+	c.result.Stats.Duration = time.Since(start) - c.result.Stats.Wait
+	return &c.result, c.facets, nil
+}
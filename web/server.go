@@ -23,11 +23,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"regexp/syntax"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	texttemplate "text/template"
 	"time"
 
@@ -38,6 +41,7 @@ import (
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
 	"github.com/sourcegraph/zoekt/query"
+	"golang.org/x/time/rate"
 )
 
 var Funcmap = template.FuncMap{
@@ -82,6 +86,12 @@ var Funcmap = template.FuncMap{
 
 const defaultNumResults = 50
 
+// Server serves the web interface and the JSON API.
+//
+// NewMux reads the fields once. Changing Print, HostCustomQueries,
+// QueryRewriter, QueryTemplates, ExportMaxResults, ExportLimiter or Top
+// afterwards has no effect on the running server; use SetSettings and
+// SetTemplates instead.
 type Server struct {
 	Searcher zoekt.Streamer
 
@@ -104,6 +114,22 @@ type Server struct {
 	// domains.
 	HostCustomQueries map[string]string
 
+	// QueryRewriter, if set, rewrites queries of the search page and the
+	// JSON API, eg. to expand aliases for groups of repositories.
+	QueryRewriter *query.Rewriter
+
+	// QueryTemplates are the query templates which clients of the JSON API
+	// can search for by name.
+	QueryTemplates *query.Templates
+
+	// ExportMaxResults, if positive, serves /export, which downloads up to
+	// this many line matches of a query as CSV or JSON lines.
+	ExportMaxResults int
+
+	// ExportLimiter, if set, limits the rate of exports. Exports beyond it
+	// are rejected with 429 Too Many Requests.
+	ExportLimiter *rate.Limiter
+
 	// This should contain the following templates: "repolist"
 	// (for the repo search result page), "result" for
 	// the search results, "search" (for the opening page),
@@ -111,12 +137,9 @@ type Server struct {
 	// "print" for the show file functionality.
 	Top *template.Template
 
-	repolist *template.Template
-	search   *template.Template
-	result   *template.Template
-	print    *template.Template
-	about    *template.Template
-	robots   *template.Template
+	pages atomic.Pointer[pages]
+
+	settings atomic.Pointer[settings]
 
 	startTime time.Time
 
@@ -129,6 +152,69 @@ type Server struct {
 	lastStatsTS time.Time
 }
 
+// pages holds the templates of the pages of a Server.
+type pages struct {
+	repolist *template.Template
+	search   *template.Template
+	result   *template.Template
+	print    *template.Template
+	about    *template.Template
+	robots   *template.Template
+}
+
+// SetTemplates replaces the templates of the pages with those of top, which
+// must contain the same templates as Top. Requests which are being served
+// finish with the previous templates. It is safe to call while the server is
+// running, eg. to reload customized templates.
+func (s *Server) SetTemplates(top *template.Template) error {
+	p := &pages{}
+	for k, v := range map[string]**template.Template{
+		"results":  &p.result,
+		"print":    &p.print,
+		"search":   &p.search,
+		"repolist": &p.repolist,
+		"about":    &p.about,
+		"robots":   &p.robots,
+	} {
+		*v = top.Lookup(k)
+		if *v == nil {
+			return fmt.Errorf("missing template %q", k)
+		}
+	}
+	s.pages.Store(p)
+	return nil
+}
+
+// Settings are the settings of a Server which can change while it is running,
+// see SetSettings. The fields have the meaning of the Server fields of the
+// same names, which NewMux takes them from.
+type Settings struct {
+	Print             bool
+	HostCustomQueries map[string]string
+	QueryRewriter     *query.Rewriter
+	QueryTemplates    *query.Templates
+	ExportMaxResults  int
+	ExportLimiter     *rate.Limiter
+}
+
+// settings are the Settings of a Server with the JSON API using them.
+type settings struct {
+	Settings
+	api http.Handler
+}
+
+// SetSettings replaces the settings of the server, which NewMux initialized
+// from its fields. Requests which are being served finish with the previous
+// settings. It is safe to call while the server is running, eg. to apply a
+// changed configuration without a restart.
+func (s *Server) SetSettings(st Settings) {
+	next := &settings{Settings: st}
+	if s.RPC {
+		next.api = http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher}, st.QueryRewriter, st.QueryTemplates))
+	}
+	s.settings.Store(next)
+}
+
 func (s *Server) getTemplate(str string) *template.Template {
 	s.templateMu.Lock()
 	defer s.templateMu.Unlock()
@@ -164,24 +250,17 @@ func (s *Server) getTextTemplate(str string) *texttemplate.Template {
 }
 
 func NewMux(s *Server) (*http.ServeMux, error) {
-	s.print = s.Top.Lookup("print")
-	if s.print == nil {
-		return nil, fmt.Errorf("missing template 'print'")
-	}
-
-	for k, v := range map[string]**template.Template{
-		"results":  &s.result,
-		"print":    &s.print,
-		"search":   &s.search,
-		"repolist": &s.repolist,
-		"about":    &s.about,
-		"robots":   &s.robots,
-	} {
-		*v = s.Top.Lookup(k)
-		if *v == nil {
-			return nil, fmt.Errorf("missing template %q", k)
-		}
+	if err := s.SetTemplates(s.Top); err != nil {
+		return nil, err
 	}
+	s.SetSettings(Settings{
+		Print:             s.Print,
+		HostCustomQueries: s.HostCustomQueries,
+		QueryRewriter:     s.QueryRewriter,
+		QueryTemplates:    s.QueryTemplates,
+		ExportMaxResults:  s.ExportMaxResults,
+		ExportLimiter:     s.ExportLimiter,
+	})
 
 	s.templateCache = map[string]*template.Template{}
 	s.textTemplateCache = map[string]*texttemplate.Template{}
@@ -195,9 +274,17 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 		mux.HandleFunc("/", s.serveSearchBox)
 		mux.HandleFunc("/about", s.serveAbout)
 		mux.HandleFunc("/print", s.servePrint)
+		mux.HandleFunc("/results", s.serveResults)
+		mux.HandleFunc("/results.js", s.serveResultsJS)
+		mux.HandleFunc("/preview", s.servePreview)
 	}
+	// SetSettings may enable exports later, so serveExport checks whether
+	// they are enabled.
+	mux.HandleFunc("/export", s.serveExport)
 	if s.RPC {
-		mux.Handle("/api/", http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher})))
+		mux.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.settings.Load().api.ServeHTTP(w, r)
+		}))
 	}
 
 	mux.HandleFunc("/healthz", s.serveHealthz)
@@ -240,9 +327,9 @@ func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
 
 	var buf bytes.Buffer
 	if result.Repos != nil {
-		err = s.repolist.Execute(&buf, &result.Repos)
+		err = s.pages.Load().repolist.Execute(&buf, &result.Repos)
 	} else if result.Result != nil {
-		err = s.result.Execute(&buf, &result.Result)
+		err = s.pages.Load().result.Execute(&buf, &result.Result)
 	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusTeapot)
@@ -255,13 +342,14 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 	qvals := r.URL.Query()
 
 	debugScore, _ := strconv.ParseBool(qvals.Get("debug"))
+	withFacets, _ := strconv.ParseBool(qvals.Get("facets"))
 
 	queryStr := qvals.Get("q")
 	if queryStr == "" {
 		return nil, fmt.Errorf("no query found")
 	}
 
-	q, err := query.Parse(queryStr)
+	q, err := s.settings.Load().QueryRewriter.Parse(queryStr)
 	if err != nil {
 		return nil, err
 	}
@@ -294,34 +382,84 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 		num = defaultNumResults
 	}
 
-	sOpts := zoekt.SearchOptions{
-		MaxWallTime: 10 * time.Second,
+	numCtxLines, err := parseCtxLines(qvals)
+	if err != nil {
+		return nil, err
 	}
 
-	numCtxLines := 0
-	if ctxLinesStr := qvals.Get("ctx"); ctxLinesStr != "" {
-		numCtxLines, err = strconv.Atoi(ctxLinesStr)
-		if err != nil || numCtxLines < 0 || numCtxLines > 10 {
-			return nil, fmt.Errorf("Number of context lines must be between 0 and 10")
+	// With num=auto, the limits are derived from the number of lines the
+	// client shows, and ctx is optional.
+	var hint *zoekt.DisplayHint
+	if numStr == "auto" {
+		lines, _ := strconv.Atoi(qvals.Get("lines"))
+		hint = &zoekt.DisplayHint{Lines: max(lines, 0)}
+		var auto zoekt.SearchOptions
+		auto.ApplyDisplayHint(*hint)
+		num = auto.MaxDocDisplayCount
+		if qvals.Get("ctx") == "" {
+			numCtxLines = auto.NumContextLines
 		}
 	}
+
+	res, err := s.searchFiles(r.Context(), q, queryStr, num, numCtxLines, hint, debugScore, withFacets)
+	if err != nil {
+		return nil, err
+	}
+	return &ApiSearchResult{Result: res}, nil
+}
+
+// parseCtxLines returns the number of context lines of the ctx parameter.
+func parseCtxLines(qvals url.Values) (int, error) {
+	ctxLinesStr := qvals.Get("ctx")
+	if ctxLinesStr == "" {
+		return 0, nil
+	}
+	numCtxLines, err := strconv.Atoi(ctxLinesStr)
+	if err != nil || numCtxLines < 0 || numCtxLines > 10 {
+		return 0, fmt.Errorf("Number of context lines must be between 0 and 10")
+	}
+	return numCtxLines, nil
+}
+
+// searchFiles returns the top num files matching q, for the results page. If
+// hint is set, it limits the matches and their size too. If withFacets is set,
+// it counts the facets of all matching files, which costs a streamed search
+// without display limits.
+func (s *Server) searchFiles(ctx context.Context, q query.Q, queryStr string, num, numCtxLines int, hint *zoekt.DisplayHint, debugScore, withFacets bool) (*ResultInput, error) {
+	sOpts := zoekt.SearchOptions{
+		MaxWallTime: 10 * time.Second,
+	}
 	sOpts.NumContextLines = numCtxLines
 
+	// Line matches split a match spanning several lines into one match per
+	// line, so we ask for chunks if the query can match a newline.
+	sOpts.ChunkMatches = matchesAcrossLines(q)
+
 	sOpts.SetDefaults()
+	if hint != nil {
+		sOpts.ApplyDisplayHint(*hint)
+		sOpts.NumContextLines = numCtxLines
+	}
 	sOpts.MaxDocDisplayCount = num
 	sOpts.DebugScore = debugScore
 
-	ctx := r.Context()
 	if err := zjson.CalculateDefaultSearchLimits(ctx, q, s.Searcher, &sOpts); err != nil {
 		return nil, err
 	}
 
-	result, err := s.Searcher.Search(ctx, q, &sOpts)
+	var result *zoekt.SearchResult
+	var facets *facetCounter
+	var err error
+	if withFacets {
+		result, facets, err = s.searchWithFacets(ctx, q, &sOpts)
+	} else {
+		result, err = s.Searcher.Search(ctx, q, &sOpts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	fileMatches, err := s.formatResults(result, queryStr, s.Print)
+	fileMatches, err := s.formatResults(result, queryStr, s.settings.Load().Print)
 	if err != nil {
 		return nil, err
 	}
@@ -338,13 +476,55 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 		QueryStr:    queryStr,
 		FileMatches: fileMatches,
 	}
+	if facets != nil {
+		_, isOr := q.(*query.Or)
+		res.Facets = facets.facets(queryStr, isOr)
+	}
 	if res.Stats.Wait < res.Stats.Duration/10 {
 		// Suppress queueing stats if they are neglible.
 		res.Stats.Wait = 0
 	}
 
 	res.Last.Debug = debugScore
-	return &ApiSearchResult{Result: &res}, nil
+	res.Last.Facets = withFacets
+	return &res, nil
+}
+
+// matchesAcrossLines returns true if a content atom of q can match a
+// newline.
+func matchesAcrossLines(q query.Q) bool {
+	found := false
+	query.VisitAtoms(q, func(q query.Q) {
+		switch s := q.(type) {
+		case *query.Substring:
+			found = found || (!s.FileName && strings.Contains(s.Pattern, "\n"))
+		case *query.Regexp:
+			found = found || (!s.FileName && regexpMatchesNewline(s.Regexp))
+		}
+	})
+	return found
+}
+
+func regexpMatchesNewline(r *syntax.Regexp) bool {
+	switch r.Op {
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpLiteral:
+		return slices.Contains(r.Rune, '\n')
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(r.Rune); i += 2 {
+			if r.Rune[i] <= '\n' && '\n' <= r.Rune[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, sub := range r.Sub {
+		if regexpMatchesNewline(sub) {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Server) servePrint(w http.ResponseWriter, r *http.Request) {
@@ -400,10 +580,11 @@ func (s *Server) serveSearchBoxErr(w http.ResponseWriter, r *http.Request) error
 
 	d.Last.Query = r.URL.Query().Get("q")
 	if d.Last.Query == "" {
-		custom := s.HostCustomQueries[r.Host]
+		hostQueries := s.settings.Load().HostCustomQueries
+		custom := hostQueries[r.Host]
 		if custom == "" {
 			host, _, _ := net.SplitHostPort(r.Host)
-			custom = s.HostCustomQueries[host]
+			custom = hostQueries[host]
 		}
 
 		if custom != "" {
@@ -412,7 +593,7 @@ func (s *Server) serveSearchBoxErr(w http.ResponseWriter, r *http.Request) error
 	}
 
 	var buf bytes.Buffer
-	if err := s.search.Execute(&buf, &d); err != nil {
+	if err := s.pages.Load().search.Execute(&buf, &d); err != nil {
 		return err
 	}
 	_, _ = w.Write(buf.Bytes())
@@ -438,7 +619,7 @@ func (s *Server) serveAboutErr(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var buf bytes.Buffer
-	if err := s.about.Execute(&buf, &d); err != nil {
+	if err := s.pages.Load().about.Execute(&buf, &d); err != nil {
 		return err
 	}
 	_, _ = w.Write(buf.Bytes())
@@ -454,7 +635,7 @@ func (s *Server) serveAbout(w http.ResponseWriter, r *http.Request) {
 func (s *Server) serveRobotsErr(w http.ResponseWriter, r *http.Request) error {
 	data := struct{}{}
 	var buf bytes.Buffer
-	if err := s.robots.Execute(&buf, &data); err != nil {
+	if err := s.pages.Load().robots.Execute(&buf, &data); err != nil {
 		return err
 	}
 	_, _ = w.Write(buf.Bytes())
@@ -640,7 +821,7 @@ func (s *Server) servePrintErr(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var buf bytes.Buffer
-	if err := s.print.Execute(&buf, &d); err != nil {
+	if err := s.pages.Load().print.Execute(&buf, &d); err != nil {
 		return err
 	}
 
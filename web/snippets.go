@@ -58,6 +58,14 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 		}
 		return buf.String()
 	}
+	lineFragment := func(repo string, linenum int) string {
+		fragment := getFragment(repo, linenum)
+		if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
+			// TODO - remove this is backward compatibility glue.
+			fragment = "#" + fragment
+		}
+		return fragment
+	}
 	getURL := func(repo, filename string, branches []string, version string) string {
 		tpl := templateMap[repo]
 		if localPrint || tpl == nil {
@@ -115,15 +123,10 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 		}
 
 		for _, m := range f.LineMatches {
-			fragment := getFragment(f.Repository, m.LineNumber)
-			if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
-				// TODO - remove this is backward compatibility glue.
-				fragment = "#" + fragment
-			}
 			md := Match{
 				FileName: f.FileName,
 				LineNum:  m.LineNumber,
-				URL:      fMatch.URL + fragment,
+				URL:      fMatch.URL + lineFragment(f.Repository, m.LineNumber),
 
 				Score:      m.Score,
 				ScoreDebug: m.DebugScore,
@@ -150,7 +153,69 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			}
 			fMatch.Matches = append(fMatch.Matches, md)
 		}
+
+		for _, cm := range f.ChunkMatches {
+			if len(cm.Ranges) == 0 {
+				continue
+			}
+			md := chunkToMatch(&cm)
+			md.FileName = f.FileName
+			md.URL = fMatch.URL + lineFragment(f.Repository, md.LineNum)
+			fMatch.Matches = append(fMatch.Matches, md)
+		}
 		fmatches = append(fmatches, &fMatch)
 	}
 	return fmatches, nil
 }
+
+// chunkToMatch converts a chunk match to a single Match. The lines of the
+// chunk from the start of the first range up to the end of the last range form
+// the fragments, so a range spanning several lines is rendered as one match.
+// The remaining lines of the chunk are context.
+func chunkToMatch(cm *zoekt.ChunkMatch) Match {
+	start := cm.ContentStart.ByteOffset
+	content := cm.Content
+
+	first := int(cm.Ranges[0].Start.ByteOffset - start)
+	last := first
+	for _, r := range cm.Ranges {
+		last = max(last, int(r.End.ByteOffset-start))
+	}
+
+	lineStart := bytes.LastIndexByte(content[:first], '\n') + 1
+	lineEnd := len(content)
+	if last > first && content[last-1] == '\n' {
+		lineEnd = last
+	} else if i := bytes.IndexByte(content[last:], '\n'); i >= 0 {
+		lineEnd = last + i + 1
+	}
+
+	md := Match{
+		LineNum:    int(cm.Ranges[0].Start.LineNumber),
+		Before:     string(content[:lineStart]),
+		After:      string(content[lineEnd:]),
+		Score:      cm.Score,
+		ScoreDebug: cm.DebugScore,
+	}
+	if cm.FileName {
+		md.LineNum = 0
+	}
+
+	lastEnd := lineStart
+	for i, r := range cm.Ranges {
+		l := max(int(r.Start.ByteOffset-start), lastEnd)
+		e := max(int(r.End.ByteOffset-start), l)
+
+		frag := Fragment{
+			Pre:   string(content[lastEnd:l]),
+			Match: string(content[l:e]),
+		}
+		if i == len(cm.Ranges)-1 {
+			frag.Post = string(content[e:lineEnd])
+		}
+
+		md.Fragments = append(md.Fragments, frag)
+		lastEnd = e
+	}
+	return md
+}
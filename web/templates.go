@@ -15,6 +15,7 @@
 package web
 
 import (
+	"fmt"
 	"html/template"
 	"log"
 )
@@ -22,6 +23,26 @@ import (
 // Top provides the standard templates in parsed form
 var Top = template.New("top").Funcs(Funcmap)
 
+// NewTop returns a new copy of the standard templates in parsed form. Unlike
+// Top, the copy can be extended with custom templates after the standard
+// templates have been executed.
+func NewTop() (*template.Template, error) {
+	top := template.New("top").Funcs(Funcmap)
+	if err := parseStandardTemplates(top); err != nil {
+		return nil, err
+	}
+	return top, nil
+}
+
+func parseStandardTemplates(top *template.Template) error {
+	for k, v := range TemplateText {
+		if _, err := top.New(k).Parse(v); err != nil {
+			return fmt.Errorf("parse(%s): %v", k, err)
+		}
+	}
+	return nil
+}
+
 // TemplateText contains the text of the standard templates.
 var TemplateText = map[string]string{
 	"head": `
@@ -63,7 +84,27 @@ var TemplateText = map[string]string{
      padding: unset;
      overflow: unset;
   }
+  .facets li {
+     overflow: hidden;
+     text-overflow: ellipsis;
+     white-space: nowrap;
+  }
   :target { background-color: #ccf; }
+  .file-result.selected { outline: 2px solid #66afe9; }
+  #preview {
+     display: none;
+     position: fixed;
+     top: 60px;
+     right: 0;
+     bottom: 0;
+     width: 45%;
+     overflow: auto;
+     background: white;
+     border-left: 1px solid #ddd;
+     padding: 8px;
+     z-index: 1000;
+  }
+  #preview .preview-line { background-color: #ccf; }
   table tbody tr td { border: none !important; padding: 2px !important; }
 </style>
 </head>
@@ -120,6 +161,7 @@ var TemplateText = map[string]string{
           <button class="btn btn-primary">Search</button>
           <!--Hack: we use a hidden form field to keep track of the debug flag across searches-->
           {{if .Debug}}<input id="debug" name="debug" type="hidden" value="{{.Debug}}">{{end}}
+          {{if .Facets}}<input id="facets" name="facets" type="hidden" value="{{.Facets}}">{{end}}
         </div>
       </form>
     </div>
@@ -174,6 +216,7 @@ document.onkeydown=function(e){
           <dt><a href="search?q=phone+archived:no">phone archived:no</a></dt><dd>search for "phone" in repositories that are not archived</dd>
           <dt><a href="search?q=phone+fork:no">phone fork:no</a></dt><dd>search for "phone" in repositories that are not forks</dd>
           <dt><a href="search?q=phone+public:no">phone public:no</a></dt><dd>search for "phone" in repositories that are not public</dd>
+          <dt><a href="search?q=phone+visibility:public">phone visibility:public</a></dt><dd>search for "phone" in public repositories</dd>
           <dt><a href="search?q=phone+b:master">phone b:master</a></dt><dd>for Git repos, find "phone" in files in branches whose name contains "master".</dd>
           <dt><a href="search?q=phone+b:HEAD">phone b:HEAD</a></dt><dd>for Git repos, find "phone" in the default ('HEAD') branch.</dd>
         </dl>
@@ -214,6 +257,22 @@ document.onkeydown=function(e){
 <body id="results">
   {{template "navbar" .Last}}
   <div class="container-fluid container-results">
+    <div class="row">
+    {{if .Facets}}
+    <div class="col-md-2 facets">
+      {{range .Facets}}
+      <h5>{{.Name}}</h5>
+      <ul class="list-unstyled">
+        {{range .Values}}
+        <li><a href="search?q={{.Query}}&num={{$.Last.Num}}&facets=true" title="{{.Query}}">{{.Value}}</a> <span class="badge">{{.Count}}</span></li>
+        {{end}}
+      </ul>
+      {{end}}
+    </div>
+    <div class="col-md-10">
+    {{else}}
+    <div class="col-md-12">
+    {{end}}
     <h5>
       {{if .Stats.Crashes}}<br><b>{{.Stats.Crashes}} shards crashed</b><br>{{end}}
       {{ $fileCount := len .FileMatches }}
@@ -221,9 +280,11 @@ document.onkeydown=function(e){
         showing top {{ $fileCount }} files (<a rel="nofollow"
            href="search?q={{.Last.Query}}&num={{More .Last.Num}}">show more</a>).
       {{else}}.{{end}}
+      {{if not .Last.Facets}}<a rel="nofollow" href="search?q={{.Last.Query}}&num={{.Last.Num}}&facets=true">Show facets</a>{{end}}
     </h5>
     {{range .FileMatches}}
-    <table class="table table-hover table-condensed">
+    <table class="table table-hover table-condensed file-result" data-repo="{{.Repo}}" data-file="{{.FileName}}"
+           {{- with .Branches}} data-branch="{{index . 0}}"{{end}}{{with .Matches}} data-line="{{(index . 0).LineNum}}"{{end}} data-url="{{.URL}}">
       <thead>
         <tr>
           <th>
@@ -254,6 +315,14 @@ document.onkeydown=function(e){
       {{end}}
     </table>
     {{end}}
+    <div id="results-end" data-query="{{.QueryStr}}" data-num="{{.Last.Num}}" data-ctx="{{.Last.Ctx}}"
+         data-more="{{lt (len .FileMatches) .Stats.FileCount}}"></div>
+    <p class="text-muted"><small>
+      Keys: <kbd>j</kbd>/<kbd>k</kbd> next/previous result, <kbd>enter</kbd> open it, <kbd>p</kbd> preview it, <kbd>/</kbd> search.
+    </small></p>
+    </div>
+    </div>
+  <div id="preview"><div id="preview-title"></div><div id="preview-body"></div></div>
 
   <nav class="navbar navbar-default navbar-bottom">
     <div class="container">
@@ -275,6 +344,7 @@ document.onkeydown=function(e){
   </nav>
   </div>
   {{ template "jsdep"}}
+  <script src="results.js"></script>
 </body>
 </html>
 `,
@@ -402,10 +472,7 @@ disallow: /search
 }
 
 func init() {
-	for k, v := range TemplateText {
-		_, err := Top.New(k).Parse(v)
-		if err != nil {
-			log.Panicf("parse(%s): %v:", k, err)
-		}
+	if err := parseStandardTemplates(Top); err != nil {
+		log.Panic(err)
 	}
 }
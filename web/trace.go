@@ -2,7 +2,9 @@ package web
 
 import (
 	"context"
+	"io"
 
+	"github.com/grafana/regexp"
 	"github.com/opentracing/opentracing-go"
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/trace"
@@ -54,5 +56,36 @@ func (s traceAwareSearcher) StreamSearch(
 func (s traceAwareSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
 	return s.Searcher.List(ctx, q, opts)
 }
+
+// StreamList implements zoekt.ListStreamer.
+func (s traceAwareSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList) error) (*zoekt.RepoList, error) {
+	return zoekt.StreamList(ctx, s.Searcher, q, opts, send)
+}
+
+// Definitions implements zoekt.DefinitionSearcher.
+func (s traceAwareSearcher) Definitions(ctx context.Context, name string, opts *zoekt.DefinitionOptions) ([]zoekt.Definition, error) {
+	return zoekt.Definitions(ctx, s.Searcher, name, opts)
+}
+
+// Count implements zoekt.CountSearcher.
+func (s traceAwareSearcher) Count(ctx context.Context, q query.Q, opts *zoekt.CountOptions) (*zoekt.CountResult, error) {
+	return zoekt.Count(ctx, s.Searcher, q, opts)
+}
+
+// UpdateRepositoryMetadata implements zoekt.RepositoryMetadataUpdater.
+func (s traceAwareSearcher) UpdateRepositoryMetadata(ctx context.Context, name string, u *zoekt.RepositoryMetadataUpdate) (*zoekt.Repository, error) {
+	return zoekt.UpdateRepositoryMetadata(ctx, s.Searcher, name, u)
+}
+
+// ShardFiles implements zoekt.ShardFetcher.
+func (s traceAwareSearcher) ShardFiles(ctx context.Context, repos *regexp.Regexp) ([]zoekt.ShardFile, error) {
+	return zoekt.ShardFiles(ctx, s.Searcher, repos)
+}
+
+// OpenShardFile implements zoekt.ShardFetcher.
+func (s traceAwareSearcher) OpenShardFile(ctx context.Context, name string) (io.ReadCloser, zoekt.ShardFile, error) {
+	return zoekt.OpenShardFile(ctx, s.Searcher, name)
+}
+
 func (s traceAwareSearcher) Close()         { s.Searcher.Close() }
 func (s traceAwareSearcher) String() string { return s.Searcher.String() }
@@ -0,0 +1,354 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// maxPreviewLines is the maximum number of lines a preview shows before and
+// after its line.
+const maxPreviewLines = 50
+
+// maxResultsPageSize is the maximum number of files of a ResultsPage. Larger
+// pages are cut to it, and the results page fetches the rest with the next
+// pages.
+const maxResultsPageSize = 500
+
+// ResultsPage is a page of the ranked results of a query, which the results
+// page fetches to show more results without reloading.
+type ResultsPage struct {
+	// Offset is the rank of the first of FileMatches, starting at 0.
+	Offset      int
+	FileMatches []*FileMatch
+
+	// More is set if files beyond this page match the query.
+	More bool
+}
+
+// Preview holds the lines of a document around a line, for the preview pane
+// of the results page.
+type Preview struct {
+	Repository string
+	FileName   string
+
+	// Line is the line the preview is centered on. Start is the number of
+	// the first of Lines. Line numbers start at 1.
+	Line      int
+	Start     int
+	Lines     []string
+	LineCount int
+}
+
+// resultsJS implements the keyboard navigation of the results page. j and k
+// select the next and previous result, enter opens it and p toggles a pane
+// previewing the code around its first match. Selecting past the last result
+// fetches the next page of results from /results.
+const resultsJS = `
+(function() {
+  var end = document.getElementById("results-end");
+  if (!end) { return; }
+  var query = end.dataset.query, num = end.dataset.num, ctxLines = end.dataset.ctx;
+  var more = end.dataset.more === "true";
+  var selected = -1, loading = false, previewed = null;
+
+  function results() {
+    return document.getElementsByClassName("file-result");
+  }
+
+  function el(tag, className, text) {
+    var e = document.createElement(tag);
+    if (className) { e.className = className; }
+    if (text !== undefined) { e.appendChild(document.createTextNode(text)); }
+    return e;
+  }
+
+  // safeURL reports whether url may be navigated to. Like html/template, it
+  // drops javascript: URLs.
+  function safeURL(url) {
+    return !!url && !/^\s*javascript:/i.test(url);
+  }
+
+  // link links child to url, if it is a safeURL.
+  function link(url, child) {
+    if (!safeURL(url)) { return child; }
+    var a = el("a");
+    a.href = url;
+    a.appendChild(child);
+    return a;
+  }
+
+  // renderResult renders a FileMatch of a ResultsPage like the
+  // results template does.
+  function renderResult(fm) {
+    var table = el("table", "table table-hover table-condensed file-result");
+    table.dataset.repo = fm.Repo;
+    table.dataset.file = fm.FileName;
+    table.dataset.url = fm.URL;
+    if (fm.Branches && fm.Branches.length) { table.dataset.branch = fm.Branches[0]; }
+    if (fm.Matches && fm.Matches.length) { table.dataset.line = fm.Matches[0].LineNum; }
+
+    var th = el("th");
+    var anchor = el("a");
+    anchor.name = fm.ResultID;
+    th.appendChild(anchor);
+    var title = el("small");
+    title.appendChild(link(fm.URL, document.createTextNode(fm.Repo + ":" + fm.FileName)));
+    title.appendChild(document.createTextNode(": [ "));
+    (fm.Branches || []).forEach(function(b) {
+      title.appendChild(el("span", "label label-default", b));
+      title.appendChild(document.createTextNode(", "));
+    });
+    title.appendChild(document.createTextNode("]"));
+    if (fm.DuplicateID) {
+      var dup = el("a", "label label-dup", "Duplicate result");
+      dup.href = "#" + fm.DuplicateID;
+      title.appendChild(document.createTextNode(" "));
+      title.appendChild(dup);
+    }
+    th.appendChild(title);
+    table.appendChild(el("thead")).appendChild(el("tr")).appendChild(th);
+
+    if (!fm.DuplicateID) {
+      var tbody = table.appendChild(el("tbody"));
+      (fm.Matches || []).forEach(function(m) {
+        if (m.LineNum <= 0) { return; }
+        var pre = el("pre", "inline-pre");
+        var lineNum = el("span", "noselect");
+        lineNum.appendChild(link(m.URL, el("u", "", String(m.LineNum))));
+        lineNum.appendChild(document.createTextNode(": "));
+        pre.appendChild(lineNum);
+        (m.Fragments || []).forEach(function(f) {
+          pre.appendChild(document.createTextNode(f.Pre));
+          pre.appendChild(el("b", "", f.Match));
+          pre.appendChild(document.createTextNode(f.Post.replace(/\n$/, "")));
+        });
+        var td = el("td");
+        td.style.backgroundColor = "rgba(238, 238, 255, 0.6)";
+        td.appendChild(pre);
+        tbody.appendChild(el("tr")).appendChild(td);
+      });
+    }
+    return table;
+  }
+
+  // loadMore appends the next page of results, and then selects the
+  // result at index next if it exists.
+  function loadMore(next) {
+    if (loading || !more) { return; }
+    loading = true;
+    var url = "results?q=" + encodeURIComponent(query) + "&num=" + num +
+        "&offset=" + results().length + "&ctx=" + ctxLines;
+    $.getJSON(url).done(function(page) {
+      page.FileMatches.forEach(function(fm) {
+        end.parentNode.insertBefore(renderResult(fm), end);
+      });
+      more = page.More && page.FileMatches.length > 0;
+      select(next);
+    }).always(function() { loading = false; });
+  }
+
+  function previewShown() {
+    return document.getElementById("preview").style.display === "block";
+  }
+
+  function showPreview() {
+    var r = results()[selected];
+    var pane = document.getElementById("preview");
+    pane.style.display = "block";
+    if (!r || previewed === r) { return; }
+    previewed = r;
+    var params = "r=" + encodeURIComponent(r.dataset.repo) +
+        "&f=" + encodeURIComponent(r.dataset.file) +
+        "&l=" + encodeURIComponent(r.dataset.line || 1);
+    if (r.dataset.branch) { params += "&b=" + encodeURIComponent(r.dataset.branch); }
+    var title = document.getElementById("preview-title");
+    var body = document.getElementById("preview-body");
+    title.textContent = r.dataset.repo + ":" + r.dataset.file;
+    body.textContent = "Loading...";
+    $.getJSON("preview?" + params).done(function(p) {
+      if (previewed !== r) { return; }
+      body.textContent = "";
+      var focus = null;
+      p.Lines.forEach(function(line, i) {
+        var n = p.Start + i;
+        var pre = el("pre", n === p.Line ? "inline-pre preview-line" : "inline-pre");
+        pre.appendChild(el("span", "noselect", n + ": "));
+        pre.appendChild(document.createTextNode(line));
+        body.appendChild(pre);
+        if (n === p.Line) { focus = pre; }
+      });
+      if (focus) { focus.scrollIntoView({block: "center"}); }
+    }).fail(function(xhr) {
+      if (previewed === r) { body.textContent = "Preview failed: " + xhr.responseText; }
+    });
+  }
+
+  function hidePreview() {
+    document.getElementById("preview").style.display = "none";
+  }
+
+  function select(i) {
+    var rs = results();
+    if (i >= rs.length) {
+      loadMore(i);
+      return;
+    }
+    if (i < 0) { return; }
+    if (selected >= 0 && selected < rs.length) { rs[selected].classList.remove("selected"); }
+    selected = i;
+    rs[i].classList.add("selected");
+    rs[i].scrollIntoView({block: "nearest"});
+    if (previewShown()) { showPreview(); }
+  }
+
+  document.addEventListener("keydown", function(e) {
+    var t = e.target.tagName;
+    if (t === "INPUT" || t === "TEXTAREA" || t === "SELECT" || e.ctrlKey || e.metaKey || e.altKey) {
+      return;
+    }
+    switch (e.key) {
+    case "j":
+      select(selected + 1);
+      break;
+    case "k":
+      select(selected - 1);
+      break;
+    case "Enter":
+      var r = results()[selected];
+      if (!r || !safeURL(r.dataset.url)) { return; }
+      window.location.href = r.dataset.url;
+      break;
+    case "p":
+      if (selected < 0) { select(0); }
+      if (previewShown()) { hidePreview(); } else { showPreview(); }
+      break;
+    case "Escape":
+      hidePreview();
+      break;
+    default:
+      return;
+    }
+    e.preventDefault();
+  });
+})();
+`
+
+func (s *Server) serveResultsJS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	_, _ = w.Write([]byte(resultsJS))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// serveResults serves the files ranked offset to offset+num of the query q,
+// as a ResultsPage. Duplicate results of earlier pages refer to the result
+// IDs of those pages.
+func (s *Server) serveResults(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	queryStr := qvals.Get("q")
+	if queryStr == "" {
+		http.Error(w, "no query found", http.StatusBadRequest)
+		return
+	}
+	q, err := s.settings.Load().QueryRewriter.Parse(queryStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	num, err := strconv.Atoi(qvals.Get("num"))
+	if err != nil || num <= 0 {
+		num = defaultNumResults
+	}
+	num = min(num, maxResultsPageSize)
+	offset := 0
+	if offsetStr := qvals.Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+	numCtxLines, err := parseCtxLines(qvals)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Ranking is global, so a page needs the search for all files ranked up
+	// to its end.
+	res, err := s.searchFiles(r.Context(), q, queryStr, offset+num, numCtxLines, nil, false, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := ResultsPage{
+		Offset:      offset,
+		FileMatches: []*FileMatch{},
+		More:        offset+num < res.Stats.FileCount,
+	}
+	if offset < len(res.FileMatches) {
+		page.FileMatches = res.FileMatches[offset:]
+	}
+	writeJSON(w, &page)
+}
+
+// servePreview serves the lines around line l of the file f in repository r
+// as a Preview. If the branch b is given, the file is taken from it. The
+// number of lines before and after l is given by ctx.
+func (s *Server) servePreview(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	repo, fileName := qvals.Get("r"), qvals.Get("f")
+	if repo == "" || fileName == "" {
+		http.Error(w, "missing repository or file", http.StatusBadRequest)
+		return
+	}
+	line, err := strconv.Atoi(qvals.Get("l"))
+	if err != nil || line < 1 {
+		line = 1
+	}
+	numCtxLines := 10
+	if ctxStr := qvals.Get("ctx"); ctxStr != "" {
+		numCtxLines, err = strconv.Atoi(ctxStr)
+		if err != nil || numCtxLines < 0 || numCtxLines > maxPreviewLines {
+			http.Error(w, "ctx must be between 0 and "+strconv.Itoa(maxPreviewLines), http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := max(1, line-numCtxLines)
+	ranges := []zoekt.LineRange{{Start: uint32(start), End: uint32(line + numCtxLines)}}
+	res, err := zoekt.Contents(r.Context(), s.Searcher, repo, fileName, qvals.Get("b"), ranges)
+	if errors.Is(err, zoekt.ErrInvalidLineRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if res == nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	p := Preview{
+		Repository: res.Repository,
+		FileName:   res.FileName,
+		Line:       line,
+		Start:      start,
+		Lines:      []string{},
+		LineCount:  int(res.LineCount),
+	}
+	if len(res.Ranges) > 0 {
+		p.Lines = strings.Split(strings.TrimSuffix(string(res.Ranges[0].Content), "\n"), "\n")
+	}
+	writeJSON(w, &p)
+}